@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// readable leaves out the files that the editor may not read. Nothing
+// is left if that can not be told.
+func readable(files []db.File, editor string) []db.File {
+	files, err := fs.Readable(files, editor)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return files
+}
+
+// canChangeAccess returns whether the editor may change who can read
+// and change the page
+func canChangeAccess(tr *TemplateRender) bool {
+	return tr.SignedIn && tr.Domain != "public" && (tr.Access.Owner == "" || tr.Access.Owner == tr.EditorID)
+}
+
+// handleAccess changes who may read and change a page, which makes
+// whoever changes it first its owner
+func (tr *TemplateRender) handleAccess(w http.ResponseWriter, r *http.Request) (err error) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/"+tr.Domain+"/"+tr.Page, 302)
+		return
+	}
+	files, err := fs.Get(tr.Page, tr.Domain)
+	if err != nil || len(files) != 1 {
+		return tr.handleMain(w, r, "there is no page "+tr.Page)
+	}
+	f := files[0]
+	tr.Access, err = fs.GetPageAccess(f.ID)
+	if err != nil {
+		return
+	}
+	if !canChangeAccess(tr) {
+		return tr.handleMain(w, r, "only the owner of the page can change who can open it")
+	}
+
+	access := db.PageAccess{
+		Level:   r.FormValue("level"),
+		Owner:   tr.Access.Owner,
+		Editors: strings.FieldsFunc(r.FormValue("editors"), func(c rune) bool { return c == ',' || c == ' ' || c == '\n' }),
+	}
+	if access.Owner == "" {
+		access.Owner = tr.EditorID
+	}
+	if _, ispublic, _ := fs.GetDomainFromName(tr.Domain); ispublic && access.Level == db.AccessDropBox {
+		return tr.handleMain(w, r, "only pages of private domains can be drop boxes")
+	}
+	if err = fs.SetPageAccess(f.ID, access); err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	http.Redirect(w, r, "/"+tr.Domain+"/"+f.ID, 302)
+	return
+}
+
+// pageAccess returns why the editor may not change the pages of a
+// websocket message, if they may not
+func pageAccess(p Payload, editor string) (err error) {
+	for _, id := range []string{p.ID, p.Target} {
+		if id == "" {
+			continue
+		}
+		if err = fs.CheckAccess(id, editor, true); err != nil {
+			return
+		}
+	}
+	return
+}
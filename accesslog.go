@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// accessLogEnabled keeps an access log for the domains that want one,
+// and accessLogRetention is how long it is kept
+var accessLogEnabled bool
+var accessLogRetention = 90 * 24 * time.Hour
+
+// accessLogInterval is how often the counted requests are saved
+var accessLogInterval = time.Minute
+
+// maxAccessPath is the longest path that is counted, longer ones are cut
+const maxAccessPath = 200
+
+// accessDays is how many days of the access log the settings show
+const accessDays = 30
+
+// accessKey is what requests are counted by
+type accessKey struct {
+	day, domain, path, client string
+}
+
+// accesses are the requests counted since they were last saved
+var accesses struct {
+	sync.Mutex
+	hits map[accessKey]int
+}
+
+// notDomains are the first parts of paths that are not domains
+var notDomains = map[string]bool{
+	"": true, "static": true, "uploads": true, "api": true, "ws": true,
+	"metrics": true, "login": true, "logout": true,
+	"search": true, "moderation": true, "graphql": true, "oembed": true,
+	"replica": true, "upload": true, "duplicates": true, "favicon.ico": true,
+	"robots.txt": true, "sitemap.xml": true,
+}
+
+// clientKind sorts the client of a request into a few kinds, so that
+// nothing that tells one visitor from another is kept
+func clientKind(r *http.Request) string {
+	ua := strings.ToLower(r.UserAgent())
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/feed.atom") || strings.HasSuffix(r.URL.Path, "/feed.json") ||
+		strings.Contains(ua, "feed") || strings.Contains(ua, "rss"):
+		return "feed"
+	case ua == "":
+		return "other"
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "crawl") || strings.Contains(ua, "spider") ||
+		strings.Contains(ua, "slurp") || strings.Contains(ua, "preview"):
+		return "bot"
+	case strings.HasPrefix(ua, "curl") || strings.HasPrefix(ua, "wget") || strings.HasPrefix(ua, "python") ||
+		strings.HasPrefix(ua, "go-http-client") || strings.HasPrefix(ua, "httpie") || strings.HasPrefix(ua, "okhttp") ||
+		strings.HasPrefix(ua, "java"):
+		return "tool"
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "android") || strings.Contains(ua, "iphone"):
+		return "mobile"
+	case strings.HasPrefix(ua, "mozilla"):
+		return "desktop"
+	}
+	return "other"
+}
+
+// logAccess counts a request for a page of a domain. Only the day, the
+// path and the kind of client are kept, and only for the domains that
+// want an access log, which is looked up when they are saved.
+func logAccess(r *http.Request) {
+	if !accessLogEnabled || readOnly() || (r.Method != "GET" && r.Method != "HEAD") {
+		return
+	}
+	path := strings.TrimRight(r.URL.Path, "/")
+	domain := strings.ToLower(strings.Split(strings.TrimPrefix(path, "/"), "/")[0])
+	if notDomains[domain] {
+		return
+	}
+	if len(path) > maxAccessPath {
+		path = path[:maxAccessPath]
+	}
+	key := accessKey{
+		day:    time.Now().UTC().Format("2006-01-02"),
+		domain: domain,
+		path:   path,
+		client: clientKind(r),
+	}
+	accesses.Lock()
+	if accesses.hits == nil {
+		accesses.hits = make(map[accessKey]int)
+	}
+	accesses.hits[key]++
+	accesses.Unlock()
+}
+
+// runAccessLog saves the counted requests every accessLogInterval and
+// deletes the days that are older than accessLogRetention
+func runAccessLog() {
+	if !accessLogEnabled {
+		return
+	}
+	for {
+		time.Sleep(accessLogInterval)
+		saveAccesses()
+		deleteOldAccesses(time.Now())
+	}
+}
+
+// saveAccesses saves the counted requests of the domains that want an
+// access log
+func saveAccesses() {
+	accesses.Lock()
+	hits := accesses.hits
+	accesses.hits = nil
+	accesses.Unlock()
+
+	wants := make(map[string]bool)
+	var saved []db.Access
+	for key, n := range hits {
+		if _, ok := wants[key.domain]; !ok {
+			options, err := fs.GetDomainOptions(key.domain)
+			wants[key.domain] = err == nil && options.AccessLog
+		}
+		if !wants[key.domain] {
+			continue
+		}
+		saved = append(saved, db.Access{Day: key.day, Domain: key.domain, Path: key.path, Client: key.client, Hits: n})
+	}
+	if len(saved) == 0 {
+		return
+	}
+	if err := fs.AddAccesses(saved); err != nil {
+		log.Error(err)
+	}
+}
+
+func deleteOldAccesses(now time.Time) {
+	if accessLogRetention <= 0 {
+		return
+	}
+	deleted, err := fs.DeleteAccessesBefore(now.Add(-accessLogRetention).UTC().Format("2006-01-02"))
+	if err != nil {
+		log.Error(err)
+	} else if deleted > 0 {
+		log.Debugf("deleted %d rows of the access log", deleted)
+	}
+}
+
+// accessSummary sums up the access log of the domain for its settings
+func accessSummary(domain string, now time.Time) (summary db.AccessSummary, err error) {
+	since := now.UTC().AddDate(0, 0, 1-accessDays).Format("2006-01-02")
+	return fs.GetAccessSummary(domain, since, 10)
+}
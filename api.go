@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// APIFile is the public representation of a file returned by the API
+type APIFile struct {
+	ID       string    `json:"id"`
+	Slug     string    `json:"slug"`
+	Title    string    `json:"title"`
+	Modified time.Time `json:"modified"`
+	Archived bool      `json:"archived,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Data     string    `json:"data,omitempty"`
+}
+
+// APIFilesPage is one page of the files of a domain
+type APIFilesPage struct {
+	Files []APIFile `json:"files"`
+	// NextCursor continues with the next page, it is empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+)
+
+func newAPIFiles(files []db.File) (apiFiles []APIFile) {
+	apiFiles = make([]APIFile, len(files))
+	for i, f := range files {
+		apiFiles[i] = APIFile{
+			ID:       f.ID,
+			Slug:     f.Slug,
+			Title:    f.DisplayName(),
+			Modified: f.Modified,
+		}
+	}
+	return
+}
+
+// apiSignedIn returns whether the request has the key of the domain,
+// either in the cookie or in an "Authorization: Bearer" header
+func apiSignedIn(w http.ResponseWriter, r *http.Request, domain string) bool {
+	if keyDomain, hasKey := apiKeyDomain(r); hasKey {
+		return keyDomain == domain
+	}
+	signedIn, _, _, _, _ := isSignedIn(w, r, domain)
+	return signedIn
+}
+
+// apiKeyDomain returns the domain of the bearer key of the request, if
+// it has one
+func apiKeyDomain(r *http.Request) (domain string, hasKey bool) {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if key == r.Header.Get("Authorization") {
+		return
+	}
+	domain, err := fs.CheckKey(strings.TrimSpace(key))
+	if err != nil {
+		domain = ""
+	}
+	return domain, true
+}
+
+// apiCanRead returns whether the request may read the domain
+func apiCanRead(w http.ResponseWriter, r *http.Request, domain string) bool {
+	if domain == "public" || apiSignedIn(w, r, domain) {
+		return true
+	}
+	_, ispublic, err := fs.GetDomainFromName(domain)
+	return err == nil && ispublic
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// formMethod is the method of a request to the API, where the forms of
+// pages, which can only POST, send undo to DELETE. They work without
+// JavaScript, and send redirect to go back to the page.
+func formMethod(r *http.Request) string {
+	if r.Method == "POST" && r.FormValue("undo") == "1" {
+		return "DELETE"
+	}
+	return r.Method
+}
+
+// handleAPIPins lists (GET), pins (POST) or unpins (DELETE) the
+// pages of a domain that the user is signed in to
+func (tr *TemplateRender) handleAPIPins(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	signedIn, _, _, _, _ := isSignedIn(w, r, domain)
+	if !signedIn || domain == "public" {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	method := formMethod(r)
+	switch method {
+	case "GET":
+		files, errGet := fs.GetPinned(domain)
+		if errGet != nil {
+			return errGet
+		}
+		return writeJSON(w, http.StatusOK, newAPIFiles(readable(files, tr.EditorID)))
+	case "POST":
+		err = fs.Pin(domain, id)
+	case "DELETE":
+		err = fs.Unpin(domain, id)
+	default:
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "method not allowed"})
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Payload{ID: id, Domain: domain, Message: err.Error()})
+		return
+	}
+	if r.FormValue("redirect") != "" {
+		http.Redirect(w, r, "/"+domain+"/"+id, http.StatusSeeOther)
+		return
+	}
+	return writeJSON(w, http.StatusOK, Payload{ID: id, Domain: domain, Message: "pinned", Success: method == "POST"})
+}
+
+// handleAPIArchive archives (POST) or unarchives (DELETE) a page of a
+// domain that the user is signed in to
+func (tr *TemplateRender) handleAPIArchive(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	signedIn, _, _, _, _ := isSignedIn(w, r, domain)
+	if !signedIn || domain == "public" {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	method := formMethod(r)
+	switch method {
+	case "POST":
+		err = fs.SetArchived(id, domain, true)
+	case "DELETE":
+		err = fs.SetArchived(id, domain, false)
+	default:
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "method not allowed"})
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Payload{ID: id, Domain: domain, Message: err.Error()})
+		return
+	}
+	forgetDomain(domain)
+	if r.FormValue("redirect") != "" {
+		http.Redirect(w, r, "/"+domain+"/"+id, http.StatusSeeOther)
+		return
+	}
+	return writeJSON(w, http.StatusOK, Payload{ID: id, Domain: domain, Message: "archived", Success: method == "POST"})
+}
+
+// handleAPIv1 serves the versioned API at /api/v1/{domain}/...
+func (tr *TemplateRender) handleAPIv1(w http.ResponseWriter, r *http.Request) (err error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/"), "/"), "/")
+	if len(parts) != 2 || (parts[1] != "files" && parts[1] != "archive" && parts[1] != "complete" && parts[1] != "ask" && parts[1] != "preview" && parts[1] != "config") {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: "not found"})
+	}
+	domain := strings.ToLower(parts[0])
+	if parts[1] == "archive" {
+		return handleAPIArchive(w, r, domain)
+	}
+	if parts[1] == "config" {
+		return handleAPIConfig(w, r, domain)
+	}
+	if !apiCanRead(w, r, domain) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	if parts[1] == "complete" {
+		return handleAPIComplete(w, r, domain)
+	}
+	if parts[1] == "ask" {
+		return handleAPIAsk(w, r, domain)
+	}
+	if parts[1] == "preview" {
+		return handleAPIPreview(w, r, domain)
+	}
+	return handleAPIFiles(w, r, domain)
+}
+
+// handleAPIFiles lists the files of a domain in the order they were
+// modified, a page at a time, so that integrations can keep up with a
+// domain by asking for the files modified since they last asked
+func handleAPIFiles(w http.ResponseWriter, r *http.Request, domain string) (err error) {
+	query := r.URL.Query()
+	limit := apiDefaultLimit
+	if query.Get("limit") != "" {
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > apiMaxLimit {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: fmt.Sprintf("limit must be between 1 and %d", apiMaxLimit)})
+		}
+	}
+	var after time.Time
+	var afterID string
+	if since := query.Get("modified_since"); since != "" {
+		after, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: "modified_since must be a time like 2006-01-02T15:04:05Z"})
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, afterID, err = decodeCursor(cursor)
+		if err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: "invalid cursor"})
+		}
+	}
+	tag := strings.ToLower(strings.TrimPrefix(query.Get("tag"), "#"))
+
+	page := APIFilesPage{Files: []APIFile{}}
+	for len(page.Files) < limit {
+		var files []db.File
+		files, err = fs.GetModifiedSince(domain, after, afterID, limit)
+		if err != nil {
+			return
+		}
+		readableIDs := make(map[string]bool)
+		for _, f := range readable(files, requestEditorID(r)) {
+			readableIDs[f.ID] = true
+		}
+		for _, f := range files {
+			after, afterID = f.Modified, f.ID
+			if !readableIDs[f.ID] {
+				continue
+			}
+			tags := utils.Tags(f.Data)
+			if tag != "" && !containsString(tags, tag) {
+				continue
+			}
+			page.Files = append(page.Files, APIFile{
+				ID:       f.ID,
+				Slug:     f.Slug,
+				Title:    f.DisplayName(),
+				Modified: f.Modified,
+				Archived: f.Archived,
+				Tags:     tags,
+				Data:     f.Data,
+			})
+			if len(page.Files) == limit {
+				break
+			}
+		}
+		if len(files) < limit {
+			// there are no more files
+			return writeJSON(w, http.StatusOK, page)
+		}
+	}
+	page.NextCursor = encodeCursor(after, afterID)
+	return writeJSON(w, http.StatusOK, page)
+}
+
+// encodeCursor encodes the last file of a page
+func encodeCursor(modified time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(modified.UnixNano(), 10) + ":" + id))
+}
+
+func decodeCursor(cursor string) (modified time.Time, id string, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		err = errors.New("invalid cursor")
+		return
+	}
+	nanoseconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return
+	}
+	return time.Unix(0, nanoseconds).UTC(), parts[1], nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/embeddings"
+	"github.com/schollz/rwtxt/src/llm"
+	"github.com/schollz/rwtxt/src/search"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// answerer answers questions about the pages of a domain, if a chat
+// model is set with -llm. Without it questions get the pages that may
+// answer them.
+var answerer *llm.Client
+
+const (
+	// askPages is how many pages a question is answered from
+	askPages = 5
+	// askText is how much of each page the model reads
+	askText = 4000
+	// askQuestion is how long a question can be
+	askQuestion = 1000
+)
+
+// askPrompt tells the model how to answer
+const askPrompt = `You answer questions about the notes of the user. Answer only from the notes below, which are numbered. After each thing you say, cite the notes it is from with their numbers in brackets, like [1] or [2][3]. If the notes do not answer the question, say so.`
+
+// citationMarker is a citation of a numbered page in an answer, like [2]
+var citationMarker = regexp.MustCompile(`\[(\d+)\]`)
+
+// APIAnswer is the answer to a question about a domain, with the pages
+// it is from
+type APIAnswer struct {
+	Question string `json:"question"`
+	// Answer is markdown whose citations link to the sources, or empty
+	// if there is no model to answer
+	Answer  string      `json:"answer,omitempty"`
+	Sources []APISource `json:"sources"`
+}
+
+// APISource is a page that a question is answered from, numbered as it
+// is cited in the answer
+type APISource struct {
+	Number  int    `json:"number"`
+	ID      string `json:"id"`
+	Slug    string `json:"slug"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Excerpt string `json:"excerpt"`
+}
+
+// truncateText cuts the text to at most limit bytes, without splitting
+// characters
+func truncateText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	return text[:limit]
+}
+
+// questionWords are the words of the question to search for
+func questionWords(question string) (words []string) {
+	seen := make(map[string]bool)
+	for _, word := range search.Parse(question).Words {
+		word = strings.TrimSuffix(word, "*")
+		if utf8.RuneCountInString(word) < 3 || utils.IsStopWord(word) || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return
+}
+
+// rankByWords returns the pages that have the most of the words, where
+// the words that fewer pages have count more
+func rankByWords(files []db.File, words []string, limit int) []db.File {
+	counts := make([]map[string]int, len(files))
+	pagesWith := make(map[string]int)
+	for i, f := range files {
+		counts[i] = make(map[string]int)
+		for _, word := range search.Parse(f.Title + " " + f.Data).Words {
+			counts[i][word]++
+		}
+		for _, word := range words {
+			if counts[i][word] > 0 {
+				pagesWith[word]++
+			}
+		}
+	}
+	scores := make(map[string]float64)
+	for i, f := range files {
+		for _, word := range words {
+			if n := float64(counts[i][word]); n > 0 {
+				idf := math.Log(1 + float64(len(files))/float64(pagesWith[word]))
+				scores[f.ID] += idf * n / (n + 1.2)
+			}
+		}
+	}
+	ranked := append([]db.File{}, files...)
+	sort.SliceStable(ranked, func(i, j int) bool { return scores[ranked[i].ID] > scores[ranked[j].ID] })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+// retrievePages returns the pages of the domain that the editor may read
+// and that may answer the question, the likeliest first. With an API
+// for embeddings they are the pages nearest to the question, otherwise
+// those that have its words.
+func retrievePages(domain, editor, question string) (files []db.File, err error) {
+	if embedder != nil {
+		files, err = retrieveByEmbedding(domain, editor, question)
+		if err == nil {
+			return
+		}
+		log.Warn(err)
+	}
+	words := questionWords(question)
+	found, err := fs.SearchAny(domain, words)
+	if err != nil {
+		return
+	}
+	return rankByWords(readable(found, editor), words, askPages), nil
+}
+
+func retrieveByEmbedding(domain, editor, question string) (files []db.File, err error) {
+	all, err := fs.GetAll(domain)
+	if err != nil {
+		return
+	}
+	var candidates []db.File
+	for _, f := range readable(all, editor) {
+		if !f.Archived {
+			candidates = append(candidates, f)
+		}
+	}
+	vectors, err := embedFiles(domain, candidates)
+	if err != nil {
+		return
+	}
+	query, err := embedder.Embed([]string{question})
+	if err != nil {
+		return
+	}
+	var embedded []db.File
+	var others [][]float32
+	for _, f := range candidates {
+		if vector, ok := vectors[f.ID]; ok {
+			embedded = append(embedded, f)
+			others = append(others, vector)
+		}
+	}
+	for _, match := range embeddings.Nearest(query[0], others, askPages) {
+		files = append(files, embedded[match.Index])
+	}
+	return
+}
+
+// answerQuestion answers the question from the pages, linking its
+// citations to them
+func answerQuestion(base, domain, question string, files []db.File) (answer string, err error) {
+	var notes strings.Builder
+	for i, f := range files {
+		notes.WriteString("[" + strconv.Itoa(i+1) + "] " + f.DisplayName() + "\n")
+		notes.WriteString(truncateText(f.Data, askText) + "\n\n")
+	}
+	answer, err = answerer.Chat([]llm.Message{
+		{Role: "system", Content: askPrompt},
+		{Role: "user", Content: "Notes:\n\n" + notes.String() + "Question: " + question},
+	})
+	if err != nil {
+		return
+	}
+	answer = citationMarker.ReplaceAllStringFunc(answer, func(marker string) string {
+		n, _ := strconv.Atoi(marker[1 : len(marker)-1])
+		if n < 1 || n > len(files) {
+			return marker
+		}
+		return "[" + strconv.Itoa(n) + "](" + base + "/" + domain + "/" + files[n-1].ID + ")"
+	})
+	return
+}
+
+// handleAPIAsk answers the question ?q= about the pages of the domain,
+// with the pages that it is answered from
+func handleAPIAsk(w http.ResponseWriter, r *http.Request, domain string) (err error) {
+	question := strings.TrimSpace(r.FormValue("q"))
+	if question == "" {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: "ask a question with q"})
+	}
+	if len(question) > askQuestion {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: "the question is too long"})
+	}
+
+	files, err := retrievePages(domain, requestEditorID(r), question)
+	if err != nil {
+		return
+	}
+	ask := APIAnswer{Question: question, Sources: []APISource{}}
+	for i, f := range files {
+		ask.Sources = append(ask.Sources, APISource{
+			Number:  i + 1,
+			ID:      f.ID,
+			Slug:    f.Slug,
+			Title:   f.DisplayName(),
+			URL:     baseURL(r) + "/" + domain + "/" + f.ID,
+			Excerpt: utils.FirstParagraph(f.Data, 280),
+		})
+	}
+	if answerer != nil && len(files) > 0 {
+		ask.Answer, err = answerQuestion(baseURL(r), domain, question, files)
+		if err != nil {
+			log.Warn(err)
+			return writeJSON(w, http.StatusBadGateway, Payload{Message: "could not answer: " + err.Error()})
+		}
+	}
+	return writeJSON(w, http.StatusOK, ask)
+}
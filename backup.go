@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// backupRemote is the rclone remote or folder that backups are uploaded
+// to, like "s3:bucket/rwtxt"
+var backupRemote string
+
+// backupRecipient is the age public key or GPG key that backups are
+// encrypted for, and backupIdentity the age key file that decrypts them
+var backupRecipient string
+var backupIdentity string
+
+var backupInterval = 24 * time.Hour
+
+// backupKeep is how many backups are kept, older ones are deleted
+var backupKeep = 30
+
+const backupTimeFormat = "20060102T150405Z"
+
+// backupName matches the names of backups, like
+// "rwtxt-20181101T120000Z.sql.gz.age"
+var backupName = regexp.MustCompile(`^rwtxt-(\d{8}T\d{6}Z)\.sql\.gz\.(age|gpg)$`)
+
+func backupPath(name string) string {
+	if strings.HasSuffix(backupRemote, ":") || strings.HasSuffix(backupRemote, "/") {
+		return backupRemote + name
+	}
+	return backupRemote + "/" + name
+}
+
+// backupTime returns when a backup was made, from its name
+func backupTime(name string) (t time.Time, err error) {
+	match := backupName.FindStringSubmatch(name)
+	if match == nil {
+		err = errors.New(name + " is not a backup")
+		return
+	}
+	return time.Parse(backupTimeFormat, match[1])
+}
+
+func encryptCommand() *exec.Cmd {
+	if strings.HasPrefix(backupRecipient, "age1") {
+		return exec.Command("age", "--encrypt", "--recipient", backupRecipient)
+	}
+	return exec.Command("gpg", "--batch", "--yes", "--trust-model", "always", "--encrypt", "--recipient", backupRecipient)
+}
+
+func decryptCommand(name string) *exec.Cmd {
+	if strings.HasSuffix(name, ".age") {
+		return exec.Command("age", "--decrypt", "--identity", backupIdentity)
+	}
+	return exec.Command("gpg", "--batch", "--decrypt")
+}
+
+// listBackups returns the names of the backups, oldest first
+func listBackups() (names []string, err error) {
+	if backupRemote == "" {
+		err = errors.New("set -backup to where the backups are")
+		return
+	}
+	out, err := rclone("lsf", "--files-only", backupRemote)
+	if err != nil {
+		return
+	}
+	for _, name := range strings.Split(out, "\n") {
+		if backupName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// backupsToPrune returns the oldest backups, beyond the newest keep
+func backupsToPrune(names []string, keep int) []string {
+	if keep < 1 || len(names) <= keep {
+		return nil
+	}
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	return sorted[:len(sorted)-keep]
+}
+
+// backup uploads an encrypted dump of the database, including its
+// uploads, and deletes the oldest backups
+func backup() (name string, err error) {
+	extension := ".gpg"
+	if strings.HasPrefix(backupRecipient, "age1") {
+		extension = ".age"
+	}
+	name = "rwtxt-" + time.Now().UTC().Format(backupTimeFormat) + ".sql.gz" + extension
+
+	tmp, err := ioutil.TempFile("", "rwtxt-backup")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	cmd := encryptCommand()
+	cmd.Stdout = tmp
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return name, errors.Wrap(err, "could not encrypt backup")
+	}
+	errDump := fs.WriteDump(stdin)
+	stdin.Close()
+	if err = cmd.Wait(); err != nil {
+		return name, errors.Wrap(err, "could not encrypt backup: "+strings.TrimSpace(stderr.String()))
+	}
+	if errDump != nil {
+		return name, errDump
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	if _, err = rclone("copyto", tmp.Name(), backupPath(name)); err != nil {
+		return
+	}
+
+	names, err := listBackups()
+	if err != nil {
+		return
+	}
+	for _, old := range backupsToPrune(names, backupKeep) {
+		if _, err = rclone("deletefile", backupPath(old)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// runBackups makes a backup every backupInterval, counting from the
+// last backup that was made
+func runBackups() {
+	for {
+		wait := time.Duration(0)
+		names, err := listBackups()
+		if err != nil {
+			log.Error(err)
+		} else if len(names) > 0 {
+			last, _ := backupTime(names[len(names)-1])
+			wait = backupInterval - time.Since(last)
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		name, err := backup()
+		if err != nil {
+			log.Errorf("could not back up: %v", err)
+			// try again later, instead of right away
+			time.Sleep(time.Hour)
+			continue
+		}
+		log.Infof("backed up to %s", backupPath(name))
+	}
+}
+
+// printBackups lists the backups, for the "backups" command
+func printBackups() (err error) {
+	names, err := listBackups()
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return
+}
+
+// downloadBackup returns the decrypted dump of a backup
+func downloadBackup(name string) (dump []byte, err error) {
+	tmp, err := ioutil.TempFile("", "rwtxt-restore")
+	if err != nil {
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if _, err = rclone("copyto", backupPath(name), tmp.Name()); err != nil {
+		return
+	}
+	encrypted, err := os.Open(tmp.Name())
+	if err != nil {
+		return
+	}
+	defer encrypted.Close()
+
+	cmd := decryptCommand(name)
+	cmd.Stdin = encrypted
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	dump, err = cmd.Output()
+	if err != nil {
+		err = errors.Wrap(err, "could not decrypt "+name+": "+strings.TrimSpace(stderr.String()))
+	}
+	return
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// dailyNote is the slug of a page about a day, like 2024-05-01 or
+// journal/2024-05-01
+var dailyNote = regexp.MustCompile(`(?:^|/)(\d{4}-\d{2}-\d{2})$`)
+
+// calendarEvent is a day in the calendar of a domain
+type calendarEvent struct {
+	UID     string
+	Day     time.Time
+	Summary string
+	URL     string
+	Updated time.Time
+}
+
+// calendarEvents returns the daily notes, the pages with a date in their
+// front matter and the tasks with due dates of the domain, that the
+// editor may read
+func calendarEvents(base, domain, editor string) (events []calendarEvent, err error) {
+	files, err := fs.GetAll(domain)
+	if err != nil {
+		return
+	}
+	for _, f := range readable(files, editor) {
+		link := base + "/" + domain + "/" + f.ID
+		if match := dailyNote.FindStringSubmatch(f.Slug); match != nil {
+			if day, errParse := time.Parse("2006-01-02", match[1]); errParse == nil {
+				events = append(events, calendarEvent{UID: f.ID, Day: day, Summary: f.DisplayName(), URL: link, Updated: f.Modified})
+			}
+		}
+		front, _ := utils.FrontMatter(f.Data)
+		for i, date := range front["date"] {
+			if day, errParse := time.Parse("2006-01-02", strings.TrimSpace(date)); errParse == nil {
+				events = append(events, calendarEvent{UID: f.ID + "-date" + strconv.Itoa(i), Day: day, Summary: f.DisplayName(), URL: link, Updated: f.Modified})
+			}
+		}
+	}
+	tasks, err := readableTasks(domain, editor)
+	if err != nil {
+		return
+	}
+	for _, t := range tasks {
+		events = append(events, calendarEvent{
+			UID:     t.FileID + "-task" + strconv.Itoa(t.Line),
+			Day:     t.Due,
+			Summary: t.Text + " (" + t.Page().DisplayName() + ")",
+			URL:     base + "/" + domain + "/" + t.FileID,
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Day.Before(events[j].Day) })
+	return
+}
+
+// icsText escapes text for a value of an iCalendar property
+var icsText = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icsLine folds a content line of iCalendar into lines of at most 75
+// bytes, without splitting characters (RFC 5545)
+func icsLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// the space that continues a line counts too
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// handleCalendar serves the calendar of the domain as iCalendar, so that
+// calendar apps can subscribe to it
+func (tr *TemplateRender) handleCalendar(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, errGet := fs.GetDomainFromName(tr.Domain)
+	if errGet != nil || (!tr.SignedIn && !ispublic) {
+		http.Error(w, "domain is not public", http.StatusForbidden)
+		return
+	}
+	host := r.Host
+	// calendar apps have no editor id, so pages only for their owner
+	// are left out
+	events, err := calendarEvents(baseURL(r), tr.Domain, "")
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC().Format("20060102T150405Z")
+	var b strings.Builder
+	icsLine(&b, "BEGIN:VCALENDAR")
+	icsLine(&b, "VERSION:2.0")
+	icsLine(&b, "PRODID:-//rwtxt//EN")
+	icsLine(&b, "CALSCALE:GREGORIAN")
+	icsLine(&b, "X-WR-CALNAME:"+icsText.Replace(tr.Domain+" on rwtxt"))
+	for _, e := range events {
+		stamp := now
+		if !e.Updated.IsZero() {
+			stamp = e.Updated.UTC().Format("20060102T150405Z")
+		}
+		icsLine(&b, "BEGIN:VEVENT")
+		icsLine(&b, "UID:"+e.UID+"@"+host)
+		icsLine(&b, "DTSTAMP:"+stamp)
+		icsLine(&b, "DTSTART;VALUE=DATE:"+e.Day.Format("20060102"))
+		icsLine(&b, "DTEND;VALUE=DATE:"+e.Day.AddDate(0, 0, 1).Format("20060102"))
+		icsLine(&b, "SUMMARY:"+icsText.Replace(e.Summary))
+		icsLine(&b, "URL:"+e.URL)
+		icsLine(&b, "END:VEVENT")
+	}
+	icsLine(&b, "END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	_, err = w.Write([]byte(b.String()))
+	return
+}
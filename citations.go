@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/schollz/rwtxt/src/bibtex"
+)
+
+// bibliographyPage is the page of a domain with the BibTeX entries that
+// its pages cite
+const bibliographyPage = "bibliography"
+
+// cite replaces the citations of the markdown with links to a list of
+// references from the bibliography of the domain
+func cite(domain, editor, markdown string) string {
+	if !strings.Contains(markdown, "[") || !strings.Contains(markdown, "@") {
+		return markdown
+	}
+	var entries map[string]bibtex.Entry
+	files, err := fs.Get(bibliographyPage, domain)
+	if err == nil && len(files) == 1 && fs.CheckAccess(files[0].ID, editor, false) == nil {
+		entries = bibtex.Parse(files[0].Data)
+	}
+	return bibtex.Cite(markdown, entries)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/importer"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// ClipForm is a web page that is about to be clipped
+type ClipForm struct {
+	URL       string
+	Title     string
+	Selection string
+}
+
+// bookmarklet opens the clipper for the current page and its selection
+func bookmarklet(r *http.Request, domain string) template.URL {
+	return template.URL(`javascript:(function(){var s='',g=window.getSelection();` +
+		`if(g.rangeCount){var d=document.createElement('div');d.appendChild(g.getRangeAt(0).cloneContents());s=d.innerHTML;}` +
+		`window.open('` + baseURL(r) + `/api/clip?domain=` + url.QueryEscape(domain) +
+		`&url='+encodeURIComponent(location.href)+'&title='+encodeURIComponent(document.title)+'&selection='+encodeURIComponent(s));})()`)
+}
+
+// handleAPIClip saves a web page as a new page. GET shows the clipper
+// that the bookmarklet opens, POST fetches the page (or uses the selection
+// that was sent) and saves its content as markdown.
+func (tr *TemplateRender) handleAPIClip(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := strings.ToLower(strings.TrimSpace(r.FormValue("domain")))
+	tr.Domain = domain
+	tr.SignedIn, tr.DomainKey, _, _, _ = isSignedIn(w, r, domain)
+	if !tr.SignedIn || domain == "public" {
+		if r.Method == "POST" && wantsJSON(r) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		return tr.handleMain(w, r, "need to log in to clip pages")
+	}
+
+	tr.Clip = ClipForm{
+		URL:       strings.TrimSpace(r.FormValue("url")),
+		Title:     strings.TrimSpace(r.FormValue("title")),
+		Selection: r.FormValue("selection"),
+	}
+	if r.Method != "POST" {
+		tr.Title = "Clip " + tr.Clip.Title
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "text/html")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		return clipTemplate.Execute(gz, tr)
+	}
+
+	f, err := clip(domain, tr.Clip)
+	if err != nil {
+		log.Warn(err)
+		if wantsJSON(r) {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: "could not clip " + tr.Clip.URL})
+		}
+		return tr.handleMain(w, r, "could not clip "+tr.Clip.URL)
+	}
+	if wantsJSON(r) {
+		return writeJSON(w, http.StatusOK, newAPIFiles([]db.File{f})[0])
+	}
+	http.Redirect(w, r, "/"+domain+"/"+f.ID, 302)
+	return nil
+}
+
+// clip converts the web page into a page of the domain, with a line
+// saying where it came from
+func clip(domain string, form ClipForm) (f db.File, err error) {
+	page, err := url.Parse(form.URL)
+	if err != nil {
+		return
+	}
+	var document []byte
+	if strings.TrimSpace(form.Selection) == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		document, page, err = fetch(ctx, form.URL)
+		if err != nil {
+			return
+		}
+	}
+	title, markdown, err := importer.Clip(page, document, form.Selection)
+	if err != nil {
+		return
+	}
+	if form.Title != "" {
+		title = form.Title
+	}
+	if title == "" {
+		title = page.Host
+	}
+
+	data := "# " + title + "\n\n" +
+		"_Clipped from [" + page.Host + "](" + form.URL + ") on " + time.Now().Format("Jan 2 2006") + "_\n\n" +
+		markdown + "\n"
+	f = fs.NewFile(utils.Slugify(data), data)
+	f.Domain = domain
+	err = fs.Save(f)
+	if err == db.ErrSlugTaken {
+		err = nil
+	}
+	if err == nil {
+		forgetDomain(domain)
+		pluginSaved(f)
+		webhookSaved(f)
+	}
+	return
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") || r.FormValue("format") == "json"
+}
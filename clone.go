@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// runClone is the "clone" command, which copies a domain of another
+// rwtxt into a domain of the database, like a transfer does
+func runClone(args []string) (err error) {
+	set := flag.NewFlagSet("clone", flag.ExitOnError)
+	key := set.String("key", "", "transfer key or token of the domain on the other rwtxt, or else $RWTXT_KEY")
+	domain := set.String("domain", "", "domain to copy into, instead of one named like the copied domain")
+	password := set.String("password", "", "password of the domain to copy into, if it has to be made")
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "usage: rwtxt [-db rwtxt.db] clone [-key key] [-domain name] [-password password] https://<other rwtxt>/<domain>")
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	if set.NArg() != 1 {
+		set.Usage()
+		return errors.New("give the address of one domain to clone")
+	}
+	source := set.Arg(0)
+	_, remoteDomain, err := parseDomainAddress(source)
+	if err != nil {
+		return
+	}
+	if *key == "" {
+		*key = os.Getenv("RWTXT_KEY")
+	}
+	if *key == "" {
+		return errors.New("set -key to a transfer key or a token of " + source)
+	}
+	if *domain == "" {
+		*domain = remoteDomain
+	}
+	*domain = strings.ToLower(strings.TrimSpace(*domain))
+	if *domain == "public" {
+		return errors.New("can not clone into public, set -domain")
+	}
+
+	// whoever runs the command may clone from their own network, which
+	// transfers started from a page may not
+	transferClient = &http.Client{Timeout: transferClient.Timeout}
+
+	fs, err = db.New(dbName)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+	fs.SetLimits(limits.Limits)
+	fs.SetReservedSlugs(reservedSlug)
+	if _, _, errDomain := fs.GetDomainFromName(*domain); errDomain != nil {
+		if *password == "" {
+			return errors.New("there is no domain " + *domain + " here, set -password to make it")
+		}
+		if err = fs.SetDomain(*domain, *password); err != nil {
+			return
+		}
+		fmt.Printf("made the domain %s\n", *domain)
+	}
+
+	imported, err := pullDomain(*domain, db.InstanceEditor, source, *key)
+	if err != nil {
+		return errors.Wrap(err, "could not clone "+source)
+	}
+	fmt.Printf("cloned %d pages of %s into %s\n", imported, source, *domain)
+	return
+}
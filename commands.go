@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// templateFolder is where a domain keeps the pages that /template
+// fills in, so that "/template meeting" uses the page templates/meeting
+const templateFolder = "templates/"
+
+// expandCommand returns what a slash command that needs the pages of the
+// domain is replaced with. /date and /upload are handled by the editor.
+func expandCommand(domain, editor, text, command string) (expansion string, err error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", errors.New("no command")
+	}
+	switch fields[0] {
+	case "/toc":
+		expansion = utils.TableOfContents(text)
+		if expansion == "" {
+			err = errors.New("the page has no headings")
+		}
+	case "/template":
+		if len(fields) < 2 {
+			return "", errors.New("name a template, like /template meeting")
+		}
+		var f db.File
+		f, err = templatePage(domain, editor, strings.Join(fields[1:], " "))
+		expansion = f.Data
+	default:
+		err = errors.New("unknown command " + fields[0])
+	}
+	return
+}
+
+// templatePage returns the page of the template, which is looked for in
+// the template folder first
+func templatePage(domain, editor, name string) (f db.File, err error) {
+	name = utils.Slugify(name)
+	for _, page := range []string{templateFolder + name, name} {
+		files, errGet := fs.Get(page, domain)
+		if errGet != nil || len(files) != 1 {
+			continue
+		}
+		if err = fs.CheckAccess(files[0].ID, editor, false); err != nil {
+			return
+		}
+		if hidden, _ := fs.IsHidden(files[0].ID); hidden {
+			continue
+		}
+		return files[0], nil
+	}
+	return f, errors.New("there is no template " + name)
+}
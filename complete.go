@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/search"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// completeLimit is how many suggestions are returned at most
+const completeLimit = 10
+
+// APICompletions are the pages and tags that start with what is being
+// typed, for the editor to suggest
+type APICompletions struct {
+	Pages []APIFile `json:"pages"`
+	Tags  []string  `json:"tags"`
+}
+
+// handleAPIComplete suggests pages for ?page= and tags for ?tag=
+func handleAPIComplete(w http.ResponseWriter, r *http.Request, domain string) (err error) {
+	completions := APICompletions{Pages: []APIFile{}, Tags: []string{}}
+	editor := requestEditorID(r)
+	if page, ok := r.URL.Query()["page"]; ok {
+		var files []db.File
+		files, err = fs.FindSlugs(strings.TrimSpace(page[0]), domain, completeLimit)
+		if err != nil {
+			return
+		}
+		completions.Pages = newAPIFiles(readable(files, editor))
+	}
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		completions.Tags, err = completeTags(domain, editor, strings.ToLower(strings.TrimPrefix(tag, "#")))
+		if err != nil {
+			return
+		}
+	}
+	return writeJSON(w, http.StatusOK, completions)
+}
+
+// completeTags returns the tags of the domain that start with the
+// prefix, most used first
+func completeTags(domain, editor, prefix string) (tags []string, err error) {
+	tags, err = usedTags(domain, editor, prefix)
+	if len(tags) > completeLimit {
+		tags = tags[:completeLimit]
+	}
+	return
+}
+
+// usedTags returns all the tags of the pages of the domain that the
+// editor may read that start with the prefix, most used first
+func usedTags(domain, editor, prefix string) (tags []string, err error) {
+	files, err := fs.GetAll(domain)
+	if err != nil {
+		return
+	}
+	counts := make(map[string]int)
+	for _, f := range readable(files, editor) {
+		for _, tag := range utils.Tags(f.Data) {
+			if strings.HasPrefix(tag, prefix) {
+				counts[tag]++
+			}
+		}
+	}
+	tags = []string{}
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if counts[tags[i]] != counts[tags[j]] {
+			return counts[tags[i]] > counts[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	return
+}
+
+// tagSuggestions is how many tags are suggested for a page at most
+const tagSuggestions = 5
+
+// suggestTags suggests tags for the text of a page that it does not have
+// yet: first the tags of the domain that are words of the text, then the
+// words the text uses most
+func suggestTags(domain, editor, text string) (suggestions []string, err error) {
+	has := make(map[string]bool)
+	for _, tag := range utils.Tags(text) {
+		has[tag] = true
+	}
+	words := make(map[string]bool)
+	for _, word := range search.Parse(text).Words {
+		words[word] = true
+	}
+	tags, err := usedTags(domain, editor, "")
+	if err != nil {
+		return
+	}
+	candidates := []string{}
+	for _, tag := range tags {
+		if words[tag] {
+			candidates = append(candidates, tag)
+		}
+	}
+	candidates = append(candidates, utils.Keywords(text, tagSuggestions)...)
+	for _, tag := range candidates {
+		if has[tag] {
+			continue
+		}
+		has[tag] = true
+		suggestions = append(suggestions, tag)
+		if len(suggestions) == tagSuggestions {
+			break
+		}
+	}
+	return
+}
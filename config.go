@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// domainConfigFormat marks the backups of the settings of a domain, which
+// are JSON files that can be restored to the domain on a new rwtxt
+const domainConfigFormat = "rwtxt-config"
+const domainConfigVersion = 1
+
+// maxConfigSize is the most bytes a backup of settings can have
+const maxConfigSize = 1 << 20
+
+// DomainConfig is a backup of the settings of a domain, with its webhooks
+// and the hashes of its keys. The hashes can not sign in, but a key whose
+// hash is restored signs in to the domain again.
+type DomainConfig struct {
+	Format   string           `json:"format"`
+	Version  int              `json:"version"`
+	Domain   string           `json:"domain"`
+	Public   bool             `json:"public"`
+	Options  db.DomainOptions `json:"options"`
+	Keys     []db.KeyHash     `json:"keys,omitempty"`
+	Exported time.Time        `json:"exported"`
+}
+
+// domainConfig backs up the settings of the domain
+func domainConfig(domain string) (config DomainConfig, err error) {
+	config = DomainConfig{
+		Format:   domainConfigFormat,
+		Version:  domainConfigVersion,
+		Domain:   domain,
+		Exported: time.Now().UTC(),
+	}
+	_, config.Public, err = fs.GetDomainFromName(domain)
+	if err != nil {
+		return
+	}
+	config.Options, err = fs.GetDomainOptions(domain)
+	if err != nil {
+		return
+	}
+	config.Options.TransferKey, config.Options.TransferKeyExpires = "", time.Time{}
+	config.Keys, err = fs.GetKeyHashes(domain)
+	return
+}
+
+// readDomainConfig reads a backup of settings
+func readDomainConfig(r io.Reader) (config DomainConfig, err error) {
+	err = json.NewDecoder(io.LimitReader(r, maxConfigSize)).Decode(&config)
+	if err != nil {
+		return config, errors.Wrap(err, "not a backup of settings")
+	}
+	if config.Format != domainConfigFormat {
+		return config, errors.New("not a backup of settings")
+	}
+	if config.Version > domainConfigVersion {
+		return config, errors.New("the backup is from a newer rwtxt")
+	}
+	return
+}
+
+// restoreDomainConfig restores a backup of settings to the domain, which
+// can have another name than the domain that was backed up. It returns
+// how many keys it restored.
+func restoreDomainConfig(domain string, config DomainConfig) (restored int, err error) {
+	options := config.Options
+	if len(options.Webhooks) > maxWebhooks {
+		return 0, errors.Errorf("a domain can have %d webhooks", maxWebhooks)
+	}
+	for _, webhook := range options.Webhooks {
+		u, errURL := url.Parse(webhook)
+		if errURL != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return 0, errors.New("a webhook needs an http or https address")
+		}
+	}
+	if len(options.Webhooks) > 0 && options.WebhookSecret == "" {
+		options.WebhookSecret, err = newWebhookSecret()
+		if err != nil {
+			return
+		}
+	}
+	current, err := fs.GetDomainOptions(domain)
+	if err != nil {
+		return
+	}
+	options.TransferKey, options.TransferKeyExpires = current.TransferKey, current.TransferKeyExpires
+
+	if err = fs.SetUniqueSlugs(domain, options.UniqueSlugs); err != nil {
+		return
+	}
+	if err = fs.SetDomainOptions(domain, options); err != nil {
+		return
+	}
+	if err = fs.UpdateDomain(domain, "", config.Public); err != nil {
+		return
+	}
+	restored, err = fs.RestoreKeyHashes(domain, config.Keys)
+	if err == nil {
+		log.Debugf("restored the settings of %s and %d keys to %s", config.Domain, restored, domain)
+	}
+	return
+}
+
+// restoredMessage says what was restored from a backup of settings
+func restoredMessage(restored int) string {
+	if restored == 1 {
+		return "restored the settings and 1 sign-in"
+	}
+	return "restored the settings and " + strconv.Itoa(restored) + " sign-ins"
+}
+
+// handleAPIConfig downloads the backup of the settings of a domain (GET)
+// for someone signed in to it, or restores one (POST) with a token of
+// the domain
+func handleAPIConfig(w http.ResponseWriter, r *http.Request, domain string) (err error) {
+	if domain == "public" {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "cannot modify public"})
+	}
+	switch r.Method {
+	case "GET":
+		if !apiSignedIn(w, r, domain) {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+		}
+		config, errConfig := domainConfig(domain)
+		if errConfig != nil {
+			return errConfig
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+domain+`.rwtxt-config.json"`)
+		return writeJSON(w, http.StatusOK, config)
+	case "POST":
+		// restoring needs the key in a header, which other sites can not
+		// send along like the cookie
+		if keyDomain, hasKey := apiKeyDomain(r); !hasKey || keyDomain != domain {
+			return writeJSON(w, http.StatusForbidden, Payload{Message: "need a token of the domain"})
+		}
+		config, errConfig := readDomainConfig(r.Body)
+		if errConfig != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Domain: domain, Message: errConfig.Error()})
+		}
+		restored, errRestore := restoreDomainConfig(domain, config)
+		if errRestore != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Domain: domain, Message: errRestore.Error()})
+		}
+		return writeJSON(w, http.StatusOK, Payload{Domain: domain, Message: restoredMessage(restored), Success: true})
+	}
+	return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "method not allowed"})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsPingInterval is how often open websockets are pinged
+	wsPingInterval = 30 * time.Second
+	// wsPongWait is how long a websocket may go without answering a ping
+	wsPongWait = 2 * wsPingInterval
+	// wsCloseIdle is the close code sent to websockets that were idle,
+	// which are not reconnected until the page is edited again
+	wsCloseIdle = 4000
+)
+
+var (
+	// wsIdleTimeout closes websockets that sent nothing for this long
+	wsIdleTimeout = time.Hour
+	// maxWebsockets is the number of websockets that may be open at once
+	maxWebsockets = 1000
+	// maxWebsocketsPerIP is the number of websockets that one address
+	// may have open at once
+	maxWebsocketsPerIP = 20
+	// metricsEnabled serves the number of open websockets at /metrics
+	metricsEnabled bool
+	// allowedOrigins are the other sites whose pages may open websockets
+	allowedOrigins = make(map[string]bool)
+)
+
+// connections counts the open websockets
+var connections = struct {
+	sync.Mutex
+	total    int
+	perIP    map[string]int
+	rejected int64
+}{perIP: make(map[string]int)}
+
+// openConnection counts a new websocket of the address, unless the
+// address or the server has too many open already
+func openConnection(ip string) bool {
+	connections.Lock()
+	defer connections.Unlock()
+	if connections.total >= maxWebsockets || connections.perIP[ip] >= maxWebsocketsPerIP {
+		connections.rejected++
+		return false
+	}
+	connections.total++
+	connections.perIP[ip]++
+	return true
+}
+
+func closeConnection(ip string) {
+	connections.Lock()
+	defer connections.Unlock()
+	connections.total--
+	connections.perIP[ip]--
+	if connections.perIP[ip] <= 0 {
+		delete(connections.perIP, ip)
+	}
+}
+
+// setAllowedOrigins parses a comma separated list of origins
+func setAllowedOrigins(origins string) {
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+		if origin != "" {
+			allowedOrigins[origin] = true
+		}
+	}
+}
+
+// checkOrigin only lets pages of this site, or of the allowed origins,
+// open websockets. Clients that are not browsers send no origin.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) || allowedOrigins[strings.ToLower(origin)] {
+		return true
+	}
+	log.Debugf("refusing websocket from %s", origin)
+	return false
+}
+
+// trustProxy is whether rwtxt runs behind a proxy on the same machine,
+// whose X-Forwarded-For header gives the address of the client
+var trustProxy bool
+
+// clientIP returns the address of the client. The address forwarded by
+// a proxy is only believed with -trust-proxy, when the proxy runs on the
+// same machine, and never for an onion service, whose clients all come
+// from Tor on the same machine. The proxy adds the address it was reached
+// from to the end of X-Forwarded-For, and what comes before it is sent
+// by the client, so only the last address is taken.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustProxy || torMode {
+		return host
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		if last := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(last) != nil {
+			return last
+		}
+	}
+	return host
+}
+
+// keepAlive pings the websocket so that connections that went away are
+// noticed, and closes it once it has been idle for too long. It returns a
+// function that stops pinging.
+func (s *editSession) keepAlive() (stop func()) {
+	s.touch()
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			deadline := time.Now().Add(10 * time.Second)
+			if time.Since(time.Unix(0, atomic.LoadInt64(&s.lastMessage))) > wsIdleTimeout {
+				log.Debug("closing idle websocket")
+				s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(wsCloseIdle, "idle"), deadline)
+				s.conn.Close()
+				return
+			}
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				log.Debug("ping:", err)
+				s.conn.Close()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// touch notes that the client sent a message
+func (s *editSession) touch() {
+	atomic.StoreInt64(&s.lastMessage, time.Now().UnixNano())
+}
+
+// handleMetrics shows the open websockets in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) (err error) {
+	connections.Lock()
+	total, addresses, rejected := connections.total, len(connections.perIP), connections.rejected
+	connections.Unlock()
+	editors.Lock()
+	pages := len(editors.files)
+	editors.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err = fmt.Fprintf(w, `# HELP rwtxt_websockets_open Open websocket connections.
+# TYPE rwtxt_websockets_open gauge
+rwtxt_websockets_open %d
+# HELP rwtxt_websocket_addresses Addresses with open websocket connections.
+# TYPE rwtxt_websocket_addresses gauge
+rwtxt_websocket_addresses %d
+# HELP rwtxt_websockets_rejected_total Websocket connections refused for exceeding a limit.
+# TYPE rwtxt_websockets_rejected_total counter
+rwtxt_websockets_rejected_total %d
+# HELP rwtxt_pages_open Pages that are open in a websocket connection.
+# TYPE rwtxt_pages_open gauge
+rwtxt_pages_open %d
+`, total, addresses, rejected, pages)
+	if err != nil {
+		return
+	}
+
+	keyCleanup.Lock()
+	runs, deleted, last := keyCleanup.runs, keyCleanup.deleted, keyCleanup.last
+	keyCleanup.Unlock()
+	var lastSeconds int64
+	if !last.IsZero() {
+		lastSeconds = last.Unix()
+	}
+	_, err = fmt.Fprintf(w, `# HELP rwtxt_key_cleanups_total Times that expired sign-ins were deleted.
+# TYPE rwtxt_key_cleanups_total counter
+rwtxt_key_cleanups_total %d
+# HELP rwtxt_keys_deleted_total Sign-ins deleted because they expired.
+# TYPE rwtxt_keys_deleted_total counter
+rwtxt_keys_deleted_total %d
+# HELP rwtxt_key_cleanup_last_timestamp_seconds When expired sign-ins were last deleted.
+# TYPE rwtxt_key_cleanup_last_timestamp_seconds gauge
+rwtxt_key_cleanup_last_timestamp_seconds %d
+`, runs, deleted, lastSeconds)
+	if err != nil {
+		return
+	}
+
+	m, err := lastMaintenance()
+	if err != nil || m == nil {
+		return
+	}
+	integrity := 0
+	if m.OK() {
+		integrity = 1
+	}
+	_, err = fmt.Fprintf(w, `# HELP rwtxt_db_maintenance_last_timestamp_seconds When the database was last checked and vacuumed.
+# TYPE rwtxt_db_maintenance_last_timestamp_seconds gauge
+rwtxt_db_maintenance_last_timestamp_seconds %d
+# HELP rwtxt_db_integrity_ok Whether the last integrity check of the database found nothing wrong.
+# TYPE rwtxt_db_integrity_ok gauge
+rwtxt_db_integrity_ok %d
+# HELP rwtxt_db_size_bytes Size of the database after it was last vacuumed.
+# TYPE rwtxt_db_size_bytes gauge
+rwtxt_db_size_bytes %d
+`, m.Started.Unix(), integrity, m.SizeAfter)
+	return
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// maxConvertedSize limits the size of a document made by the converter
+const maxConvertedSize = 64 << 20
+
+// converter is an external command, like pandoc, that exports pages into
+// the formats that are not built in. The page is given on stdin as
+// markdown, and {format} and {output} in the arguments are replaced with
+// the format and the file to write to. Without {output} the document is
+// read from stdout.
+var converter struct {
+	command []string
+	// formats maps the extension of each format to the name that the
+	// converter knows it by
+	formats map[string]string
+	names   []string
+	timeout time.Duration
+}
+
+// setConverter configures the converter from a command and a list of
+// formats like "odt,rtf,tex:latex"
+func setConverter(command, formats string, timeout time.Duration) {
+	converter.command = strings.Fields(command)
+	converter.formats = make(map[string]string)
+	converter.names = nil
+	converter.timeout = timeout
+	if len(converter.command) == 0 {
+		return
+	}
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+		ext, name := format, format
+		if i := strings.Index(format, ":"); i > 0 {
+			ext, name = format[:i], format[i+1:]
+		}
+		if _, ok := converter.formats[ext]; ok {
+			continue
+		}
+		converter.formats[ext] = name
+		converter.names = append(converter.names, ext)
+		pageActions = append(pageActions, "export/"+ext)
+	}
+}
+
+// convert runs the converter on the markdown in an empty temporary
+// directory, with a bare environment, and kills it after the timeout
+func convert(markdown, ext string) (b []byte, err error) {
+	format, ok := converter.formats[ext]
+	if !ok {
+		err = errors.New("unknown format " + ext)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "rwtxt-export")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output."+ext)
+
+	args := make([]string, len(converter.command)-1)
+	usesOutput := false
+	for i, arg := range converter.command[1:] {
+		if strings.Contains(arg, "{output}") {
+			usesOutput = true
+		}
+		arg = strings.Replace(arg, "{format}", format, -1)
+		args[i] = strings.Replace(arg, "{output}", output, -1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), converter.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, converter.command[0], args...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir, "TMPDIR=" + dir}
+	cmd.Stdin = strings.NewReader(markdown)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.New("converter timed out after " + converter.timeout.String())
+		return
+	} else if err != nil {
+		err = errors.Wrap(err, "converter: "+strings.TrimSpace(stderr.String()))
+		return
+	}
+
+	if !usesOutput {
+		b = stdout.Bytes()
+	} else {
+		info, errStat := os.Stat(output)
+		if errStat != nil {
+			err = errors.Wrap(errStat, "converter did not write output")
+			return
+		} else if info.Size() > maxConvertedSize {
+			err = errors.New("converted document is too large")
+			return
+		}
+		b, err = ioutil.ReadFile(output)
+	}
+	if len(b) > maxConvertedSize {
+		err = errors.New("converted document is too large")
+	}
+	return
+}
+
+// handleExportConverted downloads a page in a format made by the
+// converter, like /domain/page/export/odt
+func (tr *TemplateRender) handleExportConverted(w http.ResponseWriter, r *http.Request, ext string) (err error) {
+	f, err := tr.getReadableFile(tr.Page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+
+	b, err := convert(f.Data, ext)
+	if err != nil {
+		log.Warn(err)
+		http.Error(w, "could not export as "+ext, http.StatusInternalServerError)
+		return nil
+	}
+	w.Header().Set("Content-Type", contentType("export."+ext))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(f)+"."+ext+`"`)
+	_, err = w.Write(b)
+	return
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// csvRows is how many rows of a CSV upload are shown on one page of its
+// table, unless the macro says otherwise
+const csvRows = 25
+
+// maxCSVRows is the most rows a page of a CSV table can show
+const maxCSVRows = 500
+
+// csvCell keeps a value of a CSV file from being read as markdown
+var csvCell = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "`", "\\`", "<", "&lt;")
+
+// csvTable returns the CSV upload as a markdown table. Its header links
+// sort the rows and the links under it page through them, with query
+// parameters numbered by the table so that tables on one page are
+// sorted and paged on their own:
+//
+//	?csv1.sort=2 sorts the first table by its second column
+//	?csv1.sort=-2 sorts it the other way
+//	?csv1.page=3 shows its third page
+func (c macroContext) csvTable(upload string, named map[string]string) string {
+	id := strings.TrimPrefix(strings.SplitN(upload, "?", 2)[0], "/uploads/")
+	if !strings.HasPrefix(id, "sha256-") {
+		return macroError(`name an upload, like {{csv "/uploads/sha256-..."}}`)
+	}
+	name, data, err := readBlob(id)
+	if err != nil {
+		return macroError("there is no upload " + id)
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return macroError(name + " is not a CSV file: " + err.Error())
+	}
+	if len(records) == 0 {
+		return macroError(name + " is empty")
+	}
+
+	perPage := csvRows
+	if named["rows"] != "" {
+		n, errRows := strconv.Atoi(named["rows"])
+		if errRows != nil || n < 1 {
+			return macroError("the rows of csv is not a number")
+		}
+		perPage = n
+		if perPage > maxCSVRows {
+			perPage = maxCSVRows
+		}
+	}
+
+	n := 1
+	if c.tables != nil {
+		*c.tables++
+		n = *c.tables
+	}
+	sortKey, pageKey := fmt.Sprintf("csv%d.sort", n), fmt.Sprintf("csv%d.page", n)
+	link := func(key, value string) string {
+		query := url.Values{}
+		for k, v := range c.query {
+			query[k] = v
+		}
+		query.Del(pageKey)
+		if value != "" {
+			query.Set(key, value)
+		}
+		return "?" + query.Encode()
+	}
+
+	header, rows := records[0], records[1:]
+	column, _ := strconv.Atoi(c.query.Get(sortKey))
+	descending := column < 0
+	if descending {
+		column = -column
+	}
+	if column > 0 && column <= len(header) {
+		sortCSV(rows, column-1, descending)
+	} else {
+		column = 0
+	}
+
+	pages := (len(rows) + perPage - 1) / perPage
+	page, _ := strconv.Atoi(c.query.Get(pageKey))
+	if page < 1 {
+		page = 1
+	} else if page > pages && pages > 0 {
+		page = pages
+	}
+	first := (page - 1) * perPage
+	last := first + perPage
+	if last > len(rows) {
+		last = len(rows)
+	}
+
+	table := [][]string{make([]string, len(header))}
+	for i, title := range header {
+		order, arrow := strconv.Itoa(i+1), ""
+		if column == i+1 {
+			if descending {
+				arrow = " ▼"
+			} else {
+				order, arrow = "-"+order, " ▲"
+			}
+		}
+		table[0][i] = "[" + csvCell.Replace(title) + arrow + "](" + link(sortKey, order) + ")"
+	}
+	for _, row := range rows[first:last] {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = csvCell.Replace(value)
+		}
+		table = append(table, cells)
+	}
+
+	markdown := "\n\n" + utils.FormatTable(table, nil)
+	if pages > 1 {
+		markdown += fmt.Sprintf("\nRows %d–%d of %d", first+1, last, len(rows))
+		if page > 1 {
+			markdown += " · [« Previous](" + link(pageKey, strconv.Itoa(page-1)) + ")"
+		}
+		if page < pages {
+			markdown += " · [Next »](" + link(pageKey, strconv.Itoa(page+1)) + ")"
+		}
+		markdown += "\n"
+	}
+	return markdown
+}
+
+// sortCSV sorts the rows by the column, as numbers if both values are
+// numbers
+func sortCSV(rows [][]string, column int, descending bool) {
+	value := func(row []string) string {
+		if column < len(row) {
+			return strings.TrimSpace(row[column])
+		}
+		return ""
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := value(rows[i]), value(rows[j])
+		if descending {
+			a, b = b, a
+		}
+		x, errX := strconv.ParseFloat(a, 64)
+		y, errY := strconv.ParseFloat(b, 64)
+		if errX == nil && errY == nil {
+			return x < y
+		}
+		return strings.ToLower(a) < strings.ToLower(b)
+	})
+}
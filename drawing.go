@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// drawingName is the name of the blobs that drawings are saved as
+const drawingName = "drawing.json"
+
+// maxDrawingSize is how large the JSON of a drawing may be
+const maxDrawingSize = 1 << 20
+
+// Drawing is a sketch made of strokes of a pen, which is saved as JSON
+// in a blob and shown on pages with {{drawing "sha256-..."}}
+type Drawing struct {
+	Width   int      `json:"width"`
+	Height  int      `json:"height"`
+	Strokes []Stroke `json:"strokes"`
+}
+
+// Stroke is a line through points of a drawing
+type Stroke struct {
+	Color  string       `json:"color"`
+	Width  float64      `json:"width"`
+	Points [][2]float64 `json:"points"`
+}
+
+var (
+	drawingColor    = regexp.MustCompile(`^#[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+	newDrawingMacro = regexp.MustCompile(`\{\{\s*drawing\s*\}\}`)
+)
+
+// parseDrawing reads the JSON of a drawing, keeping its size and strokes
+// within bounds
+func parseDrawing(data []byte) (d Drawing, err error) {
+	if len(data) > maxDrawingSize {
+		return d, errors.New("the drawing is too large")
+	}
+	if err = json.Unmarshal(data, &d); err != nil {
+		return d, errors.Wrap(err, "not a drawing")
+	}
+	if d.Width < 1 || d.Width > 4000 {
+		d.Width = 800
+	}
+	if d.Height < 1 || d.Height > 4000 {
+		d.Height = 500
+	}
+	for i := range d.Strokes {
+		if !drawingColor.MatchString(d.Strokes[i].Color) {
+			d.Strokes[i].Color = "#000000"
+		}
+		if d.Strokes[i].Width <= 0 || d.Strokes[i].Width > 100 {
+			d.Strokes[i].Width = 2
+		}
+	}
+	return
+}
+
+// SVG draws the drawing as an image
+func (d Drawing) SVG() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, d.Width, d.Height, d.Width, d.Height)
+	for _, s := range d.Strokes {
+		if len(s.Points) == 0 {
+			continue
+		}
+		var path strings.Builder
+		for i, p := range s.Points {
+			if i == 0 {
+				fmt.Fprintf(&path, "M%.1f %.1f", p[0], p[1])
+			} else {
+				fmt.Fprintf(&path, " L%.1f %.1f", p[0], p[1])
+			}
+		}
+		if len(s.Points) == 1 {
+			// a dot
+			path.WriteString(" l0.1 0")
+		}
+		fmt.Fprintf(&b, `<path d="%s" fill="none" stroke="%s" stroke-width="%.1f" stroke-linecap="round" stroke-linejoin="round"/>`, path.String(), s.Color, s.Width)
+	}
+	b.WriteString("</svg>")
+	return b.String()
+}
+
+// readDrawing returns the drawing saved in the blob
+func readDrawing(id string) (d Drawing, err error) {
+	name, data, err := readBlob(id)
+	if err != nil {
+		return
+	}
+	if name != drawingName {
+		return d, errors.New(id + " is not a drawing")
+	}
+	return parseDrawing(data)
+}
+
+// drawing returns the markdown of a drawing, an image of it that links
+// to where it is edited, or a link to start one if it has no id yet
+func (c macroContext) drawing(id string) string {
+	page := "/" + c.domain + "/" + strings.SplitN(c.pages[len(c.pages)-1], "#", 2)[0]
+	if id == "" {
+		return "[New drawing](" + page + "/drawing)"
+	}
+	if !strings.HasPrefix(id, "sha256-") {
+		return macroError(`name a drawing, like {{drawing "sha256-..."}}`)
+	}
+	return "[![Drawing](" + page + "/drawing.svg?id=" + id + ")](" + page + "/drawing?id=" + id + ")"
+}
+
+// handleDrawing shows a drawing of a page to be edited, and saves it
+// as a new blob that the page then shows instead
+func (tr *TemplateRender) handleDrawing(w http.ResponseWriter, r *http.Request) (err error) {
+	f, err := tr.getReadableFile(tr.Page)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	id := r.FormValue("id")
+	if r.Method != "POST" {
+		d := Drawing{Width: 800, Height: 500, Strokes: []Stroke{}}
+		if id != "" {
+			if d, err = readDrawing(id); err != nil {
+				return tr.handleMain(w, r, err.Error())
+			}
+		}
+		drawing, _ := json.Marshal(d)
+		tr.File = db.File{ID: f.ID, Title: f.Title, Slug: f.Slug}
+		tr.Title = "Drawing in " + f.DisplayName()
+		tr.Drawing = string(drawing)
+		tr.DrawingID = id
+		return drawingTemplate.Execute(w, tr)
+	}
+
+	if !tr.SignedIn || tr.Domain == "public" {
+		http.Error(w, "need to be logged in", http.StatusForbidden)
+		return
+	}
+	if readOnly() || isBlocked(r) {
+		http.Error(w, "blocked", http.StatusForbidden)
+		return
+	}
+	if err = fs.CheckAccess(f.ID, tr.EditorID, true); err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	d, err := parseDrawing([]byte(r.FormValue("drawing")))
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	data, _ := json.Marshal(d)
+	link, err := saveUpload(drawingName, strings.NewReader(string(data)))
+	if err != nil {
+		return
+	}
+	newID := strings.TrimPrefix(strings.SplitN(link, "?", 2)[0], "/uploads/")
+	err = fs.ChangeData(f.ID, tr.Domain, func(data string) (string, error) {
+		if id != "" && strings.Contains(data, id) {
+			return strings.Replace(data, id, newID, -1), nil
+		}
+		macro := `{{drawing "` + newID + `"}}`
+		if loc := newDrawingMacro.FindStringIndex(data); loc != nil {
+			return data[:loc[0]] + macro + data[loc[1]:], nil
+		}
+		return strings.TrimRight(data, "\n") + "\n\n" + macro + "\n", nil
+	})
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	forgetDomain(tr.Domain)
+	http.Redirect(w, r, "/"+tr.Domain+"/"+f.ID, 302)
+	return
+}
+
+// handleDrawingSVG shows a drawing of a page as an image
+func (tr *TemplateRender) handleDrawingSVG(w http.ResponseWriter, r *http.Request) (err error) {
+	if _, err = tr.getReadableFile(tr.Page); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	d, err := readDrawing(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	// drawings are named by their hash, so they never change
+	w.Header().Set("Cache-Control", "public, max-age=7776000")
+	w.Header().Set("Content-Type", "image/svg+xml")
+	_, err = w.Write([]byte(d.SVG()))
+	return
+}
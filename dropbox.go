@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// dropBoxFile returns the file of the page if it is a drop box
+func dropBoxFile(domain, page string) (f db.File, ok bool) {
+	files, err := fs.Get(page, domain)
+	if err != nil || len(files) != 1 {
+		return
+	}
+	access, err := fs.GetPageAccess(files[0].ID)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	return files[0], access.Level == db.AccessDropBox
+}
+
+// dropBoxEntry is what is added to a drop box for the text
+func dropBoxEntry(text string, added time.Time) string {
+	return "---\n\n*Added " + added.UTC().Format("Jan 2 2006 3:04pm") + " UTC*\n\n" + strings.TrimSpace(text)
+}
+
+// handleDropBox lets someone who is not signed in to the domain add to
+// a drop box, without showing them what is in it
+func (tr *TemplateRender) handleDropBox(w http.ResponseWriter, r *http.Request, f db.File) (err error) {
+	tr.File = db.File{ID: f.ID}
+	tr.Title = "Drop box"
+	if r.Method == "POST" {
+		if isBlocked(r) {
+			http.Error(w, "blocked", http.StatusForbidden)
+			return
+		}
+		text := strings.TrimSpace(r.FormValue("text"))
+		if text == "" {
+			tr.Message = "Write something to add."
+			return dropBoxTemplate.Execute(w, tr)
+		}
+		err = fs.Append(f.ID, tr.Domain, dropBoxEntry(text, time.Now()))
+		if err == db.ErrPageTooLarge {
+			tr.Message = "The drop box is full."
+			return dropBoxTemplate.Execute(w, tr)
+		} else if err != nil {
+			return
+		}
+		tr.Message = "Thank you, it was added."
+	}
+	return dropBoxTemplate.Execute(w, tr)
+}
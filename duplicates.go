@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/documentsimilarity"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+const (
+	// duplicateThreshold is the Jaccard similarity above which a page
+	// is considered a duplicate of another page
+	duplicateThreshold = 0.9
+	// duplicateMinLength is the shortest content that is checked
+	// for duplicates, as short notes are too often similar
+	duplicateMinLength = 100
+)
+
+// findDuplicate returns the page in the domain that is most similar to
+// the data of the file, if it is nearly identical
+func findDuplicate(domain string, fileid string, data string) (duplicate db.File, similarity float64, found bool, err error) {
+	if len(data) < duplicateMinLength {
+		return
+	}
+	files, err := fs.GetAll(domain)
+	if err != nil {
+		return
+	}
+	documents := []string{}
+	others := []db.File{}
+	for _, file := range files {
+		if file.ID == fileid || len(file.Data) < duplicateMinLength {
+			continue
+		}
+		others = append(others, file)
+		documents = append(documents, file.Data)
+	}
+	if len(documents) == 0 {
+		return
+	}
+
+	ds, err := documentsimilarity.New(documents)
+	if err != nil {
+		return
+	}
+	similarities, err := ds.JaccardSimilarity(data)
+	if err != nil || len(similarities) == 0 {
+		return
+	}
+	if similarities[0].Similarity < duplicateThreshold {
+		return
+	}
+	duplicate = others[similarities[0].Index]
+	similarity = similarities[0].Similarity
+	found = true
+	return
+}
+
+// handleDuplicates resolves pages that share a slug, either by merging
+// all of them into one page or by renaming one of them
+func (tr *TemplateRender) handleDuplicates(w http.ResponseWriter, r *http.Request) (err error) {
+	tr.Domain = strings.TrimSpace(strings.ToLower(r.FormValue("domain")))
+	tr.SignedIn, tr.DomainKey, tr.DefaultDomain, tr.DomainList, tr.DomainKeys = isSignedIn(w, r, tr.Domain)
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/"+tr.Domain, 302)
+		return
+	}
+	if !tr.SignedIn && tr.Domain != "public" {
+		return tr.handleMain(w, r, "need to log in to change pages")
+	}
+
+	id := r.FormValue("id")
+	switch r.FormValue("action") {
+	case "merge":
+		var files []db.File
+		files, err = fs.Get(r.FormValue("slug"), tr.Domain)
+		if err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+		for _, f := range files {
+			if f.ID == id {
+				continue
+			}
+			log.Debugf("merging %s into %s", f.ID, id)
+			err = fs.Merge(f.ID, id, tr.Domain)
+			if err != nil {
+				return tr.handleMain(w, r, err.Error())
+			}
+		}
+		forgetDomain(tr.Domain)
+		http.Redirect(w, r, "/"+tr.Domain+"/"+id, 302)
+	case "rename":
+		var slug string
+		slug, err = fs.Rename(id, tr.Domain, r.FormValue("title"))
+		if err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+		forgetDomain(tr.Domain)
+		http.Redirect(w, r, "/"+tr.Domain+"/"+slug, 302)
+	default:
+		return tr.handleMain(w, r, "unknown action")
+	}
+	return
+}
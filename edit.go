@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// handleEdit edits a page with a plain form, for browsers without
+// JavaScript. The text is saved like the editor saves it, and is not
+// saved over changes that were made since the form was opened.
+func (tr *TemplateRender) handleEdit(w http.ResponseWriter, r *http.Request) (err error) {
+	if !tr.SignedIn && tr.Domain != "public" {
+		return tr.handleMain(w, r, "need to log in to edit pages")
+	}
+	if isBlocked(r) {
+		return tr.handleMain(w, r, "you can not change pages")
+	}
+	_, _, err = fs.GetDomainFromName(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, "domain does not exist")
+	}
+	havePage, err := fs.Exists(tr.Page, tr.Domain)
+	if err != nil {
+		return
+	}
+	var files []db.File
+	if havePage {
+		if files, err = fs.Get(tr.Page, tr.Domain); err != nil {
+			return
+		}
+	}
+	var f db.File
+	if len(files) > 0 {
+		f = files[0]
+		if errAccess := fs.CheckAccess(f.ID, tr.EditorID, true); errAccess != nil {
+			return tr.handleMain(w, r, errAccess.Error())
+		}
+		tr.EditVersion = f.Modified.UnixNano()
+	} else {
+		// the page is made when something is written in it
+		f = db.File{ID: utils.UUID(), Slug: tr.Page, Domain: tr.Domain}
+	}
+	if torMode {
+		w.Header().Set("Content-Security-Policy", torScriptlessContentSecurity)
+	}
+
+	if r.Method == "POST" {
+		text := strings.Replace(r.FormValue("text"), "\r\n", "\n", -1)
+		data := strings.TrimSpace(text)
+		version, _ := strconv.ParseInt(r.FormValue("version"), 10, 64)
+		options, _ := fs.GetDomainOptions(tr.Domain)
+		if holder, locked := editHolder(f.ID); locked && options.LockEditing {
+			tr.Message = holder + " is editing this page, try again when they are done."
+		} else if version != tr.EditVersion && data != strings.TrimSpace(f.Data) {
+			// keep what was written, and save it over the changes only
+			// when it is sent again
+			tr.Message = "The page was changed since you opened it. Save again to replace those changes with yours."
+		} else if data == "" && len(files) == 0 {
+			http.Redirect(w, r, "/"+tr.Domain, 302)
+			return
+		} else {
+			f.Data, f.Slug, f.Domain = data, utils.Slugify(data), tr.Domain
+			f.Editor, f.EditorIP = tr.EditorID, clientIP(r)
+			if len(files) == 0 {
+				f.Created = time.Now()
+			}
+			tr.Message, err = saveEdit(f, clientIP(r), tr.EditorID)
+			if err != nil {
+				return
+			}
+			if tr.Message == "" {
+				http.Redirect(w, r, "/"+tr.Domain+"/"+f.ID, 302)
+				return
+			}
+		}
+		f.Data = text
+		if files, _ = fs.Get(f.ID, tr.Domain); len(files) > 0 {
+			tr.EditVersion = files[0].Modified.UnixNano()
+		}
+	}
+
+	if len(files) > 0 {
+		tr.Page = f.ID
+	}
+	tr.File = f
+	tr.Title = "Editing " + f.DisplayName()
+	tr.Rows = strings.Count(f.Data, "\n") + 5
+	if tr.Rows < 15 {
+		tr.Rows = 15
+	}
+	return editTemplate.Execute(w, tr)
+}
+
+// saveEdit saves a page that was edited, like an editor saves it, and
+// returns why it was not saved when that can be fixed by the editor
+func saveEdit(f db.File, ip, editor string) (message string, err error) {
+	f.Data = runScripts(scriptOnSave, f.Domain, f, f.Data)
+	err = fs.Save(f)
+	if err == nil || err == db.ErrSlugTaken {
+		forgetDomain(f.Domain)
+		go storeRendering(f)
+	}
+	if err == db.ErrSlugTaken {
+		message = "Another page has this name, so the page was saved without it."
+		err = nil
+	} else if err == db.ErrPageTooLarge || err == db.ErrTooManyPages || err == db.ErrReadOnly || err == db.ErrNoAccess || err == db.ErrOtherDomain {
+		return err.Error(), nil
+	} else if err != nil {
+		return
+	}
+	if errIP := fs.SetSourceIP(f.ID, ip); errIP != nil {
+		log.Error(errIP)
+	}
+	pluginSaved(f)
+	webhookSaved(f)
+	pushChanged(f, editor)
+	go func() {
+		if errSimilar := addSimilar(f.Domain, f.ID); errSimilar != nil {
+			log.Error(errSimilar)
+		}
+	}()
+	return
+}
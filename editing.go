@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/websocket"
+	"github.com/schollz/rwtxt/src/db"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// editLockTimeout is how long an editor keeps the soft lock on a page
+// after their last change
+const editLockTimeout = 5 * time.Minute
+
+// saveInterval is how often the changes of an editor are saved
+// while they are typing
+var saveInterval = time.Second
+
+// tagSuggestInterval is how often tags are suggested for a page while
+// it is written
+const tagSuggestInterval = 15 * time.Second
+
+// editSession is the websocket connection of someone viewing or
+// editing a page
+type editSession struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+	ip        string
+	editor    string
+
+	// lastMessage is when the client last sent something, in Unix
+	// nanoseconds, accessed atomically
+	lastMessage int64
+
+	// the text this connection last sent, used only by the
+	// connection's own goroutine to apply patches
+	text   string
+	textID string
+
+	// guarded by saveLock
+	saveLock  sync.Mutex
+	pending   *pendingSave
+	saveTimer *time.Timer
+	lastSaved time.Time
+	// the tags last suggested, and when
+	suggestedTags string
+	lastTagCheck  time.Time
+
+	// guarded by editors
+	fileID   string
+	name     string
+	editing  bool
+	since    time.Time
+	lastEdit time.Time
+}
+
+// editors has the sessions of every page that is open, so that
+// everyone on a page can be told who is editing it
+var editors = struct {
+	sync.Mutex
+	files map[string]map[*editSession]bool
+}{files: make(map[string]map[*editSession]bool)}
+
+// send writes to the websocket, which may be shared with broadcasts
+func (s *editSession) send(p Payload) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return s.conn.WriteJSON(p)
+}
+
+func (s *editSession) displayName() string {
+	if s.name == "" {
+		return "someone"
+	}
+	return s.name
+}
+
+// active returns whether the session has edited its page recently
+func (s *editSession) active() bool {
+	return s.editing && time.Since(s.lastEdit) < editLockTimeout
+}
+
+// watch moves the session to the page
+func (s *editSession) watch(fileID string, name string) {
+	editors.Lock()
+	previous := s.fileID
+	if name != "" {
+		s.name = truncate(name, 40)
+	}
+	if previous != fileID {
+		s.remove()
+		s.fileID = fileID
+		s.editing = false
+		if editors.files[fileID] == nil {
+			editors.files[fileID] = make(map[*editSession]bool)
+		}
+		editors.files[fileID][s] = true
+	}
+	editors.Unlock()
+	if previous != fileID && previous != "" {
+		broadcastEditors(previous)
+	}
+}
+
+// edit marks the session as editing its page. When the page is locked
+// it returns whoever is editing the page instead.
+func (s *editSession) edit(lock bool) (holder string, locked bool) {
+	editors.Lock()
+	if lock {
+		for other := range editors.files[s.fileID] {
+			if other != s && other.active() && (!s.active() || other.since.Before(s.since)) {
+				editors.Unlock()
+				return other.displayName(), true
+			}
+		}
+	}
+	started := !s.editing
+	if !s.active() {
+		s.since = time.Now()
+	}
+	s.editing = true
+	s.lastEdit = time.Now()
+	editors.Unlock()
+	if started {
+		broadcastEditors(s.fileID)
+	}
+	return
+}
+
+// editHolder returns who is editing the page in an editor, for saves
+// that do not come from an editor
+func editHolder(fileID string) (holder string, editing bool) {
+	editors.Lock()
+	defer editors.Unlock()
+	for s := range editors.files[fileID] {
+		if s.active() {
+			return s.displayName(), true
+		}
+	}
+	return
+}
+
+// leave removes the session when its websocket closes
+func (s *editSession) leave() {
+	editors.Lock()
+	fileID := s.fileID
+	s.remove()
+	editors.Unlock()
+	if fileID != "" {
+		broadcastEditors(fileID)
+	}
+}
+
+// remove must be called with editors locked
+func (s *editSession) remove() {
+	if s.fileID == "" {
+		return
+	}
+	delete(editors.files[s.fileID], s)
+	if len(editors.files[s.fileID]) == 0 {
+		delete(editors.files, s.fileID)
+	}
+	s.fileID = ""
+}
+
+// pendingSave is the latest change of an editor that is not saved yet
+type pendingSave struct {
+	file    db.File
+	payload Payload
+}
+
+// currentText returns the text of the page that this connection sent
+// last, or the saved text if it sent none
+func (s *editSession) currentText(id, domain string) string {
+	if s.textID == id {
+		return s.text
+	}
+	files, err := fs.Get(id, domain)
+	if err != nil || len(files) != 1 {
+		return ""
+	}
+	return files[0].Data
+}
+
+// queueSave saves the change once saveInterval has passed since the
+// last save. Changes that are still waiting are replaced, as every
+// change has the whole text of the page.
+func (s *editSession) queueSave(f db.File, p Payload) {
+	s.saveLock.Lock()
+	defer s.saveLock.Unlock()
+	if s.pending != nil && s.pending.file.ID != f.ID {
+		s.save()
+	}
+	s.pending = &pendingSave{file: f, payload: p}
+	if s.saveTimer == nil {
+		s.saveTimer = time.AfterFunc(saveInterval-time.Since(s.lastSaved), s.flush)
+	}
+}
+
+// flush saves the change that is waiting, if there is one
+func (s *editSession) flush() {
+	s.saveLock.Lock()
+	defer s.saveLock.Unlock()
+	s.save()
+}
+
+// save must be called with saveLock held, which keeps saves in order
+func (s *editSession) save() {
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+	}
+	if s.pending == nil {
+		return
+	}
+	f, p := s.pending.file, s.pending.payload
+	s.pending = nil
+	s.lastSaved = time.Now()
+	ctx, span := tracer.Start(context.Background(), "ws save",
+		trace.WithAttributes(attribute.String("rwtxt.domain", f.Domain), attribute.String("rwtxt.page", f.ID)),
+	)
+	defer span.End()
+
+	var response Payload
+	if f.Data == "" {
+		// a new page is not made until something is written in it
+		if exists, _ := fs.Exists(f.ID, f.Domain); !exists {
+			savedChanges.add(p.Client, p.Seq)
+			if err := s.send(Payload{ID: p.ID, Message: "ack", Seq: p.Seq}); err != nil {
+				log.Debug("write:", err)
+			}
+			return
+		}
+	}
+	f.Data = runScripts(scriptOnSave, f.Domain, f, f.Data)
+	end := traceDB(ctx, "Save")
+	err := fs.Save(f)
+	if err == nil || err == db.ErrSlugTaken {
+		end(nil)
+		forgetDomain(f.Domain)
+		go storeRendering(f)
+	} else {
+		end(err)
+	}
+	if err == db.ErrSlugTaken {
+		// the domain requires unique slugs and the content
+		// was saved without taking the slug of another page
+		savedChanges.add(p.Client, p.Seq)
+		response = Payload{
+			ID:      p.ID,
+			Slug:    p.Slug,
+			Message: "slug_taken",
+			Data:    err.Error(),
+			Seq:     p.Seq,
+		}
+	} else if err == db.ErrPageTooLarge || err == db.ErrTooManyPages || err == db.ErrOtherDomain ||
+		err == db.ErrReadOnly || err == db.ErrNoAccess {
+		// sending it again would not help
+		response = Payload{
+			ID:      p.ID,
+			Message: "not saving",
+			Data:    err.Error(),
+			Seq:     p.Seq,
+		}
+	} else if err != nil {
+		// not acknowledged, so the editor sends it again
+		log.Error(err)
+		response = Payload{
+			ID:      p.ID,
+			Message: "not saving",
+		}
+	} else {
+		savedChanges.add(p.Client, p.Seq)
+		if errIP := fs.SetSourceIP(f.ID, s.ip); errIP != nil {
+			log.Error(errIP)
+		}
+		pluginSaved(f)
+		webhookSaved(f)
+		pushChanged(f, s.editor)
+		files, _ := fs.Get(p.Slug, p.Domain)
+		response = Payload{
+			ID:      p.ID,
+			Slug:    p.Slug,
+			Message: "unique_slug",
+			Success: len(files) < 2,
+			Seq:     p.Seq,
+		}
+	}
+	if err = s.send(response); err != nil {
+		log.Debug("write:", err)
+	}
+	if response.Message == "unique_slug" {
+		s.suggestTags(f)
+	}
+}
+
+// suggestTags sends the tags suggested for the page when they change,
+// and must be called with saveLock held
+func (s *editSession) suggestTags(f db.File) {
+	if time.Since(s.lastTagCheck) < tagSuggestInterval {
+		return
+	}
+	s.lastTagCheck = time.Now()
+	tags, err := suggestTags(f.Domain, s.editor, f.Data)
+	if err != nil {
+		log.Debug(err)
+		return
+	}
+	if suggested := strings.Join(tags, " "); suggested != s.suggestedTags {
+		s.suggestedTags = suggested
+		if err = s.send(Payload{ID: f.ID, Message: "tags", Tags: tags}); err != nil {
+			log.Debug("write:", err)
+		}
+	}
+}
+
+// broadcastEditors tells everyone on the page who else is editing it
+func broadcastEditors(fileID string) {
+	editors.Lock()
+	messages := make(map[*editSession]Payload)
+	for s := range editors.files[fileID] {
+		p := Payload{ID: fileID, Message: "editors", Editors: []string{}}
+		for other := range editors.files[fileID] {
+			if other != s && other.editing {
+				p.Editors = append(p.Editors, other.displayName())
+			}
+		}
+		messages[s] = p
+	}
+	editors.Unlock()
+
+	for s, p := range messages {
+		if err := s.send(p); err != nil {
+			log.Debug("write:", err)
+		}
+	}
+}
+
+// Patch replaces Delete characters at Start of a text that is Length
+// characters long with Insert. Characters are counted in UTF-16 code
+// units, like the indices of JavaScript strings.
+type Patch struct {
+	Start  int    `json:"start"`
+	Delete int    `json:"delete"`
+	Insert string `json:"insert"`
+	Length int    `json:"length"`
+}
+
+// apply returns the patched text, unless the patch was made for another text
+func (p Patch) apply(text string) (patched string, ok bool) {
+	units := utf16.Encode([]rune(text))
+	if p.Length != len(units) || p.Start < 0 || p.Delete < 0 || p.Start+p.Delete > len(units) {
+		return
+	}
+	insert := utf16.Encode([]rune(p.Insert))
+	result := make([]uint16, 0, len(units)-p.Delete+len(insert))
+	result = append(result, units[:p.Start]...)
+	result = append(result, insert...)
+	result = append(result, units[p.Start+p.Delete:]...)
+	return string(utf16.Decode(result)), true
+}
+
+// changeLog is the last change saved from each editor
+type changeLog struct {
+	sync.Mutex
+	clients map[string]savedChange
+}
+
+type savedChange struct {
+	seq   int64
+	saved time.Time
+}
+
+// savedChanges forgets editors after an hour, as changes are only sent
+// again while reconnecting
+var savedChanges = &changeLog{clients: make(map[string]savedChange)}
+
+// saved returns whether the change was saved already
+func (l *changeLog) saved(client string, seq int64) bool {
+	if client == "" || seq == 0 {
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+	return seq <= l.clients[client].seq
+}
+
+func (l *changeLog) add(client string, seq int64) {
+	if client == "" || seq == 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	for c, change := range l.clients {
+		if time.Since(change.saved) > time.Hour {
+			delete(l.clients, c)
+		}
+	}
+	l.clients[client] = savedChange{seq: seq, saved: time.Now()}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// editorCookie keeps the anonymous id of a browser, which is recorded
+// with every revision it makes of a page of a public domain, so that
+// vandalism can be traced and reverted, and which owns the pages that
+// only some may read or change
+const editorCookie = "rwtxt-editor"
+
+// validEditorID matches the ids of browsers, which are 128 random bits
+var validEditorID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// editorID returns the anonymous id of the browser, and gives it one
+// if it has none yet. A browser that still has an id of the kind that
+// was given before, which could be guessed, gets a new one; the pages
+// of the old id stay with it, since anyone could have sent it.
+func editorID(w http.ResponseWriter, r *http.Request) string {
+	if id := requestEditorID(r); id != "" {
+		return id
+	}
+	id, err := newEditorID()
+	if err != nil {
+		log.Error(err)
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     editorCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  time.Now().Add(10 * 365 * 24 * time.Hour),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// newEditorID makes an anonymous id for a browser
+func newEditorID() (id string, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// requestEditorID returns the anonymous id that the browser sent, if it
+// sent one
+func requestEditorID(r *http.Request) string {
+	if cookie, err := r.Cookie(editorCookie); err == nil && validEditorID.MatchString(cookie.Value) {
+		return cookie.Value
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// pageActions are the views of a page that are reached by adding
+// them to the path of the page, like /domain/page/embed
+var pageActions = []string{"embed", "report", "access", "edit", "submit", "drawing", "drawing.svg", "summarize", "share", "qr", "export.html", "export.docx", "export.epub", "export.pdf", "export.zip"}
+
+// splitPageAction splits a page path into the page and its action
+func splitPageAction(page string) (string, string) {
+	for _, action := range pageActions {
+		if strings.HasSuffix(page, "/"+action) {
+			return strings.TrimSuffix(page, "/"+action), action
+		}
+	}
+	return page, ""
+}
+
+// reservedSlug reports whether a page with the slug would be hidden by a
+// system page of the domain, like settings, or by an action of another
+// page, like notes/edit. Pages are not given such slugs.
+func reservedSlug(slug string) bool {
+	slug = cleanSlugPath(strings.ToLower(slug))
+	if reservedPages[slug] {
+		return true
+	}
+	_, action := splitPageAction(slug)
+	return action != ""
+}
+
+// pageExists reports whether the page of the request is a stored page of
+// the domain, by its id or its slug
+func (tr *TemplateRender) pageExists() bool {
+	exists, err := fs.Exists(tr.Page, tr.Domain)
+	if err != nil {
+		log.Debug(err)
+	}
+	return exists
+}
+
+// OEmbed is an oEmbed response of the rich type, see https://oembed.com
+type OEmbed struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	Title        string `json:"title"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// getPublicFile returns the single file of the page if it can be read
+// without signing in, by the editor
+func getPublicFile(domain, page, editor string) (f db.File, err error) {
+	_, ispublic, err := fs.GetDomainFromName(domain)
+	if err != nil {
+		return
+	}
+	if !ispublic && domain != "public" {
+		err = fmt.Errorf("domain is not public")
+		return
+	}
+	files, err := fs.Get(page, domain)
+	if err != nil {
+		return
+	}
+	if len(files) != 1 {
+		err = fmt.Errorf("page is ambiguous")
+		return
+	}
+	f = files[0]
+	err = fs.CheckAccess(f.ID, editor, false)
+	return
+}
+
+// handleEmbed shows a page without any navigation, to be put in an iframe
+func (tr *TemplateRender) handleEmbed(w http.ResponseWriter, r *http.Request) (err error) {
+	f, err := tr.getReadableFile(tr.Page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+
+	tr.File = f
+	tr.Title = f.DisplayName()
+	tr.Rendered = renderPage(tr.Domain, tr.EditorID, f, r.URL.Query())
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return embedTemplate.Execute(gz, tr)
+}
+
+// handleOEmbed describes how to embed a public page for oEmbed consumers
+func handleOEmbed(w http.ResponseWriter, r *http.Request) (err error) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "json" {
+		http.Error(w, "only json is supported", http.StatusNotImplemented)
+		return
+	}
+	u, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	fields := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	if len(fields) < 2 {
+		http.Error(w, "url must be a page", http.StatusNotFound)
+		return
+	}
+	domain := strings.ToLower(fields[0])
+	page, _ := splitPageAction(cleanSlugPath(strings.ToLower(fields[1])))
+	f, err := getPublicFile(domain, page, requestEditorID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+
+	width, height := 600, 400
+	if maxWidth, errConv := strconv.Atoi(r.URL.Query().Get("maxwidth")); errConv == nil && maxWidth > 0 && maxWidth < width {
+		width = maxWidth
+	}
+	if maxHeight, errConv := strconv.Atoi(r.URL.Query().Get("maxheight")); errConv == nil && maxHeight > 0 && maxHeight < height {
+		height = maxHeight
+	}
+	embedURL := baseURL(r) + "/" + domain + "/" + f.ID + "/embed"
+	return writeJSON(w, http.StatusOK, OEmbed{
+		Version:      "1.0",
+		Type:         "rich",
+		ProviderName: "rwtxt",
+		ProviderURL:  baseURL(r),
+		Title:        f.DisplayName(),
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" title="%s"></iframe>`,
+			embedURL, width, height, template.HTMLEscapeString(f.DisplayName())),
+		Width:  width,
+		Height: height,
+	})
+}
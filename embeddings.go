@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/embeddings"
+)
+
+// embedder gets the embeddings of pages to find similar ones, if an API
+// for embeddings is set with -embeddings
+var embedder *embeddings.Client
+
+const (
+	// embeddingBatch is how many pages are sent to the API at once
+	embeddingBatch = 32
+	// embeddingLimit is how many pages get new embeddings at a time, the
+	// rest get theirs the next time
+	embeddingLimit = 256
+	// embeddingText is how much of a page is embedded, which models
+	// have a limit for
+	embeddingText = 8000
+)
+
+// embeddingInput is the text of the page to embed and its hash, which
+// tells whether its embedding is still up to date
+func embeddingInput(f db.File) (text, hash string) {
+	text = truncateText(f.Data, embeddingText)
+	return text, textHash(text)
+}
+
+// textHash tells texts apart, to know whether what was made from one is
+// still up to date
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// embedFiles returns the embeddings of the files, making the ones that
+// are missing or out of date, starting with those that come first
+func embedFiles(domain string, files []db.File) (vectors map[string][]float32, err error) {
+	stored, err := fs.GetEmbeddings(domain, embedder.Model)
+	if err != nil {
+		return
+	}
+	vectors = make(map[string][]float32)
+	hashes := make(map[string]string)
+	for _, e := range stored {
+		vectors[e.FileID] = e.Vector
+		hashes[e.FileID] = e.Hash
+	}
+
+	var missing []db.Embedding
+	var texts []string
+	for _, f := range files {
+		text, hash := embeddingInput(f)
+		if hashes[f.ID] == hash {
+			continue
+		}
+		delete(vectors, f.ID)
+		if len(missing) < embeddingLimit {
+			missing = append(missing, db.Embedding{FileID: f.ID, Hash: hash})
+			texts = append(texts, text)
+		}
+	}
+	for start := 0; start < len(missing); start += embeddingBatch {
+		end := start + embeddingBatch
+		if end > len(missing) {
+			end = len(missing)
+		}
+		var batch [][]float32
+		batch, err = embedder.Embed(texts[start:end])
+		if err != nil {
+			return
+		}
+		for i, vector := range batch {
+			e := missing[start+i]
+			e.Vector = vector
+			if err = fs.SetEmbedding(domain, embedder.Model, e); err != nil {
+				return
+			}
+			vectors[e.FileID] = vector
+		}
+	}
+	return
+}
+
+// similarByEmbedding returns the ids of the pages of the domain whose
+// embeddings are nearest to that of the page, with how similar they are
+func similarByEmbedding(domain, fileid string, files []db.File) (ids []string, scores []float64, err error) {
+	// the page goes first so that it always has an embedding
+	ordered := make([]db.File, 0, len(files))
+	for _, f := range files {
+		if f.ID == fileid {
+			ordered = append([]db.File{f}, ordered...)
+		} else {
+			ordered = append(ordered, f)
+		}
+	}
+	vectors, err := embedFiles(domain, ordered)
+	if err != nil {
+		return
+	}
+	query, ok := vectors[fileid]
+	if !ok {
+		return
+	}
+	var others [][]float32
+	var otherIDs []string
+	for _, f := range ordered {
+		if vector, ok := vectors[f.ID]; ok && f.ID != fileid {
+			others = append(others, vector)
+			otherIDs = append(otherIDs, f.ID)
+		}
+	}
+	for _, match := range embeddings.Nearest(query, others, 5) {
+		ids = append(ids, otherIDs[match.Index])
+		scores = append(scores, match.Similarity)
+	}
+	return
+}
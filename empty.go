@@ -0,0 +1,114 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// emptyPageAge is how long pages with nothing written in them are kept
+// before they are deleted, or forever if it is zero
+var emptyPageAge = 24 * time.Hour
+
+// runEmptyPageCleanup deletes the pages that have been empty for longer
+// than emptyPageAge, once an hour
+func runEmptyPageCleanup() {
+	if emptyPageAge <= 0 {
+		return
+	}
+	for {
+		deleteEmptyPages(time.Now().Add(-emptyPageAge))
+		time.Sleep(time.Hour)
+	}
+}
+
+func deleteEmptyPages(before time.Time) {
+	files, err := fs.GetEmptyPages("", before)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = f.ID
+	}
+	deleted, err := fs.DeleteEmptyPages(ids)
+	if err != nil {
+		log.Error(err)
+	}
+	if deleted > 0 {
+		forgetDomains()
+		log.Debugf("deleted %d empty pages", deleted)
+	}
+}
+
+// writableEmptyPages returns the empty pages of the domain that the
+// editor may change
+func writableEmptyPages(domain, editor string) (files []db.File, err error) {
+	empty, err := fs.GetEmptyPages(domain, time.Now())
+	if err != nil {
+		return
+	}
+	for _, f := range empty {
+		access, errAccess := fs.GetPageAccess(f.ID)
+		if errAccess != nil {
+			return nil, errAccess
+		}
+		if access.CanWrite(editor) {
+			files = append(files, f)
+		}
+	}
+	return
+}
+
+// handleEmptyPages lists the pages of the domain that have nothing
+// written in them, and deletes the ones that are chosen
+func (tr *TemplateRender) handleEmptyPages(w http.ResponseWriter, r *http.Request) (err error) {
+	if tr.Domain == "public" {
+		if !isAdmin(w, r) {
+			return tr.handleMain(w, r, "only moderators can clean up the public domain")
+		}
+	} else if !tr.SignedIn {
+		return tr.handleMain(w, r, "need to log in to clean up empty pages")
+	}
+
+	files, err := writableEmptyPages(tr.Domain, tr.EditorID)
+	if err != nil {
+		return
+	}
+	if r.Method == "POST" {
+		if err = r.ParseForm(); err != nil {
+			return
+		}
+		chosen := make(map[string]bool)
+		for _, id := range r.Form["id"] {
+			chosen[id] = true
+		}
+		var ids []string
+		for _, f := range files {
+			if chosen[f.ID] {
+				ids = append(ids, f.ID)
+			}
+		}
+		var deleted int
+		deleted, err = fs.DeleteEmptyPages(ids)
+		if err != nil {
+			return
+		}
+		forgetDomain(tr.Domain)
+		log.Debugf("deleted %d empty pages of %s", deleted, tr.Domain)
+		http.Redirect(w, r, "/"+tr.Domain+"/empty", 302)
+		return
+	}
+
+	tr.Files = files
+	tr.Title = tr.Domain + "/empty"
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return emptyTemplate.Execute(gz, tr)
+}
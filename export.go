@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/export"
+)
+
+var uploadSource = regexp.MustCompile(`src="/uploads/([^"?]+)(\?[^"]*)?"`)
+
+// readStaticAsset returns an uncompressed static asset, like "css/rwtxt.css"
+func readStaticAsset(name string) (b []byte, err error) {
+	compressed, err := Asset("assets/" + name + ".gz")
+	if err != nil {
+		return
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// readBlob returns the uncompressed data of an upload and its filename
+func readBlob(id string) (name string, data []byte, err error) {
+	name, data, compressed, _, err := fs.GetBlob(id)
+	if err != nil || !compressed {
+		return
+	}
+	data, err = gunzip(data)
+	return
+}
+
+// contentType guesses the MIME type of an upload from its filename
+func contentType(name string) string {
+	if t := mime.TypeByExtension(strings.ToLower(path.Ext(name))); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// inlineUploads replaces the sources of uploaded images with data URIs
+func inlineUploads(html string) string {
+	return uploadSource.ReplaceAllStringFunc(html, func(src string) string {
+		id := uploadSource.FindStringSubmatch(src)[1]
+		name, data, err := readBlob(id)
+		if err != nil {
+			log.Debugf("could not inline %s: %s", id, err)
+			return src
+		}
+		return `src="data:` + contentType(name) + `;base64,` + base64.StdEncoding.EncodeToString(data) + `"`
+	})
+}
+
+// ExportRender is rendered as a single self-contained HTML file
+type ExportRender struct {
+	Title    string
+	CSS      template.CSS
+	Rendered template.HTML
+	File     db.File
+}
+
+// handleExportHTML downloads a page as a single HTML file with its
+// stylesheets and images inlined, for archiving or emailing
+func (tr *TemplateRender) handleExportHTML(w http.ResponseWriter, r *http.Request) (err error) {
+	f, err := tr.getReadableFile(tr.Page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(f)+`.html"`)
+	return exportTemplate.Execute(w, ExportRender{
+		Title:    f.DisplayName(),
+		CSS:      template.CSS(exportStyle()),
+		Rendered: template.HTML(inlineUploads(string(renderPage(tr.Domain, tr.EditorID, f, nil)))),
+		File:     f,
+	})
+}
+
+// exportStyle is the CSS of the site, for HTML that is read without it
+func exportStyle() string {
+	var css bytes.Buffer
+	for _, name := range []string{"css/normalize.css", "css/rwtxt.css", "css/prism.css"} {
+		b, errAsset := readStaticAsset(name)
+		if errAsset != nil {
+			log.Debug(errAsset)
+			continue
+		}
+		css.Write(b)
+		css.WriteString("\n")
+	}
+	return css.String()
+}
+
+// exportFilename is a file name for a page without folders in it
+func exportFilename(f db.File) string {
+	name := f.Slug
+	if name == "" {
+		name = f.ID
+	}
+	return strings.Replace(name, "/", "-", -1)
+}
+
+// getReadableFile returns the single file of the page, if the
+// user can read it
+func (tr *TemplateRender) getReadableFile(page string) (f db.File, err error) {
+	if !tr.SignedIn {
+		return getPublicFile(tr.Domain, page, tr.EditorID)
+	}
+	files, err := fs.Get(page, tr.Domain)
+	if err != nil {
+		return
+	} else if len(files) == 0 {
+		err = errors.New("no such page")
+		return
+	}
+	f = files[0]
+	err = fs.CheckAccess(f.ID, tr.EditorID, false)
+	return
+}
+
+// exportFormat writes pages into a downloadable document
+type exportFormat struct {
+	contentType string
+	write       func(io.Writer, export.Document) error
+}
+
+var exportFormats = map[string]exportFormat{
+	"docx": {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", export.WriteDOCX},
+	"epub": {"application/epub+zip", export.WriteEPUB},
+	"html": {"text/html; charset=utf-8", export.WriteHTML},
+	"pdf":  {"application/pdf", export.WritePDF},
+}
+
+// loadUpload loads an uploaded image to embed it into an export
+func loadUpload(src string) (img export.Image, err error) {
+	if !strings.HasPrefix(src, "/uploads/") {
+		err = errors.New("not an upload: " + src)
+		return
+	}
+	id := strings.Split(strings.TrimPrefix(src, "/uploads/"), "?")[0]
+	name, data, err := readBlob(id)
+	if err != nil {
+		return
+	}
+	img = export.Image{Name: name, ContentType: contentType(name), Data: data}
+	return
+}
+
+// writeExport converts the files into the format and sends the document
+func writeExport(w http.ResponseWriter, r *http.Request, format, domain, id, title, filename string, files []db.File) (err error) {
+	doc := export.Document{
+		ID:        absoluteURL(r, id),
+		Title:     title,
+		BaseURL:   baseURL(r),
+		LoadImage: loadUpload,
+	}
+	if format == "html" {
+		doc.Style = exportStyle()
+	}
+	for _, f := range files {
+		doc.Chapters = append(doc.Chapters, export.Chapter{
+			Title: f.DisplayName(),
+			HTML:  string(renderPage(domain, requestEditorID(r), f, nil)),
+		})
+		if f.Modified.After(doc.Modified) {
+			doc.Modified = f.Modified
+		}
+	}
+
+	// convert before writing so that errors are not sent as a document
+	var b bytes.Buffer
+	if err = exportFormats[format].write(&b, doc); err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", exportFormats[format].contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+"."+format+`"`)
+	_, err = w.Write(b.Bytes())
+	return
+}
+
+// handleExportPage downloads a page as a document, like
+// /domain/page/export.epub
+func (tr *TemplateRender) handleExportPage(w http.ResponseWriter, r *http.Request, format string) (err error) {
+	f, err := tr.getReadableFile(tr.Page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	return writeExport(w, r, format, tr.Domain, "/"+tr.Domain+"/"+f.ID, f.DisplayName(), exportFilename(f), []db.File{f})
+}
+
+// outlineNote is the text of the page without its slug and title, which
+// the outline has already
+func outlineNote(f db.File) string {
+	note := strings.TrimSpace(f.Data)
+	lines := strings.SplitN(note, "\n", 2)
+	if strings.TrimSpace(lines[0]) == f.Slug || f.Title != "" && strings.HasPrefix(lines[0], "#") && strings.TrimSpace(strings.TrimLeft(lines[0], "#")) == f.Title {
+		if len(lines) == 1 {
+			return ""
+		}
+		return outlineNote(db.File{Slug: f.Slug, Title: f.Title, Data: lines[1]})
+	}
+	return note
+}
+
+// treeOutlines returns the pages of the folders as outlines nested like
+// their slugs. Folders without a page are outlines with just their name.
+func treeOutlines(nodes []*TreeNode) (outlines []export.Outline) {
+	for _, node := range nodes {
+		outline := export.Outline{Text: node.Name, Children: treeOutlines(node.Children)}
+		if node.File != nil {
+			if node.File.Title != "" {
+				outline.Text = node.File.Title
+			}
+			outline.Note = outlineNote(*node.File)
+			outline.Created = node.File.Created
+		}
+		outlines = append(outlines, outline)
+	}
+	return
+}
+
+// handleExportOPML downloads the pages of the domain, or of the folder
+// in ?prefix=, as an OPML outline nested like their slugs, for outliners
+func (tr *TemplateRender) handleExportOPML(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
+	if !tr.SignedIn && !ispublic {
+		return tr.handleMain(w, r, "need to log in to export")
+	}
+	prefix := cleanSlugPath(r.URL.Query().Get("prefix"))
+	files, err := fs.GetAllWithPrefix(tr.Domain, prefix, false)
+	if err != nil {
+		return
+	}
+	files = readable(files, tr.EditorID)
+	var modified time.Time
+	for _, f := range files {
+		if f.Modified.After(modified) {
+			modified = f.Modified
+		}
+	}
+	title := tr.Domain
+	if prefix != "" {
+		title = prefix
+	}
+
+	var b bytes.Buffer
+	if err = export.WriteOPML(&b, title, modified, treeOutlines(buildTree(tr.Domain, prefix, files).Children)); err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.Replace(title, "/", "-", -1)+`.opml"`)
+	_, err = w.Write(b.Bytes())
+	return
+}
+
+// handleExportPages downloads a set of pages as one document, one
+// chapter per page. The pages are chosen by repeating ?page= or by
+// the folder in ?prefix=, like /domain/export.epub?prefix=book. The
+// pages of ?page= can be put in order by numbering them with ?at-<page>=,
+// and those without a number come after them.
+func (tr *TemplateRender) handleExportPages(w http.ResponseWriter, r *http.Request, format string) (err error) {
+	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
+	if !tr.SignedIn && !ispublic {
+		return tr.handleMain(w, r, "need to log in to export")
+	}
+
+	var files []db.File
+	title := tr.Domain
+	prefix := cleanSlugPath(r.URL.Query().Get("prefix"))
+	if prefix != "" {
+		title = prefix
+		files, err = fs.GetAllWithPrefix(tr.Domain, prefix, false)
+		if err != nil {
+			return
+		}
+		files = readable(files, tr.EditorID)
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Slug < files[j].Slug
+		})
+	}
+	pages := r.URL.Query()["page"]
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pageNumber(r, pages[i]) < pageNumber(r, pages[j])
+	})
+	for _, page := range pages {
+		f, errFile := tr.getReadableFile(cleanSlugPath(strings.ToLower(page)))
+		if errFile != nil {
+			http.Error(w, page+": "+errFile.Error(), http.StatusNotFound)
+			return nil
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		http.Error(w, "no pages to export", http.StatusBadRequest)
+		return nil
+	}
+	if len(files) == 1 {
+		title = files[0].DisplayName()
+	}
+	if t := r.URL.Query().Get("title"); t != "" {
+		title = t
+	}
+	return writeExport(w, r, format, tr.Domain, r.URL.RequestURI(), title, strings.Replace(title, "/", "-", -1), files)
+}
+
+// pageNumber is where a page was put in the order of an export, or after
+// all the others if it was not
+func pageNumber(r *http.Request, page string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get("at-" + page)))
+	if err != nil {
+		return math.MaxInt32
+	}
+	return n
+}
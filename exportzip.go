@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// pageUpload finds the uploads that a page links to or draws, with the
+// rest of their links
+var pageUpload = regexp.MustCompile(`(/uploads/|\{\{\s*drawing\s+")(sha256-[0-9a-f]{64})(\?[^)\s"]*)?`)
+
+// zipName is a name for an upload in a zip, without folders, that no
+// other upload in it has
+func zipName(name string, taken map[string]bool) string {
+	name = strings.Replace(path.Base(strings.Replace(name, `\`, "/", -1)), "..", ".", -1)
+	if name == "" || name == "." || name == "/" {
+		name = "upload"
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 2; taken[strings.ToLower(name)]; n++ {
+		name = base + "-" + strconv.Itoa(n) + ext
+	}
+	taken[strings.ToLower(name)] = true
+	return name
+}
+
+// handleExportZip downloads a page as markdown with all of the uploads it
+// links to, like /domain/page/export.zip, for handing a note over with
+// its files. The links of the page point to the files in the zip.
+func (tr *TemplateRender) handleExportZip(w http.ResponseWriter, r *http.Request) (err error) {
+	f, err := tr.getReadableFile(tr.Page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	filename := exportFilename(f)
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	taken := map[string]bool{strings.ToLower(filename + ".md"): true}
+	names := make(map[string]string)
+	for _, match := range pageUpload.FindAllStringSubmatch(f.Data, -1) {
+		id := match[2]
+		if _, ok := names[id]; ok {
+			continue
+		}
+		name, data, errBlob := readBlob(id)
+		if errBlob != nil {
+			log.Debugf("could not export upload %s: %v", id, errBlob)
+			names[id] = ""
+			continue
+		}
+		if name == "" {
+			if q, errQuery := url.ParseQuery(strings.TrimPrefix(match[3], "?")); errQuery == nil {
+				name = q.Get("filename")
+			}
+		}
+		names[id] = zipName(name, taken)
+		fw, errCreate := zw.Create(filename + "/" + names[id])
+		if errCreate != nil {
+			return errCreate
+		}
+		if _, err = fw.Write(data); err != nil {
+			return
+		}
+	}
+
+	// drawings stay macros, which only work on the site
+	markdown := pageUpload.ReplaceAllStringFunc(f.Data, func(link string) string {
+		match := pageUpload.FindStringSubmatch(link)
+		if match[1] != "/uploads/" || names[match[2]] == "" {
+			return link
+		}
+		return (&url.URL{Path: names[match[2]]}).String()
+	})
+	fw, err := zw.Create(filename + "/" + filename + ".md")
+	if err != nil {
+		return
+	}
+	if _, err = fw.Write([]byte(markdown)); err != nil {
+		return
+	}
+	if err = zw.Close(); err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	_, err = w.Write(b.Bytes())
+	return
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+var (
+	// linkTag matches the opening tag of a link
+	linkTag   = regexp.MustCompile(`<a\s[^>]*>`)
+	hrefAttr  = regexp.MustCompile(`\shref="([^"]*)"`)
+	relAttr   = regexp.MustCompile(`\srel="[^"]*"`)
+	classAttr = regexp.MustCompile(`\sclass="([^"]*)"`)
+)
+
+// relValues are the values that the rel of external links can have
+var relValues = map[string]bool{
+	"nofollow": true, "noopener": true, "noreferrer": true, "ugc": true, "sponsored": true, "external": true,
+}
+
+// cleanRel keeps the known values of a rel, once each
+func cleanRel(rel string) string {
+	var values []string
+	for _, value := range strings.Fields(strings.ToLower(strings.Replace(rel, ",", " ", -1))) {
+		if relValues[value] && !containsString(values, value) {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// externalRel is the rel of the links of a domain to other sites. Unless
+// the domain chose its own, they are nofollow while the domain is public
+// so that spamming it does not pay, and noreferrer while it is private
+// so that the sites do not learn its addresses. They always have
+// noopener.
+func externalRel(domain string, options db.DomainOptions) string {
+	rel := cleanRel(options.LinkRel)
+	if rel == "" {
+		_, ispublic, _ := fs.GetDomainFromName(domain)
+		rel = "noreferrer"
+		if ispublic || domain == "public" {
+			rel = "nofollow ugc"
+		}
+	}
+	return cleanRel(rel + " noopener")
+}
+
+// isExternal returns whether a link leads to another site
+func isExternal(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && u.Host != "" && (u.Scheme == "" || u.Scheme == "http" || u.Scheme == "https")
+}
+
+// decorateLinks sets the rel of the links to other sites, and marks
+// them with the external class to show an icon after them
+func decorateLinks(rendered, rel string, mark bool) string {
+	return linkTag.ReplaceAllStringFunc(rendered, func(tag string) string {
+		href := hrefAttr.FindStringSubmatch(tag)
+		if href == nil || !isExternal(html.UnescapeString(href[1])) {
+			return tag
+		}
+		tag = strings.TrimSuffix(relAttr.ReplaceAllString(tag, ""), ">")
+		if mark {
+			class := classAttr.FindStringSubmatch(tag)
+			if class == nil {
+				tag += ` class="external"`
+			} else if !containsString(strings.Fields(class[1]), "external") {
+				tag = strings.Replace(tag, class[0], ` class="`+class[1]+` external"`, 1)
+			}
+		}
+		return tag + ` rel="` + rel + `">`
+	})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// feedLength is the number of most recently modified pages in a feed
+const feedLength = 20
+
+// Feed is the format independent feed of a domain, which is written
+// out as Atom or JSON Feed
+type Feed struct {
+	Title    string
+	HomeURL  string
+	FeedURL  string
+	Updated  time.Time
+	Items    []FeedItem
+	Language string
+}
+
+// FeedItem is a single page in a feed
+type FeedItem struct {
+	ID          string
+	URL         string
+	Title       string
+	Summary     string
+	ContentHTML string
+	Published   time.Time
+	Modified    time.Time
+}
+
+// newFeed collects the most recently modified pages of a domain
+func newFeed(r *http.Request, domain string) (feed Feed, err error) {
+	files, err := fs.GetTopX(domain, feedLength)
+	if err != nil {
+		return
+	}
+	// feed readers have no editor id, so pages only for their owner
+	// are left out
+	files = readable(files, "")
+	base := baseURL(r)
+	feed = Feed{
+		Title:   domain + " on rwtxt",
+		HomeURL: base + "/" + domain,
+		FeedURL: base + r.URL.Path,
+		Items:   make([]FeedItem, len(files)),
+	}
+	for i, f := range files {
+		link := f.Slug
+		if link == "" {
+			link = f.ID
+		}
+		feed.Items[i] = FeedItem{
+			ID:          base + "/" + domain + "/" + f.ID,
+			URL:         base + "/" + domain + "/" + link,
+			Title:       f.DisplayName(),
+			Summary:     utils.FirstParagraph(f.Data, 200),
+			ContentHTML: string(renderPage(domain, "", f, nil)),
+			Published:   f.Created,
+			Modified:    f.Modified,
+		}
+		if f.Modified.After(feed.Updated) {
+			feed.Updated = f.Modified
+		}
+	}
+	return
+}
+
+// jsonFeed is the JSON Feed 1.1 format, see https://jsonfeed.org/version/1.1
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	Summary       string `json:"summary,omitempty"`
+	ContentHTML   string `json:"content_html"`
+	DatePublished string `json:"date_published"`
+	DateModified  string `json:"date_modified"`
+}
+
+// writeJSONFeed writes the feed in the JSON Feed 1.1 format
+func (feed Feed) writeJSONFeed(w http.ResponseWriter) (err error) {
+	jf := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.HomeURL,
+		FeedURL:     feed.FeedURL,
+		Items:       make([]jsonFeedItem, len(feed.Items)),
+	}
+	for i, item := range feed.Items {
+		jf.Items[i] = jsonFeedItem{
+			ID:            item.ID,
+			URL:           item.URL,
+			Title:         item.Title,
+			Summary:       item.Summary,
+			ContentHTML:   item.ContentHTML,
+			DatePublished: item.Published.UTC().Format(time.RFC3339),
+			DateModified:  item.Modified.UTC().Format(time.RFC3339),
+		}
+	}
+	w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+	return json.NewEncoder(w).Encode(jf)
+}
+
+// atomFeed is the Atom format, see RFC 4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string    `xml:"id"`
+	Title     string    `xml:"title"`
+	Link      atomLink  `xml:"link"`
+	Published string    `xml:"published"`
+	Updated   string    `xml:"updated"`
+	Summary   *atomText `xml:"summary,omitempty"`
+	Content   atomText  `xml:"content"`
+}
+
+// writeAtom writes the feed in the Atom format
+func (feed Feed) writeAtom(w http.ResponseWriter) (err error) {
+	af := atomFeed{
+		ID:      feed.HomeURL,
+		Title:   feed.Title,
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: feed.HomeURL},
+			{Href: feed.FeedURL, Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: make([]atomEntry, len(feed.Items)),
+	}
+	for i, item := range feed.Items {
+		af.Entries[i] = atomEntry{
+			ID:        item.ID,
+			Title:     item.Title,
+			Link:      atomLink{Href: item.URL},
+			Published: item.Published.UTC().Format(time.RFC3339),
+			Updated:   item.Modified.UTC().Format(time.RFC3339),
+			Content:   atomText{Type: "html", Body: item.ContentHTML},
+		}
+		if item.Summary != "" {
+			af.Entries[i].Summary = &atomText{Type: "text", Body: item.Summary}
+		}
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	_, err = w.Write([]byte(xml.Header))
+	if err != nil {
+		return
+	}
+	return xml.NewEncoder(w).Encode(af)
+}
+
+// handleFeed serves the recent pages of a domain as Atom or JSON Feed
+func (tr *TemplateRender) handleFeed(w http.ResponseWriter, r *http.Request, format string) (err error) {
+	_, ispublic, errGet := fs.GetDomainFromName(tr.Domain)
+	if errGet != nil || (!tr.SignedIn && !ispublic) {
+		http.Error(w, "domain is not public", http.StatusForbidden)
+		return
+	}
+	feed, err := newFeed(r, tr.Domain)
+	if err != nil {
+		return
+	}
+	if format == "json" {
+		return feed.writeJSONFeed(w)
+	}
+	return feed.writeAtom(w)
+}
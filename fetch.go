@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxFetchSize limits the size of the web pages that are fetched
+const maxFetchSize = 5 << 20
+
+// privateNetworks are the addresses that fetched links may not point to,
+// so that they can not be used to reach the server's own network
+var privateNetworks = func() (networks []*net.IPNet) {
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4",
+		"240.0.0.0/4", "::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return
+}()
+
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchClient only connects to public addresses. The address is checked
+// after it is resolved, so that names pointing to private addresses and
+// redirects to them are refused too.
+var fetchClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return errors.New("refusing to connect to " + host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("can not follow redirect to " + req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// fetch gets a web page from the internet
+func fetch(ctx context.Context, link string) (b []byte, final *url.URL, err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = errors.New("can only fetch http and https links")
+		return
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "rwtxt/"+Version)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf("could not get %s: %s", link, resp.Status)
+		return
+	}
+	b, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxFetchSize))
+	final = resp.Request.URL
+	return
+}
+
+// checkLink returns the status code of a link. Sites that do not answer
+// HEAD requests properly are asked again with GET.
+func checkLink(ctx context.Context, link string) (status int, err error) {
+	for _, method := range []string{"HEAD", "GET"} {
+		var req *http.Request
+		req, err = http.NewRequest(method, link, nil)
+		if err != nil {
+			return
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("User-Agent", "rwtxt/"+Version)
+		var resp *http.Response
+		resp, err = fetchClient.Do(req)
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status < 400 {
+			return
+		}
+	}
+	return
+}
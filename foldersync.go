@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// syncStateFile keeps what the folder looked like after the last sync,
+// to tell which side changed since
+const syncStateFile = ".rwtxt-sync.json"
+
+// syncInterval is how often the folders are synced
+var syncInterval = time.Minute
+
+// syncFolders are the folders that domains are mirrored to
+var syncFolders []syncFolder
+
+// conflictCopy matches the copies made of files that changed on both
+// sides, they are kept in the folder but not synced
+var conflictCopy = regexp.MustCompile(`\.conflict-\d{8}-\d{6}\.md$`)
+
+// remoteFolder matches rclone remotes like "dropbox:notes"
+var remoteFolder = regexp.MustCompile(`^[A-Za-z0-9_\-. ]{2,}:`)
+
+// syncFolder mirrors the pages of a domain as .md files in a folder
+type syncFolder struct {
+	Domain string
+	Folder string
+}
+
+// syncedFile is the page a file belongs to, and the hash of the content
+// they both had at the last sync
+type syncedFile struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// parseSyncFolders parses "domain=folder,other=remote:path"
+func parseSyncFolders(s string) (folders []syncFolder, err error) {
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.New("sync folders should look like domain=folder: " + pair)
+		}
+		folders = append(folders, syncFolder{
+			Domain: strings.ToLower(strings.TrimSpace(parts[0])),
+			Folder: strings.TrimSpace(parts[1]),
+		})
+	}
+	return
+}
+
+func hashContent(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+// syncFilename is where a page is kept in the folder, nested slugs
+// become nested folders
+func syncFilename(f db.File, taken map[string]bool) string {
+	name := cleanSlugPath(f.Slug)
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return f.ID + ".md"
+		}
+	}
+	if taken[name+".md"] {
+		name += "-" + f.ID
+	}
+	return name + ".md"
+}
+
+// runFolderSync syncs the folders forever
+func runFolderSync(folders []syncFolder) {
+	for {
+		for _, folder := range folders {
+			if err := folder.sync(); err != nil {
+				log.Warnf("syncing %s with %s: %s", folder.Domain, folder.Folder, err)
+			}
+		}
+		time.Sleep(syncInterval)
+	}
+}
+
+// sync copies the changes in the domain to the folder and the changes in
+// the folder to the domain. When a page and its file both changed, the
+// page wins and the file is kept next to it as a conflict copy.
+func (s syncFolder) sync() (err error) {
+	dir := s.Folder
+	remote := remoteFolder.MatchString(s.Folder) && !filepath.IsAbs(s.Folder)
+	if remote {
+		// remotes are synced through a local copy with rclone
+		dir = filepath.Join(dbName+".sync", s.Domain)
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return
+		}
+		if _, err = rclone("sync", s.Folder, dir); err != nil {
+			return
+		}
+	}
+
+	state := make(map[string]syncedFile)
+	b, err := ioutil.ReadFile(filepath.Join(dir, syncStateFile))
+	if err == nil {
+		if err = json.Unmarshal(b, &state); err != nil {
+			return errors.Wrap(err, "reading "+syncStateFile)
+		}
+	} else if !os.IsNotExist(err) {
+		return
+	}
+
+	files, err := fs.GetAll(s.Domain)
+	if err != nil {
+		return
+	}
+	pages := make(map[string]db.File)
+	for _, f := range files {
+		f.Domain = s.Domain
+		pages[f.ID] = f
+	}
+
+	newState := make(map[string]syncedFile)
+	taken := make(map[string]bool)
+	// pages that still need to be written, with their previous file
+	oldNames := make(map[string]string)
+	now := time.Now()
+
+	var names []string
+	for name := range state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		synced := state[name]
+		content, errRead := readSyncFile(dir, name)
+		if errRead != nil && !os.IsNotExist(errRead) {
+			return errRead
+		}
+		fileExists := errRead == nil
+		fileChanged := !fileExists || hashContent(content) != synced.Hash
+		page, pageExists := pages[synced.ID]
+		pageChanged := !pageExists || hashContent(page.Data) != synced.Hash
+
+		switch {
+		case !fileChanged && !pageChanged:
+			newState[name] = synced
+			taken[name] = true
+		case !pageChanged && !fileExists:
+			// the file was deleted, so the page is too
+			page.Data = ""
+			err = saveSyncedPage(page)
+			delete(pages, page.ID)
+		case !pageChanged:
+			page.Data = content
+			page.Slug = utils.Slugify(content)
+			page.Modified = now.UTC()
+			err = saveSyncedPage(page)
+			newState[name] = syncedFile{ID: page.ID, Hash: hashContent(content)}
+			taken[name] = true
+		case !pageExists && fileChanged && fileExists:
+			// the page was deleted but the file was edited, so it comes back
+			page = fs.NewFile(utils.Slugify(content), content)
+			page.Domain = s.Domain
+			err = saveSyncedPage(page)
+			newState[name] = syncedFile{ID: page.ID, Hash: hashContent(content)}
+			taken[name] = true
+		case !pageExists:
+			// the page was deleted
+			if fileExists {
+				err = os.Remove(filepath.Join(dir, filepath.FromSlash(name)))
+			}
+		default:
+			if fileChanged && fileExists {
+				err = writeSyncFile(dir, conflictName(name, now), content)
+				log.Infof("%s changed in %s and in %s, kept the file as a copy", name, s.Domain, s.Folder)
+			}
+			oldNames[page.ID] = name
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	// pages that are new or changed
+	for _, page := range files {
+		if _, ok := pages[page.ID]; !ok {
+			continue
+		} else if _, synced := findSynced(newState, page.ID); synced {
+			continue
+		}
+		name := syncFilename(page, taken)
+		taken[name] = true
+		if err = writeSyncFile(dir, name, page.Data); err != nil {
+			return
+		}
+		if oldName, ok := oldNames[page.ID]; ok && oldName != name {
+			os.Remove(filepath.Join(dir, filepath.FromSlash(oldName)))
+		}
+		newState[name] = syncedFile{ID: page.ID, Hash: hashContent(page.Data)}
+	}
+
+	// files that are new
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, errWalk error) error {
+		if errWalk != nil {
+			return errWalk
+		}
+		name, _ := filepath.Rel(dir, p)
+		name = filepath.ToSlash(name)
+		if info.IsDir() {
+			if name != "." && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(name, ".md") || strings.HasPrefix(info.Name(), ".") || conflictCopy.MatchString(name) {
+			return nil
+		}
+		if _, ok := newState[name]; ok {
+			return nil
+		}
+		if _, ok := state[name]; ok {
+			return nil
+		}
+		content, errRead := readSyncFile(dir, name)
+		if errRead != nil {
+			return errRead
+		}
+		page := fs.NewFile(utils.Slugify(content), content)
+		page.Domain = s.Domain
+		if errSave := saveSyncedPage(page); errSave != nil {
+			return errSave
+		}
+		newState[name] = syncedFile{ID: page.ID, Hash: hashContent(content)}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	b, err = json.MarshalIndent(newState, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = writeSyncFile(dir, syncStateFile, string(b)); err != nil {
+		return
+	}
+	if remote {
+		_, err = rclone("sync", dir, s.Folder)
+	}
+	return
+}
+
+func findSynced(state map[string]syncedFile, id string) (name string, ok bool) {
+	for name, synced := range state {
+		if synced.ID == id {
+			return name, true
+		}
+	}
+	return
+}
+
+func saveSyncedPage(f db.File) (err error) {
+	f.Editor = db.InstanceEditor
+	err = fs.Save(f)
+	if err == db.ErrSlugTaken {
+		err = nil
+	}
+	if err == nil {
+		forgetDomain(f.Domain)
+	}
+	return
+}
+
+func conflictName(name string, t time.Time) string {
+	return strings.TrimSuffix(name, ".md") + ".conflict-" + t.Format("20060102-150405") + ".md"
+}
+
+func readSyncFile(dir, name string) (content string, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	content = string(b)
+	return
+}
+
+// writeSyncFile writes the file through a temporary file, so that
+// editors never see half of it
+func writeSyncFile(dir, name, content string) (err error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return
+	}
+	tmp := p + ".tmp"
+	if err = ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, p)
+}
+
+// rclone runs an rclone command, like "sync from to", and returns what
+// it printed
+func rclone(args ...string) (out string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	b, err := exec.CommandContext(ctx, "rclone", args...).CombinedOutput()
+	out = strings.TrimSpace(string(b))
+	if err != nil {
+		err = errors.Wrap(err, "rclone: "+out)
+	}
+	return
+}
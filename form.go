@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Form is a form that a page declares in its front matter, like
+//
+//	---
+//	form: rows
+//	field: Name
+//	field: Email (email, optional)
+//	field: Rating (1 / 2 / 3 / 4 / 5)
+//	field: Feedback (long)
+//	---
+//
+// Submissions are added to the page as rows of a table, or with
+// "form: pages" as pages in the folder of the page.
+type Form struct {
+	Pages  bool
+	Fields []FormField
+}
+
+// FormField is one question of a form
+type FormField struct {
+	Name     string
+	Label    string
+	Type     string
+	Choices  []string
+	Optional bool
+}
+
+// The types of form fields, besides choosing one of Choices
+var formFieldTypes = []string{"text", "long", "email", "number"}
+
+// parseForm returns the form of the page, or nil if it has none
+func parseForm(markdown string) *Form {
+	front, _ := utils.FrontMatter(markdown)
+	if len(front["form"]) == 0 || len(front["field"]) == 0 {
+		return nil
+	}
+	form := &Form{Pages: strings.ToLower(front["form"][0]) == "pages"}
+	for i, field := range front["field"] {
+		f := FormField{Label: field, Type: "text", Name: "field" + strconv.Itoa(i)}
+		if open := strings.Index(field, "("); open > 0 && strings.HasSuffix(field, ")") {
+			f.Label = strings.TrimSpace(field[:open])
+			for _, option := range strings.Split(field[open+1:len(field)-1], ",") {
+				option = strings.TrimSpace(option)
+				switch {
+				case strings.ToLower(option) == "optional":
+					f.Optional = true
+				case containsString(formFieldTypes, strings.ToLower(option)):
+					f.Type = strings.ToLower(option)
+				case strings.Contains(option, "/"):
+					f.Type = "choice"
+					for _, choice := range strings.Split(option, "/") {
+						if choice = strings.TrimSpace(choice); choice != "" {
+							f.Choices = append(f.Choices, choice)
+						}
+					}
+				}
+			}
+		}
+		if f.Label != "" {
+			form.Fields = append(form.Fields, f)
+		}
+	}
+	if len(form.Fields) == 0 {
+		return nil
+	}
+	return form
+}
+
+// values returns the answers of a submission, in the order of the fields
+func (form *Form) values(r *http.Request) (values []string, err error) {
+	for _, f := range form.Fields {
+		value := strings.TrimSpace(r.FormValue(f.Name))
+		if value == "" {
+			if !f.Optional {
+				return nil, errors.New(f.Label + " is missing")
+			}
+			values = append(values, "")
+			continue
+		}
+		switch f.Type {
+		case "email":
+			if _, errEmail := mail.ParseAddress(value); errEmail != nil {
+				return nil, errors.New(f.Label + " is not an email address")
+			}
+		case "number":
+			if _, errNumber := strconv.ParseFloat(value, 64); errNumber != nil {
+				return nil, errors.New(f.Label + " is not a number")
+			}
+		case "choice":
+			if !containsString(f.Choices, value) {
+				return nil, errors.New("choose one of the answers for " + f.Label)
+			}
+		}
+		values = append(values, value)
+	}
+	return
+}
+
+// formCell makes a value fit in a cell of a markdown table
+func formCell(value string) string {
+	value = strings.Replace(value, "|", `\|`, -1)
+	value = strings.Replace(value, "\r\n", "\n", -1)
+	return strings.Replace(value, "\n", "<br>", -1)
+}
+
+// addRow adds a submission to the table at the end of the page, or
+// starts the table if the page does not end with one
+func (form *Form) addRow(data string, values []string, submitted time.Time) string {
+	row := "| " + submitted.UTC().Format("2006-01-02 15:04")
+	for _, value := range values {
+		row += " | " + formCell(value)
+	}
+	row += " |"
+
+	data = strings.TrimRight(data, "\n")
+	lines := strings.Split(data, "\n")
+	if !strings.HasPrefix(strings.TrimSpace(lines[len(lines)-1]), "|") {
+		header, separator := "| Submitted", "| ---"
+		for _, f := range form.Fields {
+			header += " | " + formCell(f.Label)
+			separator += " | ---"
+		}
+		data += "\n\n" + header + " |\n" + separator + " |"
+	}
+	return data + "\n" + row + "\n"
+}
+
+// page returns the page of a submission, in the folder of the form page
+func (form *Form) page(parent db.File, domain string, values []string, submitted time.Time) (f db.File) {
+	folder := parent.Slug
+	if folder == "" {
+		folder = parent.ID
+	}
+	f = db.File{
+		ID:       utils.UUID(),
+		Domain:   domain,
+		Created:  submitted,
+		Modified: submitted,
+	}
+	f.Slug = folder + "/" + f.ID
+	f.Data = fmt.Sprintf("# %s %s\n", parent.DisplayName(), submitted.UTC().Format("2006-01-02 15:04"))
+	for i, field := range form.Fields {
+		f.Data += "\n**" + field.Label + "**\n\n" + values[i] + "\n"
+	}
+	return
+}
+
+// formFile returns the file of the page and its form, if it has one
+func formFile(domain, page string) (f db.File, form *Form, ok bool) {
+	files, err := fs.Get(page, domain)
+	if err != nil || len(files) != 1 {
+		return
+	}
+	f, form = files[0], parseForm(files[0].Data)
+	return f, form, form != nil
+}
+
+// handleForm shows only the form of a page, to someone who can not
+// read the page but can answer its form
+func (tr *TemplateRender) handleForm(w http.ResponseWriter, r *http.Request, f db.File, form *Form) (err error) {
+	tr.File = db.File{ID: f.ID, Title: f.Title, Slug: f.Slug}
+	tr.Title = f.DisplayName()
+	tr.Form = form
+	return formTemplate.Execute(w, tr)
+}
+
+// handleSubmit saves the answers to the form of a page
+func (tr *TemplateRender) handleSubmit(w http.ResponseWriter, r *http.Request) (err error) {
+	f, form, ok := formFile(tr.Domain, tr.Page)
+	if !ok {
+		return tr.handleMain(w, r, "there is no form at "+tr.Page)
+	}
+	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
+	if tr.SignedIn || ispublic || tr.Domain == "public" {
+		if err = fs.CheckAccess(f.ID, tr.EditorID, false); err != nil {
+			return tr.handleMain(w, r, err.Error())
+		}
+	}
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/"+tr.Domain+"/"+f.ID, 302)
+		return
+	}
+	if isBlocked(r) {
+		http.Error(w, "blocked", http.StatusForbidden)
+		return
+	}
+
+	values, err := form.values(r)
+	if err != nil {
+		tr.Message = err.Error()
+		return tr.handleForm(w, r, f, form)
+	}
+	submitted := time.Now()
+	if form.Pages {
+		err = fs.Save(form.page(f, tr.Domain, values, submitted))
+	} else {
+		err = fs.ChangeData(f.ID, tr.Domain, func(data string) (string, error) {
+			return form.addRow(data, values, submitted), nil
+		})
+	}
+	if err == db.ErrPageTooLarge || err == db.ErrTooManyPages {
+		tr.Message = "The form is not taking answers any more."
+		return tr.handleForm(w, r, f, form)
+	} else if err != nil {
+		return
+	}
+	forgetDomain(tr.Domain)
+	tr.Message = "Thank you, your answers were saved."
+	tr.Form = nil
+	tr.File = db.File{ID: f.ID, Title: f.Title, Slug: f.Slug}
+	tr.Title = f.DisplayName()
+	return formTemplate.Execute(w, tr)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// graphQLSchema is what can be queried at /graphql
+const graphQLSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+type Query {
+	# the domains the request is signed in to, and the public domain
+	domains: [Domain!]!
+	# a domain, if it is public or the request is signed in to it
+	domain(name: String!): Domain
+}
+
+type Domain {
+	name: String!
+	public: Boolean!
+	# the files of the domain, most recently modified first
+	files(tag: String, modifiedSince: Time, includeArchived: Boolean, limit: Int): [File!]!
+	# a file by its id or slug
+	file(id: String!): File
+	tags: [Tag!]!
+}
+
+type Tag {
+	name: String!
+	count: Int!
+}
+
+type File {
+	id: ID!
+	slug: String!
+	title: String!
+	created: Time!
+	modified: Time!
+	views: Int!
+	archived: Boolean!
+	data: String!
+	html: String!
+	tags: [String!]!
+	# the slugs of the pages of the domain that the file links to
+	links: [String!]!
+	externalLinks: [String!]!
+	# earlier versions of the file, newest first
+	revisions(limit: Int): [Revision!]!
+}
+
+type Revision {
+	time: Time!
+	data: String!
+}
+`
+
+var graphQL = graphql.MustParseSchema(graphQLSchema, &graphQLResolver{}, graphql.MaxDepth(8))
+
+// graphQLRequest gives the resolvers the request, to check what it
+// is signed in to
+type graphQLRequest struct {
+	w http.ResponseWriter
+	r *http.Request
+}
+
+type graphQLRequestKey struct{}
+
+func graphQLCanRead(ctx context.Context, domain string) bool {
+	req, ok := ctx.Value(graphQLRequestKey{}).(*graphQLRequest)
+	return ok && apiCanRead(req.w, req.r, domain)
+}
+
+// graphQLEditorID returns the anonymous editor id of the request
+func graphQLEditorID(ctx context.Context) string {
+	if req, ok := ctx.Value(graphQLRequestKey{}).(*graphQLRequest); ok {
+		return requestEditorID(req.r)
+	}
+	return ""
+}
+
+// handleGraphQL answers GraphQL queries sent as JSON in a POST, or in
+// the query string of a GET
+func handleGraphQL(w http.ResponseWriter, r *http.Request) (err error) {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	switch r.Method {
+	case "POST":
+		if err = json.NewDecoder(r.Body).Decode(&params); err != nil {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: "could not decode query: " + err.Error()})
+		}
+	case "GET":
+		params.Query = r.URL.Query().Get("query")
+		params.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err = json.Unmarshal([]byte(variables), &params.Variables); err != nil {
+				return writeJSON(w, http.StatusBadRequest, Payload{Message: "could not decode variables: " + err.Error()})
+			}
+		}
+	default:
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "method not allowed"})
+	}
+
+	ctx := context.WithValue(r.Context(), graphQLRequestKey{}, &graphQLRequest{w: w, r: r})
+	return writeJSON(w, http.StatusOK, graphQL.Exec(ctx, params.Query, params.OperationName, params.Variables))
+}
+
+type graphQLResolver struct{}
+
+func (*graphQLResolver) Domains(ctx context.Context) (domains []*domainResolver, err error) {
+	names := []string{"public"}
+	if req, ok := ctx.Value(graphQLRequestKey{}).(*graphQLRequest); ok {
+		_, _, _, domainList, _ := isSignedIn(req.w, req.r, "")
+		names = append(names, domainList...)
+		if keyDomain, _ := apiKeyDomain(req.r); keyDomain != "" {
+			names = append(names, keyDomain)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		d, errDomain := newDomainResolver(name)
+		if errDomain != nil {
+			return nil, errDomain
+		}
+		domains = append(domains, d)
+	}
+	return
+}
+
+func (*graphQLResolver) Domain(ctx context.Context, args struct{ Name string }) (*domainResolver, error) {
+	name := strings.ToLower(strings.TrimSpace(args.Name))
+	if !graphQLCanRead(ctx, name) {
+		return nil, nil
+	}
+	return newDomainResolver(name)
+}
+
+type domainResolver struct {
+	name   string
+	public bool
+}
+
+func newDomainResolver(name string) (d *domainResolver, err error) {
+	_, ispublic, err := fs.GetDomainFromName(name)
+	if err != nil {
+		return
+	}
+	return &domainResolver{name: name, public: ispublic}, nil
+}
+
+func (d *domainResolver) Name() string {
+	return d.name
+}
+
+func (d *domainResolver) Public() bool {
+	return d.public
+}
+
+func (d *domainResolver) Files(ctx context.Context, args struct {
+	Tag             *string
+	ModifiedSince   *graphql.Time
+	IncludeArchived *bool
+	Limit           *int32
+}) (files []*fileResolver, err error) {
+	limit := graphQLLimit(args.Limit)
+	all, err := fs.GetAllWithPrefix(d.name, "", args.IncludeArchived != nil && *args.IncludeArchived)
+	if err != nil {
+		return
+	}
+	all = readable(all, graphQLEditorID(ctx))
+	files = []*fileResolver{}
+	for _, f := range all {
+		if len(files) == limit {
+			break
+		}
+		if args.ModifiedSince != nil && f.Modified.Before(args.ModifiedSince.Time) {
+			continue
+		}
+		if args.Tag != nil && !containsString(utils.Tags(f.Data), strings.ToLower(strings.TrimPrefix(*args.Tag, "#"))) {
+			continue
+		}
+		files = append(files, &fileResolver{domain: d.name, f: f})
+	}
+	return
+}
+
+func (d *domainResolver) File(ctx context.Context, args struct{ ID string }) (*fileResolver, error) {
+	files, err := fs.Get(args.ID, d.name)
+	if err != nil || len(files) == 0 || files[0].Data == "" {
+		return nil, nil
+	}
+	if fs.CheckAccess(files[0].ID, graphQLEditorID(ctx), false) != nil {
+		return nil, nil
+	}
+	return &fileResolver{domain: d.name, f: files[0]}, nil
+}
+
+func (d *domainResolver) Tags(ctx context.Context) (tags []*tagResolver, err error) {
+	files, err := fs.GetAll(d.name)
+	if err != nil {
+		return
+	}
+	files = readable(files, graphQLEditorID(ctx))
+	counts := make(map[string]int32)
+	for _, f := range files {
+		for _, tag := range utils.Tags(f.Data) {
+			counts[tag]++
+		}
+	}
+	tags = []*tagResolver{}
+	for name, count := range counts {
+		tags = append(tags, &tagResolver{name: name, count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].count != tags[j].count {
+			return tags[i].count > tags[j].count
+		}
+		return tags[i].name < tags[j].name
+	})
+	return
+}
+
+type tagResolver struct {
+	name  string
+	count int32
+}
+
+func (t *tagResolver) Name() string {
+	return t.name
+}
+
+func (t *tagResolver) Count() int32 {
+	return t.count
+}
+
+type fileResolver struct {
+	domain string
+	f      db.File
+}
+
+func (r *fileResolver) ID() graphql.ID {
+	return graphql.ID(r.f.ID)
+}
+
+func (r *fileResolver) Slug() string {
+	return r.f.Slug
+}
+
+func (r *fileResolver) Title() string {
+	return r.f.DisplayName()
+}
+
+func (r *fileResolver) Created() graphql.Time {
+	return graphql.Time{Time: r.f.Created}
+}
+
+func (r *fileResolver) Modified() graphql.Time {
+	return graphql.Time{Time: r.f.Modified}
+}
+
+func (r *fileResolver) Views() int32 {
+	return int32(r.f.Views)
+}
+
+func (r *fileResolver) Archived() bool {
+	return r.f.Archived
+}
+
+func (r *fileResolver) Data() string {
+	return r.f.Data
+}
+
+func (r *fileResolver) HTML(ctx context.Context) string {
+	return string(renderPage(r.domain, graphQLEditorID(ctx), r.f, nil))
+}
+
+func (r *fileResolver) Tags() []string {
+	return nonNilStrings(utils.Tags(r.f.Data))
+}
+
+func (r *fileResolver) Links() []string {
+	slugs, _ := pageLinks(r.domain, r.f)
+	return nonNilStrings(slugs)
+}
+
+func (r *fileResolver) ExternalLinks() []string {
+	_, external := pageLinks(r.domain, r.f)
+	return nonNilStrings(external)
+}
+
+func (r *fileResolver) Revisions(args struct{ Limit *int32 }) (revisions []*revisionResolver) {
+	snapshots := r.f.History.GetSnapshots()
+	revisions = []*revisionResolver{}
+	for i := len(snapshots) - 1; i >= 0 && len(revisions) < graphQLLimit(args.Limit); i-- {
+		revisions = append(revisions, &revisionResolver{f: &r.f, timestamp: snapshots[i]})
+	}
+	return
+}
+
+type revisionResolver struct {
+	f         *db.File
+	timestamp int64
+}
+
+func (r *revisionResolver) Time() graphql.Time {
+	return graphql.Time{Time: time.Unix(0, r.timestamp).UTC()}
+}
+
+func (r *revisionResolver) Data() (string, error) {
+	return r.f.History.GetPreviousByTimestamp(r.timestamp)
+}
+
+// graphQLLimit returns the limit asked for, within the limits of the API
+func graphQLLimit(limit *int32) int {
+	if limit == nil || *limit < 1 {
+		return apiDefaultLimit
+	}
+	if *limit > apiMaxLimit {
+		return apiMaxLimit
+	}
+	return int(*limit)
+}
+
+// nonNilStrings returns an empty list instead of nil, for non-null lists
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
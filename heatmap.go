@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+)
+
+// heatmapWeeks is how many weeks the heatmap of a domain shows
+const heatmapWeeks = 53
+
+// Heatmap shows how much a domain was written in on each day of the
+// last year, like the contributions of a GitHub profile
+type Heatmap struct {
+	Weeks []HeatmapWeek
+	Total int
+	// Days is how many days something was written
+	Days int
+}
+
+// HeatmapWeek is a column of the heatmap, from Sunday to Saturday
+type HeatmapWeek struct {
+	// Month names the month that starts in the week
+	Month string
+	Days  []HeatmapDay
+}
+
+// HeatmapDay is a square of the heatmap
+type HeatmapDay struct {
+	Date  time.Time
+	Edits int
+	// Level is how dark the square is, from 0 for no edits to 4
+	Level int
+	// Future is whether the day has not come yet
+	Future bool
+}
+
+// buildHeatmap lays out the edits of each day, by the days of edits
+// like "2006-01-02", in weeks up to the one of today
+func buildHeatmap(edits map[string]int, today time.Time) (heatmap *Heatmap) {
+	heatmap = new(Heatmap)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	start := today.AddDate(0, 0, -int(today.Weekday())-7*(heatmapWeeks-1))
+
+	most := 0
+	for _, n := range edits {
+		if n > most {
+			most = n
+		}
+	}
+	day := start
+	for w := 0; w < heatmapWeeks; w++ {
+		var week HeatmapWeek
+		for d := 0; d < 7; d++ {
+			n := edits[day.Format("2006-01-02")]
+			week.Days = append(week.Days, HeatmapDay{Date: day, Edits: n, Level: heatLevel(n, most), Future: day.After(today)})
+			if day.Day() == 1 || (w == 0 && d == 0) {
+				week.Month = day.Format("Jan")
+			}
+			if n > 0 {
+				heatmap.Total += n
+				heatmap.Days++
+			}
+			day = day.AddDate(0, 0, 1)
+		}
+		heatmap.Weeks = append(heatmap.Weeks, week)
+	}
+	return
+}
+
+// heatLevel splits the edits of a day into four levels up to the most
+// edits of a day
+func heatLevel(n, most int) int {
+	if n <= 0 || most <= 0 {
+		return 0
+	}
+	return (n*4 + most - 1) / most
+}
+
+// editsHeatmap is the heatmap of the edits of the domain in the last year
+func editsHeatmap(domain string) (heatmap *Heatmap, err error) {
+	now := time.Now().UTC()
+	edits, err := fs.GetEditsByDay(domain, now.AddDate(0, 0, -7*heatmapWeeks))
+	if err != nil {
+		return
+	}
+	return buildHeatmap(edits, now), nil
+}
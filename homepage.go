@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// homepage is the page that the domain opens with, if it has one and
+// the visitor may read it. Otherwise the domain opens with its recent
+// pages, and with the form to sign in to it.
+func (tr *TemplateRender) homepage() (page string, ok bool) {
+	if tr.Domain == "public" {
+		return
+	}
+	_, ispublic, err := fs.GetDomainFromName(tr.Domain)
+	if err != nil || (!ispublic && !tr.SignedIn) {
+		return
+	}
+	options, err := fs.GetDomainOptions(tr.Domain)
+	if err != nil || options.Homepage == "" {
+		return
+	}
+	f, ok := findHomepage(tr.Domain, options.Homepage)
+	if !ok || len(readable([]db.File{f}, tr.EditorID)) == 0 {
+		return "", false
+	}
+	return f.ID, true
+}
+
+// findHomepage finds the one page of the domain with the id or the
+// name, as it is typed or as its slug
+func findHomepage(domain, name string) (f db.File, ok bool) {
+	for _, page := range []string{name, utils.Slugify(name)} {
+		files, err := fs.Get(page, domain)
+		if err == nil && len(files) == 1 {
+			return files[0], true
+		}
+	}
+	return
+}
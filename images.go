@@ -0,0 +1,29 @@
+package main
+
+import "github.com/schollz/rwtxt/src/images"
+
+// maxImageSize is the longest side that uploaded photos are shrunk to,
+// or 0 to keep them as they are
+var maxImageSize int
+
+// keepOriginals keeps the photos that are shrunk as they were, linked
+// from the smaller ones
+var keepOriginals bool
+
+// imageQuality is the JPEG quality of shrunk photos
+const imageQuality = 85
+
+// prepareImage removes the metadata of an uploaded image, like where a
+// photo was taken, and shrinks photos that are larger than maxImageSize.
+// The photo before it was shrunk is returned too if it is to be kept.
+func prepareImage(data []byte) (prepared, original []byte) {
+	prepared = images.Strip(data)
+	shrunk, ok := images.Shrink(prepared, maxImageSize, imageQuality)
+	if !ok {
+		return
+	}
+	if keepOriginals {
+		original = prepared
+	}
+	return shrunk, original
+}
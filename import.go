@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/images"
+	"github.com/schollz/rwtxt/src/importer"
+)
+
+// importInterval is how often scheduled imports are imported again
+var importInterval = time.Hour
+
+func importOptions(domain, folder string) importer.Options {
+	return importer.Options{
+		Domain: domain,
+		Folder: folder,
+		SaveAttachment: func(name string, data []byte) (link string, err error) {
+			threat, err := scanUpload(name, data)
+			if err != nil {
+				err = errors.Wrap(err, "could not scan "+name)
+				return
+			} else if threat != "" {
+				err = errors.New(name + " was rejected as malware: " + threat)
+				return
+			}
+			data = images.Strip(data)
+			if link, err = saveUpload(name, bytes.NewReader(data)); err == nil {
+				indexUpload(domain, link, name, data)
+			}
+			return
+		},
+	}
+}
+
+// savePages saves imported pages into the domain. With update, a page
+// replaces the page that already has its slug, instead of being added
+// next to it, unless the editor may not change that page.
+func savePages(domain, editor string, pages []importer.Page, update bool) (err error) {
+	defer forgetDomain(domain)
+	for _, page := range pages {
+		f := fs.NewFile(page.Slug, page.Markdown)
+		if update {
+			files, _ := fs.Get(page.Slug, domain)
+			if len(files) > 0 {
+				if files[0].Data == page.Markdown {
+					continue
+				}
+				f = files[0]
+				f.Data = page.Markdown
+				f.Modified = time.Now().UTC()
+				page.Created, page.Modified = time.Time{}, time.Time{}
+			}
+		}
+		f.Domain, f.Editor = domain, editor
+		if !page.Created.IsZero() {
+			f.Created = page.Created.UTC()
+		}
+		if !page.Modified.IsZero() {
+			f.Modified = page.Modified.UTC()
+		}
+		err = fs.Save(f)
+		if err == db.ErrReadOnly || err == db.ErrNoAccess {
+			log.Debugf("not importing over %s/%s: %s", domain, f.ID, err)
+			continue
+		}
+		if err != nil && err != db.ErrSlugTaken {
+			return
+		}
+	}
+	return nil
+}
+
+// handleImport imports an Evernote .enex file, a Notion .zip export, an
+// OPML outline, a MediaWiki .xml dump, a .zip of a DokuWiki or the
+// archive of a domain, or the markdown files of a GitHub repository or
+// gist, into the domain in the folder given by the form
+func (tr *TemplateRender) handleImport(w http.ResponseWriter, r *http.Request) (err error) {
+	if !tr.SignedIn || tr.Domain == "public" {
+		return tr.handleMain(w, r, "need to log in to import")
+	}
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/"+tr.Domain, 302)
+		return
+	}
+
+	folder := cleanSlugPath(strings.ToLower(r.FormValue("folder")))
+	if r.FormValue("action") == "unschedule" {
+		return tr.unscheduleImport(w, r, r.FormValue("source"), folder)
+	}
+	if source := strings.TrimSpace(r.FormValue("source")); source != "" {
+		return tr.importGitHub(w, r, source, folder, r.FormValue("schedule") == "on")
+	}
+
+	file, info, err := r.FormFile("file")
+	if err != nil {
+		return tr.handleMain(w, r, "choose a file to import")
+	}
+	defer file.Close()
+
+	var pages []importer.Page
+	switch strings.ToLower(path.Ext(info.Filename)) {
+	case ".enex":
+		pages, err = importer.ENEX(file, importOptions(tr.Domain, folder))
+	case ".opml":
+		pages, err = importer.OPML(file, importOptions(tr.Domain, folder))
+	case ".xml":
+		pages, err = importer.MediaWiki(file, importOptions(tr.Domain, folder))
+	case ".zip":
+		if isDomainArchive(file, info.Size) {
+			var imported int
+			imported, err = importDomainArchive(tr.Domain, tr.EditorID, file, info.Size)
+			if err != nil {
+				log.Warn(err)
+				return tr.handleMain(w, r, "could not import "+info.Filename+": "+err.Error())
+			}
+			log.Debugf("imported %d pages of an archive into %s", imported, tr.Domain)
+			http.Redirect(w, r, treeLink(tr.Domain, ""), 302)
+			return nil
+		}
+		if importer.IsDokuWiki(file, info.Size) {
+			pages, err = importer.DokuWiki(file, info.Size, importOptions(tr.Domain, folder))
+			break
+		}
+		pages, err = importer.Notion(file, info.Size, importOptions(tr.Domain, folder))
+	default:
+		return tr.handleMain(w, r, "can only import Evernote .enex files, Notion .zip exports, .opml outlines or wikis")
+	}
+	if err != nil {
+		log.Warn(err)
+		return tr.handleMain(w, r, "could not import "+info.Filename)
+	}
+	if err = savePages(tr.Domain, tr.EditorID, pages, false); err != nil {
+		return
+	}
+	log.Debugf("imported %d pages into %s", len(pages), tr.Domain)
+	http.Redirect(w, r, treeLink(tr.Domain, folder), 302)
+	return nil
+}
+
+// importGitHub imports a repository or gist, and keeps importing it
+// when it is scheduled
+func (tr *TemplateRender) importGitHub(w http.ResponseWriter, r *http.Request, source, folder string, schedule bool) (err error) {
+	pages, err := importer.GitHub(source, importOptions(tr.Domain, folder))
+	if err != nil {
+		log.Warn(err)
+		return tr.handleMain(w, r, "could not import "+source)
+	}
+	if err = savePages(tr.Domain, tr.EditorID, pages, true); err != nil {
+		return
+	}
+
+	if schedule {
+		options, errOptions := fs.GetDomainOptions(tr.Domain)
+		if errOptions != nil {
+			return errOptions
+		}
+		scheduled := db.ImportSource{URL: source, Folder: folder, LastImported: time.Now().UTC()}
+		found := false
+		for i, s := range options.Imports {
+			if s.URL == source && s.Folder == folder {
+				options.Imports[i], found = scheduled, true
+			}
+		}
+		if !found {
+			options.Imports = append(options.Imports, scheduled)
+		}
+		if err = fs.SetDomainOptions(tr.Domain, options); err != nil {
+			return
+		}
+	}
+	http.Redirect(w, r, treeLink(tr.Domain, folder), 302)
+	return nil
+}
+
+func (tr *TemplateRender) unscheduleImport(w http.ResponseWriter, r *http.Request, source, folder string) (err error) {
+	options, err := fs.GetDomainOptions(tr.Domain)
+	if err != nil {
+		return
+	}
+	imports := options.Imports[:0]
+	for _, s := range options.Imports {
+		if s.URL != source || s.Folder != folder {
+			imports = append(imports, s)
+		}
+	}
+	options.Imports = imports
+	if err = fs.SetDomainOptions(tr.Domain, options); err != nil {
+		return
+	}
+	http.Redirect(w, r, "/"+tr.Domain, 302)
+	return nil
+}
+
+// runScheduledImports imports the scheduled repositories and gists
+// again whenever they were last imported more than importInterval ago
+func runScheduledImports() {
+	for {
+		time.Sleep(time.Minute)
+		domains, err := fs.GetScheduledImports()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for domain, sources := range domains {
+			for _, source := range sources {
+				if time.Since(source.LastImported) < importInterval {
+					continue
+				}
+				source.Error = ""
+				pages, errImport := importer.GitHub(source.URL, importOptions(domain, source.Folder))
+				if errImport == nil {
+					// nobody is importing, so pages that only some may
+					// change are left alone
+					errImport = savePages(domain, "", pages, true)
+				}
+				if errImport != nil {
+					log.Warnf("importing %s into %s: %s", source.URL, domain, errImport)
+					source.Error = errImport.Error()
+				}
+				source.LastImported = time.Now().UTC()
+				if err = updateScheduledImport(domain, source); err != nil {
+					log.Error(err)
+				}
+			}
+		}
+	}
+}
+
+// updateScheduledImport saves when a source was imported, unless it was
+// unscheduled in the meantime
+func updateScheduledImport(domain string, source db.ImportSource) (err error) {
+	options, err := fs.GetDomainOptions(domain)
+	if err != nil {
+		return
+	}
+	for i, s := range options.Imports {
+		if s.URL == source.URL && s.Folder == source.Folder {
+			options.Imports[i] = source
+			return fs.SetDomainOptions(domain, options)
+		}
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// keyExpiry is how long a sign-in to a domain is kept without being
+// used, or forever if it is zero, which suits sites with one user
+var keyExpiry = 5 * 24 * time.Hour
+
+// keyCleanupInterval is how often the sign-ins that expired are deleted
+var keyCleanupInterval = time.Hour
+
+// keyCleanup counts the sign-ins that were deleted, for /metrics
+var keyCleanup struct {
+	sync.Mutex
+	runs    int64
+	deleted int64
+	last    time.Time
+}
+
+// runKeyCleanup deletes the sign-ins that were not used for longer than
+// keyExpiry, every keyCleanupInterval
+func runKeyCleanup() {
+	if keyExpiry <= 0 || keyCleanupInterval <= 0 {
+		return
+	}
+	for {
+		deleteOldKeys()
+		time.Sleep(keyCleanupInterval)
+	}
+}
+
+func deleteOldKeys() {
+	deleted, err := fs.DeleteOldKeys(keyExpiry)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if deleted > 0 {
+		log.Debugf("deleted %d expired keys", deleted)
+	}
+	keyCleanup.Lock()
+	keyCleanup.runs++
+	keyCleanup.deleted += deleted
+	keyCleanup.last = time.Now()
+	keyCleanup.Unlock()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// Limits are the instance-wide resource limits. The page limits are
+// checked by the database whenever a page is saved.
+type Limits struct {
+	db.Limits
+	// MaxRequestBody is the most bytes a request can send
+	MaxRequestBody int64
+	// MaxImportSize is the most bytes an uploaded export can have
+	MaxImportSize int64
+	// RequestTimeout is how long a request may take, except for
+	// websockets, imports and downloads
+	RequestTimeout time.Duration
+}
+
+var limits = Limits{
+	Limits: db.Limits{
+		MaxPageSize: 1 << 20,
+	},
+	MaxRequestBody: 16 << 20,
+	MaxImportSize:  256 << 20,
+	RequestTimeout: time.Minute,
+}
+
+// addFlags lets every limit be set from the command line
+func (l *Limits) addFlags() {
+	flag.IntVar(&l.MaxPageSize, "max-page-size", l.MaxPageSize, "most bytes a page can have, 0 for no limit")
+	flag.IntVar(&l.MaxPagesPerDomain, "max-pages-per-domain", l.MaxPagesPerDomain, "most pages a domain can have, 0 for no limit")
+	flag.Int64Var(&l.MaxRequestBody, "max-request-body", l.MaxRequestBody, "most bytes a request can send, including uploads")
+	flag.Int64Var(&l.MaxImportSize, "max-import-size", l.MaxImportSize, "most bytes an uploaded export can have")
+	flag.DurationVar(&l.RequestTimeout, "request-timeout", l.RequestTimeout, "how long a request may take")
+}
+
+// limitRequests caps the size of request bodies and the time requests
+// take. Websockets, database downloads and exports can not be buffered,
+// and imports are allowed to be larger and slower.
+func limitRequests(next http.Handler) http.Handler {
+	timed := http.TimeoutHandler(next, limits.RequestTimeout, "request took too long")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" || r.URL.Path == "/replica" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if isDownload(r.URL.Path) {
+			r.Body = http.MaxBytesReader(w, r.Body, limits.MaxRequestBody)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if isImport(r.URL.Path) {
+			r.Body = http.MaxBytesReader(w, r.Body, limits.MaxImportSize)
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limits.MaxRequestBody)
+		timed.ServeHTTP(w, r)
+	})
+}
+
+// isDownload returns whether the path downloads an archive or export of
+// a domain or page, which is written while it is made and can take long.
+// The path is read as handle reads it, so that only these routes match
+// and not pages that are named like them.
+func isDownload(p string) bool {
+	if strings.HasPrefix(p, "/api/v1/") {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(p, "/api/v1/"), "/"), "/")
+		return len(parts) == 2 && (parts[1] == "archive" || parts[1] == "config")
+	}
+	page, ok := domainPage(p)
+	if !ok {
+		return false
+	}
+	switch page {
+	case "export.docx", "export.epub", "export.html", "export.pdf", "export.opml":
+		return true
+	}
+	_, action := splitPageAction(page)
+	return strings.HasPrefix(action, "export.") || strings.HasPrefix(action, "export/")
+}
+
+// isImport returns whether the path is the import of a domain
+func isImport(p string) bool {
+	page, ok := domainPage(p)
+	return ok && page == "import"
+}
+
+// domainPage returns the page of a path of a domain, like
+// projects/notes of /mydomain/projects/notes, as handle reads it
+func domainPage(p string) (page string, ok bool) {
+	fields := strings.Split(p, "/")
+	if len(fields) < 3 || notDomains[fields[1]] {
+		return "", false
+	}
+	return cleanSlugPath(strings.ToLower(strings.Join(fields[2:], "/"))), true
+}
+
+// MaxPageKB is the largest page in kilobytes, for the settings page
+func (l Limits) MaxPageKB() int {
+	return l.MaxPageSize >> 10
+}
+
+// MaxRequestMB is the largest upload in megabytes, for the settings page
+func (l Limits) MaxRequestMB() int64 {
+	return l.MaxRequestBody >> 20
+}
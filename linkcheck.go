@@ -0,0 +1,208 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+	"golang.org/x/net/html"
+)
+
+// linkCheckInterval is how often external links are checked again
+var linkCheckInterval = 24 * time.Hour
+
+// reservedPages are the pages of a domain that are not stored pages
+var reservedPages = map[string]bool{
+	"list": true, "tree": true, "feed.atom": true, "feed.json": true, "import": true,
+	"export.docx": true, "export.epub": true, "new": true, "linkcheck": true, "transfer": true,
+	"tasks": true, "calendar.ics": true, "empty": true, "export.opml": true,
+	"export.html": true, "export.pdf": true, "settings": true, "onthisday": true, "queue": true,
+	"recent": true,
+}
+
+// BrokenLink is a link on a page that leads nowhere
+type BrokenLink struct {
+	Page   db.File
+	Link   string
+	Slug   string
+	Status db.LinkStatus
+}
+
+// LinkReport lists the broken links of a domain
+type LinkReport struct {
+	Missing   []BrokenLink
+	External  []BrokenLink
+	Unchecked int
+}
+
+// pageLinks returns the slugs of the domain and the external links
+// that the page links to
+func pageLinks(domain string, f db.File) (slugs []string, external []string) {
+	page := f.Slug
+	if page == "" {
+		page = f.ID
+	}
+	base := &url.URL{Path: "/" + domain + "/" + page}
+	root, err := html.Parse(strings.NewReader(string(utils.RenderMarkdownToHTML(f.Data))))
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			if link, internal, ok := resolveLink(domain, base, htmlAttr(n, "href")); ok && !seen[link] {
+				seen[link] = true
+				if internal {
+					slugs = append(slugs, link)
+				} else {
+					external = append(external, link)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return
+}
+
+// resolveLink returns the slug that a link points to when it is a page of
+// the domain, or the link itself when it is an external web page
+func resolveLink(domain string, base *url.URL, href string) (link string, internal bool, ok bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || u.Opaque != "" {
+		return
+	}
+	if u.Host != "" {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return
+		}
+		u.Fragment = ""
+		return u.String(), false, true
+	}
+	if u.Scheme != "" || u.Path == "" {
+		// mailto: links and links within the page
+		return
+	}
+	u = base.ResolveReference(u)
+	if !strings.HasPrefix(u.Path, "/"+domain+"/") {
+		return
+	}
+	slug, _ := splitPageAction(strings.TrimPrefix(u.Path, "/"+domain+"/"))
+	slug = cleanSlugPath(slug)
+	if slug == "" || reservedPages[slug] {
+		return
+	}
+	return slug, true, true
+}
+
+// linkReport finds the links to pages that do not exist, and the external
+// links that were found to be broken the last time they were checked, in
+// the pages that the editor may read
+func linkReport(domain, editor string) (report LinkReport, err error) {
+	files, err := fs.GetAll(domain)
+	if err != nil {
+		return
+	}
+	exists := make(map[string]bool)
+	for _, f := range files {
+		exists[f.ID] = true
+		exists[f.Slug] = true
+	}
+	for _, f := range readable(files, editor) {
+		slugs, external := pageLinks(domain, f)
+		f.Data = ""
+		for _, slug := range slugs {
+			if !exists[slug] {
+				report.Missing = append(report.Missing, BrokenLink{Page: f, Link: "/" + domain + "/" + slug, Slug: slug})
+			}
+		}
+		for _, link := range external {
+			status, found, errStatus := fs.GetLinkStatus(link)
+			if errStatus != nil {
+				log.Error(errStatus)
+			}
+			if !found {
+				report.Unchecked++
+			} else if status.Broken() {
+				report.External = append(report.External, BrokenLink{Page: f, Link: link, Status: status})
+			}
+		}
+	}
+	return
+}
+
+// runLinkChecks checks the external links of every domain in the
+// background, each at most once every linkCheckInterval
+func runLinkChecks() {
+	for {
+		domains, err := fs.GetDomainNames()
+		if err != nil {
+			log.Error(err)
+		}
+		for _, domain := range domains {
+			checkExternalLinks(domain)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func checkExternalLinks(domain string) {
+	files, err := fs.GetAll(domain)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, f := range files {
+		_, external := pageLinks(domain, f)
+		for _, link := range external {
+			status, found, err := fs.GetLinkStatus(link)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if found && time.Since(status.Checked) < linkCheckInterval {
+				continue
+			}
+			status = db.LinkStatus{URL: link, Checked: time.Now()}
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			status.Status, err = checkLink(ctx, link)
+			cancel()
+			if err != nil {
+				status.Error = err.Error()
+			}
+			if err = fs.SaveLinkStatus(status); err != nil {
+				log.Error(err)
+			}
+			// be polite to the sites being checked
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (tr *TemplateRender) handleLinkCheck(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
+	if !tr.SignedIn && !ispublic {
+		return tr.handleMain(w, r, "need to log in to check links")
+	}
+
+	report, err := linkReport(tr.Domain, tr.EditorID)
+	if err != nil {
+		return
+	}
+	tr.LinkReport = &report
+	tr.Title = tr.Domain + "/linkcheck"
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return linkCheckTemplate.Execute(gz, tr)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// The layouts of lists of pages: a line for each page, or cards in
+// columns with the first image of each page
+const (
+	listCompact = ""
+	listCards   = "cards"
+)
+
+// listLayoutCookie keeps the layout that lists were last shown in
+const listLayoutCookie = "rwtxt-list-layout"
+
+// listLayout is the layout of ?layout, which is kept in a cookie for
+// the next lists, or else the one in the cookie
+func listLayout(w http.ResponseWriter, r *http.Request) string {
+	if layout, ok := r.URL.Query()["layout"]; ok {
+		if layout[0] != listCards {
+			layout[0] = listCompact
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:    listLayoutCookie,
+			Value:   layout[0],
+			Path:    "/",
+			Expires: time.Now().Add(365 * 24 * time.Hour),
+		})
+		return layout[0]
+	}
+	if cookie, err := r.Cookie(listLayoutCookie); err == nil && cookie.Value == listCards {
+		return listCards
+	}
+	return listCompact
+}
+
+// listPreviews returns what lists show of the files besides their names,
+// by their id. Onion services leave out images from other sites.
+func listPreviews(files []db.File) map[string]db.Preview {
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = f.ID
+	}
+	previews, err := fs.GetPreviews(ids)
+	if err != nil {
+		log.Error(err)
+	}
+	if torMode {
+		for id, p := range previews {
+			if !strings.HasPrefix(p.Image, "/") || strings.HasPrefix(p.Image, "//") {
+				p.Image = ""
+				previews[id] = p
+			}
+		}
+	}
+	return previews
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/search"
+)
+
+// ListNav leads from a page to the pages before and after it in the list
+// or the search results that it was opened from. The list is carried in
+// the from of the address of the page, like /domain/page?from=/domain/list
+type ListNav struct {
+	// Name says what the list is, like "all pages"
+	Name  string
+	Link  string
+	Index int
+	Count int
+	Prev  *db.File
+	Next  *db.File
+	// Domain is the domain of the pages of the list that do not say
+	Domain string
+}
+
+// PageLink links to a page of the list, keeping the list
+func (nav ListNav) PageLink(f db.File) string {
+	domain := f.Domain
+	if domain == "" {
+		domain = nav.Domain
+	}
+	return "/" + domain + "/" + f.ID + "?from=" + url.QueryEscape(nav.Link)
+}
+
+// listNav finds the page in the list that it was opened from, by making
+// the list again like the list or search page that it links back to.
+// There is none if the list is not one of those or the page is not in it.
+func (tr *TemplateRender) listNav(w http.ResponseWriter, r *http.Request, from string, page db.File) (nav *ListNav, err error) {
+	u, errParse := url.Parse(from)
+	if errParse != nil || u.Host != "" || u.Scheme != "" {
+		return
+	}
+	query := u.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	prefix := cleanSlugPath(query.Get("prefix"))
+	includeArchived := query.Get("archived") == "1"
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	var files []db.File
+	var domain, path, name string
+	link := url.Values{}
+	if includeArchived {
+		link.Set("archived", "1")
+	}
+	switch {
+	case u.Path == "/search" && query.Get("scope") == "mine" && q != "":
+		parsed := search.Parse(q)
+		end := traceDB(r.Context(), "SearchDomains")
+		files, err = fs.SearchDomains(parsed, searchedDomains(tr.DomainList, parsed), includeArchived)
+		end(err)
+		path = "/search"
+		link.Set("scope", "mine")
+		link.Set("q", q)
+		name = "results for '" + q + "' in your domains"
+	case len(parts) == 1 && parts[0] != "" && q != "":
+		domain = parts[0]
+		if !apiCanRead(w, r, domain) {
+			return
+		}
+		files, err = searchFiles(w, r, domain, search.Parse(q), prefix, includeArchived)
+		path = "/" + domain
+		link.Set("q", q)
+		name = "results for '" + q + "'"
+	case len(parts) == 2 && parts[1] == "list":
+		domain = parts[0]
+		if !apiCanRead(w, r, domain) {
+			return
+		}
+		files, err = listFiles(r, domain, prefix, includeArchived)
+		path = "/" + domain + "/list"
+		name = "all pages"
+	default:
+		return
+	}
+	if err != nil {
+		return
+	}
+	if prefix != "" {
+		link.Set("prefix", prefix)
+		name += " under " + prefix
+	}
+
+	files = readable(files, tr.EditorID)
+	nav = &ListNav{Name: name, Domain: domain}
+	for i := range files {
+		if files[i].ID != page.ID {
+			continue
+		}
+		nav.Index = i + 1
+		if i > 0 {
+			prev := files[i-1]
+			nav.Prev = &prev
+		}
+		if i+1 < len(files) {
+			next := files[i+1]
+			nav.Next = &next
+		}
+		break
+	}
+	if nav.Index == 0 {
+		return nil, nil
+	}
+	nav.Count = len(files)
+	nav.Link = path
+	if len(link) > 0 {
+		nav.Link += "?" + link.Encode()
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// litestreamReplica is where litestream copies the database to, like
+// "s3://bucket/rwtxt.db". The credentials are read by litestream from
+// its usual environment variables.
+var litestreamReplica string
+var litestreamCommand string
+
+var litestream struct {
+	sync.Mutex
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	stopping bool
+}
+
+// restoreLitestream gets the database from the replica, if there is a
+// replica and no database yet
+func restoreLitestream(database string) (err error) {
+	cmd := exec.Command(litestreamCommand, "restore", "-if-db-not-exists", "-if-replica-exists", "-o", database, litestreamReplica)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		err = errors.Wrapf(err, "could not restore %s from %s: %s", database, litestreamReplica, output)
+	}
+	return
+}
+
+// runLitestream keeps litestream copying every change of the database to
+// the replica, starting it again if it stops
+func runLitestream(database string) {
+	go stopLitestreamOnSignal()
+	wait := time.Second
+	for {
+		started := time.Now()
+		err := replicateLitestream(database)
+		litestream.Lock()
+		stopping := litestream.stopping
+		litestream.Unlock()
+		if stopping {
+			return
+		}
+		log.Errorf("litestream stopped: %v", err)
+		if time.Since(started) > time.Minute {
+			wait = time.Second
+		} else if wait < time.Minute {
+			wait *= 2
+		}
+		time.Sleep(wait)
+	}
+}
+
+func replicateLitestream(database string) (err error) {
+	cmd := exec.Command(litestreamCommand, "replicate", database, litestreamReplica)
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	cmd.Stderr = cmd.Stdout
+	litestream.Lock()
+	if litestream.stopping {
+		litestream.Unlock()
+		return
+	}
+	err = cmd.Start()
+	if err != nil {
+		litestream.Unlock()
+		return
+	}
+	exited := make(chan struct{})
+	litestream.cmd, litestream.exited = cmd, exited
+	litestream.Unlock()
+	logLitestream(output)
+	err = cmd.Wait()
+	close(exited)
+	return
+}
+
+func logLitestream(output io.Reader) {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		log.Debugf("litestream: %s", scanner.Text())
+	}
+}
+
+// stopLitestreamOnSignal lets litestream copy the last changes before
+// the server exits
+func stopLitestreamOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	litestream.Lock()
+	litestream.stopping = true
+	cmd, exited := litestream.cmd, litestream.exited
+	litestream.Unlock()
+	if cmd != nil {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			cmd.Process.Kill()
+		}
+		select {
+		case <-exited:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+		}
+	}
+	log.Flush()
+	os.Exit(0)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// maxMacroDepth is how deeply included pages may include other pages
+const maxMacroDepth = 5
+
+// maxListPages is how many pages {{listpages}} lists at most
+const maxListPages = 200
+
+var (
+	macro     = regexp.MustCompile(`\{\{\s*(include|listpages|date|csv|drawing)\b([^}]*)\}\}`)
+	macroArgs = regexp.MustCompile(`(?:(\w+)\s*=\s*)?"([^"]*)"`)
+)
+
+// macroContext is the page that macros are expanded in, and the pages
+// that include it, so that a page including itself is noticed. Included
+// sections are kept as the id of their page, # and their heading. The
+// query of the request sorts and pages the tables of CSV uploads, which
+// are counted in tables.
+type macroContext struct {
+	domain string
+	editor string
+	pages  []string
+	query  url.Values
+	tables *int
+}
+
+// expandMacros replaces the macros of the markdown, leaving code alone:
+//
+//	{{include "page"}} the text of another page of the domain
+//	{{include "page#Heading"}} the section of the page under the heading
+//	{{listpages tag="x" prefix="folder" limit="10"}} links to pages
+//	{{date}} or {{date "Jan 2, 2006"}} today's date
+//	{{csv "/uploads/sha256-..." rows="50"}} a table of a CSV upload
+//	{{drawing "sha256-..."}} a drawing, or {{drawing}} to start one
+func (c macroContext) expandMacros(markdown string) string {
+	if !strings.Contains(markdown, "{{") {
+		return markdown
+	}
+	lines := strings.Split(markdown, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || !strings.Contains(line, "{{") {
+			continue
+		}
+		// parts with odd indices are inline code
+		parts := strings.Split(line, "`")
+		for j := 0; j < len(parts); j += 2 {
+			parts[j] = macro.ReplaceAllStringFunc(parts[j], c.expandMacro)
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c macroContext) expandMacro(m string) string {
+	match := macro.FindStringSubmatch(m)
+	var args []string
+	named := make(map[string]string)
+	for _, arg := range macroArgs.FindAllStringSubmatch(match[2], -1) {
+		if arg[1] == "" {
+			args = append(args, arg[2])
+		} else {
+			named[strings.ToLower(arg[1])] = arg[2]
+		}
+	}
+	switch match[1] {
+	case "include":
+		if len(args) == 0 {
+			return macroError(`name the page, like {{include "page"}}`)
+		}
+		return c.include(args[0])
+	case "listpages":
+		return c.listPages(named)
+	case "csv":
+		if len(args) == 0 {
+			return macroError(`name the upload, like {{csv "/uploads/sha256-..."}}`)
+		}
+		return c.csvTable(args[0], named)
+	case "drawing":
+		if len(args) == 0 {
+			return c.drawing("")
+		}
+		return c.drawing(args[0])
+	default:
+		layout := "2006-01-02"
+		if len(args) > 0 {
+			layout = args[0]
+		}
+		return time.Now().Format(layout)
+	}
+}
+
+// macroError is shown instead of a macro that can not be expanded
+func macroError(message string) string {
+	return "*" + strings.NewReplacer("*", `\*`, "{{", "{ {").Replace(message) + "*"
+}
+
+// include returns the text of the page, or of the section of it after
+// #, with its own macros expanded. "#Heading" is a section of the page
+// itself.
+func (c macroContext) include(page string) string {
+	if len(c.pages) > maxMacroDepth {
+		return macroError("pages are included too deeply to include " + page)
+	}
+	name, heading := page, ""
+	if i := strings.Index(page, "#"); i >= 0 {
+		name, heading = page[:i], strings.TrimSpace(page[i+1:])
+	}
+	var f db.File
+	if name == "" && heading != "" {
+		// the page that the macro is in
+		files, err := fs.Get(strings.SplitN(c.pages[len(c.pages)-1], "#", 2)[0], c.domain)
+		if err != nil || len(files) != 1 {
+			return macroError("there is no page to include " + page + " from")
+		}
+		f = files[0]
+	} else {
+		files, err := fs.Get(name, c.domain)
+		if err != nil || len(files) != 1 {
+			return macroError("there is no page " + name + " to include")
+		}
+		f = files[0]
+		if fs.CheckAccess(f.ID, c.editor, false) != nil {
+			return macroError("you can not open " + name)
+		}
+		if hidden, _ := fs.IsHidden(f.ID); hidden {
+			return macroError("there is no page " + name + " to include")
+		}
+	}
+
+	// other sections of a page can be included in it, but not the page
+	// or section that is being expanded
+	key := f.ID
+	if heading != "" {
+		key += "#" + utils.Slugify(heading)
+	}
+	if containsString(c.pages, key) {
+		return macroError(page + " includes itself")
+	}
+	_, body := utils.FrontMatter(f.Data)
+	if heading != "" {
+		var found bool
+		if body, found = utils.Section(body, heading); !found {
+			return macroError("there is no section " + heading + " in " + name)
+		}
+	}
+	c.pages = append(c.pages[:len(c.pages):len(c.pages)], key)
+	return c.expandMacros(body)
+}
+
+// listPages returns links to the pages with the tag or in the folder
+func (c macroContext) listPages(named map[string]string) string {
+	limit := maxListPages
+	if named["limit"] != "" {
+		n, err := strconv.Atoi(named["limit"])
+		if err != nil || n < 1 {
+			return macroError("the limit of listpages is not a number")
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	prefix := strings.Trim(named["prefix"], "/")
+	tag := strings.ToLower(strings.TrimPrefix(named["tag"], "#"))
+
+	files, err := fs.GetAllWithPrefix(c.domain, prefix, false)
+	if err != nil {
+		return macroError(err.Error())
+	}
+	var list []string
+	for _, f := range readable(files, c.editor) {
+		if len(list) == limit {
+			break
+		}
+		if containsString(c.pages, f.ID) || (tag != "" && !containsString(utils.Tags(f.Data), tag)) {
+			continue
+		}
+		if hidden, _ := fs.IsHidden(f.ID); hidden {
+			continue
+		}
+		list = append(list, "- ["+strings.NewReplacer("[", `\[`, "]", `\]`).Replace(f.DisplayName())+"](/"+c.domain+"/"+slugOrID(f)+")")
+	}
+	if len(list) == 0 {
+		return macroError("no pages")
+	}
+	return "\n" + strings.Join(list, "\n") + "\n"
+}
+
+// slugOrID is the slug of the page, or its id if it has none
+func slugOrID(f db.File) string {
+	if f.Slug != "" {
+		return f.Slug
+	}
+	return f.ID
+}
@@ -3,22 +3,33 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/gorilla/websocket"
-	"github.com/schollz/documentsimilarity"
+	"github.com/pkg/errors"
 	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/embeddings"
+	"github.com/schollz/rwtxt/src/extract"
+	"github.com/schollz/rwtxt/src/llm"
+	"github.com/schollz/rwtxt/src/search"
 	"github.com/schollz/rwtxt/src/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -29,6 +40,27 @@ var viewEditTemplate *template.Template
 var mainTemplate *template.Template
 var loginTemplate *template.Template
 var listTemplate *template.Template
+var treeTemplate *template.Template
+var linkCheckTemplate *template.Template
+var embedTemplate *template.Template
+var exportTemplate *template.Template
+var clipTemplate *template.Template
+var reportTemplate *template.Template
+var moderationTemplate *template.Template
+var dropBoxTemplate *template.Template
+var formTemplate *template.Template
+var readerTemplate *template.Template
+var drawingTemplate *template.Template
+var tasksTemplate *template.Template
+var notfoundTemplate *template.Template
+var emptyTemplate *template.Template
+var searchTemplate *template.Template
+var editTemplate *template.Template
+var shareTemplate *template.Template
+var apiDocsTemplate *template.Template
+var settingsTemplate *template.Template
+var onThisDayTemplate *template.Template
+var queueTemplate *template.Template
 var fs *db.FileSystem
 
 type TemplateRender struct {
@@ -51,64 +83,125 @@ type TemplateRender struct {
 	Message           string
 	NumResults        int
 	Files             []db.File
+	Uploads           []db.Upload
 	MostActiveList    []db.File
+	PinnedList        []db.File
+	IsPinned          bool
+	IncludeArchived   bool
+	ArchivedLink      string
+	Duplicates        bool
+	DomainOptions     db.DomainOptions
+	Meta              *PageMeta
+	Robots            string
+	AllowIndexing     bool
+	ExportFormats     []string
+	Clip              ClipForm
+	Bookmarklet       template.URL
 	SimilarFiles      []db.File
+	SearchGroups      []SearchGroup
 	Search            string
 	DomainExists      bool
 	ShowCookieMessage bool
 	EditOnly          bool
+	EditVersion       int64
+	NoScript          bool
+	Shared            bool
+	ShareExpires      time.Time
+	ShareLinks        []ShareLink
+	APIDocs           *APIDocs
+	Prefix            string
+	Breadcrumbs       []Breadcrumb
+	Tree              *TreeNode
+	LinkReport        *LinkReport
+	Deadlines         *Deadlines
+	Summary           *db.Summary
+	SummaryOutdated   bool
+	CanSummarize      bool
+	Summaries         map[string]db.Summary
+	CanReport         bool
+	ReportReasons     []string
+	Reports           []db.Report
+	IsAdmin           bool
+	IsHidden          bool
+	Edits             []db.Edit
+	EditFilter        db.EditFilter
+	Maintenance       *db.Maintenance
+	Maintaining       bool
+	AccessLog         bool
+	Visits            *db.AccessSummary
+	EditorID          string
+	PushKey           string
+	Access            db.PageAccess
+	CanWrite          bool
+	CanChangeAccess   bool
+	Form              *Form
+	Drawing           string
+	DrawingID         string
+	Theme             string
+	Keys              []db.Key
+	NewKey            string
+	PageCount         int
+	Limits            Limits
+	Heatmap           *Heatmap
+	Memories          *Memories
+	ReadingList       *ReadingList
+	ReadingItem       db.ReadingItem
+	Queued            bool
+	ListNav           *ListNav
+	// ListLink is the from of the links to the pages of a list
+	ListLink string
+	// Homepage is the page that the domain opens with
+	Homepage   *db.File
+	IsHomepage bool
+	// ListLayout is how lists of pages are laid out, and LayoutLink
+	// shows the list in the other layout
+	ListLayout string
+	LayoutLink string
+	Previews   map[string]db.Preview
 }
 
 func init() {
-	var err error
-
-	b, err := Asset("assets/viewedit.html")
-	if err != nil {
-		panic(err)
-	}
-	viewEditTemplate = template.Must(template.New("viewedit").Parse(string(b)))
-	b, err = Asset("assets/header.html")
-	if err != nil {
-		panic(err)
-	}
-	viewEditTemplate = template.Must(viewEditTemplate.Parse(string(b)))
-	b, err = Asset("assets/footer.html")
-	if err != nil {
-		panic(err)
-	}
-	viewEditTemplate = template.Must(viewEditTemplate.Parse(string(b)))
-
-	b, err = Asset("assets/main.html")
+	viewEditTemplate = loadTemplate("viewedit", "assets/viewedit.html")
+	mainTemplate = loadTemplate("main", "assets/main.html")
+	listTemplate = loadTemplate("main", "assets/list.html")
+	treeTemplate = loadTemplate("tree", "assets/tree.html")
+	linkCheckTemplate = loadTemplate("linkcheck", "assets/linkcheck.html")
+	embedTemplate = loadTemplate("embed", "assets/embed.html")
+	clipTemplate = loadTemplate("clip", "assets/clip.html")
+	reportTemplate = loadTemplate("report", "assets/report.html")
+	moderationTemplate = loadTemplate("moderation", "assets/moderation.html")
+	dropBoxTemplate = loadTemplate("dropbox", "assets/dropbox.html")
+	formTemplate = loadTemplate("form", "assets/form.html")
+	readerTemplate = loadTemplate("reader", "assets/reader.html")
+	drawingTemplate = loadTemplate("drawing", "assets/drawing.html")
+	tasksTemplate = loadTemplate("tasks", "assets/tasks.html")
+	notfoundTemplate = loadTemplate("notfound", "assets/notfound.html")
+	emptyTemplate = loadTemplate("empty", "assets/empty.html")
+	searchTemplate = loadTemplate("search", "assets/search.html")
+	editTemplate = loadTemplate("edit", "assets/edit.html")
+	shareTemplate = loadTemplate("share", "assets/share.html")
+	apiDocsTemplate = loadTemplate("apidocs", "assets/apidocs.html")
+	settingsTemplate = loadTemplate("settings", "assets/settings.html")
+	onThisDayTemplate = loadTemplate("onthisday", "assets/onthisday.html")
+	queueTemplate = loadTemplate("queue", "assets/queue.html")
+	b, err := Asset("assets/export.html")
 	if err != nil {
 		panic(err)
 	}
-	mainTemplate = template.Must(template.New("main").Parse(string(b)))
-	b, err = Asset("assets/header.html")
-	if err != nil {
-		panic(err)
-	}
-	mainTemplate = template.Must(mainTemplate.Parse(string(b)))
-	b, err = Asset("assets/footer.html")
-	if err != nil {
-		panic(err)
-	}
-	mainTemplate = template.Must(mainTemplate.Parse(string(b)))
+	exportTemplate = template.Must(template.New("export").Parse(string(b)))
+}
 
-	b, err = Asset("assets/list.html")
-	if err != nil {
-		panic(err)
-	}
-	listTemplate = template.Must(template.New("main").Parse(string(b)))
-	b, err = Asset("assets/header.html")
-	if err != nil {
-		panic(err)
-	}
-	listTemplate = template.Must(listTemplate.Parse(string(b)))
-	b, err = Asset("assets/footer.html")
-	if err != nil {
-		panic(err)
+// loadTemplate parses the asset along with the shared header and footer
+func loadTemplate(name string, asset string) *template.Template {
+	t := template.New(name)
+	for _, assetName := range []string{asset, "assets/header.html", "assets/footer.html"} {
+		b, err := Asset(assetName)
+		if err != nil {
+			panic(err)
+		}
+		t = template.Must(t.Parse(string(b)))
 	}
-	listTemplate = template.Must(listTemplate.Parse(string(b)))
+	return t
 }
 
 var dbName string
@@ -119,6 +212,60 @@ func main() {
 	var debug = flag.Bool("debug", false, "debug mode")
 	var showVersion = flag.Bool("v", false, "show version")
 	var database = flag.String("db", "rwtxt.db", "name of the database")
+	flag.BoolVar(&allowIndexing, "allow-indexing", false, "let public domains opt in to search engine indexing")
+	var converterCommand = flag.String("converter", "", "command to export pages into more formats, like \"pandoc --sandbox -f markdown -t {format} -o {output}\"")
+	var converterFormats = flag.String("converter-formats", "", "formats offered by the converter, like \"odt,rtf,tex:latex\"")
+	var converterTimeout = flag.Duration("converter-timeout", 30*time.Second, "time limit for the converter")
+	var clamdAddress = flag.String("clamd", "", "scan uploads with clamd at its socket or host:port, like \"/run/clamav/clamd.ctl\", and reject malware")
+	var scanCommand = flag.String("scan-command", "", "scan uploads with a command that reads them from stdin and exits with 1 for malware, like \"clamdscan --no-summary -\"")
+	var scanTimeout = flag.Duration("scan-timeout", 30*time.Second, "time limit for scanning an upload")
+	var pluginsFolder = flag.String("plugins", "", "folder of plugin executables that hook into rendering, saving and signing in")
+	var pluginTimeout = flag.Duration("plugin-timeout", 5*time.Second, "time limit for each run of a plugin")
+	flag.DurationVar(&importInterval, "import-interval", time.Hour, "how often scheduled GitHub imports are imported again")
+	var syncFoldersFlag = flag.String("sync", "", "mirror domains to folders of .md files, like \"notes=/home/me/notes,work=dropbox:work\"")
+	flag.DurationVar(&saveInterval, "save-interval", time.Second, "how often the changes of someone typing are saved")
+	flag.DurationVar(&linkCheckInterval, "link-check-interval", 24*time.Hour, "how often external links are checked for being broken")
+	flag.DurationVar(&syncInterval, "sync-interval", time.Minute, "how often domains are synced with their folders")
+	flag.DurationVar(&wsIdleTimeout, "ws-idle-timeout", time.Hour, "close websockets that sent nothing for this long")
+	flag.IntVar(&maxWebsockets, "max-websockets", 1000, "number of websockets that may be open at once")
+	flag.IntVar(&maxWebsocketsPerIP, "max-websockets-per-ip", 20, "number of websockets that one address may have open at once")
+	var allowedOriginsFlag = flag.String("allowed-origins", "", "other sites that may open websockets, like \"https://notes.example.com\"")
+	flag.BoolVar(&metricsEnabled, "metrics", false, "serve the number of open websockets at /metrics")
+	flag.StringVar(&replicaKey, "replica-key", "", "key that mirrors use to download the database")
+	flag.StringVar(&mirrorOf, "mirror", "", "serve a read-only copy of another instance, like \"https://notes.example.com\"")
+	flag.StringVar(&mirrorKey, "mirror-key", "", "the -replica-key of the instance being mirrored")
+	flag.DurationVar(&mirrorInterval, "mirror-interval", time.Minute, "how often the mirrored instance is copied")
+	flag.StringVar(&litestreamReplica, "litestream", "", "continuously copy the database with litestream to a replica, like \"s3://bucket/rwtxt.db\", and restore it from there on start")
+	flag.StringVar(&litestreamCommand, "litestream-command", "litestream", "the litestream command")
+	flag.StringVar(&backupRemote, "backup", "", "upload encrypted backups to an rclone remote or folder, like \"s3:bucket/rwtxt\"")
+	flag.StringVar(&backupRecipient, "backup-recipient", "", "age public key or GPG key id that backups are encrypted for")
+	flag.StringVar(&backupIdentity, "backup-identity", "", "age key file that decrypts backups, for restoring them")
+	flag.DurationVar(&backupInterval, "backup-interval", backupInterval, "how often backups are made")
+	flag.IntVar(&backupKeep, "backup-keep", backupKeep, "how many backups are kept")
+	flag.StringVar(&pushContact, "push-contact", pushContact, "mailto: or https: address that push services can reach you at about notifications")
+	flag.IntVar(&maxImageSize, "max-image-size", 0, "shrink uploaded JPEG photos to this many pixels on their longest side, or never if 0")
+	flag.BoolVar(&keepOriginals, "keep-originals", false, "keep photos that -max-image-size shrinks, linked from the smaller ones")
+	var otlpEndpoint = flag.String("otlp", "", "send traces of requests to an OpenTelemetry collector with OTLP/HTTP, like \"http://localhost:4318\"")
+	var otlpSample = flag.Float64("otlp-sample", 1, "fraction of requests that are traced, from 0 to 1")
+	flag.DurationVar(&cacheTTL, "cache-ttl", cacheTTL, "how long the lists of pages on the main and list pages of a domain are kept, or 0 to query them every time")
+	flag.DurationVar(&keyExpiry, "key-expiry", keyExpiry, "sign out of domains that were not used for this long, or never if 0")
+	flag.DurationVar(&keyCleanupInterval, "key-cleanup-interval", keyCleanupInterval, "how often expired sign-ins are deleted")
+	flag.BoolVar(&accessLogEnabled, "access-log", false, "let domains opt in to counting the visits of their pages, by day and kind of client")
+	flag.DurationVar(&accessLogRetention, "access-log-retention", accessLogRetention, "how long the counted visits are kept, or forever if 0")
+	flag.DurationVar(&maintenanceInterval, "maintenance-interval", maintenanceInterval, "how often the database is checked for corruption and vacuumed, or never if 0")
+	flag.DurationVar(&emptyPageAge, "empty-page-age", emptyPageAge, "delete pages that have been empty for this long, or never if 0")
+	var embeddingsURL = flag.String("embeddings", "", "OpenAI-compatible API that finds similar pages by their embeddings, like \"http://localhost:11434/v1\"")
+	var embeddingsModel = flag.String("embeddings-model", "text-embedding-3-small", "model of the -embeddings API")
+	var embeddingsKey = flag.String("embeddings-key", "", "key of the -embeddings API, or else $EMBEDDINGS_API_KEY")
+	var llmURL = flag.String("llm", "", "OpenAI-compatible API whose chat model answers questions about domains at /api/v1/<domain>/ask")
+	var llmModel = flag.String("llm-model", "gpt-4o-mini", "model of the -llm API")
+	var llmKey = flag.String("llm-key", "", "key of the -llm API, or else $LLM_API_KEY")
+	var peerTubeFlag = flag.String("peertube", "", "PeerTube instances whose videos are shown as players, like \"framatube.org,tilvids.com\"")
+	flag.BoolVar(&torMode, "tor", false, "serve as an onion service: no hints of other sites, pages read without JavaScript and no fetching of linked sites")
+	flag.BoolVar(&trustProxy, "trust-proxy", false, "believe the X-Forwarded-For header of a proxy on the same machine for the addresses of clients, except with -tor")
+	flag.StringVar(&onionAddress, "onion", "", "the .onion address of the site, which Tor Browser is pointed to with an Onion-Location header")
+	flag.StringVar(&adminDomain, "admin", "", "domain whose members moderate reported pages of public domains at /moderation")
+	limits.addFlags()
 	flag.Parse()
 
 	if *showVersion {
@@ -136,39 +283,153 @@ func main() {
 		panic(err)
 	}
 	dbName = *database
+	setConverter(*converterCommand, *converterFormats, *converterTimeout)
+	setUploadScanner(*clamdAddress, *scanCommand, *scanTimeout)
+	setAllowedOrigins(*allowedOriginsFlag)
+	setPeerTubeInstances(*peerTubeFlag)
+	syncFolders, err = parseSyncFolders(*syncFoldersFlag)
+	if err != nil {
+		panic(err)
+	}
+	if err = loadPlugins(*pluginsFolder, *pluginTimeout); err != nil {
+		panic(err)
+	}
+	shutdownTracing, err := setupTracing(*otlpEndpoint, *otlpSample)
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
+	if *embeddingsURL != "" {
+		if *embeddingsKey == "" {
+			*embeddingsKey = os.Getenv("EMBEDDINGS_API_KEY")
+		}
+		embedder = embeddings.New(*embeddingsURL, *embeddingsModel, *embeddingsKey)
+	}
+	if *llmURL != "" {
+		if *llmKey == "" {
+			*llmKey = os.Getenv("LLM_API_KEY")
+		}
+		answerer = llm.New(*llmURL, *llmModel, *llmKey)
+		// the model is given up on before the request that asks it times
+		// out, so that the asker is told why there is no answer
+		answerer.HTTP.Timeout = limits.RequestTimeout * 9 / 10
+	}
 	defer log.Flush()
 
-	err = serve()
+	// "backups" lists the backups, "restore" restores one, "stats" shows
+	// how much the database keeps and "clone" copies a domain of another
+	// rwtxt, instead of serving
+	switch flag.Arg(0) {
+	case "backups":
+		err = printBackups()
+	case "restore":
+		err = runRestore(flag.Args()[1:])
+	case "stats":
+		err = runStats(flag.Args()[1:])
+	case "clone":
+		err = runClone(flag.Args()[1:])
+	default:
+		if backupRemote != "" && backupRecipient == "" {
+			err = errors.New("backups are encrypted, set -backup-recipient")
+		} else {
+			err = serve()
+		}
+	}
 	if err != nil {
 		log.Error(err)
 	}
 }
 
 type Payload struct {
-	ID        string `json:"id,omitempty"`
-	DomainKey string `json:"domain_key,omitempty"`
-	Domain    string `json:"domain,omitempty"`
-	Data      string `json:"data,omitempty"`
-	Slug      string `json:"slug,omitempty"`
-	Message   string `json:"message,omitempty"`
-	Success   bool   `json:"success"`
+	ID         string   `json:"id,omitempty"`
+	Domain     string   `json:"domain,omitempty"`
+	Data       string   `json:"data,omitempty"`
+	Slug       string   `json:"slug,omitempty"`
+	Message    string   `json:"message,omitempty"`
+	Success    bool     `json:"success"`
+	Target     string   `json:"target,omitempty"`
+	TargetSlug string   `json:"target_slug,omitempty"`
+	Similarity float64  `json:"similarity,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	Editors    []string `json:"editors,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	// Client and Seq number the changes of an editor, so that changes
+	// sent again after reconnecting are acknowledged but not saved twice
+	Client string `json:"client,omitempty"`
+	Seq    int64  `json:"seq,omitempty"`
+	// Patch is sent instead of Data to change the text sent before
+	Patch *Patch `json:"patch,omitempty"`
+	// Table is sent instead of Data to change a table of the page
+	Table *TableEdit `json:"table,omitempty"`
 }
 
+// TableEdit replaces the table of a page with the index, counting from
+// 0, with rows that were edited in a grid
+type TableEdit struct {
+	Index int        `json:"index"`
+	Rows  [][]string `json:"rows"`
+}
+
+// duplicateCheckInterval is how often saved content is checked
+// for duplicates while someone is typing
+const duplicateCheckInterval = 10 * time.Second
+
 var wsupgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	ReadBufferSize:    1024,
+	WriteBufferSize:   1024,
+	EnableCompression: true,
+	CheckOrigin:       checkOrigin,
 }
 
 func serve() (err error) {
+	if litestreamReplica != "" {
+		err = restoreLitestream(dbName)
+		if err != nil {
+			return
+		}
+	}
 	fs, err = db.New(dbName)
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	fs.SetLimits(limits.Limits)
+	fs.SetReservedSlugs(reservedSlug)
+	if litestreamReplica != "" {
+		err = fs.EnableWAL()
+		if err != nil {
+			return
+		}
+		go runLitestream(dbName)
+	}
 
+	if err = loadShareKey(); err != nil {
+		return
+	}
+	if readOnly() {
+		go runMirror()
+	} else {
+		if err = loadVAPIDKey(); err != nil {
+			return
+		}
+		go runBackgroundJobs()
+	}
+	log.Info("running on port 8152")
+	handlers := limitRequests(readOnlyRequests(http.HandlerFunc(handler)))
+	if tracing {
+		handlers = traceRequests(handlers)
+	}
+	server := &http.Server{
+		Addr:              ":8152",
+		Handler:           handlers,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	return server.ListenAndServe()
+}
+
+// runBackgroundJobs dumps the database and runs the jobs that change it
+func runBackgroundJobs() {
 	go func() {
 		lastDumped := time.Now()
 		for {
@@ -179,29 +440,43 @@ func serve() (err error) {
 			}
 			if time.Since(lastModified).Seconds() > 3 && time.Since(lastDumped).Seconds() > 10 {
 				log.Debug("dumping")
-				errDelete := fs.DeleteOldKeys()
-				if errDelete != nil {
-					log.Error(errDelete)
-				}
-				errDump := fs.DumpSQL()
-				if errDump != nil {
-					log.Error(errDump)
+				// litestream keeps a better copy than the dump
+				if litestreamReplica == "" {
+					errDump := fs.DumpSQL()
+					if errDump != nil {
+						log.Error(errDump)
+					}
 				}
 				lastDumped = time.Now()
 			}
 		}
 	}()
-	log.Info("running on port 8152")
-	http.HandleFunc("/", handler)
-	return http.ListenAndServe(":8152", nil)
+	if backupRemote != "" {
+		go runBackups()
+	}
+	go runScheduledImports()
+	go runUnfurler()
+	if !torMode {
+		go runLinkChecks()
+	}
+	go runReminders()
+	go runEmptyPageCleanup()
+	go runKeyCleanup()
+	go runMaintenance()
+	go runAccessLog()
+	if len(syncFolders) > 0 {
+		go runFolderSync(syncFolders)
+	}
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
 	t := time.Now()
 	err := handle(w, r)
 	if err != nil {
+		traceError(r.Context(), err)
 		log.Error(err)
 	}
+	logAccess(r)
 	log.Infof("%v %v %v %s", r.RemoteAddr, r.Method, r.URL.Path, time.Since(t))
 }
 
@@ -210,20 +485,189 @@ func (tr *TemplateRender) handleSearch(w http.ResponseWriter, r *http.Request, d
 	if !tr.SignedIn && !ispublic {
 		return tr.handleMain(w, r, "need to log in to search")
 	}
-	files, errGet := fs.Find(query, tr.Domain)
-	if errGet != nil {
-		return errGet
+	tr.Prefix = cleanSlugPath(r.URL.Query().Get("prefix"))
+	tr.IncludeArchived = r.URL.Query().Get("archived") == "1"
+	q := search.Parse(query)
+	files, err := searchFiles(w, r, domain, q, tr.Prefix, tr.IncludeArchived)
+	if err != nil {
+		return
+	}
+	tr.Summaries = summaries(files)
+	if tr.Prefix == "" {
+		// documents are searched in this domain, and shown with the
+		// pages linking to them that can be read
+		end := traceDB(r.Context(), "SearchUploads")
+		uploads, errGet := fs.SearchUploads(q, domain, tr.IncludeArchived)
+		end(errGet)
+		if errGet != nil {
+			return errGet
+		}
+		for i := range uploads {
+			uploads[i].Pages = readable(uploads[i].Pages, tr.EditorID)
+		}
+		tr.Uploads = uploads
 	}
 	return tr.handleList(w, r, query, files)
 }
 
+// searchFiles searches the domain, and the other domains that the query
+// names that could be searched from their own page, the latest first
+func searchFiles(w http.ResponseWriter, r *http.Request, domain string, q search.Query, prefix string, includeArchived bool) (files []db.File, err error) {
+	domains := q.Domains
+	if len(domains) == 0 {
+		domains = []string{domain}
+	}
+	for _, name := range domains {
+		if name != domain {
+			// other domains are searched only if they could be searched
+			// from their own page
+			_, public, errGet := fs.GetDomainFromName(name)
+			if signedIn, _, _, _, _ := isSignedIn(w, r, name); errGet != nil || (!public && !signedIn) {
+				continue
+			}
+		}
+		end := traceDB(r.Context(), "Search")
+		found, errGet := fs.Search(q, name, prefix, includeArchived)
+		end(errGet)
+		if errGet != nil {
+			return nil, errGet
+		}
+		for i := range found {
+			found[i].Domain = name
+		}
+		files = append(files, found...)
+	}
+	if len(domains) > 1 {
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Modified.After(files[j].Modified) })
+	}
+	return
+}
+
+// SearchGroup is the results of a search in one domain
+type SearchGroup struct {
+	Domain string
+	Files  []db.File
+}
+
+// handleSearchAll searches every domain that the user is signed in to at
+// /search?q=...&scope=mine, showing the results of each domain together
+func (tr *TemplateRender) handleSearchAll(w http.ResponseWriter, r *http.Request) (err error) {
+	query := r.URL.Query().Get("q")
+	tr.Domain = tr.DefaultDomain
+	if r.URL.Query().Get("scope") != "mine" {
+		http.Redirect(w, r, "/"+tr.DefaultDomain+"?q="+url.QueryEscape(query), 302)
+		return
+	}
+	if len(tr.DomainList) == 0 {
+		return tr.handleMain(w, r, "need to log in to search")
+	}
+	q := search.Parse(query)
+	domains := searchedDomains(tr.DomainList, q)
+	tr.IncludeArchived = r.URL.Query().Get("archived") == "1"
+	end := traceDB(r.Context(), "SearchDomains")
+	files, err := fs.SearchDomains(q, domains, tr.IncludeArchived)
+	end(err)
+	if err != nil {
+		return
+	}
+	files = readable(files, tr.EditorID)
+	for _, f := range files {
+		if len(tr.SearchGroups) == 0 || tr.SearchGroups[len(tr.SearchGroups)-1].Domain != f.Domain {
+			tr.SearchGroups = append(tr.SearchGroups, SearchGroup{Domain: f.Domain})
+		}
+		last := &tr.SearchGroups[len(tr.SearchGroups)-1]
+		last.Files = append(last.Files, f)
+	}
+	tr.Summaries = summaries(files)
+	tr.Title = query + " pages"
+	tr.Search = query
+	tr.NumResults = len(files)
+	tr.DomainList = domains
+	tr.ListLink = r.URL.RequestURI()
+
+	links := r.URL.Query()
+	if tr.IncludeArchived {
+		links.Del("archived")
+	} else {
+		links.Set("archived", "1")
+	}
+	tr.ArchivedLink = r.URL.Path + "?" + links.Encode()
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return searchTemplate.Execute(gz, tr)
+}
+
+// searchedDomains are the domains signed in to that the query searches,
+// all of them unless it names some
+func searchedDomains(domainList []string, q search.Query) (domains []string) {
+	for _, name := range domainList {
+		// the keys of the domains in the cookie were checked already
+		if len(q.Domains) == 0 || containsString(q.Domains, name) {
+			domains = append(domains, name)
+		}
+	}
+	return
+}
+
+// listFiles returns the pages of the domain beneath the prefix, without
+// their text
+func listFiles(r *http.Request, domain, prefix string, includeArchived bool) (files []db.File, err error) {
+	return cachedFiles(domain, "list "+strconv.FormatBool(includeArchived)+" "+prefix, func() (files []db.File, err error) {
+		end := traceDB(r.Context(), "GetAllWithPrefix")
+		files, err = fs.GetAllWithPrefix(domain, prefix, includeArchived)
+		end(err)
+		for i := range files {
+			files[i].Data = ""
+			files[i].DataHTML = template.HTML("")
+		}
+		return
+	})
+}
+
 func (tr *TemplateRender) handleList(w http.ResponseWriter, r *http.Request, query string, files []db.File) (err error) {
 	// show the list page
 	tr.Title = query + " pages"
+	files = readable(files, tr.EditorID)
 	tr.Files = files
-	tr.NumResults = len(files)
+	tr.NumResults = len(files) + len(tr.Uploads)
 	tr.Search = query
 	tr.RandomUUID = utils.UUID()
+	q := r.URL.Query()
+	q.Del("layout")
+	if !tr.Duplicates {
+		tr.ListLink = r.URL.Path
+		if len(q) > 0 {
+			tr.ListLink += "?" + q.Encode()
+		}
+		tr.ListLayout = listLayout(w, r)
+		tr.Previews = listPreviews(files)
+	}
+
+	// link to the same listing in the other layout
+	layout := r.URL.Query()
+	if tr.ListLayout == listCards {
+		layout.Set("layout", "compact")
+	} else {
+		layout.Set("layout", listCards)
+	}
+	tr.LayoutLink = r.URL.Path + "?" + layout.Encode()
+
+	// link to the same listing with archived pages toggled
+	if tr.IncludeArchived {
+		q.Del("archived")
+	} else {
+		q.Set("archived", "1")
+	}
+	tr.ArchivedLink = r.URL.Path + "?" + q.Encode()
+	if tr.Prefix != "" {
+		tr.Breadcrumbs = append(breadcrumbs(tr.Domain, tr.Prefix), Breadcrumb{
+			Name: tr.Prefix[strings.LastIndex(tr.Prefix, "/")+1:],
+			Link: treeLink(tr.Domain, tr.Prefix),
+		})
+	}
 
 	w.Header().Set("Content-Encoding", "gzip")
 	w.Header().Set("Content-Type", "text/html")
@@ -313,23 +757,8 @@ func (tr *TemplateRender) handleMain(w http.ResponseWriter, r *http.Request, mes
 		http.SetCookie(w, &cookie)
 	}
 
-	// create a page to write to
-	newFile := db.File{
-		ID:       utils.UUID(),
-		Created:  time.Now(),
-		Domain:   tr.Domain,
-		Modified: time.Now(),
-	}
-	defer func() {
-		go func() {
-			// premediate the page
-			err := fs.Save(newFile)
-			if err != nil {
-				log.Debug(err)
-			}
-		}()
-	}()
-	tr.RandomUUID = newFile.ID
+	// a page to write to, which is saved once something is written
+	tr.RandomUUID = utils.UUID()
 
 	// delete this
 	_, ispublic, domainErr := fs.GetDomainFromName(tr.Domain)
@@ -341,12 +770,49 @@ func (tr *TemplateRender) handleMain(w http.ResponseWriter, r *http.Request, mes
 	tr.SignedIn = signedin
 	tr.DomainIsPrivate = !ispublic && tr.Domain != "public"
 	tr.DomainExists = domainErr == nil
-	tr.Files, err = fs.GetTopX(tr.Domain, 10)
+	if tr.DomainExists {
+		tr.DomainOptions, _ = fs.GetDomainOptions(tr.Domain)
+	}
+	if tr.SignedIn {
+		tr.Bookmarklet = bookmarklet(r, tr.Domain)
+	}
+	tr.Files, err = cachedFiles(tr.Domain, "recent", func() (files []db.File, err error) {
+		end := traceDB(r.Context(), "GetTopX")
+		files, err = fs.GetTopX(tr.Domain, 10)
+		end(err)
+		return
+	})
 	if err != nil {
 		log.Debug(err)
 	}
+	tr.Files = readable(tr.Files, tr.EditorID)
 
-	tr.MostActiveList, _ = fs.GetTopXMostViews(tr.Domain, 10)
+	tr.MostActiveList, _ = cachedFiles(tr.Domain, "views", func() (files []db.File, err error) {
+		end := traceDB(r.Context(), "GetTopXMostViews")
+		files, err = fs.GetTopXMostViews(tr.Domain, 10)
+		end(err)
+		return
+	})
+	tr.MostActiveList = readable(tr.MostActiveList, tr.EditorID)
+	if tr.DomainExists && tr.Domain != "public" && (ispublic || tr.SignedIn) {
+		tr.Memories, err = onThisDay(tr.Domain, tr.EditorID, time.Now(), maxOnThisDay)
+		if err != nil {
+			log.Debug(err)
+		}
+	}
+	if tr.SignedIn && tr.Domain != "public" {
+		tr.PinnedList, err = fs.GetPinned(tr.Domain)
+		if err != nil {
+			log.Debug(err)
+		}
+		tr.PinnedList = readable(tr.PinnedList, tr.EditorID)
+		end := traceDB(r.Context(), "GetEditsByDay")
+		tr.Heatmap, err = editsHeatmap(tr.Domain)
+		end(err)
+		if err != nil {
+			log.Debug(err)
+		}
+	}
 	tr.Title = "rwtxt"
 	tr.Message = message
 	tr.DomainValue = template.HTMLAttr(`value="` + tr.Domain + `"`)
@@ -403,6 +869,9 @@ func (tr *TemplateRender) handleLogin(w http.ResponseWriter, r *http.Request) (e
 		}
 	}
 	tr.DomainKey, err = fs.SetKey(tr.Domain, password)
+	if err != nil && pluginAuth(tr.Domain, password) {
+		tr.DomainKey, err = fs.NewKey(tr.Domain)
+	}
 	if err != nil {
 		tr.Domain = "public"
 		return tr.handleMain(w, r, err.Error())
@@ -416,81 +885,220 @@ func (tr *TemplateRender) handleLogin(w http.ResponseWriter, r *http.Request) (e
 	return nil
 }
 
-func (tr *TemplateRender) handleLoginUpdate(w http.ResponseWriter, r *http.Request) (err error) {
-	tr.DomainKey = strings.TrimSpace(strings.ToLower(r.FormValue("domain_key")))
-	tr.Domain = strings.TrimSpace(strings.ToLower(r.FormValue("domain")))
-	password := strings.TrimSpace(r.FormValue("password"))
-	isPublic := strings.TrimSpace(r.FormValue("ispublic")) == "on"
-	if tr.Domain == "public" || tr.Domain == "" {
-		tr.Domain = "public"
-		return tr.handleMain(w, r, "cannot modify public")
-	}
-
-	// check that the key is valid
-	domainFound, err := fs.CheckKey(tr.DomainKey)
-	if err != nil || tr.Domain != domainFound {
-		if err != nil {
-			log.Debug(err)
-		}
-		return tr.handleMain(w, r, err.Error())
+func (tr *TemplateRender) handleWebsocket(w http.ResponseWriter, r *http.Request) (err error) {
+	// handle websockets on this page
+	ip := clientIP(r)
+	if !openConnection(ip) {
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
 	}
+	defer closeConnection(ip)
 
-	err = fs.UpdateDomain(tr.Domain, password, isPublic)
-	message := "settings updated"
-	if password != "" {
-		message = "password updated"
+	// the websocket is for one domain, and only pages of private
+	// domains that the cookie has a key for can be opened
+	domain := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	if domain == "" {
+		domain = "public"
 	}
-	if err != nil {
-		message = err.Error()
+	signedIn, _, _, _, _ := isSignedIn(w, r, domain)
+	_, ispublic, _ := fs.GetDomainFromName(domain)
+	domainValidated := signedIn || domain == "public"
+	if !domainValidated && !ispublic {
+		http.Error(w, "need to log in to "+domain, http.StatusForbidden)
+		return
 	}
-	return tr.handleMain(w, r, message)
-}
 
-func (tr *TemplateRender) handleWebsocket(w http.ResponseWriter, r *http.Request) (err error) {
-	// handle websockets on this page
-	c, errUpgrade := wsupgrader.Upgrade(w, r, nil)
+	// a mirror shows pages but does not save them, and nobody saves
+	// from an address that a moderator blocked
+	canSave := domainValidated && !readOnly() && !isBlocked(r)
+
+	// the browser gets its anonymous editor id with the upgrade if it
+	// has none yet
+	c, errUpgrade := wsupgrader.Upgrade(w, r, http.Header{"Set-Cookie": w.Header()["Set-Cookie"]})
 	if errUpgrade != nil {
 		return errUpgrade
 	}
 	defer c.Close()
-	domainChecked := false
-	domainValidated := false
+	c.SetReadLimit(limits.MaxRequestBody)
+	session := &editSession{conn: c, ip: ip, editor: tr.EditorID}
+	defer session.leave()
+	defer session.flush()
+	defer session.keepAlive()()
 	var editFile db.File
 	var p Payload
+	var lastDuplicateCheck time.Time
+	warnedDuplicates := make(map[string]bool)
+	// each message is a trace of its own, linked to the websocket
+	span := trace.SpanFromContext(context.Background())
+	defer func() { span.End() }()
 	for {
+		span.End()
+		p = Payload{}
 		err := c.ReadJSON(&p)
 		if err != nil {
 			log.Debug("read:", err)
+			session.flush()
 			if editFile.ID != "" {
 				log.Debugf("saving editing of /%s/%s", editFile.Domain, editFile.ID)
-				if editFile.Domain != "public" {
-					err = addSimilar(editFile.Domain, editFile.ID)
-					if err != nil {
-						log.Error(err)
-					}
+				err = addSimilar(editFile.Domain, editFile.ID)
+				if err != nil {
+					log.Error(err)
 				}
 			}
 			break
 		}
 		// log.Debugf("recv: %v", p)
-
-		if !domainChecked {
-			domainChecked = true
-			if p.Domain == "public" {
-				domainValidated = true
-			} else {
-				_, keyErr := fs.CheckKey(p.DomainKey)
-				if keyErr == nil {
-					domainValidated = true
+		session.touch()
+		if p.Domain == "" {
+			p.Domain = domain
+		}
+		_, span = tracer.Start(context.Background(), "ws "+websocketMessage(p),
+			trace.WithNewRoot(),
+			trace.WithLinks(trace.LinkFromContext(r.Context())),
+			trace.WithAttributes(attribute.String("rwtxt.domain", p.Domain), attribute.String("rwtxt.page", p.ID)),
+		)
+
+		if p.Domain != domain {
+			log.Debugf("websocket for %s got a message for %s", domain, p.Domain)
+			err = session.send(Payload{
+				Message: "not saving",
+				Seq:     p.Seq,
+			})
+			if err != nil {
+				log.Debug("write:", err)
+				break
+			}
+		} else if p.Message == "watch" && p.ID != "" {
+			// someone opened the page, tell them who is editing it
+			session.watch(p.ID, p.Name)
+			broadcastEditors(p.ID)
+		} else if errAccess := pageAccess(p, session.editor); errAccess != nil {
+			err = session.send(Payload{
+				ID:      p.ID,
+				Message: "not saving",
+				Data:    errAccess.Error(),
+				Seq:     p.Seq,
+			})
+			if err != nil {
+				log.Debug("write:", err)
+				break
+			}
+		} else if p.Message == "expand" && p.ID != "" && canSave {
+			// fill in a slash command with what is on the server
+			expansion, errExpand := expandCommand(p.Domain, session.editor, session.currentText(p.ID, p.Domain), p.Data)
+			response := Payload{
+				ID:      p.ID,
+				Message: "expansion",
+				Data:    expansion,
+				Success: errExpand == nil,
+			}
+			if errExpand != nil {
+				response.Data = errExpand.Error()
+			}
+			err = session.send(response)
+			if err != nil {
+				log.Debug("write:", err)
+				break
+			}
+		} else if p.Message == "merge" && p.ID != "" && p.Target != "" && canSave {
+			// merge this page into the duplicate page
+			session.flush()
+			errMerge := fs.Merge(p.ID, p.Target, p.Domain)
+			if errMerge == nil {
+				forgetDomain(p.Domain)
+			}
+			response := Payload{
+				ID:      p.ID,
+				Target:  p.Target,
+				Message: "merged",
+				Success: errMerge == nil,
+			}
+			if errMerge != nil {
+				log.Error(errMerge)
+				response.Data = errMerge.Error()
+			}
+			editFile = db.File{}
+			err = session.send(response)
+			if err != nil {
+				log.Debug("write:", err)
+				break
+			}
+		} else if p.ID != "" && canSave {
+			// save it
+			session.watch(p.ID, p.Name)
+			if savedChanges.saved(p.Client, p.Seq) {
+				err = session.send(Payload{
+					ID:      p.ID,
+					Message: "ack",
+					Seq:     p.Seq,
+				})
+				if err != nil {
+					log.Debug("write:", err)
+					break
 				}
+				continue
 			}
-		}
-
-		// save it
-		if p.ID != "" && domainValidated {
-			if p.Domain == "" {
-				p.Domain = "public"
+			options, _ := fs.GetDomainOptions(p.Domain)
+			if holder, locked := session.edit(options.LockEditing); locked {
+				// someone else is editing the page, keep their changes
+				err = session.send(Payload{
+					ID:      p.ID,
+					Message: "locked",
+					Data:    holder,
+					Seq:     p.Seq,
+				})
+				if err != nil {
+					log.Debug("write:", err)
+					break
+				}
+				continue
 			}
+			if p.Table != nil {
+				var errTable error
+				p.Data, errTable = utils.ReplaceTable(session.currentText(p.ID, p.Domain), p.Table.Index, p.Table.Rows)
+				if errTable != nil {
+					err = session.send(Payload{
+						ID:      p.ID,
+						Message: "not saving",
+						Data:    errTable.Error(),
+						Seq:     p.Seq,
+					})
+					if err != nil {
+						log.Debug("write:", err)
+						break
+					}
+					continue
+				}
+				p.Patch = nil
+				// the editor gets the text with the table, to change it further
+				err = session.send(Payload{
+					ID:      p.ID,
+					Message: "table",
+					Data:    p.Data,
+				})
+				if err != nil {
+					log.Debug("write:", err)
+					break
+				}
+			}
+			if p.Patch != nil {
+				var ok bool
+				p.Data, ok = p.Patch.apply(session.text)
+				if session.textID != p.ID || !ok {
+					// the patch is for text this connection does not have
+					err = session.send(Payload{
+						ID:      p.ID,
+						Message: "resync",
+						Seq:     p.Seq,
+					})
+					if err != nil {
+						log.Debug("write:", err)
+						break
+					}
+					continue
+				}
+			}
+			session.text, session.textID = p.Data, p.ID
 			data := strings.TrimSpace(p.Data)
 			if data == introText {
 				data = ""
@@ -502,26 +1110,36 @@ func (tr *TemplateRender) handleWebsocket(w http.ResponseWriter, r *http.Request
 				Created: time.Now(),
 				Domain:  p.Domain,
 			}
-			err = fs.Save(editFile)
-			if err != nil {
-				log.Error(err)
-			}
-			fs, _ := fs.Get(p.Slug, p.Domain)
-
-			err = c.WriteJSON(Payload{
-				ID:      p.ID,
-				Slug:    p.Slug,
-				Message: "unique_slug",
-				Success: len(fs) < 2,
-			})
-			if err != nil {
-				log.Debug("write:", err)
-				break
+			editFile.Editor, editFile.EditorIP = session.editor, session.ip
+			session.queueSave(editFile, p)
+
+			// warn about nearly identical pages, at most once per page
+			if time.Since(lastDuplicateCheck) > duplicateCheckInterval {
+				lastDuplicateCheck = time.Now()
+				duplicate, similarity, found, errDuplicate := findDuplicate(editFile.Domain, editFile.ID, editFile.Data)
+				if errDuplicate != nil {
+					log.Debug(errDuplicate)
+				}
+				if found && !warnedDuplicates[duplicate.ID] {
+					warnedDuplicates[duplicate.ID] = true
+					err = session.send(Payload{
+						ID:         p.ID,
+						Message:    "duplicate",
+						Target:     duplicate.ID,
+						TargetSlug: duplicate.Slug,
+						Similarity: similarity,
+					})
+					if err != nil {
+						log.Debug("write:", err)
+						break
+					}
+				}
 			}
 		} else {
 			log.Debug("not saving")
-			err = c.WriteJSON(Payload{
+			err = session.send(Payload{
 				Message: "not saving",
+				Seq:     p.Seq,
 			})
 			if err != nil {
 				log.Debug("write:", err)
@@ -555,58 +1173,134 @@ func handleStatic(w http.ResponseWriter, r *http.Request) (err error) {
 	return
 }
 
+// renderMarkdown renders the markdown of a page of the domain, with the
+// extensions and video players the domain uses
+func renderMarkdown(domain, markdown string) template.HTML {
+	options, _ := fs.GetDomainOptions(domain)
+	videos := options.Videos
+	if torMode {
+		// players load the videos from their sites
+		videos = db.VideosLink
+	}
+	rendered := embedVideos(string(utils.RenderMarkdown(markdown, options.StrictMarkdown)), videos)
+	return template.HTML(decorateLinks(rendered, externalRel(domain, options), options.MarkExternalLinks))
+}
+
+// renderPage renders a page of the domain for the editor, without its
+// front matter, changed by the render plugins and the scripts of the
+// domain, with its macros expanded and its citations referenced.
+// The query of the request sorts and pages the tables of CSV uploads.
+func renderPage(domain, editor string, f db.File, query url.Values) template.HTML {
+	_, body := utils.FrontMatter(f.Data)
+	body = pluginRender(domain, f.ID, body)
+	body = runScripts(scriptOnView, domain, f, body)
+	body = macroContext{domain: domain, editor: editor, pages: []string{f.ID}, query: query, tables: new(int)}.expandMacros(body)
+	return renderMarkdown(domain, cite(domain, editor, body))
+}
+
 func (tr *TemplateRender) handleViewEdit(w http.ResponseWriter, r *http.Request) (err error) {
 	// handle new page
 	// get edit url parameter
 	log.Debugf("loading %s", tr.Page)
+	end := traceDB(r.Context(), "Exists")
 	havePage, err := fs.Exists(tr.Page, tr.Domain)
+	end(err)
 	if err != nil {
 		return
 	}
-	initialMarkdown := ""
 	var f db.File
 
 	// check if domain is public and exists
 	_, ispublic, errGet := fs.GetDomainFromName(tr.Domain)
+	if havePage && !tr.SignedIn && tr.Domain != "public" {
+		// anyone can add to a drop box
+		if f, ok := dropBoxFile(tr.Domain, tr.Page); ok {
+			return tr.handleDropBox(w, r, f)
+		}
+		// and anyone can answer a form
+		if f, form, ok := formFile(tr.Domain, tr.Page); ok {
+			return tr.handleForm(w, r, f, form)
+		}
+	}
 	if errGet == nil && !tr.SignedIn && !ispublic {
 		return tr.handleMain(w, r, "domain is not public, sign in first")
 	}
 
 	if havePage {
 		var files []db.File
+		end = traceDB(r.Context(), "Get")
 		files, err = fs.Get(tr.Page, tr.Domain)
+		end(err)
 		if err != nil {
 			log.Error(err)
 			return tr.handleMain(w, r, err.Error())
 		}
 		if len(files) > 1 {
+			// several pages share this slug, offer to merge or rename them
+			tr.Duplicates = true
 			return tr.handleList(w, r, tr.Page, files)
 		} else {
 			f = files[0]
 		}
+		tr.IsAdmin = isAdmin(w, r)
+		tr.IsHidden, err = fs.IsHidden(f.ID)
+		if err != nil {
+			log.Error(err)
+		}
+		if tr.IsHidden && !tr.IsAdmin {
+			return tr.handleMain(w, r, "this page was hidden by a moderator")
+		}
+		tr.Access, err = fs.GetPageAccess(f.ID)
+		if err != nil {
+			return
+		}
+		if !tr.Access.CanRead(tr.EditorID) {
+			return tr.handleMain(w, r, db.ErrNoAccess.Error())
+		}
+		tr.CanWrite = tr.Access.CanWrite(tr.EditorID)
+		tr.CanChangeAccess = canChangeAccess(tr)
 		tr.SimilarFiles, err = fs.GetSimilar(f.ID)
 		if err != nil {
 			log.Error(err)
 		}
+		tr.SimilarFiles = readable(tr.SimilarFiles, tr.EditorID)
+		if tr.SignedIn && tr.Domain != "public" {
+			tr.IsPinned, err = fs.IsPinned(tr.Domain, f.ID)
+			if err != nil {
+				log.Error(err)
+			}
+			tr.ReadingItem, tr.Queued, err = fs.GetReadingItem(tr.DomainKey, f.ID)
+			if err != nil {
+				log.Error(err)
+			}
+		}
 	} else {
-		uuid := utils.UUID()
+		if isBlocked(r) {
+			return tr.handleMain(w, r, "you can not make new pages")
+		}
+		if errGet != nil {
+			return tr.handleMain(w, r, "domain does not exist")
+		}
+		if r.URL.Query().Get("create") == "" {
+			// nothing is made until asked for, so that following a
+			// link does not leave an empty page behind
+			suggestions, errSuggest := suggestPages(tr.Domain, tr.Page, tr.EditorID)
+			if errSuggest != nil {
+				log.Error(errSuggest)
+			}
+			return tr.handleNotFound(w, r, suggestions)
+		}
+		// the page is saved when something is written in it
 		f = db.File{
-			ID:       uuid,
+			ID:       utils.UUID(),
+			Slug:     tr.Page,
 			Created:  time.Now(),
 			Domain:   tr.Domain,
 			Modified: time.Now(),
 		}
-		f.Slug = tr.Page
-		f.Data = ""
-		err = fs.Save(f)
-		if err != nil {
-			return tr.handleMain(w, r, "domain does not exist")
-		}
-		log.Debugf("saved: %+v", f)
-		http.Redirect(w, r, "/"+tr.Domain+"/"+tr.Page, 302)
-		return
+		tr.CanWrite = true
 	}
-	initialMarkdown += "\n\n" + f.Data
+	tr.Form = parseForm(f.Data)
 	// if f.Data == "" {
 	// 	f.Data = introText
 	// }
@@ -618,12 +1312,43 @@ func (tr *TemplateRender) handleViewEdit(w http.ResponseWriter, r *http.Request)
 		}
 	}()
 
-	tr.Title = f.Slug
-	tr.Rendered = utils.RenderMarkdownToHTML(initialMarkdown)
+	tr.Title = f.DisplayName()
+	if (ispublic || tr.Domain == "public") && !torMode {
+		tr.Meta = newPageMeta(r, tr.Domain, f)
+	}
+	tr.CanReport = canReport(tr.Domain)
+	tr.DomainIsPrivate = !ispublic && tr.Domain != "public"
+	if tr.IsAdmin && (ispublic || tr.Domain == "public") {
+		tr.Edits, err = fs.GetFileEdits(f.ID)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	tr.Breadcrumbs = breadcrumbs(tr.Domain, f.Slug)
+	if from := r.URL.Query().Get("from"); from != "" {
+		tr.ListNav, err = tr.listNav(w, r, from, f)
+		if err != nil {
+			log.Error(err)
+			err = nil
+		}
+	}
+	_, span := tracer.Start(r.Context(), "render")
+	tr.Rendered, tr.Rows = viewRendering(tr.Domain, tr.EditorID, f, r.URL.Query())
+	span.End()
+	if options, _ := fs.GetDomainOptions(tr.Domain); options.UnfurlLinks && !torMode {
+		tr.Rendered = unfurlLinks(tr.Rendered, externalRel(tr.Domain, options))
+	}
 	tr.File = f
 	tr.IntroText = template.JS(introText)
-	tr.Rows = len(strings.Split(string(utils.RenderMarkdownToHTML(initialMarkdown)), "\n")) + 1
+	if isLong(f) {
+		tr.CanSummarize = tr.CanWrite && (tr.SignedIn || tr.Domain == "public")
+		if summary, ok := summaries([]db.File{f})[f.ID]; ok {
+			tr.Summary = &summary
+			tr.SummaryOutdated = summary.Hash != textHash(f.Data)
+		}
+	}
 	tr.EditOnly = strings.TrimSpace(f.Data) == ""
+	tr.PushKey = vapidPublicKey()
 
 	w.Header().Set("Content-Encoding", "gzip")
 	w.Header().Set("Content-Type", "text/html")
@@ -631,35 +1356,115 @@ func (tr *TemplateRender) handleViewEdit(w http.ResponseWriter, r *http.Request)
 	defer gz.Close()
 	log.Debug(strings.TrimSpace(f.Data))
 
+	tr.NoScript = scriptless(tr, r)
+	if tr.NoScript {
+		w.Header().Set("Content-Security-Policy", torScriptlessContentSecurity)
+	}
+	if r.URL.Query().Get("view") == "reader" || tr.NoScript {
+		// just the page, to read or print it
+		return readerTemplate.Execute(gz, tr)
+	}
 	return viewEditTemplate.Execute(gz, tr)
 
 }
 
+// uploadCacheControl lets browsers keep uploads for good, since they are
+// named by the hash of what is in them and so never change
+const uploadCacheControl = "public, max-age=31536000, immutable"
+
+// compressedFormats are the extensions of files that are compressed
+// already, which are stored and sent as they are instead of gzipped
+var compressedFormats = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".avif": true, ".heic": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".7z": true, ".rar": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true,
+	".mp4": true, ".webm": true, ".mov": true, ".mkv": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".oga": true, ".opus": true, ".weba": true, ".flac": true,
+}
+
+// isCompressedFormat is whether the file is compressed already
+func isCompressedFormat(name string) bool {
+	return compressedFormats[strings.ToLower(path.Ext(name))]
+}
+
+// acceptsGzip is whether the client can uncompress gzipped responses
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(encoding, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func (tr *TemplateRender) handleUploads(w http.ResponseWriter, r *http.Request, id string) (err error) {
 	log.Debug("getting ", id)
-	name, data, _, err := fs.GetBlob(id)
+	etag := `"` + id + `"`
+	// what share links open is not kept, since the links expire
+	if r.URL.Query().Get("sig") == "" {
+		w.Header().Set("Cache-Control", uploadCacheControl)
+	}
+	w.Header().Set("ETag", etag)
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		// an id that was never uploaded is not cached, but the upload is
+		// not read to say so
+		exists, errExists := fs.BlobExists(id)
+		if errExists != nil || !exists {
+			w.Header().Del("ETag")
+			w.Header().Del("Cache-Control")
+			http.Error(w, "no such upload", http.StatusNotFound)
+			return errExists
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	name, data, compressed, _, err := fs.GetBlob(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Vary", "Accept-Encoding")
-	w.Header().Set("Cache-Control", "public, max-age=7776000")
-	w.Header().Set("Content-Encoding", "gzip")
-	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Type", contentType(name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("Content-Disposition",
 		`attachment; filename="`+name+`"`,
 	)
-	w.Write(data)
-	return
+	if audioType := utils.AudioType(name); audioType != "" {
+		w.Header().Set("Content-Type", audioType)
+		w.Header().Set("Content-Disposition", `inline; filename="`+name+`"`)
+	} else if compressed && !isCompressedFormat(name) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(data)
+			return
+		}
+	}
+	return serveBlob(w, r, name, data, compressed)
 }
 
 func (tr *TemplateRender) handleUpload(w http.ResponseWriter, r *http.Request) (err error) {
-	domain := r.URL.Query().Get("domain")
-	if !tr.SignedIn || domain == "public" {
+	// the upload is indexed with the domain it is for, which needs to be
+	// signed in to rather than the domain of the path
+	domain := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	signedIn, _, _, _, _ := isSignedIn(w, r, domain)
+	if !signedIn || domain == "public" {
 		http.Error(w, "need to be logged in", http.StatusForbidden)
 		return
 	}
+	if isBlocked(r) {
+		http.Error(w, "blocked", http.StatusForbidden)
+		return
+	}
 
 	file, info, err := r.FormFile("file")
 	if err != nil {
@@ -668,42 +1473,117 @@ func (tr *TemplateRender) handleUpload(w http.ResponseWriter, r *http.Request) (
 	}
 	defer file.Close()
 
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	threat, err := scanUpload(info.Filename, data)
+	if err != nil {
+		http.Error(w, "could not scan the upload, try again later", http.StatusServiceUnavailable)
+		return nil
+	} else if threat != "" {
+		http.Error(w, info.Filename+" was rejected as malware: "+threat, http.StatusUnprocessableEntity)
+		return
+	}
+	data, original := prepareImage(data)
+	link, err := saveUpload(info.Filename, bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if original != nil {
+		// the editor links the photo to the one it was shrunk from
+		originalLink, errOriginal := saveUpload(info.Filename, bytes.NewReader(original))
+		if errOriginal != nil {
+			http.Error(w, errOriginal.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Original-Location", originalLink)
+	}
+	if extract.CanRead(info.Filename) {
+		go indexUpload(domain, link, info.Filename, data)
+	}
+
+	w.Header().Set("Location", link)
+	_, err = w.Write([]byte("ok"))
+	return
+}
+
+// serveBlob sends an upload as it was uploaded, uncompressing it if it
+// was gzipped, and answers requests for parts of it, which players of
+// audio make
+func serveBlob(w http.ResponseWriter, r *http.Request, name string, data []byte, compressed bool) (err error) {
+	if compressed {
+		if data, err = gunzip(data); err != nil {
+			return
+		}
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	return
+}
+
+// gunzip uncompresses the data of a blob
+func gunzip(compressed []byte) (data []byte, err error) {
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// saveUpload saves a file as a blob named by its hash and returns
+// the link to it
+func saveUpload(name string, file io.ReadSeeker) (link string, err error) {
 	h := sha256.New()
 	if _, err = io.Copy(h, file); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	id := fmt.Sprintf("sha256-%x", h.Sum(nil))
 
-	// copy file to buffer
+	// copy file to buffer, gzipped unless it is compressed already
 	file.Seek(0, io.SeekStart)
 	var fileData bytes.Buffer
-	gzipWriter := gzip.NewWriter(&fileData)
-	_, err = io.Copy(gzipWriter, file)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	compressed := !isCompressedFormat(name)
+	if compressed {
+		gzipWriter := gzip.NewWriter(&fileData)
+		_, err = io.Copy(gzipWriter, file)
+		if err != nil {
+			return
+		}
+		gzipWriter.Close()
+	} else if _, err = io.Copy(&fileData, file); err != nil {
 		return
 	}
-	gzipWriter.Close()
 
 	// save file
-	err = fs.SaveBlob(id, info.Filename, fileData.Bytes())
+	err = fs.SaveBlob(id, name, fileData.Bytes(), compressed)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	w.Header().Set("Location", "/uploads/"+id+"?filename="+url.QueryEscape(info.Filename))
-	_, err = w.Write([]byte("ok"))
+	link = "/uploads/" + id + "?filename=" + url.QueryEscape(name)
 	return
 }
 
+// indexUpload keeps the text of a document uploaded to the domain, so
+// that searching the domain finds it
+func indexUpload(domain, link, name string, data []byte) {
+	text, ok := extract.Text(name, data)
+	if !ok || text == "" {
+		return
+	}
+	id := strings.TrimPrefix(strings.SplitN(link, "?", 2)[0], "/uploads/")
+	if err := fs.SaveUploadText(id, domain, name, text); err != nil {
+		log.Error(err)
+	}
+}
+
 func handle(w http.ResponseWriter, r *http.Request) (err error) {
 	// very special paths
 	if r.URL.Path == "/robots.txt" {
 		// special path
-		w.Write([]byte(`User-agent: * 
-Disallow: /`))
+		return handleRobots(w, r)
 	} else if r.URL.Path == "/favicon.ico" {
 		// TODO
 	} else if r.URL.Path == "/sitemap.xml" {
@@ -718,13 +1598,23 @@ Disallow: /`))
 	tr := new(TemplateRender)
 	tr.Domain = "public"
 	if len(fields) > 2 {
-		tr.Page = strings.TrimSpace(strings.ToLower(fields[2]))
+		// slugs can be nested like /domain/projects/alpha/notes
+		tr.Page = cleanSlugPath(strings.ToLower(strings.Join(fields[2:], "/")))
 	}
 	if len(fields) > 1 {
 		tr.Domain = strings.TrimSpace(strings.ToLower(fields[1]))
 	}
 
 	tr.SignedIn, tr.DomainKey, tr.DefaultDomain, tr.DomainList, tr.DomainKeys = isSignedIn(w, r, tr.Domain)
+	tr.EditorID = editorID(w, r)
+	tr.Robots = robotsPolicy(tr.Domain)
+	tr.AllowIndexing = allowIndexing
+	tr.ExportFormats = converter.names
+	if options, errOptions := fs.GetDomainOptions(tr.Domain); errOptions == nil {
+		tr.Theme = options.Theme
+	}
+	w.Header().Set("X-Robots-Tag", tr.Robots)
+	setOnionHeaders(w, r)
 
 	if r.URL.Path == "/" {
 		// special path /
@@ -735,42 +1625,172 @@ Disallow: /`))
 	} else if r.URL.Path == "/ws" {
 		// special path /ws
 		return tr.handleWebsocket(w, r)
-	} else if r.URL.Path == "/update" {
-		// special path /login
-		return tr.handleLoginUpdate(w, r)
+	} else if r.URL.Path == "/metrics" && metricsEnabled {
+		// special path /metrics
+		return handleMetrics(w, r)
 	} else if r.URL.Path == "/logout" {
 		// special path /logout
 		return tr.handleLogout(w, r)
+	} else if r.URL.Path == "/api/pins" {
+		// special path /api/pins
+		return tr.handleAPIPins(w, r)
+	} else if r.URL.Path == "/api/push" {
+		// special path /api/push
+		return tr.handlePush(w, r)
+	} else if r.URL.Path == "/api/archive" {
+		// special path /api/archive
+		return tr.handleAPIArchive(w, r)
+	} else if r.URL.Path == "/api/readinglist" {
+		// special path /api/readinglist
+		return tr.handleAPIReadingList(w, r)
+	} else if r.URL.Path == "/api/openapi.json" {
+		// special path /api/openapi.json
+		return handleOpenAPI(w, r)
+	} else if r.URL.Path == "/api/docs" {
+		// special path /api/docs
+		return tr.handleAPIDocs(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		// special path /api/v1/{domain}/...
+		return tr.handleAPIv1(w, r)
+	} else if r.URL.Path == "/replica" {
+		// special path /replica
+		return handleReplica(w, r)
+	} else if r.URL.Path == "/graphql" {
+		// special path /graphql
+		return handleGraphQL(w, r)
+	} else if r.URL.Path == "/api/clip" {
+		// special path /api/clip
+		return tr.handleAPIClip(w, r)
+	} else if r.URL.Path == "/oembed" {
+		// special path /oembed
+		return handleOEmbed(w, r)
+	} else if r.URL.Path == "/duplicates" {
+		// special path /duplicates
+		return tr.handleDuplicates(w, r)
+	} else if r.URL.Path == "/search" {
+		// special path /search
+		return tr.handleSearchAll(w, r)
+	} else if r.URL.Path == "/moderation" {
+		// special path /moderation
+		return tr.handleModeration(w, r)
 	} else if r.URL.Path == "/upload" {
 		// special path /upload
 		return tr.handleUpload(w, r)
-	} else if tr.Page == "new" {
-		// special path /upload
-		http.Redirect(w, r, "/"+tr.DefaultDomain+"/"+createPage(tr.DefaultDomain).ID, 302)
+	} else if tr.Page == "new" && !tr.pageExists() {
+		// special path /{domain}/new
+		http.Redirect(w, r, "/"+tr.DefaultDomain+"/"+utils.UUID()+"?create=1", 302)
 		return
 	} else if strings.HasPrefix(r.URL.Path, "/uploads") {
 		// special path /uploads
+		if r.URL.Query().Get("sig") != "" {
+			if _, ok := validShare("/uploads/"+tr.Page, r.URL.Query()); !ok {
+				http.Error(w, "the share link has expired", http.StatusForbidden)
+				return
+			}
+			setShareHeaders(w)
+		}
 		return tr.handleUploads(w, r, tr.Page)
 	} else if tr.Domain != "" && tr.Page == "" {
 		if r.URL.Query().Get("q") != "" {
 			return tr.handleSearch(w, r, tr.Domain, r.URL.Query().Get("q"))
 		}
+		if page, ok := tr.homepage(); ok {
+			// the domain opens with its homepage, and lists its recent
+			// pages at /domain/recent
+			tr.Page = page
+			tr.IsHomepage = true
+			return tr.handleViewEdit(w, r)
+		}
 		// domain exists, handle normally
 		return tr.handleMain(w, r, "")
 	} else if tr.Domain != "" && tr.Page != "" {
-		if tr.Page == "list" {
-			files, _ := fs.GetAll(tr.Domain)
-			for i := range files {
-				files[i].Data = ""
-				files[i].DataHTML = template.HTML("")
+		// a page that was named like a system page or an action before
+		// such names were refused is shown instead of them
+		var action string
+		if !reservedSlug(tr.Page) || !tr.pageExists() {
+			if handled, err := tr.handleSystemPage(w, r); handled {
+				return err
 			}
-			return tr.handleList(w, r, "All", files)
+			tr.Page, action = splitPageAction(tr.Page)
+		}
+		if action == "" && !tr.SignedIn && r.URL.Query().Get("sig") != "" {
+			return tr.handleShared(w, r)
+		} else if action == "embed" {
+			return tr.handleEmbed(w, r)
+		} else if action == "report" {
+			return tr.handleReport(w, r)
+		} else if action == "access" {
+			return tr.handleAccess(w, r)
+		} else if action == "summarize" {
+			return tr.handleSummarize(w, r)
+		} else if action == "share" {
+			return tr.handleShare(w, r)
+		} else if action == "qr" {
+			return tr.handleQR(w, r)
+		} else if action == "submit" {
+			return tr.handleSubmit(w, r)
+		} else if action == "edit" {
+			return tr.handleEdit(w, r)
+		} else if action == "drawing" {
+			return tr.handleDrawing(w, r)
+		} else if action == "drawing.svg" {
+			return tr.handleDrawingSVG(w, r)
+		} else if action == "export.html" {
+			return tr.handleExportHTML(w, r)
+		} else if action == "export.zip" {
+			return tr.handleExportZip(w, r)
+		} else if strings.HasPrefix(action, "export.") {
+			return tr.handleExportPage(w, r, strings.TrimPrefix(action, "export."))
+		} else if strings.HasPrefix(action, "export/") {
+			return tr.handleExportConverted(w, r, strings.TrimPrefix(action, "export/"))
 		}
 		return tr.handleViewEdit(w, r)
 	}
 	return
 }
 
+// handleSystemPage handles the pages of a domain that are not stored
+// pages, like /domain/settings, and reports whether the page was one
+func (tr *TemplateRender) handleSystemPage(w http.ResponseWriter, r *http.Request) (handled bool, err error) {
+	if tr.Page == "list" {
+		tr.Prefix = cleanSlugPath(r.URL.Query().Get("prefix"))
+		tr.IncludeArchived = r.URL.Query().Get("archived") == "1"
+		files, _ := listFiles(r, tr.Domain, tr.Prefix, tr.IncludeArchived)
+		return true, tr.handleList(w, r, "All", files)
+	} else if tr.Page == "tree" {
+		return true, tr.handleTree(w, r)
+	} else if tr.Page == "recent" {
+		return true, tr.handleMain(w, r, "")
+	} else if tr.Page == "feed.atom" {
+		return true, tr.handleFeed(w, r, "atom")
+	} else if tr.Page == "feed.json" {
+		return true, tr.handleFeed(w, r, "json")
+	} else if tr.Page == "calendar.ics" {
+		return true, tr.handleCalendar(w, r)
+	} else if tr.Page == "linkcheck" {
+		return true, tr.handleLinkCheck(w, r)
+	} else if tr.Page == "tasks" {
+		return true, tr.handleTasks(w, r)
+	} else if tr.Page == "empty" {
+		return true, tr.handleEmptyPages(w, r)
+	} else if tr.Page == "settings" {
+		return true, tr.handleSettings(w, r)
+	} else if tr.Page == "onthisday" {
+		return true, tr.handleOnThisDay(w, r)
+	} else if tr.Page == "queue" {
+		return true, tr.handleQueue(w, r)
+	} else if tr.Page == "import" {
+		return true, tr.handleImport(w, r)
+	} else if tr.Page == "transfer" {
+		return true, tr.handleTransfer(w, r)
+	} else if tr.Page == "export.docx" || tr.Page == "export.epub" || tr.Page == "export.html" || tr.Page == "export.pdf" {
+		return true, tr.handleExportPages(w, r, strings.TrimPrefix(tr.Page, "export."))
+	} else if tr.Page == "export.opml" {
+		return true, tr.handleExportOPML(w, r)
+	}
+	return false, nil
+}
+
 // setLogLevel determines the log level
 func setLogLevel(level string) (err error) {
 
@@ -809,53 +1829,20 @@ func setLogLevel(level string) (err error) {
 	return
 }
 
-// createPage throws error if domain does not exist
-func createPage(domain string) (f db.File) {
-	f = db.File{
-		ID:       utils.UUID(),
-		Created:  time.Now(),
-		Domain:   domain,
-		Modified: time.Now(),
-	}
-	err := fs.Save(f)
-	if err != nil {
-		log.Debug(err)
-	}
-	return
-}
-
+// addSimilar replaces the pages similar to the page by those with the
+// nearest embeddings, if there is an API for them. Otherwise the pages
+// are kept similar by their words as they are saved.
 func addSimilar(domain string, fileid string) (err error) {
-	files, err := fs.GetAll(domain)
-	documents := []string{}
-	ids := []string{}
-	maindocument := ""
-	for _, file := range files {
-		if file.ID == fileid {
-			maindocument = file.Data
-			continue
-		}
-		ids = append(ids, file.ID)
-		documents = append(documents, file.Data)
-	}
-
-	ds, err := documentsimilarity.New(documents)
-	if err != nil {
+	if embedder == nil {
 		return
 	}
-
-	similarities, err := ds.JaccardSimilarity(maindocument)
+	files, err := fs.GetAll(domain)
 	if err != nil {
 		return
 	}
-
-	if len(similarities) > 5 {
-		similarities = similarities[:5]
-	}
-	similarIds := make([]string, len(similarities))
-	for i, similarity := range similarities {
-		similarIds[i] = ids[similarity.Index]
+	similarIds, scores, err := similarByEmbedding(domain, fileid, files)
+	if err != nil || len(similarIds) == 0 {
+		return
 	}
-
-	err = fs.SetSimilar(fileid, similarIds)
-	return
+	return fs.SetSimilar(fileid, similarIds, scores)
 }
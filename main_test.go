@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testOwner    = "0123456789abcdef0123456789abcdef"
+	testStranger = "fedcba9876543210fedcba9876543210"
+)
+
+// newTestDomains opens a database in a temporary folder with the private
+// domains d and e, and returns a key of each
+func newTestDomains(t *testing.T) (keyD, keyE string, done func()) {
+	tmp, err := ioutil.TempDir("", "rwtxt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = db.New(filepath.Join(tmp, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetReservedSlugs(reservedSlug)
+	for _, domain := range []string{"d", "e"} {
+		assert.Nil(t, fs.SetDomain(domain, "pw"))
+	}
+	keyD, _ = fs.SetKey("d", "pw")
+	keyE, _ = fs.SetKey("e", "pw")
+	return keyD, keyE, func() {
+		// pages are still indexed in the background for a moment
+		time.Sleep(200 * time.Millisecond)
+		fs.Close()
+		fs = nil
+		forgetDomains()
+		os.RemoveAll(tmp)
+	}
+}
+
+// testRequest handles a request with the domain key and editor id as
+// cookies, if they are set, and returns the response with its body
+// unzipped
+func testRequest(t *testing.T, method, target, key, editor string) (w *httptest.ResponseRecorder, body string) {
+	r := httptest.NewRequest(method, target, nil)
+	if key != "" {
+		r.AddCookie(&http.Cookie{Name: "rwtxt-domains", Value: key})
+	}
+	if editor != "" {
+		r.AddCookie(&http.Cookie{Name: editorCookie, Value: editor})
+	}
+	w = httptest.NewRecorder()
+	assert.Nil(t, handle(w, r))
+	b := w.Body.Bytes()
+	if w.Header().Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(bytes.NewReader(b))
+		if assert.Nil(t, err) {
+			b, _ = ioutil.ReadAll(gz)
+		}
+	}
+	return w, string(b)
+}
+
+func testPage(t *testing.T, domain, data string) db.File {
+	f := fs.NewFile(data, data)
+	f.Domain = domain
+	assert.Nil(t, fs.Save(f))
+	return f
+}
+
+func TestWebsocketSignIn(t *testing.T) {
+	_, keyE, done := newTestDomains(t)
+	defer done()
+
+	// a sign-in to another domain does not open the domain of the query
+	for _, key := range []string{"", keyE} {
+		w, _ := testRequest(t, "GET", "/ws?domain=d", key, "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+}
+
+func TestPinsSignIn(t *testing.T) {
+	keyD, keyE, done := newTestDomains(t)
+	defer done()
+	f := testPage(t, "d", "pinned page of d")
+
+	for _, key := range []string{"", keyE} {
+		w, _ := testRequest(t, "POST", "/api/pins?domain=d&id="+f.ID, key, "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	pinned, _ := fs.IsPinned("d", f.ID)
+	assert.False(t, pinned)
+
+	w, _ := testRequest(t, "POST", "/api/pins?domain=d&id="+f.ID, keyD, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	pinned, _ = fs.IsPinned("d", f.ID)
+	assert.True(t, pinned)
+}
+
+func TestUploadSignIn(t *testing.T) {
+	keyD, keyE, done := newTestDomains(t)
+	defer done()
+
+	for _, key := range []string{"", keyE} {
+		w, _ := testRequest(t, "POST", "/upload?domain=d", key, "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	// signed in, the upload is only missing its file
+	r := httptest.NewRequest("POST", "/upload?domain=d", nil)
+	r.AddCookie(&http.Cookie{Name: "rwtxt-domains", Value: keyD})
+	w := httptest.NewRecorder()
+	assert.NotNil(t, handle(w, r))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestArchiveSignIn(t *testing.T) {
+	keyD, keyE, done := newTestDomains(t)
+	defer done()
+	testPage(t, "d", "secret of d")
+
+	for _, key := range []string{"", keyE} {
+		w, body := testRequest(t, "GET", "/api/v1/d/archive", key, "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NotContains(t, body, "secret of d")
+	}
+	w, body := testRequest(t, "GET", "/api/v1/d/archive", keyD, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, len(zr.File))
+	}
+}
+
+func TestPageAccessLevels(t *testing.T) {
+	keyD, _, done := newTestDomains(t)
+	defer done()
+	f := testPage(t, "d", "diary of the owner")
+	assert.Nil(t, fs.SetPageAccess(f.ID, db.PageAccess{Level: db.AccessOwner, Owner: testOwner}))
+
+	_, body := testRequest(t, "GET", "/d/"+f.ID, keyD, testOwner)
+	assert.Contains(t, body, "diary of the owner")
+	_, body = testRequest(t, "GET", "/d/"+f.ID, keyD, testStranger)
+	assert.NotContains(t, body, "diary of the owner")
+	_, body = testRequest(t, "GET", "/d/"+f.ID+"/export.html", keyD, testStranger)
+	assert.NotContains(t, body, "diary of the owner")
+
+	// the archive only has the pages that whoever downloads it may read
+	_, body = testRequest(t, "GET", "/api/v1/d/archive", keyD, testStranger)
+	assert.NotContains(t, body, f.ID)
+	_, body = testRequest(t, "GET", "/api/v1/d/archive", keyD, testOwner)
+	assert.Contains(t, body, f.ID)
+
+	// read only pages are read by everyone but only saved by the owner
+	assert.Nil(t, fs.SetPageAccess(f.ID, db.PageAccess{Level: db.AccessReadOnly, Owner: testOwner}))
+	_, body = testRequest(t, "GET", "/d/"+f.ID, keyD, testStranger)
+	assert.Contains(t, body, "diary of the owner")
+	f.Data = "diary of someone else"
+	f.Editor = testStranger
+	assert.Equal(t, db.ErrReadOnly, fs.Save(f))
+	f.Editor = testOwner
+	assert.Nil(t, fs.Save(f))
+}
+
+func TestShareLinks(t *testing.T) {
+	_, _, done := newTestDomains(t)
+	defer done()
+	shareKey = []byte("a key to sign share links in tests")
+	defer func() { shareKey = nil }()
+	f := testPage(t, "d", "shared with a link")
+	other := testPage(t, "d", "not shared")
+
+	page := "/d/" + f.ID
+	_, body := testRequest(t, "GET", signLink(page, time.Now().Add(time.Hour)), "", "")
+	assert.Contains(t, body, "shared with a link")
+
+	// a link opens only the page it was signed for, until it expires
+	signed := signLink(page, time.Now().Add(time.Hour))
+	_, body = testRequest(t, "GET", strings.Replace(signed, f.ID, other.ID, 1), "", "")
+	assert.NotContains(t, body, "not shared")
+	_, body = testRequest(t, "GET", signLink(page, time.Now().Add(-time.Minute)), "", "")
+	assert.NotContains(t, body, "shared with a link")
+	_, body = testRequest(t, "GET", signed[:len(signed)-2], "", "")
+	assert.NotContains(t, body, "shared with a link")
+
+	assert.Nil(t, fs.SaveBlob("sha256-abc", "a.txt", []byte("uploaded"), false))
+	w, _ := testRequest(t, "GET", signLink("/uploads/sha256-abc", time.Now().Add(-time.Minute)), "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, body = testRequest(t, "GET", signLink("/uploads/sha256-abc", time.Now().Add(time.Hour)), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "uploaded", body)
+}
+
+func TestOwnerRecovery(t *testing.T) {
+	keyD, keyE, done := newTestDomains(t)
+	defer done()
+	adminDomain = "d"
+	defer func() { adminDomain = "" }()
+	f := testPage(t, "d", "pages of a lost cookie")
+	assert.Nil(t, fs.SetPageAccess(f.ID, db.PageAccess{Level: db.AccessOwner, Owner: testOwner}))
+
+	// only the admins give pages to another editor
+	target := "/moderation?action=owner&editor=" + testOwner + "&to=" + testStranger
+	w, _ := testRequest(t, "POST", target, keyE, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = testRequest(t, "POST", "/moderation?action=owner&editor="+testOwner+"&to=guessable", keyD, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, db.ErrNoAccess, fs.CheckAccess(f.ID, testStranger, false))
+
+	w, _ = testRequest(t, "POST", target, keyD, "")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Nil(t, fs.CheckAccess(f.ID, testStranger, true))
+	assert.Equal(t, db.ErrNoAccess, fs.CheckAccess(f.ID, testOwner, false))
+}
+
+func TestScriptMemory(t *testing.T) {
+	s := script{name: "scripts/grow", code: `local s="x" while true do s=s..s end`}
+	_, ok, err := s.run(scriptOnView, "d", db.File{}, "text")
+	assert.False(t, ok)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "the script used too much memory", err.Error())
+	}
+
+	s.code = `local t={} while true do t[#t+1]="x" end`
+	_, ok, err = s.run(scriptOnView, "d", db.File{}, "text")
+	assert.False(t, ok)
+	assert.NotNil(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Add("X-Forwarded-For", "10.0.0.1, 192.0.2.7")
+	assert.Equal(t, "127.0.0.1", clientIP(r))
+
+	// only the address that the proxy added is believed
+	trustProxy = true
+	defer func() { trustProxy = false }()
+	assert.Equal(t, "192.0.2.7", clientIP(r))
+	torMode = true
+	assert.Equal(t, "127.0.0.1", clientIP(r))
+	torMode = false
+	r.RemoteAddr = "198.51.100.3:1234"
+	assert.Equal(t, "198.51.100.3", clientIP(r))
+}
+
+func TestEmbedVideos(t *testing.T) {
+	setPeerTubeInstances("https://tube.example.org/")
+	defer func() { peerTubeInstances = make(map[string]bool) }()
+
+	for _, videos := range []string{db.VideosEmbed, db.VideosClickToLoad} {
+		rendered := embedVideos(`<p><a href="https://tube.example.org/w/kkGMgK9ZtnKfYAgnEtQxbv">video</a></p>`, videos)
+		assert.Contains(t, rendered, `sandbox="allow-scripts allow-same-origin"`)
+	}
+	// other instances stay links
+	link := `<p><a href="https://tube.example.net/w/kkGMgK9ZtnKfYAgnEtQxbv">video</a></p>`
+	assert.Equal(t, link, embedVideos(link, db.VideosEmbed))
+}
+
+func TestLimitRoutes(t *testing.T) {
+	// export/docx is only a route with a -converter
+	for p, download := range map[string]bool{
+		"/api/v1/d/archive":          true,
+		"/api/v1/d/pages/archive":    false,
+		"/d/export.epub":             true,
+		"/d/notes/export.zip":        true,
+		"/d/notes/export/docx":       false,
+		"/d/export.md":               false,
+		"/d/notes-export.html":       false,
+		"/uploads/a/export.html":     false,
+		"/d/projects/export.html/me": false,
+	} {
+		assert.Equal(t, download, isDownload(p), p)
+	}
+	assert.True(t, isImport("/d/import"))
+	assert.False(t, isImport("/d/projects/import"))
+	assert.False(t, isImport("/api/v1/import"))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// maintenanceInterval is how often the database is checked for
+// corruption and vacuumed, or never if it is zero
+var maintenanceInterval = 7 * 24 * time.Hour
+
+// maintenanceSetting is the setting that keeps the last maintenance
+const maintenanceSetting = "maintenance"
+
+// maintenanceRunning is whether the database is being maintained, so
+// that the admins do not start it twice
+var maintenanceRunning struct {
+	sync.Mutex
+	running bool
+}
+
+// runMaintenance maintains the database when maintenanceInterval has
+// passed since it was last maintained, looking once an hour
+func runMaintenance() {
+	if maintenanceInterval <= 0 {
+		return
+	}
+	for {
+		last, err := lastMaintenance()
+		if err != nil {
+			log.Error(err)
+		} else if last == nil || time.Since(last.Started) >= maintenanceInterval {
+			maintainDatabase()
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// lastMaintenance returns the last maintenance, or nil if there was none
+func lastMaintenance() (m *db.Maintenance, err error) {
+	value, err := fs.Setting(maintenanceSetting)
+	if err != nil || value == "" {
+		return
+	}
+	m = new(db.Maintenance)
+	err = json.Unmarshal([]byte(value), m)
+	return
+}
+
+// startMaintenance maintains the database in the background, unless it
+// is being maintained already, and returns whether it started
+func startMaintenance() bool {
+	if !claimMaintenance() {
+		return false
+	}
+	go func() {
+		defer releaseMaintenance()
+		maintain()
+	}()
+	return true
+}
+
+// maintainDatabase maintains the database, unless the admins are
+// maintaining it already
+func maintainDatabase() {
+	if !claimMaintenance() {
+		return
+	}
+	defer releaseMaintenance()
+	maintain()
+}
+
+func claimMaintenance() bool {
+	maintenanceRunning.Lock()
+	defer maintenanceRunning.Unlock()
+	if maintenanceRunning.running {
+		return false
+	}
+	maintenanceRunning.running = true
+	return true
+}
+
+func releaseMaintenance() {
+	maintenanceRunning.Lock()
+	maintenanceRunning.running = false
+	maintenanceRunning.Unlock()
+}
+
+// isMaintaining returns whether the database is being maintained
+func isMaintaining() bool {
+	maintenanceRunning.Lock()
+	defer maintenanceRunning.Unlock()
+	return maintenanceRunning.running
+}
+
+// maintain checks and vacuums the database, logs what it found and
+// keeps it for the moderation page and /metrics
+func maintain() {
+	log.Info("maintaining the database")
+	m, err := fs.Maintain()
+	if err != nil {
+		log.Errorf("could not maintain the database: %s", err)
+		return
+	}
+	if !m.OK() {
+		log.Errorf("the database is corrupt, restore it from a backup: %s", strings.Join(m.Problems, "; "))
+	} else {
+		log.Infof("maintained the database in %s, from %d to %d bytes", m.Duration, m.SizeBefore, m.SizeAfter)
+	}
+	b, err := json.Marshal(m)
+	if err == nil {
+		err = fs.SetSetting(maintenanceSetting, string(b))
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// PageMeta is the Open Graph and Twitter card metadata of a public page
+type PageMeta struct {
+	Title       string
+	Description string
+	Image       string
+	URL         string
+	SiteName    string
+	OEmbedURL   string
+}
+
+// baseURL returns the scheme and host that the request was made to
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.ToLower(strings.Split(proto, ",")[0])
+	}
+	return scheme + "://" + r.Host
+}
+
+// absoluteURL makes links relative to the site, like /uploads/x, absolute
+func absoluteURL(r *http.Request, link string) string {
+	if strings.HasPrefix(link, "/") && !strings.HasPrefix(link, "//") {
+		return baseURL(r) + link
+	}
+	return link
+}
+
+// newPageMeta returns the metadata used to unfurl links to a page
+func newPageMeta(r *http.Request, domain string, f db.File) *PageMeta {
+	link := f.Slug
+	if link == "" {
+		link = f.ID
+	}
+	meta := &PageMeta{
+		Title:       f.DisplayName(),
+		Description: utils.FirstParagraph(f.Data, 200),
+		URL:         baseURL(r) + "/" + domain + "/" + link,
+		SiteName:    "rwtxt",
+	}
+	meta.OEmbedURL = baseURL(r) + "/oembed?format=json&url=" + url.QueryEscape(meta.URL)
+	if image := utils.FirstImage(f.Data); image != "" {
+		meta.Image = absoluteURL(r, image)
+	}
+	return meta
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+)
+
+// replicaKey lets mirrors download the database from /replica
+var replicaKey string
+
+// mirrorOf is the instance that this instance is a read-only mirror of
+var mirrorOf string
+var mirrorKey string
+var mirrorInterval time.Duration
+
+var mirrorClient = &http.Client{Timeout: 5 * time.Minute}
+
+func readOnly() bool {
+	return mirrorOf != ""
+}
+
+// handleReplica sends the whole database to a mirror
+func handleReplica(w http.ResponseWriter, r *http.Request) (err error) {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if replicaKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(replicaKey)) != 1 {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "not allowed"})
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	return fs.WriteDump(w)
+}
+
+// pullMirror loads the database of the instance being mirrored, unless
+// it is the same as the last one loaded
+func pullMirror(lastSum [sha256.Size]byte) (sum [sha256.Size]byte, err error) {
+	sum = lastSum
+	req, err := http.NewRequest("GET", strings.TrimSuffix(mirrorOf, "/")+"/replica", nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+mirrorKey)
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf("could not mirror %s: %s", mirrorOf, resp.Status)
+		return
+	}
+	dump, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = errors.Wrap(err, "could not mirror "+mirrorOf)
+		return
+	}
+	newSum := sha256.Sum256(dump)
+	if newSum == lastSum {
+		return
+	}
+	err = fs.Restore(bytes.NewReader(dump))
+	if err != nil {
+		return
+	}
+	forgetDomains()
+	log.Debugf("mirrored %s", mirrorOf)
+	return newSum, nil
+}
+
+// runMirror keeps the database the same as the instance being mirrored
+func runMirror() {
+	var sum [sha256.Size]byte
+	for {
+		var err error
+		sum, err = pullMirror(sum)
+		if err != nil {
+			log.Error(err)
+		}
+		time.Sleep(mirrorInterval)
+	}
+}
+
+// readOnlyRequests refuses everything but reading on a mirror
+func readOnlyRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnly() && r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "this is a read-only mirror of "+mirrorOf, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
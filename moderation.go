@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// adminDomain is the domain whose members moderate the public domains.
+// Pages can only be reported when it is set.
+var adminDomain string
+
+var reportReasons = []string{"spam", "abuse", "illegal", "personal information", "copyright", "other"}
+
+// isAdmin returns whether the request is signed in to the admin domain
+func isAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminDomain == "" {
+		return false
+	}
+	signedIn, _, _, _, _ := isSignedIn(w, r, adminDomain)
+	return signedIn
+}
+
+// isBlocked returns whether a moderator blocked the address of the request
+func isBlocked(r *http.Request) bool {
+	blocked, err := fs.IsBlocked(clientIP(r))
+	if err != nil {
+		log.Error(err)
+	}
+	return blocked
+}
+
+// canReport returns whether pages of the domain can be reported
+func canReport(domain string) bool {
+	if adminDomain == "" {
+		return false
+	}
+	_, ispublic, _ := fs.GetDomainFromName(domain)
+	return ispublic || domain == "public"
+}
+
+// handleReport shows the form to report a page, and files the report
+func (tr *TemplateRender) handleReport(w http.ResponseWriter, r *http.Request) (err error) {
+	if !canReport(tr.Domain) {
+		http.Error(w, "pages of "+tr.Domain+" can not be reported", http.StatusNotFound)
+		return
+	}
+	files, err := fs.Get(tr.Page, tr.Domain)
+	if err != nil || len(files) == 0 {
+		return tr.handleMain(w, r, "there is no page "+tr.Page)
+	}
+	f := files[0]
+	tr.File = f
+	tr.Title = "Report " + f.DisplayName()
+	tr.ReportReasons = reportReasons
+
+	if r.Method == "POST" {
+		reason := r.FormValue("reason")
+		if !containsString(reportReasons, reason) {
+			tr.Message = "choose why the page is reported"
+			return reportTemplate.Execute(w, tr)
+		}
+		if isBlocked(r) {
+			http.Error(w, "blocked", http.StatusForbidden)
+			return
+		}
+		details := strings.TrimSpace(r.FormValue("details"))
+		if len(details) > 2000 {
+			details = details[:2000]
+		}
+		err = fs.AddReport(db.Report{
+			Domain:     tr.Domain,
+			FileID:     f.ID,
+			Slug:       f.Slug,
+			Reason:     reason,
+			Details:    details,
+			ReporterIP: clientIP(r),
+		})
+		if err != nil {
+			return
+		}
+		tr.Message = "Thank you, the moderators will look at the page."
+	}
+	return reportTemplate.Execute(w, tr)
+}
+
+// handleModeration shows the open reports to the admins and carries out
+// what they decide
+func (tr *TemplateRender) handleModeration(w http.ResponseWriter, r *http.Request) (err error) {
+	if !isAdmin(w, r) {
+		http.Error(w, "need to log in to "+adminDomain, http.StatusForbidden)
+		return
+	}
+	tr.Domain = adminDomain
+	tr.Title = "Moderation"
+	tr.EditFilter, err = parseEditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	if r.Method == "POST" && r.FormValue("action") == "revert" {
+		var reverted []db.Edit
+		reverted, err = fs.RevertEdits(tr.EditFilter)
+		if err != nil {
+			return
+		}
+		for _, edit := range reverted {
+			forgetDomain(edit.Domain)
+		}
+		log.Infof("moderation: reverted %d pages edited by %+v", len(reverted), tr.EditFilter)
+		http.Redirect(w, r, "/moderation", 302)
+		return
+	} else if r.Method == "POST" && r.FormValue("action") == "owner" {
+		// an owner that lost their editor id gets their pages back
+		to := strings.TrimSpace(r.FormValue("to"))
+		if tr.EditFilter.Editor == "" || !validEditorID.MatchString(to) {
+			http.Error(w, "need the old and the new editor id", http.StatusBadRequest)
+			return nil
+		}
+		if err = fs.RenameEditor(tr.EditFilter.Editor, to); err != nil {
+			return
+		}
+		log.Infof("moderation: gave the pages of editor %s to %s", tr.EditFilter.Editor, to)
+		forgetDomains()
+		http.Redirect(w, r, "/moderation?editor="+to, 302)
+		return
+	} else if r.Method == "POST" && r.FormValue("action") == "maintain" {
+		if startMaintenance() {
+			log.Infof("moderation: maintaining the database")
+		}
+		http.Redirect(w, r, "/moderation#database", 302)
+		return
+	} else if r.Method == "POST" {
+		var id int
+		id, err = strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "bad report", http.StatusBadRequest)
+			return nil
+		}
+		if err = moderate(id, r.FormValue("action")); err != nil {
+			return
+		}
+		http.Redirect(w, r, "/moderation", 302)
+		return
+	}
+	if tr.EditFilter.Editor != "" || tr.EditFilter.IP != "" {
+		// everything an editor or an address changed, to revert
+		tr.Edits, err = fs.GetEdits(tr.EditFilter)
+		if err != nil {
+			return
+		}
+		return moderationTemplate.Execute(w, tr)
+	}
+	tr.Reports, err = fs.GetReports()
+	if err != nil {
+		return
+	}
+	tr.Maintenance, err = lastMaintenance()
+	if err != nil {
+		return
+	}
+	tr.Maintaining = isMaintaining()
+	return moderationTemplate.Execute(w, tr)
+}
+
+// moderate carries out an action on a reported page. Hiding the page or
+// blocking whoever wrote it keeps the report open, until the page is
+// deleted or the report is closed.
+func moderate(id int, action string) (err error) {
+	report, err := fs.GetReport(id)
+	if err != nil {
+		return
+	}
+	log.Infof("moderation: %s %s/%s", action, report.Domain, report.Slug)
+	defer forgetDomain(report.Domain)
+	switch action {
+	case "hide", "unhide":
+		hidden := action == "hide"
+		if err = fs.SetArchived(report.FileID, report.Domain, hidden); err != nil {
+			return
+		}
+		return fs.SetHidden(report.FileID, hidden)
+	case "block":
+		if report.SourceIP == "" {
+			return
+		}
+		return fs.BlockIP(report.SourceIP)
+	case "delete":
+		if err = fs.Delete(report.FileID); err != nil {
+			return
+		}
+		return fs.ResolveReports(report.FileID, "deleted")
+	case "close":
+		return fs.ResolveReports(report.FileID, "closed")
+	}
+	return
+}
+
+// editFilterTime is how the moderation page shows times, which are UTC
+const editFilterTime = "2006-01-02T15:04"
+
+// parseEditFilter returns the editor, address and time window that the
+// moderation page asks for
+func parseEditFilter(r *http.Request) (filter db.EditFilter, err error) {
+	filter.Editor = strings.TrimSpace(r.FormValue("editor"))
+	filter.IP = strings.TrimSpace(r.FormValue("ip"))
+	if since := r.FormValue("since"); since != "" {
+		if filter.Since, err = time.Parse(editFilterTime, since); err != nil {
+			return filter, errors.New("could not understand the time " + since)
+		}
+	}
+	if until := r.FormValue("until"); until != "" {
+		if filter.Until, err = time.Parse(editFilterTime, until); err != nil {
+			return filter, errors.New("could not understand the time " + until)
+		}
+		// until the end of that minute
+		filter.Until = filter.Until.Add(time.Minute - time.Nanosecond)
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"sort"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// The pages suggested for a slug that does not exist are at least this
+// similar to it, and there are at most this many of them
+const (
+	suggestionSimilarity = 0.3
+	suggestionCount      = 5
+)
+
+// suggestPages returns the pages of the domain, that the editor may read,
+// whose slug or title is close to the slug, the closest first
+func suggestPages(domain, slug, editor string) (suggestions []db.File, err error) {
+	files, err := fs.GetAll(domain)
+	if err != nil {
+		return
+	}
+	scores := make(map[string]float64)
+	for _, f := range readable(files, editor) {
+		if f.Archived {
+			continue
+		}
+		score := utils.Similarity(slug, f.Slug)
+		if f.Title != "" {
+			if byTitle := utils.Similarity(slug, f.Title); byTitle > score {
+				score = byTitle
+			}
+		}
+		if score >= suggestionSimilarity {
+			scores[f.ID] = score
+			suggestions = append(suggestions, f)
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return scores[suggestions[i].ID] > scores[suggestions[j].ID]
+	})
+	if len(suggestions) > suggestionCount {
+		suggestions = suggestions[:suggestionCount]
+	}
+	return
+}
+
+// handleNotFound tells that the page does not exist yet, suggesting the
+// pages with close names, and asks whether to make it
+func (tr *TemplateRender) handleNotFound(w http.ResponseWriter, r *http.Request, suggestions []db.File) (err error) {
+	tr.SimilarFiles = suggestions
+	tr.Title = tr.Domain + "/" + tr.Page
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusNotFound)
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return notfoundTemplate.Execute(gz, tr)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// maxOnThisDay is how many pages the page of a domain shows from the
+// same day in earlier months and years
+const maxOnThisDay = 5
+
+// Memories are the pages written on the same day in an earlier month or
+// year, like a month or a year ago today
+type Memories struct {
+	Date   time.Time
+	Groups []MemoryGroup
+	// More is how many there are beyond the ones shown
+	More int
+}
+
+// MemoryGroup are the pages of one earlier day
+type MemoryGroup struct {
+	Ago   string
+	Date  time.Time
+	Files []db.File
+}
+
+// memoryAgo says how long before the day the time is, when it is on the
+// same day of an earlier month in the last year or of an earlier year
+func memoryAgo(t, day time.Time) (ago string, months int, ok bool) {
+	if t.Day() != day.Day() {
+		return
+	}
+	months = (day.Year()-t.Year())*12 + int(day.Month()) - int(t.Month())
+	switch {
+	case months <= 0:
+		return
+	case months%12 == 0:
+		ago = plural(months/12, "year") + " ago"
+	case months < 12:
+		ago = plural(months, "month") + " ago"
+	default:
+		return
+	}
+	return ago, months, true
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "a " + unit
+	}
+	return strconv.Itoa(n) + " " + unit + "s"
+}
+
+// onThisDay groups the pages of the domain that the editor may read by
+// how long before the day they were made or changed, the latest first.
+// At most limit pages are kept, if it is more than 0.
+func onThisDay(domain, editor string, day time.Time, limit int) (memories *Memories, err error) {
+	day = day.UTC()
+	files, err := cachedFiles(domain, "onthisday "+day.Format("2006-01-02"), func() ([]db.File, error) {
+		return fs.GetOnThisDay(domain, day)
+	})
+	if err != nil {
+		return
+	}
+	files = readable(files, editor)
+
+	memories = &Memories{Date: day}
+	groups := make(map[int]*MemoryGroup)
+	var order []int
+	for _, f := range files {
+		// a page is remembered for when it was made, or else for when it
+		// was last changed
+		ago, months, ok := memoryAgo(f.Created.UTC(), day)
+		if !ok {
+			ago, months, ok = memoryAgo(f.Modified.UTC(), day)
+		}
+		if !ok {
+			continue
+		}
+		if groups[months] == nil {
+			groups[months] = &MemoryGroup{Ago: ago, Date: day.AddDate(0, -months, 0)}
+			order = append(order, months)
+		}
+		f.Data = ""
+		groups[months].Files = append(groups[months].Files, f)
+	}
+	sort.Ints(order)
+	shown := 0
+	for _, months := range order {
+		group := *groups[months]
+		if limit > 0 && shown+len(group.Files) > limit {
+			memories.More += shown + len(group.Files) - limit
+			group.Files = group.Files[:limit-shown]
+		}
+		shown += len(group.Files)
+		if len(group.Files) > 0 {
+			memories.Groups = append(memories.Groups, group)
+		}
+	}
+	return
+}
+
+// handleOnThisDay lists the pages of the domain from the same day in
+// earlier months and years, of today or of the ?date
+func (tr *TemplateRender) handleOnThisDay(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, err := fs.GetDomainFromName(tr.Domain)
+	if err != nil {
+		return tr.handleMain(w, r, "domain does not exist")
+	}
+	if !ispublic && !tr.SignedIn {
+		return tr.handleMain(w, r, "need to log in to read "+tr.Domain)
+	}
+	day := time.Now()
+	if date := r.URL.Query().Get("date"); date != "" {
+		day, err = time.Parse("2006-01-02", date)
+		if err != nil {
+			http.Error(w, "the date should be like 2006-01-02", http.StatusBadRequest)
+			return nil
+		}
+	}
+	tr.Memories, err = onThisDay(tr.Domain, tr.EditorID, day, 0)
+	if err != nil {
+		return
+	}
+	tr.Title = tr.Domain + "/onthisday"
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return onThisDayTemplate.Execute(gz, tr)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// APIParameter is a parameter of an operation of the API, in the path or
+// in the query (or the form, for POST)
+type APIParameter struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// APIOperation is an operation of the API. The OpenAPI document and the
+// documentation page are both made from them, so that they agree.
+type APIOperation struct {
+	ID          string
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Parameters  []APIParameter
+	// Response is a value of the type of the JSON that is returned, or
+	// nil if ContentType is returned instead
+	Response    interface{}
+	ContentType string
+	// Access is who may use it: "read" is anyone for public domains and
+	// the domain key for private ones, "key" is always the domain key and
+	// "cookie" is being signed in to the domain in the browser
+	Access string
+}
+
+// APIType is a type of the JSON of the API, for the documentation page
+type APIType struct {
+	Name   string
+	Schema string
+}
+
+// APIDocs is what the documentation page shows
+type APIDocs struct {
+	Operations []APIOperation
+	Types      []APIType
+}
+
+var (
+	apiDomainParameter = APIParameter{Name: "domain", In: "path", Type: "string", Required: true, Description: "The name of the domain."}
+	apiQueryDomain     = APIParameter{Name: "domain", In: "query", Type: "string", Required: true, Description: "The name of the domain."}
+	apiQueryID         = APIParameter{Name: "id", In: "query", Type: "string", Required: true, Description: "The id of the page."}
+	apiRedirect        = APIParameter{Name: "redirect", In: "query", Type: "string", Description: "Go back to the page instead of returning JSON, for forms."}
+)
+
+var apiOperations = []APIOperation{
+	{
+		ID: "listFiles", Method: "GET", Path: "/api/v1/{domain}/files",
+		Summary:     "List the pages of a domain",
+		Description: "Pages are listed oldest change first, a page of results at a time. Pass the next_cursor of a response as cursor to get the next one, until it has none.",
+		Parameters: []APIParameter{
+			apiDomainParameter,
+			{Name: "modified_since", In: "query", Type: "date-time", Description: "Only pages changed after this time, like 2006-01-02T15:04:05Z."},
+			{Name: "tag", In: "query", Type: "string", Description: "Only pages with this tag."},
+			{Name: "limit", In: "query", Type: "integer", Description: "How many pages to return, 50 by default and at most 500."},
+			{Name: "cursor", In: "query", Type: "string", Description: "The next_cursor of the previous response."},
+		},
+		Response: APIFilesPage{},
+		Access:   "read",
+	},
+	{
+		ID: "complete", Method: "GET", Path: "/api/v1/{domain}/complete",
+		Summary:     "Suggest pages and tags",
+		Description: "Returns up to ten pages whose name starts with page or whose title contains it, and the most used tags that start with tag.",
+		Parameters: []APIParameter{
+			apiDomainParameter,
+			{Name: "page", In: "query", Type: "string", Description: "The start of the name of a page."},
+			{Name: "tag", In: "query", Type: "string", Description: "The start of a tag."},
+		},
+		Response: APICompletions{},
+		Access:   "read",
+	},
+	{
+		ID: "ask", Method: "GET", Path: "/api/v1/{domain}/ask",
+		Summary:     "Ask a question about a domain",
+		Description: "Returns the pages that may answer the question, and an answer written from them when the site has a chat model.",
+		Parameters: []APIParameter{
+			apiDomainParameter,
+			{Name: "q", In: "query", Type: "string", Required: true, Description: "The question."},
+		},
+		Response: APIAnswer{},
+		Access:   "read",
+	},
+	{
+		ID: "preview", Method: "GET", Path: "/api/v1/{domain}/preview",
+		Summary:     "Preview a page",
+		Description: "Returns the start of the page rendered as HTML, cut after length characters of its text, like the cards shown when hovering over links.",
+		Parameters: []APIParameter{
+			apiDomainParameter,
+			{Name: "page", In: "query", Type: "string", Required: true, Description: "The slug or id of the page."},
+			{Name: "length", In: "query", Type: "integer", Description: "How many characters to keep, 300 by default and at most 2000."},
+		},
+		Response: APIPreview{},
+		Access:   "read",
+	},
+	{
+		ID: "downloadArchive", Method: "GET", Path: "/api/v1/{domain}/archive",
+		Summary:     "Download a domain",
+		Description: "Returns the pages, uploads and settings of the domain as a zip archive, which another instance can import.",
+		Parameters:  []APIParameter{apiDomainParameter},
+		ContentType: "application/zip",
+		Access:      "key",
+	},
+	{
+		ID: "downloadConfig", Method: "GET", Path: "/api/v1/{domain}/config",
+		Summary:     "Back up the settings of a domain",
+		Description: "Returns the settings and webhooks of the domain, with the salted SHA-256 hashes of its keys, to restore after rebuilding an instance.",
+		Parameters:  []APIParameter{apiDomainParameter},
+		Response:    DomainConfig{},
+		Access:      "key",
+	},
+	{
+		ID: "restoreConfig", Method: "POST", Path: "/api/v1/{domain}/config",
+		Summary:     "Restore the settings of a domain",
+		Description: "Restores a backup of settings, sent as the body, to the domain. The keys in the backup sign in to the domain again. Needs the key in an Authorization: Bearer header.",
+		Parameters:  []APIParameter{apiDomainParameter},
+		Response:    Payload{},
+		Access:      "key",
+	},
+	{
+		ID: "listPins", Method: "GET", Path: "/api/pins",
+		Summary:    "List the pinned pages of a domain",
+		Parameters: []APIParameter{apiQueryDomain},
+		Response:   []APIFile{},
+		Access:     "cookie",
+	},
+	{
+		ID: "pin", Method: "POST", Path: "/api/pins",
+		Summary:    "Pin a page",
+		Parameters: []APIParameter{apiQueryDomain, apiQueryID, apiRedirect},
+		Response:   Payload{},
+		Access:     "cookie",
+	},
+	{
+		ID: "unpin", Method: "DELETE", Path: "/api/pins",
+		Summary:    "Unpin a page",
+		Parameters: []APIParameter{apiQueryDomain, apiQueryID},
+		Response:   Payload{},
+		Access:     "cookie",
+	},
+	{
+		ID: "getReadingList", Method: "GET", Path: "/api/readinglist",
+		Summary:     "List the reading list of a domain",
+		Description: "Each browser signed in to the domain has its own reading list. Unread pages come first, in the order they were added.",
+		Parameters:  []APIParameter{apiQueryDomain},
+		Response:    APIReadingList{},
+		Access:      "cookie",
+	},
+	{
+		ID: "queue", Method: "POST", Path: "/api/readinglist",
+		Summary:     "Add a page to the reading list, or mark it as read",
+		Description: "Without read the page is added to the reading list, unread.",
+		Parameters: []APIParameter{apiQueryDomain, apiQueryID, apiRedirect,
+			{Name: "read", In: "query", Type: "string", Description: "1 to mark the page as read, 0 to mark it as unread."}},
+		Response: Payload{},
+		Access:   "cookie",
+	},
+	{
+		ID: "unqueue", Method: "DELETE", Path: "/api/readinglist",
+		Summary:    "Take a page off the reading list",
+		Parameters: []APIParameter{apiQueryDomain, apiQueryID},
+		Response:   Payload{},
+		Access:     "cookie",
+	},
+	{
+		ID: "archive", Method: "POST", Path: "/api/archive",
+		Summary:     "Archive a page",
+		Description: "Archived pages are left out of listings and searches unless they are asked for.",
+		Parameters:  []APIParameter{apiQueryDomain, apiQueryID, apiRedirect},
+		Response:    Payload{},
+		Access:      "cookie",
+	},
+	{
+		ID: "unarchive", Method: "DELETE", Path: "/api/archive",
+		Summary:    "Unarchive a page",
+		Parameters: []APIParameter{apiQueryDomain, apiQueryID},
+		Response:   Payload{},
+		Access:     "cookie",
+	},
+	{
+		ID: "clip", Method: "POST", Path: "/api/clip",
+		Summary:     "Clip a web page",
+		Description: "Saves the main content of the web page, or the selection that is sent, as a new page. Send Accept: application/json to get the page instead of being redirected to it.",
+		Parameters: []APIParameter{
+			apiQueryDomain,
+			{Name: "url", In: "query", Type: "string", Required: true, Description: "The web page."},
+			{Name: "title", In: "query", Type: "string", Description: "The title of the web page."},
+			{Name: "selection", In: "query", Type: "string", Description: "The HTML of the selected part of the web page, which is saved instead of fetching it."},
+		},
+		Response: APIFile{},
+		Access:   "cookie",
+	},
+}
+
+// AccessText is who may use an operation, for the documentation page
+func (op APIOperation) AccessText() string {
+	switch op.Access {
+	case "read":
+		return "Anyone, for public domains. Private domains need the domain key."
+	case "key":
+		return "Needs the domain key."
+	}
+	return "Needs to be signed in to the domain in the browser."
+}
+
+// ResponseList is whether an operation returns a list of ResponseType
+func (op APIOperation) ResponseList() bool {
+	return op.Response != nil && reflect.TypeOf(op.Response).Kind() == reflect.Slice
+}
+
+// ResponseType is the name of the type that an operation returns
+func (op APIOperation) ResponseType() string {
+	if op.Response == nil {
+		return op.ContentType
+	}
+	if op.ResponseList() {
+		return reflect.TypeOf(op.Response).Elem().Name()
+	}
+	return reflect.TypeOf(op.Response).Name()
+}
+
+// ResponseText is what an operation returns
+func (op APIOperation) ResponseText() string {
+	if op.ResponseList() {
+		return "A list of " + op.ResponseType() + "."
+	}
+	return op.ResponseType() + "."
+}
+
+// apiSchema returns the JSON schema of a type, adding the structs in it
+// to the schemas by their names and referring to them
+func apiSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Ptr:
+		return apiSchema(t.Elem(), schemas)
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": apiSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": apiSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			// the name is taken first in case the struct has itself in it
+			schemas[t.Name()] = nil
+			properties := make(map[string]interface{})
+			var required []string
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				tag := strings.Split(field.Tag.Get("json"), ",")
+				if field.PkgPath != "" || tag[0] == "-" {
+					continue
+				}
+				name := tag[0]
+				if name == "" {
+					name = field.Name
+				}
+				properties[name] = apiSchema(field.Type, schemas)
+				if len(tag) == 1 {
+					required = append(required, name)
+				}
+			}
+			schema := map[string]interface{}{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+			schemas[t.Name()] = schema
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// apiParameterSchema is the schema of the type of a parameter
+func apiParameterSchema(p APIParameter) map[string]interface{} {
+	if p.Type == "date-time" {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	return map[string]interface{}{"type": p.Type}
+}
+
+// openAPIDocument describes the API as an OpenAPI 3 document, with the
+// site at server
+func openAPIDocument(server string) (doc map[string]interface{}, schemas map[string]interface{}) {
+	schemas = make(map[string]interface{})
+	errorSchema := apiSchema(reflect.TypeOf(Payload{}), schemas)
+	paths := make(map[string]map[string]interface{})
+	for _, op := range apiOperations {
+		var parameters []interface{}
+		for _, p := range op.Parameters {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      apiParameterSchema(p),
+			})
+		}
+		content := map[string]interface{}{op.ContentType: map[string]interface{}{}}
+		if op.Response != nil {
+			content = map[string]interface{}{"application/json": map[string]interface{}{
+				"schema": apiSchema(reflect.TypeOf(op.Response), schemas),
+			}}
+		}
+		security := []interface{}{map[string]interface{}{"cookie": []string{}}}
+		if op.Access != "cookie" {
+			security = []interface{}{map[string]interface{}{"bearer": []string{}}, map[string]interface{}{"cookie": []string{}}}
+		}
+		if op.Access == "read" {
+			// public domains need neither
+			security = append(security, map[string]interface{}{})
+		}
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"parameters":  parameters,
+			"security":    security,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{"description": op.ResponseText(), "content": content},
+				"default": map[string]interface{}{
+					"description": "An error, with its message.",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}},
+				},
+			},
+		}
+		if op.Description != "" {
+			operation["description"] = op.Description
+		}
+		if paths[op.Path] == nil {
+			paths[op.Path] = make(map[string]interface{})
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	version := Version
+	if version == "" {
+		version = "dev"
+	}
+	doc = map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "rwtxt",
+			"version":     version,
+			"description": "The pages of rwtxt domains. Public domains can be read by anyone, private domains need the domain key as a bearer token or the cookie of a browser that is signed in to them.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": server}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "The key of the domain."},
+				"cookie": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "rwtxt-domains"},
+			},
+		},
+	}
+	return
+}
+
+// handleOpenAPI serves the OpenAPI document of the API, which anyone can
+// fetch to generate clients
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) (err error) {
+	doc, _ := openAPIDocument(baseURL(r))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	return writeJSON(w, http.StatusOK, doc)
+}
+
+// handleAPIDocs shows the operations and types of the API
+func (tr *TemplateRender) handleAPIDocs(w http.ResponseWriter, r *http.Request) (err error) {
+	_, schemas := openAPIDocument(baseURL(r))
+	docs := &APIDocs{Operations: apiOperations}
+	for name, schema := range schemas {
+		b, errJSON := json.MarshalIndent(schema, "", "  ")
+		if errJSON != nil {
+			return errJSON
+		}
+		docs.Types = append(docs.Types, APIType{Name: name, Schema: string(b)})
+	}
+	sort.Slice(docs.Types, func(i, j int) bool {
+		return docs.Types[i].Name < docs.Types[j].Name
+	})
+	tr.APIDocs = docs
+	tr.Title = "API"
+	tr.Domain = tr.DefaultDomain
+	if tr.Domain == "" {
+		tr.Domain = "public"
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return apiDocsTemplate.Execute(gz, tr)
+}
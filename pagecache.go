@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// cacheTTL is how long the lists of pages of a domain are kept, or 0 to
+// query them for every visitor
+var cacheTTL = time.Minute
+
+// maxCachedLists limits how many lists are kept, over all domains
+const maxCachedLists = 1000
+
+// pageCache keeps the lists of pages that the main page and the list of
+// a domain show, which busy public domains would otherwise query for
+// every visitor. A domain's lists are forgotten when its pages change,
+// and after cacheTTL anyway, since views change them too. Lists are kept
+// rather than pages, which are different for everyone.
+var pageCache = struct {
+	sync.Mutex
+	domains map[string]*domainCache
+	size    int
+}{domains: make(map[string]*domainCache)}
+
+type domainCache struct {
+	// generation counts how often the domain changed, so that a list that
+	// was queried before a change is not kept after it
+	generation int
+	lists      map[string]cachedList
+}
+
+type cachedList struct {
+	files []db.File
+	made  time.Time
+}
+
+// cachedFiles returns the files of the domain that get returns, from the
+// cache if they were got under the key less than cacheTTL ago
+func cachedFiles(domain, key string, get func() ([]db.File, error)) (files []db.File, err error) {
+	if cacheTTL <= 0 {
+		return get()
+	}
+	pageCache.Lock()
+	c := pageCache.domains[domain]
+	if c == nil {
+		c = &domainCache{lists: make(map[string]cachedList)}
+		pageCache.domains[domain] = c
+	}
+	generation := c.generation
+	list, ok := c.lists[key]
+	pageCache.Unlock()
+	if ok && time.Since(list.made) < cacheTTL {
+		return append([]db.File(nil), list.files...), nil
+	}
+
+	files, err = get()
+	if err != nil {
+		return
+	}
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	if pageCache.domains[domain] != c || c.generation != generation {
+		return
+	}
+	if pageCache.size >= maxCachedLists {
+		pageCache.domains = make(map[string]*domainCache)
+		pageCache.size = 0
+		return
+	}
+	if _, ok := c.lists[key]; !ok {
+		pageCache.size++
+	}
+	c.lists[key] = cachedList{files: append([]db.File(nil), files...), made: time.Now()}
+	return
+}
+
+// forgetDomain forgets the lists of pages of a domain, after its pages
+// change
+func forgetDomain(domain string) {
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	if c := pageCache.domains[domain]; c != nil {
+		c.generation++
+		pageCache.size -= len(c.lists)
+		c.lists = make(map[string]cachedList)
+	}
+}
+
+// forgetDomains forgets the lists of pages of every domain, after pages
+// change whose domain is not known
+func forgetDomains() {
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	for _, c := range pageCache.domains {
+		c.generation++
+		c.lists = make(map[string]cachedList)
+	}
+	pageCache.size = 0
+}
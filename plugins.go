@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// maxPluginOutput limits how much a plugin may answer
+const maxPluginOutput = 16 << 20
+
+// The hooks that plugins can register for
+const (
+	// hookRender changes the markdown of a page before it is rendered
+	hookRender = "render"
+	// hookSave is told about a page after it is saved
+	hookSave = "save"
+	// hookAuth signs in to a domain with something other than its
+	// password, like the password of a directory of users
+	hookAuth = "auth"
+)
+
+// plugins are executables in the -plugins folder that add to rwtxt
+// without changing it. Each one is run with a PluginRequest as JSON on
+// stdin and answers with a PluginResponse as JSON on stdout. When it
+// starts, rwtxt asks each plugin for its hooks with {"hook": "hooks"}.
+var plugins struct {
+	list    []plugin
+	timeout time.Duration
+}
+
+type plugin struct {
+	path  string
+	hooks map[string]bool
+}
+
+// PluginRequest is what a plugin is asked
+type PluginRequest struct {
+	Hook     string `json:"hook"`
+	Domain   string `json:"domain,omitempty"`
+	Page     string `json:"page,omitempty"`
+	Data     string `json:"data,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// PluginResponse is what a plugin answers. Hooks are the hooks it
+// registers for, Data the changed markdown of a render hook and OK
+// whether an auth hook signs in. A plugin that fails says why in Error.
+type PluginResponse struct {
+	Hooks []string `json:"hooks,omitempty"`
+	Data  *string  `json:"data,omitempty"`
+	OK    bool     `json:"ok,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// loadPlugins registers the executables of the folder as plugins, in
+// the order of their names
+func loadPlugins(dir string, timeout time.Duration) (err error) {
+	plugins.list = nil
+	plugins.timeout = timeout
+	if dir == "" {
+		return
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "reading plugins")
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || entry.Mode()&0111 == 0 {
+			continue
+		}
+		p := plugin{path: filepath.Join(dir, entry.Name()), hooks: make(map[string]bool)}
+		response, errRun := p.run(PluginRequest{Hook: "hooks"})
+		if errRun != nil {
+			log.Warnf("not loading plugin %s: %s", entry.Name(), errRun)
+			continue
+		}
+		for _, hook := range response.Hooks {
+			p.hooks[hook] = true
+		}
+		log.Infof("loaded plugin %s for %v", entry.Name(), response.Hooks)
+		plugins.list = append(plugins.list, p)
+	}
+	return
+}
+
+// run asks the plugin, killing it after the timeout
+func (p plugin) run(request PluginRequest) (response PluginResponse, err error) {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), plugins.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Dir = filepath.Dir(p.path)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return response, errors.New("plugin timed out after " + plugins.timeout.String())
+	} else if err != nil {
+		return response, errors.Wrap(err, "plugin: "+stderr.String())
+	}
+	if stdout.Len() > maxPluginOutput {
+		return response, errors.New("plugin answered too much")
+	}
+	if err = json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return response, errors.Wrap(err, "plugin answered")
+	}
+	if response.Error != "" {
+		err = errors.New(response.Error)
+	}
+	return
+}
+
+// pluginRender passes the markdown of a page through the render hooks
+func pluginRender(domain, page, markdown string) string {
+	for _, p := range plugins.list {
+		if !p.hooks[hookRender] {
+			continue
+		}
+		response, err := p.run(PluginRequest{Hook: hookRender, Domain: domain, Page: page, Data: markdown})
+		if err != nil {
+			log.Warnf("%s: %s", p.path, err)
+			continue
+		}
+		if response.Data != nil {
+			markdown = *response.Data
+		}
+	}
+	return markdown
+}
+
+// pluginSaved tells the save hooks about a saved page, in the background
+func pluginSaved(f db.File) {
+	for _, p := range plugins.list {
+		if !p.hooks[hookSave] {
+			continue
+		}
+		go func(p plugin) {
+			if _, err := p.run(PluginRequest{Hook: hookSave, Domain: f.Domain, Page: f.ID, Data: f.Data}); err != nil {
+				log.Warnf("%s: %s", p.path, err)
+			}
+		}(p)
+	}
+}
+
+// pluginAuth is whether an auth hook signs in to the domain with the
+// password
+func pluginAuth(domain, password string) bool {
+	for _, p := range plugins.list {
+		if !p.hooks[hookAuth] {
+			continue
+		}
+		response, err := p.run(PluginRequest{Hook: hookAuth, Domain: domain, Password: password})
+		if err != nil {
+			log.Warnf("%s: %s", p.path, err)
+			continue
+		}
+		if response.OK {
+			return true
+		}
+	}
+	return false
+}
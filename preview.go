@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// previewLength is how many characters of a page are previewed, unless
+	// the request asks for another length
+	previewLength    = 300
+	previewMaxLength = 2000
+)
+
+// APIPreview is the start of a page, rendered, to show when hovering
+// over a link to it
+type APIPreview struct {
+	ID       string    `json:"id"`
+	Slug     string    `json:"slug"`
+	Title    string    `json:"title"`
+	Modified time.Time `json:"modified"`
+	HTML     string    `json:"html"`
+	// Truncated is whether there is more to the page than the preview
+	Truncated bool `json:"truncated"`
+}
+
+// previewSkipped are the elements that are left out of previews, along
+// with what is in them
+var previewSkipped = map[string]bool{
+	"iframe": true, "video": true, "audio": true, "object": true,
+	"script": true, "style": true, "form": true, "textarea": true,
+}
+
+// voidElements have no end tag
+var voidElements = map[string]bool{
+	"area": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// truncateHTML keeps the HTML up to the first length characters of its
+// text, and closes the elements that are still open there
+func truncateHTML(s string, length int) (truncated string, cut bool) {
+	var b bytes.Buffer
+	var open []string
+	skipping := 0
+	chars := 0
+	z := html.NewTokenizer(strings.NewReader(s))
+tokens:
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		name, _ := z.TagName()
+		tag := string(name)
+		if skipping > 0 {
+			if tt == html.StartTagToken && !voidElements[tag] {
+				skipping++
+			} else if tt == html.EndTagToken {
+				skipping--
+			}
+			continue
+		}
+		switch tt {
+		case html.StartTagToken:
+			if previewSkipped[tag] {
+				skipping = 1
+				continue
+			}
+			if !voidElements[tag] {
+				open = append(open, tag)
+			}
+			b.Write(z.Raw())
+		case html.SelfClosingTagToken:
+			b.Write(z.Raw())
+		case html.EndTagToken:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == tag {
+					open = open[:i]
+					b.Write(z.Raw())
+					break
+				}
+			}
+		case html.TextToken:
+			text := []rune(string(z.Text()))
+			if chars+len(text) <= length {
+				chars += len(text)
+				b.WriteString(html.EscapeString(string(text)))
+				continue
+			}
+			kept := string(text[:length-chars])
+			// do not cut a word in half
+			if i := strings.LastIndex(kept, " "); i > 0 {
+				kept = kept[:i]
+			} else if chars > 0 && text[length-chars] != ' ' {
+				kept = ""
+			}
+			b.WriteString(html.EscapeString(strings.TrimRight(kept, " ,.;:")) + "…")
+			cut = true
+			break tokens
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String(), cut
+}
+
+// handleAPIPreview returns the start of the rendered ?page, by its slug
+// or id, for the hover cards of links to it
+func handleAPIPreview(w http.ResponseWriter, r *http.Request, domain string) (err error) {
+	query := r.URL.Query()
+	page := strings.TrimSpace(query.Get("page"))
+	if page == "" {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: "need a page"})
+	}
+	length := previewLength
+	if query.Get("length") != "" {
+		length, err = strconv.Atoi(query.Get("length"))
+		if err != nil || length < 1 || length > previewMaxLength {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: fmt.Sprintf("length must be between 1 and %d", previewMaxLength)})
+		}
+	}
+
+	editor := requestEditorID(r)
+	files, errGet := fs.Get(page, domain)
+	if errGet == nil {
+		files = readable(files, editor)
+	}
+	if errGet != nil || len(files) == 0 {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: "there is no page " + page})
+	}
+	f := files[0]
+	rendered, _ := viewRendering(domain, editor, f, nil)
+	preview := APIPreview{
+		ID:       f.ID,
+		Slug:     f.Slug,
+		Title:    f.DisplayName(),
+		Modified: f.Modified,
+	}
+	preview.HTML, preview.Truncated = truncateHTML(string(rendered), length)
+	return writeJSON(w, http.StatusOK, preview)
+}
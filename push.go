@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"golang.org/x/crypto/hkdf"
+)
+
+// pushDelay is how long after a page changes its subscribers are
+// notified, so that someone typing for a while sends one notification
+var pushDelay = 2 * time.Minute
+
+// pushContact is how push services can reach whoever runs the instance
+var pushContact = "https://github.com/schollz/rwtxt"
+
+// pushTTL is how long push services keep a notification for a browser
+// that is offline
+const pushTTL = 24 * time.Hour
+
+// vapidSetting is the setting that keeps the key that notifications are
+// signed with, which browsers subscribe to
+const vapidSetting = "vapid-key"
+
+var vapidKey *ecdsa.PrivateKey
+
+// loadVAPIDKey reads the key that notifications are signed with, making
+// it the first time
+func loadVAPIDKey() (err error) {
+	value, err := fs.Setting(vapidSetting)
+	if err != nil {
+		return
+	}
+	if value == "" {
+		key, errKey := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if errKey != nil {
+			return errKey
+		}
+		der, errKey := x509.MarshalPKCS8PrivateKey(key)
+		if errKey != nil {
+			return errKey
+		}
+		vapidKey = key
+		return fs.SetSetting(vapidSetting, base64.StdEncoding.EncodeToString(der))
+	}
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return errors.Wrap(err, vapidSetting)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return errors.Wrap(err, vapidSetting)
+	}
+	var ok bool
+	if vapidKey, ok = key.(*ecdsa.PrivateKey); !ok {
+		return errors.New(vapidSetting + " is not an ECDSA key")
+	}
+	return
+}
+
+// vapidPublicKey is the key that browsers subscribe with, or "" if
+// notifications are not sent
+func vapidPublicKey() string {
+	if vapidKey == nil {
+		return ""
+	}
+	public, err := vapidKey.PublicKey.ECDH()
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(public.Bytes())
+}
+
+// pushNotification is what the service worker shows
+type pushNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+}
+
+// pendingPushes are the pages that changed and whose subscribers are
+// notified once pushDelay passed, with who changed them
+var pendingPushes = struct {
+	sync.Mutex
+	editors map[string]map[string]bool
+}{editors: make(map[string]map[string]bool)}
+
+// pushChanged notifies the subscribers of the page, after a while, that
+// the editor changed it
+func pushChanged(f db.File, editor string) {
+	if vapidKey == nil {
+		return
+	}
+	pendingPushes.Lock()
+	defer pendingPushes.Unlock()
+	if editors, ok := pendingPushes.editors[f.ID]; ok {
+		editors[editor] = true
+		return
+	}
+	pendingPushes.editors[f.ID] = map[string]bool{editor: true}
+	time.AfterFunc(pushDelay, func() {
+		pendingPushes.Lock()
+		editors := pendingPushes.editors[f.ID]
+		delete(pendingPushes.editors, f.ID)
+		pendingPushes.Unlock()
+		notifySubscribers(f.ID, f.Domain, editors)
+	})
+}
+
+// notifySubscribers sends a notification to each browser subscribed to
+// the page, except to those of the only one who changed it
+func notifySubscribers(id, domain string, editors map[string]bool) {
+	subscriptions, err := fs.GetSubscriptions(id)
+	if err != nil || len(subscriptions) == 0 {
+		return
+	}
+	files, err := fs.Get(id, domain)
+	if err != nil || len(files) == 0 {
+		return
+	}
+	payload, _ := json.Marshal(pushNotification{
+		Title: files[0].DisplayName() + " changed",
+		Body:  "in " + domain,
+		URL:   "/" + domain + "/" + files[0].Slug,
+	})
+	for _, s := range subscriptions {
+		if len(editors) == 1 && editors[s.Editor] {
+			continue
+		}
+		err = sendPush(s, payload)
+		if err == errPushGone {
+			err = fs.Unsubscribe("", s.Endpoint)
+		}
+		if err != nil {
+			log.Debugf("push to %s: %s", s.Endpoint, err)
+		}
+	}
+}
+
+// errPushGone is when a browser is not subscribed anymore
+var errPushGone = errors.New("subscription is gone")
+
+// sendPush sends an encrypted notification to the push service of the
+// browser, signed with the VAPID key
+func sendPush(s db.PushSubscription, payload []byte) (err error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil || endpoint.Scheme != "https" {
+		return errors.New("push endpoints must be https")
+	}
+	body, err := encryptPush(s, payload)
+	if err != nil {
+		return
+	}
+	jwt, err := vapidJWT(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("TTL", strconv.Itoa(int(pushTTL.Seconds())))
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "vapid t="+jwt+", k="+vapidPublicKey())
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errPushGone
+	case resp.StatusCode >= 300:
+		return errors.New(resp.Status)
+	}
+	return
+}
+
+// vapidJWT signs a token that tells the push service of the audience who
+// sends the notification (RFC 8292)
+func vapidJWT(audience string) (jwt string, err error) {
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": pushContact,
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, vapidKey, hash[:])
+	if err != nil {
+		return
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// encryptPush encrypts the payload for the browser as a single aes128gcm
+// record (RFC 8291)
+func encryptPush(s db.PushSubscription, payload []byte) (body []byte, err error) {
+	browserKey, err := decodePushKey(s.Keys.P256dh)
+	if err != nil {
+		return
+	}
+	authSecret, err := decodePushKey(s.Keys.Auth)
+	if err != nil {
+		return
+	}
+	browserPublic, err := ecdh.P256().NewPublicKey(browserKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "p256dh")
+	}
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	secret, err := private.ECDH(browserPublic)
+	if err != nil {
+		return
+	}
+	public := private.PublicKey().Bytes()
+
+	ikm, err := hkdfKey(secret, authSecret, "WebPush: info\x00"+string(browserKey)+string(public), 32)
+	if err != nil {
+		return
+	}
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	cek, err := hkdfKey(ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return
+	}
+	nonce, err := hkdfKey(ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+
+	// the header is the salt, the record size and the key of the server,
+	// and the payload ends with the delimiter of the last record
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, 4096)
+	body = append(body, byte(len(public)))
+	body = append(body, public...)
+	return gcm.Seal(body, nonce, append(payload[:len(payload):len(payload)], 2), nil), nil
+}
+
+// hkdfKey derives a key of the length with HKDF-SHA256
+func hkdfKey(secret, salt []byte, info string, length int) (key []byte, err error) {
+	key = make([]byte, length)
+	_, err = io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key)
+	return
+}
+
+// decodePushKey decodes a key of a subscription, which browsers send in
+// base64url with or without padding
+func decodePushKey(key string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+}
+
+// handlePush subscribes (POST) and unsubscribes (DELETE) a browser to
+// notifications of changes of a page that it can read
+func (tr *TemplateRender) handlePush(w http.ResponseWriter, r *http.Request) (err error) {
+	if vapidKey == nil || readOnly() {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: "notifications are off"})
+	}
+	tr.Domain = strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	tr.SignedIn, _, _, _, _ = isSignedIn(w, r, tr.Domain)
+	f, err := tr.getReadableFile(r.URL.Query().Get("id"))
+	if err != nil {
+		return writeJSON(w, http.StatusNotFound, Payload{Message: err.Error()})
+	}
+	var s db.PushSubscription
+	if err = json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&s); err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: "not a subscription"})
+	}
+	if u, errURL := url.Parse(s.Endpoint); errURL != nil || u.Scheme != "https" {
+		return writeJSON(w, http.StatusBadRequest, Payload{Message: "push endpoints must be https"})
+	}
+	switch r.Method {
+	case "POST":
+		if _, errKey := decodePushKey(s.Keys.P256dh); errKey != nil || s.Keys.Auth == "" {
+			return writeJSON(w, http.StatusBadRequest, Payload{Message: "not a subscription"})
+		}
+		s.Editor = tr.EditorID
+		err = fs.Subscribe(f.ID, s)
+	case "DELETE":
+		err = fs.Unsubscribe(f.ID, s.Endpoint)
+	default:
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "method not allowed"})
+	}
+	if err != nil {
+		return writeJSON(w, http.StatusBadRequest, Payload{ID: f.ID, Message: err.Error()})
+	}
+	return writeJSON(w, http.StatusOK, Payload{ID: f.ID, Domain: tr.Domain, Message: "subscribed", Success: r.Method == "POST"})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/schollz/rwtxt/src/qr"
+)
+
+// qrScale is the size in pixels of the modules of QR codes
+const qrScale = 6
+
+// handleQR sends a QR code of the link to a page, to open it on a phone.
+// With the expiry and signature of a share link it is the code of the
+// share link, which anyone who has that link may see.
+func (tr *TemplateRender) handleQR(w http.ResponseWriter, r *http.Request) (err error) {
+	link := "/" + tr.Domain + "/" + tr.Page
+	if r.URL.Query().Get("sig") != "" {
+		if _, ok := validShare(link, r.URL.Query()); !ok {
+			http.Error(w, "the share link has expired", http.StatusForbidden)
+			return
+		}
+		q := url.Values{}
+		q.Set("expires", r.URL.Query().Get("expires"))
+		q.Set("sig", r.URL.Query().Get("sig"))
+		link += "?" + q.Encode()
+		setShareHeaders(w)
+	} else {
+		f, errGet := tr.getReadableFile(tr.Page)
+		if errGet != nil {
+			http.Error(w, errGet.Error(), http.StatusNotFound)
+			return
+		}
+		link = "/" + tr.Domain + "/" + f.ID
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	}
+	data, err := qr.PNG(absoluteURL(r, link), qrScale)
+	if err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	_, err = w.Write(data)
+	return
+}
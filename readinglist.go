@@ -0,0 +1,146 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// ReadingList is the reading list of a sign-in, and how far along it is
+type ReadingList struct {
+	Items []db.ReadingItem
+	Read  int
+}
+
+// Percent is how much of the reading list was read
+func (l ReadingList) Percent() int {
+	if len(l.Items) == 0 {
+		return 0
+	}
+	return l.Read * 100 / len(l.Items)
+}
+
+// APIReadingList is the reading list of a sign-in in the API
+type APIReadingList struct {
+	Items []APIReadingItem `json:"items"`
+	Read  int              `json:"read"`
+}
+
+// APIReadingItem is a page on a reading list, with when it was read
+type APIReadingItem struct {
+	File  APIFile    `json:"file"`
+	Added time.Time  `json:"added"`
+	Read  *time.Time `json:"read,omitempty"`
+}
+
+func newAPIReadingList(list *ReadingList) (apiList APIReadingList) {
+	apiList = APIReadingList{Items: []APIReadingItem{}, Read: list.Read}
+	for _, item := range list.Items {
+		apiItem := APIReadingItem{File: newAPIFiles([]db.File{item.File})[0], Added: item.Added}
+		if item.IsRead() {
+			read := item.Read
+			apiItem.Read = &read
+		}
+		apiList.Items = append(apiList.Items, apiItem)
+	}
+	return
+}
+
+// readingList returns the pages on the reading list of the key that the
+// editor may still read
+func readingList(key, editor string) (list *ReadingList, err error) {
+	items, err := fs.GetReadingList(key)
+	if err != nil {
+		return
+	}
+	files := make([]db.File, len(items))
+	for i, item := range items {
+		files[i] = item.File
+	}
+	canRead := make(map[string]bool)
+	for _, f := range readable(files, editor) {
+		canRead[f.ID] = true
+	}
+	list = new(ReadingList)
+	for _, item := range items {
+		if !canRead[item.File.ID] {
+			continue
+		}
+		list.Items = append(list.Items, item)
+		if item.IsRead() {
+			list.Read++
+		}
+	}
+	return
+}
+
+// handleAPIReadingList lists (GET) the reading list of the browser for
+// a domain it is signed in to, adds a page to it (POST), marks it as read
+// or unread (POST with read=1 or read=0), or takes it off (DELETE)
+func (tr *TemplateRender) handleAPIReadingList(w http.ResponseWriter, r *http.Request) (err error) {
+	domain := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	signedIn, key, _, _, _ := isSignedIn(w, r, domain)
+	if !signedIn || domain == "public" {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	method := formMethod(r)
+	message := "queued"
+	switch method {
+	case "GET":
+		list, errList := readingList(key, tr.EditorID)
+		if errList != nil {
+			return errList
+		}
+		return writeJSON(w, http.StatusOK, newAPIReadingList(list))
+	case "POST":
+		switch r.FormValue("read") {
+		case "1":
+			message = "read"
+			err = fs.SetRead(key, id, true)
+		case "0":
+			message = "unread"
+			err = fs.SetRead(key, id, false)
+		default:
+			err = fs.AddToReadingList(key, id)
+		}
+	case "DELETE":
+		message = "unqueued"
+		err = fs.RemoveFromReadingList(key, id)
+	default:
+		return writeJSON(w, http.StatusMethodNotAllowed, Payload{Message: "method not allowed"})
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, Payload{ID: id, Domain: domain, Message: err.Error()})
+		return
+	}
+	if redirect := r.FormValue("redirect"); redirect == "queue" {
+		http.Redirect(w, r, "/"+domain+"/queue", http.StatusSeeOther)
+		return
+	} else if redirect != "" {
+		http.Redirect(w, r, "/"+domain+"/"+id, http.StatusSeeOther)
+		return
+	}
+	return writeJSON(w, http.StatusOK, Payload{ID: id, Domain: domain, Message: message, Success: true})
+}
+
+// handleQueue shows the reading list of the browser for the domain
+func (tr *TemplateRender) handleQueue(w http.ResponseWriter, r *http.Request) (err error) {
+	if tr.Domain == "public" || !tr.SignedIn {
+		return tr.handleMain(w, r, "need to log in to keep a reading list")
+	}
+	tr.ReadingList, err = readingList(tr.DomainKey, tr.EditorID)
+	if err != nil {
+		return
+	}
+	tr.Title = tr.Domain + "/queue"
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return queueTemplate.Execute(gz, tr)
+}
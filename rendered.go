@@ -0,0 +1,85 @@
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// renderingHash is the hash of what the HTML of a page is made from: its
+// text, the options of its domain and the version of rwtxt. Pages that
+// are not rendered the same for everyone every time, because they have
+// macros or citations or are changed by plugins or scripts when they are
+// shown, have none.
+func renderingHash(domain string, f db.File) (hash string, ok bool) {
+	_, body := utils.FrontMatter(f.Data)
+	if strings.Contains(body, "{{") || strings.Contains(body, "[") && strings.Contains(body, "@") {
+		return
+	}
+	for _, p := range plugins.list {
+		if p.hooks[hookRender] {
+			return
+		}
+	}
+	options, _ := fs.GetDomainOptions(domain)
+	if options.Scripts && domain != "public" && !strings.HasPrefix(f.Slug, scriptFolder) {
+		return
+	}
+	return textHash(strings.Join([]string{
+		Version,
+		strconv.FormatBool(torMode),
+		options.Videos,
+		strconv.FormatBool(options.StrictMarkdown),
+		externalRel(domain, options),
+		strconv.FormatBool(options.MarkExternalLinks),
+		f.Data,
+	}, "\n")), true
+}
+
+// renderedRows is how many rows the editor of a page starts with
+func renderedRows(rendered template.HTML) int {
+	return strings.Count(string(rendered), "\n") + 2
+}
+
+// storeRendering renders a page that was saved and keeps its HTML, so
+// that it is not rendered again when it is viewed
+func storeRendering(f db.File) {
+	hash, ok := renderingHash(f.Domain, f)
+	if !ok || f.Data == "" {
+		return
+	}
+	rendered := renderPage(f.Domain, "", f, nil)
+	err := fs.SetRendering(db.Rendering{FileID: f.ID, HTML: string(rendered), Rows: renderedRows(rendered), Hash: hash})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// viewRendering returns the HTML of a page and the rows of its editor,
+// as it was kept when it was saved if it is still the same. Otherwise
+// the page is rendered, and kept if it can be.
+func viewRendering(domain, editor string, f db.File, query url.Values) (rendered template.HTML, rows int) {
+	hash, ok := renderingHash(domain, f)
+	if ok && f.Data != "" {
+		stored, err := fs.GetRendering(f.ID)
+		if err != nil {
+			log.Error(err)
+		} else if stored.Hash == hash {
+			return template.HTML(stored.HTML), stored.Rows
+		}
+	}
+	rendered = renderPage(domain, editor, f, query)
+	rows = renderedRows(rendered)
+	if ok && f.Data != "" && !readOnly() {
+		err := fs.SetRendering(db.Rendering{FileID: f.ID, HTML: string(rendered), Rows: rows, Hash: hash})
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	return
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// restoreChange is what restoring does to one page
+type restoreChange struct {
+	Domain string
+	Slug   string
+	Action string
+	File   db.File
+}
+
+const (
+	restoreChanged   = "change"
+	restoreAdded     = "add back"
+	restoreKept      = "keep, it is newer than what is restored"
+	restoreRemoved   = "remove, it is newer than the backup"
+	restoreUnchanged = "unchanged"
+)
+
+// parseRestoreTime parses times like "2018-11-01T12:00:00Z" or
+// "2018-11-01", which means the end of that day
+func parseRestoreTime(s string) (t time.Time, err error) {
+	if t, err = time.Parse(time.RFC3339, s); err == nil {
+		return
+	}
+	if t, err = time.Parse("2006-01-02", s); err == nil {
+		return t.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return t, errors.New("could not understand the time " + s + ", use 2006-01-02 or 2006-01-02T15:04:05Z")
+}
+
+// backupAt returns the latest backup made at or before t
+func backupAt(names []string, t time.Time) (name string, err error) {
+	for _, n := range names {
+		made, errTime := backupTime(n)
+		if errTime == nil && !made.After(t) && n > name {
+			name = n
+		}
+	}
+	if name == "" {
+		err = errors.New("there is no backup from before " + t.Format(time.RFC3339))
+	}
+	return
+}
+
+// planRestore compares the pages as they are with the pages as they
+// will be restored
+func planRestore(domain string, current, restored []db.File) (changes []restoreChange) {
+	byID := make(map[string]db.File)
+	for _, f := range current {
+		byID[f.ID] = f
+	}
+	for _, f := range restored {
+		f.Domain = domain
+		change := restoreChange{Domain: domain, Slug: f.Slug, File: f}
+		if f.Slug == "" {
+			change.Slug = f.ID
+		}
+		now, ok := byID[f.ID]
+		switch {
+		case !ok:
+			change.Action = restoreAdded
+		case now.Data == f.Data:
+			change.Action = restoreUnchanged
+		default:
+			change.Action = restoreChanged
+		}
+		delete(byID, f.ID)
+		changes = append(changes, change)
+	}
+	for _, f := range current {
+		if _, ok := byID[f.ID]; ok {
+			change := restoreChange{Domain: domain, Slug: f.Slug, Action: restoreKept, File: f}
+			if f.Slug == "" {
+				change.Slug = f.ID
+			}
+			changes = append(changes, change)
+		}
+	}
+	return
+}
+
+// pagesOf returns the pages of a domain, or just the page with the id
+// or slug if it is given
+func pagesOf(fs *db.FileSystem, domain, page string) (files []db.File, err error) {
+	if page == "" {
+		return fs.GetAllWithPrefix(domain, "", true)
+	}
+	exists, err := fs.Exists(page, domain)
+	if err != nil || !exists {
+		return
+	}
+	return fs.Get(page, domain)
+}
+
+// pagesAt returns the pages as they were at t, from their revisions
+func pagesAt(files []db.File, t time.Time) (restored []db.File, err error) {
+	for _, f := range files {
+		if f.Created.After(t) || len(f.History.GetSnapshots()) == 0 {
+			continue
+		}
+		f.Data, err = f.History.GetPreviousByTimestamp(t.UnixNano())
+		if err != nil {
+			return
+		}
+		restored = append(restored, f)
+	}
+	return
+}
+
+// runRestore is the "restore" command, which restores the database from
+// a backup, or a domain or a page from a backup or from its revisions
+func runRestore(args []string) (err error) {
+	set := flag.NewFlagSet("restore", flag.ExitOnError)
+	atFlag := set.String("at", "", "restore to how things were at this time, like 2018-11-01T12:00:00Z")
+	domain := set.String("domain", "", "only restore the pages of this domain")
+	page := set.String("page", "", "only restore this page of -domain, by its id or slug")
+	history := set.Bool("history", false, "restore the pages from their revisions instead of a backup")
+	dryRun := set.Bool("dry-run", false, "list what would change, without changing anything")
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "usage: rwtxt [flags] restore [-at time] [-domain name [-page id]] [-history] [-dry-run] [backup]")
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	if *page != "" && *domain == "" {
+		*domain = "public"
+	}
+	var at time.Time
+	if *atFlag != "" {
+		if at, err = parseRestoreTime(*atFlag); err != nil {
+			return
+		}
+	}
+
+	// the pages are restored from their revisions, or from a copy of
+	// the database restored from a backup
+	var source *db.FileSystem
+	var dump []byte
+	if *history {
+		if at.IsZero() {
+			return errors.New("set -at to the time to restore the revisions of")
+		}
+		if *domain == "" {
+			return errors.New("set -domain to the domain to restore the revisions of")
+		}
+	} else {
+		var names []string
+		if names, err = listBackups(); err != nil {
+			return
+		}
+		name := set.Arg(0)
+		switch {
+		case name != "" && !containsString(names, name):
+			return errors.Errorf("there is no backup named %s", name)
+		case name == "" && !at.IsZero():
+			if name, err = backupAt(names, at); err != nil {
+				return
+			}
+		case name == "" && len(names) == 0:
+			return errors.New("there are no backups in " + backupRemote)
+		case name == "":
+			name = names[len(names)-1]
+		}
+		fmt.Printf("restoring from %s\n", name)
+		if dump, err = downloadBackup(name); err != nil {
+			return
+		}
+		var tmp string
+		if source, tmp, err = openDump(dump); err != nil {
+			return
+		}
+		defer os.Remove(tmp)
+		defer os.Remove(tmp + ".sql.gz")
+		defer source.Close()
+	}
+
+	fs, err = db.New(dbName)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+
+	domains := []string{*domain}
+	if *domain == "" {
+		if domains, err = allDomainNames(fs, source); err != nil {
+			return
+		}
+	}
+	var changes []restoreChange
+	for _, d := range domains {
+		var current, restored []db.File
+		if current, err = pagesOf(fs, d, *page); err != nil {
+			return
+		}
+		if *history {
+			restored, err = pagesAt(current, at)
+		} else {
+			restored, err = pagesOf(source, d, *page)
+		}
+		if err != nil {
+			return
+		}
+		changes = append(changes, planRestore(d, current, restored)...)
+	}
+
+	for _, change := range changes {
+		if *domain == "" && change.Action == restoreKept {
+			// restoring everything replaces the whole database
+			change.Action = restoreRemoved
+		}
+		if change.Action != restoreUnchanged {
+			fmt.Printf("%s/%s: %s\n", change.Domain, change.Slug, change.Action)
+		}
+	}
+	if *dryRun {
+		return
+	}
+
+	if *domain == "" {
+		// everything is restored, including the domains and their keys
+		if err = fs.Restore(bytes.NewReader(dump)); err == nil {
+			fmt.Printf("restored %s\n", dbName)
+		}
+		return
+	}
+	for _, change := range changes {
+		if change.Action != restoreChanged && change.Action != restoreAdded {
+			continue
+		}
+		// saving keeps the current revisions, so this can be undone
+		change.File.Editor = db.InstanceEditor
+		if err = fs.Save(change.File); err != nil {
+			return errors.Wrap(err, "could not restore "+change.Domain+"/"+change.Slug)
+		}
+	}
+	fmt.Printf("restored %s\n", *domain)
+	return
+}
+
+// openDump loads a dump into a temporary database, to read from
+func openDump(dump []byte) (source *db.FileSystem, name string, err error) {
+	tmp, err := ioutil.TempFile("", "rwtxt-restore")
+	if err != nil {
+		return
+	}
+	name = tmp.Name()
+	tmp.Close()
+	if source, err = db.New(name); err != nil {
+		os.Remove(name)
+		return
+	}
+	if err = source.Restore(bytes.NewReader(dump)); err != nil {
+		source.Close()
+		os.Remove(name)
+		os.Remove(name + ".sql.gz")
+	}
+	return
+}
+
+// allDomainNames returns the names of the domains in any of the databases
+func allDomainNames(databases ...*db.FileSystem) (names []string, err error) {
+	seen := make(map[string]bool)
+	for _, d := range databases {
+		var domainNames []string
+		if domainNames, err = d.GetDomainNames(); err != nil {
+			return
+		}
+		for _, name := range domainNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return
+}
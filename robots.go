@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	log "github.com/cihub/seelog"
+)
+
+// allowIndexing lets public domains opt in to being indexed by search
+// engines, otherwise the whole site is closed to crawlers
+var allowIndexing bool
+
+// domainIndexable returns whether search engines may index the domain
+func domainIndexable(domain string) bool {
+	if !allowIndexing || domain == "public" {
+		return false
+	}
+	_, ispublic, err := fs.GetDomainFromName(domain)
+	if err != nil || !ispublic {
+		return false
+	}
+	options, err := fs.GetDomainOptions(domain)
+	return err == nil && options.AllowIndexing
+}
+
+// robotsPolicy returns the robots meta tag content for the domain
+func robotsPolicy(domain string) string {
+	if domainIndexable(domain) {
+		return "index, follow"
+	}
+	return "noindex, nofollow"
+}
+
+// handleRobots only lets crawlers into the domains that opted in
+func handleRobots(w http.ResponseWriter, r *http.Request) (err error) {
+	var b bytes.Buffer
+	b.WriteString("User-agent: *\n")
+	if allowIndexing {
+		domains, errGet := fs.GetIndexableDomains()
+		if errGet != nil {
+			log.Error(errGet)
+		}
+		for _, domain := range domains {
+			if domain == "public" {
+				continue
+			}
+			b.WriteString("Allow: /" + domain + "/\n")
+			b.WriteString("Allow: /" + domain + "$\n")
+		}
+		b.WriteString("Allow: /static/\n")
+	}
+	b.WriteString("Disallow: /\n")
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = w.Write(b.Bytes())
+	return
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// scriptFolder is where a domain keeps the Lua scripts that run when its
+// pages are saved and viewed, if its options let them
+const scriptFolder = "scripts/"
+
+// scriptTimeout is how long a script may run each time
+const scriptTimeout = time.Second
+
+// maxScriptString is the longest string a script may make with the
+// string and table libraries, or return
+const maxScriptString = 1 << 20
+
+// maxScriptTable is the longest list that table.insert may make
+const maxScriptTable = 1 << 16
+
+// maxScriptMemory is how much may be allocated while a script runs,
+// which bounds what it makes with .. and by growing its own tables
+const maxScriptMemory = 64 << 20
+
+// scriptAllocations is the metric of the bytes allocated by the process
+const scriptAllocations = "/gc/heap/allocs:bytes"
+
+// scriptFormatWidth matches the widths and precisions of string.format
+// that are longer than two digits, which Lua does not allow either
+var scriptFormatWidth = regexp.MustCompile(`%[-+ #0]*[0-9]{3,}|%[-+ #0]*[0-9]*\.[0-9]{3,}`)
+
+// The functions that scripts define to run when pages are saved and
+// viewed. They are given the text of the page and a table about it, and
+// return the text to save or show, or nothing to leave it as it is.
+const (
+	scriptOnSave = "on_save"
+	scriptOnView = "on_view"
+)
+
+// luaFence is a fenced block of Lua, which is the code of a script page
+// that has one
+var luaFence = regexp.MustCompile("(?s)```lua[ \t]*\r?\n(.*?)\r?\n```")
+
+// script is a page of the script folder
+type script struct {
+	name string
+	code string
+}
+
+// domainScripts returns the scripts of the domain, in the order of their
+// names, if the domain runs them
+func domainScripts(domain string) (scripts []script) {
+	if domain == "public" {
+		return
+	}
+	if options, err := fs.GetDomainOptions(domain); err != nil || !options.Scripts {
+		return
+	}
+	// the scripts are kept with the lists of pages of the domain, which
+	// are forgotten when its pages change
+	files, err := cachedFiles(domain, scriptFolder, func() ([]db.File, error) {
+		return fs.GetAllWithPrefix(domain, strings.TrimSuffix(scriptFolder, "/"), false)
+	})
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Slug, scriptFolder) {
+			continue
+		}
+		_, code := utils.FrontMatter(f.Data)
+		if blocks := luaFence.FindAllStringSubmatch(code, -1); len(blocks) > 0 {
+			code = ""
+			for _, block := range blocks {
+				code += block[1] + "\n"
+			}
+		}
+		scripts = append(scripts, script{name: f.Slug, code: code})
+	}
+	sort.Slice(scripts, func(i, j int) bool { return scripts[i].name < scripts[j].name })
+	return
+}
+
+// runScripts passes the text of the page through the function of each
+// script of the domain. Scripts do not run on the scripts themselves.
+func runScripts(function, domain string, f db.File, text string) string {
+	if strings.HasPrefix(f.Slug, scriptFolder) {
+		return text
+	}
+	for _, s := range domainScripts(domain) {
+		result, ok, err := s.run(function, domain, f, text)
+		if err != nil {
+			log.Warnf("%s/%s: %s", domain, s.name, err)
+		} else if ok {
+			text = result
+		}
+	}
+	return text
+}
+
+// run calls the function of the script in a new Lua state that has only
+// the base, string, table and math libraries, without files or loading
+// code. Its stack is small, the strings and lists that the libraries
+// make are capped, and it is stopped when it runs too long or allocates
+// too much.
+func (s script) run(function, domain string, f db.File, text string) (result string, ok bool, err error) {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:        true,
+		CallStackSize:       200,
+		RegistrySize:        1024,
+		RegistryMaxSize:     64 * 1024,
+		MinimizeStackMemory: true,
+	})
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	openScriptLibraries(L)
+	outOfMemory := watchScriptMemory(ctx, cancel)
+
+	defer func() {
+		if atomic.LoadInt32(outOfMemory) == 1 {
+			err = errors.New("the script used too much memory")
+		} else if ctx.Err() != nil {
+			err = errors.New("the script ran too long")
+		}
+	}()
+	if err = L.DoString(s.code); err != nil {
+		return
+	}
+	fn := L.GetGlobal(function)
+	if fn.Type() != lua.LTFunction {
+		return
+	}
+	page := L.NewTable()
+	page.RawSetString("id", lua.LString(f.ID))
+	page.RawSetString("slug", lua.LString(f.Slug))
+	page.RawSetString("title", lua.LString(f.DisplayName()))
+	page.RawSetString("domain", lua.LString(domain))
+	if err = L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, lua.LString(text), page); err != nil {
+		return
+	}
+	value := L.Get(-1)
+	L.Pop(1)
+	if value.Type() == lua.LTNil {
+		return
+	}
+	if value.Type() != lua.LTString {
+		return "", false, errors.New(function + " returned " + value.Type().String() + " instead of text")
+	}
+	if result = lua.LVAsString(value); len(result) > maxScriptString {
+		return "", false, errors.New(function + " returned too much text")
+	}
+	return result, true, nil
+}
+
+// watchScriptMemory stops the script, by cancelling its context, once
+// more than maxScriptMemory is allocated after it started, and then sets
+// what it returns to 1. The runtime only counts what the whole process
+// allocates, so other requests count against the script too, but the
+// script is stopped between two instructions before it can take all the
+// memory there is.
+func watchScriptMemory(ctx context.Context, cancel context.CancelFunc) (outOfMemory *int32) {
+	outOfMemory = new(int32)
+	sample := []metrics.Sample{{Name: scriptAllocations}}
+	metrics.Read(sample)
+	start := sample[0].Value.Uint64()
+	go func() {
+		tick := time.NewTicker(time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			metrics.Read(sample)
+			if sample[0].Value.Uint64()-start > maxScriptMemory {
+				atomic.StoreInt32(outOfMemory, 1)
+				cancel()
+				return
+			}
+		}
+	}()
+	return
+}
+
+// openScriptLibraries opens what scripts may use, and adds tags(text),
+// which returns the tags of markdown, and today(), which returns the
+// date like 2006-01-02
+func openScriptLibraries(L *lua.LState) {
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage", "print", "_printregs", "newproxy"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	capScriptLibraries(L)
+	L.SetGlobal("tags", L.NewFunction(func(L *lua.LState) int {
+		tags := L.NewTable()
+		for _, tag := range utils.Tags(L.CheckString(1)) {
+			tags.Append(lua.LString(tag))
+		}
+		L.Push(tags)
+		return 1
+	}))
+	L.SetGlobal("today", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(time.Now().Format("2006-01-02")))
+		return 1
+	}))
+}
+
+// capScriptLibraries checks what the functions of the string and table
+// libraries would make before they make it, so that a script can not
+// make a string longer than maxScriptString or a list longer than
+// maxScriptTable with them
+func capScriptLibraries(L *lua.LState) {
+	tooLong := func(L *lua.LState, name string, n int) {
+		if n > maxScriptString {
+			L.RaiseError("%s makes a string that is too long", name)
+		}
+	}
+	capFunction(L, lua.StringLibName, "rep", func(L *lua.LState) {
+		text, n := L.CheckString(1), L.CheckInt(2)
+		if n > 0 && len(text) > maxScriptString/n {
+			tooLong(L, "string.rep", maxScriptString+1)
+		}
+	})
+	capFunction(L, lua.StringLibName, "format", func(L *lua.LState) {
+		format := L.CheckString(1)
+		if scriptFormatWidth.MatchString(format) {
+			L.RaiseError("string.format has a width or precision that is too long")
+		}
+		n := len(format)
+		for i := 2; i <= L.GetTop(); i++ {
+			n += len(L.Get(i).String())
+		}
+		tooLong(L, "string.format", n)
+	})
+	capFunction(L, lua.StringLibName, "gsub", func(L *lua.LState) {
+		text := L.CheckString(1)
+		repl, ok := L.Get(3).(lua.LString)
+		if !ok {
+			return
+		}
+		// every match adds the replacement, and its captures add at most
+		// the text once for every % of the replacement
+		matches := len(text) + 1
+		if limit := L.OptInt(4, -1); limit >= 0 && limit < matches {
+			matches = limit
+		}
+		if len(repl) > 0 && matches > maxScriptString/len(repl) {
+			tooLong(L, "string.gsub", maxScriptString+1)
+		}
+		tooLong(L, "string.gsub", len(text)+matches*len(repl)+strings.Count(string(repl), "%")*len(text))
+	})
+	capFunction(L, lua.TabLibName, "concat", func(L *lua.LState) {
+		list := L.CheckTable(1)
+		n := len(L.OptString(2, "")) * list.Len()
+		list.ForEach(func(_, value lua.LValue) {
+			if s, ok := value.(lua.LString); ok {
+				n += len(s)
+			} else if number, ok := value.(lua.LNumber); ok {
+				n += len(number.String())
+			}
+		})
+		tooLong(L, "table.concat", n)
+	})
+	capFunction(L, lua.TabLibName, "insert", func(L *lua.LState) {
+		if L.CheckTable(1).Len() >= maxScriptTable {
+			L.RaiseError("table.insert makes a list that is too long")
+		}
+	})
+}
+
+// capFunction replaces a function of a library with one that checks its
+// arguments before calling it
+func capFunction(L *lua.LState, library, name string, check func(L *lua.LState)) {
+	lib, ok := L.GetGlobal(library).(*lua.LTable)
+	if !ok {
+		return
+	}
+	original, ok := lib.RawGetString(name).(*lua.LFunction)
+	if !ok || original.GFunction == nil {
+		return
+	}
+	lib.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+		check(L)
+		return original.GFunction(L)
+	}))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// maxWebhooks limits how many webhooks a domain can have
+const maxWebhooks = 5
+
+// handleSettings shows the settings of a domain, in sections that are
+// each saved with their own form so that one does not reset the others
+func (tr *TemplateRender) handleSettings(w http.ResponseWriter, r *http.Request) (err error) {
+	if tr.Domain == "public" {
+		return tr.handleMain(w, r, "cannot modify public")
+	}
+	_, ispublic, err := fs.GetDomainFromName(tr.Domain)
+	if err != nil || !tr.SignedIn {
+		return tr.handleMain(w, r, "need to log in to change settings")
+	}
+	tr.DomainIsPrivate = !ispublic
+	tr.DomainOptions, err = fs.GetDomainOptions(tr.Domain)
+	if err != nil {
+		return
+	}
+
+	if r.Method == "POST" {
+		// the key of the domain is sent with the form, so that other
+		// sites can not send it
+		if r.FormValue("domain_key") != tr.DomainKey {
+			http.Error(w, "the form is out of date", http.StatusForbidden)
+			return
+		}
+		tr.Message, err = tr.saveSettings(r.FormValue("section"), r)
+		if err != nil {
+			tr.Message = err.Error()
+			err = nil
+		}
+		_, ispublic, _ = fs.GetDomainFromName(tr.Domain)
+		tr.DomainIsPrivate = !ispublic
+		tr.DomainOptions, _ = fs.GetDomainOptions(tr.Domain)
+	}
+
+	tr.Keys, err = fs.GetKeys(tr.Domain, tr.DomainKey)
+	if err != nil {
+		return
+	}
+	tr.PageCount, err = fs.CountPages(tr.Domain)
+	if err != nil {
+		return
+	}
+	if tr.DomainOptions.Homepage != "" {
+		if f, ok := findHomepage(tr.Domain, tr.DomainOptions.Homepage); ok {
+			tr.Homepage = &f
+		}
+	}
+	tr.AccessLog = accessLogEnabled
+	if accessLogEnabled && tr.DomainOptions.AccessLog {
+		var visits db.AccessSummary
+		visits, err = accessSummary(tr.Domain, time.Now())
+		if err != nil {
+			return
+		}
+		tr.Visits = &visits
+	}
+	tr.Limits = limits
+	tr.Title = tr.Domain + "/settings"
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return settingsTemplate.Execute(gz, tr)
+}
+
+// saveSettings saves one section of the settings and returns what
+// happened
+func (tr *TemplateRender) saveSettings(section string, r *http.Request) (message string, err error) {
+	on := func(name string) bool {
+		return strings.TrimSpace(r.FormValue(name)) == "on"
+	}
+	options := tr.DomainOptions
+	switch section {
+	case "password":
+		password := strings.TrimSpace(r.FormValue("password"))
+		if password == "" {
+			return "the password can not be empty", nil
+		}
+		if password != strings.TrimSpace(r.FormValue("confirm")) {
+			return "the passwords are not the same", nil
+		}
+		err = fs.UpdateDomain(tr.Domain, password, !tr.DomainIsPrivate)
+		return "password updated", err
+	case "visibility":
+		err = fs.UpdateDomain(tr.Domain, "", on("ispublic"))
+		if err != nil {
+			return
+		}
+		options.AllowIndexing = on("indexable")
+	case "pages":
+		options.Homepage = ""
+		if name := strings.TrimSpace(r.FormValue("homepage")); name != "" {
+			f, ok := findHomepage(tr.Domain, name)
+			if !ok {
+				return "there is no one page " + name + " to open the domain with", nil
+			}
+			options.Homepage = f.ID
+		}
+		err = fs.SetUniqueSlugs(tr.Domain, on("uniqueslugs"))
+		if err != nil {
+			return
+		}
+		options.UniqueSlugs = on("uniqueslugs")
+		options.UnfurlLinks = on("unfurllinks")
+		options.LockEditing = on("lockediting")
+		options.StrictMarkdown = on("strictmarkdown")
+		options.Scripts = on("scripts")
+		options.Reminders = on("reminders")
+		options.LinkRel = cleanRel(r.FormValue("linkrel"))
+		options.MarkExternalLinks = on("markexternallinks")
+		if videos := r.FormValue("videos"); videos == db.VideosClickToLoad || videos == db.VideosEmbed || videos == db.VideosLink {
+			options.Videos = videos
+		}
+	case "theme":
+		theme := r.FormValue("theme")
+		if theme != db.ThemeLight && theme != db.ThemeDark && theme != db.ThemeAuto {
+			return "there is no such theme", nil
+		}
+		options.Theme = theme
+		tr.Theme = theme
+	case "tokens":
+		switch r.FormValue("action") {
+		case "new":
+			tr.NewKey, err = fs.NewKey(tr.Domain)
+			return "made a token, which is only shown now", err
+		case "revoke":
+			id, errID := strconv.Atoi(r.FormValue("id"))
+			if errID != nil {
+				return "there is no such token", nil
+			}
+			return "signed out", fs.DeleteDomainKey(tr.Domain, id)
+		case "others":
+			var deleted int64
+			deleted, err = fs.DeleteOtherKeys(tr.Domain, tr.DomainKey)
+			return "signed out " + strconv.FormatInt(deleted, 10) + " other browsers and tokens", err
+		}
+		return
+	case "webhooks":
+		switch r.FormValue("action") {
+		case "add":
+			link := strings.TrimSpace(r.FormValue("url"))
+			u, errURL := url.Parse(link)
+			if errURL != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return "a webhook needs an http or https address", nil
+			}
+			if len(options.Webhooks) >= maxWebhooks {
+				return "a domain can have " + strconv.Itoa(maxWebhooks) + " webhooks", nil
+			}
+			if containsString(options.Webhooks, link) {
+				return
+			}
+			options.Webhooks = append(options.Webhooks, link)
+			if options.WebhookSecret == "" {
+				options.WebhookSecret, err = newWebhookSecret()
+				if err != nil {
+					return
+				}
+			}
+		case "remove":
+			var webhooks []string
+			for _, webhook := range options.Webhooks {
+				if webhook != r.FormValue("url") {
+					webhooks = append(webhooks, webhook)
+				}
+			}
+			options.Webhooks = webhooks
+		}
+	case "visits":
+		options.AccessLog = on("accesslog")
+		if !options.AccessLog {
+			// what was counted goes along with the choice to count it
+			if err = fs.DeleteDomainAccesses(tr.Domain); err != nil {
+				return
+			}
+		}
+	case "config":
+		file, _, errFile := r.FormFile("file")
+		if errFile != nil {
+			return "choose a backup of the settings", nil
+		}
+		defer file.Close()
+		config, errConfig := readDomainConfig(file)
+		if errConfig != nil {
+			return errConfig.Error(), nil
+		}
+		var restored int
+		restored, err = restoreDomainConfig(tr.Domain, config)
+		if err != nil {
+			return
+		}
+		tr.Theme = config.Options.Theme
+		return restoredMessage(restored), nil
+	default:
+		return "there is no such setting", nil
+	}
+	err = fs.SetDomainOptions(tr.Domain, options)
+	if err != nil {
+		return
+	}
+	log.Debugf("updated the %s settings of %s", section, tr.Domain)
+	return "settings updated", nil
+}
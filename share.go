@@ -0,0 +1,193 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// shareSetting is the setting that keeps the key that share links are
+// signed with
+const shareSetting = "share-key"
+
+var shareKey []byte
+
+// shareHours are how long a share link may open a page, by default
+// a day and at most a week
+const (
+	shareHours    = 24
+	maxShareHours = 7 * 24
+)
+
+// ShareLink is a link that opens a page or an upload of a private domain
+// without signing in, until it expires
+type ShareLink struct {
+	Name string
+	Link string
+	// QR is the link to the QR code of the link, which only pages have
+	QR string
+}
+
+// shareUpload finds the uploads that a page links to, with their names
+var shareUpload = regexp.MustCompile(`/uploads/(sha256-[0-9a-f]{64})(?:\?filename=([^)\s"&]+))?`)
+
+// loadShareKey reads the key that share links are signed with, making
+// it the first time. A mirror only reads it, so that the links of the
+// site it mirrors open on it too.
+func loadShareKey() (err error) {
+	value, err := fs.Setting(shareSetting)
+	if err != nil {
+		return
+	}
+	if value == "" {
+		if readOnly() {
+			return
+		}
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return
+		}
+		shareKey = key
+		return fs.SetSetting(shareSetting, base64.StdEncoding.EncodeToString(key))
+	}
+	shareKey, err = base64.StdEncoding.DecodeString(value)
+	return errors.Wrap(err, shareSetting)
+}
+
+// shareSignature signs the path of a page or upload until it expires
+func shareSignature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, shareKey)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signLink returns the link to the path that opens it until it expires
+func signLink(path string, expires time.Time) string {
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", shareSignature(path, expires.Unix()))
+	return path + "?" + q.Encode()
+}
+
+// validShare returns whether the query signs the path and has not
+// expired, and when it expires
+func validShare(path string, query url.Values) (expires time.Time, ok bool) {
+	if len(shareKey) == 0 {
+		return
+	}
+	seconds, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return
+	}
+	expires = time.Unix(seconds, 0)
+	if time.Now().After(expires) {
+		return
+	}
+	return expires, hmac.Equal([]byte(query.Get("sig")), []byte(shareSignature(path, seconds)))
+}
+
+// setShareHeaders keeps what a share link opens out of caches, search
+// engines and the referrers of the links on it
+func setShareHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+}
+
+// handleShare makes links that open a page of a private domain, and the
+// uploads it links to, for a while without signing in
+func (tr *TemplateRender) handleShare(w http.ResponseWriter, r *http.Request) (err error) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/"+tr.Domain+"/"+tr.Page, 302)
+		return
+	}
+	if !tr.SignedIn {
+		return tr.handleMain(w, r, "need to log in to share pages")
+	}
+	if len(shareKey) == 0 {
+		return tr.handleMain(w, r, "pages can not be shared here")
+	}
+	f, err := tr.getReadableFile(tr.Page)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	hours, errHours := strconv.Atoi(r.FormValue("hours"))
+	if errHours != nil || hours < 1 {
+		hours = shareHours
+	} else if hours > maxShareHours {
+		hours = maxShareHours
+	}
+	tr.ShareExpires = time.Now().Add(time.Duration(hours) * time.Hour)
+
+	page := "/" + tr.Domain + "/" + f.ID
+	signed := signLink(page, tr.ShareExpires)
+	tr.ShareLinks = []ShareLink{{
+		Name: f.DisplayName(),
+		Link: absoluteURL(r, signed),
+		QR:   page + "/qr" + strings.TrimPrefix(signed, page),
+	}}
+	shared := make(map[string]bool)
+	for _, match := range shareUpload.FindAllStringSubmatch(f.Data, -1) {
+		if shared[match[1]] {
+			continue
+		}
+		shared[match[1]] = true
+		name, _ := url.QueryUnescape(match[2])
+		if name == "" {
+			name = match[1]
+		}
+		link := signLink("/uploads/"+match[1], tr.ShareExpires)
+		if match[2] != "" {
+			link += "&filename=" + match[2]
+		}
+		tr.ShareLinks = append(tr.ShareLinks, ShareLink{Name: name, Link: absoluteURL(r, link)})
+	}
+	tr.File = f
+	tr.Title = "Sharing " + f.DisplayName()
+	setShareHeaders(w)
+	return shareTemplate.Execute(w, tr)
+}
+
+// handleShared shows a page of a private domain to whoever has a share
+// link to it, as the reader view without scripts
+func (tr *TemplateRender) handleShared(w http.ResponseWriter, r *http.Request) (err error) {
+	expires, ok := validShare("/"+tr.Domain+"/"+tr.Page, r.URL.Query())
+	if !ok {
+		return tr.handleMain(w, r, "the share link has expired, ask for a new one")
+	}
+	files, err := fs.Get(tr.Page, tr.Domain)
+	if err != nil || len(files) != 1 {
+		return tr.handleMain(w, r, "there is no page "+tr.Page)
+	}
+	f := files[0]
+	if hidden, _ := fs.IsHidden(f.ID); hidden {
+		return tr.handleMain(w, r, "this page was hidden by a moderator")
+	}
+
+	tr.File = f
+	tr.Title = f.DisplayName()
+	tr.Shared, tr.ShareExpires, tr.NoScript = true, expires, true
+	// the page is shown without macros, which could bring in the other
+	// pages of the domain
+	_, body := utils.FrontMatter(f.Data)
+	tr.Rendered = renderMarkdown(tr.Domain, body)
+
+	setShareHeaders(w)
+	w.Header().Set("Content-Security-Policy", torScriptlessContentSecurity)
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return readerTemplate.Execute(gz, tr)
+}
@@ -0,0 +1,318 @@
+// Package bibtex reads BibTeX entries and turns pandoc-style citations
+// like [@key] in markdown into references to them.
+package bibtex
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Entry is one reference of a BibTeX file
+type Entry struct {
+	Type string
+	Key  string
+	// Fields are keyed by their lowercase name, without braces or quotes
+	Fields map[string]string
+}
+
+var (
+	entryStart = regexp.MustCompile(`@(\w+)\s*[{(]`)
+	spaces     = regexp.MustCompile(`\s+`)
+	and        = regexp.MustCompile(`\s+and\s+`)
+)
+
+// Parse returns the entries of the BibTeX text by their key. Anything
+// that is not an entry, like @comment, @string or text around the
+// entries, is skipped.
+func Parse(text string) (entries map[string]Entry) {
+	entries = make(map[string]Entry)
+	for _, loc := range entryStart.FindAllStringSubmatchIndex(text, -1) {
+		entryType := strings.ToLower(text[loc[2]:loc[3]])
+		if entryType == "comment" || entryType == "string" || entryType == "preamble" {
+			continue
+		}
+		body := text[loc[1]:]
+		comma := strings.IndexAny(body, ",}")
+		if comma < 0 || body[comma] != ',' {
+			continue
+		}
+		e := Entry{
+			Type:   entryType,
+			Key:    strings.TrimSpace(body[:comma]),
+			Fields: parseFields(body[comma+1:]),
+		}
+		if e.Key != "" {
+			entries[e.Key] = e
+		}
+	}
+	return
+}
+
+// parseFields reads name = value pairs up to the end of the entry
+func parseFields(body string) (fields map[string]string) {
+	fields = make(map[string]string)
+	i := 0
+	for i < len(body) {
+		// the name
+		for i < len(body) && strings.ContainsRune(" \t\r\n,", rune(body[i])) {
+			i++
+		}
+		if i >= len(body) || body[i] == '}' || body[i] == ')' || body[i] == '@' {
+			return
+		}
+		eq := strings.IndexByte(body[i:], '=')
+		if eq < 0 {
+			return
+		}
+		name := strings.ToLower(strings.TrimSpace(body[i : i+eq]))
+		i += eq + 1
+		for i < len(body) && strings.ContainsRune(" \t\r\n", rune(body[i])) {
+			i++
+		}
+		if i >= len(body) {
+			return
+		}
+
+		// the value, in braces, in quotes or bare
+		var value string
+		switch body[i] {
+		case '{':
+			depth, start := 0, i+1
+			for ; i < len(body); i++ {
+				if body[i] == '{' {
+					depth++
+				} else if body[i] == '}' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if i >= len(body) {
+				return
+			}
+			value = body[start:i]
+			i++
+		case '"':
+			end := strings.IndexByte(body[i+1:], '"')
+			if end < 0 {
+				return
+			}
+			value = body[i+1 : i+1+end]
+			i += end + 2
+		default:
+			end := strings.IndexAny(body[i:], ",}) \t\r\n")
+			if end < 0 {
+				end = len(body) - i
+			}
+			value = body[i : i+end]
+			i += end
+		}
+		value = strings.NewReplacer("{", "", "}", "", `\&`, "&", "~", " ", "--", "–").Replace(value)
+		fields[name] = strings.TrimSpace(spaces.ReplaceAllString(value, " "))
+	}
+	return
+}
+
+// name is a person as given and family name
+type name struct {
+	given, family string
+}
+
+// authors returns the authors of the entry, or its editors if it has no
+// authors
+func (e Entry) authors() (names []name) {
+	list := e.Fields["author"]
+	if list == "" {
+		list = e.Fields["editor"]
+	}
+	if list == "" {
+		return
+	}
+	for _, author := range and.Split(list, -1) {
+		author = strings.TrimSpace(author)
+		if parts := strings.SplitN(author, ",", 2); len(parts) == 2 {
+			names = append(names, name{given: strings.TrimSpace(parts[1]), family: strings.TrimSpace(parts[0])})
+		} else if i := strings.LastIndex(author, " "); i > 0 {
+			names = append(names, name{given: author[:i], family: author[i+1:]})
+		} else {
+			names = append(names, name{family: author})
+		}
+	}
+	return
+}
+
+// Year returns the year of the entry, from its year or its date
+func (e Entry) Year() string {
+	if e.Fields["year"] != "" {
+		return e.Fields["year"]
+	}
+	if date := e.Fields["date"]; len(date) >= 4 {
+		return date[:4]
+	}
+	return "n.d."
+}
+
+// Label is how the entry is cited, like "Smith and Jones 2020" or
+// "Smith et al. 2020"
+func (e Entry) Label() string {
+	names := e.authors()
+	var who string
+	switch len(names) {
+	case 0:
+		who = e.Fields["title"]
+		if who == "" {
+			who = e.Key
+		}
+	case 1:
+		who = names[0].family
+	case 2:
+		who = names[0].family + " and " + names[1].family
+	default:
+		who = names[0].family + " et al."
+	}
+	return who + " " + e.Year()
+}
+
+// Reference is the entry as markdown for a list of references
+func (e Entry) Reference() string {
+	var people []string
+	for _, n := range e.authors() {
+		people = append(people, strings.TrimSpace(n.given+" "+n.family))
+	}
+	reference := ""
+	if len(people) > 0 {
+		if len(people) > 1 {
+			people[len(people)-2] += " and " + people[len(people)-1]
+			people = people[:len(people)-1]
+		}
+		reference = escape(strings.Join(people, ", ")) + " "
+	}
+	reference += "(" + e.Year() + ")."
+	if title := e.Fields["title"]; title != "" {
+		reference += " " + escape(strings.TrimRight(title, ".")) + "."
+	}
+	for _, container := range []string{"journal", "booktitle", "publisher", "school", "institution"} {
+		if value := e.Fields[container]; value != "" {
+			reference += " *" + escape(value) + "*"
+			if e.Fields["volume"] != "" && container == "journal" {
+				reference += " " + escape(e.Fields["volume"])
+			}
+			if e.Fields["pages"] != "" {
+				reference += ", " + escape(e.Fields["pages"])
+			}
+			reference += "."
+			break
+		}
+	}
+	if doi := e.Fields["doi"]; doi != "" {
+		reference += " <https://doi.org/" + doi + ">"
+	} else if url := e.Fields["url"]; url != "" {
+		reference += " <" + url + ">"
+	}
+	return reference
+}
+
+// escape keeps text from being read as markdown
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", "&lt;").Replace(text)
+}
+
+var (
+	citation    = regexp.MustCompile(`\[([^\[\]]*@[^\[\]]+)\](\()?`)
+	citationKey = regexp.MustCompile(`^(.*?)-?@([\w:.#$%&+?<>~/-]*[\w])(.*)$`)
+	notAnchor   = regexp.MustCompile(`[^a-zA-Z0-9:_.-]`)
+)
+
+// Anchor is the id of the reference of the key
+func Anchor(key string) string {
+	return "ref-" + notAnchor.ReplaceAllString(key, "-")
+}
+
+// Cite replaces the citations of the markdown, like [@key],
+// [see @key, p. 33] or [@one; @two], with links to references that are
+// listed at the end. Citations of keys that are not entries are shown
+// with a question mark. Citations in code are left as they are.
+func Cite(markdown string, entries map[string]Entry) string {
+	if !strings.Contains(markdown, "@") {
+		return markdown
+	}
+	cited := make(map[string]bool)
+	lines := strings.Split(markdown, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || !strings.Contains(line, "@") {
+			continue
+		}
+		// parts with odd indices are inline code
+		parts := strings.Split(line, "`")
+		for j := 0; j < len(parts); j += 2 {
+			parts[j] = citation.ReplaceAllStringFunc(parts[j], func(m string) string {
+				match := citation.FindStringSubmatch(m)
+				if match[2] != "" {
+					// a link
+					return m
+				}
+				return cite(match[1], entries, cited)
+			})
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+	markdown = strings.Join(lines, "\n")
+	if len(cited) == 0 {
+		return markdown
+	}
+
+	var references []Entry
+	for key := range cited {
+		references = append(references, entries[key])
+	}
+	sort.Slice(references, func(i, j int) bool {
+		if a, b := strings.ToLower(references[i].Label()), strings.ToLower(references[j].Label()); a != b {
+			return a < b
+		}
+		return references[i].Key < references[j].Key
+	})
+	markdown = strings.TrimRight(markdown, "\n") + "\n\n## References\n"
+	for _, e := range references {
+		markdown += "\n" + `<span id="` + Anchor(e.Key) + `"></span>` + e.Reference() + "\n"
+	}
+	return markdown
+}
+
+// cite returns one citation, which may cite several entries separated
+// by semicolons. It is left alone if it does not look like a citation.
+func cite(text string, entries map[string]Entry, cited map[string]bool) string {
+	var citations []string
+	for _, part := range strings.Split(text, ";") {
+		match := citationKey.FindStringSubmatch(strings.TrimSpace(part))
+		if match == nil || (match[1] != "" && !strings.HasSuffix(match[1], " ")) {
+			// like an email address
+			return "[" + text + "]"
+		}
+		prefix, key, locator := strings.TrimSpace(match[1]), match[2], strings.TrimSpace(match[3])
+		e, ok := entries[key]
+		var c string
+		if ok {
+			cited[key] = true
+			c = "[" + escape(e.Label()) + "](#" + Anchor(key) + ")"
+		} else {
+			c = "**" + escape(key) + "?**"
+		}
+		if prefix != "" {
+			c = prefix + " " + c
+		}
+		if strings.HasPrefix(locator, ",") {
+			c += locator
+		} else if locator != "" {
+			c += " " + locator
+		}
+		citations = append(citations, c)
+	}
+	return "(" + strings.Join(citations, "; ") + ")"
+}
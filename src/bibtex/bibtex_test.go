@@ -0,0 +1,48 @@
+package bibtex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testBib = `Some notes about the references.
+
+@comment{ignored}
+
+@article{doe2020,
+  author = {Doe, Jane and John {van} Smith},
+  title = {On {Things}: a study},
+  journal = "Journal of Things",
+  volume = 12,
+  pages = {1--10},
+  year = 2020,
+}
+
+@book{roe2019, author = {Ann Roe and Bo Lee and Cy Poe}, title = {A Book}, publisher = {Press}, date = {2019-05-01}}
+`
+
+func TestParse(t *testing.T) {
+	entries := Parse(testBib)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "article", entries["doe2020"].Type)
+	assert.Equal(t, "On Things: a study", entries["doe2020"].Fields["title"])
+	assert.Equal(t, "1–10", entries["doe2020"].Fields["pages"])
+	assert.Equal(t, "Doe and Smith 2020", entries["doe2020"].Label())
+	assert.Equal(t, "Roe et al. 2019", entries["roe2019"].Label())
+	assert.Equal(t, "Jane Doe and John van Smith (2020). On Things: a study. *Journal of Things* 12, 1–10.", entries["doe2020"].Reference())
+}
+
+func TestCite(t *testing.T) {
+	md := Cite("As shown [see @doe2020, p. 3; @roe2019] and [@missing].\nMail [me@example.com] or `[@doe2020]`, [a link](http://a.com/@doe2020).", Parse(testBib))
+	assert.Equal(t, `As shown (see [Doe and Smith 2020](#ref-doe2020), p. 3; [Roe et al. 2019](#ref-roe2019)) and (**missing?**).
+Mail [me@example.com] or `+"`[@doe2020]`"+`, [a link](http://a.com/@doe2020).
+
+## References
+
+<span id="ref-doe2020"></span>Jane Doe and John van Smith (2020). On Things: a study. *Journal of Things* 12, 1–10.
+
+<span id="ref-roe2019"></span>Ann Roe, Bo Lee and Cy Poe (2019). A Book. *Press*.
+`, md)
+	assert.Equal(t, "no citations", Cite("no citations", nil))
+}
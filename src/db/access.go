@@ -0,0 +1,251 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The access levels of a page. Pages are shared by default, so that
+// everyone who can open the domain can read and change them. A drop box
+// is shared too, and anyone who can not open the domain can add to it
+// without reading it.
+const (
+	AccessShared   = ""
+	AccessReadOnly = "readonly"
+	AccessOwner    = "owner"
+	AccessDropBox  = "dropbox"
+)
+
+// ErrNoAccess is returned when someone may not read a page
+var ErrNoAccess = errors.New("only the owner of this page can open it")
+
+// ErrReadOnly is returned when someone may read a page but not change it
+var ErrReadOnly = errors.New("only the owner of this page can change it")
+
+// InstanceEditor saves pages as the instance itself, like restores and
+// synced folders do, which may change every page
+const InstanceEditor = "instance"
+
+// PageAccess is who may read and change a page. People are known by
+// the anonymous editor id of their browser.
+type PageAccess struct {
+	Level string
+	Owner string
+	// Editors may change the page as well as the owner
+	Editors []string
+}
+
+// CanRead returns whether the editor may read the page
+func (a PageAccess) CanRead(editor string) bool {
+	return a.Level != AccessOwner || a.CanWrite(editor)
+}
+
+// CanWrite returns whether the editor may change the page
+func (a PageAccess) CanWrite(editor string) bool {
+	if a.Level == AccessShared || a.Level == AccessDropBox || a.Owner == "" {
+		return true
+	}
+	if editor == "" {
+		return false
+	}
+	if editor == a.Owner {
+		return true
+	}
+	for _, e := range a.Editors {
+		if e == editor {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *FileSystem) initializePageAccess() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	pageaccess (
+		fsid TEXT NOT NULL PRIMARY KEY,
+		level TEXT,
+		owner TEXT,
+		editors TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating pageaccess table")
+	}
+	return
+}
+
+// GetPageAccess returns who may read and change the page
+func (fs *FileSystem) GetPageAccess(fileid string) (a PageAccess, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.getPageAccess(fileid)
+}
+
+func (fs *FileSystem) getPageAccess(fileid string) (a PageAccess, err error) {
+	var editors string
+	err = fs.db.QueryRow(`SELECT level, owner, editors FROM pageaccess WHERE fsid = ?`, fileid).Scan(&a.Level, &a.Owner, &editors)
+	if err == sql.ErrNoRows {
+		return PageAccess{}, nil
+	} else if err != nil {
+		return a, errors.Wrap(err, "GetPageAccess")
+	}
+	if editors != "" {
+		err = json.Unmarshal([]byte(editors), &a.Editors)
+	}
+	return
+}
+
+// SetPageAccess changes who may read and change the page
+func (fs *FileSystem) SetPageAccess(fileid string, a PageAccess) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if a.Level == AccessShared {
+		_, err = fs.db.Exec(`DELETE FROM pageaccess WHERE fsid = ?`, fileid)
+		return errors.Wrap(err, "SetPageAccess")
+	}
+	if a.Level != AccessReadOnly && a.Level != AccessOwner && a.Level != AccessDropBox {
+		return errors.New("unknown access " + a.Level)
+	}
+	var editors []string
+	for _, e := range a.Editors {
+		if e = strings.TrimSpace(e); e != "" && e != a.Owner {
+			editors = append(editors, e)
+		}
+	}
+	editorsJSON, _ := json.Marshal(editors)
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO pageaccess (fsid, level, owner, editors) VALUES (?, ?, ?, ?)`,
+		fileid, a.Level, a.Owner, string(editorsJSON))
+	if err != nil {
+		err = errors.Wrap(err, "SetPageAccess")
+	}
+	return
+}
+
+// Readable returns the files that the editor may read
+func (fs *FileSystem) Readable(files []File, editor string) (readable []File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	ids, err := fs.getAllFromPreparedQuerySingleString(`SELECT fsid FROM pageaccess WHERE level = ?`, AccessOwner)
+	if err != nil {
+		return nil, errors.Wrap(err, "Readable")
+	}
+	if len(ids) == 0 {
+		return files, nil
+	}
+	restricted := make(map[string]bool)
+	for _, id := range ids {
+		restricted[id] = true
+	}
+	readable = files[:0:0]
+	for _, f := range files {
+		if restricted[f.ID] {
+			var a PageAccess
+			if a, err = fs.getPageAccess(f.ID); err != nil {
+				return
+			}
+			if !a.CanRead(editor) {
+				continue
+			}
+		}
+		readable = append(readable, f)
+	}
+	return
+}
+
+// checkWrite returns why the editor may not change the page, if they may
+// not
+func (fs *FileSystem) checkWrite(fileid, editor string) (err error) {
+	if editor == InstanceEditor {
+		return
+	}
+	a, err := fs.getPageAccess(fileid)
+	if err != nil {
+		return
+	}
+	if !a.CanRead(editor) {
+		return ErrNoAccess
+	}
+	if !a.CanWrite(editor) {
+		return ErrReadOnly
+	}
+	return
+}
+
+// RenameEditor gives the pages, revisions and subscriptions of an editor
+// to their new id
+func (fs *FileSystem) RenameEditor(from, to string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "RenameEditor")
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		`UPDATE pageaccess SET owner = ? WHERE owner = ?`,
+		`UPDATE revisioneditors SET editor = ? WHERE editor = ?`,
+		`UPDATE pushsubscriptions SET editor = ? WHERE editor = ?`,
+	} {
+		if _, err = tx.Exec(query, to, from); err != nil {
+			return errors.Wrap(err, "RenameEditor")
+		}
+	}
+
+	// the other editors of a page are kept as a list
+	rows, err := tx.Query(`SELECT fsid, editors FROM pageaccess WHERE editors LIKE ?`, `%"`+from+`"%`)
+	if err != nil {
+		return errors.Wrap(err, "RenameEditor")
+	}
+	changed := make(map[string]string)
+	for rows.Next() {
+		var fsid, editorsJSON string
+		var editors []string
+		if err = rows.Scan(&fsid, &editorsJSON); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "RenameEditor")
+		}
+		if err = json.Unmarshal([]byte(editorsJSON), &editors); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "RenameEditor")
+		}
+		for i := range editors {
+			if editors[i] == from {
+				editors[i] = to
+			}
+		}
+		b, _ := json.Marshal(editors)
+		changed[fsid] = string(b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return errors.Wrap(err, "RenameEditor")
+	}
+	for fsid, editorsJSON := range changed {
+		if _, err = tx.Exec(`UPDATE pageaccess SET editors = ? WHERE fsid = ?`, editorsJSON, fsid); err != nil {
+			return errors.Wrap(err, "RenameEditor")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "RenameEditor")
+}
+
+// CheckAccess returns ErrNoAccess if the editor may not read the page,
+// or ErrReadOnly if they may read but want to change it and may not
+func (fs *FileSystem) CheckAccess(fileid, editor string, write bool) (err error) {
+	a, err := fs.GetPageAccess(fileid)
+	if err != nil {
+		return
+	}
+	if !a.CanRead(editor) {
+		return ErrNoAccess
+	}
+	if write && !a.CanWrite(editor) {
+		return ErrReadOnly
+	}
+	return
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Access counts the requests for a path of a domain on a day, by the
+// kind of client that made them. Nothing else about who made them is
+// kept.
+type Access struct {
+	// Day is like 2006-01-02, in UTC
+	Day    string
+	Domain string
+	Path   string
+	// Client is the kind of client, like "desktop", "mobile" or "bot"
+	Client string
+	Hits   int
+}
+
+// Hits is how many requests something had
+type Hits struct {
+	Name string
+	Hits int
+}
+
+// AccessSummary is what the access log of a domain shows since a day
+type AccessSummary struct {
+	Since   string
+	Total   int
+	Days    []Hits
+	Paths   []Hits
+	Clients []Hits
+}
+
+func (fs *FileSystem) initializeAccessLog() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	accesslog (
+		day TEXT NOT NULL,
+		domainid INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		client TEXT NOT NULL,
+		hits INTEGER DEFAULT 0,
+		PRIMARY KEY(domainid, day, path, client)
+	);
+	CREATE INDEX IF NOT EXISTS idx_accesslog_day ON accesslog(day);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating accesslog table")
+	}
+	return
+}
+
+// AddAccesses adds the hits to the access log. Accesses of domains that
+// do not exist are left out.
+func (fs *FileSystem) AddAccesses(accesses []Access) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "AddAccesses")
+	}
+	defer tx.Rollback()
+	domainids := make(map[string]int)
+	for _, a := range accesses {
+		domainid, ok := domainids[a.Domain]
+		if !ok {
+			domainid, _, _, _ = fs.getDomainFromName(a.Domain)
+			domainids[a.Domain] = domainid
+		}
+		if domainid == 0 {
+			continue
+		}
+		_, err = tx.Exec(`INSERT OR IGNORE INTO accesslog (day, domainid, path, client, hits) VALUES (?, ?, ?, ?, 0)`,
+			a.Day, domainid, a.Path, a.Client)
+		if err != nil {
+			return errors.Wrap(err, "AddAccesses")
+		}
+		_, err = tx.Exec(`UPDATE accesslog SET hits = hits + ? WHERE domainid = ? AND day = ? AND path = ? AND client = ?`,
+			a.Hits, domainid, a.Day, a.Path, a.Client)
+		if err != nil {
+			return errors.Wrap(err, "AddAccesses")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "AddAccesses")
+}
+
+// DeleteAccessesBefore deletes the access log of the days before the
+// day, and returns how many rows it deleted
+func (fs *FileSystem) DeleteAccessesBefore(day string) (deleted int64, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	result, err := fs.db.Exec(`DELETE FROM accesslog WHERE day < ?`, day)
+	if err != nil {
+		return 0, errors.Wrap(err, "DeleteAccessesBefore")
+	}
+	return result.RowsAffected()
+}
+
+// DeleteDomainAccesses deletes the access log of the domain
+func (fs *FileSystem) DeleteDomainAccesses(domain string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`DELETE FROM accesslog WHERE domainid = (SELECT id FROM domains WHERE name = ?)`, domain)
+	return errors.Wrap(err, "DeleteDomainAccesses")
+}
+
+// GetAccessSummary sums up the access log of the domain since the day,
+// with the hits of each day, of the top paths and of each kind of client
+func (fs *FileSystem) GetAccessSummary(domain, since string, top int) (summary AccessSummary, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	summary.Since = since
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	summary.Days, err = fs.getHits(`SELECT day, SUM(hits) FROM accesslog
+	WHERE domainid = ? AND day >= ? GROUP BY day ORDER BY day`, domainid, since)
+	if err != nil {
+		return
+	}
+	summary.Paths, err = fs.getHits(`SELECT path, SUM(hits) AS n FROM accesslog
+	WHERE domainid = ? AND day >= ? GROUP BY path ORDER BY n DESC, path LIMIT ?`, domainid, since, top)
+	if err != nil {
+		return
+	}
+	summary.Clients, err = fs.getHits(`SELECT client, SUM(hits) AS n FROM accesslog
+	WHERE domainid = ? AND day >= ? GROUP BY client ORDER BY n DESC, client`, domainid, since)
+	for _, day := range summary.Days {
+		summary.Total += day.Hits
+	}
+	return
+}
+
+func (fs *FileSystem) getHits(query string, args ...interface{}) (hits []Hits, err error) {
+	rows, err := fs.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAccessSummary")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h Hits
+		if err = rows.Scan(&h.Name, &h.Hits); err != nil {
+			return nil, errors.Wrap(err, "GetAccessSummary")
+		}
+		hits = append(hits, h)
+	}
+	return hits, errors.Wrap(rows.Err(), "GetAccessSummary")
+}
@@ -3,9 +3,13 @@ package db
 import (
 	"bufio"
 	"compress/gzip"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"html/template"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -14,14 +18,18 @@ import (
 	log "github.com/cihub/seelog"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/search"
 	"github.com/schollz/rwtxt/src/utils"
 	"github.com/schollz/sqlite3dump"
 	"github.com/schollz/versionedtext"
 )
 
 type FileSystem struct {
-	name string
-	db   *sql.DB
+	name   string
+	db     *sql.DB
+	limits Limits
+	// reserved reports whether a page may not be given a slug
+	reserved func(slug string) bool
 	sync.RWMutex
 }
 
@@ -36,6 +44,25 @@ type File struct {
 	History  versionedtext.VersionedText
 	DataHTML template.HTML
 	Views    int
+	Archived bool
+	Title    string
+	// Editor is the anonymous id of whoever is saving the file, and
+	// EditorIP their address. Both are kept with the revision that the
+	// save makes in a public domain.
+	Editor   string
+	EditorIP string
+}
+
+// DisplayName returns the title of the file, falling back
+// to its slug and then its id
+func (f File) DisplayName() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	if f.Slug != "" {
+		return f.Slug
+	}
+	return f.ID
 }
 
 // New will initialize a filesystem
@@ -89,7 +116,9 @@ func (fs *FileSystem) initializeDB() (err error) {
 			created TIMESTAMP,
 			modified TIMESTAMP,
 			history TEXT,
-			views INTEGER DEFAULT 0
+			views INTEGER DEFAULT 0,
+			archived INTEGER DEFAULT 0,
+			title TEXT
 		);`
 	_, err = fs.db.Exec(sqlStmt)
 	if err != nil {
@@ -97,6 +126,16 @@ func (fs *FileSystem) initializeDB() (err error) {
 		return
 	}
 
+	// columns added after the first release
+	err = fs.addColumn("fs", "archived", "INTEGER DEFAULT 0")
+	if err != nil {
+		return
+	}
+	err = fs.addColumn("fs", "title", "TEXT")
+	if err != nil {
+		return
+	}
+
 	sqlStmt = `CREATE VIRTUAL TABLE IF NOT EXISTS 
 		fts USING fts4 (id,data);`
 	_, err = fs.db.Exec(sqlStmt)
@@ -109,12 +148,17 @@ func (fs *FileSystem) initializeDB() (err error) {
 		id INTEGER NOT NULL PRIMARY KEY,
 		name TEXT,
 		hashed_pass TEXT,
-		ispublic INTEGER DEFAULT 0
+		ispublic INTEGER DEFAULT 0,
+		options TEXT
 	);`
 	_, err = fs.db.Exec(sqlStmt)
 	if err != nil {
 		err = errors.Wrap(err, "creating domains table")
 	}
+	err = fs.addColumn("domains", "options", "TEXT")
+	if err != nil {
+		return
+	}
 
 	sqlStmt = `CREATE TABLE IF NOT EXISTS
 	keys (
@@ -139,6 +183,11 @@ func (fs *FileSystem) initializeDB() (err error) {
 	if err != nil {
 		err = errors.Wrap(err, "creating domains table")
 	}
+	// blobs were always gzipped before this was stored
+	err = fs.addColumn("blobs", "compressed", "INTEGER DEFAULT 1")
+	if err != nil {
+		return
+	}
 
 	sqlStmt = `CREATE TABLE IF NOT EXISTS
 	similar (
@@ -148,7 +197,96 @@ func (fs *FileSystem) initializeDB() (err error) {
 	);`
 	_, err = fs.db.Exec(sqlStmt)
 	if err != nil {
-		err = errors.Wrap(err, "creating similarities table")
+		return errors.Wrap(err, "creating similarities table")
+	}
+	err = fs.initializeSimilar()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializePins()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeUnfurls()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeLinkStatuses()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeReports()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeRevisionEditors()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializePageAccess()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializePush()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeTasks()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeTags()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeEmbeddings()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeSummaries()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeUploads()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeRenderings()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeDayIndexes()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeReadingList()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeRestoredKeys()
+	if err != nil {
+		return
+	}
+
+	err = fs.initializeAccessLog()
+	if err != nil {
+		return
 	}
 
 	domainid, _, _, _ := fs.getDomainFromName("public")
@@ -157,10 +295,87 @@ func (fs *FileSystem) initializeDB() (err error) {
 		fs.UpdateDomain("public", "", true)
 	}
 
+	err = fs.backfillTitles()
+	if err != nil {
+		return
+	}
+
 	fs.DumpSQL()
 	return
 }
 
+// addColumn adds a column to a table created by a previous version,
+// doing nothing if the column already exists
+func (fs *FileSystem) addColumn(table, column, definition string) (err error) {
+	columns, err := fs.getAllFromPreparedQuerySingleString(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return errors.Wrap(err, "getting columns of "+table)
+	}
+	for _, c := range columns {
+		if c == column {
+			return
+		}
+	}
+	_, err = fs.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		err = errors.Wrap(err, "adding column "+column+" to "+table)
+	}
+	return
+}
+
+// backfillTitles sets the titles of files saved before titles were stored
+func (fs *FileSystem) backfillTitles() (err error) {
+	rows, err := fs.db.Query(`SELECT fs.id, fts.data FROM fs INNER JOIN fts ON fs.id=fts.id WHERE fs.title IS NULL`)
+	if err != nil {
+		return errors.Wrap(err, "backfillTitles")
+	}
+	titles := make(map[string]string)
+	for rows.Next() {
+		var id, data string
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "backfillTitles")
+		}
+		titles[id] = utils.Title(data)
+	}
+	rows.Close()
+	if len(titles) == 0 {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin backfillTitles")
+	}
+	stmt, err := tx.Prepare(`UPDATE fs SET title = ? WHERE id = ?`)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt backfillTitles")
+	}
+	defer stmt.Close()
+	for id, title := range titles {
+		_, err = stmt.Exec(title, id)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "exec backfillTitles")
+		}
+	}
+	return tx.Commit()
+}
+
+// EnableWAL switches the database to write-ahead logging, which tools
+// that replicate the database, like litestream, need
+func (fs *FileSystem) EnableWAL() (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	_, err = fs.db.Exec(`PRAGMA journal_mode = WAL; PRAGMA busy_timeout = 5000;`)
+	if err != nil {
+		err = errors.Wrap(err, "EnableWAL")
+	}
+	return
+}
+
 // DumpSQL will dump the SQL as text to filename.sql
 func (fs *FileSystem) DumpSQL() (err error) {
 	fs.Lock()
@@ -179,15 +394,26 @@ func (fs *FileSystem) DumpSQL() (err error) {
 	if err != nil {
 		return
 	}
-	gf := gzip.NewWriter(fi)
-	fw := bufio.NewWriter(gf)
-	err = sqlite3dump.DumpMigration(fs.db, fw)
-	fw.Flush()
-	gf.Close()
+	err = fs.dump(fi)
 	fi.Close()
 	return
 }
 
+// dump writes the rows of every table as gzipped SQL
+func (fs *FileSystem) dump(w io.Writer) (err error) {
+	gf := gzip.NewWriter(w)
+	fw := bufio.NewWriter(gf)
+	err = sqlite3dump.DumpMigration(fs.db, fw)
+	if err != nil {
+		return errors.Wrap(err, "dump")
+	}
+	err = fw.Flush()
+	if err != nil {
+		return errors.Wrap(err, "dump")
+	}
+	return gf.Close()
+}
+
 // NewFile returns a new file
 func (fs *FileSystem) NewFile(slug, data string) (f File) {
 	f = File{
@@ -200,8 +426,8 @@ func (fs *FileSystem) NewFile(slug, data string) (f File) {
 	return
 }
 
-// SaveBlob will save a blob
-func (fs *FileSystem) SaveBlob(id string, name string, blob []byte) (err error) {
+// SaveBlob will save a blob, which is gzipped if it is compressed
+func (fs *FileSystem) SaveBlob(id string, name string, blob []byte, compressed bool) (err error) {
 	fs.Lock()
 	defer fs.Unlock()
 
@@ -215,21 +441,25 @@ func (fs *FileSystem) SaveBlob(id string, name string, blob []byte) (err error)
 	(
 		id,
 		name,
-		data
+		data,
+		compressed
 	) 
 		VALUES 	
 	(
+		?,
 		?,
 		?,
 		?
 	)`)
 	if err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "stmt SaveBlob")
 	}
 	_, err = stmt.Exec(
-		id, name, blob,
+		id, name, blob, compressed,
 	)
 	if err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "exec SaveBlob")
 	}
 	defer stmt.Close()
@@ -240,17 +470,30 @@ func (fs *FileSystem) SaveBlob(id string, name string, blob []byte) (err error)
 	return
 }
 
-// GetBlob will save a blob
-func (fs *FileSystem) GetBlob(id string) (name string, data []byte, views int, err error) {
+// BlobExists reports whether a blob was saved with the id, without
+// reading it
+func (fs *FileSystem) BlobExists(id string) (exists bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	err = fs.db.QueryRow("SELECT EXISTS(SELECT 1 FROM blobs WHERE id = ?)", id).Scan(&exists)
+	if err != nil {
+		err = errors.Wrap(err, "BlobExists")
+	}
+	return
+}
+
+// GetBlob will get a blob, and whether it is gzipped
+func (fs *FileSystem) GetBlob(id string) (name string, data []byte, compressed bool, views int, err error) {
 	fs.Lock()
 	defer fs.Unlock()
 
-	stmt, err := fs.db.Prepare("SELECT name,data,views FROM blobs WHERE id = ?")
+	stmt, err := fs.db.Prepare("SELECT name,data,compressed,views FROM blobs WHERE id = ?")
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&name, &data, &views)
+	err = stmt.QueryRow(id).Scan(&name, &data, &compressed, &views)
 	if err != nil {
 		return
 	}
@@ -264,11 +507,13 @@ func (fs *FileSystem) GetBlob(id string) (name string, data []byte, views int, e
 	}
 	stmt, err = tx.Prepare("UPDATE blobs SET views=? WHERE id=?")
 	if err != nil {
+		tx.Rollback()
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(views+1, id)
 	if err != nil {
+		tx.Rollback()
 		return
 	}
 	err = tx.Commit()
@@ -276,35 +521,224 @@ func (fs *FileSystem) GetBlob(id string) (name string, data []byte, views int, e
 	return
 }
 
+// ErrOtherDomain is returned when a page is saved with the id of a page
+// of another domain
+var ErrOtherDomain = errors.New("a page of another domain has that id")
+
 // Save a file to the file system. Will insert or ignore, and then update.
+// The editor of the file must be allowed to change it, if only some are.
 func (fs *FileSystem) Save(f File) (err error) {
 	fs.Lock()
 	defer fs.Unlock()
+	if err = fs.checkWrite(f.ID, f.Editor); err != nil {
+		return
+	}
+	return fs.save(f)
+}
+
+// ChangeData changes the text of a file. It is read and saved at once,
+// so that no other change made at the same time is lost.
+func (fs *FileSystem) ChangeData(id, domain string, change func(data string) (string, error)) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	files, err := fs.get(id, domain)
+	if err != nil {
+		return
+	}
+	if len(files) != 1 {
+		return errors.New("no such file")
+	}
+	f := files[0]
+	f.Domain = domain
+	if f.Data, err = change(f.Data); err != nil {
+		return
+	}
+	return fs.save(f)
+}
 
+// Append adds text to the end of a file, after a blank line
+func (fs *FileSystem) Append(id, domain, text string) (err error) {
+	return fs.ChangeData(id, domain, func(data string) (string, error) {
+		if strings.TrimSpace(data) != "" {
+			data = strings.TrimRight(data, "\n") + "\n\n"
+		}
+		return data + text, nil
+	})
+}
+
+func (fs *FileSystem) save(f File) (err error) {
 	// get current history and then update the history
 	files, _ := fs.get(f.ID, f.Domain)
+	var lastEdit int64
 	if len(files) == 1 {
 		f.History = files[0].History
+		lastEdit = f.History.LastEditTime()
 		f.History.Update(f.Data)
 	} else {
 		f.History = versionedtext.NewVersionedText(f.Data)
 	}
+	f.Title = utils.Title(f.Data)
+
 	// make sure domain exists
 	if f.Domain == "" {
 		f.Domain = "public"
 	}
-	domainid, _, _, _ := fs.getDomainFromName(f.Domain)
+	domainid, _, ispublic, _ := fs.getDomainFromName(f.Domain)
 	if domainid == 0 {
 		return errors.New("domain does not exist")
 	}
+	// a page of another domain is never saved over
+	owner, err := fs.fileDomainID(f.ID)
+	if err != nil {
+		return
+	}
+	if owner != 0 && owner != domainid {
+		return ErrOtherDomain
+	}
+	err = fs.checkLimits(f, domainid, files)
+	if err != nil {
+		return
+	}
+
+	// a slug that is reserved is not given to a page, but a page keeps
+	// the slug it already has
+	slugTaken := false
+	if fs.reserved != nil && f.Slug != "" && fs.reserved(f.Slug) &&
+		!(len(files) == 1 && files[0].ID == f.ID && files[0].Slug == f.Slug) {
+		slugTaken = true
+		f.Slug = ""
+		if len(files) == 1 && files[0].ID == f.ID && !fs.reserved(files[0].Slug) {
+			f.Slug = files[0].Slug
+		}
+	}
+
+	historyBytes, _ := json.Marshal(f.History)
+	err = fs.saveRow(f, domainid, string(historyBytes))
+	if isUniqueConstraintError(err) {
+		// the domain requires unique slugs, so keep the content
+		// but not the slug that another file already has
+		slugTaken = true
+		f.Slug = ""
+		if len(files) == 1 && files[0].ID == f.ID {
+			f.Slug = files[0].Slug
+		}
+		err = fs.saveRow(f, domainid, string(historyBytes))
+	}
+	if err != nil {
+		return
+	}
+	if slugTaken {
+		defer func() {
+			if err == nil {
+				err = ErrSlugTaken
+			}
+		}()
+	}
+	// who changed pages is only kept where anyone can change them
+	keepEditor := (ispublic == 1 || f.Domain == "public") && f.Editor != InstanceEditor
+	if edited := f.History.LastEditTime(); keepEditor && (f.Editor != "" || f.EditorIP != "") && edited > lastEdit {
+		err = fs.saveRevisionEditor(f.ID, edited, f.Editor, f.EditorIP)
+		if err != nil {
+			return
+		}
+	}
+
+	err = fs.saveIndex(f, domainid)
+	if err != nil {
+		return
+	}
+	err = fs.saveTasks(f, domainid)
+	if err != nil {
+		return
+	}
+	err = fs.saveTags(f, domainid)
+	if err != nil {
+		return
+	}
+	return fs.saveSimilar(f, domainid)
+}
+
+// saveIndex saves the text of the file into the full text index, which
+// only changes the text of a page of the domain
+func (fs *FileSystem) saveIndex(f File, domainid int) (err error) {
+	// check if exists in fts
+	sqlStmt := "INSERT INTO fts(data,id) VALUES (?,?)"
+	args := []interface{}{f.Data, f.ID}
+	var ftsHasID bool
+	ftsHasID, err = fs.idExists(f.ID)
+	if err != nil {
+		return errors.Wrap(err, "doesExist")
+	}
+	if ftsHasID {
+		sqlStmt = "UPDATE fts SET data=? WHERE id=? AND id IN (SELECT id FROM fs WHERE domainid=?)"
+		args = append(args, domainid)
+	}
+
+	// update the index
+	tx3, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin virtual Save")
+	}
+	stmt3, err := tx3.Prepare(sqlStmt)
+	if err != nil {
+		tx3.Rollback()
+		return errors.Wrap(err, "stmt virtual update")
+	}
+	defer stmt3.Close()
+
+	_, err = stmt3.Exec(args...)
+	if err != nil {
+		tx3.Rollback()
+		return errors.Wrap(err, "exec virtual update")
+	}
+	err = tx3.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit virtual update")
+	}
+	return
+}
+
+// SetArchived will archive or unarchive a file, which hides it
+// from the listings of the domain without deleting it
+func (fs *FileSystem) SetArchived(id string, domain string, archived bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin SetArchived")
+	}
+	stmt, err := tx.Prepare(`
+	UPDATE fs SET archived = ? 
+	WHERE id = ? AND domainid IN (SELECT id FROM domains WHERE name = ?)`)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt SetArchived")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(archived, id, domain)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec SetArchived")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit SetArchived")
+	}
+	return
+}
 
+// saveRow inserts the file into the fs table, or updates it if
+// it already exists
+func (fs *FileSystem) saveRow(f File, domainid int, historyJSON string) (err error) {
 	tx, err := fs.db.Begin()
 	if err != nil {
 		return errors.Wrap(err, "begin Save")
 	}
 
 	stmt, err := tx.Prepare(`
-	INSERT OR IGNORE INTO
+	INSERT INTO
 		fs
 	(
 		id,
@@ -312,7 +746,8 @@ func (fs *FileSystem) Save(f File) (err error) {
 		slug,
 		created,
 		modified,
-		history
+		history,
+		title
 	) 
 		values 	
 	(
@@ -321,23 +756,25 @@ func (fs *FileSystem) Save(f File) (err error) {
 		?,
 		?,
 		?,
+		?,
 		?
-	)`)
+	) ON CONFLICT(id) DO NOTHING`)
 	if err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "stmt Save")
 	}
 
-	historyBytes, _ := json.Marshal(f.History)
-
 	_, err = stmt.Exec(
 		f.ID,
 		domainid,
 		f.Slug,
 		f.Created,
 		time.Now().UTC(),
-		string(historyBytes),
+		historyJSON,
+		f.Title,
 	)
 	if err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "exec Save")
 	}
 	defer stmt.Close()
@@ -355,11 +792,13 @@ func (fs *FileSystem) Save(f File) (err error) {
 	UPDATE fs SET 
 		slug = ?,
 		modified = ?,
-		history = ?
+		history = ?,
+		title = ?
 	WHERE
-		id = ?
+		id = ? AND domainid = ?
 	`)
 	if err != nil {
+		tx2.Rollback()
 		return errors.Wrap(err, "stmt update")
 	}
 	defer stmt2.Close()
@@ -367,52 +806,20 @@ func (fs *FileSystem) Save(f File) (err error) {
 	_, err = stmt2.Exec(
 		f.Slug,
 		time.Now().UTC(),
-		string(historyBytes),
+		historyJSON,
+		f.Title,
 		f.ID,
+		domainid,
 	)
 	if err != nil {
+		tx2.Rollback()
 		return errors.Wrap(err, "exec update")
 	}
 	err = tx2.Commit()
 	if err != nil {
 		return errors.Wrap(err, "commit update")
 	}
-
-	// check if exists in fts
-	sqlStmt := "INSERT INTO fts(data,id) VALUES (?,?)"
-	var ftsHasID bool
-	ftsHasID, err = fs.idExists(f.ID)
-	if err != nil {
-		return errors.Wrap(err, "doesExist")
-	}
-	if ftsHasID {
-		sqlStmt = "UPDATE fts SET data=? WHERE id=?"
-	}
-
-	// update the index
-	tx3, err := fs.db.Begin()
-	if err != nil {
-		return errors.Wrap(err, "begin virtual Save")
-	}
-	stmt3, err := tx3.Prepare(sqlStmt)
-	if err != nil {
-		return errors.Wrap(err, "stmt virtual update")
-	}
-	defer stmt3.Close()
-
-	_, err = stmt3.Exec(
-		f.Data,
-		f.ID,
-	)
-	if err != nil {
-		return errors.Wrap(err, "exec virtual update")
-	}
-	err = tx3.Commit()
-	if err != nil {
-		return errors.Wrap(err, "commit virtual update")
-	}
 	return
-
 }
 
 // Close will make sure that the lock file is closed
@@ -465,6 +872,23 @@ func (fs *FileSystem) SetKey(domain, password string) (key string, err error) {
 	if err != nil {
 		return
 	}
+	return fs.addKey(domainid)
+}
+
+// NewKey makes a key for the domain without its password, for someone
+// who signed in to it another way
+func (fs *FileSystem) NewKey(domain string) (key string, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	domainid, _, _, err := fs.getDomainFromName(strings.ToLower(domain))
+	if err != nil {
+		return
+	}
+	return fs.addKey(domainid)
+}
+
+// addKey makes a key for the domain
+func (fs *FileSystem) addKey(domainid int) (key string, err error) {
 	if domainid == 0 {
 		err = errors.New("domain does not exist")
 		return
@@ -475,31 +899,57 @@ func (fs *FileSystem) SetKey(domain, password string) (key string, err error) {
 	}
 	stmt, err := tx.Prepare("insert into keys(domainid,key,lastused) values(?, ?,?)")
 	if err != nil {
+		tx.Rollback()
 		return
 	}
 	defer stmt.Close()
-	key = utils.UUID()
+	key, err = newKey()
+	if err != nil {
+		tx.Rollback()
+		return
+	}
 	_, err = stmt.Exec(domainid, key, time.Now().UTC())
 	if err != nil {
+		tx.Rollback()
 		return
 	}
 	err = tx.Commit()
 	return
 }
 
-// DeleteOldKeys deletes keys older than 5 days
-func (fs *FileSystem) DeleteOldKeys() (err error) {
-	// first check if it is a domain
+// newKey makes a key, which is 128 random bits that can not be guessed
+func newKey() (key string, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		err = errors.Wrap(err, "could not make a key")
+		return
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DeleteOldKeys deletes the keys that were not used for the age, and
+// returns how many it deleted
+func (fs *FileSystem) DeleteOldKeys(age time.Duration) (deleted int64, err error) {
 	fs.Lock()
 	defer fs.Unlock()
 
-	// first purge the database of old stuff
-	stmt, err := fs.db.Prepare(`DELETE FROM keys WHERE lastused <= DATETIME('now','-5 days')`)
+	stmt, err := fs.db.Prepare(`DELETE FROM keys WHERE lastused <= DATETIME('now', ?)`)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec()
+	result, err := stmt.Exec(fmt.Sprintf("-%d seconds", int64(age.Seconds())))
+	if err != nil {
+		return
+	}
+	_, err = fs.db.Exec(`DELETE FROM restoredkeys WHERE lastused <= DATETIME('now', ?)`, fmt.Sprintf("-%d seconds", int64(age.Seconds())))
+	if err != nil {
+		return
+	}
+	deleted, err = result.RowsAffected()
+	if err == nil && deleted > 0 {
+		err = fs.deleteOrphanedReadingLists()
+	}
 	return
 }
 
@@ -516,6 +966,9 @@ func (fs *FileSystem) DeleteKey(key string) (err error) {
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(key)
+	if err == nil {
+		err = fs.deleteOrphanedReadingLists()
+	}
 	return
 }
 
@@ -530,11 +983,13 @@ func (fs *FileSystem) UpdateViews(f File) (err error) {
 	}
 	stmt, err := tx.Prepare("UPDATE fs SET views=? WHERE id=?")
 	if err != nil {
+		tx.Rollback()
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(f.Views+1, f.ID)
 	if err != nil {
+		tx.Rollback()
 		return
 	}
 	err = tx.Commit()
@@ -584,6 +1039,9 @@ func (fs *FileSystem) checkKey(key string) (domain string, err error) {
 	}
 	defer stmt.Close()
 	err = stmt.QueryRow(key).Scan(&domain)
+	if err == sql.ErrNoRows {
+		domain, err = fs.useRestoredKey(key)
+	}
 	if err != nil {
 		return
 	}
@@ -606,12 +1064,14 @@ func (fs *FileSystem) UpdateKeys(keys []string) (err error) {
 	for _, key := range keys {
 		stmt, errUpdate := tx.Prepare("UPDATE keys SET lastused=? WHERE key=?")
 		if errUpdate != nil {
+			tx.Rollback()
 			err = errUpdate
 			return
 		}
 		defer stmt.Close()
 		_, err = stmt.Exec(time.Now().UTC(), key)
 		if err != nil {
+			tx.Rollback()
 			return
 		}
 	}
@@ -641,15 +1101,18 @@ func (fs *FileSystem) setDomain(domain, password string) (err error) {
 
 	stmt, err := tx.Prepare(`INSERT INTO domains (name, hashed_pass, ispublic) VALUES (?,?,?)`)
 	if err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "stmt Save")
 	}
 
 	hashedPassword, err := utils.HashPassword(password)
 	if err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "can't hash password")
 	}
 	_, err = stmt.Exec(domain, hashedPassword, 0)
 	if err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "exec Save")
 	}
 	defer stmt.Close()
@@ -689,15 +1152,18 @@ func (fs *FileSystem) UpdateDomain(domain, password string, ispublic bool) (err
 		ispublic = ?
 		WHERE name = ?`)
 		if err != nil {
+			tx.Rollback()
 			return errors.Wrap(err, "stmt Save")
 		}
 		_, err = stmt.Exec(isPublicValue, domain)
 		if err != nil {
+			tx.Rollback()
 			return errors.Wrap(err, "exec Save")
 		}
 	} else {
 		hashedPassword, err := utils.HashPassword(password)
 		if err != nil {
+			tx.Rollback()
 			return errors.Wrap(err, "can't hash password")
 		}
 		stmt, err = tx.Prepare(`UPDATE domains 
@@ -706,10 +1172,12 @@ func (fs *FileSystem) UpdateDomain(domain, password string, ispublic bool) (err
 		ispublic = ?
 		WHERE name = ?`)
 		if err != nil {
+			tx.Rollback()
 			return errors.Wrap(err, "stmt Save")
 		}
 		_, err = stmt.Exec(hashedPassword, isPublicValue, domain)
 		if err != nil {
+			tx.Rollback()
 			return errors.Wrap(err, "exec Save")
 		}
 	}
@@ -760,6 +1228,17 @@ func (fs *FileSystem) GetDomainFromName(domain string) (domainid int, ispublic b
 	return
 }
 
+// GetDomainNames returns the names of all the domains
+func (fs *FileSystem) GetDomainNames() (domains []string, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	domains, err = fs.getAllFromPreparedQuerySingleString(`SELECT name FROM domains ORDER BY name`)
+	if err != nil {
+		err = errors.Wrap(err, "GetDomainNames")
+	}
+	return
+}
+
 func (fs *FileSystem) getDomainFromName(domain string) (domainid int, hashedPassword string, ispublic int, err error) {
 	// prepare statement
 	query := "SELECT id,hashed_pass,ispublic FROM domains WHERE name = ?"
@@ -794,61 +1273,12 @@ func (fs *FileSystem) getDomainFromName(domain string) (domainid int, hashedPass
 	return
 }
 
-func (fs *FileSystem) SetSimilar(id string, similarids []string) (err error) {
-	// first purge the database of previous similarities
-	stmt, err := fs.db.Prepare(`DELETE FROM similar WHERE fsid=?;`)
-	if err != nil {
-		return
-	}
-	_, err = stmt.Exec(id)
-	stmt.Close()
-	if err != nil {
-		return
-	}
-
-	for _, similarid := range similarids {
-		// inset new similarities
-		tx, err := fs.db.Begin()
-		if err != nil {
-			return errors.Wrap(err, "begin setsmiilar")
-		}
-		stmt, err := tx.Prepare(`
-	INSERT OR REPLACE INTO
-		similar
-	(
-		fsid,
-		fsid_similar
-	) 
-		VALUES 	
-	(
-		?,
-		?
-	)`)
-		if err != nil {
-			return errors.Wrap(err, "stmt setsimilar")
-		}
-		_, err = stmt.Exec(
-			id, similarid,
-		)
-		if err != nil {
-			return errors.Wrap(err, "exec setsimilar")
-		}
-		defer stmt.Close()
-		err = tx.Commit()
-		if err != nil {
-			return errors.Wrap(err, "commit setsimilar")
-		}
-	}
-
-	return
-}
-
 // GetAll returns all the files for a given domain
 func (fs *FileSystem) GetAll(domain string) (files []File, err error) {
 	fs.Lock()
 	defer fs.Unlock()
 	return fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	INNER JOIN domains ON fs.domainid=domains.id
 	WHERE 
@@ -857,20 +1287,68 @@ func (fs *FileSystem) GetAll(domain string) (files []File, err error) {
 	ORDER BY fs.modified DESC`, domain)
 }
 
-// GetSimilar returns all the files for a given domain
+// GetAllWithPrefix returns all the files for a given domain whose slug is
+// the prefix or is nested beneath it, e.g. "projects" matches "projects/alpha".
+// Archived files are left out unless includeArchived is set.
+func (fs *FileSystem) GetAllWithPrefix(domain string, prefix string, includeArchived bool) (files []File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs 
+	INNER JOIN fts ON fs.id=fts.id 
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE 
+		domains.name = ?
+		AND (? = '' OR fs.slug = ? OR fs.slug LIKE ? ESCAPE '\')
+		AND (? OR fs.archived = 0)
+		AND LENGTH(fts.data) > 0
+	ORDER BY fs.modified DESC`, domain, prefix, prefix, likePrefix(prefix), includeArchived)
+}
+
+// GetModifiedSince returns up to limit files of the domain, in the order
+// they were last modified. It starts after the file afterID that was
+// modified at after, so that files modified at the same time are neither
+// skipped nor repeated. An empty afterID starts with the files modified at after.
+func (fs *FileSystem) GetModifiedSince(domain string, after time.Time, afterID string, limit int) (files []File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	after = after.UTC()
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs 
+	INNER JOIN fts ON fs.id=fts.id 
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE 
+		domains.name = ?
+		AND (fs.modified > ? OR (fs.modified = ? AND fs.id > ?))
+		AND LENGTH(fts.data) > 0
+	ORDER BY fs.modified ASC, fs.id ASC LIMIT ?`, domain, after, after, afterID, limit)
+}
+
+// likePrefix escapes a slug prefix for matching nested slugs with LIKE
+func likePrefix(prefix string) string {
+	return likeEscape(prefix) + "/%"
+}
+
+// likeEscape escapes the wildcards of LIKE in the text
+func likeEscape(text string) string {
+	text = strings.Replace(text, `\`, `\\`, -1)
+	text = strings.Replace(text, `%`, `\%`, -1)
+	return strings.Replace(text, `_`, `\_`, -1)
+}
+
+// GetSimilar returns the files most similar to the file, the most
+// similar first
 func (fs *FileSystem) GetSimilar(fileid string) (files []File, err error) {
 	fs.Lock()
 	defer fs.Unlock()
 	return fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
+	INNER JOIN similar ON similar.fsid_similar=fs.id
 	WHERE 
 		LENGTH(fts.data) > 0
-	AND 
-		fs.id IN (
-			SELECT fsid_similar FROM similar WHERE fsid = ?
-		)
-	ORDER BY fs.modified DESC`, fileid)
+		AND similar.fsid = ?
+	ORDER BY similar.score DESC, fs.modified DESC`, fileid)
 }
 
 // GetTopX returns the info from a file
@@ -878,11 +1356,12 @@ func (fs *FileSystem) GetTopX(domain string, num int) (files []File, err error)
 	fs.Lock()
 	defer fs.Unlock()
 	return fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	INNER JOIN domains ON fs.domainid=domains.id
 	WHERE 
 		domains.name = ?
+		AND fs.archived = 0
 		AND LENGTH(fts.data) > 0
 	ORDER BY fs.modified DESC LIMIT ?`, domain, num)
 }
@@ -892,11 +1371,12 @@ func (fs *FileSystem) GetTopXMostViews(domain string, num int) (files []File, er
 	fs.Lock()
 	defer fs.Unlock()
 	return fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	INNER JOIN domains ON fs.domainid=domains.id
 	WHERE 
 		domains.name = ?
+		AND fs.archived = 0
 		AND LENGTH(fts.data) > 0
 	ORDER BY fs.views DESC LIMIT ?`, domain, num)
 }
@@ -911,7 +1391,7 @@ func (fs *FileSystem) Get(id string, domain string) (files []File, err error) {
 func (fs *FileSystem) get(id string, domain string) (files []File, err error) {
 
 	files, err = fs.getAllFromPreparedQuery(`
-		SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views FROM fs 
+		SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs 
 		INNER JOIN fts ON fs.id=fts.id 
 		INNER JOIN domains ON fs.domainid=domains.id
 		WHERE 
@@ -928,7 +1408,7 @@ func (fs *FileSystem) get(id string, domain string) (files []File, err error) {
 	}
 
 	files, err = fs.getAllFromPreparedQuery(`
-	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title
 	FROM fs 
 	INNER JOIN fts ON fs.id=fts.id 
 	INNER JOIN domains ON fs.domainid=domains.id
@@ -988,7 +1468,7 @@ func (fs *FileSystem) Find(text string, domain string) (files []File, err error)
 	defer fs.Unlock()
 
 	files, err = fs.getAllFromPreparedQuery(`
-		SELECT fs.id,fs.slug,fs.created,fs.modified,snippet(fts),fs.history,fs.views FROM fts 
+		SELECT fs.id,fs.slug,fs.created,fs.modified,snippet(fts),fs.history,fs.views,fs.archived,fs.title FROM fts 
 			INNER JOIN fs ON fs.id=fts.id 
 			INNER JOIN domains ON fs.domainid=domains.id
 			WHERE fts.data MATCH ?
@@ -997,6 +1477,152 @@ func (fs *FileSystem) Find(text string, domain string) (files []File, err error)
 	return
 }
 
+// FindWithPrefix returns the files matching the text whose slug is
+// the prefix or is nested beneath it. Archived files are left out unless
+// includeArchived is set.
+func (fs *FileSystem) FindWithPrefix(text string, domain string, prefix string, includeArchived bool) (files []File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	files, err = fs.getAllFromPreparedQuery(`
+		SELECT fs.id,fs.slug,fs.created,fs.modified,snippet(fts),fs.history,fs.views,fs.archived,fs.title FROM fts 
+			INNER JOIN fs ON fs.id=fts.id 
+			INNER JOIN domains ON fs.domainid=domains.id
+			WHERE fts.data MATCH ?
+			AND domains.name = ?
+			AND (? = '' OR fs.slug = ? OR fs.slug LIKE ? ESCAPE '\')
+			AND (? OR fs.archived = 0)
+			ORDER BY modified DESC`, text, domain, prefix, prefix, likePrefix(prefix), includeArchived)
+	return
+}
+
+// Search returns the files of the domain that match the search, most
+// recently modified first, with snippets of where their text matched.
+// Like FindWithPrefix it can be limited to a prefix and leaves out
+// archived files unless includeArchived is set.
+func (fs *FileSystem) Search(q search.Query, domain string, prefix string, includeArchived bool) (files []File, err error) {
+	snippet, where, args := searchConditions(q, prefix, includeArchived)
+	where = append([]string{"domains.name = ?"}, where...)
+	args = append([]interface{}{domain}, args...)
+
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.getAllFromPreparedQuery(`
+		SELECT fs.id,fs.slug,fs.created,fs.modified,`+snippet+`,fs.history,fs.views,fs.archived,fs.title FROM fts 
+			INNER JOIN fs ON fs.id=fts.id 
+			INNER JOIN domains ON fs.domainid=domains.id
+			WHERE `+strings.Join(where, "\n\t\t\tAND ")+`
+			ORDER BY fs.modified DESC`, args...)
+}
+
+// SearchDomains returns the files of the domains that match the search,
+// with their domain, by the name of their domain and then most recently
+// modified first
+func (fs *FileSystem) SearchDomains(q search.Query, domains []string, includeArchived bool) (files []File, err error) {
+	if len(domains) == 0 {
+		return []File{}, nil
+	}
+	snippet, where, conditionArgs := searchConditions(q, "", includeArchived)
+	var selects []string
+	var args []interface{}
+	for _, domain := range domains {
+		selects = append(selects, `
+		SELECT fs.id,fs.slug,fs.created,fs.modified,`+snippet+`,fs.history,fs.views,fs.archived,fs.title,domains.name FROM fts 
+			INNER JOIN fs ON fs.id=fts.id 
+			INNER JOIN domains ON fs.domainid=domains.id
+			WHERE `+strings.Join(append([]string{"domains.name = ?"}, where...), "\n\t\t\tAND "))
+		args = append(append(args, domain), conditionArgs...)
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.getAllFromPreparedQuery(strings.Join(selects, "\n\t\tUNION ALL")+`
+		ORDER BY 10, 4 DESC`, args...)
+}
+
+// searchConditions returns what to select as the text of the files that
+// match the search, and the conditions on fs and fts with their
+// arguments
+func searchConditions(q search.Query, prefix string, includeArchived bool) (snippet string, where []string, args []interface{}) {
+	snippet = "''"
+	where = []string{"LENGTH(fts.data) > 0"}
+	if match := q.Match(); match != "" {
+		snippet = "snippet(fts)"
+		where = append(where, "fts.data MATCH ?")
+		args = append(args, match)
+	}
+	if prefix != "" {
+		where = append(where, `(fs.slug = ? OR fs.slug LIKE ? ESCAPE '\')`)
+		args = append(args, prefix, likePrefix(prefix))
+	}
+	if !includeArchived {
+		where = append(where, "fs.archived = 0")
+	}
+	for _, title := range q.Titles {
+		where = append(where, `LOWER(fs.title) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscape(title)+"%")
+	}
+	for _, tag := range q.Tags {
+		where = append(where, "fs.id IN (SELECT fsid FROM tags WHERE domainid = fs.domainid AND tag = ?)")
+		args = append(args, tag)
+	}
+	if !q.After.IsZero() {
+		where = append(where, "fs.modified >= ?")
+		args = append(args, q.After.UTC())
+	}
+	if !q.Before.IsZero() {
+		where = append(where, "fs.modified < ?")
+		args = append(args, q.Before.UTC())
+	}
+	return
+}
+
+// SearchAny returns the files of the domain that are not archived and
+// have any of the words, with their text, to rank them by how well they
+// match
+func (fs *FileSystem) SearchAny(domain string, words []string) (files []File, err error) {
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.Replace(word, `"`, "", -1); word != "" {
+			terms = append(terms, `"`+word+`"`)
+		}
+	}
+	if len(terms) == 0 {
+		return
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fts 
+	INNER JOIN fs ON fs.id=fts.id 
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE 
+		domains.name = ?
+		AND fts.data MATCH ?
+		AND fs.archived = 0
+	ORDER BY fs.modified DESC`, domain, strings.Join(terms, " OR "))
+}
+
+// FindSlugs returns up to limit files whose slug starts with the text or
+// whose title contains it, most viewed first, for completing links
+func (fs *FileSystem) FindSlugs(text string, domain string, limit int) (files []File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	text = likeEscape(strings.ToLower(text))
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,'',fs.history,fs.views,fs.archived,fs.title FROM fs 
+	INNER JOIN fts ON fs.id=fts.id 
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE 
+		domains.name = ?
+		AND (fs.slug LIKE ? ESCAPE '\' OR LOWER(fs.title) LIKE ? ESCAPE '\')
+		AND fs.archived = 0
+		AND LENGTH(fts.data) > 0
+	ORDER BY fs.views DESC, fs.modified DESC LIMIT ?`, domain, text+"%", "%"+text+"%", limit)
+}
+
 // Exists returns whether specified ID exists exists
 func (fs *FileSystem) idExists(id string) (exists bool, err error) {
 	files, err := fs.getAllFromPreparedQuerySingleString(`
@@ -1041,6 +1667,9 @@ func (fs *FileSystem) Exists(id string, domain string) (exists bool, err error)
 	return
 }
 
+// getAllFromPreparedQuery returns the files of the query, which selects
+// the id, slug, created, modified, data, history, views, archived and
+// title of each, and may select the name of its domain after them
 func (fs *FileSystem) getAllFromPreparedQuery(query string, args ...interface{}) (files []File, err error) {
 	// prepare statement
 	stmt, err := fs.db.Prepare(query)
@@ -1058,11 +1687,16 @@ func (fs *FileSystem) getAllFromPreparedQuery(query string, args ...interface{})
 
 	// loop through rows
 	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		err = errors.Wrap(err, "get columns of files")
+		return
+	}
 	files = []File{}
 	for rows.Next() {
 		var f File
-		var history sql.NullString
-		err = rows.Scan(
+		var history, title sql.NullString
+		dest := []interface{}{
 			&f.ID,
 			&f.Slug,
 			&f.Created,
@@ -1070,7 +1704,13 @@ func (fs *FileSystem) getAllFromPreparedQuery(query string, args ...interface{})
 			&f.Data,
 			&history,
 			&f.Views,
-		)
+			&f.Archived,
+			&title,
+		}
+		if len(columns) > len(dest) {
+			dest = append(dest, &f.Domain)
+		}
+		err = rows.Scan(dest...)
 		if err != nil {
 			err = errors.Wrap(err, "get rows of file")
 			return
@@ -1082,6 +1722,7 @@ func (fs *FileSystem) getAllFromPreparedQuery(query string, args ...interface{})
 				return
 			}
 		}
+		f.Title = title.String
 		f.DataHTML = template.HTML(f.Data)
 		files = append(files, f)
 	}
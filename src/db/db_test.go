@@ -1,39 +1,268 @@
 package db
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/schollz/rwtxt/src/search"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestBasic(t *testing.T) {
 	os.Remove("test.db")
+	defer os.Remove("test.db")
+	defer os.Remove("test.db.sql.gz")
 
 	fs, err := New("test.db")
 	assert.Nil(t, err)
+	defer fs.Close()
 
-	f := fs.NewFile("test1", "someslug", "some text")
-	assert.Nil(t, err)
+	f := fs.NewFile("someslug", "some text")
 	err = fs.Save(f)
 	assert.Nil(t, err)
 	time.Sleep(1 * time.Second)
 	err = fs.Save(f)
 	assert.Nil(t, err)
 
-	f2, err := fs.Get("test1")
-	assert.Equal(t, f.Data, f2.Data)
+	files, err := fs.Get(f.ID, "public")
 	assert.Nil(t, err)
-	assert.True(t, f2.Modified.Second()-f.Modified.Second() >= 1)
+	if assert.Equal(t, 1, len(files)) {
+		assert.Equal(t, f.Data, files[0].Data)
+		assert.True(t, files[0].Modified.Sub(f.Modified) >= time.Second)
+	}
 
-	exists, err := fs.Exists("doesn't exist")
+	exists, err := fs.Exists("doesn't exist", "public")
 	assert.Nil(t, err)
 	assert.False(t, exists)
-	exists, err = fs.Exists("test1")
+	exists, err = fs.Exists(f.ID, "public")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = fs.Exists("someslug", "public")
 	assert.Nil(t, err)
 	assert.True(t, exists)
 
 	err = fs.DumpSQL()
 	assert.Nil(t, err)
 }
+
+// newTestFileSystem returns a file system in a temporary folder with the
+// domains a and b
+func newTestFileSystem(t *testing.T) (fs *FileSystem, done func()) {
+	tmp, err := ioutil.TempDir("", "rwtxt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = New(filepath.Join(tmp, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, fs.SetDomain("a", "pw"))
+	assert.Nil(t, fs.SetDomain("b", "pw"))
+	return fs, func() {
+		fs.Close()
+		os.RemoveAll(tmp)
+	}
+}
+
+func TestSaveOtherDomain(t *testing.T) {
+	fs, done := newTestFileSystem(t)
+	defer done()
+
+	f := fs.NewFile("notes", "# notes of a")
+	f.Domain = "a"
+	assert.Nil(t, fs.Save(f))
+
+	// the same id in another domain does not change the page
+	other := f
+	other.Domain = "b"
+	other.Data = "# notes of b"
+	assert.Equal(t, ErrOtherDomain, fs.Save(other))
+	files, err := fs.Get(f.ID, "a")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(files)) {
+		assert.Equal(t, "# notes of a", files[0].Data)
+	}
+	_, err = fs.Get(f.ID, "b")
+	assert.NotNil(t, err)
+	found, err := fs.Find("notes", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+
+	// the page of the domain is still saved
+	f.Data = "# notes of a, again"
+	assert.Nil(t, fs.Save(f))
+	files, _ = fs.Get(f.ID, "a")
+	assert.Equal(t, "# notes of a, again", files[0].Data)
+}
+
+func TestPins(t *testing.T) {
+	fs, done := newTestFileSystem(t)
+	defer done()
+
+	f := fs.NewFile("pinned", "pinned")
+	f.Domain = "a"
+	assert.Nil(t, fs.Save(f))
+	assert.NotNil(t, fs.Pin("nosuchdomain", f.ID))
+	assert.Nil(t, fs.Pin("a", f.ID))
+	assert.Nil(t, fs.Pin("a", f.ID))
+
+	pinned, err := fs.IsPinned("a", f.ID)
+	assert.Nil(t, err)
+	assert.True(t, pinned)
+	files, err := fs.GetPinned("a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+
+	// a page is only listed as pinned in its own domain
+	assert.Nil(t, fs.Pin("b", f.ID))
+	files, err = fs.GetPinned("b")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+
+	assert.Nil(t, fs.Unpin("a", f.ID))
+	pinned, err = fs.IsPinned("a", f.ID)
+	assert.Nil(t, err)
+	assert.False(t, pinned)
+}
+
+func TestReservedSlugs(t *testing.T) {
+	fs, done := newTestFileSystem(t)
+	defer done()
+
+	old := fs.NewFile("settings", "settings from before")
+	old.Domain = "a"
+	assert.Nil(t, fs.Save(old))
+	fs.SetReservedSlugs(func(slug string) bool { return slug == "settings" })
+
+	f := fs.NewFile("settings", "new settings")
+	f.Domain = "a"
+	assert.Equal(t, ErrSlugTaken, fs.Save(f))
+	files, _ := fs.Get(f.ID, "a")
+	if assert.Equal(t, 1, len(files)) {
+		assert.Equal(t, "", files[0].Slug)
+		assert.Equal(t, "new settings", files[0].Data)
+	}
+
+	// a page keeps the slug it had
+	old.Data = "settings from before, changed"
+	assert.Nil(t, fs.Save(old))
+	files, _ = fs.Get(old.ID, "a")
+	assert.Equal(t, "settings", files[0].Slug)
+}
+
+func TestAccess(t *testing.T) {
+	fs, done := newTestFileSystem(t)
+	defer done()
+
+	f := fs.NewFile("private", "private")
+	f.Domain = "a"
+	assert.Nil(t, fs.Save(f))
+	assert.Nil(t, fs.SetPageAccess(f.ID, PageAccess{Level: AccessOwner, Owner: "owner", Editors: []string{"editor"}}))
+
+	assert.Nil(t, fs.CheckAccess(f.ID, "owner", true))
+	assert.Nil(t, fs.CheckAccess(f.ID, "editor", true))
+	assert.Equal(t, ErrNoAccess, fs.CheckAccess(f.ID, "someone", false))
+	assert.Equal(t, ErrNoAccess, fs.CheckAccess(f.ID, "", false))
+
+	// only the owner, the editors and the instance may save the page
+	f.Data = "changed by someone"
+	f.Editor = "someone"
+	assert.Equal(t, ErrNoAccess, fs.Save(f))
+	f.Editor = ""
+	assert.Equal(t, ErrNoAccess, fs.Save(f))
+	f.Data = "changed by the editor"
+	f.Editor = "editor"
+	assert.Nil(t, fs.Save(f))
+	f.Data = "changed by the instance"
+	f.Editor = InstanceEditor
+	assert.Nil(t, fs.Save(f))
+	files, _ := fs.Get(f.ID, "a")
+	assert.Equal(t, "changed by the instance", files[0].Data)
+
+	assert.Nil(t, fs.SetPageAccess(f.ID, PageAccess{Level: AccessReadOnly, Owner: "owner"}))
+	assert.Nil(t, fs.CheckAccess(f.ID, "someone", false))
+	assert.Equal(t, ErrReadOnly, fs.CheckAccess(f.ID, "someone", true))
+	f.Editor = "someone"
+	assert.Equal(t, ErrReadOnly, fs.Save(f))
+
+	readable, err := fs.Readable([]File{f}, "someone")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(readable))
+	assert.Nil(t, fs.SetPageAccess(f.ID, PageAccess{Level: AccessOwner, Owner: "owner", Editors: []string{"editor"}}))
+	readable, err = fs.Readable([]File{f}, "someone")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(readable))
+
+	// renaming an editor keeps what they may do
+	assert.Nil(t, fs.RenameEditor("owner", "newowner"))
+	assert.Nil(t, fs.RenameEditor("editor", "neweditor"))
+	a, err := fs.GetPageAccess(f.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "newowner", a.Owner)
+	assert.Equal(t, []string{"neweditor"}, a.Editors)
+	assert.Equal(t, ErrNoAccess, fs.CheckAccess(f.ID, "owner", false))
+	assert.Nil(t, fs.CheckAccess(f.ID, "neweditor", true))
+}
+
+func TestRestoreKeyHashes(t *testing.T) {
+	fs, done := newTestFileSystem(t)
+	defer done()
+	key, err := fs.SetKey("a", "pw")
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(key))
+
+	hashes, err := fs.GetKeyHashes("a")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(hashes)) {
+		assert.NotEqual(t, "", hashes[0].Salt)
+		assert.NotEqual(t, HashKey(key, ""), hashes[0].Hash)
+	}
+
+	// the keys of a backup sign in to the domain on another instance,
+	// also from backups from before salts
+	other, doneOther := newTestFileSystem(t)
+	defer doneOther()
+	hashes = append(hashes, KeyHash{Hash: HashKey("an older key", "")})
+	restored, err := other.RestoreKeyHashes("a", hashes)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, restored)
+	for _, k := range []string{key, "an older key"} {
+		domain, err := other.CheckKey(k)
+		assert.Nil(t, err)
+		assert.Equal(t, "a", domain)
+	}
+	_, err = other.CheckKey("not a key")
+	assert.NotNil(t, err)
+}
+
+func TestImportFile(t *testing.T) {
+	fs, done := newTestFileSystem(t)
+	defer done()
+
+	f := fs.NewFile("diary", "diary")
+	f.Domain = "a"
+	assert.Nil(t, fs.Save(f))
+	assert.Nil(t, fs.SetPageAccess(f.ID, PageAccess{Level: AccessOwner, Owner: "owner"}))
+
+	// an import does not change what the editor may not save
+	f.Data = "diary of someone else #imported"
+	f.Modified = time.Now().Add(time.Hour)
+	f.Editor = "someone"
+	_, _, err := fs.ImportFile(f)
+	assert.Equal(t, ErrNoAccess, err)
+	files, _ := fs.Get(f.ID, "a")
+	assert.Equal(t, "diary", files[0].Data)
+
+	// and is indexed like a save
+	f.Data = "diary of the owner #imported"
+	f.Editor = "owner"
+	_, imported, err := fs.ImportFile(f)
+	assert.Nil(t, err)
+	assert.True(t, imported)
+	tagged, err := fs.Search(search.Query{Tags: []string{"imported"}}, "a", "", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tagged))
+}
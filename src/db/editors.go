@@ -0,0 +1,230 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Edit is a revision of a page and the anonymous editor who made it
+type Edit struct {
+	FileID string
+	Domain string
+	Slug   string
+	Editor string
+	IP     string
+	Time   time.Time
+}
+
+// EditFilter picks the edits of an editor or an address, or both, made
+// between Since and Until when they are set
+type EditFilter struct {
+	Editor string
+	IP     string
+	Since  time.Time
+	Until  time.Time
+}
+
+// where returns the conditions of the filter
+func (filter EditFilter) where() (where string, args []interface{}, err error) {
+	if filter.Editor == "" && filter.IP == "" {
+		err = errors.New("choose an editor or an address")
+		return
+	}
+	where = "1"
+	if filter.Editor != "" {
+		where += " AND revisioneditors.editor = ?"
+		args = append(args, filter.Editor)
+	}
+	if filter.IP != "" {
+		where += " AND revisioneditors.ip = ?"
+		args = append(args, filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		where += " AND revisioneditors.timestamp >= ?"
+		args = append(args, filter.Since.UnixNano())
+	}
+	if !filter.Until.IsZero() {
+		where += " AND revisioneditors.timestamp <= ?"
+		args = append(args, filter.Until.UnixNano())
+	}
+	return
+}
+
+func (fs *FileSystem) initializeRevisionEditors() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	revisioneditors (
+		fsid TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		editor TEXT,
+		PRIMARY KEY(fsid, timestamp)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating revisioneditors table")
+		return
+	}
+	err = fs.addColumn("revisioneditors", "ip", "TEXT DEFAULT ''")
+	if err != nil {
+		return
+	}
+	for _, sqlStmt := range []string{
+		`CREATE INDEX IF NOT EXISTS revisioneditors_editor ON revisioneditors(editor)`,
+		`CREATE INDEX IF NOT EXISTS revisioneditors_ip ON revisioneditors(ip)`,
+	} {
+		_, err = fs.db.Exec(sqlStmt)
+		if err != nil {
+			return errors.Wrap(err, "creating revisioneditors index")
+		}
+	}
+	return
+}
+
+// saveRevisionEditor records who made the revision of the file at the
+// timestamp, in Unix nanoseconds like the revisions
+func (fs *FileSystem) saveRevisionEditor(fileid string, timestamp int64, editor, ip string) (err error) {
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO revisioneditors (fsid, timestamp, editor, ip) VALUES (?, ?, ?, ?)`, fileid, timestamp, editor, ip)
+	if err != nil {
+		err = errors.Wrap(err, "saveRevisionEditor")
+	}
+	return
+}
+
+// GetEdits returns the edits that the filter picks, newest first
+func (fs *FileSystem) GetEdits(filter EditFilter) (edits []Edit, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	where, args, err := filter.where()
+	if err != nil {
+		return
+	}
+	return fs.getEdits(where+` ORDER BY revisioneditors.timestamp DESC`, args...)
+}
+
+// GetFileEdits returns the latest revision of a file by each of its
+// editors, newest first
+func (fs *FileSystem) GetFileEdits(fileid string) (edits []Edit, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	all, err := fs.getEdits(`revisioneditors.fsid = ? ORDER BY revisioneditors.timestamp DESC`, fileid)
+	seen := make(map[string]bool)
+	for _, e := range all {
+		if !seen[e.Editor] {
+			seen[e.Editor] = true
+			edits = append(edits, e)
+		}
+	}
+	return
+}
+
+func (fs *FileSystem) getEdits(where string, args ...interface{}) (edits []Edit, err error) {
+	rows, err := fs.db.Query(`
+	SELECT revisioneditors.fsid, domains.name, fs.slug, revisioneditors.editor, revisioneditors.ip, revisioneditors.timestamp
+	FROM revisioneditors
+	INNER JOIN fs ON revisioneditors.fsid = fs.id
+	INNER JOIN domains ON fs.domainid = domains.id
+	WHERE `+where, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "getEdits")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Edit
+		var timestamp int64
+		if err = rows.Scan(&e.FileID, &e.Domain, &e.Slug, &e.Editor, &e.IP, &timestamp); err != nil {
+			return nil, errors.Wrap(err, "getEdits")
+		}
+		e.Time = time.Unix(0, timestamp).UTC()
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+// RevertEdits undoes the edits that the filter picks, by restoring
+// every page they changed to how it was before the first of them. Later
+// edits of those pages by others are undone too. Pages that were made by
+// one of the edits are deleted.
+func (fs *FileSystem) RevertEdits(filter EditFilter) (reverted []Edit, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	where, args, err := filter.where()
+	if err != nil {
+		return
+	}
+	edits, err := fs.getEdits(where+` ORDER BY revisioneditors.timestamp ASC`, args...)
+	if err != nil {
+		return
+	}
+	done := make(map[string]bool)
+	for _, e := range edits {
+		if done[e.FileID] {
+			continue
+		}
+		done[e.FileID] = true
+
+		var files []File
+		files, err = fs.get(e.FileID, e.Domain)
+		if err != nil {
+			return
+		}
+		if len(files) != 1 {
+			continue
+		}
+		f := files[0]
+		f.Domain = e.Domain
+
+		// the revision before the first edit
+		var before int64
+		for _, snapshot := range f.History.GetSnapshots() {
+			if snapshot < e.Time.UnixNano() {
+				before = snapshot
+			}
+		}
+		if before == 0 {
+			err = fs.delete(f.ID)
+		} else {
+			f.Data, err = f.History.GetPreviousByTimestamp(before)
+			if err != nil {
+				return
+			}
+			f.Editor, f.EditorIP = "", ""
+			err = fs.save(f)
+		}
+		if err != nil && err != ErrSlugTaken {
+			return reverted, errors.Wrap(err, "could not revert "+e.Domain+"/"+e.FileID)
+		}
+		err = nil
+		reverted = append(reverted, e)
+	}
+	return
+}
+
+// GetEditsByDay returns how many revisions of the pages of the domain
+// were made on each day since the time, by the day in UTC like
+// "2006-01-02"
+func (fs *FileSystem) GetEditsByDay(domain string, since time.Time) (days map[string]int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	rows, err := fs.db.Query(`
+	SELECT DATE(revisioneditors.timestamp / 1000000000, 'unixepoch'), COUNT(*)
+	FROM revisioneditors
+	INNER JOIN fs ON revisioneditors.fsid = fs.id
+	INNER JOIN domains ON fs.domainid = domains.id
+	WHERE domains.name = ? AND revisioneditors.timestamp >= ?
+	GROUP BY 1`, domain, since.UnixNano())
+	if err != nil {
+		return nil, errors.Wrap(err, "GetEditsByDay")
+	}
+	defer rows.Close()
+	days = make(map[string]int)
+	for rows.Next() {
+		var day string
+		var edits int
+		if err = rows.Scan(&day, &edits); err != nil {
+			return nil, errors.Wrap(err, "GetEditsByDay")
+		}
+		days[day] = edits
+	}
+	return days, rows.Err()
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Embedding is the embedding of the text of a file, with the hash of the
+// text it was made from
+type Embedding struct {
+	FileID string
+	Hash   string
+	Vector []float32
+}
+
+func (fs *FileSystem) initializeEmbeddings() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	embeddings (
+		fsid TEXT NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		model TEXT,
+		hash TEXT,
+		vector BLOB
+	);
+	CREATE INDEX IF NOT EXISTS idx_embeddings_domain ON embeddings(domainid, model);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating embeddings table")
+	}
+	return
+}
+
+// SetEmbedding keeps the embedding of the file made by the model
+func (fs *FileSystem) SetEmbedding(domain, model string, e Embedding) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+	vector := make([]byte, 4*len(e.Vector))
+	for i, x := range e.Vector {
+		binary.LittleEndian.PutUint32(vector[4*i:], math.Float32bits(x))
+	}
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO embeddings (fsid, domainid, model, hash, vector) VALUES (?, ?, ?, ?, ?)`,
+		e.FileID, domainid, model, e.Hash, vector)
+	if err != nil {
+		err = errors.Wrap(err, "SetEmbedding")
+	}
+	return
+}
+
+// GetEmbeddings returns the embeddings made by the model of the files of
+// the domain
+func (fs *FileSystem) GetEmbeddings(domain, model string) (embeddings []Embedding, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rows, err := fs.db.Query(`
+	SELECT embeddings.fsid, embeddings.hash, embeddings.vector FROM embeddings
+	INNER JOIN domains ON domains.id = embeddings.domainid
+	WHERE domains.name = ? AND embeddings.model = ?`, domain, model)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetEmbeddings")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Embedding
+		var vector []byte
+		if err = rows.Scan(&e.FileID, &e.Hash, &vector); err != nil {
+			return nil, errors.Wrap(err, "GetEmbeddings")
+		}
+		e.Vector = make([]float32, len(vector)/4)
+		for i := range e.Vector {
+			e.Vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(vector[4*i:]))
+		}
+		embeddings = append(embeddings, e)
+	}
+	err = errors.Wrap(rows.Err(), "GetEmbeddings")
+	return
+}
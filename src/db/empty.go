@@ -0,0 +1,68 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// emptyPage is the condition on fs and fts of a page with nothing
+// written in it
+const emptyPage = `(fts.data IS NULL OR TRIM(fts.data, ' ' || char(9, 10, 13)) = '')`
+
+// GetEmptyPages returns the pages that have nothing written in them and
+// were last changed before the time, oldest first. Without a domain it
+// returns those of every domain.
+func (fs *FileSystem) GetEmptyPages(domain string, before time.Time) (files []File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rows, err := fs.db.Query(`
+	SELECT fs.id, fs.slug, fs.created, fs.modified, domains.name FROM fs
+	LEFT JOIN fts ON fts.id = fs.id
+	INNER JOIN domains ON domains.id = fs.domainid
+	WHERE `+emptyPage+` AND fs.modified < ? AND (? = '' OR domains.name = ?)
+	ORDER BY fs.modified`, before.UTC(), domain, domain)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetEmptyPages")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f File
+		var slug *string
+		if err = rows.Scan(&f.ID, &slug, &f.Created, &f.Modified, &f.Domain); err != nil {
+			return nil, errors.Wrap(err, "GetEmptyPages")
+		}
+		if slug != nil {
+			f.Slug = *slug
+		}
+		files = append(files, f)
+	}
+	err = errors.Wrap(rows.Err(), "GetEmptyPages")
+	return
+}
+
+// DeleteEmptyPages deletes the pages with the ids that are still empty,
+// returning how many were deleted
+func (fs *FileSystem) DeleteEmptyPages(ids []string) (deleted int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	for _, id := range ids {
+		var empty int
+		err = fs.db.QueryRow(`SELECT COUNT(*) FROM fs LEFT JOIN fts ON fts.id = fs.id
+		WHERE fs.id = ? AND `+emptyPage, id).Scan(&empty)
+		if err != nil {
+			return deleted, errors.Wrap(err, "DeleteEmptyPages")
+		}
+		if empty == 0 {
+			// something was written in it meanwhile
+			continue
+		}
+		if err = fs.delete(id); err != nil {
+			return
+		}
+		deleted++
+	}
+	return
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Key is a sign-in to a domain, in a browser or as a token of the API
+type Key struct {
+	ID       int
+	LastUsed time.Time
+	// Current is whether it is the key that asked for the keys
+	Current bool
+}
+
+// GetKeys returns the keys of the domain, most recently used first
+func (fs *FileSystem) GetKeys(domain, current string) (keys []Key, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rows, err := fs.db.Query(`
+	SELECT keys.id, keys.key, keys.lastused FROM keys
+	INNER JOIN domains ON keys.domainid=domains.id
+	WHERE domains.name = ?
+	ORDER BY keys.lastused DESC`, domain)
+	if err != nil {
+		err = errors.Wrap(err, "GetKeys")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k Key
+		var key string
+		if err = rows.Scan(&k.ID, &key, &k.LastUsed); err != nil {
+			err = errors.Wrap(err, "GetKeys")
+			return
+		}
+		k.Current = key == current
+		keys = append(keys, k)
+	}
+	err = rows.Err()
+	return
+}
+
+// DeleteDomainKey deletes a key of the domain by its id
+func (fs *FileSystem) DeleteDomainKey(domain string, id int) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`DELETE FROM keys WHERE id = ? AND domainid = (SELECT id FROM domains WHERE name = ?)`, id, domain)
+	if err != nil {
+		return errors.Wrap(err, "DeleteDomainKey")
+	}
+	return fs.deleteOrphanedReadingLists()
+}
+
+// DeleteOtherKeys deletes every key of the domain except the one given,
+// and returns how many it deleted
+func (fs *FileSystem) DeleteOtherKeys(domain, key string) (deleted int64, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	result, err := fs.db.Exec(`DELETE FROM keys WHERE key != ? AND domainid = (SELECT id FROM domains WHERE name = ?)`, key, domain)
+	if err != nil {
+		err = errors.Wrap(err, "DeleteOtherKeys")
+		return
+	}
+	deleted, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+	restored, err := fs.db.Exec(`DELETE FROM restoredkeys WHERE domainid = (SELECT id FROM domains WHERE name = ?)`, domain)
+	if err != nil {
+		err = errors.Wrap(err, "DeleteOtherKeys")
+		return
+	}
+	deletedRestored, err := restored.RowsAffected()
+	deleted += deletedRestored
+	if err == nil && deleted > 0 {
+		err = fs.deleteOrphanedReadingLists()
+	}
+	return
+}
+
+// KeyHash is a key of a domain as it is kept in the backups of its
+// settings, hashed so that the backup can not sign in to the domain
+type KeyHash struct {
+	Hash     string    `json:"sha256"`
+	Salt     string    `json:"salt,omitempty"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// maxKeySalt is the longest salt of a restored key
+const maxKeySalt = 64
+
+// HashKey hashes a key for a backup, as the HMAC-SHA256 of the key with
+// the salt of the backup. Keys were once short enough to be guessed,
+// and the salt keeps the hashes of one backup from being checked against
+// those of another. Backups from before salts have the SHA-256 of their
+// keys, which is what an empty salt gives.
+func HashKey(key, salt string) string {
+	if salt == "" {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// restoredkeys are the hashes of the keys that were restored from a
+// backup, which become keys again when they are first used
+func (fs *FileSystem) initializeRestoredKeys() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	restoredkeys (
+		hash TEXT NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		lastused TIMESTAMP,
+		salt TEXT DEFAULT ''
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating restoredkeys table")
+		return
+	}
+	return fs.addColumn("restoredkeys", "salt", "TEXT DEFAULT ''")
+}
+
+// GetKeyHashes returns the hashes of the keys of the domain, with a new
+// salt, along with the restored keys that were not used since
+func (fs *FileSystem) GetKeyHashes(domain string) (hashes []KeyHash, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	salt, err := newKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetKeyHashes")
+	}
+
+	rows, err := fs.db.Query(`
+	SELECT keys.key, keys.lastused FROM keys
+	INNER JOIN domains ON keys.domainid=domains.id
+	WHERE domains.name = ?`, domain)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetKeyHashes")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		h := KeyHash{Salt: salt}
+		if err = rows.Scan(&key, &h.LastUsed); err != nil {
+			return nil, errors.Wrap(err, "GetKeyHashes")
+		}
+		h.Hash = HashKey(key, salt)
+		hashes = append(hashes, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "GetKeyHashes")
+	}
+
+	restored, err := fs.db.Query(`
+	SELECT restoredkeys.hash, restoredkeys.salt, restoredkeys.lastused FROM restoredkeys
+	INNER JOIN domains ON restoredkeys.domainid=domains.id
+	WHERE domains.name = ?`, domain)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetKeyHashes")
+	}
+	defer restored.Close()
+	for restored.Next() {
+		var h KeyHash
+		if err = restored.Scan(&h.Hash, &h.Salt, &h.LastUsed); err != nil {
+			return nil, errors.Wrap(err, "GetKeyHashes")
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, errors.Wrap(restored.Err(), "GetKeyHashes")
+}
+
+// RestoreKeyHashes restores the keys of a backup to the domain, so that
+// the browsers and tokens that were signed in to it still are. It returns
+// how many it restored, leaving out the keys that the domain has.
+func (fs *FileSystem) RestoreKeyHashes(domain string, hashes []KeyHash) (restored int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return 0, errors.New("domain does not exist")
+	}
+	keys, err := fs.getAllFromPreparedQuerySingleString(`SELECT key FROM keys WHERE domainid = ?`, domainid)
+	if err != nil {
+		return 0, errors.Wrap(err, "RestoreKeyHashes")
+	}
+	// the hashes of the keys of the domain, with each salt of the backup
+	existing := make(map[string]map[string]bool)
+	exists := func(h KeyHash) bool {
+		if existing[h.Salt] == nil {
+			existing[h.Salt] = make(map[string]bool)
+			for _, key := range keys {
+				existing[h.Salt][HashKey(key, h.Salt)] = true
+			}
+		}
+		return existing[h.Salt][h.Hash]
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "RestoreKeyHashes")
+	}
+	defer tx.Rollback()
+	for _, h := range hashes {
+		if len(h.Hash) != sha256.Size*2 || len(h.Salt) > maxKeySalt || exists(h) {
+			continue
+		}
+		if _, errHex := hex.DecodeString(h.Hash); errHex != nil {
+			continue
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO restoredkeys(hash, salt, domainid, lastused) VALUES (?, ?, ?, ?)`, h.Hash, h.Salt, domainid, h.LastUsed.UTC())
+		if err != nil {
+			return 0, errors.Wrap(err, "RestoreKeyHashes")
+		}
+		restored++
+	}
+	return restored, errors.Wrap(tx.Commit(), "RestoreKeyHashes")
+}
+
+// useRestoredKey makes the key a key again if it was restored, and
+// returns its domain. The key is hashed with each salt of the restored
+// keys, of which there is one for every backup that was restored.
+func (fs *FileSystem) useRestoredKey(key string) (domain string, err error) {
+	salts, err := fs.getAllFromPreparedQuerySingleString(`SELECT DISTINCT salt FROM restoredkeys`)
+	if err != nil {
+		return
+	}
+	var hash string
+	var domainid int
+	err = sql.ErrNoRows
+	for _, salt := range salts {
+		hash = HashKey(key, salt)
+		err = fs.db.QueryRow(`
+		SELECT domains.id, domains.name FROM restoredkeys
+		INNER JOIN domains ON restoredkeys.domainid=domains.id
+		WHERE restoredkeys.hash = ? AND restoredkeys.salt = ?`, hash, salt).Scan(&domainid, &domain)
+		if err != sql.ErrNoRows {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM restoredkeys WHERE hash = ?`, hash); err != nil {
+		return
+	}
+	if _, err = tx.Exec(`INSERT INTO keys(domainid, key, lastused) VALUES (?, ?, ?)`, domainid, key, time.Now().UTC()); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
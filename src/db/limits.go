@@ -0,0 +1,77 @@
+package db
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrPageTooLarge is returned when a file is larger than the limit
+var ErrPageTooLarge = errors.New("page is larger than the limit")
+
+// ErrTooManyPages is returned when a new file would take the domain
+// over its limit of pages
+var ErrTooManyPages = errors.New("domain has as many pages as allowed")
+
+// Limits keep one domain from using up the whole database.
+// Zero means unlimited.
+type Limits struct {
+	// MaxPageSize is the most bytes that a page can have
+	MaxPageSize int
+	// MaxPagesPerDomain is the most pages with content that a domain can have
+	MaxPagesPerDomain int
+}
+
+// SetLimits sets the limits checked when files are saved
+func (fs *FileSystem) SetLimits(limits Limits) {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.limits = limits
+}
+
+// SetReservedSlugs sets what reports whether a slug is reserved, like
+// the names of the pages that are not stored. Saved pages are not given
+// such slugs, as if another page had them.
+func (fs *FileSystem) SetReservedSlugs(reserved func(slug string) bool) {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.reserved = reserved
+}
+
+// checkLimits returns an error when saving the file would exceed the
+// limits. existing is the file as it is saved now, if it is.
+func (fs *FileSystem) checkLimits(f File, domainid int, existing []File) (err error) {
+	if fs.limits.MaxPageSize > 0 && len(f.Data) > fs.limits.MaxPageSize {
+		return ErrPageTooLarge
+	}
+	if fs.limits.MaxPagesPerDomain <= 0 || f.Data == "" || (len(existing) > 0 && existing[0].Data != "") {
+		return
+	}
+	var pages int
+	err = fs.db.QueryRow(`
+	SELECT COUNT(*) FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	WHERE fs.domainid = ? AND fs.id != ? AND LENGTH(fts.data) > 0`, domainid, f.ID).Scan(&pages)
+	if err != nil {
+		return errors.Wrap(err, "checkLimits")
+	}
+	if pages >= fs.limits.MaxPagesPerDomain {
+		return ErrTooManyPages
+	}
+	return
+}
+
+// CountPages returns how many pages with content the domain has, which
+// is what MaxPagesPerDomain limits
+func (fs *FileSystem) CountPages(domain string) (pages int, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	err = fs.db.QueryRow(`
+	SELECT COUNT(*) FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id
+	WHERE domains.name = ? AND LENGTH(fts.data) > 0`, domain).Scan(&pages)
+	if err != nil {
+		err = errors.Wrap(err, "CountPages")
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LinkStatus is the result of checking whether an external link works
+type LinkStatus struct {
+	URL     string
+	Status  int
+	Error   string
+	Checked time.Time
+}
+
+// Broken returns whether the link points to a page that does not exist
+func (s LinkStatus) Broken() bool {
+	return s.Status == 404 || s.Status == 410
+}
+
+func (fs *FileSystem) initializeLinkStatuses() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	linkstatus (
+		url TEXT NOT NULL PRIMARY KEY,
+		status INTEGER,
+		error TEXT,
+		checked TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating linkstatus table")
+	}
+	return
+}
+
+// GetLinkStatus returns when the link was last checked, if it was
+func (fs *FileSystem) GetLinkStatus(link string) (s LinkStatus, found bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	stmt, err := fs.db.Prepare(`SELECT url, status, error, checked FROM linkstatus WHERE url = ?`)
+	if err != nil {
+		err = errors.Wrap(err, "stmt GetLinkStatus")
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(link).Scan(&s.URL, &s.Status, &s.Error, &s.Checked)
+	if err == sql.ErrNoRows {
+		return s, false, nil
+	} else if err != nil {
+		err = errors.Wrap(err, "GetLinkStatus")
+		return
+	}
+	found = true
+	return
+}
+
+// SaveLinkStatus saves the result of checking a link
+func (fs *FileSystem) SaveLinkStatus(s LinkStatus) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	stmt, err := fs.db.Prepare(`INSERT OR REPLACE INTO linkstatus (url, status, error, checked) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt SaveLinkStatus")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(s.URL, s.Status, s.Error, s.Checked.UTC())
+	if err != nil {
+		return errors.Wrap(err, "exec SaveLinkStatus")
+	}
+	return
+}
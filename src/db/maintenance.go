@@ -0,0 +1,91 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxIntegrityProblems is how many problems an integrity check lists at
+// most
+const maxIntegrityProblems = 100
+
+// Maintenance is what checking and compacting the database found
+type Maintenance struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	// Problems are what the integrity check found, none if it is intact
+	Problems []string `json:"problems,omitempty"`
+	Vacuumed bool     `json:"vacuumed"`
+	// SizeBefore and SizeAfter are the bytes of the database before and
+	// after it was vacuumed
+	SizeBefore int64 `json:"size_before"`
+	SizeAfter  int64 `json:"size_after"`
+}
+
+// OK returns whether the integrity check found nothing wrong
+func (m Maintenance) OK() bool {
+	return len(m.Problems) == 0
+}
+
+// Maintain checks the integrity of the database, updates the statistics
+// that SQLite plans queries with, and vacuums it unless it is corrupt,
+// which gives back the space of deleted rows. Nothing else can use the
+// database until it is done.
+func (fs *FileSystem) Maintain() (m Maintenance, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	m.Started = time.Now().UTC()
+	defer func() { m.Duration = time.Since(m.Started) }()
+	if m.SizeBefore, err = fs.size(); err != nil {
+		return
+	}
+	m.SizeAfter = m.SizeBefore
+
+	rows, err := fs.db.Query(fmt.Sprintf(`PRAGMA integrity_check(%d)`, maxIntegrityProblems))
+	if err != nil {
+		return m, errors.Wrap(err, "integrity_check")
+	}
+	for rows.Next() {
+		var problem string
+		if err = rows.Scan(&problem); err != nil {
+			rows.Close()
+			return m, errors.Wrap(err, "integrity_check")
+		}
+		if problem != "ok" {
+			m.Problems = append(m.Problems, problem)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return m, errors.Wrap(err, "integrity_check")
+	}
+	if !m.OK() {
+		// vacuuming a corrupt database can lose more of it
+		return
+	}
+
+	if _, err = fs.db.Exec(`ANALYZE`); err != nil {
+		return m, errors.Wrap(err, "ANALYZE")
+	}
+	if _, err = fs.db.Exec(`VACUUM`); err != nil {
+		return m, errors.Wrap(err, "VACUUM")
+	}
+	m.Vacuumed = true
+	m.SizeAfter, err = fs.size()
+	return
+}
+
+// size returns the bytes of the pages of the database
+func (fs *FileSystem) size() (size int64, err error) {
+	var pages, pageSize int64
+	if err = fs.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, errors.Wrap(err, "page_count")
+	}
+	if err = fs.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, errors.Wrap(err, "page_size")
+	}
+	return pages * pageSize, nil
+}
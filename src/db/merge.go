@@ -0,0 +1,104 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Merge appends the content of one file onto another file in the same
+// domain and then empties the first file so that it is purged
+func (fs *FileSystem) Merge(fromID string, toID string, domain string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if fromID == toID {
+		return errors.New("cannot merge a file into itself")
+	}
+	from, err := fs.getByID(fromID, domain)
+	if err != nil {
+		return errors.Wrap(err, "merge from")
+	}
+	to, err := fs.getByID(toID, domain)
+	if err != nil {
+		return errors.Wrap(err, "merge to")
+	}
+
+	to.Domain = domain
+	to.Data = strings.TrimSpace(to.Data + "\n\n" + from.Data)
+	err = fs.save(to)
+	if err != nil {
+		return
+	}
+
+	from.Domain = domain
+	from.Data = ""
+	from.Modified = time.Now().UTC()
+	return fs.save(from)
+}
+
+// Rename replaces the first line of a file with the title, keeping any
+// heading markers, and updates the slug to match the new first line
+func (fs *FileSystem) Rename(id string, domain string, title string) (slug string, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	title = strings.TrimSpace(title)
+	slug = utils.Slugify(title)
+	if slug == "" {
+		err = errors.New("title must contain letters or numbers")
+		return
+	}
+	f, err := fs.getByID(id, domain)
+	if err != nil {
+		return
+	}
+
+	lines := strings.Split(f.Data, "\n")
+	replaced := false
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") && !strings.HasPrefix(title, "#") {
+			marker := line[:len(line)-len(strings.TrimLeft(line, "#"))]
+			lines[i] = marker + " " + title
+		} else {
+			lines[i] = title
+		}
+		replaced = true
+		break
+	}
+	if !replaced {
+		lines = append([]string{title}, lines...)
+	}
+
+	f.Domain = domain
+	f.Slug = slug
+	f.Data = strings.Join(lines, "\n")
+	err = fs.save(f)
+	return
+}
+
+// getByID returns a single file by its id, and never by its slug
+func (fs *FileSystem) getByID(id string, domain string) (f File, err error) {
+	files, err := fs.getAllFromPreparedQuery(`
+		SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs 
+		INNER JOIN fts ON fs.id=fts.id 
+		INNER JOIN domains ON fs.domainid=domains.id
+		WHERE 
+			fs.id = ? 
+			AND
+			domains.name = ?`, id, domain)
+	if err != nil {
+		return
+	}
+	if len(files) == 0 {
+		err = errors.New("no file with id " + id)
+		return
+	}
+	f = files[0]
+	return
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// initializeDayIndexes indexes the pages by the day of the month they
+// were made and changed, so that the pages of a day in earlier months
+// and years are found without reading every page
+func (fs *FileSystem) initializeDayIndexes() (err error) {
+	_, err = fs.db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_fs_created_day ON fs(domainid, SUBSTR(created, 9, 2));
+	CREATE INDEX IF NOT EXISTS idx_fs_modified_day ON fs(domainid, SUBSTR(modified, 9, 2));`)
+	if err != nil {
+		err = errors.Wrap(err, "creating day indexes")
+	}
+	return
+}
+
+// GetOnThisDay returns the pages of the domain that were made or last
+// changed on the same day of the month as the day, before it
+func (fs *FileSystem) GetOnThisDay(domain string, day time.Time) (files []File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	day = day.UTC()
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	dd := start.Format("02")
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM fs
+	INNER JOIN fts ON fs.id=fts.id
+	WHERE
+		fs.domainid = (SELECT id FROM domains WHERE name = ?)
+		AND fs.archived = 0
+		AND ((SUBSTR(fs.created, 9, 2) = ? AND fs.created < ?) OR (SUBSTR(fs.modified, 9, 2) = ? AND fs.modified < ?))
+		AND LENGTH(fts.data) > 0
+	ORDER BY fs.created DESC`, domain, dd, start, dd, start)
+}
@@ -0,0 +1,252 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// ErrSlugTaken is returned when a file was saved without its new slug,
+// because another file of the domain already has it or it is reserved
+var ErrSlugTaken = errors.New("another page already has that name")
+
+func isUniqueConstraintError(err error) bool {
+	sqliteErr, ok := errors.Cause(err).(sqlite3.Error)
+	return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// How links to videos are shown: as players that load the video only
+// when they are clicked, as players that load it with the page, or as
+// plain links.
+const (
+	VideosClickToLoad = ""
+	VideosEmbed       = "embed"
+	VideosLink        = "link"
+)
+
+// How the pages of a domain look: light, dark, or as the browser
+// prefers.
+const (
+	ThemeLight = ""
+	ThemeDark  = "dark"
+	ThemeAuto  = "auto"
+)
+
+// DomainOptions are the settings of a domain, stored as JSON
+// so that new settings do not need new columns
+type DomainOptions struct {
+	// UniqueSlugs prevents two pages of the domain from having the same slug
+	UniqueSlugs bool `json:"unique_slugs,omitempty"`
+	// AllowIndexing lets search engines index the domain while it is public
+	AllowIndexing bool `json:"allow_indexing,omitempty"`
+	// UnfurlLinks shows links that are on a line of their own as previews
+	UnfurlLinks bool `json:"unfurl_links,omitempty"`
+	// LockEditing keeps others from saving a page while someone edits it
+	LockEditing bool `json:"lock_editing,omitempty"`
+	// StrictMarkdown renders pages without tables, footnotes and the
+	// other extensions of markdown
+	StrictMarkdown bool `json:"strict_markdown,omitempty"`
+	// Videos is how links to videos on a line of their own are shown
+	Videos string `json:"videos,omitempty"`
+	// LinkRel is the rel of links to other sites, like "nofollow ugc",
+	// instead of the one that depends on whether the domain is public
+	LinkRel string `json:"link_rel,omitempty"`
+	// MarkExternalLinks shows an icon after links to other sites
+	MarkExternalLinks bool `json:"mark_external_links,omitempty"`
+	// Scripts runs the Lua scripts of the scripts/ folder when pages
+	// are saved and viewed
+	Scripts bool `json:"scripts,omitempty"`
+	// Reminders notifies the browsers subscribed to a page of its tasks
+	// on the day they are due
+	Reminders bool `json:"reminders,omitempty"`
+	// Theme is whether the pages of the domain are light or dark
+	Theme string `json:"theme,omitempty"`
+	// Webhooks are told about the pages of the domain that are saved
+	Webhooks []string `json:"webhooks,omitempty"`
+	// WebhookSecret signs what is sent to the webhooks
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+	// AccessLog counts the visits of the pages of the domain, by day and
+	// by the kind of client, while the instance keeps an access log
+	AccessLog bool `json:"access_log,omitempty"`
+	// Homepage is the id of the page that the domain opens with, instead
+	// of its recent pages
+	Homepage string `json:"homepage,omitempty"`
+	// Imports are imported into the domain again on a schedule
+	Imports []ImportSource `json:"imports,omitempty"`
+	// TransferKey lets another instance copy the domain, until
+	// TransferKeyExpires
+	TransferKey        string    `json:"transfer_key,omitempty"`
+	TransferKeyExpires time.Time `json:"transfer_key_expires,omitempty"`
+}
+
+// ActiveTransferKey returns the transfer key, unless it expired
+func (o DomainOptions) ActiveTransferKey() string {
+	if time.Now().After(o.TransferKeyExpires) {
+		return ""
+	}
+	return o.TransferKey
+}
+
+// ImportSource is a repository or gist whose markdown files are
+// kept imported into a folder of the domain
+type ImportSource struct {
+	URL          string    `json:"url"`
+	Folder       string    `json:"folder,omitempty"`
+	LastImported time.Time `json:"last_imported,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// GetDomainOptions returns the settings of the domain
+func (fs *FileSystem) GetDomainOptions(domain string) (options DomainOptions, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.getDomainOptions(domain)
+}
+
+func (fs *FileSystem) getDomainOptions(domain string) (options DomainOptions, err error) {
+	stmt, err := fs.db.Prepare(`SELECT options FROM domains WHERE name = ?`)
+	if err != nil {
+		err = errors.Wrap(err, "stmt getDomainOptions")
+		return
+	}
+	defer stmt.Close()
+	var optionsJSON sql.NullString
+	err = stmt.QueryRow(domain).Scan(&optionsJSON)
+	if err != nil {
+		err = errors.Wrap(err, "domain "+domain+" does not exist")
+		return
+	}
+	if optionsJSON.Valid && optionsJSON.String != "" {
+		err = json.Unmarshal([]byte(optionsJSON.String), &options)
+		if err != nil {
+			err = errors.Wrap(err, "could not parse options")
+		}
+	}
+	return
+}
+
+// SetDomainOptions saves the settings of the domain
+func (fs *FileSystem) SetDomainOptions(domain string, options DomainOptions) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.setDomainOptions(domain, options)
+}
+
+func (fs *FileSystem) setDomainOptions(domain string, options DomainOptions) (err error) {
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin setDomainOptions")
+	}
+	stmt, err := tx.Prepare(`UPDATE domains SET options = ? WHERE name = ?`)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt setDomainOptions")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(string(optionsJSON), domain)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec setDomainOptions")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit setDomainOptions")
+	}
+	return
+}
+
+// SetUniqueSlugs turns slug uniqueness on or off for a domain. It is
+// enforced by a partial unique index on the pages of the domain, so it
+// cannot be turned on while pages of the domain still share a slug.
+func (fs *FileSystem) SetUniqueSlugs(domain string, unique bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+	options, err := fs.getDomainOptions(domain)
+	if err != nil {
+		return
+	}
+
+	// the index name and condition can only be built from the integer id
+	indexName := fmt.Sprintf("unique_slug_%d", domainid)
+	if unique {
+		_, err = fs.db.Exec(fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON fs(slug) WHERE domainid = %d AND slug != ''`, indexName, domainid))
+		if err != nil {
+			if isUniqueConstraintError(err) {
+				err = errors.New("some pages already share a name, merge or rename them first")
+			}
+			return
+		}
+	} else {
+		_, err = fs.db.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS %s`, indexName))
+		if err != nil {
+			return
+		}
+	}
+
+	options.UniqueSlugs = unique
+	return fs.setDomainOptions(domain, options)
+}
+
+// GetIndexableDomains returns the public domains that allow search engines
+func (fs *FileSystem) GetIndexableDomains() (domains []string, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rows, err := fs.db.Query(`SELECT name, options FROM domains WHERE ispublic = 1 AND options IS NOT NULL`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetIndexableDomains")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, optionsJSON string
+		err = rows.Scan(&name, &optionsJSON)
+		if err != nil {
+			return nil, errors.Wrap(err, "GetIndexableDomains")
+		}
+		var options DomainOptions
+		if json.Unmarshal([]byte(optionsJSON), &options) == nil && options.AllowIndexing {
+			domains = append(domains, name)
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// GetScheduledImports returns the scheduled imports of every domain
+func (fs *FileSystem) GetScheduledImports() (imports map[string][]ImportSource, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rows, err := fs.db.Query(`SELECT name, options FROM domains WHERE options IS NOT NULL`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetScheduledImports")
+	}
+	defer rows.Close()
+	imports = make(map[string][]ImportSource)
+	for rows.Next() {
+		var name, optionsJSON string
+		err = rows.Scan(&name, &optionsJSON)
+		if err != nil {
+			return nil, errors.Wrap(err, "GetScheduledImports")
+		}
+		var options DomainOptions
+		if json.Unmarshal([]byte(optionsJSON), &options) == nil && len(options.Imports) > 0 {
+			imports[name] = options.Imports
+		}
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (fs *FileSystem) initializePins() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	pins (
+		id INTEGER NOT NULL PRIMARY KEY,
+		domainid INTEGER,
+		fsid TEXT,
+		created TIMESTAMP,
+		UNIQUE(domainid, fsid)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating pins table")
+	}
+	return
+}
+
+// Pin will pin a file to the top of the domain
+func (fs *FileSystem) Pin(domain string, fileid string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(domain)
+	if domainid == 0 {
+		return errors.New("domain does not exist")
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin Pin")
+	}
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO pins (domainid, fsid, created) VALUES (?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt Pin")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(domainid, fileid, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec Pin")
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit Pin")
+	}
+	return
+}
+
+// Unpin will remove a pinned file from the domain
+func (fs *FileSystem) Unpin(domain string, fileid string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	stmt, err := fs.db.Prepare(`
+	DELETE FROM pins WHERE fsid = ? AND domainid IN (
+		SELECT id FROM domains WHERE name = ?
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt Unpin")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(fileid, domain)
+	if err != nil {
+		return errors.Wrap(err, "exec Unpin")
+	}
+	return
+}
+
+// IsPinned returns whether the file is pinned in the domain
+func (fs *FileSystem) IsPinned(domain string, fileid string) (pinned bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	ids, err := fs.getAllFromPreparedQuerySingleString(`
+	SELECT pins.fsid FROM pins
+	INNER JOIN domains ON pins.domainid=domains.id
+	WHERE pins.fsid = ? AND domains.name = ?`, fileid, domain)
+	if err != nil {
+		err = errors.Wrap(err, "IsPinned")
+		return
+	}
+	pinned = len(ids) > 0
+	return
+}
+
+// GetPinned returns the pinned files of a domain, most recently pinned first
+func (fs *FileSystem) GetPinned(domain string) (files []File, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.getAllFromPreparedQuery(`
+	SELECT fs.id,fs.slug,fs.created,fs.modified,fts.data,fs.history,fs.views,fs.archived,fs.title FROM pins
+	INNER JOIN fs ON pins.fsid=fs.id
+	INNER JOIN fts ON fs.id=fts.id
+	INNER JOIN domains ON pins.domainid=domains.id
+	WHERE
+		domains.name = ?
+		AND fs.domainid = pins.domainid
+		AND LENGTH(fts.data) > 0
+	ORDER BY pins.created DESC`, domain)
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// excerptLength is how long the excerpt of a preview is at most
+const excerptLength = 200
+
+// maxPreviewQuery is how many files are previewed with one query, to
+// stay beneath the number of variables that SQLite allows
+const maxPreviewQuery = 500
+
+// Preview is what a list of pages shows of a file besides its name
+type Preview struct {
+	FileID string
+	// Excerpt is the first paragraph after the title, as plain text
+	Excerpt string
+	// Image is the address of the first image
+	Image string
+	Tags  []string
+}
+
+// newPreview previews the text of a file
+func newPreview(fileid, data string) (p Preview) {
+	_, body := utils.FrontMatter(data)
+	// the first line names the page, which the list shows already
+	lines := strings.SplitN(strings.TrimSpace(body), "\n", 2)
+	return Preview{
+		FileID:  fileid,
+		Excerpt: utils.FirstParagraph(strings.Join(lines[1:], "\n"), excerptLength),
+		Image:   utils.FirstImage(body),
+		Tags:    utils.Tags(body),
+	}
+}
+
+// GetPreviews returns the previews of the files, by the id of the file.
+// They are made from the text of the files, which lists leave out.
+func (fs *FileSystem) GetPreviews(fileids []string) (previews map[string]Preview, err error) {
+	previews = make(map[string]Preview)
+	fs.Lock()
+	defer fs.Unlock()
+	for start := 0; start < len(fileids); start += maxPreviewQuery {
+		ids := fileids[start:]
+		if len(ids) > maxPreviewQuery {
+			ids = ids[:maxPreviewQuery]
+		}
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+		err = fs.getPreviews(previews, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func (fs *FileSystem) getPreviews(previews map[string]Preview, args []interface{}) (err error) {
+	rows, err := fs.db.Query(`SELECT id, data FROM fts
+	WHERE id IN (?`+strings.Repeat(", ?", len(args)-1)+`)`, args...)
+	if err != nil {
+		return errors.Wrap(err, "GetPreviews")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, data string
+		if err = rows.Scan(&id, &data); err != nil {
+			return errors.Wrap(err, "GetPreviews")
+		}
+		previews[id] = newPreview(id, data)
+	}
+	return errors.Wrap(rows.Err(), "GetPreviews")
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PushSubscription is where a browser gets web push notifications, with
+// the keys that they are encrypted for. Editor is who subscribed, who is
+// not told about their own changes.
+type PushSubscription struct {
+	Editor   string `json:"-"`
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+func (fs *FileSystem) initializePush() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	pushsubscriptions (
+		id INTEGER NOT NULL PRIMARY KEY,
+		fsid TEXT,
+		editor TEXT,
+		endpoint TEXT,
+		p256dh TEXT,
+		auth TEXT,
+		created TIMESTAMP,
+		UNIQUE(fsid, endpoint)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		return errors.Wrap(err, "creating pushsubscriptions table")
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS
+	settings (
+		name TEXT NOT NULL PRIMARY KEY,
+		value TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating settings table")
+	}
+	return
+}
+
+// Subscribe has the browser notified when the file changes
+func (fs *FileSystem) Subscribe(fileid string, s PushSubscription) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	stmt, err := fs.db.Prepare(`INSERT OR REPLACE INTO pushsubscriptions (fsid, editor, endpoint, p256dh, auth, created) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt Subscribe")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(fileid, s.Editor, s.Endpoint, s.Keys.P256dh, s.Keys.Auth, time.Now().UTC())
+	if err != nil {
+		err = errors.Wrap(err, "exec Subscribe")
+	}
+	return
+}
+
+// Unsubscribe stops notifying the endpoint about the file, or about
+// every file if fileid is empty
+func (fs *FileSystem) Unsubscribe(fileid, endpoint string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	stmt, err := fs.db.Prepare(`DELETE FROM pushsubscriptions WHERE endpoint = ? AND (? = '' OR fsid = ?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt Unsubscribe")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(endpoint, fileid, fileid)
+	if err != nil {
+		err = errors.Wrap(err, "exec Unsubscribe")
+	}
+	return
+}
+
+// GetSubscriptions returns the browsers to notify when the file changes
+func (fs *FileSystem) GetSubscriptions(fileid string) (subscriptions []PushSubscription, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rows, err := fs.db.Query(`SELECT editor, endpoint, p256dh, auth FROM pushsubscriptions WHERE fsid = ?`, fileid)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetSubscriptions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s PushSubscription
+		if err = rows.Scan(&s.Editor, &s.Endpoint, &s.Keys.P256dh, &s.Keys.Auth); err != nil {
+			return nil, errors.Wrap(err, "GetSubscriptions")
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	err = rows.Err()
+	return
+}
+
+// Setting returns a setting of the instance, or "" if it is not set
+func (fs *FileSystem) Setting(name string) (value string, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	err = fs.db.QueryRow(`SELECT value FROM settings WHERE name = ?`, name).Scan(&value)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+// SetSetting sets a setting of the instance
+func (fs *FileSystem) SetSetting(name, value string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO settings (name, value) VALUES (?, ?)`, name, value)
+	if err != nil {
+		err = errors.Wrap(err, "SetSetting")
+	}
+	return
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReadingItem is a page on the reading list of a sign-in
+type ReadingItem struct {
+	File  File
+	Added time.Time
+	// Read is when it was marked as read, or zero while it is unread
+	Read time.Time
+}
+
+// IsRead returns whether the page was read
+func (item ReadingItem) IsRead() bool {
+	return !item.Read.IsZero()
+}
+
+// The reading list belongs to a key, so each browser signed in to the
+// domain has its own, and it goes when the key does.
+func (fs *FileSystem) initializeReadingList() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	readinglist (
+		keyid INTEGER NOT NULL,
+		fsid TEXT NOT NULL,
+		added TIMESTAMP,
+		read TIMESTAMP,
+		PRIMARY KEY(keyid, fsid)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating readinglist table")
+	}
+	return
+}
+
+// keyOfFile returns the id of the key, if the file is in its domain
+func (fs *FileSystem) keyOfFile(key, fileid string) (keyid int, err error) {
+	err = fs.db.QueryRow(`
+	SELECT keys.id FROM keys
+	INNER JOIN fs ON fs.domainid = keys.domainid
+	WHERE keys.key = ? AND fs.id = ?`, key, fileid).Scan(&keyid)
+	if err == sql.ErrNoRows {
+		err = errors.New("no such page")
+	} else if err != nil {
+		err = errors.Wrap(err, "keyOfFile")
+	}
+	return
+}
+
+// AddToReadingList puts the file on the reading list of the key, unread
+func (fs *FileSystem) AddToReadingList(key, fileid string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	keyid, err := fs.keyOfFile(key, fileid)
+	if err != nil {
+		return
+	}
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO readinglist (keyid, fsid, added, read) VALUES (?, ?, ?, NULL)`,
+		keyid, fileid, time.Now().UTC())
+	if err != nil {
+		err = errors.Wrap(err, "AddToReadingList")
+	}
+	return
+}
+
+// SetRead marks a file on the reading list of the key as read or unread
+func (fs *FileSystem) SetRead(key, fileid string, read bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	keyid, err := fs.keyOfFile(key, fileid)
+	if err != nil {
+		return
+	}
+	var readTime interface{}
+	if read {
+		readTime = time.Now().UTC()
+	}
+	result, err := fs.db.Exec(`UPDATE readinglist SET read = ? WHERE keyid = ? AND fsid = ?`, readTime, keyid, fileid)
+	if err != nil {
+		return errors.Wrap(err, "SetRead")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		err = errors.New("the page is not on the reading list")
+	}
+	return
+}
+
+// RemoveFromReadingList takes the file off the reading list of the key
+func (fs *FileSystem) RemoveFromReadingList(key, fileid string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	_, err = fs.db.Exec(`DELETE FROM readinglist WHERE fsid = ? AND keyid = (SELECT id FROM keys WHERE key = ?)`, fileid, key)
+	if err != nil {
+		err = errors.Wrap(err, "RemoveFromReadingList")
+	}
+	return
+}
+
+// GetReadingList returns the reading list of the key, the unread pages
+// first in the order they were added, then the read ones, latest first
+func (fs *FileSystem) GetReadingList(key string) (items []ReadingItem, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	rows, err := fs.db.Query(`
+	SELECT fs.id, fs.slug, fs.created, fs.modified, fs.title, readinglist.added, readinglist.read FROM readinglist
+	INNER JOIN keys ON readinglist.keyid = keys.id
+	INNER JOIN fs ON readinglist.fsid = fs.id
+	WHERE keys.key = ? AND fs.domainid = keys.domainid
+	ORDER BY readinglist.read IS NOT NULL, readinglist.read DESC, readinglist.added`, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetReadingList")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item ReadingItem
+		var title sql.NullString
+		var read sql.NullTime
+		if err = rows.Scan(&item.File.ID, &item.File.Slug, &item.File.Created, &item.File.Modified, &title, &item.Added, &read); err != nil {
+			return nil, errors.Wrap(err, "GetReadingList")
+		}
+		item.File.Title = title.String
+		if read.Valid {
+			item.Read = read.Time
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GetReadingItem returns the file on the reading list of the key, and
+// whether it is on it
+func (fs *FileSystem) GetReadingItem(key, fileid string) (item ReadingItem, queued bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	var read sql.NullTime
+	err = fs.db.QueryRow(`
+	SELECT readinglist.added, readinglist.read FROM readinglist
+	INNER JOIN keys ON readinglist.keyid = keys.id
+	WHERE keys.key = ? AND readinglist.fsid = ?`, key, fileid).Scan(&item.Added, &read)
+	if err == sql.ErrNoRows {
+		return item, false, nil
+	} else if err != nil {
+		return item, false, errors.Wrap(err, "GetReadingItem")
+	}
+	item.File.ID = fileid
+	if read.Valid {
+		item.Read = read.Time
+	}
+	return item, true, nil
+}
+
+// deleteOrphanedReadingLists deletes the reading lists of keys that
+// were deleted
+func (fs *FileSystem) deleteOrphanedReadingLists() (err error) {
+	_, err = fs.db.Exec(`DELETE FROM readinglist WHERE keyid NOT IN (SELECT id FROM keys)`)
+	if err != nil {
+		err = errors.Wrap(err, "deleteOrphanedReadingLists")
+	}
+	return
+}
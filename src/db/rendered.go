@@ -0,0 +1,59 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// Rendering is the HTML of a file, made when it was saved so that it is
+// not made again for every view, with the hash of what it was made from
+type Rendering struct {
+	FileID string
+	HTML   string
+	// Rows is how many rows the editor of the file starts with
+	Rows int
+	Hash string
+}
+
+func (fs *FileSystem) initializeRenderings() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	rendered (
+		fsid TEXT NOT NULL PRIMARY KEY,
+		html TEXT,
+		rows INTEGER,
+		hash TEXT
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating rendered table")
+	}
+	return
+}
+
+// SetRendering keeps the HTML of a file, replacing what it had
+func (fs *FileSystem) SetRendering(r Rendering) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO rendered (fsid, html, rows, hash) VALUES (?, ?, ?, ?)`,
+		r.FileID, r.HTML, r.Rows, r.Hash)
+	if err != nil {
+		err = errors.Wrap(err, "SetRendering")
+	}
+	return
+}
+
+// GetRendering returns the HTML kept for a file, which has no hash if it
+// has none
+func (fs *FileSystem) GetRendering(fileid string) (r Rendering, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	err = fs.db.QueryRow(`SELECT fsid, html, rows, hash FROM rendered WHERE fsid = ?`, fileid).Scan(&r.FileID, &r.HTML, &r.Rows, &r.Hash)
+	if err == sql.ErrNoRows {
+		return Rendering{}, nil
+	}
+	err = errors.Wrap(err, "GetRendering")
+	return
+}
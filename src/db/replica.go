@@ -0,0 +1,72 @@
+package db
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WriteDump writes the whole database as gzipped SQL, which another
+// instance can load with Restore
+func (fs *FileSystem) WriteDump(w io.Writer) (err error) {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.dump(w)
+}
+
+// Restore replaces everything in the database with a dump written by
+// WriteDump. The rows are replaced in one transaction, so that readers
+// see either the old or the new database.
+func (fs *FileSystem) Restore(dump io.Reader) (err error) {
+	gz, err := gzip.NewReader(dump)
+	if err != nil {
+		return errors.Wrap(err, "reading dump")
+	}
+	defer gz.Close()
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return errors.Wrap(err, "reading dump")
+	}
+	script := string(b)
+	if !strings.HasPrefix(script, "BEGIN TRANSACTION;\n") || !strings.HasSuffix(script, "COMMIT;\n") {
+		return errors.New("dump is incomplete")
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	// the full text indexes keep their rows in tables named after them,
+	// which are emptied along with them
+	tables, err := fs.getAllFromPreparedQuerySingleString(`
+	SELECT name FROM sqlite_master
+	WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'fts_%' AND name NOT LIKE 'uploadsfts_%'`)
+	if err != nil {
+		return errors.Wrap(err, "Restore")
+	}
+	deletes := ""
+	for _, table := range tables {
+		deletes += `DELETE FROM "` + strings.Replace(table, `"`, `""`, -1) + `";` + "\n"
+	}
+	script = strings.Replace(script, "BEGIN TRANSACTION;\n", "BEGIN TRANSACTION;\n"+deletes, 1)
+	// the tables and their indexes were already made by New
+	script = strings.Replace(script, "\nCREATE INDEX ", "\nCREATE INDEX IF NOT EXISTS ", -1)
+
+	// the script runs on one connection, so that it can be rolled back
+	// if it fails part of the way through
+	ctx := context.Background()
+	conn, err := fs.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Restore")
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, script)
+	if err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return errors.Wrap(err, "Restore")
+	}
+	return
+}
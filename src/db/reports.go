@@ -0,0 +1,253 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Report is a page that someone reported to the moderators
+type Report struct {
+	ID         int
+	Domain     string
+	FileID     string
+	Slug       string
+	Reason     string
+	Details    string
+	ReporterIP string
+	Created    time.Time
+	Resolution string
+	// SourceIP is the address that last saved the page, and Editor the
+	// anonymous id of whoever last changed it
+	SourceIP string
+	Editor   string
+	Hidden   bool
+	Blocked  bool
+}
+
+func (fs *FileSystem) initializeReports() (err error) {
+	for _, sqlStmt := range []string{
+		`CREATE TABLE IF NOT EXISTS
+		reports (
+			id INTEGER NOT NULL PRIMARY KEY,
+			domain TEXT,
+			fsid TEXT,
+			slug TEXT,
+			reason TEXT,
+			details TEXT,
+			reporterip TEXT,
+			created TIMESTAMP,
+			resolution TEXT DEFAULT ''
+		);`,
+		`CREATE TABLE IF NOT EXISTS
+		sourceips (
+			fsid TEXT NOT NULL PRIMARY KEY,
+			ip TEXT,
+			modified TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS
+		blockedips (
+			ip TEXT NOT NULL PRIMARY KEY,
+			created TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS
+		hidden (
+			fsid TEXT NOT NULL PRIMARY KEY,
+			created TIMESTAMP
+		);`,
+	} {
+		_, err = fs.db.Exec(sqlStmt)
+		if err != nil {
+			return errors.Wrap(err, "creating moderation tables")
+		}
+	}
+	return
+}
+
+// AddReport files a report, unless the same address already has an
+// open report of the page
+func (fs *FileSystem) AddReport(r Report) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	var open int
+	err = fs.db.QueryRow(`SELECT COUNT(*) FROM reports WHERE fsid = ? AND reporterip = ? AND resolution = ''`, r.FileID, r.ReporterIP).Scan(&open)
+	if err != nil {
+		return errors.Wrap(err, "AddReport")
+	}
+	if open > 0 {
+		return
+	}
+	_, err = fs.db.Exec(`INSERT INTO reports (domain, fsid, slug, reason, details, reporterip, created) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.Domain, r.FileID, r.Slug, r.Reason, r.Details, r.ReporterIP, time.Now().UTC())
+	if err != nil {
+		err = errors.Wrap(err, "AddReport")
+	}
+	return
+}
+
+// GetReports returns the reports that are not resolved yet, oldest first
+func (fs *FileSystem) GetReports() (reports []Report, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	rows, err := fs.db.Query(`
+	SELECT reports.id, reports.domain, reports.fsid, reports.slug, reports.reason, reports.details,
+		reports.reporterip, reports.created, IFNULL(sourceips.ip, ''),
+		hidden.fsid IS NOT NULL, blockedips.ip IS NOT NULL,
+		IFNULL((SELECT editor FROM revisioneditors WHERE revisioneditors.fsid = reports.fsid ORDER BY timestamp DESC LIMIT 1), '')
+	FROM reports
+	LEFT JOIN sourceips ON reports.fsid = sourceips.fsid
+	LEFT JOIN hidden ON reports.fsid = hidden.fsid
+	LEFT JOIN blockedips ON sourceips.ip = blockedips.ip
+	WHERE reports.resolution = ''
+	ORDER BY reports.created ASC`)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetReports")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r Report
+		err = rows.Scan(&r.ID, &r.Domain, &r.FileID, &r.Slug, &r.Reason, &r.Details,
+			&r.ReporterIP, &r.Created, &r.SourceIP, &r.Hidden, &r.Blocked, &r.Editor)
+		if err != nil {
+			return nil, errors.Wrap(err, "GetReports")
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// GetReport returns a report
+func (fs *FileSystem) GetReport(id int) (r Report, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	err = fs.db.QueryRow(`
+	SELECT reports.id, reports.domain, reports.fsid, reports.slug, reports.reason, reports.details,
+		reports.reporterip, reports.created, reports.resolution, IFNULL(sourceips.ip, '')
+	FROM reports
+	LEFT JOIN sourceips ON reports.fsid = sourceips.fsid
+	WHERE reports.id = ?`, id).Scan(&r.ID, &r.Domain, &r.FileID, &r.Slug, &r.Reason, &r.Details,
+		&r.ReporterIP, &r.Created, &r.Resolution, &r.SourceIP)
+	if err != nil {
+		err = errors.Wrap(err, "GetReport")
+	}
+	return
+}
+
+// ResolveReports resolves every open report of the page
+func (fs *FileSystem) ResolveReports(fileid, resolution string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`UPDATE reports SET resolution = ? WHERE fsid = ? AND resolution = ''`, resolution, fileid)
+	if err != nil {
+		err = errors.Wrap(err, "ResolveReports")
+	}
+	return
+}
+
+// SetSourceIP remembers the address that last saved the page
+func (fs *FileSystem) SetSourceIP(fileid, ip string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO sourceips (fsid, ip, modified) VALUES (?, ?, ?)`, fileid, ip, time.Now().UTC())
+	if err != nil {
+		err = errors.Wrap(err, "SetSourceIP")
+	}
+	return
+}
+
+// BlockIP keeps an address from saving anything
+func (fs *FileSystem) BlockIP(ip string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`INSERT OR IGNORE INTO blockedips (ip, created) VALUES (?, ?)`, ip, time.Now().UTC())
+	if err != nil {
+		err = errors.Wrap(err, "BlockIP")
+	}
+	return
+}
+
+// IsBlocked returns whether the address is blocked
+func (fs *FileSystem) IsBlocked(ip string) (blocked bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	ips, err := fs.getAllFromPreparedQuerySingleString(`SELECT ip FROM blockedips WHERE ip = ?`, ip)
+	if err != nil {
+		err = errors.Wrap(err, "IsBlocked")
+		return
+	}
+	blocked = len(ips) > 0
+	return
+}
+
+// SetHidden hides a page from everyone but the moderators, or shows it again
+func (fs *FileSystem) SetHidden(fileid string, hidden bool) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if hidden {
+		_, err = fs.db.Exec(`INSERT OR IGNORE INTO hidden (fsid, created) VALUES (?, ?)`, fileid, time.Now().UTC())
+	} else {
+		_, err = fs.db.Exec(`DELETE FROM hidden WHERE fsid = ?`, fileid)
+	}
+	if err != nil {
+		err = errors.Wrap(err, "SetHidden")
+	}
+	return
+}
+
+// IsHidden returns whether a moderator hid the page
+func (fs *FileSystem) IsHidden(fileid string) (hidden bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	ids, err := fs.getAllFromPreparedQuerySingleString(`SELECT fsid FROM hidden WHERE fsid = ?`, fileid)
+	if err != nil {
+		err = errors.Wrap(err, "IsHidden")
+		return
+	}
+	hidden = len(ids) > 0
+	return
+}
+
+// Delete removes a page and everything about it
+func (fs *FileSystem) Delete(fileid string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.delete(fileid)
+}
+
+func (fs *FileSystem) delete(fileid string) (err error) {
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin Delete")
+	}
+	for _, sqlStmt := range []string{
+		`DELETE FROM fs WHERE id = ?1`,
+		`DELETE FROM fts WHERE id = ?1`,
+		`DELETE FROM pins WHERE fsid = ?1`,
+		`DELETE FROM similar WHERE fsid = ?1 OR fsid_similar = ?1`,
+		`DELETE FROM hidden WHERE fsid = ?1`,
+		`DELETE FROM revisioneditors WHERE fsid = ?1`,
+		`DELETE FROM pageaccess WHERE fsid = ?1`,
+		`DELETE FROM duetasks WHERE fsid = ?1`,
+		`DELETE FROM tags WHERE fsid = ?1`,
+		`DELETE FROM pushsubscriptions WHERE fsid = ?1`,
+		`DELETE FROM embeddings WHERE fsid = ?1`,
+		`DELETE FROM summaries WHERE fsid = ?1`,
+		`DELETE FROM rendered WHERE fsid = ?1`,
+		`DELETE FROM simwords WHERE fsid = ?1`,
+	} {
+		if _, err = tx.Exec(sqlStmt, fileid); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "Delete")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "commit Delete")
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+const (
+	// similarCount is how many similar pages are kept for each page
+	similarCount = 5
+	// similarWords is how many distinct words of a page are compared
+	similarWords = 1000
+)
+
+// initializeSimilar adds the scores of the similar pages, and the words
+// of each page that they are found by
+func (fs *FileSystem) initializeSimilar() (err error) {
+	err = fs.addColumn("similar", "score", "REAL DEFAULT 0")
+	if err != nil {
+		return
+	}
+	_, err = fs.db.Exec(`CREATE INDEX IF NOT EXISTS idx_similar_fsid ON similar(fsid);
+	CREATE INDEX IF NOT EXISTS idx_similar_fsid_similar ON similar(fsid_similar);`)
+	if err != nil {
+		return errors.Wrap(err, "indexing similar")
+	}
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	simwords (
+		fsid TEXT,
+		domainid INTEGER,
+		word TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_simwords_fsid ON simwords(fsid);
+	CREATE INDEX IF NOT EXISTS idx_simwords_word ON simwords(domainid, word);`
+	return fs.initializeIndex("simwords", sqlStmt, fs.saveSimilar)
+}
+
+// similarityWords returns the distinct words of the text that pages are
+// compared by
+func similarityWords(text string) (words []string) {
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(word) < 3 || utils.IsStopWord(word) || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+		if len(words) == similarWords {
+			break
+		}
+	}
+	sort.Strings(words)
+	return
+}
+
+// saveSimilar keeps the words of the file and updates the pages similar
+// to it, and the pages that it is now similar to, by the share of words
+// they have in common. Only the pages that share a word with the file
+// are compared, so the similar pages of the rest stay as they are.
+func (fs *FileSystem) saveSimilar(f File, domainid int) (err error) {
+	words := similarityWords(f.Data)
+	var saved []string
+	rows, err := fs.db.Query(`SELECT word FROM simwords WHERE fsid = ? ORDER BY word`, f.ID)
+	if err != nil {
+		return errors.Wrap(err, "saveSimilar")
+	}
+	for rows.Next() {
+		var word string
+		if err = rows.Scan(&word); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "saveSimilar")
+		}
+		saved = append(saved, word)
+	}
+	rows.Close()
+	if len(saved) > 0 && sameWords(saved, words) {
+		return
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin saveSimilar")
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM simwords WHERE fsid = ?`, f.ID); err != nil {
+		return errors.Wrap(err, "exec saveSimilar")
+	}
+	for _, word := range words {
+		if _, err = tx.Exec(`INSERT INTO simwords (fsid, domainid, word) VALUES (?, ?, ?)`, f.ID, domainid, word); err != nil {
+			return errors.Wrap(err, "exec saveSimilar")
+		}
+	}
+
+	// the pages sharing words, with how many they share and have
+	var ids []string
+	scores := make(map[string]float64)
+	rows, err = tx.Query(`SELECT other.fsid, COUNT(*),
+		(SELECT COUNT(*) FROM simwords AS counted WHERE counted.fsid = other.fsid)
+	FROM simwords AS page
+	INNER JOIN simwords AS other ON other.domainid = page.domainid AND other.word = page.word
+	WHERE page.fsid = ? AND other.fsid != page.fsid
+	GROUP BY other.fsid`, f.ID)
+	if err != nil {
+		return errors.Wrap(err, "saveSimilar")
+	}
+	for rows.Next() {
+		var id string
+		var shared, count int
+		if err = rows.Scan(&id, &shared, &count); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "saveSimilar")
+		}
+		ids = append(ids, id)
+		scores[id] = float64(shared) / float64(len(words)+count-shared)
+	}
+	rows.Close()
+	sort.SliceStable(ids, func(i, j int) bool { return scores[ids[i]] > scores[ids[j]] })
+
+	if _, err = tx.Exec(`DELETE FROM similar WHERE fsid = ?1 OR fsid_similar = ?1`, f.ID); err != nil {
+		return errors.Wrap(err, "exec saveSimilar")
+	}
+	for i, id := range ids {
+		if i == similarCount {
+			break
+		}
+		if _, err = tx.Exec(`INSERT INTO similar (fsid, fsid_similar, score) VALUES (?, ?, ?)`, f.ID, id, scores[id]); err != nil {
+			return errors.Wrap(err, "exec saveSimilar")
+		}
+	}
+
+	// the file goes into the similar pages of the others where it is
+	// among the most similar
+	type kept struct {
+		count int
+		least float64
+	}
+	others := make(map[string]kept)
+	rows, err = tx.Query(`SELECT similar.fsid, COUNT(*), MIN(similar.score) FROM similar
+	INNER JOIN fs ON fs.id = similar.fsid
+	WHERE fs.domainid = ?
+	GROUP BY similar.fsid`, domainid)
+	if err != nil {
+		return errors.Wrap(err, "saveSimilar")
+	}
+	for rows.Next() {
+		var id string
+		var k kept
+		if err = rows.Scan(&id, &k.count, &k.least); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "saveSimilar")
+		}
+		others[id] = k
+	}
+	rows.Close()
+	for _, id := range ids {
+		k := others[id]
+		if k.count >= similarCount {
+			if scores[id] <= k.least {
+				continue
+			}
+			_, err = tx.Exec(`DELETE FROM similar WHERE id IN
+			(SELECT id FROM similar WHERE fsid = ? ORDER BY score LIMIT 1)`, id)
+			if err != nil {
+				return errors.Wrap(err, "exec saveSimilar")
+			}
+		}
+		if _, err = tx.Exec(`INSERT INTO similar (fsid, fsid_similar, score) VALUES (?, ?, ?)`, id, f.ID, scores[id]); err != nil {
+			return errors.Wrap(err, "exec saveSimilar")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		err = errors.Wrap(err, "commit saveSimilar")
+	}
+	return
+}
+
+func sameWords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetSimilar replaces the pages similar to the file, the most similar
+// first with their scores
+func (fs *FileSystem) SetSimilar(id string, similarids []string, scores []float64) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin SetSimilar")
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM similar WHERE fsid = ?`, id); err != nil {
+		return errors.Wrap(err, "exec SetSimilar")
+	}
+	for i, similarid := range similarids {
+		if _, err = tx.Exec(`INSERT INTO similar (fsid, fsid_similar, score) VALUES (?, ?, ?)`, id, similarid, scores[i]); err != nil {
+			return errors.Wrap(err, "exec SetSimilar")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		err = errors.Wrap(err, "commit SetSimilar")
+	}
+	return
+}
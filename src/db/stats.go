@@ -0,0 +1,244 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// blobReference matches the ids of uploads in the text of pages, in
+// links to them and in macros like drawing
+var blobReference = regexp.MustCompile(`sha256-[0-9a-f]{64}`)
+
+// pageTables are the tables with rows about pages, by the column of the
+// id of the page, which are left behind if a page is gone
+var pageTables = []struct{ Table, Column string }{
+	{"pins", "fsid"},
+	{"tags", "fsid"},
+	{"simwords", "fsid"},
+	{"similar", "fsid"},
+	{"duetasks", "fsid"},
+	{"rendered", "fsid"},
+	{"summaries", "fsid"},
+	{"embeddings", "fsid"},
+	{"pageaccess", "fsid"},
+	{"revisioneditors", "fsid"},
+	{"readinglist", "fsid"},
+	{"pushsubscriptions", "fsid"},
+	{"sourceips", "fsid"},
+	{"hidden", "fsid"},
+}
+
+// Stats is how much the instance keeps, for planning its capacity
+type Stats struct {
+	Domains []DomainStats `json:"domains"`
+	// Uploads and UploadBytes count all the uploads, which domains can
+	// share
+	Uploads      int          `json:"uploads"`
+	UploadBytes  int64        `json:"upload_bytes"`
+	LargestPages []PageStats  `json:"largest_pages"`
+	Orphans      []OrphanStat `json:"orphans"`
+}
+
+// DomainStats is how much a domain keeps
+type DomainStats struct {
+	Name     string `json:"name"`
+	Public   bool   `json:"public"`
+	Pages    int    `json:"pages"`
+	Archived int    `json:"archived"`
+	// TextBytes is the text of the pages and HistoryBytes their revisions
+	TextBytes    int64 `json:"text_bytes"`
+	HistoryBytes int64 `json:"history_bytes"`
+	// Uploads are the uploads that the pages of the domain mention
+	Uploads     int   `json:"uploads"`
+	UploadBytes int64 `json:"upload_bytes"`
+	Keys        int   `json:"keys"`
+	// Visits are the hits in the access log of the domain, if it keeps one
+	Visits int `json:"visits"`
+}
+
+// PageStats is how much a page keeps
+type PageStats struct {
+	Domain       string `json:"domain"`
+	ID           string `json:"id"`
+	Slug         string `json:"slug"`
+	TextBytes    int64  `json:"text_bytes"`
+	HistoryBytes int64  `json:"history_bytes"`
+}
+
+// OrphanStat counts the rows of a table that nothing uses anymore
+type OrphanStat struct {
+	What  string `json:"what"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes,omitempty"`
+}
+
+// Stats counts the domains, pages and uploads of the instance, with the
+// top largest pages and the data that is left over from deleted pages
+// and domains
+func (fs *FileSystem) Stats(top int) (stats Stats, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if stats.Domains, err = fs.domainStats(); err != nil {
+		return
+	}
+	if stats.LargestPages, err = fs.largestPages(top); err != nil {
+		return
+	}
+	blobs, err := fs.blobSizes()
+	if err != nil {
+		return
+	}
+	for _, size := range blobs {
+		stats.Uploads++
+		stats.UploadBytes += size
+	}
+
+	// the uploads of a domain are the ones that its pages mention
+	rows, err := fs.db.Query(`SELECT domains.name, fts.data FROM fts
+	INNER JOIN fs ON fs.id=fts.id
+	INNER JOIN domains ON fs.domainid=domains.id`)
+	if err != nil {
+		err = errors.Wrap(err, "Stats")
+		return
+	}
+	defer rows.Close()
+	mentioned := make(map[string]bool)
+	byDomain := make(map[string]map[string]bool)
+	for rows.Next() {
+		var domain, data string
+		if err = rows.Scan(&domain, &data); err != nil {
+			err = errors.Wrap(err, "Stats")
+			return
+		}
+		for _, id := range blobReference.FindAllString(data, -1) {
+			if _, ok := blobs[id]; !ok {
+				continue
+			}
+			if byDomain[domain] == nil {
+				byDomain[domain] = make(map[string]bool)
+			}
+			byDomain[domain][id] = true
+			mentioned[id] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		err = errors.Wrap(err, "Stats")
+		return
+	}
+	for i, d := range stats.Domains {
+		for id := range byDomain[d.Name] {
+			stats.Domains[i].Uploads++
+			stats.Domains[i].UploadBytes += blobs[id]
+		}
+	}
+
+	unused := OrphanStat{What: "uploads that no page mentions"}
+	for id, size := range blobs {
+		if !mentioned[id] {
+			unused.Rows++
+			unused.Bytes += size
+		}
+	}
+	stats.Orphans, err = fs.orphans()
+	stats.Orphans = append([]OrphanStat{unused}, stats.Orphans...)
+	return
+}
+
+func (fs *FileSystem) domainStats() (domains []DomainStats, err error) {
+	rows, err := fs.db.Query(`SELECT domains.name, domains.ispublic,
+		(SELECT COUNT(*) FROM fs WHERE fs.domainid=domains.id),
+		(SELECT COUNT(*) FROM fs WHERE fs.domainid=domains.id AND fs.archived=1),
+		(SELECT COALESCE(SUM(LENGTH(fts.data)), 0) FROM fts INNER JOIN fs ON fs.id=fts.id WHERE fs.domainid=domains.id),
+		(SELECT COALESCE(SUM(LENGTH(fs.history)), 0) FROM fs WHERE fs.domainid=domains.id),
+		(SELECT COUNT(*) FROM keys WHERE keys.domainid=domains.id),
+		(SELECT COALESCE(SUM(hits), 0) FROM accesslog WHERE accesslog.domainid=domains.id)
+	FROM domains ORDER BY domains.name`)
+	if err != nil {
+		return nil, errors.Wrap(err, "domainStats")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d DomainStats
+		var ispublic int
+		if err = rows.Scan(&d.Name, &ispublic, &d.Pages, &d.Archived, &d.TextBytes, &d.HistoryBytes, &d.Keys, &d.Visits); err != nil {
+			return nil, errors.Wrap(err, "domainStats")
+		}
+		d.Public = ispublic == 1
+		domains = append(domains, d)
+	}
+	return domains, errors.Wrap(rows.Err(), "domainStats")
+}
+
+// largestPages returns the pages that keep the most, with their revisions
+func (fs *FileSystem) largestPages(top int) (pages []PageStats, err error) {
+	rows, err := fs.db.Query(`SELECT COALESCE(domains.name, ''), fs.id, fs.slug,
+		COALESCE(LENGTH(fts.data), 0), COALESCE(LENGTH(fs.history), 0) FROM fs
+	LEFT JOIN fts ON fs.id=fts.id
+	LEFT JOIN domains ON fs.domainid=domains.id
+	ORDER BY COALESCE(LENGTH(fts.data), 0) + COALESCE(LENGTH(fs.history), 0) DESC
+	LIMIT ?`, top)
+	if err != nil {
+		return nil, errors.Wrap(err, "largestPages")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p PageStats
+		if err = rows.Scan(&p.Domain, &p.ID, &p.Slug, &p.TextBytes, &p.HistoryBytes); err != nil {
+			return nil, errors.Wrap(err, "largestPages")
+		}
+		pages = append(pages, p)
+	}
+	return pages, errors.Wrap(rows.Err(), "largestPages")
+}
+
+// blobSizes returns the size of every upload by its id
+func (fs *FileSystem) blobSizes() (sizes map[string]int64, err error) {
+	sizes = make(map[string]int64)
+	rows, err := fs.db.Query(`SELECT id, COALESCE(LENGTH(data), 0) FROM blobs`)
+	if err != nil {
+		return nil, errors.Wrap(err, "blobSizes")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var size int64
+		if err = rows.Scan(&id, &size); err != nil {
+			return nil, errors.Wrap(err, "blobSizes")
+		}
+		sizes[id] = size
+	}
+	return sizes, errors.Wrap(rows.Err(), "blobSizes")
+}
+
+// orphans counts the rows that are about pages or domains that are gone
+func (fs *FileSystem) orphans() (orphans []OrphanStat, err error) {
+	queries := []struct{ what, query string }{
+		{"texts without a page", `SELECT COUNT(*) FROM fts WHERE id NOT IN (SELECT id FROM fs)`},
+		{"pages without a domain", `SELECT COUNT(*) FROM fs WHERE domainid NOT IN (SELECT id FROM domains)`},
+		{"keys without a domain", `SELECT COUNT(*) FROM keys WHERE domainid NOT IN (SELECT id FROM domains)`},
+		{"visits without a domain", `SELECT COUNT(*) FROM accesslog WHERE domainid NOT IN (SELECT id FROM domains)`},
+	}
+	for _, t := range pageTables {
+		// the names of the tables are constants, so they can be put in
+		// the query
+		queries = append(queries, struct{ what, query string }{
+			t.Table + " of pages that are gone",
+			fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s NOT IN (SELECT id FROM fs)`, t.Table, t.Column),
+		})
+	}
+	for _, q := range queries {
+		o := OrphanStat{What: q.what}
+		if err = fs.db.QueryRow(q.query).Scan(&o.Rows); err != nil {
+			return nil, errors.Wrap(err, "orphans")
+		}
+		orphans = append(orphans, o)
+	}
+	sort.SliceStable(orphans, func(i, j int) bool {
+		return orphans[i].Rows > orphans[j].Rows
+	})
+	return
+}
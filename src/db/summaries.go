@@ -0,0 +1,80 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Summary is a short summary of the text of a file, with the hash of the
+// text it was made from
+type Summary struct {
+	FileID string
+	Text   string
+	Hash   string
+	// Summarizer is the model that wrote the summary, or "extract" if
+	// it is sentences of the text
+	Summarizer string
+	Created    time.Time
+}
+
+func (fs *FileSystem) initializeSummaries() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	summaries (
+		fsid TEXT NOT NULL PRIMARY KEY,
+		summary TEXT,
+		hash TEXT,
+		summarizer TEXT,
+		created TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating summaries table")
+	}
+	return
+}
+
+// SetSummary keeps the summary of a file, replacing the one it had
+func (fs *FileSystem) SetSummary(s Summary) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, err = fs.db.Exec(`INSERT OR REPLACE INTO summaries (fsid, summary, hash, summarizer, created) VALUES (?, ?, ?, ?, ?)`,
+		s.FileID, s.Text, s.Hash, s.Summarizer, time.Now().UTC())
+	if err != nil {
+		err = errors.Wrap(err, "SetSummary")
+	}
+	return
+}
+
+// GetSummaries returns the summaries of the files that have one, by the
+// id of the file
+func (fs *FileSystem) GetSummaries(fileids []string) (summaries map[string]Summary, err error) {
+	summaries = make(map[string]Summary)
+	if len(fileids) == 0 {
+		return
+	}
+	args := make([]interface{}, len(fileids))
+	for i, id := range fileids {
+		args[i] = id
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	rows, err := fs.db.Query(`SELECT fsid, summary, hash, summarizer, created FROM summaries
+	WHERE fsid IN (?`+strings.Repeat(", ?", len(fileids)-1)+`)`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetSummaries")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Summary
+		if err = rows.Scan(&s.FileID, &s.Text, &s.Hash, &s.Summarizer, &s.Created); err != nil {
+			return nil, errors.Wrap(err, "GetSummaries")
+		}
+		summaries[s.FileID] = s
+	}
+	err = errors.Wrap(rows.Err(), "GetSummaries")
+	return
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+func (fs *FileSystem) initializeTags() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	tags (
+		fsid TEXT,
+		domainid INTEGER,
+		tag TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_tags_fsid ON tags(fsid);
+	CREATE INDEX IF NOT EXISTS idx_tags_tag ON tags(domainid, tag);`
+	return fs.initializeIndex("tags", sqlStmt, fs.saveTags)
+}
+
+// saveTags keeps the tags of the file, so that files can be searched by
+// their tags
+func (fs *FileSystem) saveTags(f File, domainid int) (err error) {
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin saveTags")
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM tags WHERE fsid = ?`, f.ID); err != nil {
+		return errors.Wrap(err, "exec saveTags")
+	}
+	for _, tag := range utils.Tags(f.Data) {
+		if _, err = tx.Exec(`INSERT INTO tags (fsid, domainid, tag) VALUES (?, ?, ?)`, f.ID, domainid, tag); err != nil {
+			return errors.Wrap(err, "exec saveTags")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		err = errors.Wrap(err, "commit saveTags")
+	}
+	return
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// DueTask is an item of a task list of a page that is due on a date
+type DueTask struct {
+	Domain string
+	FileID string
+	Slug   string
+	Title  string
+	utils.Task
+}
+
+// Page returns the file that the task is on, to link to it
+func (t DueTask) Page() File {
+	return File{ID: t.FileID, Slug: t.Slug, Title: t.Title, Domain: t.Domain}
+}
+
+func (fs *FileSystem) initializeTasks() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	duetasks (
+		fsid TEXT,
+		domainid INTEGER,
+		line INTEGER,
+		task TEXT,
+		due TEXT,
+		done INTEGER DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_duetasks_fsid ON duetasks(fsid);
+	CREATE INDEX IF NOT EXISTS idx_duetasks_due ON duetasks(domainid, due);`
+	return fs.initializeIndex("duetasks", sqlStmt, fs.saveTasks)
+}
+
+// initializeIndex makes a table that keeps something found in the text
+// of files, finding it in every file when the table is new
+func (fs *FileSystem) initializeIndex(table, sqlStmt string, save func(f File, domainid int) error) (err error) {
+	var exists int
+	err = fs.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&exists)
+	if err != nil {
+		return errors.Wrap(err, "checking "+table+" table")
+	}
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		return errors.Wrap(err, "creating "+table+" table")
+	}
+	if exists > 0 {
+		return
+	}
+
+	rows, err := fs.db.Query(`SELECT fs.id, fs.domainid, fts.data FROM fs INNER JOIN fts ON fts.id = fs.id`)
+	if err != nil {
+		return errors.Wrap(err, "indexing "+table)
+	}
+	var files []File
+	var domainids []int
+	for rows.Next() {
+		var f File
+		var domainid int
+		if err = rows.Scan(&f.ID, &domainid, &f.Data); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "indexing "+table)
+		}
+		files = append(files, f)
+		domainids = append(domainids, domainid)
+	}
+	rows.Close()
+	for i, f := range files {
+		if err = save(f, domainids[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// saveTasks keeps the tasks with due dates of the file
+func (fs *FileSystem) saveTasks(f File, domainid int) (err error) {
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin saveTasks")
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM duetasks WHERE fsid = ?`, f.ID); err != nil {
+		return errors.Wrap(err, "exec saveTasks")
+	}
+	for _, t := range utils.DueTasks(f.Data) {
+		_, err = tx.Exec(`INSERT INTO duetasks (fsid, domainid, line, task, due, done) VALUES (?, ?, ?, ?, ?, ?)`,
+			f.ID, domainid, t.Line, t.Text, t.Due.Format("2006-01-02"), t.Done)
+		if err != nil {
+			return errors.Wrap(err, "exec saveTasks")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		err = errors.Wrap(err, "commit saveTasks")
+	}
+	return
+}
+
+// GetDueTasks returns the tasks that are not done and are due before the
+// time, or whenever if it is zero, soonest first. Without a domain it
+// returns those of every domain.
+func (fs *FileSystem) GetDueTasks(domain string, before time.Time) (tasks []DueTask, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	until := "9999-12-31"
+	if !before.IsZero() {
+		until = before.Format("2006-01-02")
+	}
+	rows, err := fs.db.Query(`
+	SELECT domains.name, fs.id, fs.slug, fs.title, duetasks.line, duetasks.task, duetasks.due
+	FROM duetasks
+	INNER JOIN fs ON fs.id = duetasks.fsid
+	INNER JOIN domains ON domains.id = duetasks.domainid
+	WHERE duetasks.done = 0 AND duetasks.due < ? AND (? = '' OR domains.name = ?)
+	ORDER BY duetasks.due, fs.id, duetasks.line`, until, domain, domain)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetDueTasks")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t DueTask
+		var slug, title *string
+		var due string
+		if err = rows.Scan(&t.Domain, &t.FileID, &slug, &title, &t.Line, &t.Text, &due); err != nil {
+			return nil, errors.Wrap(err, "GetDueTasks")
+		}
+		if slug != nil {
+			t.Slug = *slug
+		}
+		if title != nil {
+			t.Title = *title
+		}
+		t.Due, _ = time.Parse("2006-01-02", due)
+		tasks = append(tasks, t)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// ImportFile saves a file copied from another instance as it is, with
+// its revisions, times and views. A file that the domain already has is
+// only replaced by a file that was modified later, and a file whose id
+// another domain has gets a new id, which is returned. Like a save, it
+// returns ErrNoAccess or ErrReadOnly if the editor may not change the
+// file that the domain has.
+func (fs *FileSystem) ImportFile(f File) (id string, imported bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, _ := fs.getDomainFromName(f.Domain)
+	if domainid == 0 {
+		err = errors.New("domain does not exist")
+		return
+	}
+	owner, err := fs.fileDomainID(f.ID)
+	if err != nil {
+		return
+	}
+	if owner != 0 && owner != domainid {
+		// the same file gets the same new id when it is imported again
+		f.ID = fmt.Sprintf("%x", sha256.Sum256([]byte(f.Domain+"/"+f.ID)))[:20]
+		if owner, err = fs.fileDomainID(f.ID); err != nil {
+			return
+		}
+		if owner != 0 && owner != domainid {
+			f.ID = utils.UUID()
+			owner = 0
+		}
+	}
+	var existing []File
+	if owner == domainid {
+		if err = fs.checkWrite(f.ID, f.Editor); err != nil {
+			return
+		}
+		existing, _ = fs.get(f.ID, f.Domain)
+		if len(existing) > 0 && existing[0].ID == f.ID && !f.Modified.After(existing[0].Modified) {
+			return f.ID, false, nil
+		}
+	}
+	id = f.ID
+	f.Title = utils.Title(f.Data)
+	err = fs.checkLimits(f, domainid, existing)
+	if err != nil {
+		return
+	}
+
+	historyBytes, _ := json.Marshal(f.History)
+	err = fs.importRow(f, domainid, string(historyBytes))
+	if isUniqueConstraintError(err) {
+		// another page of the domain has the slug
+		f.Slug = ""
+		err = fs.importRow(f, domainid, string(historyBytes))
+	}
+	if err != nil {
+		return
+	}
+	err = fs.saveIndex(f, domainid)
+	if err != nil {
+		return
+	}
+	err = fs.saveTasks(f, domainid)
+	if err != nil {
+		return
+	}
+	err = fs.saveTags(f, domainid)
+	if err != nil {
+		return
+	}
+	err = fs.saveSimilar(f, domainid)
+	imported = err == nil
+	return
+}
+
+// fileDomainID returns the id of the domain of a file, or 0 if there is
+// no file with the id
+func (fs *FileSystem) fileDomainID(id string) (domainid int, err error) {
+	err = fs.db.QueryRow(`SELECT domainid FROM fs WHERE id = ?`, id).Scan(&domainid)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		err = errors.Wrap(err, "fileDomainID")
+	}
+	return
+}
+
+func (fs *FileSystem) importRow(f File, domainid int, historyJSON string) (err error) {
+	stmt, err := fs.db.Prepare(`
+	INSERT INTO fs (id, domainid, slug, created, modified, history, views, archived, title)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		slug = excluded.slug,
+		created = excluded.created,
+		modified = excluded.modified,
+		history = excluded.history,
+		views = excluded.views,
+		archived = excluded.archived,
+		title = excluded.title`)
+	if err != nil {
+		return errors.Wrap(err, "stmt importRow")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(f.ID, domainid, f.Slug, f.Created.UTC(), f.Modified.UTC(), historyJSON, f.Views, f.Archived, f.Title)
+	if err != nil {
+		err = errors.Wrap(err, "exec importRow")
+	}
+	return
+}
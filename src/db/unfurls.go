@@ -0,0 +1,72 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Unfurl is the preview of a link, cached after it is fetched
+type Unfurl struct {
+	URL         string
+	Title       string
+	Description string
+	Icon        string
+	Fetched     time.Time
+}
+
+func (fs *FileSystem) initializeUnfurls() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	unfurls (
+		url TEXT NOT NULL PRIMARY KEY,
+		title TEXT,
+		description TEXT,
+		icon TEXT,
+		fetched TIMESTAMP
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating unfurls table")
+	}
+	return
+}
+
+// GetUnfurl returns the cached preview of the link, if there is one
+func (fs *FileSystem) GetUnfurl(link string) (u Unfurl, found bool, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	stmt, err := fs.db.Prepare(`SELECT url, title, description, icon, fetched FROM unfurls WHERE url = ?`)
+	if err != nil {
+		err = errors.Wrap(err, "stmt GetUnfurl")
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(link).Scan(&u.URL, &u.Title, &u.Description, &u.Icon, &u.Fetched)
+	if err == sql.ErrNoRows {
+		return u, false, nil
+	} else if err != nil {
+		err = errors.Wrap(err, "GetUnfurl")
+		return
+	}
+	found = true
+	return
+}
+
+// SaveUnfurl caches the preview of a link
+func (fs *FileSystem) SaveUnfurl(u Unfurl) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	stmt, err := fs.db.Prepare(`INSERT OR REPLACE INTO unfurls (url, title, description, icon, fetched) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return errors.Wrap(err, "stmt SaveUnfurl")
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(u.URL, u.Title, u.Description, u.Icon, u.Fetched.UTC())
+	if err != nil {
+		return errors.Wrap(err, "exec SaveUnfurl")
+	}
+	return
+}
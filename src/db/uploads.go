@@ -0,0 +1,155 @@
+package db
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/search"
+)
+
+// Upload is a document uploaded to a domain whose text is searched along
+// with the pages of the domain
+type Upload struct {
+	ID          string
+	Name        string
+	Created     time.Time
+	SnippetHTML template.HTML
+	// Pages are the pages of the domain that link to the upload
+	Pages []File
+}
+
+// Link returns the link to the upload
+func (u Upload) Link() string {
+	return "/uploads/" + u.ID + "?filename=" + url.QueryEscape(u.Name)
+}
+
+func (fs *FileSystem) initializeUploads() (err error) {
+	sqlStmt := `CREATE TABLE IF NOT EXISTS
+	uploads (
+		blobid TEXT,
+		domainid INTEGER,
+		name TEXT,
+		created TIMESTAMP,
+		PRIMARY KEY (blobid, domainid)
+	);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		return errors.Wrap(err, "creating uploads table")
+	}
+
+	// the text is kept apart from the pages, so that it does not show
+	// up as a page in the listings
+	sqlStmt = `CREATE VIRTUAL TABLE IF NOT EXISTS
+		uploadsfts USING fts4 (blobid,domainid,data);`
+	_, err = fs.db.Exec(sqlStmt)
+	if err != nil {
+		err = errors.Wrap(err, "creating uploadsfts table")
+	}
+	return
+}
+
+// SaveUploadText keeps the text of a document uploaded to the domain, so
+// that searching the domain finds it
+func (fs *FileSystem) SaveUploadText(blobID, domain, name, text string) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	domainid, _, _, err := fs.getDomainFromName(domain)
+	if err != nil {
+		return errors.Wrap(err, "SaveUploadText")
+	}
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin SaveUploadText")
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`INSERT OR REPLACE INTO uploads (blobid, domainid, name, created) VALUES (?, ?, ?, ?)`,
+		blobID, domainid, name, time.Now().UTC()); err != nil {
+		return errors.Wrap(err, "exec SaveUploadText")
+	}
+	if _, err = tx.Exec(`DELETE FROM uploadsfts WHERE blobid = ? AND domainid = ?`, blobID, domainid); err != nil {
+		return errors.Wrap(err, "exec SaveUploadText")
+	}
+	if _, err = tx.Exec(`INSERT INTO uploadsfts (blobid, domainid, data) VALUES (?, ?, ?)`, blobID, domainid, text); err != nil {
+		return errors.Wrap(err, "exec SaveUploadText")
+	}
+	if err = tx.Commit(); err != nil {
+		err = errors.Wrap(err, "commit SaveUploadText")
+	}
+	return
+}
+
+// SearchUploads returns the documents uploaded to the domain whose text
+// matches the search, newest first, with the pages of the domain that
+// link to them. Only the words of a search are looked for in documents,
+// so a search by title or tag finds none.
+func (fs *FileSystem) SearchUploads(q search.Query, domain string, includeArchived bool) (uploads []Upload, err error) {
+	match := q.Match()
+	if match == "" || len(q.Titles) > 0 || len(q.Tags) > 0 {
+		return
+	}
+	where := []string{"uploadsfts.data MATCH ?", "domains.name = ?"}
+	// the matches are marked by characters that are not in text, to
+	// make them bold once the text is escaped
+	args := []interface{}{"\x01", "\x02", match, domain}
+	if !q.After.IsZero() {
+		where = append(where, "uploads.created >= ?")
+		args = append(args, q.After.UTC())
+	}
+	if !q.Before.IsZero() {
+		where = append(where, "uploads.created < ?")
+		args = append(args, q.Before.UTC())
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+	rows, err := fs.db.Query(`
+		SELECT uploads.blobid,uploads.name,uploads.created,snippet(uploadsfts,?,?,'...',2) FROM uploadsfts
+			INNER JOIN uploads ON uploads.blobid=uploadsfts.blobid AND uploads.domainid=uploadsfts.domainid
+			INNER JOIN domains ON uploads.domainid=domains.id
+			WHERE `+strings.Join(where, "\n\t\t\tAND ")+`
+			ORDER BY uploads.created DESC`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "SearchUploads")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u Upload
+		var snippet string
+		if err = rows.Scan(&u.ID, &u.Name, &u.Created, &snippet); err != nil {
+			return nil, errors.Wrap(err, "SearchUploads")
+		}
+		u.SnippetHTML = snippetHTML(snippet)
+		uploads = append(uploads, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "SearchUploads")
+	}
+	rows.Close()
+
+	for i, u := range uploads {
+		uploads[i].Pages, err = fs.getAllFromPreparedQuery(`
+		SELECT fs.id,fs.slug,fs.created,fs.modified,'',fs.history,fs.views,fs.archived,fs.title FROM fts
+			INNER JOIN fs ON fs.id=fts.id
+			INNER JOIN domains ON fs.domainid=domains.id
+			WHERE fts.data MATCH ?
+			AND domains.name = ?
+			AND (? OR fs.archived = 0)
+			ORDER BY fs.modified DESC`, `"`+u.ID+`"`, domain, includeArchived)
+		if err != nil {
+			return nil, errors.Wrap(err, "SearchUploads")
+		}
+	}
+	return
+}
+
+// snippetHTML escapes the text of a snippet, which comes from a
+// document, and makes its matches bold
+func snippetHTML(snippet string) template.HTML {
+	snippet = template.HTMLEscapeString(snippet)
+	snippet = strings.Replace(snippet, "\x01", "<b>", -1)
+	return template.HTML(strings.Replace(snippet, "\x02", "</b>", -1))
+}
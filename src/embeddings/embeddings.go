@@ -0,0 +1,138 @@
+// Package embeddings gets embeddings of texts from an OpenAI-compatible
+// API, like OpenAI's or a local model served by Ollama or llama.cpp, and
+// finds the embeddings that are nearest to another.
+package embeddings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Client gets embeddings from an API
+type Client struct {
+	// URL is where the API is, like https://api.openai.com/v1 or
+	// http://localhost:11434/v1, without /embeddings
+	URL string
+	// Model is the model that makes the embeddings
+	Model string
+	// Key is sent as a bearer token, if there is one
+	Key  string
+	HTTP *http.Client
+}
+
+// New returns a client of the API at the URL
+func New(url, model, key string) *Client {
+	return &Client{
+		URL:   strings.TrimSuffix(url, "/"),
+		Model: model,
+		Key:   key,
+		HTTP:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+type request struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type response struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed returns the embeddings of the texts, in the same order
+func (c *Client) Embed(texts []string) (vectors [][]float32, err error) {
+	if len(texts) == 0 {
+		return
+	}
+	body, err := json.Marshal(request{Model: c.Model, Input: texts})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", c.URL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("embeddings: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var r response
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return
+	}
+	vectors = make([][]float32, len(texts))
+	for _, d := range r.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, errors.New("embeddings: response has an unknown index")
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for _, v := range vectors {
+		if len(v) == 0 {
+			return nil, errors.New("embeddings: response is missing an embedding")
+		}
+	}
+	return
+}
+
+// Cosine is the cosine similarity of two embeddings, from -1 to 1, or 0
+// if they are not the same length
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// Match is an embedding that is near another
+type Match struct {
+	Index      int
+	Similarity float64
+}
+
+// Nearest returns the k embeddings that are most similar to the query,
+// the most similar first. The pages of a domain are few enough to
+// compare the query with each of them, which finds the nearest exactly.
+func Nearest(query []float32, vectors [][]float32, k int) (matches []Match) {
+	for i, v := range vectors {
+		if len(v) != len(query) {
+			continue
+		}
+		matches = append(matches, Match{Index: i, Similarity: Cosine(query, v)})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Similarity > matches[j].Similarity })
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return
+}
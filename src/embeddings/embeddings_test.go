@@ -0,0 +1,37 @@
+package embeddings
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var req request
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "small", req.Model)
+		// answered out of order, which the index puts right
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer server.Close()
+
+	vectors, err := New(server.URL+"/v1/", "small", "secret").Embed([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]float32{{1, 0}, {0, 1}}, vectors)
+}
+
+func TestNearest(t *testing.T) {
+	vectors := [][]float32{{0, 1}, {1, 0.1}, {1, 2, 3}, {-1, 0}, {1, 0}}
+	matches := Nearest([]float32{1, 0}, vectors, 2)
+	assert.Equal(t, 2, len(matches))
+	assert.Equal(t, 4, matches[0].Index)
+	assert.InDelta(t, 1, matches[0].Similarity, 1e-9)
+	assert.Equal(t, 1, matches[1].Index)
+	assert.Equal(t, 0.0, Cosine([]float32{0, 0}, []float32{1, 0}))
+}
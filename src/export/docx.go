@@ -0,0 +1,517 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	// image formats that can be sized for embedding
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	// emuPerPixel converts pixels at 96 dpi into English Metric Units
+	emuPerPixel = 9525
+	// maxImageWidth is the width between the page margins, in EMU
+	maxImageWidth = 5943600
+)
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+type docxRelationship struct {
+	id       string
+	kind     string
+	target   string
+	external bool
+}
+
+type docxImage struct {
+	relID         string
+	width, height int
+}
+
+// docxWriter converts HTML into WordprocessingML
+type docxWriter struct {
+	doc        Document
+	body       bytes.Buffer
+	rels       []docxRelationship
+	media      map[string][]byte
+	images     map[string]*docxImage
+	mediaTypes map[string]string
+	lists      []docxList
+	drawings   int
+}
+
+type docxList struct {
+	ordered bool
+	level   int
+}
+
+// docxContext is the style of the paragraphs in a block
+type docxContext struct {
+	style string
+	numID int
+	level int
+	depth int
+}
+
+// docxParagraph collects the runs of a paragraph until it is written
+type docxParagraph struct {
+	style string
+	numID int
+	level int
+	runs  bytes.Buffer
+}
+
+// docxRun is the character formatting of a run of text
+type docxRun struct {
+	bold, italic, strike, code, link bool
+	vertAlign                        string
+}
+
+// WriteDOCX writes the document as a Word document, with a page
+// break between each page
+func WriteDOCX(w io.Writer, doc Document) (err error) {
+	d := &docxWriter{
+		doc:        doc,
+		media:      make(map[string][]byte),
+		images:     make(map[string]*docxImage),
+		mediaTypes: make(map[string]string),
+	}
+	d.rel("http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles", "styles.xml", false)
+	d.rel("http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering", "numbering.xml", false)
+
+	if len(doc.Chapters) > 1 {
+		d.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr>`)
+		d.body.WriteString(d.runXML(doc.Title, docxRun{}))
+		d.body.WriteString(`</w:p>`)
+	}
+	for i, chapter := range doc.Chapters {
+		if i > 0 || len(doc.Chapters) > 1 {
+			d.body.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+		}
+		root, errParse := parseBody(chapter.HTML)
+		if errParse != nil {
+			return errors.Wrap(errParse, "WriteDOCX")
+		}
+		d.blocks(root, &docxContext{})
+	}
+
+	z := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data string
+	}{
+		{"[Content_Types].xml", d.contentTypes()},
+		{"_rels/.rels", docxPackageRels},
+		{"docProps/core.xml", d.coreProperties()},
+		{"word/document.xml", d.document()},
+		{"word/styles.xml", docxStyles},
+		{"word/numbering.xml", d.numbering()},
+		{"word/_rels/document.xml.rels", d.relationships()},
+	}
+	for _, f := range files {
+		if err = writeZipFile(z, f.name, []byte(f.data)); err != nil {
+			return
+		}
+	}
+	for name, data := range d.media {
+		if err = writeZipFile(z, "word/"+name, data); err != nil {
+			return
+		}
+	}
+	return errors.Wrap(z.Close(), "WriteDOCX")
+}
+
+func (d *docxWriter) rel(kind, target string, external bool) string {
+	id := fmt.Sprintf("rId%d", len(d.rels)+1)
+	d.rels = append(d.rels, docxRelationship{id: id, kind: kind, target: target, external: external})
+	return id
+}
+
+func isBlock(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch n.Data {
+	case "p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "blockquote",
+		"ul", "ol", "li", "hr", "table", "dl", "dt", "dd", "section", "figure":
+		return true
+	}
+	return false
+}
+
+// blocks writes the children of n as paragraphs, grouping inline
+// elements that are next to each other into one paragraph
+func (d *docxWriter) blocks(n *html.Node, ctx *docxContext) {
+	var p *docxParagraph
+	flush := func() {
+		if p != nil {
+			d.writeParagraph(p)
+			p = nil
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isBlock(c) {
+			flush()
+			d.block(c, ctx)
+			continue
+		}
+		if p == nil {
+			if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+				continue
+			}
+			p = d.paragraph(ctx)
+		}
+		d.inline(p, c, docxRun{})
+	}
+	flush()
+}
+
+// paragraph starts a paragraph in the context, only the first paragraph
+// of a list item gets a bullet
+func (d *docxWriter) paragraph(ctx *docxContext) *docxParagraph {
+	p := &docxParagraph{style: ctx.style, numID: ctx.numID, level: ctx.level}
+	ctx.numID = 0
+	return p
+}
+
+func (d *docxWriter) block(n *html.Node, ctx *docxContext) {
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		p := &docxParagraph{style: "Heading" + n.Data[1:]}
+		d.inlineChildren(p, n, docxRun{})
+		d.writeParagraph(p)
+	case "pre":
+		text := strings.TrimSuffix(textContent(n), "\n")
+		for _, line := range strings.Split(text, "\n") {
+			p := &docxParagraph{style: "Code"}
+			p.runs.WriteString(d.runXML(line, docxRun{}))
+			d.writeParagraph(p)
+		}
+	case "blockquote":
+		quote := *ctx
+		quote.style = "Quote"
+		d.blocks(n, &quote)
+	case "ul", "ol":
+		numID := d.list(n.Data == "ol", ctx.depth)
+		for li := n.FirstChild; li != nil; li = li.NextSibling {
+			if li.Type != html.ElementNode || li.Data != "li" {
+				continue
+			}
+			d.blocks(li, &docxContext{
+				style: "ListParagraph",
+				numID: numID,
+				level: ctx.depth,
+				depth: ctx.depth + 1,
+			})
+		}
+	case "hr":
+		d.body.WriteString(`<w:p><w:pPr><w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="auto"/></w:pBdr></w:pPr></w:p>`)
+	case "table":
+		d.table(n)
+	default:
+		d.blocks(n, ctx)
+	}
+}
+
+func (d *docxWriter) table(n *html.Node) {
+	d.body.WriteString(`<w:tbl><w:tblPr><w:tblW w:w="0" w:type="auto"/><w:tblBorders>`)
+	for _, side := range []string{"top", "left", "bottom", "right", "insideH", "insideV"} {
+		fmt.Fprintf(&d.body, `<w:%s w:val="single" w:sz="4" w:space="0" w:color="auto"/>`, side)
+	}
+	d.body.WriteString(`</w:tblBorders></w:tblPr>`)
+	for _, tr := range findElements(n, "tr") {
+		d.body.WriteString(`<w:tr>`)
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || (c.Data != "td" && c.Data != "th") {
+				continue
+			}
+			d.body.WriteString(`<w:tc>`)
+			length := d.body.Len()
+			if c.Data == "th" {
+				p := &docxParagraph{}
+				d.inlineChildren(p, c, docxRun{bold: true})
+				d.writeParagraph(p)
+			} else {
+				d.blocks(c, &docxContext{})
+			}
+			// every cell needs a paragraph
+			if d.body.Len() == length {
+				d.body.WriteString(`<w:p/>`)
+			}
+			d.body.WriteString(`</w:tc>`)
+		}
+		d.body.WriteString(`</w:tr>`)
+	}
+	d.body.WriteString(`</w:tbl>`)
+}
+
+func (d *docxWriter) inlineChildren(p *docxParagraph, n *html.Node, r docxRun) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		d.inline(p, c, r)
+	}
+}
+
+func (d *docxWriter) inline(p *docxParagraph, n *html.Node, r docxRun) {
+	if n.Type == html.TextNode {
+		text := whitespace.ReplaceAllString(n.Data, " ")
+		if p.runs.Len() == 0 {
+			text = strings.TrimLeft(text, " ")
+		}
+		if text != "" {
+			p.runs.WriteString(d.runXML(text, r))
+		}
+		return
+	} else if n.Type != html.ElementNode {
+		return
+	}
+
+	switch n.Data {
+	case "strong", "b":
+		r.bold = true
+	case "em", "i":
+		r.italic = true
+	case "del", "s", "strike":
+		r.strike = true
+	case "code", "kbd", "samp":
+		r.code = true
+	case "sup":
+		r.vertAlign = "superscript"
+	case "sub":
+		r.vertAlign = "subscript"
+	case "br":
+		p.runs.WriteString(`<w:r><w:br/></w:r>`)
+		return
+	case "img":
+		p.runs.WriteString(d.imageXML(attr(n, "src"), attr(n, "alt"), r))
+		return
+	case "a":
+		href := attr(n, "href")
+		if href == "" || strings.HasPrefix(href, "#") {
+			break
+		}
+		id := d.rel("http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink", d.doc.resolve(href), true)
+		r.link = true
+		fmt.Fprintf(&p.runs, `<w:hyperlink r:id="%s">`, id)
+		d.inlineChildren(p, n, r)
+		p.runs.WriteString(`</w:hyperlink>`)
+		return
+	}
+	d.inlineChildren(p, n, r)
+}
+
+func (d *docxWriter) writeParagraph(p *docxParagraph) {
+	d.body.WriteString(`<w:p>`)
+	if p.style != "" || p.numID != 0 {
+		d.body.WriteString(`<w:pPr>`)
+		if p.style != "" {
+			fmt.Fprintf(&d.body, `<w:pStyle w:val="%s"/>`, p.style)
+		}
+		if p.numID != 0 {
+			fmt.Fprintf(&d.body, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, p.level, p.numID)
+		}
+		d.body.WriteString(`</w:pPr>`)
+	}
+	d.body.Write(p.runs.Bytes())
+	d.body.WriteString(`</w:p>`)
+}
+
+func (d *docxWriter) runXML(text string, r docxRun) string {
+	var b strings.Builder
+	b.WriteString(`<w:r>`)
+	if r != (docxRun{}) {
+		// the order of the properties is fixed by the schema
+		b.WriteString(`<w:rPr>`)
+		if r.link {
+			b.WriteString(`<w:rStyle w:val="Hyperlink"/>`)
+		}
+		if r.code {
+			b.WriteString(`<w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/>`)
+		}
+		if r.bold {
+			b.WriteString(`<w:b/>`)
+		}
+		if r.italic {
+			b.WriteString(`<w:i/>`)
+		}
+		if r.strike {
+			b.WriteString(`<w:strike/>`)
+		}
+		if r.vertAlign != "" {
+			fmt.Fprintf(&b, `<w:vertAlign w:val="%s"/>`, r.vertAlign)
+		}
+		b.WriteString(`</w:rPr>`)
+	}
+	fmt.Fprintf(&b, `<w:t xml:space="preserve">%s</w:t></w:r>`, escape(text))
+	return b.String()
+}
+
+// imageXML embeds an image, or falls back to its description when the
+// image can not be loaded or sized
+func (d *docxWriter) imageXML(src, alt string, r docxRun) string {
+	img, ok := d.images[src]
+	if !ok {
+		if data, loaded := d.doc.loadImage(src); loaded {
+			if config, _, err := image.DecodeConfig(bytes.NewReader(data.Data)); err == nil {
+				ext := imageExtension(data)
+				name := fmt.Sprintf("media/image%d%s", len(d.media)+1, ext)
+				d.media[name] = data.Data
+				d.mediaTypes[strings.TrimPrefix(ext, ".")] = data.ContentType
+				img = &docxImage{
+					relID:  d.rel("http://schemas.openxmlformats.org/officeDocument/2006/relationships/image", name, false),
+					width:  config.Width * emuPerPixel,
+					height: config.Height * emuPerPixel,
+				}
+				if img.width > maxImageWidth {
+					img.height = img.height * maxImageWidth / img.width
+					img.width = maxImageWidth
+				}
+			}
+		}
+		d.images[src] = img
+	}
+	if img == nil {
+		if alt == "" {
+			return ""
+		}
+		return d.runXML(alt, r)
+	}
+
+	d.drawings++
+	return fmt.Sprintf(`<w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0">`+
+		`<wp:extent cx="%[1]d" cy="%[2]d"/><wp:docPr id="%[3]d" name="Picture %[3]d" descr="%[4]s"/>`+
+		`<a:graphic xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main">`+
+		`<a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:pic xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:nvPicPr><pic:cNvPr id="%[3]d" name="Picture %[3]d"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%[5]s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%[1]d" cy="%[2]d"/></a:xfrm>`+
+		`<a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r>`,
+		img.width, img.height, d.drawings, escape(alt), img.relID)
+}
+
+// list numbers a new list, so that ordered lists each start at one
+func (d *docxWriter) list(ordered bool, level int) int {
+	d.lists = append(d.lists, docxList{ordered: ordered, level: level})
+	return len(d.lists)
+}
+
+func (d *docxWriter) numbering() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">`)
+	for abstractID, format := range []string{"bullet", "decimal"} {
+		fmt.Fprintf(&b, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, abstractID)
+		for level := 0; level < 9; level++ {
+			text := "•"
+			if format == "decimal" {
+				text = fmt.Sprintf("%%%d.", level+1)
+			}
+			fmt.Fprintf(&b, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`,
+				level, format, text, 720*(level+1))
+		}
+		b.WriteString(`</w:abstractNum>`)
+	}
+	for i, list := range d.lists {
+		abstractID := 0
+		if list.ordered {
+			abstractID = 1
+		}
+		fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="%d"/><w:lvlOverride w:ilvl="%d"><w:startOverride w:val="1"/></w:lvlOverride></w:num>`,
+			i+1, abstractID, list.level)
+	}
+	b.WriteString(`</w:numbering>`)
+	return b.String()
+}
+
+func (d *docxWriter) document() string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" ` +
+		`xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing">` +
+		`<w:body>` + d.body.String() +
+		`<w:sectPr><w:pgSz w:w="12240" w:h="15840"/>` +
+		`<w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="720" w:footer="720" w:gutter="0"/>` +
+		`</w:sectPr></w:body></w:document>`
+}
+
+func (d *docxWriter) relationships() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for _, rel := range d.rels {
+		mode := ""
+		if rel.external {
+			mode = ` TargetMode="External"`
+		}
+		fmt.Fprintf(&b, `<Relationship Id="%s" Type="%s" Target="%s"%s/>`, rel.id, rel.kind, escape(rel.target), mode)
+	}
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+func (d *docxWriter) contentTypes() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>`)
+	for ext, contentType := range d.mediaTypes {
+		fmt.Fprintf(&b, `<Default Extension="%s" ContentType="%s"/>`, escape(ext), escape(contentType))
+	}
+	b.WriteString(`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+		`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+		`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>` +
+		`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+		`</Types>`)
+	return b.String()
+}
+
+func (d *docxWriter) coreProperties() string {
+	modified := d.doc.Modified
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" `+
+		`xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" `+
+		`xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`+
+		`<dc:title>%s</dc:title><dc:identifier>%s</dc:identifier><dc:language>%s</dc:language>`+
+		`<dcterms:modified xsi:type="dcterms:W3CDTF">%s</dcterms:modified></cp:coreProperties>`,
+		escape(d.doc.Title), escape(d.doc.ID), escape(d.doc.language()), modified.UTC().Format("2006-01-02T15:04:05Z"))
+}
+
+const docxPackageRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>` +
+	`</Relationships>`
+
+const docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Georgia" w:hAnsi="Georgia" w:cs="Georgia"/><w:sz w:val="22"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="160" w:line="276" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:rPr><w:b/><w:sz w:val="56"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="360"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="40"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="32"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/><w:sz w:val="28"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading4"><w:name w:val="heading 4"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="3"/></w:pPr><w:rPr><w:b/><w:sz w:val="24"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading5"><w:name w:val="heading 5"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="4"/></w:pPr><w:rPr><w:b/><w:i/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading6"><w:name w:val="heading 6"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="5"/></w:pPr><w:rPr><w:i/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:pPr><w:ind w:left="720"/></w:pPr><w:rPr><w:i/><w:color w:val="555555"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr><w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/><w:sz w:val="20"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="60"/></w:pPr></w:style>` +
+	`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>` +
+	`</w:styles>`
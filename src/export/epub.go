@@ -0,0 +1,192 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStyle = `body { font-family: serif; line-height: 1.4; }
+pre, code { font-family: monospace; }
+pre { white-space: pre-wrap; }
+blockquote { margin-left: 1em; padding-left: 1em; border-left: 2px solid #ccc; }
+img { max-width: 100%; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; }
+`
+
+type epubItem struct {
+	id        string
+	href      string
+	mediaType string
+}
+
+// WriteEPUB writes the document as an EPUB 3 book with one chapter
+// per page
+func WriteEPUB(w io.Writer, doc Document) (err error) {
+	z := zip.NewWriter(w)
+
+	// the mimetype has to come first and can not be compressed
+	f, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return errors.Wrap(err, "WriteEPUB")
+	}
+	if _, err = io.WriteString(f, "application/epub+zip"); err != nil {
+		return errors.Wrap(err, "WriteEPUB")
+	}
+	if err = writeZipFile(z, "META-INF/container.xml", []byte(epubContainer)); err != nil {
+		return
+	}
+	if err = writeZipFile(z, "OEBPS/style.css", []byte(epubStyle)); err != nil {
+		return
+	}
+
+	var chapters, images []epubItem
+	imageHrefs := make(map[string]string)
+	for i, chapter := range doc.Chapters {
+		root, errParse := parseBody(chapter.HTML)
+		if errParse != nil {
+			return errors.Wrap(errParse, "WriteEPUB")
+		}
+
+		// images are stored in the book, the ones that can't be are
+		// replaced by their description
+		for _, img := range findElements(root, "img") {
+			src := attr(img, "src")
+			href, ok := imageHrefs[src]
+			if !ok {
+				if data, loaded := doc.loadImage(src); loaded {
+					item := epubItem{
+						id:        fmt.Sprintf("image%d", len(images)+1),
+						mediaType: data.ContentType,
+					}
+					item.href = "images/" + item.id + imageExtension(data)
+					if err = writeZipFile(z, "OEBPS/"+item.href, data.Data); err != nil {
+						return
+					}
+					images = append(images, item)
+					href = item.href
+				}
+				imageHrefs[src] = href
+			}
+			if href == "" {
+				img.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: attr(img, "alt")}, img)
+				img.Parent.RemoveChild(img)
+				continue
+			}
+			setAttr(img, "src", href)
+		}
+
+		var body bytes.Buffer
+		for n := root.FirstChild; n != nil; n = n.NextSibling {
+			if err = html.Render(&body, n); err != nil {
+				return errors.Wrap(err, "WriteEPUB")
+			}
+		}
+
+		item := epubItem{
+			id:        fmt.Sprintf("chapter%d", i+1),
+			mediaType: "application/xhtml+xml",
+		}
+		item.href = item.id + ".xhtml"
+		chapters = append(chapters, item)
+		if err = writeZipFile(z, "OEBPS/"+item.href, []byte(xhtmlPage(doc.language(), chapter.Title, body.String()))); err != nil {
+			return
+		}
+	}
+
+	var nav bytes.Buffer
+	fmt.Fprintf(&nav, "<nav epub:type=\"toc\" id=\"toc\">\n<h1>%s</h1>\n<ol>\n", escape(doc.Title))
+	for i, chapter := range doc.Chapters {
+		fmt.Fprintf(&nav, "<li><a href=\"%s\">%s</a></li>\n", chapters[i].href, escape(chapter.Title))
+	}
+	nav.WriteString("</ol>\n</nav>")
+	if err = writeZipFile(z, "OEBPS/nav.xhtml", []byte(xhtmlPage(doc.language(), doc.Title, nav.String()))); err != nil {
+		return
+	}
+
+	if err = writeZipFile(z, "OEBPS/content.opf", []byte(epubPackage(doc, chapters, images))); err != nil {
+		return
+	}
+	return errors.Wrap(z.Close(), "WriteEPUB")
+}
+
+func epubPackage(doc Document, chapters, images []epubItem) string {
+	modified := doc.Modified
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" xml:lang="%s">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>%s</dc:language>
+    <meta property="dcterms:modified">%s</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+`, escape(doc.language()), escape(doc.ID), escape(doc.Title), escape(doc.language()), modified.UTC().Format("2006-01-02T15:04:05Z"))
+	for _, items := range [][]epubItem{chapters, images} {
+		for _, item := range items {
+			fmt.Fprintf(&b, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"/>\n", item.id, item.href, escape(item.mediaType))
+		}
+	}
+	b.WriteString("  </manifest>\n  <spine>\n")
+	for _, item := range chapters {
+		fmt.Fprintf(&b, "    <itemref idref=\"%s\"/>\n", item.id)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+func xhtmlPage(language, title, body string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="%s" lang="%s">
+<head>
+<title>%s</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+%s
+</body>
+</html>
+`, escape(language), escape(language), escape(title), body)
+}
+
+// findElements returns every element with the tag beneath n
+func findElements(n *html.Node, tag string) (found []*html.Node) {
+	if n.Type == html.ElementNode && n.Data == tag {
+		found = append(found, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		found = append(found, findElements(c, tag)...)
+	}
+	return
+}
+
+func writeZipFile(z *zip.Writer, name string, data []byte) (err error) {
+	f, err := z.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "creating "+name)
+	}
+	_, err = f.Write(data)
+	return errors.Wrap(err, "writing "+name)
+}
@@ -0,0 +1,147 @@
+// Package export converts rendered pages into documents that can be
+// opened by word processors and e-readers.
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Image is an image that is embedded into a document
+type Image struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Chapter is a single page of a document, as rendered HTML
+type Chapter struct {
+	Title string
+	HTML  string
+}
+
+// Document is a set of pages that are exported together
+type Document struct {
+	// ID uniquely identifies the document, like the URL it was exported from
+	ID       string
+	Title    string
+	Language string
+	Modified time.Time
+	// BaseURL is used to resolve relative links
+	BaseURL  string
+	Chapters []Chapter
+	// LoadImage returns the image at src, the image is left out if it
+	// can not be loaded
+	LoadImage func(src string) (Image, error)
+	// Style is the CSS of HTML documents, which have a plain style
+	// without it
+	Style string
+}
+
+func (doc Document) language() string {
+	if doc.Language == "" {
+		return "en"
+	}
+	return doc.Language
+}
+
+func (doc Document) loadImage(src string) (img Image, ok bool) {
+	if doc.LoadImage == nil {
+		return
+	}
+	img, err := doc.LoadImage(src)
+	return img, err == nil && len(img.Data) > 0
+}
+
+// resolve makes a link absolute so that it works outside of the site
+func (doc Document) resolve(link string) string {
+	base, err := url.Parse(doc.BaseURL)
+	if err != nil || doc.BaseURL == "" {
+		return link
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// parseBody parses rendered HTML into the children of a body element
+func parseBody(s string) (body *html.Node, err error) {
+	body = &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		return
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	return
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// textContent returns all of the text inside a node
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// imageExtension returns the file extension to store an image with
+func imageExtension(img Image) string {
+	ext := strings.ToLower(path.Ext(img.Name))
+	if ext != "" {
+		return ext
+	}
+	switch img.ContentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	case "image/svg+xml":
+		return ".svg"
+	case "image/webp":
+		return ".webp"
+	}
+	return ".png"
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const testHTML = `<h1 id="notes">Notes &amp; things</h1>
+<p>Some <strong>bold</strong>, <em>italic</em> and <code>code</code> with a <a href="/public/other">link</a>.<br>
+<img src="/uploads/abc" alt="a dot"> <img src="https://example.com/x.png" alt="remote"></p>
+<ul><li>one<ul><li>nested</li></ul></li><li>two</li></ul>
+<ol><li>first</li></ol>
+<pre><code>x := 1
+y := 2
+</code></pre>
+<blockquote><p>quoted</p></blockquote>
+<hr>
+<table><thead><tr><th>a</th><th>b</th></tr></thead><tbody><tr><td>1</td><td></td></tr></tbody></table>`
+
+func testDocument(t *testing.T) Document {
+	var dot bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.Black)
+	assert.Nil(t, png.Encode(&dot, img))
+	return Document{
+		ID:       "http://localhost/public/abc",
+		Title:    "Notes",
+		BaseURL:  "http://localhost",
+		Chapters: []Chapter{{Title: "Notes & things", HTML: testHTML}, {Title: "Two", HTML: "<p>two</p>"}},
+		LoadImage: func(src string) (Image, error) {
+			if src == "/uploads/abc" {
+				return Image{Name: "dot.png", ContentType: "image/png", Data: dot.Bytes()}, nil
+			}
+			return Image{}, errors.New("not found")
+		},
+	}
+}
+
+// readZip returns the files of the archive, checking that the XML in
+// it is well formed
+func readZip(t *testing.T, b []byte) map[string]string {
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert.Nil(t, err)
+	files := make(map[string]string)
+	for _, f := range z.File {
+		rc, err := f.Open()
+		assert.Nil(t, err)
+		data, err := ioutil.ReadAll(rc)
+		assert.Nil(t, err)
+		rc.Close()
+		files[f.Name] = string(data)
+		if strings.HasSuffix(f.Name, ".png") || f.Name == "mimetype" || strings.HasSuffix(f.Name, ".css") {
+			continue
+		}
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		decoder.Strict = true
+		for {
+			_, err = decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if !assert.Nil(t, err, f.Name) {
+				break
+			}
+		}
+	}
+	return files
+}
+
+func TestWriteDOCX(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, WriteDOCX(&b, testDocument(t)))
+	files := readZip(t, b.Bytes())
+	document := files["word/document.xml"]
+	assert.Contains(t, document, `<w:pStyle w:val="Heading1"/>`)
+	assert.Contains(t, document, "Notes &amp; things")
+	assert.Contains(t, document, `<w:ilvl w:val="1"/>`)
+	assert.Contains(t, document, `<w:pStyle w:val="Code"/></w:pPr><w:r><w:t xml:space="preserve">y := 2</w:t>`)
+	assert.Contains(t, document, `<wp:extent cx="19050" cy="9525"/>`)
+	assert.Contains(t, document, ">remote<")
+	assert.Contains(t, files["word/_rels/document.xml.rels"], `Target="http://localhost/public/other" TargetMode="External"`)
+	assert.Contains(t, files["[Content_Types].xml"], `<Default Extension="png" ContentType="image/png"/>`)
+	assert.NotEmpty(t, files["word/media/image1.png"])
+}
+
+func TestWriteEPUB(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, WriteEPUB(&b, testDocument(t)))
+	assert.Equal(t, "mimetype", string(b.Bytes()[30:38]))
+	files := readZip(t, b.Bytes())
+	assert.Equal(t, "application/epub+zip", files["mimetype"])
+	assert.Contains(t, files["OEBPS/chapter1.xhtml"], `<img src="images/image1.png" alt="a dot"/>`)
+	assert.Contains(t, files["OEBPS/chapter1.xhtml"], `remote</p>`)
+	assert.Contains(t, files["OEBPS/content.opf"], `<itemref idref="chapter2"/>`)
+	assert.Contains(t, files["OEBPS/content.opf"], `href="images/image1.png" media-type="image/png"`)
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<a href="chapter1.xhtml">Notes &amp; things</a>`)
+}
+
+func TestWriteOPML(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, WriteOPML(&b, "plans", time.Date(2018, 9, 11, 14, 44, 11, 0, time.UTC), []Outline{
+		{Text: "Garden", Note: "Water\ndaily", Children: []Outline{{Text: "Tomatoes & beans"}}},
+	}))
+	assert.Contains(t, b.String(), `<dateModified>Tue, 11 Sep 2018 14:44:11 +0000</dateModified>`)
+	assert.Contains(t, b.String(), `<outline text="Garden" _note="Water&#xA;daily">`)
+	assert.Contains(t, b.String(), `<outline text="Tomatoes &amp; beans"></outline>`)
+}
+
+func TestWriteHTML(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, WriteHTML(&b, testDocument(t)))
+	assert.Contains(t, b.String(), `<li><a href="#chapter2">Two</a></li>`)
+	assert.Contains(t, b.String(), `<section class="chapter" id="chapter1">`)
+	assert.Contains(t, b.String(), `<img src="data:image/png;base64,`)
+	assert.Contains(t, b.String(), `<a href="http://localhost/public/other">`)
+	assert.Contains(t, b.String(), `.chapter { page-break-before: always; }`)
+}
+
+func TestWritePDF(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, WritePDF(&b, testDocument(t)))
+	pdf := b.String()
+	assert.True(t, strings.HasPrefix(pdf, "%PDF-1.4\n"))
+	assert.True(t, strings.HasSuffix(pdf, "%%EOF\n"))
+
+	// every object is where the cross reference table says
+	xref := strings.Index(pdf, "xref\n")
+	entries := strings.Split(pdf[xref:strings.Index(pdf, "trailer")], "\n")[3:]
+	for i, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		offset, err := strconv.Atoi(entry[:10])
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(pdf[offset:], strconv.Itoa(i+1)+" 0 obj\n"), entry)
+	}
+
+	var text strings.Builder
+	for _, stream := range strings.Split(pdf, ">>\nstream\n")[1:] {
+		stream = stream[:strings.Index(stream, "\nendstream")]
+		if z, err := zlib.NewReader(strings.NewReader(stream)); err == nil {
+			data, _ := ioutil.ReadAll(z)
+			text.Write(data)
+		}
+	}
+	assert.Contains(t, text.String(), "(Notes & things) Tj")
+	assert.Contains(t, text.String(), "/Im1 Do")
+	assert.Contains(t, text.String(), "/F5 9.0 Tf")
+	assert.Contains(t, pdf, "/Count 3 >>")
+	assert.Contains(t, pdf, "/URI (http://localhost/public/other)")
+	assert.Contains(t, pdf, "/Subtype /Image /Width 2 /Height 1")
+	assert.Contains(t, pdf, pdfTextString("Two"))
+}
+
+func TestWinAnsi(t *testing.T) {
+	assert.Equal(t, "caf\xe9 \x93quoted\x94 ?", winAnsi("café “quoted” 日"))
+}
@@ -0,0 +1,84 @@
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// bookStyle starts each page of a book on a new sheet when it is printed
+const bookStyle = `.chapter { page-break-before: always; }
+.contents li { margin: 0.3em 0; }
+`
+
+// WriteHTML writes the document as a single HTML file, with a table of
+// contents that links to each page and the images inlined, so that it
+// can be read or printed as a book without the site
+func WriteHTML(w io.Writer, doc Document) (err error) {
+	var b bytes.Buffer
+	style := doc.Style
+	if style == "" {
+		style = epubStyle
+	}
+	fmt.Fprintf(&b, `<!DOCTYPE html>
+<html lang="%s">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%s</title>
+<style>
+%s
+%s</style>
+</head>
+<body>
+<div class="main">
+<h1>%s</h1>
+<nav class="contents" aria-label="Contents">
+<ol>
+`, escape(doc.language()), escape(doc.Title), style, bookStyle, escape(doc.Title))
+	for i, chapter := range doc.Chapters {
+		fmt.Fprintf(&b, "<li><a href=\"#chapter%d\">%s</a></li>\n", i+1, escape(chapter.Title))
+	}
+	b.WriteString("</ol>\n</nav>\n")
+
+	images := make(map[string]string)
+	for i, chapter := range doc.Chapters {
+		root, errParse := parseBody(chapter.HTML)
+		if errParse != nil {
+			return errors.Wrap(errParse, "WriteHTML")
+		}
+		for _, img := range findElements(root, "img") {
+			src := attr(img, "src")
+			uri, ok := images[src]
+			if !ok {
+				if data, loaded := doc.loadImage(src); loaded {
+					uri = "data:" + data.ContentType + ";base64," + base64.StdEncoding.EncodeToString(data.Data)
+				}
+				images[src] = uri
+			}
+			if uri != "" {
+				setAttr(img, "src", uri)
+			}
+		}
+		for _, a := range findElements(root, "a") {
+			if href := attr(a, "href"); href != "" && href[0] != '#' {
+				setAttr(a, "href", doc.resolve(href))
+			}
+		}
+
+		fmt.Fprintf(&b, "<section class=\"chapter\" id=\"chapter%d\">\n", i+1)
+		for n := root.FirstChild; n != nil; n = n.NextSibling {
+			if err = html.Render(&b, n); err != nil {
+				return errors.Wrap(err, "WriteHTML")
+			}
+		}
+		b.WriteString("\n</section>\n")
+	}
+	b.WriteString("</div>\n</body>\n</html>\n")
+	_, err = w.Write(b.Bytes())
+	return errors.Wrap(err, "WriteHTML")
+}
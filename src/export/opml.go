@@ -0,0 +1,58 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Outline is an entry of an outline, with the entries nested in it
+type Outline struct {
+	Text     string
+	Note     string
+	Created  time.Time
+	Children []Outline
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Note     string        `xml:"_note,attr,omitempty"`
+	Created  string        `xml:"created,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlDocument struct {
+	XMLName      xml.Name      `xml:"opml"`
+	Version      string        `xml:"version,attr"`
+	Title        string        `xml:"head>title"`
+	DateModified string        `xml:"head>dateModified,omitempty"`
+	Outlines     []opmlOutline `xml:"body>outline"`
+}
+
+func opmlOutlines(outlines []Outline) (converted []opmlOutline) {
+	for _, o := range outlines {
+		outline := opmlOutline{Text: o.Text, Note: o.Note, Outlines: opmlOutlines(o.Children)}
+		if !o.Created.IsZero() {
+			outline.Created = o.Created.UTC().Format(time.RFC1123Z)
+		}
+		converted = append(converted, outline)
+	}
+	return
+}
+
+// WriteOPML writes the outlines as an OPML 2.0 document, which
+// outliners can open
+func WriteOPML(w io.Writer, title string, modified time.Time, outlines []Outline) (err error) {
+	doc := opmlDocument{Version: "2.0", Title: title, Outlines: opmlOutlines(outlines)}
+	if !modified.IsZero() {
+		doc.DateModified = modified.UTC().Format(time.RFC1123Z)
+	}
+	if _, err = io.WriteString(w, xml.Header); err != nil {
+		return errors.Wrap(err, "WriteOPML")
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return errors.Wrap(encoder.Encode(doc), "WriteOPML")
+}
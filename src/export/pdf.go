@@ -0,0 +1,842 @@
+package export
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// the A4 sheet and its margins, in points
+const (
+	pdfWidth  = 595.0
+	pdfHeight = 842.0
+	pdfMargin = 60.0
+	// pdfPointsPerPixel sizes images at 96 dpi
+	pdfPointsPerPixel = 0.75
+)
+
+type pdfFont int
+
+// the fonts that every PDF reader has, so they are not embedded
+const (
+	fontRegular pdfFont = iota
+	fontBold
+	fontItalic
+	fontBoldItalic
+	fontMono
+)
+
+var pdfFontNames = []string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Helvetica-BoldOblique", "Courier"}
+
+// the widths of the printable ASCII letters of Helvetica, in thousandths
+// of the size of the font. The oblique fonts are as wide.
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// winAnsiExtra are the letters of the Windows code page that are not
+// where Unicode has them
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// winAnsi converts text into the encoding of the standard fonts, which
+// only has Western European letters, so others become question marks
+func winAnsi(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if c, ok := winAnsiExtra[r]; ok {
+			b = append(b, c)
+		} else if r == '\t' || r == '\n' || r == '\r' {
+			b = append(b, ' ')
+		} else if r >= 0x20 && r < 0x7F || r >= 0xA0 && r <= 0xFF {
+			b = append(b, byte(r))
+		} else if r >= 0x7F {
+			b = append(b, '?')
+		}
+	}
+	return string(b)
+}
+
+// charWidth is the width of a letter, in thousandths of the size
+func charWidth(font pdfFont, c byte) int {
+	if font == fontMono {
+		return 600
+	}
+	bold := font == fontBold || font == fontBoldItalic
+	if c >= 32 && c <= 126 {
+		if bold {
+			return helveticaBoldWidths[c-32]
+		}
+		return helveticaWidths[c-32]
+	}
+	switch c {
+	case 0x85, 0x97, 0x89:
+		return 1000
+	case 0x95:
+		return 350
+	case 0x91, 0x92, 0x82:
+		if bold {
+			return 278
+		}
+		return 222
+	case 0x93, 0x94, 0x84:
+		if bold {
+			return 500
+		}
+		return 333
+	case 0xA0:
+		return 278
+	}
+	return 556
+}
+
+func textWidth(font pdfFont, size float64, text string) float64 {
+	width := 0
+	for i := 0; i < len(text); i++ {
+		width += charWidth(font, text[i])
+	}
+	return float64(width) * size / 1000
+}
+
+// pdfStyle is the style of the text in a block
+type pdfStyle struct {
+	size         float64
+	indent       float64
+	bold, italic bool
+	mono         bool
+	link         string
+	// spacing is the space after each paragraph
+	spacing float64
+}
+
+func (s pdfStyle) font() pdfFont {
+	switch {
+	case s.mono:
+		return fontMono
+	case s.bold && s.italic:
+		return fontBoldItalic
+	case s.bold:
+		return fontBold
+	case s.italic:
+		return fontItalic
+	}
+	return fontRegular
+}
+
+// pdfItem is a word of a paragraph, a break in it or an image in it
+type pdfItem struct {
+	text  string
+	font  pdfFont
+	size  float64
+	link  string
+	space bool
+	br    bool
+	image *html.Node
+}
+
+// pdfPlaced is a word placed on a line
+type pdfPlaced struct {
+	pdfItem
+	x, width float64
+}
+
+// pdfLink is an area of a page that opens a link, or a page of the
+// document when it has no URI
+type pdfLink struct {
+	x0, y0, x1, y1 float64
+	uri            string
+	page           int
+}
+
+type pdfPage struct {
+	content bytes.Buffer
+	links   []pdfLink
+}
+
+type pdfImage struct {
+	index         int
+	width, height int
+	colorSpace    string
+	filter        string
+	data          []byte
+}
+
+// pdfWriter lays out HTML onto the pages of a PDF
+type pdfWriter struct {
+	doc       Document
+	pages     []*pdfPage
+	page      *pdfPage
+	y         float64
+	images    []*pdfImage
+	imageSrcs map[string]*pdfImage
+	// pendingSpace is whether a space comes before the next word
+	pendingSpace bool
+	// marker is the bullet or number of the list item whose first line
+	// is drawn next
+	marker string
+}
+
+// WritePDF writes the document as a PDF book, with a table of contents
+// and each page starting on a new sheet. The text is set in the fonts
+// that every PDF reader has, which only have Western European letters.
+func WritePDF(w io.Writer, doc Document) (err error) {
+	p := &pdfWriter{doc: doc, imageSrcs: make(map[string]*pdfImage)}
+	var starts []int
+	for _, chapter := range doc.Chapters {
+		root, errParse := parseBody(chapter.HTML)
+		if errParse != nil {
+			return errors.Wrap(errParse, "WritePDF")
+		}
+		p.newPage()
+		starts = append(starts, len(p.pages)-1)
+		p.blocks(root, pdfStyle{size: 11, spacing: 6})
+	}
+	chapters := p.pages
+
+	// the contents are laid out once to count their pages, and again
+	// with the numbers of the pages after them
+	p.pages = nil
+	p.contents(starts, 0)
+	offset := len(p.pages)
+	p.pages = nil
+	p.contents(starts, offset)
+	pages := append(p.pages, chapters...)
+	for i := range starts {
+		starts[i] += offset
+	}
+	return errors.Wrap(p.write(w, pages, starts), "WritePDF")
+}
+
+func (p *pdfWriter) newPage() {
+	p.page = &pdfPage{}
+	p.pages = append(p.pages, p.page)
+	p.y = pdfHeight - pdfMargin
+}
+
+// ensure starts a new page if the height does not fit on this one
+func (p *pdfWriter) ensure(height float64) {
+	if p.y-height < pdfMargin && p.y < pdfHeight-pdfMargin {
+		p.newPage()
+	}
+}
+
+// gap leaves space between blocks, but not at the top of a page
+func (p *pdfWriter) gap(height float64) {
+	if p.y < pdfHeight-pdfMargin {
+		p.y -= height
+	}
+}
+
+// contents writes the title of the document and a table of contents
+// that links to the first page of each chapter
+func (p *pdfWriter) contents(starts []int, offset int) {
+	p.newPage()
+	title := winAnsi(p.doc.Title)
+	p.paragraph([]pdfItem{{text: title, font: fontBold, size: 22}}, pdfStyle{size: 22})
+	p.gap(18)
+	p.paragraph([]pdfItem{{text: "Contents", font: fontBold, size: 14}}, pdfStyle{size: 14})
+	p.gap(6)
+	const size = 11.0
+	for i, chapter := range p.doc.Chapters {
+		p.ensure(size * 1.6)
+		p.y -= size * 1.6
+		number := strconv.Itoa(starts[i] + offset + 1)
+		numberWidth := textWidth(fontRegular, size, number)
+		text := winAnsi(chapter.Title)
+		room := pdfWidth - 2*pdfMargin - numberWidth - 24
+		if textWidth(fontRegular, size, text) > room {
+			text = text[:fitPrefix(fontRegular, size, text, room-textWidth(fontRegular, size, "..."))] + "..."
+		}
+		p.text(fontRegular, size, pdfMargin, p.y, text, "")
+		p.text(fontRegular, size, pdfWidth-pdfMargin-numberWidth, p.y, number, "")
+		p.page.links = append(p.page.links, pdfLink{
+			x0: pdfMargin, y0: p.y - 3, x1: pdfWidth - pdfMargin, y1: p.y + size,
+			page: starts[i] + offset,
+		})
+	}
+}
+
+// blocks lays out the children of n, grouping inline elements that are
+// next to each other into one paragraph
+func (p *pdfWriter) blocks(n *html.Node, s pdfStyle) {
+	var items []pdfItem
+	flush := func() {
+		if len(items) > 0 {
+			p.paragraph(items, s)
+			p.gap(s.spacing)
+		}
+		items = nil
+		p.pendingSpace = false
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isBlock(c) {
+			flush()
+			p.block(c, s)
+			continue
+		}
+		items = p.inline(items, c, s)
+	}
+	flush()
+}
+
+var headingSizes = map[string]float64{"h1": 20, "h2": 16, "h3": 14, "h4": 12, "h5": 11, "h6": 11}
+
+func (p *pdfWriter) block(n *html.Node, s pdfStyle) {
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		heading := pdfStyle{size: headingSizes[n.Data], indent: s.indent, bold: true}
+		p.gap(heading.size * 0.6)
+		var items []pdfItem
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			items = p.inline(items, c, heading)
+		}
+		p.pendingSpace = false
+		// a heading is kept with the line after it
+		p.ensure(heading.size*1.4 + s.size*2.8)
+		p.paragraph(items, heading)
+		p.gap(heading.size * 0.4)
+	case "pre":
+		p.pre(textContent(n), s)
+		p.gap(s.spacing)
+	case "blockquote":
+		quote := s
+		quote.indent += 18
+		quote.italic = true
+		p.blocks(n, quote)
+	case "ul", "ol":
+		number := 0
+		for li := n.FirstChild; li != nil; li = li.NextSibling {
+			if li.Type != html.ElementNode || li.Data != "li" {
+				continue
+			}
+			number++
+			p.marker = "\x95"
+			if n.Data == "ol" {
+				p.marker = strconv.Itoa(number) + "."
+			}
+			item := s
+			item.indent += 18
+			item.spacing = 2
+			p.blocks(li, item)
+			p.marker = ""
+		}
+		p.gap(s.spacing)
+	case "hr":
+		p.ensure(12)
+		p.y -= 6
+		fmt.Fprintf(&p.page.content, "0.5 w 0.6 G %.2f %.2f m %.2f %.2f l S 0 G\n", pdfMargin+s.indent, p.y, pdfWidth-pdfMargin, p.y)
+		p.y -= 6
+	case "table":
+		p.table(n, s)
+		p.gap(s.spacing)
+	default:
+		p.blocks(n, s)
+	}
+}
+
+// inline adds the words of n to the items of a paragraph
+func (p *pdfWriter) inline(items []pdfItem, n *html.Node, s pdfStyle) []pdfItem {
+	if n.Type == html.TextNode {
+		text := winAnsi(n.Data)
+		words := strings.Fields(text)
+		if len(words) == 0 {
+			p.pendingSpace = p.pendingSpace || text != ""
+			return items
+		}
+		if text[0] == ' ' {
+			p.pendingSpace = true
+		}
+		for _, word := range words {
+			items = append(items, pdfItem{text: word, font: s.font(), size: s.size, link: s.link, space: p.pendingSpace})
+			p.pendingSpace = true
+		}
+		p.pendingSpace = text[len(text)-1] == ' '
+		return items
+	} else if n.Type != html.ElementNode {
+		return items
+	}
+
+	switch n.Data {
+	case "strong", "b":
+		s.bold = true
+	case "em", "i":
+		s.italic = true
+	case "code", "kbd", "samp":
+		s.mono = true
+	case "br":
+		return append(items, pdfItem{br: true})
+	case "img":
+		return append(items, pdfItem{image: n, space: p.pendingSpace})
+	case "a":
+		if href := attr(n, "href"); href != "" && !strings.HasPrefix(href, "#") {
+			s.link = p.doc.resolve(href)
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		items = p.inline(items, c, s)
+	}
+	return items
+}
+
+// fitPrefix returns how much of the text fits in the width, which is at
+// least one letter
+func fitPrefix(font pdfFont, size float64, text string, width float64) int {
+	used := 0.0
+	for i := 0; i < len(text); i++ {
+		used += float64(charWidth(font, text[i])) * size / 1000
+		if used > width {
+			if i == 0 {
+				return 1
+			}
+			return i
+		}
+	}
+	return len(text)
+}
+
+// wrap breaks the words into lines of the width, breaking words that
+// are longer than a line
+func wrap(items []pdfItem, width float64) (lines [][]pdfPlaced) {
+	var line []pdfPlaced
+	used := 0.0
+	for _, item := range items {
+		if item.br {
+			lines = append(lines, line)
+			line, used = nil, 0
+			continue
+		}
+		gap := 0.0
+		if item.space && len(line) > 0 {
+			gap = textWidth(item.font, item.size, " ")
+		}
+		w := textWidth(item.font, item.size, item.text)
+		if used+gap+w > width && len(line) > 0 {
+			lines = append(lines, line)
+			line, used, gap = nil, 0, 0
+		}
+		for w > width-used && len(item.text) > 1 {
+			n := fitPrefix(item.font, item.size, item.text, width-used-gap)
+			part := item
+			part.text = item.text[:n]
+			line = append(line, pdfPlaced{pdfItem: part, x: used + gap, width: textWidth(item.font, item.size, part.text)})
+			lines = append(lines, line)
+			line, used, gap = nil, 0, 0
+			item.text = item.text[n:]
+			w = textWidth(item.font, item.size, item.text)
+		}
+		line = append(line, pdfPlaced{pdfItem: item, x: used + gap, width: w})
+		used += gap + w
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return
+}
+
+// paragraph lays out the items as lines, and the images in them as
+// blocks of their own
+func (p *pdfWriter) paragraph(items []pdfItem, s pdfStyle) {
+	x := pdfMargin + s.indent
+	width := pdfWidth - pdfMargin - x
+	var words []pdfItem
+	flush := func() {
+		for _, line := range wrap(words, width) {
+			p.line(line, x, s.size)
+		}
+		words = nil
+	}
+	for _, item := range items {
+		if item.image == nil {
+			words = append(words, item)
+			continue
+		}
+		flush()
+		p.image(item.image, s)
+	}
+	if len(words) > 0 || p.marker != "" {
+		flush()
+	}
+}
+
+// line draws a line of words on the page, with the marker of its list
+// item if it is the first
+func (p *pdfWriter) line(line []pdfPlaced, x, size float64) {
+	for _, placed := range line {
+		if placed.size > size {
+			size = placed.size
+		}
+	}
+	height := size * 1.4
+	p.ensure(height)
+	p.y -= height
+	baseline := p.y + size*0.35
+	if p.marker != "" {
+		p.text(fontRegular, size, x-14, baseline, p.marker, "")
+		p.marker = ""
+	}
+	p.words(line, x, baseline)
+}
+
+// words draws the words of a line, joining those in the same font
+func (p *pdfWriter) words(line []pdfPlaced, x, baseline float64) {
+	for i := 0; i < len(line); {
+		j := i + 1
+		text := line[i].text
+		for j < len(line) && line[j].font == line[i].font && line[j].size == line[i].size && line[j].link == line[i].link && line[j].space {
+			text += " " + line[j].text
+			j++
+		}
+		p.text(line[i].font, line[i].size, x+line[i].x, baseline, text, line[i].link)
+		i = j
+	}
+}
+
+// text draws text, which opens the link if it has one
+func (p *pdfWriter) text(font pdfFont, size, x, y float64, text, link string) {
+	if link != "" {
+		p.page.content.WriteString("0 0 0.55 rg ")
+	}
+	fmt.Fprintf(&p.page.content, "BT /F%d %.1f Tf %.2f %.2f Td %s Tj ET\n", font+1, size, x, y, pdfString(text))
+	if link != "" {
+		p.page.content.WriteString("0 g\n")
+		p.page.links = append(p.page.links, pdfLink{
+			x0: x, y0: y - size*0.25, x1: x + textWidth(font, size, text), y1: y + size*0.8,
+			uri: link,
+		})
+	}
+}
+
+// pre draws preformatted text as it is, breaking the lines that are
+// wider than the page
+func (p *pdfWriter) pre(text string, s pdfStyle) {
+	const size = 9.0
+	x := pdfMargin + s.indent + 8
+	perLine := int((pdfWidth - pdfMargin - x) / (0.6 * size))
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		line = winAnsi(strings.Replace(line, "\t", "    ", -1))
+		for {
+			part := line
+			if len(part) > perLine {
+				part = line[:perLine]
+			}
+			p.line([]pdfPlaced{{pdfItem: pdfItem{text: part, font: fontMono, size: size}}}, x, size)
+			line = line[len(part):]
+			if line == "" {
+				break
+			}
+		}
+	}
+}
+
+// table draws the rows of a table with their cells side by side, as
+// wide as each other
+func (p *pdfWriter) table(n *html.Node, s pdfStyle) {
+	rows := findElements(n, "tr")
+	columns := 0
+	for _, row := range rows {
+		if cells := len(tableCells(row)); cells > columns {
+			columns = cells
+		}
+	}
+	if columns == 0 {
+		return
+	}
+	x := pdfMargin + s.indent
+	width := (pdfWidth - pdfMargin - x) / float64(columns)
+	height := s.size * 1.4
+	for _, row := range rows {
+		var cells [][][]pdfPlaced
+		lines := 1
+		for _, cell := range tableCells(row) {
+			style := s
+			style.bold = cell.Data == "th"
+			var items []pdfItem
+			for c := cell.FirstChild; c != nil; c = c.NextSibling {
+				items = p.inline(items, c, style)
+			}
+			p.pendingSpace = false
+			// images in cells are left as their descriptions
+			var words []pdfItem
+			for _, item := range items {
+				if item.image != nil {
+					for _, word := range strings.Fields(winAnsi(attr(item.image, "alt"))) {
+						words = append(words, pdfItem{text: word, font: style.font(), size: style.size, space: true})
+					}
+					continue
+				}
+				words = append(words, item)
+			}
+			wrapped := wrap(words, width-8)
+			if len(wrapped) > lines {
+				lines = len(wrapped)
+			}
+			cells = append(cells, wrapped)
+		}
+		rowHeight := float64(lines)*height + 6
+		p.ensure(rowHeight)
+		top := p.y
+		for i := 0; i < columns; i++ {
+			left := x + float64(i)*width
+			fmt.Fprintf(&p.page.content, "0.5 w 0.6 G %.2f %.2f %.2f %.2f re S 0 G\n", left, top-rowHeight, width, rowHeight)
+			if i >= len(cells) {
+				continue
+			}
+			for j, line := range cells[i] {
+				p.words(line, left+4, top-3-float64(j+1)*height+s.size*0.35)
+			}
+		}
+		p.y -= rowHeight
+	}
+}
+
+// tableCells returns the cells of a row of a table
+func tableCells(row *html.Node) (cells []*html.Node) {
+	for c := row.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+			cells = append(cells, c)
+		}
+	}
+	return
+}
+
+// image draws an image as wide as it is, or as the page if it is wider,
+// or its description if it can not be loaded
+func (p *pdfWriter) image(n *html.Node, s pdfStyle) {
+	img := p.loadImage(attr(n, "src"))
+	if img == nil {
+		var items []pdfItem
+		for _, word := range strings.Fields(winAnsi(attr(n, "alt"))) {
+			items = append(items, pdfItem{text: word, font: s.font(), size: s.size, space: true})
+		}
+		p.paragraph(items, s)
+		return
+	}
+	x := pdfMargin + s.indent
+	maxWidth, maxHeight := pdfWidth-pdfMargin-x, pdfHeight-2*pdfMargin
+	width := float64(img.width) * pdfPointsPerPixel
+	height := float64(img.height) * pdfPointsPerPixel
+	if width > maxWidth {
+		width, height = maxWidth, height*maxWidth/width
+	}
+	if height > maxHeight {
+		width, height = width*maxHeight/height, maxHeight
+	}
+	p.ensure(height + 4)
+	p.y -= height + 4
+	fmt.Fprintf(&p.page.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", width, height, x, p.y, img.index)
+}
+
+// loadImage loads an image once, for every page that has it
+func (p *pdfWriter) loadImage(src string) *pdfImage {
+	if img, ok := p.imageSrcs[src]; ok {
+		return img
+	}
+	var img *pdfImage
+	if data, ok := p.doc.loadImage(src); ok {
+		img = newPDFImage(data.Data)
+	}
+	if img != nil {
+		p.images = append(p.images, img)
+		img.index = len(p.images)
+	}
+	p.imageSrcs[src] = img
+	return img
+}
+
+// newPDFImage embeds JPEG photos as they are, and other images as their
+// colors on a white background
+func newPDFImage(data []byte) *pdfImage {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		return nil
+	}
+	img := &pdfImage{width: config.Width, height: config.Height, colorSpace: "/DeviceRGB"}
+	if format == "jpeg" && (config.ColorModel == color.YCbCrModel || config.ColorModel == color.GrayModel) {
+		if config.ColorModel == color.GrayModel {
+			img.colorSpace = "/DeviceGray"
+		}
+		img.filter, img.data = "/DCTDecode", data
+		return img
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	bounds := decoded.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// the colors are premultiplied by their alpha, so adding
+			// what is transparent puts them on white
+			r, g, b, a := decoded.At(x, y).RGBA()
+			rgb = append(rgb, byte((r+0xffff-a)>>8), byte((g+0xffff-a)>>8), byte((b+0xffff-a)>>8))
+		}
+	}
+	img.filter, img.data = "/FlateDecode", deflate(rgb)
+	return img
+}
+
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	z := zlib.NewWriter(&b)
+	z.Write(data)
+	z.Close()
+	return b.Bytes()
+}
+
+// pdfString is a string of the standard fonts, escaped
+func pdfString(text string) string {
+	r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", `\r`)
+	return "(" + r.Replace(text) + ")"
+}
+
+// pdfTextString is a string of any letters, for the title and bookmarks
+func pdfTextString(text string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// pdfObjects numbers the objects of a PDF and keeps what is in them
+type pdfObjects [][]byte
+
+// reserve numbers an object that is written later
+func (o *pdfObjects) reserve() int {
+	*o = append(*o, nil)
+	return len(*o)
+}
+
+func (o *pdfObjects) set(number int, format string, args ...interface{}) {
+	(*o)[number-1] = []byte(fmt.Sprintf(format, args...))
+}
+
+func (o *pdfObjects) stream(number int, dict string, data []byte) {
+	(*o)[number-1] = append([]byte(fmt.Sprintf("<< %s /Length %d >>\nstream\n", dict, len(data))), append(data, "\nendstream"...)...)
+}
+
+// write writes the pages with their fonts and images, with bookmarks
+// for the chapters that start at the pages
+func (p *pdfWriter) write(w io.Writer, pages []*pdfPage, starts []int) (err error) {
+	var o pdfObjects
+	catalog, tree, outlines, info := o.reserve(), o.reserve(), o.reserve(), o.reserve()
+
+	var fonts strings.Builder
+	for i, name := range pdfFontNames {
+		font := o.reserve()
+		o.set(font, "<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name)
+		fmt.Fprintf(&fonts, "/F%d %d 0 R ", i+1, font)
+	}
+	var images strings.Builder
+	for _, img := range p.images {
+		number := o.reserve()
+		dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter %s",
+			img.width, img.height, img.colorSpace, img.filter)
+		o.stream(number, dict, img.data)
+		fmt.Fprintf(&images, "/Im%d %d 0 R ", img.index, number)
+	}
+	resources := fmt.Sprintf("<< /Font << %s>> /XObject << %s>> >>", fonts.String(), images.String())
+
+	pageNumbers := make([]int, len(pages))
+	for i := range pages {
+		pageNumbers[i] = o.reserve()
+	}
+	var kids strings.Builder
+	for i, page := range pages {
+		if i > 0 {
+			number := strconv.Itoa(i + 1)
+			fmt.Fprintf(&page.content, "BT /F1 9 Tf %.2f %.2f Td %s Tj ET\n", (pdfWidth-textWidth(fontRegular, 9, number))/2, pdfMargin/2, pdfString(number))
+		}
+		content := o.reserve()
+		o.stream(content, "/Filter /FlateDecode", deflate(page.content.Bytes()))
+		var annots strings.Builder
+		for _, link := range page.links {
+			annot := o.reserve()
+			action := fmt.Sprintf("/A << /S /URI /URI %s >>", pdfString(link.uri))
+			if link.uri == "" {
+				action = fmt.Sprintf("/Dest [%d 0 R /Fit]", pageNumbers[link.page])
+			}
+			o.set(annot, "<< /Type /Annot /Subtype /Link /Rect [%.2f %.2f %.2f %.2f] /Border [0 0 0] %s >>",
+				link.x0, link.y0, link.x1, link.y1, action)
+			fmt.Fprintf(&annots, "%d 0 R ", annot)
+		}
+		o.set(pageNumbers[i], "<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources %s /Contents %d 0 R /Annots [%s] >>",
+			tree, pdfWidth, pdfHeight, resources, content, annots.String())
+		fmt.Fprintf(&kids, "%d 0 R ", pageNumbers[i])
+	}
+	o.set(tree, "<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(pages))
+
+	// a bookmark for each chapter
+	items := make([]int, len(starts))
+	for i := range starts {
+		items[i] = o.reserve()
+	}
+	for i, start := range starts {
+		var siblings string
+		if i > 0 {
+			siblings += fmt.Sprintf(" /Prev %d 0 R", items[i-1])
+		}
+		if i+1 < len(items) {
+			siblings += fmt.Sprintf(" /Next %d 0 R", items[i+1])
+		}
+		o.set(items[i], "<< /Title %s /Parent %d 0 R%s /Dest [%d 0 R /Fit] >>",
+			pdfTextString(p.doc.Chapters[i].Title), outlines, siblings, pageNumbers[start])
+	}
+	if len(items) > 0 {
+		o.set(outlines, "<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>", items[0], items[len(items)-1], len(items))
+	} else {
+		o.set(outlines, "<< /Type /Outlines /Count 0 >>")
+	}
+	o.set(catalog, "<< /Type /Catalog /Pages %d 0 R /Outlines %d 0 R /PageMode /UseOutlines /Lang %s >>",
+		tree, outlines, pdfTextString(p.doc.language()))
+	o.set(info, "<< /Title %s /Producer (rwtxt) >>", pdfTextString(p.doc.Title))
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(o))
+	for i, object := range o {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n", i+1)
+		b.Write(object)
+		b.WriteString("\nendobj\n")
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(o)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(o)+1, catalog, info, xref)
+	_, err = w.Write(b.Bytes())
+	return
+}
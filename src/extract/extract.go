@@ -0,0 +1,66 @@
+// Package extract gets the text out of uploaded documents, so that they
+// can be searched along with the pages that link to them.
+package extract
+
+import (
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxText is the most text that is kept of a document
+const MaxText = 1 << 20
+
+// CanRead returns whether the text of a document with the name can be
+// read, so that documents without text need not be read at all
+func CanRead(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".txt", ".text", ".md", ".markdown", ".pdf":
+		return true
+	}
+	return false
+}
+
+// Text returns the text of a document, from its name and what is in it,
+// and whether it is a kind of document that has text to read
+func Text(name string, data []byte) (text string, ok bool) {
+	if !CanRead(name) {
+		return
+	}
+	if strings.ToLower(path.Ext(name)) == ".pdf" {
+		var err error
+		if text, err = PDF(data); err != nil {
+			return
+		}
+	} else if text = string(data); !utf8.Valid(data) {
+		return
+	}
+	return clean(text), true
+}
+
+// clean collapses the spaces within lines and the blank lines between
+// them, and cuts the text to MaxText
+func clean(text string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	text = strings.Join(lines, "\n")
+	if len(text) > MaxText {
+		text = text[:MaxText]
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	return text
+}
@@ -0,0 +1,85 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pdfWith makes a PDF of the objects, numbered from 1
+func pdfWith(objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, o := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func stream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func flate(data string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(data))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestPDF(t *testing.T) {
+	// a simple font, with the pages in the order of the page tree
+	simple := pdfWith(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [4 0 R 3 0 R] /Count 2 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+		stream("", []byte("BT /F1 12 Tf 72 720 Td (Second \\(page\\)) Tj ET")),
+		stream("", []byte("BT /F1 12 Tf 72 720 Td [(Quarterly)-300(rep)10(ort)] TJ 0 -14 Td (of the garden) Tj ET")),
+	)
+	text, err := PDF(simple)
+	assert.Nil(t, err)
+	assert.Equal(t, "Quarterly report\nof the garden\n\nSecond (page)", clean(text))
+
+	// a compressed page with a font that has a ToUnicode CMap
+	cmap := `/CIDInit /ProcSet findresource begin 12 dict begin begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar <0001> <0048> <0002> <0069> endbfchar
+1 beginbfrange <0003> <0005> <0061> endbfrange
+endcmap CMapName currentdict /CMap defineresource pop end end`
+	compressed := pdfWith(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /Font << /F2 4 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [5 0 R] >>",
+		"<< /Type /Font /Subtype /Type0 /ToUnicode 6 0 R >>",
+		stream("/Filter /FlateDecode", flate("BT /F2 10 Tf <00010002> Tj T* <000300040005> Tj ET")),
+		stream("/Filter [/FlateDecode]", flate(cmap)),
+	)
+	text, err = PDF(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hi\nabc", clean(text))
+
+	_, err = PDF([]byte("not a pdf"))
+	assert.NotNil(t, err)
+}
+
+func TestText(t *testing.T) {
+	text, ok := Text("notes.MD", []byte("# Notes\r\n\r\n\r\nsome   words\n"))
+	assert.True(t, ok)
+	assert.Equal(t, "# Notes\n\nsome words", text)
+
+	_, ok = Text("photo.jpg", []byte("whatever"))
+	assert.False(t, ok)
+	_, ok = Text("binary.txt", []byte{0xff, 0xfe, 0x00})
+	assert.False(t, ok)
+
+	text, ok = Text("long.txt", []byte(strings.Repeat("é", MaxText)))
+	assert.True(t, ok)
+	assert.Equal(t, MaxText, len(text))
+}
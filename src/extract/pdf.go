@@ -0,0 +1,644 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfObjectStart finds where the objects of a PDF start, like "12 0 obj"
+var pdfObjectStart = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+
+// the values of a PDF, which are float64, bool, nil and these
+type (
+	pdfName    string
+	pdfKeyword string
+	pdfString  []byte
+	pdfArray   []interface{}
+	pdfDict    map[string]interface{}
+	pdfRef     int
+)
+
+// pdfObject is an object of a PDF with its stream, if it has one
+type pdfObject struct {
+	value  interface{}
+	stream []byte
+}
+
+// pdfFile has the objects of a PDF by their numbers
+type pdfFile struct {
+	objects map[int]pdfObject
+}
+
+// PDF returns the text of the pages of a PDF, in the order of its pages.
+// Only the text that is written with fonts that say which characters
+// they show, or with simple fonts, can be read.
+func PDF(data []byte) (text string, err error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("%PDF")) {
+		return "", errors.New("not a pdf")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", errors.New("the pdf is encrypted")
+	}
+	f := &pdfFile{objects: make(map[int]pdfObject)}
+	f.readObjects(data)
+
+	var b strings.Builder
+	for _, page := range f.pages() {
+		f.readPage(&b, page)
+		b.WriteString("\n\n")
+		if b.Len() > MaxText {
+			break
+		}
+	}
+	return b.String(), nil
+}
+
+// readObjects reads every object of the file, also those that are
+// compressed into object streams
+func (f *pdfFile) readObjects(data []byte) {
+	for _, m := range pdfObjectStart.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		l := &pdfLexer{data: data, pos: m[1]}
+		value := l.object()
+		var stream []byte
+		if l.keyword() == "stream" {
+			start := l.pos
+			if start < len(data) && data[start] == '\r' {
+				start++
+			}
+			if start < len(data) && data[start] == '\n' {
+				start++
+			}
+			end := bytes.Index(data[start:], []byte("endstream"))
+			if end < 0 {
+				continue
+			}
+			stream = bytes.TrimRight(data[start:start+end], "\r\n")
+		}
+		// objects that come later in the file replace earlier ones
+		f.objects[num] = pdfObject{value: value, stream: stream}
+	}
+	for _, o := range f.objects {
+		dict, ok := o.value.(pdfDict)
+		if !ok || dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		f.readObjectStream(dict, o.stream)
+	}
+}
+
+// readObjectStream reads the objects that are compressed into a stream,
+// which starts with the number and offset of each of them
+func (f *pdfFile) readObjectStream(dict pdfDict, stream []byte) {
+	data, ok := f.decode(dict, stream)
+	if !ok {
+		return
+	}
+	n, _ := dict["N"].(float64)
+	first, _ := dict["First"].(float64)
+	l := &pdfLexer{data: data}
+	for i := 0; i < int(n); i++ {
+		num, ok1 := l.object().(float64)
+		offset, ok2 := l.object().(float64)
+		if !ok1 || !ok2 {
+			return
+		}
+		if _, exists := f.objects[int(num)]; exists {
+			continue
+		}
+		o := &pdfLexer{data: data, pos: int(first) + int(offset)}
+		if o.pos < len(data) {
+			f.objects[int(num)] = pdfObject{value: o.object()}
+		}
+	}
+}
+
+// resolve follows a reference to the object it refers to
+func (f *pdfFile) resolve(value interface{}) interface{} {
+	for i := 0; i < 10; i++ {
+		ref, ok := value.(pdfRef)
+		if !ok {
+			return value
+		}
+		value = f.objects[int(ref)].value
+	}
+	return nil
+}
+
+// dict returns the dictionary that the value is or refers to
+func (f *pdfFile) dict(value interface{}) pdfDict {
+	dict, _ := f.resolve(value).(pdfDict)
+	return dict
+}
+
+// streamOf returns the decoded stream of the object that the value refers
+// to
+func (f *pdfFile) streamOf(value interface{}) []byte {
+	ref, ok := value.(pdfRef)
+	if !ok {
+		return nil
+	}
+	o := f.objects[int(ref)]
+	dict, _ := o.value.(pdfDict)
+	data, _ := f.decode(dict, o.stream)
+	return data
+}
+
+// decode uncompresses a stream, which can only be done for streams that
+// are not compressed or are compressed with Flate
+func (f *pdfFile) decode(dict pdfDict, stream []byte) (data []byte, ok bool) {
+	var filters []interface{}
+	switch filter := f.resolve(dict["Filter"]).(type) {
+	case pdfName:
+		filters = append(filters, filter)
+	case pdfArray:
+		filters = filter
+	}
+	data = stream
+	for _, filter := range filters {
+		if f.resolve(filter) != pdfName("FlateDecode") {
+			return nil, false
+		}
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false
+		}
+		// streams are often cut short, so what could be read is kept
+		data, _ = ioutil.ReadAll(r)
+		r.Close()
+	}
+	return data, true
+}
+
+// pages returns the pages of the file in order, from its tree of pages or
+// else in the order of their objects
+func (f *pdfFile) pages() (pages []pdfDict) {
+	var root pdfDict
+	for _, o := range f.objects {
+		if dict, ok := o.value.(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+			root = dict
+			break
+		}
+	}
+	seen := make(map[int]bool)
+	var walk func(value interface{}, depth int)
+	walk = func(value interface{}, depth int) {
+		if ref, ok := value.(pdfRef); ok {
+			if seen[int(ref)] {
+				return
+			}
+			seen[int(ref)] = true
+		}
+		node := f.dict(value)
+		if node == nil || depth > 50 {
+			return
+		}
+		if node["Type"] == pdfName("Page") {
+			pages = append(pages, node)
+			return
+		}
+		kids, _ := f.resolve(node["Kids"]).(pdfArray)
+		for _, kid := range kids {
+			walk(kid, depth+1)
+		}
+	}
+	if root != nil {
+		walk(root["Pages"], 0)
+	}
+	if len(pages) > 0 {
+		return
+	}
+	var nums []int
+	for num, o := range f.objects {
+		if dict, ok := o.value.(pdfDict); ok && dict["Type"] == pdfName("Page") {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		pages = append(pages, f.objects[num].value.(pdfDict))
+	}
+	return
+}
+
+// fonts returns how the fonts of the page turn what is shown into text,
+// by their names in the page
+func (f *pdfFile) fonts(page pdfDict) map[string]*pdfCMap {
+	resources := f.dict(page["Resources"])
+	for parent, i := page, 0; resources == nil && parent != nil && i < 50; i++ {
+		// pages take the resources of their parents when they have none
+		parent = f.dict(parent["Parent"])
+		if parent != nil {
+			resources = f.dict(parent["Resources"])
+		}
+	}
+	fonts := make(map[string]*pdfCMap)
+	for name, value := range f.dict(resources["Font"]) {
+		font := f.dict(value)
+		if font == nil {
+			continue
+		}
+		if cmap := f.streamOf(font["ToUnicode"]); cmap != nil {
+			fonts[name] = parseCMap(cmap)
+		}
+	}
+	return fonts
+}
+
+// readPage writes the text of the page, line by line
+func (f *pdfFile) readPage(b *strings.Builder, page pdfDict) {
+	var content []byte
+	switch contents := f.resolve(page["Contents"]).(type) {
+	case pdfArray:
+		for _, part := range contents {
+			content = append(append(content, f.streamOf(part)...), '\n')
+		}
+	case pdfDict:
+		content = f.streamOf(page["Contents"])
+	}
+	fonts := f.fonts(page)
+
+	var font *pdfCMap
+	var operands []interface{}
+	show := func(s pdfString) {
+		if font != nil {
+			b.WriteString(font.text(s))
+			return
+		}
+		// simple fonts are taken to be in Latin-1
+		for _, c := range s {
+			if c >= ' ' {
+				b.WriteRune(rune(c))
+			}
+		}
+	}
+	l := &pdfLexer{data: content}
+	for l.pos < len(l.data) {
+		value := l.object()
+		keyword, ok := value.(pdfKeyword)
+		if !ok {
+			if value != nil {
+				operands = append(operands, value)
+			}
+			continue
+		}
+		switch keyword {
+		case "Tf":
+			if len(operands) >= 2 {
+				name, _ := operands[len(operands)-2].(pdfName)
+				font = fonts[string(name)]
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				s, _ := operands[len(operands)-1].(pdfString)
+				show(s)
+			}
+		case "'", `"`:
+			b.WriteString("\n")
+			if len(operands) >= 1 {
+				s, _ := operands[len(operands)-1].(pdfString)
+				show(s)
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				array, _ := operands[len(operands)-1].(pdfArray)
+				for _, item := range array {
+					switch item := item.(type) {
+					case pdfString:
+						show(item)
+					case float64:
+						// a gap wider than kerning is a space, which is
+						// a quarter of an em in most fonts
+						if item < -150 {
+							b.WriteString(" ")
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if y, _ := operands[len(operands)-1].(float64); y != 0 {
+					b.WriteString("\n")
+				} else {
+					b.WriteString(" ")
+				}
+			}
+		case "T*", "ET":
+			b.WriteString("\n")
+		case "Tm":
+			b.WriteString(" ")
+		case "ID":
+			// the data of an inline image, up to EI
+			end := bytes.Index(l.data[l.pos:], []byte("EI"))
+			if end < 0 {
+				return
+			}
+			l.pos += end + 2
+		}
+		operands = operands[:0]
+	}
+}
+
+// pdfCMap maps the codes shown with a font to their text
+type pdfCMap struct {
+	width int
+	codes map[int]string
+}
+
+// parseCMap reads the codes of the ToUnicode CMap of a font
+func parseCMap(data []byte) *pdfCMap {
+	cmap := &pdfCMap{width: 1, codes: make(map[int]string)}
+	code := func(s pdfString) int {
+		if len(s) > 1 {
+			cmap.width = 2
+		}
+		n := 0
+		for _, c := range s {
+			n = n<<8 | int(c)
+		}
+		return n
+	}
+	l := &pdfLexer{data: data}
+	var operands []interface{}
+	for l.pos < len(l.data) {
+		value := l.object()
+		keyword, ok := value.(pdfKeyword)
+		if !ok {
+			if value != nil {
+				operands = append(operands, value)
+			}
+			continue
+		}
+		switch keyword {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, _ := operands[i].(pdfString)
+				dst, _ := operands[i+1].(pdfString)
+				cmap.codes[code(src)] = utf16Text(dst)
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, _ := operands[i].(pdfString)
+				hi, _ := operands[i+1].(pdfString)
+				from, to := code(lo), code(hi)
+				if to-from > 0xffff {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					text := []rune(utf16Text(dst))
+					for c := from; c <= to && len(text) > 0; c++ {
+						cmap.codes[c] = string(text)
+						text[len(text)-1]++
+					}
+				case pdfArray:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok {
+							cmap.codes[from+j] = utf16Text(s)
+						}
+					}
+				}
+			}
+		}
+		if strings.HasPrefix(string(keyword), "end") || strings.HasPrefix(string(keyword), "begin") {
+			operands = operands[:0]
+		}
+	}
+	return cmap
+}
+
+// text returns the text of the codes of a string shown with the font
+func (cmap *pdfCMap) text(s pdfString) string {
+	var b strings.Builder
+	for i := 0; i+cmap.width <= len(s); i += cmap.width {
+		n := 0
+		for _, c := range s[i : i+cmap.width] {
+			n = n<<8 | int(c)
+		}
+		b.WriteString(cmap.codes[n])
+	}
+	return b.String()
+}
+
+// utf16Text decodes the UTF-16BE text of a CMap
+func utf16Text(s pdfString) string {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// pdfLexer reads the values of a PDF or of a content stream
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// skipSpace skips the spaces and comments before the next value
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		} else if !isPDFSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// keyword returns the next keyword, without reading past it if the next
+// value is something else
+func (l *pdfLexer) keyword() pdfKeyword {
+	start := l.pos
+	if keyword, ok := l.object().(pdfKeyword); ok {
+		return keyword
+	}
+	l.pos = start
+	return ""
+}
+
+// word reads the characters up to the next space or delimiter
+func (l *pdfLexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// object reads the next value, with references for "12 0 R"
+func (l *pdfLexer) object() interface{} {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil
+	}
+	switch c := l.data[l.pos]; {
+	case c == '/':
+		l.pos++
+		return pdfName(l.word())
+	case c == '(':
+		return l.literalString()
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		dict := make(pdfDict)
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.data) {
+				return dict
+			}
+			if l.data[l.pos] == '>' {
+				l.pos += 2
+				return dict
+			}
+			key, ok := l.object().(pdfName)
+			if !ok {
+				continue
+			}
+			dict[string(key)] = l.object()
+		}
+	case c == '<':
+		return l.hexString()
+	case c == '[':
+		l.pos++
+		array := pdfArray{}
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.data) {
+				return array
+			}
+			if l.data[l.pos] == ']' {
+				l.pos++
+				return array
+			}
+			array = append(array, l.object())
+		}
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		l.pos++
+		return nil
+	}
+
+	word := l.word()
+	if word == "" {
+		l.pos++
+		return nil
+	}
+	number, err := strconv.ParseFloat(word, 64)
+	if err != nil {
+		switch word {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return pdfKeyword(word)
+	}
+	// a number may start a reference
+	start := l.pos
+	l.skipSpace()
+	if _, err := strconv.Atoi(l.word()); err == nil {
+		l.skipSpace()
+		if l.word() == "R" {
+			return pdfRef(int(number))
+		}
+	}
+	l.pos = start
+	return number
+}
+
+// literalString reads a string in parentheses, which may have balanced
+// parentheses and escapes in it
+func (l *pdfLexer) literalString() pdfString {
+	l.pos++
+	var s []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return s
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return s
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// the string goes on on the next line
+				if c == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					n := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(n)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+// hexString reads a string of hexadecimal digits in angle brackets
+func (l *pdfLexer) hexString() pdfString {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	for i := range s {
+		n, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		s[i] = byte(n)
+	}
+	return s
+}
@@ -0,0 +1,314 @@
+// Package images removes the metadata of uploaded images, like where a
+// photo was taken, and shrinks photos that are larger than the web needs.
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+var (
+	jpegStart = []byte{0xFF, 0xD8}
+	pngStart  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Strip removes the metadata of JPEG, PNG and WebP images: EXIF (with
+// the location of the camera), XMP, IPTC, comments and text. JPEG
+// photos keep which way up they are. Other data, and images that can
+// not be read, are returned as they are.
+func Strip(data []byte) []byte {
+	var stripped []byte
+	var ok bool
+	switch {
+	case bytes.HasPrefix(data, jpegStart):
+		stripped, _, ok = stripJPEG(data)
+	case bytes.HasPrefix(data, pngStart):
+		stripped, ok = stripPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		stripped, ok = stripWebP(data)
+	}
+	if !ok {
+		return data
+	}
+	return stripped
+}
+
+// stripJPEG drops the APP1 (EXIF and XMP), APP3 to APP13 and APP15
+// segments and the comments. APP0 (JFIF), APP2 (color profiles) and
+// APP14 (Adobe color transforms) change how the image looks, so they
+// are kept. The orientation of EXIF is kept in an EXIF of its own, and
+// returned.
+func stripJPEG(data []byte) (stripped []byte, orientation int, ok bool) {
+	orientation = 1
+	var segments [][]byte
+	i := 2
+	for {
+		if i+2 > len(data) || data[i] != 0xFF {
+			return
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// fill bytes
+			i++
+			continue
+		}
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			segments = append(segments, data[i:i+2])
+			i += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// the image data follows the start of scan
+			segments = append(segments, data[i:])
+			break
+		}
+		if i+4 > len(data) {
+			return
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return
+		}
+		segment := data[i:end]
+		i = end
+		switch {
+		case marker == 0xE1:
+			if o := exifOrientation(segment[4:]); o > 1 {
+				orientation = o
+			}
+		case marker >= 0xE3 && marker <= 0xEF && marker != 0xEE, marker == 0xFE:
+			// dropped
+		default:
+			segments = append(segments, segment)
+		}
+	}
+
+	stripped = append([]byte{}, jpegStart...)
+	if len(segments) > 0 && segments[0][1] == 0xE0 {
+		stripped = append(stripped, segments[0]...)
+		segments = segments[1:]
+	}
+	if orientation > 1 {
+		stripped = append(stripped, orientationEXIF(orientation)...)
+	}
+	for _, segment := range segments {
+		stripped = append(stripped, segment...)
+	}
+	return stripped, orientation, true
+}
+
+// exifOrientation reads which way up a photo is from the EXIF of a
+// JPEG, which is 1 if it is the right way up or can not be read
+func exifOrientation(exif []byte) int {
+	if len(exif) < 14 || string(exif[:6]) != "Exif\x00\x00" {
+		return 1
+	}
+	tiff := exif[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// orientationEXIF is an APP1 segment with nothing but the orientation
+func orientationEXIF(orientation int) []byte {
+	segment := []byte{0xFF, 0xE1, 0, 34}
+	segment = append(segment, "Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08"...)
+	// one entry: orientation, a short, one of them
+	segment = append(segment, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0)
+	// no next IFD
+	return append(segment, 0, 0, 0, 0)
+}
+
+// pngMetadata are the chunks of PNG images that only have metadata
+var pngMetadata = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNG(data []byte) (stripped []byte, ok bool) {
+	stripped = append([]byte{}, pngStart...)
+	for i := len(pngStart); i < len(data); {
+		if i+12 > len(data) {
+			return
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return
+		}
+		chunk := data[i:end]
+		i = end
+		if !pngMetadata[string(chunk[4:8])] {
+			stripped = append(stripped, chunk...)
+		}
+		if string(chunk[4:8]) == "IEND" {
+			break
+		}
+	}
+	return stripped, true
+}
+
+func stripWebP(data []byte) (stripped []byte, ok bool) {
+	stripped = append([]byte{}, data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			if i+8+size != len(data) {
+				return
+			}
+			// the padding of the last chunk is sometimes left out
+			end = len(data)
+		}
+		chunk := data[i:end]
+		i = end
+		switch string(chunk[:4]) {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			if size > 0 {
+				chunk = append([]byte{}, chunk...)
+				// clear the flags that say there are EXIF and XMP
+				chunk[8] &^= 0x08 | 0x04
+			}
+		}
+		stripped = append(stripped, chunk...)
+	}
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, true
+}
+
+// Shrink makes a JPEG photo whose longest side is longer than maxSide
+// that long, turned the right way up. It returns false if the data is
+// not a JPEG or is small enough already.
+func Shrink(data []byte, maxSide, quality int) (shrunk []byte, ok bool) {
+	if !bytes.HasPrefix(data, jpegStart) || maxSide <= 0 {
+		return
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= maxSide && config.Height <= maxSide {
+		return
+	}
+	_, orientation, _ := stripJPEG(data)
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	width, height := maxSide, config.Height*maxSide/config.Width
+	if config.Height > config.Width {
+		width, height = config.Width*maxSide/config.Height, maxSide
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	small := orient(scale(img, width, height), orientation)
+
+	var b bytes.Buffer
+	if err = jpeg.Encode(&b, small, &jpeg.Options{Quality: quality}); err != nil {
+		return
+	}
+	return b.Bytes(), true
+}
+
+// scale shrinks an image by averaging the pixels that each pixel of the
+// smaller image covers
+func scale(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, (y+1)*srcHeight/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, (x+1)*srcWidth/width
+			if x1 == x0 {
+				x1++
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			p := dst.Pix[y*dst.Stride+x*4:]
+			for i := range sum {
+				p[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// orient turns an image the right way up, by its EXIF orientation
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], img.Pix[y*img.Stride+x*4:y*img.Stride+x*4+4])
+		}
+	}
+	return dst
+}
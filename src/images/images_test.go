@@ -0,0 +1,138 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	// the top left corner is white, to see which way up it is
+	for y := 0; y < height/4; y++ {
+		for x := 0; x < width/4; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	return img
+}
+
+// exifSegment is an APP1 segment of a camera, with its orientation and
+// where it was
+func exifSegment(orientation int) []byte {
+	tiff := []byte("II\x2A\x00\x08\x00\x00\x00")
+	tiff = append(tiff, 2, 0)
+	tiff = append(tiff, 0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), 0, 0, 0)
+	// a GPS IFD, which only needs to be found by the test
+	tiff = append(tiff, 0x25, 0x88, 4, 0, 1, 0, 0, 0, 38, 0, 0, 0)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, "GPS 52.37N 4.89E"...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	segment = append(segment, "Exif\x00\x00"...)
+	segment = append(segment, tiff...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return segment
+}
+
+func testJPEG(t *testing.T, width, height, orientation int) []byte {
+	var b bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&b, testImage(width, height), nil))
+	data := b.Bytes()
+	comment := []byte{0xFF, 0xFE, 0, 7, 'h', 'e', 'l', 'l', 'o'}
+	return append(append(append([]byte{0xFF, 0xD8}, exifSegment(orientation)...), comment...), data[2:]...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	data := testJPEG(t, 16, 8, 6)
+	stripped := Strip(data)
+	assert.False(t, bytes.Contains(stripped, []byte("GPS")))
+	assert.False(t, bytes.Contains(stripped, []byte("hello")))
+	assert.True(t, bytes.Contains(stripped, orientationEXIF(6)))
+	_, orientation, ok := stripJPEG(stripped)
+	assert.True(t, ok)
+	assert.Equal(t, 6, orientation)
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	assert.Nil(t, err)
+	assert.Equal(t, 16, img.Bounds().Dx())
+
+	// upright photos need no EXIF at all
+	stripped = Strip(testJPEG(t, 16, 8, 1))
+	assert.False(t, bytes.Contains(stripped, []byte("Exif")))
+
+	assert.Equal(t, []byte("not an image"), Strip([]byte("not an image")))
+	assert.Equal(t, data[:40], Strip(data[:40]))
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, sum...)
+}
+
+func TestStripPNG(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, png.Encode(&b, testImage(4, 4)))
+	data := b.Bytes()
+	iend := len(data) - 12
+	data = append(append(append([]byte{}, data[:iend]...), pngChunk("tEXt", []byte("GPS\x0052.37N"))...), data[iend:]...)
+	stripped := Strip(data)
+	assert.False(t, bytes.Contains(stripped, []byte("GPS")))
+	assert.Equal(t, b.Len(), len(stripped))
+	_, err := png.Decode(bytes.NewReader(stripped))
+	assert.Nil(t, err)
+}
+
+func TestStripWebP(t *testing.T) {
+	chunk := func(kind string, data []byte) []byte {
+		c := append([]byte(kind), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(c[4:], uint32(len(data)))
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	data = append(data, chunk("VP8X", []byte{0x0C, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+	data = append(data, chunk("VP8L", []byte{1, 2, 3})...)
+	data = append(data, chunk("EXIF", []byte("GPS 52.37N"))...)
+	data = append(data, chunk("XMP ", []byte("<x:xmpmeta/>"))...)
+	stripped := Strip(data)
+	assert.False(t, bytes.Contains(stripped, []byte("GPS")))
+	assert.False(t, bytes.Contains(stripped, []byte("xmpmeta")))
+	assert.Equal(t, byte(0), stripped[20])
+	assert.Equal(t, uint32(len(stripped)-8), binary.LittleEndian.Uint32(stripped[4:]))
+}
+
+func TestShrink(t *testing.T) {
+	_, ok := Shrink(testJPEG(t, 40, 20, 1), 40, 85)
+	assert.False(t, ok)
+
+	// turned to stand up, with the white corner at the top right
+	shrunk, ok := Shrink(testJPEG(t, 400, 200, 6), 100, 85)
+	assert.True(t, ok)
+	img, err := jpeg.Decode(bytes.NewReader(shrunk))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 100), img.Bounds())
+	r, _, _, _ := img.At(45, 5).RGBA()
+	assert.True(t, r > 0xE000)
+	r, _, _, _ = img.At(5, 90).RGBA()
+	assert.True(t, r < 0x8000)
+	assert.False(t, bytes.Contains(shrunk, []byte("GPS")))
+}
@@ -0,0 +1,126 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type zipEntry struct {
+	data     []byte
+	modified time.Time
+}
+
+// readZip reads every file of the archive, including the archives
+// inside it that Notion splits large exports into
+func readZip(r io.ReaderAt, size int64, entries map[string]zipEntry) (err error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return errors.Wrap(err, "reading zip")
+	}
+	for _, f := range z.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, errOpen := f.Open()
+		if errOpen != nil {
+			return errors.Wrap(errOpen, "reading "+f.Name)
+		}
+		data, errRead := ioutil.ReadAll(rc)
+		rc.Close()
+		if errRead != nil {
+			return errors.Wrap(errRead, "reading "+f.Name)
+		}
+		if strings.ToLower(path.Ext(f.Name)) == ".zip" {
+			if err = readZip(bytes.NewReader(data), int64(len(data)), entries); err != nil {
+				return
+			}
+			continue
+		}
+		entries[f.Name] = zipEntry{data: data, modified: f.Modified}
+	}
+	return
+}
+
+// trimRoot removes the folder that every file is in, if there is one
+func trimRoot(entries map[string]zipEntry) map[string]zipEntry {
+	root := ""
+	for name := range entries {
+		i := strings.Index(name, "/")
+		if i < 0 || (root != "" && name[:i] != root) {
+			return entries
+		}
+		root = name[:i]
+	}
+	trimmed := make(map[string]zipEntry)
+	for name, entry := range entries {
+		trimmed[strings.TrimPrefix(name, root+"/")] = entry
+	}
+	return trimmed
+}
+
+// archive is a set of exported files, where pages link to each
+// other and to the other files by relative paths
+type archive struct {
+	entries     map[string]zipEntry
+	opts        Options
+	slugger     *slugger
+	slugs       map[string]string
+	attachments map[string]string
+	// err is the first attachment that could not be saved
+	err error
+}
+
+func newArchive(entries map[string]zipEntry, opts Options) *archive {
+	return &archive{
+		entries:     entries,
+		opts:        opts,
+		slugger:     newSlugger(opts.Folder),
+		slugs:       make(map[string]string),
+		attachments: make(map[string]string),
+	}
+}
+
+// addPage gives the file a slug from its folders and title
+func (a *archive) addPage(name string, folders []string, title string) {
+	a.slugs[name] = a.slugger.slug(folders, title)
+}
+
+// resolve rewrites a relative link in the file so that it points to the
+// imported page or to the saved attachment
+func (a *archive) resolve(name, link string) string {
+	if strings.Contains(link, ":") || strings.HasPrefix(link, "#") || strings.HasPrefix(link, "/") {
+		return link
+	}
+	target, err := url.PathUnescape(strings.SplitN(link, "#", 2)[0])
+	if err != nil {
+		return link
+	}
+	target = path.Join(path.Dir(name), target)
+	if slug, ok := a.slugs[target]; ok {
+		return "/" + a.opts.Domain + "/" + slug
+	}
+	if saved, ok := a.attachments[target]; ok {
+		return saved
+	}
+	attachment, ok := a.entries[target]
+	if !ok || a.opts.SaveAttachment == nil {
+		return link
+	}
+	saved, err := a.opts.SaveAttachment(path.Base(target), attachment.data)
+	if err != nil {
+		if a.err == nil {
+			a.err = err
+		}
+		return link
+	}
+	a.attachments[target] = saved
+	return saved
+}
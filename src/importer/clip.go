@@ -0,0 +1,158 @@
+package importer
+
+import (
+	"bytes"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// unlikelyContent matches the classes and ids of the parts of a web page
+// that are not its content
+var unlikelyContent = regexp.MustCompile(`(?i)comment|sidebar|footer|masthead|navbar|menu|share|social|advert|sponsor|promo|related|cookie|newsletter|subscribe|popup|banner`)
+
+// Clip converts a web page into markdown, keeping only its main content,
+// with links and images made absolute. When selection is given, only that
+// part of the page is converted.
+func Clip(page *url.URL, document []byte, selection string) (title, markdown string, err error) {
+	root, err := html.Parse(bytes.NewReader(document))
+	if err != nil {
+		err = errors.Wrap(err, "parsing page")
+		return
+	}
+	title = pageTitle(root)
+
+	resolve := func(link string) string {
+		ref, errParse := url.Parse(strings.TrimSpace(link))
+		if errParse != nil || page == nil {
+			return link
+		}
+		return page.ResolveReference(ref).String()
+	}
+
+	var content *html.Node
+	if strings.TrimSpace(selection) != "" {
+		content, err = parseFragment(selection)
+		if err != nil {
+			err = errors.Wrap(err, "parsing selection")
+			return
+		}
+	} else {
+		removeClutter(root)
+		content = mainContent(root)
+	}
+	markdown = htmlToMarkdown{resolve: resolve}.convert(content)
+
+	// the title is added again when the page is saved
+	if heading, rest := splitTitle(markdown); heading != "" && heading == title {
+		markdown = rest
+	}
+	return
+}
+
+func parseFragment(s string) (body *html.Node, err error) {
+	root, err := html.Parse(strings.NewReader("<body>" + s + "</body>"))
+	if err != nil {
+		return
+	}
+	return documentBody(root), nil
+}
+
+// pageTitle returns the title of the page from its metadata
+func pageTitle(root *html.Node) string {
+	for _, meta := range findElements(root, "meta") {
+		if attr(meta, "property") == "og:title" && strings.TrimSpace(attr(meta, "content")) != "" {
+			return strings.TrimSpace(attr(meta, "content"))
+		}
+	}
+	for _, tag := range []string{"title", "h1"} {
+		if found := findElements(root, tag); len(found) > 0 {
+			if title := strings.TrimSpace(spaces.ReplaceAllString(textContent(found[0]), " ")); title != "" {
+				return title
+			}
+		}
+	}
+	return ""
+}
+
+// removeClutter removes the navigation, scripts, forms and the like
+func removeClutter(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.CommentNode {
+			n.RemoveChild(c)
+		} else if c.Type == html.ElementNode {
+			remove := false
+			switch c.Data {
+			case "script", "style", "noscript", "nav", "header", "footer", "aside",
+				"form", "iframe", "svg", "button", "input", "select", "textarea", "template":
+				remove = true
+			case "html", "body", "main", "article":
+			default:
+				remove = unlikelyContent.MatchString(attr(c, "class") + " " + attr(c, "id"))
+			}
+			if remove {
+				n.RemoveChild(c)
+			} else {
+				removeClutter(c)
+			}
+		}
+		c = next
+	}
+}
+
+// mainContent finds the element that holds most of the text of the page,
+// scoring the parents of paragraphs by how much text they have
+func mainContent(root *html.Node) *html.Node {
+	if articles := findElements(root, "article"); len(articles) == 1 {
+		return articles[0]
+	}
+	if mains := findElements(root, "main"); len(mains) == 1 {
+		return mains[0]
+	}
+
+	scores := make(map[*html.Node]float64)
+	for _, tag := range []string{"p", "pre"} {
+		for _, p := range findElements(root, tag) {
+			text := strings.TrimSpace(textContent(p))
+			if len(text) < 25 || p.Parent == nil {
+				continue
+			}
+			score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text))/100, 3)
+			scores[p.Parent] += score
+			if p.Parent.Parent != nil {
+				scores[p.Parent.Parent] += score / 2
+			}
+		}
+	}
+
+	var best *html.Node
+	bestScore := 0.0
+	for n, score := range scores {
+		score *= 1 - linkDensity(n)
+		if score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	if best == nil {
+		return documentBody(root)
+	}
+	return best
+}
+
+// linkDensity is how much of the text of the element is in links
+func linkDensity(n *html.Node) float64 {
+	total := len(strings.TrimSpace(textContent(n)))
+	if total == 0 {
+		return 0
+	}
+	linked := 0
+	for _, a := range findElements(n, "a") {
+		linked += len(strings.TrimSpace(textContent(a)))
+	}
+	return float64(linked) / float64(total)
+}
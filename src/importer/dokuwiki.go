@@ -0,0 +1,332 @@
+package importer
+
+import (
+	"archive/zip"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	dokuWikiHeading   = regexp.MustCompile(`^(={2,6})\s*(.+?)\s*={2,6}\s*$`)
+	dokuWikiListItem  = regexp.MustCompile(`^((?:  |\t)+)([*-])\s*(.*)$`)
+	dokuWikiCodeTag   = regexp.MustCompile(`^<(code|file)\b`)
+	dokuWikiMonospace = regexp.MustCompile(`''(.+?)''|%%(.+?)%%|<nowiki>(.*?)</nowiki>`)
+	dokuWikiLink      = regexp.MustCompile(`\[\[([^\]|]+)(?:\|([^\]]*))?\]\]`)
+	dokuWikiMedia     = regexp.MustCompile(`\{\{\s*([^}|?]+?)\s*(?:\?[^}|]*)?(?:\|([^}]*))?\}\}`)
+	dokuWikiTags      = regexp.MustCompile(`\{\{tag>([^}]*)\}\}`)
+	dokuWikiItalic    = regexp.MustCompile(`(^|[^:])//(.*?[^:])//`)
+	dokuWikiUnderline = regexp.MustCompile(`__(.+?)__`)
+	dokuWikiBreak     = regexp.MustCompile(`\\\\(\s|$)`)
+	dokuWikiMacro     = regexp.MustCompile(`~~[A-Z]+~~`)
+	imageExtensions   = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true}
+)
+
+// dokuWikiRoots are the folders of the pages and the media of a DokuWiki,
+// which are in its data folder
+func dokuWikiRoots(names []string) (pages, media string, ok bool) {
+	for _, root := range []string{"", "data/"} {
+		for _, name := range names {
+			if strings.HasPrefix(name, root+"pages/") && strings.HasSuffix(name, ".txt") {
+				return root + "pages/", root + "media/", true
+			}
+		}
+	}
+	return
+}
+
+// IsDokuWiki returns whether the zip has the pages of a DokuWiki, which
+// are the text files in its data/pages folder
+func IsDokuWiki(r io.ReaderAt, size int64) bool {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return false
+	}
+	names := make(map[string]zipEntry)
+	for _, f := range z.File {
+		names[f.Name] = zipEntry{}
+	}
+	var trimmed []string
+	for name := range trimRoot(names) {
+		trimmed = append(trimmed, name)
+	}
+	_, _, ok := dokuWikiRoots(trimmed)
+	return ok
+}
+
+// dokuWikiID is the id of a page or file from a link to it in the
+// namespace, like wiki:syntax
+func dokuWikiID(namespace, id string) string {
+	id = strings.ToLower(strings.TrimSpace(strings.Replace(id, "/", ":", -1)))
+	id = strings.Replace(id, " ", "_", -1)
+	switch {
+	case strings.HasPrefix(id, ":"):
+		return strings.TrimPrefix(id, ":")
+	case strings.HasPrefix(id, ".:"):
+		id = strings.TrimPrefix(id, ".:")
+	case strings.HasPrefix(id, "..:"):
+		if i := strings.LastIndex(namespace, ":"); i >= 0 {
+			namespace = namespace[:i]
+		} else {
+			namespace = ""
+		}
+		id = strings.TrimPrefix(id, "..:")
+	case strings.Contains(id, ":"):
+		return id
+	}
+	if namespace == "" {
+		return id
+	}
+	return namespace + ":" + id
+}
+
+// DokuWiki imports a zip of the data folder of a DokuWiki, or of its
+// pages and media folders. Namespaces become folders, links between the
+// pages are kept and the media that pages show are saved.
+func DokuWiki(r io.ReaderAt, size int64, opts Options) (pages []Page, err error) {
+	entries := make(map[string]zipEntry)
+	if err = readZip(r, size, entries); err != nil {
+		return
+	}
+	entries = trimRoot(entries)
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pagesRoot, mediaRoot, ok := dokuWikiRoots(names)
+	if !ok {
+		err = errors.New("there are no pages in a pages folder")
+		return
+	}
+
+	// every page gets its slug first, so that links can point to any page
+	slugs := newSlugger(opts.Folder)
+	slugOf := make(map[string]string)
+	var ids []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, pagesRoot) || !strings.HasSuffix(name, ".txt") {
+			continue
+		}
+		id := strings.Replace(strings.TrimSuffix(strings.TrimPrefix(name, pagesRoot), ".txt"), "/", ":", -1)
+		parts := strings.Split(strings.Replace(id, "_", " ", -1), ":")
+		slugOf[id] = slugs.slug(parts[:len(parts)-1], parts[len(parts)-1])
+		ids = append(ids, id)
+	}
+
+	saved := make(map[string]string)
+	var saveErr error
+	for _, id := range ids {
+		namespace := ""
+		if i := strings.LastIndex(id, ":"); i >= 0 {
+			namespace = id[:i]
+		}
+		link := func(target string) string {
+			section := ""
+			if i := strings.Index(target, "#"); i >= 0 {
+				target, section = target[:i], target[i+1:]
+			}
+			if strings.TrimSpace(target) == "" {
+				return wikiAnchor(section)
+			}
+			targetID := dokuWikiID(namespace, target)
+			slug, ok := slugOf[targetID]
+			if !ok {
+				slug = wikiSlug(slugs.folder, strings.Split(strings.Replace(targetID, "_", " ", -1), ":"))
+			}
+			return "/" + opts.Domain + "/" + slug + wikiAnchor(section)
+		}
+		media := func(target string) string {
+			mediaID := dokuWikiID(namespace, target)
+			name := mediaRoot + strings.Replace(mediaID, ":", "/", -1)
+			if link, ok := saved[name]; ok {
+				return link
+			}
+			entry, ok := entries[name]
+			if !ok || opts.SaveAttachment == nil {
+				return target
+			}
+			link, errSave := opts.SaveAttachment(path.Base(name), entry.data)
+			if errSave != nil {
+				if saveErr == nil {
+					saveErr = errSave
+				}
+				return target
+			}
+			saved[name] = link
+			return link
+		}
+
+		entry := entries[pagesRoot+strings.Replace(id, ":", "/", -1)+".txt"]
+		body := dokuWikiToMarkdown(string(entry.data), link, media)
+		if saveErr != nil {
+			err = errors.Wrap(saveErr, "saving attachment")
+			return
+		}
+		pages = append(pages, Page{
+			Slug:     slugOf[id],
+			Markdown: pageMarkdown(slugOf[id], "", body),
+			Created:  entry.modified,
+			Modified: entry.modified,
+		})
+	}
+	return
+}
+
+// dokuWikiToMarkdown converts DokuWiki markup into markdown, with link
+// giving the link to a page and media the link to a file
+func dokuWikiToMarkdown(text string, link, media func(target string) string) string {
+	var lines []string
+	var tableRows [][]string
+	closing := ""
+	columns := 0
+	endTable := func() {
+		if tableRows != nil {
+			lines = append(lines, markdownTable(tableRows, columns), "")
+			tableRows, columns = nil, 0
+		}
+	}
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if closing == "" && (strings.HasPrefix(trimmed, "^") || strings.HasPrefix(trimmed, "|")) {
+			// a row of a table, whose cells start with ^ when they are
+			// headers and | otherwise
+			row := dokuWikiRow(dokuWikiInline(trimmed, link, media, " "))
+			if tableRows == nil {
+				lines = append(lines, "")
+			}
+			if len(row) > columns {
+				columns = len(row)
+			}
+			tableRows = append(tableRows, row)
+			continue
+		}
+		endTable()
+		switch {
+		case closing != "":
+			if i := strings.Index(line, closing); i >= 0 {
+				if before := line[:i]; strings.TrimSpace(before) != "" {
+					lines = append(lines, before)
+				}
+				lines = append(lines, "```")
+				closing = ""
+				continue
+			}
+			lines = append(lines, line)
+		case dokuWikiCodeTag.MatchString(trimmed):
+			tag := dokuWikiCodeTag.FindStringSubmatch(trimmed)[1]
+			fence, rest, closed := wikiCode(trimmed, "</"+tag+">")
+			lines = append(lines, fence)
+			if strings.TrimSpace(rest) != "" {
+				lines = append(lines, rest)
+			}
+			if closed {
+				lines = append(lines, "```")
+			} else {
+				closing = "</" + tag + ">"
+			}
+		case dokuWikiHeading.MatchString(trimmed):
+			m := dokuWikiHeading.FindStringSubmatch(trimmed)
+			lines = append(lines, strings.Repeat("#", 7-len(m[1]))+" "+dokuWikiInline(m[2], link, media, " "))
+		case strings.HasPrefix(trimmed, "----"):
+			lines = append(lines, "---")
+		case dokuWikiListItem.MatchString(line):
+			m := dokuWikiListItem.FindStringSubmatch(line)
+			depth := len(strings.Replace(m[1], "\t", "  ", -1)) / 2
+			marker := "*"
+			if m[2] == "-" {
+				marker = "#"
+			}
+			// nested lists are taken to be of the same kind as the list
+			// they are in
+			lines = append(lines, wikiList(strings.Repeat(marker, depth), dokuWikiInline(m[3], link, media, " ")))
+		case strings.HasPrefix(line, "  ") && trimmed != "":
+			// lines indented by two spaces are preformatted
+			lines = append(lines, "    "+line[2:])
+		default:
+			lines = append(lines, dokuWikiInline(line, link, media, "  \n"))
+		}
+	}
+	endTable()
+	if closing != "" {
+		lines = append(lines, "```")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// dokuWikiRow splits a row of a table into its cells
+func dokuWikiRow(line string) (cells []string) {
+	var cell strings.Builder
+	for i, r := range line {
+		if r == '^' || r == '|' {
+			if i > 0 {
+				cells = append(cells, strings.TrimSpace(cell.String()))
+			}
+			cell.Reset()
+			continue
+		}
+		cell.WriteRune(r)
+	}
+	return
+}
+
+// dokuWikiInline converts the markup within a line, where forced line
+// breaks become lineBreak
+func dokuWikiInline(text string, link, media func(target string) string, lineBreak string) string {
+	text = dokuWikiMacro.ReplaceAllString(text, "")
+	text = dokuWikiMonospace.ReplaceAllStringFunc(text, func(s string) string {
+		m := dokuWikiMonospace.FindStringSubmatch(s)
+		return "`" + m[1] + m[2] + m[3] + "`"
+	})
+	text = dokuWikiTags.ReplaceAllStringFunc(text, func(s string) string {
+		var tags []string
+		for _, name := range strings.Fields(dokuWikiTags.FindStringSubmatch(s)[1]) {
+			if tag := wikiTag(strings.Replace(name, "_", " ", -1)); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		return strings.Join(tags, " ")
+	})
+	text = dokuWikiLink.ReplaceAllStringFunc(text, func(s string) string {
+		m := dokuWikiLink.FindStringSubmatch(s)
+		target, label := strings.TrimSpace(m[1]), strings.TrimSpace(m[2])
+		if strings.Contains(target, "://") || strings.HasPrefix(target, "mailto:") {
+			if label == "" {
+				return "<" + target + ">"
+			}
+			return "[" + label + "](" + target + ")"
+		}
+		if strings.Contains(target, ">") {
+			// interwiki links, like [[wp>Wiki]], go to other sites
+			if label == "" {
+				label = target[strings.Index(target, ">")+1:]
+			}
+			return label
+		}
+		if label == "" {
+			label = target
+		}
+		return "[" + label + "](" + link(target) + ")"
+	})
+	text = dokuWikiMedia.ReplaceAllStringFunc(text, func(s string) string {
+		m := dokuWikiMedia.FindStringSubmatch(s)
+		target, caption := m[1], strings.TrimSpace(m[2])
+		if !strings.Contains(target, "://") {
+			target = media(target)
+		}
+		if caption == "" {
+			caption = path.Base(m[1])
+		}
+		if imageExtensions[strings.ToLower(path.Ext(m[1]))] {
+			return "![" + caption + "](" + target + ")"
+		}
+		return "[" + caption + "](" + target + ")"
+	})
+	text = dokuWikiItalic.ReplaceAllString(text, "${1}_${2}_")
+	text = dokuWikiUnderline.ReplaceAllString(text, "_${1}_")
+	return dokuWikiBreak.ReplaceAllString(text, lineBreak)
+}
@@ -0,0 +1,164 @@
+package importer
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Updated   string         `xml:"updated"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+type enexResource struct {
+	Data     string `xml:"data"`
+	Mime     string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+// enexTime parses the timestamps of Evernote, like 20180911T144411Z
+func enexTime(s string) time.Time {
+	t, _ := time.Parse("20060102T150405Z", strings.TrimSpace(s))
+	return t
+}
+
+// ENEX imports the notes of an Evernote export. Attachments are saved
+// and linked where the note embeds them, or after the note otherwise.
+func ENEX(r io.Reader, opts Options) (pages []Page, err error) {
+	slugs := newSlugger(opts.Folder)
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	for {
+		token, errToken := decoder.Token()
+		if errToken == io.EOF {
+			break
+		} else if errToken != nil {
+			err = errors.Wrap(errToken, "reading enex")
+			return
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+		var note enexNote
+		if err = decoder.DecodeElement(&note, &start); err != nil {
+			err = errors.Wrap(err, "reading note")
+			return
+		}
+		var page Page
+		page, err = note.page(slugs, opts)
+		if err != nil {
+			return
+		}
+		pages = append(pages, page)
+	}
+	return
+}
+
+func (note enexNote) page(slugs *slugger, opts Options) (page Page, err error) {
+	// resources are embedded by the md5 hash of their data
+	resources := make(map[string]enexResource)
+	var hashes []string
+	for _, resource := range note.Resources {
+		data, errDecode := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(resource.Data), ""))
+		if errDecode != nil {
+			continue
+		}
+		resource.Data = string(data)
+		hash := fmt.Sprintf("%x", md5.Sum(data))
+		resources[hash] = resource
+		hashes = append(hashes, hash)
+	}
+
+	var saveErr error
+	embedded := make(map[string]string)
+	embed := func(hash string) string {
+		if link, ok := embedded[hash]; ok {
+			return link
+		}
+		resource, ok := resources[hash]
+		if !ok || opts.SaveAttachment == nil {
+			return ""
+		}
+		name := resource.FileName
+		if name == "" {
+			name = "attachment"
+			if exts, _ := mime.ExtensionsByType(resource.Mime); len(exts) > 0 {
+				name += exts[0]
+			}
+		}
+		link, err := opts.SaveAttachment(name, []byte(resource.Data))
+		if err != nil {
+			saveErr = err
+			return ""
+		}
+		if strings.HasPrefix(resource.Mime, "image/") {
+			link = "![" + name + "](" + link + ")"
+		} else {
+			link = "[" + name + "](" + link + ")"
+		}
+		embedded[hash] = link
+		return link
+	}
+
+	root, err := html.Parse(strings.NewReader(note.Content))
+	if err != nil {
+		err = errors.Wrap(err, "parsing note")
+		return
+	}
+	body := htmlToMarkdown{
+		media: func(n *html.Node) string {
+			return embed(attr(n, "hash"))
+		},
+	}.convert(documentBody(root))
+	if saveErr != nil {
+		err = errors.Wrap(saveErr, "saving attachment")
+		return
+	}
+
+	var extra []string
+	for _, hash := range hashes {
+		if _, ok := embedded[hash]; !ok {
+			if link := embed(hash); link != "" {
+				extra = append(extra, link)
+			}
+		}
+	}
+	if saveErr != nil {
+		err = errors.Wrap(saveErr, "saving attachment")
+		return
+	}
+	if len(extra) > 0 {
+		body += "\n\n" + strings.Join(extra, "\n\n")
+	}
+	if len(note.Tags) > 0 {
+		tags := make([]string, len(note.Tags))
+		for i, tag := range note.Tags {
+			tags[i] = "#" + strings.Join(strings.Fields(tag), "-")
+		}
+		body += "\n\n" + strings.Join(tags, " ")
+	}
+
+	title := strings.TrimSpace(note.Title)
+	page.Slug = slugs.slug(nil, title)
+	page.Markdown = pageMarkdown(page.Slug, title, body)
+	page.Created = enexTime(note.Created)
+	page.Modified = enexTime(note.Updated)
+	if page.Modified.IsZero() {
+		page.Modified = page.Created
+	}
+	return
+}
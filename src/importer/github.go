@@ -0,0 +1,180 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxDownloadSize limits the size of a repository or gist
+const maxDownloadSize = 256 << 20
+
+var (
+	// githubAPI is where repositories and gists are downloaded from
+	githubAPI  = "https://api.github.com"
+	httpClient = &http.Client{Timeout: 2 * time.Minute}
+)
+
+func isMarkdown(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// download gets a response from the GitHub API
+func download(link string) (b []byte, err error) {
+	resp, err := httpClient.Get(link)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf("could not get %s: %s", link, resp.Status)
+		return
+	}
+	b, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err == nil && len(b) > maxDownloadSize {
+		err = errors.New("download is too large")
+	}
+	return
+}
+
+// GitHub imports the markdown files of a GitHub repository or gist, like
+// https://github.com/owner/repo, https://github.com/owner/repo/tree/branch/notes
+// or https://gist.github.com/owner/id. The paths of the files are kept
+// as their slugs, so importing again updates the same pages.
+func GitHub(source string, opts Options) (pages []Page, err error) {
+	u, err := url.Parse(strings.TrimSpace(source))
+	if err != nil {
+		return
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch strings.ToLower(u.Host) {
+	case "github.com", "www.github.com":
+		if len(parts) < 2 {
+			return nil, errors.New("not a repository: " + source)
+		}
+		owner, repo, ref, dir := parts[0], strings.TrimSuffix(parts[1], ".git"), "", ""
+		if len(parts) > 3 && parts[2] == "tree" {
+			ref, dir = parts[3], strings.Join(parts[4:], "/")
+		}
+		return githubRepository(owner, repo, ref, dir, opts)
+	case "gist.github.com":
+		if len(parts) == 0 || parts[len(parts)-1] == "" {
+			return nil, errors.New("not a gist: " + source)
+		}
+		return githubGist(parts[len(parts)-1], opts)
+	}
+	return nil, errors.New("not a GitHub repository or gist: " + source)
+}
+
+func githubRepository(owner, repo, ref, dir string, opts Options) (pages []Page, err error) {
+	link := githubAPI + "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) + "/zipball"
+	if ref != "" {
+		link += "/" + url.PathEscape(ref)
+	}
+	b, err := download(link)
+	if err != nil {
+		return
+	}
+	entries := make(map[string]zipEntry)
+	if err = readZip(bytes.NewReader(b), int64(len(b)), entries); err != nil {
+		return
+	}
+	// the zip of a repository is in a folder named after the commit
+	entries = trimRoot(entries)
+
+	var names []string
+	for name := range entries {
+		if isMarkdown(name) && (dir == "" || strings.HasPrefix(name, dir+"/")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	a := newArchive(entries, opts)
+	for _, name := range names {
+		relative := strings.TrimPrefix(strings.TrimPrefix(name, dir), "/")
+		folders := strings.Split(relative, "/")
+		base := folders[len(folders)-1]
+		a.addPage(name, folders[:len(folders)-1], strings.TrimSuffix(base, path.Ext(base)))
+	}
+	for _, name := range names {
+		title, body := splitTitle(string(entries[name].data))
+		body = rewriteMarkdownLinks(body, func(link string) string {
+			return a.resolve(name, link)
+		})
+		if a.err != nil {
+			err = errors.Wrap(a.err, "saving attachment")
+			return
+		}
+		pages = append(pages, Page{
+			Slug:     a.slugs[name],
+			Markdown: pageMarkdown(a.slugs[name], title, body),
+			Modified: entries[name].modified,
+		})
+	}
+	return
+}
+
+type gist struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     map[string]struct {
+		Content   string `json:"content"`
+		Truncated bool   `json:"truncated"`
+		RawURL    string `json:"raw_url"`
+	} `json:"files"`
+}
+
+func githubGist(id string, opts Options) (pages []Page, err error) {
+	b, err := download(githubAPI + "/gists/" + url.PathEscape(id))
+	if err != nil {
+		return
+	}
+	var g gist
+	if err = json.Unmarshal(b, &g); err != nil {
+		return nil, errors.Wrap(err, "reading gist")
+	}
+
+	var names []string
+	for name := range g.Files {
+		if isMarkdown(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	slugs := newSlugger(opts.Folder)
+	for _, name := range names {
+		file := g.Files[name]
+		if file.Truncated {
+			var content []byte
+			content, err = download(file.RawURL)
+			if err != nil {
+				return
+			}
+			file.Content = string(content)
+		}
+		slug := slugs.slug(nil, strings.TrimSuffix(name, path.Ext(name)))
+		title, body := splitTitle(file.Content)
+		pages = append(pages, Page{
+			Slug:     slug,
+			Markdown: pageMarkdown(slug, title, body),
+			Created:  g.CreatedAt,
+			Modified: g.UpdatedAt,
+		})
+	}
+	return
+}
@@ -0,0 +1,103 @@
+// Package importer converts exports from other note taking apps
+// into pages and uploads.
+package importer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// Options decide where imported pages go
+type Options struct {
+	// Domain is the domain the pages are imported into, for links
+	// between the pages
+	Domain string
+	// Folder is put in front of the slugs of the pages, if set
+	Folder string
+	// SaveAttachment saves an image or file that belongs to a page and
+	// returns the link to it
+	SaveAttachment func(name string, data []byte) (link string, err error)
+}
+
+// Page is an imported note as markdown
+type Page struct {
+	Slug     string
+	Markdown string
+	Created  time.Time
+	Modified time.Time
+}
+
+// slugger hands out slugs that are unique within an import
+type slugger struct {
+	folder string
+	used   map[string]bool
+}
+
+func newSlugger(folder string) *slugger {
+	return &slugger{folder: strings.Trim(folder, "/"), used: make(map[string]bool)}
+}
+
+// slug returns the slug for the title nested in the folders
+func (s *slugger) slug(folders []string, title string) string {
+	parts := []string{}
+	if s.folder != "" {
+		parts = append(parts, s.folder)
+	}
+	for _, folder := range append(folders, title) {
+		if part := utils.Slugify(strings.Replace(folder, "/", "-", -1)); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	slug := strings.Join(parts, "/")
+	if slug == "" {
+		slug = "untitled"
+	}
+	unique := slug
+	for i := 2; s.used[unique]; i++ {
+		unique = slug + "-" + strconv.Itoa(i)
+	}
+	s.used[unique] = true
+	return unique
+}
+
+// pageMarkdown puts the title in front of the body, and the slug before
+// that when the title alone would give the page a different slug
+func pageMarkdown(slug, title, body string) string {
+	markdown := body
+	if title != "" {
+		markdown = "# " + title + "\n\n" + body
+	}
+	if utils.Slugify(markdown) != slug {
+		markdown = slug + "\n\n" + markdown
+	}
+	return strings.TrimSpace(markdown) + "\n"
+}
+
+// splitTitle splits markdown that starts with a heading into the
+// heading and the rest
+func splitTitle(markdown string) (title, body string) {
+	markdown = strings.TrimSpace(markdown)
+	if !strings.HasPrefix(markdown, "# ") {
+		return "", markdown
+	}
+	lines := strings.SplitN(markdown, "\n", 2)
+	title = strings.TrimSpace(strings.TrimPrefix(lines[0], "# "))
+	if len(lines) > 1 {
+		body = strings.TrimSpace(lines[1])
+	}
+	return
+}
+
+var markdownLink = regexp.MustCompile(`(!?\[[^\]]*\])\(([^)\s]+)\)`)
+
+// rewriteMarkdownLinks replaces the targets of the links and images
+func rewriteMarkdownLinks(markdown string, resolve func(string) string) string {
+	return markdownLink.ReplaceAllStringFunc(markdown, func(link string) string {
+		parts := markdownLink.FindStringSubmatch(link)
+		return parts[1] + "(" + resolve(parts[2]) + ")"
+	})
+}
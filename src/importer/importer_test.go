@@ -0,0 +1,228 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+func saver(saved map[string]string) func(string, []byte) (string, error) {
+	return func(name string, data []byte) (string, error) {
+		saved[name] = string(data)
+		return "/uploads/" + name, nil
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	root, err := html.Parse(strings.NewReader(`<h2>Plan</h2><div>Some <b>bold</b> and <i>snake_case</i><br/>next</div>
+<ul><li>one<ol><li>a</li><li>b</li></ol></li><li><a href="x.md">two</a></li></ul>
+<blockquote><p>quoted</p><p>twice</p></blockquote><pre>x := 1
+</pre><table><tr><th>a</th><th>b</th></tr><tr><td>1|2</td></tr></table>`))
+	assert.Nil(t, err)
+	markdown := htmlToMarkdown{resolve: strings.ToUpper}.convert(documentBody(root))
+	assert.Equal(t, "## Plan\n\nSome **bold** and _snake\\_case_  \nnext\n\n"+
+		"- one\n  1. a\n  2. b\n- [two](X.MD)\n\n"+
+		"> quoted\n>\n> twice\n\n```\nx := 1\n```\n\n"+
+		"| a | b |\n| --- | --- |\n| 1\\|2 |  |", markdown)
+}
+
+const testENEX = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20180911T144411Z" application="Evernote" version="Evernote Mac 7.5">
+<note><title>Groceries</title><content><![CDATA[<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><div><en-todo checked="true"/>milk</div><div><en-media hash="5d41402abc4b2a76b9719d911017c592" type="image/png"/></div></en-note>]]></content>
+<created>20180910T120000Z</created><updated>20180911T130000Z</updated><tag>shopping list</tag>
+<resource><data encoding="base64">aGVs
+bG8=</data><mime>image/png</mime><resource-attributes><file-name>hello.png</file-name></resource-attributes></resource>
+<resource><data encoding="base64">cGRm</data><mime>application/pdf</mime></resource>
+</note>
+<note><title>Groceries</title><content><![CDATA[<en-note>again</en-note>]]></content></note>
+</en-export>`
+
+func TestENEX(t *testing.T) {
+	saved := make(map[string]string)
+	pages, err := ENEX(strings.NewReader(testENEX), Options{Domain: "notes", SaveAttachment: saver(saved)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pages))
+	assert.Equal(t, "groceries", pages[0].Slug)
+	assert.Equal(t, "# Groceries\n\n[x] milk\n\n![hello.png](/uploads/hello.png)\n\n[attachment.pdf](/uploads/attachment.pdf)\n\n#shopping-list\n", pages[0].Markdown)
+	assert.Equal(t, 2018, pages[0].Modified.Year())
+	assert.Equal(t, "hello", saved["hello.png"])
+	assert.Equal(t, "groceries-2", pages[1].Slug)
+	assert.Equal(t, "groceries-2\n\n# Groceries\n\nagain\n", pages[1].Markdown)
+}
+
+func TestNotion(t *testing.T) {
+	var b bytes.Buffer
+	z := zip.NewWriter(&b)
+	for name, data := range map[string]string{
+		"Export-1/Project 0123456789abcdef0123456789abcdef.md":                                          "# Project\n\nSee [Notes](Project%200123456789abcdef0123456789abcdef/Notes%20fedcba9876543210fedcba9876543210.html) and ![](Project%200123456789abcdef0123456789abcdef/chart.png)",
+		"Export-1/Project 0123456789abcdef0123456789abcdef/Notes fedcba9876543210fedcba9876543210.html": `<html><body><article><header><h1 class="page-title">Notes</h1></header><div class="page-body"><p>Back to <a href="../Project%200123456789abcdef0123456789abcdef.md">project</a></p></div></article></body></html>`,
+		"Export-1/Project 0123456789abcdef0123456789abcdef/chart.png":                                   "png",
+		"Export-1/Tasks 00000000000000000000000000000000.csv":                                           "\xef\xbb\xbfName,Done\nWrite,Yes\n",
+		"Export-1/Tasks 00000000000000000000000000000000_all.csv":                                       "Name,Done,Created\n",
+	} {
+		f, err := z.Create(name)
+		assert.Nil(t, err)
+		f.Write([]byte(data))
+	}
+	assert.Nil(t, z.Close())
+
+	saved := make(map[string]string)
+	pages, err := Notion(bytes.NewReader(b.Bytes()), int64(b.Len()), Options{Domain: "notes", Folder: "notion", SaveAttachment: saver(saved)})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(pages))
+	assert.Equal(t, "notion/project", pages[0].Slug)
+	assert.Equal(t, "notion/project\n\n# Project\n\nSee [Notes](/notes/notion/project/notes) and ![](/uploads/chart.png)\n", pages[0].Markdown)
+	assert.Equal(t, "notion/project/notes", pages[1].Slug)
+	assert.Equal(t, "notion/project/notes\n\n# Notes\n\nBack to [project](/notes/notion/project)\n", pages[1].Markdown)
+	assert.Equal(t, "png", saved["chart.png"])
+	assert.Equal(t, "notion/tasks\n\n# Tasks\n\n| Name | Done |\n| --- | --- |\n| Write | Yes |\n", pages[2].Markdown)
+}
+
+func TestGitHub(t *testing.T) {
+	var repo bytes.Buffer
+	z := zip.NewWriter(&repo)
+	for name, data := range map[string]string{
+		"owner-notes-1a2b3c/README.md":              "# Readme\n\nIgnored",
+		"owner-notes-1a2b3c/notes/Ideas.md":         "# Ideas\n\nSee [setup](setup/install.md) and ![](../img/a.png)",
+		"owner-notes-1a2b3c/notes/setup/install.md": "Run it",
+		"owner-notes-1a2b3c/img/a.png":              "png",
+	} {
+		f, err := z.Create(name)
+		assert.Nil(t, err)
+		f.Write([]byte(data))
+	}
+	assert.Nil(t, z.Close())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/notes/zipball/main":
+			w.Write(repo.Bytes())
+		case "/gists/abc":
+			w.Write([]byte(`{"files":{"todo.md":{"content":"# Todo\n\n- one"},"run.sh":{"content":"ls"}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	githubAPI = server.URL
+
+	saved := make(map[string]string)
+	pages, err := GitHub("https://github.com/owner/notes/tree/main/notes", Options{Domain: "d", SaveAttachment: saver(saved)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pages))
+	assert.Equal(t, "ideas", pages[0].Slug)
+	assert.Equal(t, "# Ideas\n\nSee [setup](/d/setup/install) and ![](/uploads/a.png)\n", pages[0].Markdown)
+	assert.Equal(t, "setup/install\n\nRun it\n", pages[1].Markdown)
+
+	pages, err = GitHub("https://gist.github.com/owner/abc", Options{Domain: "d", Folder: "gists"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pages))
+	assert.Equal(t, "gists/todo\n\n# Todo\n\n- one\n", pages[0].Markdown)
+
+	_, err = GitHub("https://example.com/owner/notes", Options{})
+	assert.NotNil(t, err)
+}
+
+func TestClip(t *testing.T) {
+	page, _ := url.Parse("https://example.com/blog/post")
+	document := []byte(`<html><head><title>A post | Blog</title><meta property="og:title" content="A post"></head><body>
+<nav><a href="/">Home</a> <a href="/about">About</a></nav>
+<div class="content"><h1>A post</h1>
+<p>The first paragraph of the post is long enough, with commas, to count as content.</p>
+<p>The second one links to <a href="other">another post</a> and has an <img src="/img/a.png" alt="image">.</p></div>
+<div class="sidebar"><p>Subscribe to the newsletter, it is great, really, you will love it.</p></div>
+<script>var x = 1;</script></body></html>`)
+	title, markdown, err := Clip(page, document, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "A post", title)
+	assert.Equal(t, "The first paragraph of the post is long enough, with commas, to count as content.\n\n"+
+		"The second one links to [another post](https://example.com/blog/other) and has an ![image](https://example.com/img/a.png).", markdown)
+
+	_, markdown, err = Clip(page, nil, "<b>just</b> this")
+	assert.Nil(t, err)
+	assert.Equal(t, "**just** this", markdown)
+}
+
+func TestOPML(t *testing.T) {
+	pages, err := OPML(strings.NewReader(`<?xml version="1.0"?>
+<opml version="2.0"><head><title>Plans</title></head><body>
+<outline text="Garden" _note="Water&#10;daily" created="Tue, 11 Sep 2018 14:44:11 +0000">
+  <outline text="Tomatoes"/>
+  <outline text="Blog" type="link" url="https://example.com"/>
+</outline>
+<outline text="Garden"/>
+</body></opml>`), Options{Folder: "plans"})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(pages))
+	assert.Equal(t, "plans/garden", pages[0].Slug)
+	assert.Equal(t, "plans/garden\n\n# Garden\n\nWater\ndaily\n", pages[0].Markdown)
+	assert.Equal(t, 2018, pages[0].Created.Year())
+	assert.Equal(t, "plans/garden/tomatoes", pages[1].Slug)
+	assert.Equal(t, "plans/garden/blog\n\n# Blog\n\n<https://example.com>\n", pages[2].Markdown)
+	assert.Equal(t, "plans/garden-2", pages[3].Slug)
+}
+
+func TestMediaWiki(t *testing.T) {
+	pages, err := MediaWiki(strings.NewReader(`<mediawiki><page><title>Garden plan</title><ns>0</ns><revision><timestamp>2018-09-11T14:44:11Z</timestamp><text>
+== Beds ==
+'''Tomatoes''' go in [[garden_plan/North bed|the north]] and see [[Old plan#Beds]] or [https://example.com the shop].
+* water
+*# daily
+ x := 1
+{| class="wikitable"
+! Plant !! Month
+|-
+| Bean || May
+|}
+[[Category:Outdoor work]]</text></revision></page>
+<page><title>Garden plan/North bed</title><ns>0</ns><revision><text>''sunny''</text></revision></page>
+<page><title>Old plan</title><ns>0</ns><redirect title="Garden plan" /><revision><text>#REDIRECT [[Garden plan]]</text></revision></page>
+<page><title>Talk:Garden plan</title><ns>1</ns><revision><text>hi</text></revision></page>
+</mediawiki>`), Options{Domain: "notes"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pages))
+	assert.Equal(t, "garden-plan", pages[0].Slug)
+	assert.Equal(t, 2018, pages[0].Modified.Year())
+	assert.Equal(t, "# Garden plan\n\n## Beds\n"+
+		"**Tomatoes** go in [the north](/notes/garden-plan/north-bed) and see [Old plan#Beds](/notes/garden-plan#beds) or [the shop](https://example.com).\n"+
+		"- water\n  1. daily\n    x := 1\n\n| Plant | Month |\n| --- | --- |\n| Bean | May |\n\n#outdoor-work\n", pages[0].Markdown)
+	assert.Equal(t, "garden-plan/north-bed\n\n# North bed\n\n_sunny_\n", pages[1].Markdown)
+}
+
+func TestDokuWiki(t *testing.T) {
+	var b bytes.Buffer
+	z := zip.NewWriter(&b)
+	for name, data := range map[string]string{
+		"data/pages/start.txt":       "====== Welcome ======\n**Hi** //there// see [[wiki:syntax|the syntax]] and [[https://example.com]]\\\\ next\n  * one\n    * two\n{{wiki:dot.png?20|A dot}} {{tag>home_page}}\n^ a ^ b ^\n| [[start#welcome]] | ''x'' |",
+		"data/pages/wiki/syntax.txt": "<code go>\nx := 1\n</code>\nBack to [[:start]] or [[other]]",
+		"data/media/wiki/dot.png":    "png",
+		"data/meta/start.meta":       "",
+		"data/attic/start.1.txt.gz":  "",
+	} {
+		f, err := z.Create(name)
+		assert.Nil(t, err)
+		f.Write([]byte(data))
+	}
+	assert.Nil(t, z.Close())
+	assert.True(t, IsDokuWiki(bytes.NewReader(b.Bytes()), int64(b.Len())))
+
+	saved := make(map[string]string)
+	pages, err := DokuWiki(bytes.NewReader(b.Bytes()), int64(b.Len()), Options{Domain: "notes", Folder: "wiki", SaveAttachment: saver(saved)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pages))
+	assert.Equal(t, "wiki/start", pages[0].Slug)
+	assert.Equal(t, "wiki/start\n\n# Welcome\n**Hi** _there_ see [the syntax](/notes/wiki/wiki/syntax) and <https://example.com>  \nnext\n"+
+		"- one\n  - two\n![A dot](/uploads/dot.png) #home-page\n\n| a | b |\n| --- | --- |\n| [start#welcome](/notes/wiki/start#welcome) | `x` |\n", pages[0].Markdown)
+	assert.Equal(t, "wiki/wiki/syntax\n\n```go\nx := 1\n```\nBack to [:start](/notes/wiki/start) or [other](/notes/wiki/wiki/other)\n", pages[1].Markdown)
+	assert.Equal(t, "png", saved["dot.png"])
+}
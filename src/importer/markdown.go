@@ -0,0 +1,316 @@
+package importer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	spaces        = regexp.MustCompile(`\s+`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+	markdownChars = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`)
+)
+
+// htmlToMarkdown converts the HTML of notes into markdown
+type htmlToMarkdown struct {
+	// resolve rewrites the targets of links and images
+	resolve func(link string) string
+	// media converts the elements that embed attachments in Evernote
+	media func(n *html.Node) string
+}
+
+func (m htmlToMarkdown) convert(n *html.Node) string {
+	markdown := blankLines.ReplaceAllString(m.blocks(n), "\n\n")
+	return strings.TrimSpace(markdown)
+}
+
+func (m htmlToMarkdown) link(link string) string {
+	if m.resolve == nil {
+		return link
+	}
+	return m.resolve(link)
+}
+
+func isBlock(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch n.Data {
+	case "div":
+		// checkboxes of to-do lists in Notion
+		return !strings.Contains(attr(n, "class"), "checkbox")
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "blockquote", "ul", "ol",
+		"hr", "table", "section", "article", "header", "main", "figure", "figcaption",
+		"details", "summary", "en-note", "body", "dl", "dt", "dd":
+		return true
+	}
+	return false
+}
+
+// blocks converts the children of n, with a blank line between blocks
+func (m htmlToMarkdown) blocks(n *html.Node) string {
+	return m.joinBlocks(n, "\n\n")
+}
+
+func (m htmlToMarkdown) joinBlocks(n *html.Node, separator string) string {
+	var blocks []string
+	var inline strings.Builder
+	flush := func() {
+		if text := strings.TrimSpace(inline.String()); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isBlock(c) {
+			flush()
+			if block := m.block(c); strings.TrimSpace(block) != "" {
+				blocks = append(blocks, block)
+			}
+			continue
+		}
+		inline.WriteString(m.inline(c))
+	}
+	flush()
+	return strings.Join(blocks, separator)
+}
+
+func (m htmlToMarkdown) block(n *html.Node) string {
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		return strings.Repeat("#", level) + " " + strings.TrimSpace(m.inlineChildren(n))
+	case "pre":
+		return "```\n" + strings.TrimRight(textContent(n), "\n") + "\n```"
+	case "blockquote":
+		return prefixLines(m.blocks(n), "> ", "> ")
+	case "ul", "ol":
+		var items []string
+		number := 1
+		for li := n.FirstChild; li != nil; li = li.NextSibling {
+			if li.Type != html.ElementNode || li.Data != "li" {
+				continue
+			}
+			marker := "- "
+			if n.Data == "ol" {
+				marker = strconv.Itoa(number) + ". "
+				number++
+			}
+			// items without paragraphs are kept tight
+			separator := "\n"
+			if len(findElements(li, "p")) > 0 {
+				separator = "\n\n"
+			}
+			items = append(items, prefixLines(m.joinBlocks(li, separator), marker, strings.Repeat(" ", len(marker))))
+		}
+		return strings.Join(items, "\n")
+	case "hr":
+		return "---"
+	case "table":
+		return m.table(n)
+	}
+	return m.blocks(n)
+}
+
+func (m htmlToMarkdown) table(n *html.Node) string {
+	var rows [][]string
+	columns := 0
+	for _, tr := range findElements(n, "tr") {
+		var row []string
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+				cell := strings.TrimSpace(spaces.ReplaceAllString(m.inlineChildren(c), " "))
+				row = append(row, strings.Replace(cell, "|", `\|`, -1))
+			}
+		}
+		if len(row) > columns {
+			columns = len(row)
+		}
+		rows = append(rows, row)
+	}
+	return markdownTable(rows, columns)
+}
+
+// markdownTable writes rows as a table with the first row as its header
+func markdownTable(rows [][]string, columns int) string {
+	if len(rows) == 0 || columns == 0 {
+		return ""
+	}
+	var lines []string
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m htmlToMarkdown) inlineChildren(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isBlock(c) {
+			b.WriteString(" " + m.blocks(c) + " ")
+			continue
+		}
+		b.WriteString(m.inline(c))
+	}
+	return b.String()
+}
+
+// wrap puts the markers around the text, outside of its spaces
+func wrap(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := text[:strings.Index(text, trimmed)]
+	end := text[len(start)+len(trimmed):]
+	return start + marker + trimmed + marker + end
+}
+
+func (m htmlToMarkdown) inline(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return markdownChars.Replace(spaces.ReplaceAllString(n.Data, " "))
+	} else if n.Type != html.ElementNode {
+		return ""
+	}
+
+	switch n.Data {
+	case "script", "style", "head", "title", "meta", "link":
+		return ""
+	case "strong", "b":
+		return wrap(m.inlineChildren(n), "**")
+	case "em", "i":
+		return wrap(m.inlineChildren(n), "_")
+	case "s", "del", "strike":
+		return wrap(m.inlineChildren(n), "~~")
+	case "code", "kbd":
+		return "`" + textContent(n) + "`"
+	case "br":
+		return "  \n"
+	case "img":
+		return "![" + attr(n, "alt") + "](" + m.link(attr(n, "src")) + ")"
+	case "a":
+		href := attr(n, "href")
+		text := strings.TrimSpace(m.inlineChildren(n))
+		if href == "" {
+			return text
+		}
+		href = m.link(href)
+		if text == "" {
+			text = href
+		}
+		return "[" + text + "](" + href + ")"
+	case "en-media":
+		if m.media != nil {
+			return m.media(n)
+		}
+		return ""
+	case "en-todo":
+		if attr(n, "checked") == "true" {
+			return "[x] " + m.inlineChildren(n)
+		}
+		return "[ ] " + m.inlineChildren(n)
+	case "input":
+		if attr(n, "type") != "checkbox" {
+			return ""
+		}
+		if _, checked := hasAttr(n, "checked"); checked {
+			return "[x] "
+		}
+		return "[ ] "
+	case "div":
+		// checkboxes of to-do lists in Notion
+		if strings.Contains(attr(n, "class"), "checkbox-on") {
+			return "[x] "
+		}
+		return "[ ] "
+	}
+	return m.inlineChildren(n)
+}
+
+// prefixLines puts first in front of the first line and rest in front
+// of the others
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if lines[i] == "" && i > 0 {
+			lines[i] = strings.TrimRight(prefix, " ")
+			continue
+		}
+		lines[i] = prefix + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func attr(n *html.Node, key string) string {
+	val, _ := hasAttr(n, key)
+	return val
+}
+
+func hasAttr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// findElements returns every element with the tag beneath n
+func findElements(n *html.Node, tag string) (found []*html.Node) {
+	if n.Type == html.ElementNode && n.Data == tag {
+		found = append(found, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		found = append(found, findElements(c, tag)...)
+	}
+	return
+}
+
+// documentBody returns the body of a parsed document
+func documentBody(root *html.Node) *html.Node {
+	if bodies := findElements(root, "body"); len(bodies) > 0 {
+		return bodies[0]
+	}
+	return root
+}
+
+// findClass returns the first element beneath n with the class
+func findClass(n *html.Node, class string) *html.Node {
+	if n.Type == html.ElementNode {
+		for _, c := range strings.Fields(attr(n, "class")) {
+			if c == class {
+				return n
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findClass(c, class); found != nil {
+			return found
+		}
+	}
+	return nil
+}
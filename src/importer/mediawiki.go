@@ -0,0 +1,307 @@
+package importer
+
+import (
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+type mediaWikiPage struct {
+	Title    string `xml:"title"`
+	NS       int    `xml:"ns"`
+	Redirect *struct {
+		Title string `xml:"title,attr"`
+	} `xml:"redirect"`
+	Revisions []struct {
+		Timestamp string `xml:"timestamp"`
+		Text      string `xml:"text"`
+	} `xml:"revision"`
+}
+
+var (
+	mediaWikiHeading  = regexp.MustCompile(`^(={1,6})\s*(.+?)\s*={1,6}\s*$`)
+	mediaWikiListItem = regexp.MustCompile(`^([*#:;]+)\s*(.*)$`)
+	mediaWikiCode     = regexp.MustCompile(`<(code|tt|nowiki)>(.*?)</(?:code|tt|nowiki)>`)
+	mediaWikiLink     = regexp.MustCompile(`\[\[([^\]|]+)(?:\|([^\]]*))?\]\](\p{L}*)`)
+	mediaWikiExternal = regexp.MustCompile(`\[((?:https?|ftp)://[^\s\]]+|mailto:[^\s\]]+)(?:\s+([^\]]+))?\]`)
+	mediaWikiEmphasis = []struct {
+		pattern *regexp.Regexp
+		replace string
+	}{
+		{regexp.MustCompile(`'''''(.+?)'''''`), "***$1***"},
+		{regexp.MustCompile(`'''(.+?)'''`), "**$1**"},
+		{regexp.MustCompile(`''(.+?)''`), "_${1}_"},
+	}
+	mediaWikiRedirect = regexp.MustCompile(`(?i)^#redirect\s*\[\[([^\]|]+)`)
+	mediaWikiCodeTag  = regexp.MustCompile(`^<(pre|syntaxhighlight|source)\b`)
+)
+
+// mediaWikiTitle is the title of a page as MediaWiki compares them, with
+// spaces instead of underscores and a capital first letter
+func mediaWikiTitle(title string) string {
+	title = strings.Join(strings.Fields(strings.Replace(title, "_", " ", -1)), " ")
+	title = strings.TrimPrefix(title, ":")
+	if title == "" {
+		return ""
+	}
+	r, size := utf8.DecodeRuneInString(title)
+	return string(unicode.ToUpper(r)) + title[size:]
+}
+
+// MediaWiki imports the pages of an XML dump of a MediaWiki, like those
+// of Special:Export, from their latest revision. The subpages of a page
+// are nested in it, links between the pages are kept and the categories
+// of a page become its tags. Redirects are followed by the links to them
+// instead of being imported, and so are pages outside of the main
+// namespace.
+func MediaWiki(r io.Reader, opts Options) (pages []Page, err error) {
+	var wikiPages []mediaWikiPage
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	for {
+		token, errToken := decoder.Token()
+		if errToken == io.EOF {
+			break
+		} else if errToken != nil {
+			err = errors.Wrap(errToken, "reading mediawiki")
+			return
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "page" {
+			continue
+		}
+		var page mediaWikiPage
+		if err = decoder.DecodeElement(&page, &start); err != nil {
+			err = errors.Wrap(err, "reading page")
+			return
+		}
+		if page.NS == 0 && len(page.Revisions) > 0 {
+			wikiPages = append(wikiPages, page)
+		}
+	}
+
+	// every page gets its slug first, so that links can point to any page
+	slugs := newSlugger(opts.Folder)
+	slugOf := make(map[string]string)
+	redirects := make(map[string]string)
+	for _, page := range wikiPages {
+		title := mediaWikiTitle(page.Title)
+		text := page.Revisions[len(page.Revisions)-1].Text
+		if page.Redirect != nil {
+			redirects[title] = mediaWikiTitle(page.Redirect.Title)
+			continue
+		} else if m := mediaWikiRedirect.FindStringSubmatch(text); m != nil {
+			redirects[title] = mediaWikiTitle(m[1])
+			continue
+		}
+		parts := strings.Split(title, "/")
+		slugOf[title] = slugs.slug(parts[:len(parts)-1], parts[len(parts)-1])
+	}
+	link := func(target string) string {
+		section := ""
+		if i := strings.Index(target, "#"); i >= 0 {
+			target, section = target[:i], target[i+1:]
+		}
+		title := mediaWikiTitle(target)
+		for i := 0; i < 5 && redirects[title] != ""; i++ {
+			title = redirects[title]
+		}
+		slug, ok := slugOf[title]
+		if !ok {
+			slug = wikiSlug(slugs.folder, strings.Split(title, "/"))
+		}
+		return "/" + opts.Domain + "/" + slug + wikiAnchor(section)
+	}
+
+	for _, page := range wikiPages {
+		title := mediaWikiTitle(page.Title)
+		slug, ok := slugOf[title]
+		if !ok {
+			continue
+		}
+		revision := page.Revisions[len(page.Revisions)-1]
+		modified, _ := time.Parse(time.RFC3339, strings.TrimSpace(revision.Timestamp))
+		parts := strings.Split(title, "/")
+		pages = append(pages, Page{
+			Slug:     slug,
+			Markdown: pageMarkdown(slug, parts[len(parts)-1], mediaWikiToMarkdown(revision.Text, link)),
+			Modified: modified,
+		})
+	}
+	return
+}
+
+// mediaWikiToMarkdown converts wikitext into markdown, with link giving
+// the link to a page from its title
+func mediaWikiToMarkdown(text string, link func(title string) string) string {
+	var lines, tags, table []string
+	closing := ""
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case closing != "":
+			// in a block of code
+			if i := strings.Index(line, closing); i >= 0 {
+				if before := line[:i]; strings.TrimSpace(before) != "" {
+					lines = append(lines, before)
+				}
+				lines = append(lines, "```")
+				closing = ""
+				continue
+			}
+			lines = append(lines, line)
+		case table != nil:
+			table = append(table, line)
+			if strings.HasPrefix(trimmed, "|}") {
+				lines = append(lines, "", mediaWikiTable(table, link, &tags), "")
+				table = nil
+			}
+		case strings.HasPrefix(trimmed, "{|"):
+			table = []string{}
+		case mediaWikiCodeTag.MatchString(trimmed):
+			tag := mediaWikiCodeTag.FindStringSubmatch(trimmed)[1]
+			fence, rest, closed := wikiCode(trimmed, "</"+tag+">")
+			lines = append(lines, fence)
+			if strings.TrimSpace(rest) != "" {
+				lines = append(lines, rest)
+			}
+			if closed {
+				lines = append(lines, "```")
+			} else {
+				closing = "</" + tag + ">"
+			}
+		case mediaWikiHeading.MatchString(trimmed):
+			m := mediaWikiHeading.FindStringSubmatch(trimmed)
+			lines = append(lines, strings.Repeat("#", len(m[1]))+" "+mediaWikiInline(m[2], link, &tags))
+		case strings.HasPrefix(trimmed, "----"):
+			lines = append(lines, "---")
+		case mediaWikiListItem.MatchString(line):
+			m := mediaWikiListItem.FindStringSubmatch(line)
+			item := mediaWikiInline(m[2], link, &tags)
+			switch m[1][len(m[1])-1] {
+			case ':':
+				lines = append(lines, strings.Repeat("> ", len(m[1]))+item)
+			case ';':
+				term, definition := item, ""
+				if i := strings.Index(item, " : "); i >= 0 {
+					term, definition = item[:i], item[i+3:]
+				}
+				lines = append(lines, "**"+strings.TrimSpace(term)+"**", "")
+				if definition != "" {
+					lines = append(lines, strings.TrimSpace(definition), "")
+				}
+			default:
+				lines = append(lines, wikiList(m[1], item))
+			}
+		case strings.HasPrefix(line, " ") && trimmed != "":
+			// lines that start with a space are preformatted
+			lines = append(lines, "    "+line[1:])
+		default:
+			lines = append(lines, mediaWikiInline(line, link, &tags))
+		}
+	}
+	if closing != "" {
+		lines = append(lines, "```")
+	}
+	if table != nil {
+		lines = append(lines, "", mediaWikiTable(table, link, &tags))
+	}
+	markdown := strings.TrimSpace(strings.Join(lines, "\n"))
+	if len(tags) > 0 {
+		markdown += "\n\n" + strings.Join(tags, " ")
+	}
+	return markdown
+}
+
+// mediaWikiInline converts the markup within a line, adding the
+// categories that it puts the page in to the tags
+func mediaWikiInline(text string, link func(title string) string, tags *[]string) string {
+	text = mediaWikiCode.ReplaceAllString(text, "`$2`")
+	text = mediaWikiLink.ReplaceAllStringFunc(text, func(s string) string {
+		m := mediaWikiLink.FindStringSubmatch(s)
+		target, label := strings.TrimSpace(m[1]), m[2]
+		if i := strings.Index(target, ":"); i > 0 {
+			switch strings.ToLower(strings.TrimSpace(target[:i])) {
+			case "category":
+				if tag := wikiTag(target[i+1:]); tag != "" {
+					*tags = append(*tags, tag)
+				}
+				return ""
+			case "file", "image":
+				name := strings.TrimSpace(target[i+1:])
+				caption := name
+				if options := strings.Split(label, "|"); label != "" {
+					caption = options[len(options)-1]
+				}
+				return "![" + caption + "](" + name + ")"
+			}
+		}
+		if label == "" {
+			label = strings.TrimPrefix(target, ":")
+		}
+		return "[" + label + m[3] + "](" + link(target) + ")"
+	})
+	text = mediaWikiExternal.ReplaceAllStringFunc(text, func(s string) string {
+		m := mediaWikiExternal.FindStringSubmatch(s)
+		if m[2] == "" {
+			return "<" + m[1] + ">"
+		}
+		return "[" + m[2] + "](" + m[1] + ")"
+	})
+	for _, emphasis := range mediaWikiEmphasis {
+		text = emphasis.pattern.ReplaceAllString(text, emphasis.replace)
+	}
+	return text
+}
+
+// mediaWikiTable converts the lines of a table, from the line after
+// the {| that starts it
+func mediaWikiTable(lines []string, link func(title string) string, tags *[]string) string {
+	var rows [][]string
+	var row []string
+	columns := 0
+	endRow := func() {
+		if len(row) > 0 {
+			if len(row) > columns {
+				columns = len(row)
+			}
+			rows = append(rows, row)
+		}
+		row = nil
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "|}"):
+			endRow()
+		case strings.HasPrefix(line, "|-"):
+			endRow()
+		case strings.HasPrefix(line, "|+"):
+			// captions are left out
+		case strings.HasPrefix(line, "!") || strings.HasPrefix(line, "|"):
+			separator := "||"
+			if line[0] == '!' {
+				separator = "!!"
+			}
+			for _, cell := range strings.Split(line[1:], separator) {
+				// the attributes of a cell come before a single |
+				if i := strings.Index(cell, "|"); i >= 0 && strings.Contains(cell[:i], "=") && !strings.Contains(cell[:i], "[[") {
+					cell = cell[i+1:]
+				}
+				cell = mediaWikiInline(strings.TrimSpace(cell), link, tags)
+				row = append(row, strings.Replace(cell, "|", `\|`, -1))
+			}
+		case len(row) > 0:
+			// a cell goes on over more lines
+			row[len(row)-1] += " " + mediaWikiInline(line, link, tags)
+		}
+	}
+	endRow()
+	return markdownTable(rows, columns)
+}
@@ -0,0 +1,136 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// notionID is the id that Notion adds to the names of pages
+var notionID = regexp.MustCompile(`\s+[0-9a-fA-F]{32}$`)
+
+// notionTitle returns the title of a page from its file or folder name
+func notionTitle(name string) string {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	return strings.TrimSpace(notionID.ReplaceAllString(name, ""))
+}
+
+func isNotionPage(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".html":
+		return true
+	case ".csv":
+		// databases are exported twice, once with every property
+		return !strings.HasSuffix(name, "_all.csv")
+	}
+	return false
+}
+
+// Notion imports a zip of a Notion workspace exported as markdown or as
+// HTML. Pages are nested in folders like they are in Notion, links
+// between them are kept and the files they link to are saved.
+func Notion(r io.ReaderAt, size int64, opts Options) (pages []Page, err error) {
+	entries := make(map[string]zipEntry)
+	if err = readZip(r, size, entries); err != nil {
+		return
+	}
+	entries = trimRoot(entries)
+
+	var names []string
+	for name := range entries {
+		if isNotionPage(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// every page gets its slug first, so that links can point to any page
+	a := newArchive(entries, opts)
+	for _, name := range names {
+		var folders []string
+		if dir := path.Dir(name); dir != "." {
+			for _, folder := range strings.Split(dir, "/") {
+				folders = append(folders, notionTitle(folder))
+			}
+		}
+		a.addPage(name, folders, notionTitle(path.Base(name)))
+	}
+
+	for _, name := range names {
+		entry := entries[name]
+		resolve := func(link string) string {
+			return a.resolve(name, link)
+		}
+
+		title, body := notionTitle(path.Base(name)), ""
+		switch strings.ToLower(path.Ext(name)) {
+		case ".md":
+			if heading, rest := splitTitle(string(entry.data)); heading != "" {
+				title, body = heading, rest
+			} else {
+				body = rest
+			}
+			body = rewriteMarkdownLinks(body, resolve)
+		case ".html":
+			root, errParse := html.Parse(bytes.NewReader(entry.data))
+			if errParse != nil {
+				err = errors.Wrap(errParse, "parsing "+name)
+				return
+			}
+			if heading := findClass(root, "page-title"); heading != nil {
+				title = strings.TrimSpace(textContent(heading))
+			}
+			content := findClass(root, "page-body")
+			if content == nil {
+				content = documentBody(root)
+			}
+			body = htmlToMarkdown{resolve: resolve}.convert(content)
+		case ".csv":
+			body, err = csvToMarkdown(entry.data)
+			if err != nil {
+				err = errors.Wrap(err, "reading "+name)
+				return
+			}
+		}
+		if a.err != nil {
+			err = errors.Wrap(a.err, "saving attachment")
+			return
+		}
+
+		pages = append(pages, Page{
+			Slug:     a.slugs[name],
+			Markdown: pageMarkdown(a.slugs[name], title, body),
+			Created:  entry.modified,
+			Modified: entry.modified,
+		})
+	}
+	return
+}
+
+// csvToMarkdown converts an exported database into a table
+func csvToMarkdown(data []byte) (markdown string, err error) {
+	// Notion starts its csv files with a byte order mark
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return
+	}
+	columns := 0
+	for i, record := range records {
+		for j := range record {
+			records[i][j] = strings.Replace(strings.Join(strings.Fields(record[j]), " "), "|", `\|`, -1)
+		}
+		if len(record) > columns {
+			columns = len(record)
+		}
+	}
+	return markdownTable(records, columns), nil
+}
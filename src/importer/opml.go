@@ -0,0 +1,73 @@
+package importer
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr"`
+	Note     string        `xml:"_note,attr"`
+	URL      string        `xml:"url,attr"`
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	Created  string        `xml:"created,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlDocument struct {
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+// opmlTime parses the RFC 822 dates of OPML
+func opmlTime(s string) time.Time {
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822} {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// OPML imports an outline, like those of outliners and feed readers, as
+// a page for every entry nested beneath the page of the entry it is in.
+// The notes of the entries are the text of their pages.
+func OPML(r io.Reader, opts Options) (pages []Page, err error) {
+	var doc opmlDocument
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	if err = decoder.Decode(&doc); err != nil {
+		err = errors.Wrap(err, "reading opml")
+		return
+	}
+	slugs := newSlugger(opts.Folder)
+	var add func(outlines []opmlOutline, folders []string)
+	add = func(outlines []opmlOutline, folders []string) {
+		for _, outline := range outlines {
+			title := strings.TrimSpace(outline.Text)
+			if title == "" {
+				title = strings.TrimSpace(outline.Title)
+			}
+			slug := slugs.slug(folders, title)
+			body := strings.TrimSpace(outline.Note)
+			for _, link := range []string{outline.URL, outline.HTMLURL, outline.XMLURL} {
+				if link = strings.TrimSpace(link); link != "" && !strings.Contains(body, link) {
+					body = strings.TrimSpace(body + "\n\n<" + link + ">")
+				}
+			}
+			pages = append(pages, Page{
+				Slug:     slug,
+				Markdown: pageMarkdown(slug, title, body),
+				Created:  opmlTime(outline.Created),
+			})
+			add(outline.Outlines, strings.Split(strings.TrimPrefix(slug, slugs.folder+"/"), "/"))
+		}
+	}
+	add(doc.Outlines, nil)
+	return
+}
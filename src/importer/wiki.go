@@ -0,0 +1,78 @@
+package importer
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// wikiCodeLanguage is the language of a code block, from the lang="go"
+// of MediaWiki or the <code go> of DokuWiki
+var wikiCodeLanguage = regexp.MustCompile(`^<(?:code|file|pre|source|syntaxhighlight)(?:\s+lang="?([\w+#-]+)"?|\s+([\w+#-]+))?`)
+
+// wikiList converts the item of a list whose markers are those of the
+// lists it is nested in, from the outermost, where ordered lists have #
+func wikiList(markers, text string) string {
+	indent := ""
+	for _, marker := range markers[:len(markers)-1] {
+		if marker == '#' {
+			indent += "   "
+		} else {
+			indent += "  "
+		}
+	}
+	if markers[len(markers)-1] == '#' {
+		return indent + "1. " + text
+	}
+	return indent + "- " + text
+}
+
+// wikiCode starts a fenced code block from the tag that opens it, and
+// returns the text after the tag and whether the block ends on the line
+func wikiCode(line, closing string) (fence, rest string, closed bool) {
+	language := ""
+	if m := wikiCodeLanguage.FindStringSubmatch(line); m != nil {
+		language = m[1] + m[2]
+	}
+	fence = "```" + strings.ToLower(language)
+	if i := strings.Index(line, ">"); i >= 0 {
+		rest = line[i+1:]
+	}
+	if i := strings.Index(rest, closing); i >= 0 {
+		rest, closed = rest[:i], true
+	}
+	return
+}
+
+// wikiTag turns the name of a category into a tag
+func wikiTag(name string) string {
+	tag := utils.Slugify(strings.Replace(name, "/", "-", -1))
+	if tag == "" {
+		return ""
+	}
+	return "#" + tag
+}
+
+// wikiSlug is the slug that a page that was not imported would have,
+// from the names of its folders and its own
+func wikiSlug(folder string, parts []string) string {
+	var slugs []string
+	if folder != "" {
+		slugs = append(slugs, folder)
+	}
+	for _, part := range parts {
+		if slug := utils.Slugify(strings.Replace(part, "/", "-", -1)); slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+	return strings.Join(slugs, "/")
+}
+
+// wikiAnchor is the anchor of a heading of a page
+func wikiAnchor(section string) string {
+	if section = utils.HeadingAnchor(section); section == "" {
+		return ""
+	}
+	return "#" + section
+}
@@ -0,0 +1,88 @@
+// Package llm asks a chat model of an OpenAI-compatible API, like
+// OpenAI's or a local model served by Ollama or llama.cpp.
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Message is a message of a chat, from the "system", the "user" or the
+// "assistant"
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Client asks the chat model of an API
+type Client struct {
+	// URL is where the API is, like https://api.openai.com/v1 or
+	// http://localhost:11434/v1, without /chat/completions
+	URL string
+	// Model is the model that answers
+	Model string
+	// Key is sent as a bearer token, if there is one
+	Key  string
+	HTTP *http.Client
+}
+
+// New returns a client of the API at the URL
+func New(url, model, key string) *Client {
+	return &Client{
+		URL:   strings.TrimSuffix(url, "/"),
+		Model: model,
+		Key:   key,
+		HTTP:  &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+type request struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+}
+
+type response struct {
+	Choices []struct {
+		Message Message `json:"message"`
+	} `json:"choices"`
+}
+
+// Chat returns the reply of the model to the messages
+func (c *Client) Chat(messages []Message) (reply string, err error) {
+	body, err := json.Marshal(request{Model: c.Model, Messages: messages})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", c.URL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("llm: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var r response
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return
+	}
+	if len(r.Choices) == 0 {
+		return "", errors.New("llm: response has no reply")
+	}
+	return strings.TrimSpace(r.Choices[0].Message.Content), nil
+}
@@ -0,0 +1,33 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "", r.Header.Get("Authorization"))
+		var req request
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "small", req.Model)
+		assert.Equal(t, []Message{{Role: "user", Content: "hi"}}, req.Messages)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":" hello [1]\n"}}]}`))
+	}))
+	defer server.Close()
+
+	reply, err := New(server.URL+"/v1", "small", "").Chat([]Message{{Role: "user", Content: "hi"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello [1]", reply)
+
+	_, err = New(server.URL+"/missing", "small", "").Chat(nil)
+	assert.NotNil(t, err)
+}
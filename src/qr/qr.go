@@ -0,0 +1,482 @@
+// Package qr makes QR codes of links, to open them on a phone by
+// pointing its camera at the screen.
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Code is a QR code, a square of modules that are dark or light. Codes
+// are made with the medium level of error correction, which still reads
+// with 15% of it smudged.
+type Code struct {
+	Size     int
+	dark     []bool
+	function []bool
+}
+
+// the error correction codewords of each block and the number of blocks,
+// by version, at the medium level
+var (
+	eccPerBlock = []int{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28}
+	eccBlocks   = []int{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49}
+)
+
+// ErrTooLong is returned for text that does not fit in a QR code
+var ErrTooLong = errors.New("the text is too long for a QR code")
+
+// Encode makes the smallest QR code that holds the text
+func Encode(text string) (c *Code, err error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if 4+countBits(v)+8*len(data) <= 8*dataCodewords(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	// the text is in byte mode, followed by a terminator and padding
+	var bits bitBuffer
+	bits.append(4, 4)
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := 8 * dataCodewords(version)
+	if terminator := capacity - len(bits); terminator < 4 {
+		bits.append(0, terminator)
+	} else {
+		bits.append(0, 4)
+	}
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	size := 4*version + 17
+	c = &Code{Size: size, dark: make([]bool, size*size), function: make([]bool, size*size)}
+	c.drawFunctionPatterns(version)
+	c.drawCodewords(addECC(version, bits.bytes()))
+
+	// the mask that leaves the fewest patterns that confuse readers
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if penalty := c.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+	return
+}
+
+// Dark returns whether the module at x, y is dark
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.dark[y*c.Size+x]
+}
+
+// Image draws the code with modules of scale pixels, within the light
+// border of four modules that readers need
+func (c *Code) Image(scale int) image.Image {
+	const border = 4
+	width := (c.Size + 2*border) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			if c.Dark(x/scale-border, y/scale-border) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// PNG returns a PNG of the QR code of the text, with modules of scale
+// pixels
+func PNG(text string, scale int) (data []byte, err error) {
+	c, err := Encode(text)
+	if err != nil {
+		return
+	}
+	var b bytes.Buffer
+	err = png.Encode(&b, c.Image(scale))
+	return b.Bytes(), err
+}
+
+// countBits is the length of the count of bytes, by version
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// rawDataModules are the modules of a version that are left for data
+// and error correction once the patterns are drawn
+func rawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// dataCodewords are the bytes of data that a version holds
+func dataCodewords(version int) int {
+	return rawDataModules(version)/8 - eccPerBlock[version]*eccBlocks[version]
+}
+
+// addECC splits the data into blocks, adds the error correction of each
+// and interleaves the blocks
+func addECC(version int, data []byte) (result []byte) {
+	numBlocks, blockECC := eccBlocks[version], eccPerBlock[version]
+	rawCodewords := rawDataModules(version) / 8
+	numShort := numBlocks - rawCodewords%numBlocks
+	shortLen := rawCodewords / numBlocks
+	divisor := rsDivisor(blockECC)
+
+	var blocks [][]byte
+	k := 0
+	for i := 0; i < numBlocks; i++ {
+		n := shortLen - blockECC
+		if i >= numShort {
+			n++
+		}
+		block := append([]byte{}, data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			// short blocks are padded, to interleave them with the long
+			block = append(block, 0)
+		}
+		blocks = append(blocks, append(block, ecc...))
+	}
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-blockECC || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.dark[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+// drawFunctionPatterns draws the patterns that readers find and align the
+// code by, and reserves the places of the format
+func (c *Code) drawFunctionPatterns(version int) {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners have finders
+			if !(i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0) {
+				c.drawAlignment(x, y)
+			}
+		}
+	}
+	c.drawFormat(0)
+	c.drawVersion(version)
+}
+
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			dist := max(abs(dx), abs(dy))
+			if xx, yy := x+dx, y+dy; xx >= 0 && yy >= 0 && xx < c.Size && yy < c.Size {
+				c.set(xx, yy, dist != 2 && dist != 4)
+			}
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions are the rows and columns of the centers of the
+// alignment patterns of a version
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*4 + numAlign*2 + 1) / (numAlign*2 - 2) * 2
+	if version == 32 {
+		step = 26
+	}
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i, pos := numAlign-1, 4*version+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// drawFormat draws both copies of the level of error correction and the
+// mask, and the module that is always dark
+func (c *Code) drawFormat(mask int) {
+	// the medium level is 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(bits, i))
+	}
+	c.set(8, 7, bit(bits, 6))
+	c.set(8, 8, bit(bits, 7))
+	c.set(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(bits, i))
+	}
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(bits, i))
+	}
+	c.set(8, c.Size-8, true)
+}
+
+// drawVersion draws both copies of the version, which codes from
+// version 7 have
+func (c *Code) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := version<<12 | rem
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		c.set(a, b, bit(bits, i))
+		c.set(b, a, bit(bits, i))
+	}
+}
+
+// drawCodewords fills the modules that are left, in columns two wide
+// that zigzag up and down from the bottom right
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// the column of the vertical timing pattern is skipped
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.function[y*c.Size+x] && i < len(data)*8 {
+					c.dark[y*c.Size+x] = bit(int(data[i>>3]), 7-i&7)
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the modules of data that the mask selects, so applying
+// it twice undoes it
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !c.function[y*c.Size+x] {
+				c.dark[y*c.Size+x] = !c.dark[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// penalty scores the runs, boxes and finder-like patterns of the code and
+// how far it is from half dark, which make it harder to read
+func (c *Code) penalty() (penalty int) {
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	dark := 0
+	for a := 0; a < c.Size; a++ {
+		for _, line := range []func(int) bool{
+			func(b int) bool { return c.Dark(b, a) },
+			func(b int) bool { return c.Dark(a, b) },
+		} {
+			run := 1
+			for b := 1; b <= c.Size; b++ {
+				if b < c.Size && line(b) == line(b-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			for b := 0; b+11 <= c.Size; b++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, d := range pattern {
+						if line(b+k) != d {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						penalty += 40
+					}
+				}
+			}
+		}
+		for b := 0; b < c.Size; b++ {
+			if c.Dark(b, a) {
+				dark++
+			}
+			if a+1 < c.Size && b+1 < c.Size {
+				d := c.Dark(b, a)
+				if c.Dark(b+1, a) == d && c.Dark(b, a+1) == d && c.Dark(b+1, a+1) == d {
+					penalty += 3
+				}
+			}
+		}
+	}
+	percent := dark * 100 / (c.Size * c.Size)
+	return penalty + 10*(abs(percent-50)/5)
+}
+
+// rsDivisor is the generator polynomial of Reed-Solomon error correction
+// of the degree, highest term first and without its leading 1
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = rsMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = rsMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder is the error correction of the data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= rsMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// rsMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func rsMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// bitBuffer is a sequence of bits
+type bitBuffer []bool
+
+// append appends the low n bits of the value, highest first
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, bit(value, i))
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, set := range b {
+		if set {
+			result[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
+
+func bit(x, i int) bool {
+	return x>>uint(i)&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
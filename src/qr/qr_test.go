@@ -0,0 +1,137 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// the data and error correction of HELLO WORLD at version 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	assert.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, rsRemainder(data, rsDivisor(10)))
+}
+
+func TestFormatAndVersion(t *testing.T) {
+	c := &Code{Size: 45, dark: make([]bool, 45*45), function: make([]bool, 45*45)}
+	c.drawFormat(5)
+	var format []byte
+	for i := 0; i < 15; i++ {
+		if c.Dark(c.Size-1-i, 8) && i < 8 || i >= 8 && c.Dark(8, c.Size-15+i) {
+			format = append([]byte{'1'}, format...)
+		} else {
+			format = append([]byte{'0'}, format...)
+		}
+	}
+	assert.Equal(t, "100000011001110", string(format))
+
+	c.drawVersion(7)
+	var version []byte
+	for i := 0; i < 18; i++ {
+		if c.Dark(c.Size-11+i%3, i/3) {
+			version = append([]byte{'1'}, version...)
+		} else {
+			version = append([]byte{'0'}, version...)
+		}
+	}
+	assert.Equal(t, "000111110010010100", string(version))
+	assert.Equal(t, []int{6, 22, 38}, alignmentPositions(7))
+	assert.Equal(t, []int{6, 34, 60, 86, 112, 138}, alignmentPositions(32))
+}
+
+// read reads the text of a code back, checking its error correction
+func read(t *testing.T, c *Code) string {
+	version := (c.Size - 17) / 4
+	var bits int
+	for i := 0; i < 15; i++ {
+		if i < 8 && c.Dark(c.Size-1-i, 8) || i >= 8 && c.Dark(8, c.Size-15+i) {
+			bits |= 1 << uint(i)
+		}
+	}
+	mask := (bits ^ 0x5412) >> 10 & 7
+	c.applyMask(mask)
+	defer c.applyMask(mask)
+
+	raw := make([]byte, rawDataModules(version)/8)
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.function[y*c.Size+x] && i < len(raw)*8 {
+					if c.Dark(x, y) {
+						raw[i>>3] |= 0x80 >> uint(i&7)
+					}
+					i++
+				}
+			}
+		}
+	}
+
+	numBlocks, blockECC := eccBlocks[version], eccPerBlock[version]
+	numShort := numBlocks - len(raw)%numBlocks
+	shortLen := len(raw) / numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortLen; i++ {
+		for j := range blocks {
+			if i != shortLen-blockECC || j >= numShort {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		n := len(block) - blockECC
+		assert.Equal(t, block[n:], rsRemainder(block[:n], rsDivisor(blockECC)))
+		data = append(data, block[:n]...)
+	}
+
+	assert.Equal(t, byte(4), data[0]>>4)
+	var b bitBuffer
+	for _, d := range data {
+		b.append(int(d), 8)
+	}
+	length := 0
+	for _, set := range b[4 : 4+countBits(version)] {
+		length <<= 1
+		if set {
+			length |= 1
+		}
+	}
+	return string(b[4+countBits(version) : 4+countBits(version)+8*length].bytes())
+}
+
+func TestEncode(t *testing.T) {
+	for _, text := range []string{
+		"HELLO WORLD",
+		"https://rwtxt.com/public/notes",
+		"https://rwtxt.com/work/a1b2c3d4e5?expires=1792765195&sig=k3K9PEI1n1u6KuSG1PGGNN7dfo-HN6Dn-gSzNW-6J_k",
+		strings.Repeat("ü", 300),
+	} {
+		c, err := Encode(text)
+		assert.Nil(t, err)
+		assert.Equal(t, text, read(t, c))
+	}
+	c, _ := Encode("HELLO WORLD")
+	assert.Equal(t, 21, c.Size)
+
+	_, err := Encode(strings.Repeat("x", 3000))
+	assert.Equal(t, ErrTooLong, err)
+
+	data, err := PNG("https://rwtxt.com/public/notes", 4)
+	assert.Nil(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, (29+8)*4, img.Bounds().Dx())
+}
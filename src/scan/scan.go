@@ -0,0 +1,156 @@
+// Package scan checks uploaded files for viruses and other malware, with
+// the clamd daemon of ClamAV or with a command.
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scanner checks files for malware
+type Scanner interface {
+	// Scan returns the name of the malware found in the file, or "" if
+	// it is clean. Files that can not be scanned return an error.
+	Scan(name string, data []byte) (threat string, err error)
+}
+
+// chunkSize is how much of a file is sent to clamd at a time
+const chunkSize = 64 << 10
+
+// Clamd scans files with clamd, which is sent them over its socket
+type Clamd struct {
+	// Address is the unix socket of clamd, like /run/clamav/clamd.ctl,
+	// or its host:port
+	Address string
+	Timeout time.Duration
+}
+
+// Scan sends the file to clamd with INSTREAM. Files larger than the
+// StreamMaxLength of clamd can not be scanned.
+func (c Clamd) Scan(name string, data []byte) (threat string, err error) {
+	network := "tcp"
+	if strings.HasPrefix(c.Address, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, c.Address, c.Timeout)
+	if err != nil {
+		err = errors.Wrap(err, "could not connect to clamd")
+		return
+	}
+	defer conn.Close()
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		err = errors.Wrap(err, "could not send to clamd")
+		return
+	}
+	size := make([]byte, 4)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		data = data[len(chunk):]
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+		if _, err = conn.Write(append(size, chunk...)); err != nil {
+			// clamd closes the connection when the file is too large,
+			// and says so
+			break
+		}
+	}
+	if err == nil {
+		_, err = conn.Write([]byte{0, 0, 0, 0})
+	}
+
+	reply, errRead := ioutil.ReadAll(conn)
+	if errRead != nil && len(reply) == 0 {
+		if err == nil {
+			err = errRead
+		}
+		err = errors.Wrap(err, "no reply from clamd")
+		return
+	}
+	return parseReply(string(reply))
+}
+
+// parseReply reads the reply of clamd to INSTREAM, which is
+// "stream: OK", "stream: <name> FOUND" or "<why> ERROR"
+func parseReply(reply string) (threat string, err error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+	case strings.HasSuffix(reply, " FOUND"):
+		threat = strings.TrimSuffix(reply, " FOUND")
+	case strings.HasSuffix(reply, " ERROR"):
+		err = errors.New("clamd: " + strings.TrimSuffix(reply, " ERROR"))
+	default:
+		err = errors.New("clamd replied " + reply)
+	}
+	return
+}
+
+// Command scans files with a command, like "clamdscan --no-summary -",
+// which is given the file on stdin. {name} in the arguments is replaced
+// with the name of the file. Like the scanners of ClamAV, it exits with 0
+// if the file is clean and 1 if it found malware, whose name is the last
+// line that it wrote. Anything else is an error.
+type Command struct {
+	Command []string
+	Timeout time.Duration
+}
+
+// Scan runs the command on the file
+func (c Command) Scan(name string, data []byte) (threat string, err error) {
+	if len(c.Command) == 0 {
+		err = errors.New("no scan command")
+		return
+	}
+	args := make([]string, len(c.Command)-1)
+	for i, arg := range c.Command[1:] {
+		args[i] = strings.Replace(arg, "{name}", name, -1)
+	}
+
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, c.Command[0], args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.New("scan timed out after " + c.Timeout.String())
+		return
+	}
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == 1 {
+		err = nil
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		threat = strings.TrimSuffix(strings.TrimSpace(lines[len(lines)-1]), " FOUND")
+		if i := strings.LastIndex(threat, ": "); i >= 0 {
+			// like "stdin: Eicar-Signature"
+			threat = threat[i+2:]
+		}
+		if threat == "" {
+			threat = "malware"
+		}
+	} else if err != nil {
+		err = errors.Wrap(err, "scan: "+strings.TrimSpace(stderr.String()))
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClamd answers INSTREAM like clamd, finding files with "EICAR" in them
+func fakeClamd(t *testing.T) (address string, stop func()) {
+	dir, err := ioutil.TempDir("", "clamd")
+	assert.Nil(t, err)
+	address = filepath.Join(dir, "clamd.ctl")
+	l, err := net.Listen("unix", address)
+	assert.Nil(t, err)
+	stop = func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			command := make([]byte, len("zINSTREAM\x00"))
+			io.ReadFull(conn, command)
+			var data []byte
+			size := make([]byte, 4)
+			for {
+				io.ReadFull(conn, size)
+				n := binary.BigEndian.Uint32(size)
+				if n == 0 {
+					break
+				}
+				chunk := make([]byte, n)
+				io.ReadFull(conn, chunk)
+				data = append(data, chunk...)
+			}
+			if bytes.Contains(data, []byte("EICAR")) {
+				conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+	return
+}
+
+func TestClamd(t *testing.T) {
+	address, stop := fakeClamd(t)
+	defer stop()
+	c := Clamd{Address: address, Timeout: 5 * time.Second}
+	threat, err := c.Scan("clean.txt", bytes.Repeat([]byte("hello "), 50000))
+	assert.Nil(t, err)
+	assert.Equal(t, "", threat)
+
+	threat, err = c.Scan("virus.com", []byte("X5O!P%@AP EICAR test file"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Eicar-Signature", threat)
+
+	_, err = Clamd{Address: "/nonexistent/clamd.ctl"}.Scan("a.txt", []byte("a"))
+	assert.NotNil(t, err)
+}
+
+func TestParseReply(t *testing.T) {
+	_, err := parseReply("INSTREAM size limit exceeded. ERROR\x00")
+	assert.NotNil(t, err)
+	_, err = parseReply("UNKNOWN COMMAND\x00")
+	assert.NotNil(t, err)
+}
+
+func TestCommand(t *testing.T) {
+	c := Command{Command: []string{"sh", "-c", `if grep -q EICAR; then echo "stdin: Eicar-Signature FOUND"; exit 1; fi`, "{name}"}}
+	threat, err := c.Scan("clean.txt", []byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", threat)
+
+	threat, err = c.Scan("virus.com", []byte("EICAR"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Eicar-Signature", threat)
+
+	_, err = Command{Command: []string{"sh", "-c", "exit 2"}}.Scan("a.txt", []byte("a"))
+	assert.NotNil(t, err)
+
+	_, err = Command{Command: []string{"sleep", "5"}, Timeout: 100 * time.Millisecond}.Scan("a.txt", nil)
+	assert.NotNil(t, err)
+}
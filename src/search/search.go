@@ -0,0 +1,242 @@
+// Package search parses what is typed into the search box, like
+//
+//	"meeting notes" title:budget tag:work after:2024-01 -draft
+//
+// into the words and phrases to find in the text of pages and the
+// fields that the pages must have.
+package search
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed search. A page matches when it has all of its
+// words, phrases, titles and tags, none of its excluded words, and was
+// modified between After and Before.
+type Query struct {
+	// Words are found in the text. A word ending in * matches the words
+	// that start with it.
+	Words []string
+	// Phrases are found in the text as they are, from "quoted phrases"
+	Phrases []string
+	// Exclude are the words of -word and phrases of -"a phrase" that the
+	// text must not have
+	Exclude []string
+	// Titles are found in the title, from title:word or title:"a phrase"
+	Titles []string
+	// Tags are the tags of tag:name or tag:#name, in lowercase
+	Tags []string
+	// Domains are searched instead of the current one, from domain:name
+	Domains []string
+	// After and Before are the times that the pages were modified
+	// after and before, if they are not zero. after:2024-05-01 is from
+	// the day after, and before:2024-05 is until the month starts.
+	After, Before time.Time
+}
+
+// The ways dates can be written, from the most precise
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+	yearLayout  = "2006"
+)
+
+// Parse parses the search. Operators that are not known, or whose value
+// can not be read, are searched for as words.
+func Parse(text string) (q Query) {
+	for _, token := range tokenize(text) {
+		switch token.field {
+		case "title":
+			if value := strings.ToLower(strings.TrimSpace(token.value)); value != "" {
+				q.Titles = append(q.Titles, value)
+			}
+			continue
+		case "tag":
+			if value := strings.ToLower(strings.TrimPrefix(token.value, "#")); value != "" {
+				q.Tags = append(q.Tags, value)
+			}
+			continue
+		case "domain":
+			if value := strings.ToLower(strings.TrimSpace(token.value)); value != "" {
+				q.Domains = append(q.Domains, value)
+			}
+			continue
+		case "before":
+			if start, _, ok := parseDate(token.value); ok {
+				if q.Before.IsZero() || start.Before(q.Before) {
+					q.Before = start
+				}
+				continue
+			}
+		case "after":
+			if _, end, ok := parseDate(token.value); ok {
+				if end.After(q.After) {
+					q.After = end
+				}
+				continue
+			}
+		}
+
+		pieces := words(token.raw)
+		if token.quoted {
+			pieces = words(token.value)
+		} else if strings.HasSuffix(token.raw, "*") && len(pieces) > 0 {
+			pieces[len(pieces)-1] += "*"
+		}
+		switch {
+		case len(pieces) == 0:
+		case token.exclude:
+			q.Exclude = append(q.Exclude, strings.Join(pieces, " "))
+		case token.quoted:
+			q.Phrases = append(q.Phrases, strings.Join(pieces, " "))
+		case len(pieces) == 1:
+			q.Words = append(q.Words, pieces[0])
+		default:
+			// like don't, which the full text index has as two words
+			q.Phrases = append(q.Phrases, strings.Join(pieces, " "))
+		}
+	}
+	return
+}
+
+// parseDate reads a day, month or year, returning when it starts and
+// when the next one starts
+func parseDate(value string) (start, end time.Time, ok bool) {
+	for _, layout := range []string{dayLayout, monthLayout, yearLayout} {
+		if len(value) != len(layout) {
+			continue
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		switch layout {
+		case dayLayout:
+			return t, t.AddDate(0, 0, 1), true
+		case monthLayout:
+			return t, t.AddDate(0, 1, 0), true
+		default:
+			return t, t.AddDate(1, 0, 0), true
+		}
+	}
+	return
+}
+
+// words splits text into the words that the full text index has, which
+// are letters and numbers in lowercase, so that none is read as an
+// operator like OR
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Match returns the full text search of the words, phrases and excluded
+// words for SQLite, or "" if the query has no words or phrases, which
+// full text search needs
+func (q Query) Match() string {
+	terms := append([]string{}, q.Words...)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, `"`+phrase+`"`)
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	for _, word := range q.Exclude {
+		if strings.Contains(word, " ") {
+			word = `"` + word + `"`
+		}
+		terms = append(terms, "-"+word)
+	}
+	return strings.Join(terms, " ")
+}
+
+// String writes the query as it would be typed, without its domains
+func (q Query) String() string {
+	parts := append([]string{}, q.Words...)
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `"`+phrase+`"`)
+	}
+	for _, word := range q.Exclude {
+		if strings.Contains(word, " ") {
+			word = `"` + word + `"`
+		}
+		parts = append(parts, "-"+word)
+	}
+	for _, title := range q.Titles {
+		if strings.ContainsAny(title, " \t") {
+			title = `"` + title + `"`
+		}
+		parts = append(parts, "title:"+title)
+	}
+	for _, tag := range q.Tags {
+		parts = append(parts, "tag:"+tag)
+	}
+	if !q.After.IsZero() {
+		parts = append(parts, "after:"+q.After.AddDate(0, 0, -1).Format(dayLayout))
+	}
+	if !q.Before.IsZero() {
+		parts = append(parts, "before:"+q.Before.Format(dayLayout))
+	}
+	return strings.Join(parts, " ")
+}
+
+// token is a word, a "quoted phrase" or a field:value of a search, or a
+// -word or -"phrase" to exclude
+type token struct {
+	raw     string
+	field   string
+	value   string
+	quoted  bool
+	exclude bool
+}
+
+// tokenize splits the search at spaces that are not in quotes
+func tokenize(text string) (tokens []token) {
+	runes := []rune(strings.TrimSpace(text))
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		var t token
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			t.exclude = true
+			i++
+		}
+		start := i
+		// a field name is letters followed by a colon
+		j := i
+		for j < len(runes) && unicode.IsLetter(runes[j]) {
+			j++
+		}
+		if !t.exclude && j > i && j < len(runes) && runes[j] == ':' {
+			t.field = strings.ToLower(string(runes[i:j]))
+			i = j + 1
+		}
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			t.value = string(runes[i+1 : end])
+			t.quoted = true
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			t.value = string(runes[i:end])
+			i = end
+		}
+		if i > len(runes) {
+			i = len(runes)
+		}
+		t.raw = string(runes[start:i])
+		tokens = append(tokens, t)
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	q := Parse(`budget "Meeting  notes" title:"Q3 plan" tag:#Work -draft plan* don't after:2024-01-31 before:2024-06 domain:Home`)
+	assert.Equal(t, []string{"budget", "plan*"}, q.Words)
+	assert.Equal(t, []string{"meeting notes", "don t"}, q.Phrases)
+	assert.Equal(t, []string{"draft"}, q.Exclude)
+	assert.Equal(t, []string{"q3 plan"}, q.Titles)
+	assert.Equal(t, []string{"work"}, q.Tags)
+	assert.Equal(t, []string{"home"}, q.Domains)
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), q.After)
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), q.Before)
+	assert.Equal(t, `budget plan* "meeting notes" "don t" -draft`, q.Match())
+	assert.Equal(t, `budget plan* "meeting notes" "don t" -draft title:"q3 plan" tag:work after:2024-01-31 before:2024-06-01`, q.String())
+}
+
+func TestParseOperators(t *testing.T) {
+	// operators can not be smuggled into the full text search
+	q := Parse(`a OR b NEAR c" "x`)
+	assert.Equal(t, `a or b near c "x"`, q.Match())
+	q = Parse(`(a) -"b c" after:never http://example.com`)
+	assert.Equal(t, []string{"a"}, q.Words)
+	assert.Equal(t, []string{"b c"}, q.Exclude)
+	assert.Equal(t, []string{"after never", "http example com"}, q.Phrases)
+	assert.Equal(t, `a "after never" "http example com" -"b c"`, q.Match())
+	assert.True(t, q.After.IsZero())
+
+	q = Parse("tag:todo after:2023")
+	assert.Equal(t, "", q.Match())
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), q.After)
+}
@@ -4,28 +4,127 @@ import (
 	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/hex"
+	"errors"
+	"html"
 	"html/template"
+	"math"
 	"math/rand"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/microcosm-cc/bluemonday"
 	"golang.org/x/crypto/bcrypt"
 	blackfriday "gopkg.in/russross/blackfriday.v2"
 )
 
+// The markdown extensions that are always used. Strict markdown is
+// rendered with only these, close to plain CommonMark.
+const strictExtensions = blackfriday.SpaceHeadings |
+	blackfriday.NoIntraEmphasis |
+	blackfriday.FencedCode |
+	blackfriday.AutoHeadingIDs
+
+// The extensions of GitHub flavored markdown and more, which are used
+// unless the markdown is strict
+const extendedExtensions = strictExtensions |
+	blackfriday.Autolink |
+	blackfriday.Strikethrough |
+	blackfriday.BackslashLineBreak |
+	blackfriday.Tables |
+	blackfriday.Footnotes |
+	blackfriday.DefinitionLists
+
+// taskListItem is an item of a list that starts with [ ] or [x]
+var taskListItem = regexp.MustCompile(`<li>(<p>)?\[([ xX])\]\s`)
+
+// uploadLink is a rendered link to an upload, with its filename
+var uploadLink = regexp.MustCompile(`<a href="(/uploads/sha256-[0-9a-f]+\?filename=([^"&]+))"[^>]*>[^<]*</a>`)
+
+// audioTypes are the MIME types of the audio files that are played on
+// pages, by their extension
+var audioTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".weba": "audio/webm",
+}
+
+// AudioType returns the MIME type of the file if it is audio, or ""
+func AudioType(filename string) string {
+	return audioTypes[strings.ToLower(path.Ext(filename))]
+}
+
+var (
+	youTubeID   = regexp.MustCompile(`^[\w-]{11}$`)
+	vimeoID     = regexp.MustCompile(`^/(\d+)/?$`)
+	peerTubeID  = regexp.MustCompile(`^/(?:w|videos/watch)/([\w-]+)/?$`)
+	youTubePath = regexp.MustCompile(`^/(?:embed|shorts|live)/([\w-]+)/?$`)
+)
+
+// VideoEmbed returns the address that embeds the video of a YouTube,
+// Vimeo or PeerTube link in a page, without the cookies that track
+// viewers where the site allows it, or "" if it is not a video link.
+// Only the PeerTube instances given, by their host, are embedded.
+func VideoEmbed(link string, peerTube map[string]bool) (embed string) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	switch host {
+	case "youtube.com", "m.youtube.com", "youtube-nocookie.com":
+		id := u.Query().Get("v")
+		if match := youTubePath.FindStringSubmatch(u.Path); match != nil {
+			id = match[1]
+		}
+		if youTubeID.MatchString(id) {
+			return "https://www.youtube-nocookie.com/embed/" + id
+		}
+	case "youtu.be":
+		if id := strings.Trim(u.Path, "/"); youTubeID.MatchString(id) {
+			return "https://www.youtube-nocookie.com/embed/" + id
+		}
+	case "vimeo.com", "player.vimeo.com":
+		path := strings.TrimPrefix(u.Path, "/video")
+		if match := vimeoID.FindStringSubmatch(path); match != nil {
+			return "https://player.vimeo.com/video/" + match[1] + "?dnt=1"
+		}
+	default:
+		if !peerTube[strings.ToLower(u.Host)] {
+			return ""
+		}
+		if match := peerTubeID.FindStringSubmatch(u.Path); match != nil && u.RawQuery == "" {
+			return "https://" + u.Host + "/videos/embed/" + match[1]
+		}
+	}
+	return ""
+}
+
+// RenderMarkdownToHTML renders the markdown with all the extensions
 func RenderMarkdownToHTML(markdown string) template.HTML {
-	html := string(blackfriday.Run([]byte(markdown),
-		blackfriday.WithExtensions(
-			blackfriday.Autolink|
-				blackfriday.Strikethrough|
-				blackfriday.SpaceHeadings|
-				blackfriday.BackslashLineBreak|
-				blackfriday.NoIntraEmphasis|
-				blackfriday.Tables|
-				blackfriday.FencedCode|
-				blackfriday.AutoHeadingIDs|
-				blackfriday.Footnotes),
-	))
+	return RenderMarkdown(markdown, false)
+}
+
+// RenderMarkdown renders the markdown as sanitized HTML. Unless it is
+// strict, it has tables, footnotes, definition lists, strikethrough,
+// task lists and links made of bare addresses. Links to uploaded audio
+// are shown as players.
+func RenderMarkdown(markdown string, strict bool) template.HTML {
+	extensions := extendedExtensions
+	if strict {
+		extensions = strictExtensions
+	}
+	html := string(blackfriday.Run([]byte(markdown), blackfriday.WithExtensions(extensions)))
 
 	p := bluemonday.UGCPolicy()
 	p.AllowAttrs("href").OnElements("a")
@@ -35,9 +134,664 @@ func RenderMarkdownToHTML(markdown string) template.HTML {
 	p.AllowElements("p")
 	html = p.Sanitize(html)
 
+	if !strict {
+		// the checkboxes are added after sanitizing, which removes inputs
+		html = taskListItem.ReplaceAllStringFunc(html, func(item string) string {
+			match := taskListItem.FindStringSubmatch(item)
+			checked := ""
+			if match[2] != " " {
+				checked = " checked"
+			}
+			return `<li class="task">` + match[1] + `<input type="checkbox" disabled` + checked + `> `
+		})
+	}
+
+	// the players are added after sanitizing too, keeping the link for
+	// browsers that can not play the file
+	html = uploadLink.ReplaceAllStringFunc(html, func(link string) string {
+		match := uploadLink.FindStringSubmatch(link)
+		if name, err := url.QueryUnescape(match[2]); err != nil || AudioType(name) == "" {
+			return link
+		}
+		return `<audio controls preload="none" src="` + match[1] + `">` + link + `</audio>`
+	})
+
 	return template.HTML(html)
 }
 
+var (
+	slugSpaces     = regexp.MustCompile(`\s+`)
+	slugNonWord    = regexp.MustCompile(`[^\w\-/]+`)
+	slugDashes     = regexp.MustCompile(`\-\-+`)
+	slugSeparators = regexp.MustCompile(`-*/[-/]*`)
+	slugStart      = regexp.MustCompile(`^[-/]+`)
+	slugEnd        = regexp.MustCompile(`[-/]+$`)
+)
+
+// Slugify returns the slug of the first line of text that makes a slug,
+// after the front matter, following the same rules as the slugify in
+// rwtxt.js
+func Slugify(text string) string {
+	_, text = FrontMatter(text)
+	for _, line := range strings.Split(text, "\n") {
+		slug := strings.ToLower(line)
+		slug = slugSpaces.ReplaceAllString(slug, "-")
+		slug = slugNonWord.ReplaceAllString(slug, "")
+		slug = slugDashes.ReplaceAllString(slug, "-")
+		slug = slugSeparators.ReplaceAllString(slug, "/")
+		slug = slugStart.ReplaceAllString(slug, "")
+		slug = slugEnd.ReplaceAllString(slug, "")
+		if len(slug) > 1 {
+			return slug
+		}
+	}
+	return ""
+}
+
+var frontMatterLine = regexp.MustCompile(`^[\w-]+:`)
+
+// FrontMatter splits the markdown into its front matter and the rest.
+// Front matter is at the very top between two "---" lines, and each of
+// its lines is like "key: value".
+func FrontMatter(markdown string) (front map[string][]string, body string) {
+	lines := strings.Split(strings.Replace(markdown, "\r\n", "\n", -1), "\n")
+	if len(lines) < 3 || strings.TrimSpace(lines[0]) != "---" {
+		return nil, markdown
+	}
+	front = make(map[string][]string)
+	for i, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "---" && i > 0 {
+			return front, strings.Join(lines[i+2:], "\n")
+		}
+		if !frontMatterLine.MatchString(line) {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		key := strings.ToLower(parts[0])
+		front[key] = append(front[key], strings.TrimSpace(parts[1]))
+	}
+	return nil, markdown
+}
+
+// Title returns the text of the first heading of the markdown, or
+// an empty string if it has no heading
+func Title(markdown string) string {
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		// a #hashtag is not a heading
+		if level, title := headingLevel(line); level > 0 && title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+// headingLevel returns the level and text of a heading line, or 0 if
+// the line is not a heading
+func headingLevel(line string) (level int, text string) {
+	line = strings.TrimSpace(line)
+	text = strings.TrimLeft(line, "#")
+	level = len(line) - len(text)
+	if level == 0 || level > 6 || (text != "" && text[0] != ' ' && text[0] != '\t') {
+		return 0, ""
+	}
+	return level, strings.TrimSpace(strings.TrimRight(strings.TrimSpace(text), "#"))
+}
+
+// HeadingAnchor is the id that a heading gets when it is rendered
+func HeadingAnchor(text string) string {
+	var anchor []rune
+	dash := false
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if dash && len(anchor) > 0 {
+				anchor = append(anchor, '-')
+			}
+			dash = false
+			anchor = append(anchor, unicode.ToLower(r))
+		} else {
+			dash = true
+		}
+	}
+	return string(anchor)
+}
+
+// Section returns the part of the markdown from the heading with the
+// text or the anchor up to the next heading that is not beneath it
+func Section(markdown string, heading string) (section string, found bool) {
+	var lines []string
+	level := 0
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		} else if l, text := headingLevel(line); l > 0 && !inCode {
+			if level > 0 && l <= level {
+				break
+			}
+			if level == 0 && (strings.EqualFold(text, heading) || HeadingAnchor(text) == strings.ToLower(heading)) {
+				level = l
+			}
+		}
+		if level > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), level > 0
+}
+
+var hashtag = regexp.MustCompile(`(?:^|[\s(])#([\p{L}\p{N}_][\p{L}\p{N}_\-/]*)`)
+
+// Tags returns the #hashtags of the markdown in lowercase, without
+// the ones in code blocks
+func Tags(markdown string) (tags []string) {
+	seen := make(map[string]bool)
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		for _, match := range hashtag.FindAllStringSubmatch(line, -1) {
+			tag := strings.ToLower(strings.TrimRight(match[1], "-/"))
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return
+}
+
+var (
+	markdownImage    = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^)\s>]+)`)
+	markdownImages   = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownEmphasis = regexp.MustCompile("[*_`~]+")
+	markdownBlock    = regexp.MustCompile(`^(\s*[-+*>]|\s*\d+\.|\s*\|)\s*`)
+)
+
+// FirstImage returns the url of the first image in the markdown
+func FirstImage(markdown string) string {
+	match := markdownImage.FindStringSubmatch(markdown)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// FirstParagraph returns the first paragraph of the markdown that is not
+// a heading or code, as plain text shortened to at most maxLength bytes
+func FirstParagraph(markdown string, maxLength int) string {
+	paragraph := []string{}
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if trimmed == "" {
+			if len(paragraph) > 0 {
+				break
+			}
+			continue
+		}
+		trimmed = markdownBlock.ReplaceAllString(trimmed, "")
+		trimmed = markdownImages.ReplaceAllString(trimmed, "")
+		trimmed = markdownLink.ReplaceAllString(trimmed, "$1")
+		trimmed = strings.TrimSpace(markdownEmphasis.ReplaceAllString(trimmed, ""))
+		if trimmed != "" {
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	text := strings.Join(paragraph, " ")
+	if len(text) > maxLength {
+		text = text[:maxLength]
+		// do not cut a word, or a multi-byte character, in half
+		if i := strings.LastIndex(text, " "); i > 0 {
+			text = text[:i]
+		}
+		text = strings.TrimRight(text, " ,.;:") + "…"
+	}
+	return text
+}
+
+// plainText returns the paragraphs of the markdown that are not headings,
+// code or front matter, as plain text
+func plainText(markdown string) (paragraphs []string) {
+	_, body := FrontMatter(markdown)
+	paragraph := []string{}
+	inCode := false
+	for _, line := range strings.Split(body+"\n", "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if trimmed == "" {
+			if len(paragraph) > 0 {
+				paragraphs = append(paragraphs, strings.Join(paragraph, " "))
+				paragraph = []string{}
+			}
+			continue
+		}
+		trimmed = markdownBlock.ReplaceAllString(trimmed, "")
+		trimmed = markdownImages.ReplaceAllString(trimmed, "")
+		trimmed = markdownLink.ReplaceAllString(trimmed, "$1")
+		trimmed = strings.TrimSpace(markdownEmphasis.ReplaceAllString(trimmed, ""))
+		if trimmed != "" {
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	return
+}
+
+// sentenceEnd is where a sentence ends, after its punctuation
+var sentenceEnd = regexp.MustCompile(`[.!?]["')\]]*\s+`)
+
+// WordCount returns how many words the text of the markdown has, without
+// its headings and code
+func WordCount(markdown string) (count int) {
+	for _, paragraph := range plainText(markdown) {
+		count += len(strings.Fields(paragraph))
+	}
+	return
+}
+
+// stopWords are words that nearly every text has
+var stopWords = map[string]bool{
+	"the": true, "and": true, "are": true, "was": true, "were": true, "for": true, "with": true,
+	"what": true, "who": true, "when": true, "where": true, "why": true, "how": true, "which": true,
+	"did": true, "does": true, "has": true, "have": true, "had": true, "about": true, "that": true,
+	"this": true, "from": true, "can": true, "there": true, "any": true, "you": true, "your": true,
+	"they": true, "them": true, "their": true, "these": true, "those": true, "then": true, "than": true,
+	"will": true, "would": true, "could": true, "should": true, "been": true, "being": true, "into": true,
+	"also": true, "just": true, "more": true, "most": true, "some": true, "only": true, "other": true,
+	"very": true, "like": true, "make": true, "made": true, "each": true, "such": true, "much": true,
+	"many": true, "over": true, "after": true, "before": true, "because": true, "while": true, "still": true,
+	"here": true, "well": true, "even": true, "must": true, "need": true, "want": true, "using": true,
+	"used": true, "use": true, "not": true, "but": true, "all": true, "one": true, "our": true, "out": true,
+	"get": true, "got": true, "its": true, "it's": true, "his": true, "her": true, "she": true, "him": true,
+}
+
+// IsStopWord is whether the word, in lowercase, is one that nearly every
+// text has
+func IsStopWord(word string) bool {
+	return stopWords[word]
+}
+
+// Keywords returns the words of the markdown that it uses most, at most
+// the number asked for, the most used first. Only words of four or more
+// letters that are used more than once count, other than those that
+// every text uses.
+func Keywords(markdown string, n int) (keywords []string) {
+	counts := make(map[string]int)
+	var order []string
+	text := Title(markdown) + "\n" + strings.Join(plainText(markdown), "\n")
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '-'
+	}) {
+		word = strings.Trim(word, "-")
+		if len([]rune(word)) < 4 || stopWords[word] {
+			continue
+		}
+		if counts[word] == 0 {
+			order = append(order, word)
+		}
+		counts[word]++
+	}
+	for _, word := range order {
+		if counts[word] > 1 {
+			keywords = append(keywords, word)
+		}
+	}
+	sort.SliceStable(keywords, func(i, j int) bool { return counts[keywords[i]] > counts[keywords[j]] })
+	if len(keywords) > n {
+		keywords = keywords[:n]
+	}
+	return
+}
+
+// Summarize returns the sentences of the markdown that say the most, at
+// most the number asked for, in the order they are written. A sentence
+// says more the more it has of the words that the text uses most, other
+// than short ones.
+func Summarize(markdown string, sentences int) string {
+	var all []string
+	for _, paragraph := range plainText(markdown) {
+		start := 0
+		for _, end := range sentenceEnd.FindAllStringIndex(paragraph, -1) {
+			all = append(all, strings.TrimSpace(paragraph[start:end[1]]))
+			start = end[1]
+		}
+		if rest := strings.TrimSpace(paragraph[start:]); rest != "" {
+			all = append(all, rest)
+		}
+	}
+	if len(all) <= sentences {
+		return strings.Join(all, " ")
+	}
+
+	words := func(sentence string) []string {
+		return strings.FieldsFunc(strings.ToLower(sentence), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+	}
+	frequency := make(map[string]int)
+	for _, sentence := range all {
+		for _, word := range words(sentence) {
+			if len([]rune(word)) > 3 {
+				frequency[word]++
+			}
+		}
+	}
+	scores := make([]float64, len(all))
+	for i, sentence := range all {
+		sentenceWords := words(sentence)
+		for _, word := range sentenceWords {
+			scores[i] += float64(frequency[word])
+		}
+		// long sentences do not win just by being long
+		if len(sentenceWords) > 0 {
+			scores[i] /= math.Sqrt(float64(len(sentenceWords)))
+		}
+	}
+	best := make([]int, len(all))
+	for i := range best {
+		best[i] = i
+	}
+	sort.SliceStable(best, func(i, j int) bool { return scores[best[i]] > scores[best[j]] })
+	best = best[:sentences]
+	sort.Ints(best)
+	chosen := make([]string, len(best))
+	for i, index := range best {
+		chosen[i] = all[index]
+	}
+	return strings.Join(chosen, " ")
+}
+
+var (
+	renderedHeading = regexp.MustCompile(`(?s)<h([1-6]) id="([^"]+)">(.*?)</h[1-6]>`)
+	htmlTag         = regexp.MustCompile(`<[^>]*>`)
+)
+
+// TableOfContents returns a markdown list that links to the headings of
+// the markdown, using the ids that RenderMarkdownToHTML gives them
+func TableOfContents(markdown string) string {
+	_, body := FrontMatter(markdown)
+	headings := renderedHeading.FindAllStringSubmatch(string(RenderMarkdownToHTML(body)), -1)
+	top := 6
+	for _, h := range headings {
+		if level := int(h[1][0] - '0'); level < top {
+			top = level
+		}
+	}
+	toc := ""
+	for _, h := range headings {
+		name := html.UnescapeString(htmlTag.ReplaceAllString(h[3], ""))
+		name = strings.NewReplacer("[", `\[`, "]", `\]`).Replace(strings.TrimSpace(name))
+		toc += strings.Repeat("  ", int(h[1][0]-'0')-top) + "- [" + name + "](#" + h[2] + ")\n"
+	}
+	return toc
+}
+
+// Task is an item of a task list that is due on a date, like
+// "- [ ] send the report @due(2024-05-01)". Line counts from 0.
+type Task struct {
+	Line int
+	Text string
+	Due  time.Time
+	Done bool
+}
+
+var (
+	taskLine = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+\[([ xX])\]\s+(.*)$`)
+	dueDate  = regexp.MustCompile(`\s*@due\((\d{4}-\d{2}-\d{2})\)`)
+)
+
+// DueTasks returns the items of the task lists of the markdown that have
+// a due date, without the ones in code blocks
+func DueTasks(markdown string) (tasks []Task) {
+	inCode := false
+	for i, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		match := taskLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		due := dueDate.FindStringSubmatch(match[2])
+		if due == nil {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", due[1])
+		if err != nil {
+			continue
+		}
+		tasks = append(tasks, Task{
+			Line: i,
+			Text: strings.TrimSpace(dueDate.ReplaceAllString(match[2], "")),
+			Due:  date,
+			Done: match[1] != " ",
+		})
+	}
+	return
+}
+
+// Table is a markdown table. Its lines are Start up to End, and its
+// first row is the header.
+type Table struct {
+	Start, End int
+	Align      []string
+	Rows       [][]string
+}
+
+var tableDelimiter = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+
+// tableCells splits a row of a table into its cells
+func tableCells(line string) (cells []string) {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	cell := ""
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) && line[i+1] == '|' {
+			cell += `\|`
+			i++
+		} else if line[i] == '|' {
+			cells = append(cells, strings.TrimSpace(cell))
+			cell = ""
+		} else {
+			cell += string(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell))
+}
+
+// Tables returns the tables of the markdown that are not in code
+func Tables(markdown string) (tables []Table) {
+	lines := strings.Split(markdown, "\n")
+	inCode := false
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || i+1 >= len(lines) || !strings.Contains(lines[i], "|") || !strings.Contains(lines[i+1], "-") || !tableDelimiter.MatchString(lines[i+1]) {
+			continue
+		}
+		t := Table{Start: i, Rows: [][]string{tableCells(lines[i])}}
+		for _, d := range tableCells(lines[i+1]) {
+			switch {
+			case strings.HasPrefix(d, ":") && strings.HasSuffix(d, ":"):
+				t.Align = append(t.Align, "center")
+			case strings.HasSuffix(d, ":"):
+				t.Align = append(t.Align, "right")
+			case strings.HasPrefix(d, ":"):
+				t.Align = append(t.Align, "left")
+			default:
+				t.Align = append(t.Align, "")
+			}
+		}
+		j := i + 2
+		for ; j < len(lines) && strings.TrimSpace(lines[j]) != "" && strings.Contains(lines[j], "|"); j++ {
+			t.Rows = append(t.Rows, tableCells(lines[j]))
+		}
+		t.End = j
+		tables = append(tables, t)
+		i = j - 1
+	}
+	return
+}
+
+// FormatTable writes the rows as a markdown table with aligned columns.
+// The first row is the header.
+func FormatTable(rows [][]string, align []string) string {
+	columns := len(align)
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	widths := make([]int, columns)
+	cell := func(row []string, c int) string {
+		if c >= len(row) {
+			return ""
+		}
+		// a cell is one line, with its pipes escaped
+		text := strings.Join(strings.Fields(row[c]), " ")
+		text = strings.Replace(strings.Replace(text, `\|`, "|", -1), "|", `\|`, -1)
+		return text
+	}
+	for _, row := range rows {
+		for c := range widths {
+			if n := len([]rune(cell(row, c))); n > widths[c] {
+				widths[c] = n
+			}
+		}
+	}
+	for c := range widths {
+		if widths[c] < 3 {
+			widths[c] = 3
+		}
+	}
+
+	var b strings.Builder
+	line := func(cells []string) {
+		b.WriteString("|")
+		for c, text := range cells {
+			b.WriteString(" " + text + strings.Repeat(" ", widths[c]-len([]rune(text))) + " |")
+		}
+		b.WriteString("\n")
+	}
+	for r, row := range rows {
+		cells := make([]string, columns)
+		for c := range cells {
+			cells[c] = cell(row, c)
+		}
+		line(cells)
+		if r == 0 {
+			delimiters := make([]string, columns)
+			for c := range delimiters {
+				a := ""
+				if c < len(align) {
+					a = align[c]
+				}
+				d := strings.Repeat("-", widths[c])
+				switch a {
+				case "center":
+					d = ":" + d[2:] + ":"
+				case "right":
+					d = d[1:] + ":"
+				case "left":
+					d = ":" + d[1:]
+				}
+				delimiters[c] = d
+			}
+			line(delimiters)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// ReplaceTable replaces the table with the index, counting from 0, with
+// the rows, keeping the alignment of its columns
+func ReplaceTable(markdown string, index int, rows [][]string) (replaced string, err error) {
+	tables := Tables(markdown)
+	if index < 0 || index >= len(tables) {
+		return "", errors.New("there is no such table")
+	}
+	if len(rows) == 0 {
+		return "", errors.New("a table needs a header")
+	}
+	t := tables[index]
+	lines := strings.Split(markdown, "\n")
+	table := strings.Split(FormatTable(rows, t.Align), "\n")
+	lines = append(lines[:t.Start], append(table, lines[t.End:]...)...)
+	return strings.Join(lines, "\n"), nil
+}
+
+// trigrams returns the sets of three characters of the words of the
+// text in lowercase. Words are padded with two spaces before and one
+// after, like pg_trgm does, so that short words and how words start
+// count too.
+func trigrams(text string) map[string]bool {
+	grams := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			grams[string(runes[i:i+3])] = true
+		}
+	}
+	return grams
+}
+
+// Similarity is how alike two texts are, from 0 to 1, as the share of
+// their trigrams that they have in common. It is used to find the pages
+// whose names are close to one that does not exist.
+func Similarity(a, b string) float64 {
+	ga, gb := trigrams(a), trigrams(b)
+	if len(ga) == 0 || len(gb) == 0 {
+		return 0
+	}
+	shared := 0
+	for gram := range ga {
+		if gb[gram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ga)+len(gb)-shared)
+}
+
 var src = rand.NewSource(time.Now().UnixNano())
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
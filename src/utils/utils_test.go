@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlugify(t *testing.T) {
+	assert.Equal(t, "hello-world", Slugify("# Hello, World!\nsome text"))
+	assert.Equal(t, "second-line", Slugify("\n#\nSecond line"))
+	assert.Equal(t, "projects/alpha/notes", Slugify("projects / alpha // notes/"))
+	assert.Equal(t, "", Slugify("#\n!"))
+}
+
+func TestTitle(t *testing.T) {
+	assert.Equal(t, "Hello, World", Title("some text\n# Hello, World #\n## Second"))
+	assert.Equal(t, "Real title", Title("```\n# not a title\n```\n#hashtag\n## Real title"))
+	assert.Equal(t, "", Title("no headings here"))
+}
+
+func TestTags(t *testing.T) {
+	assert.Equal(t, []string{"go", "notes/work"}, Tags("# Title\n#go is fun (#Notes/Work) #go\n```\n#code\n```\nhttp://a.com/#anchor ## heading"))
+	assert.Nil(t, Tags("no tags"))
+}
+
+func TestFirstParagraph(t *testing.T) {
+	md := "# Title\n\n![logo](/uploads/sha256-abc?filename=logo.png)\n\nThis is **the** [first](http://a.com) paragraph\nstill going.\n\nSecond paragraph."
+	assert.Equal(t, "/uploads/sha256-abc?filename=logo.png", FirstImage(md))
+	assert.Equal(t, "This is the first paragraph still going.", FirstParagraph(md, 200))
+	assert.Equal(t, "This is a…", FirstParagraph("This is a long paragraph", 10))
+}
+
+func TestFrontMatter(t *testing.T) {
+	front, body := FrontMatter("---\nform: rows\nfield: Name\nfield: Email (optional)\n---\n# Title")
+	assert.Equal(t, map[string][]string{"form": {"rows"}, "field": {"Name", "Email (optional)"}}, front)
+	assert.Equal(t, "# Title", body)
+	assert.Equal(t, "title", Slugify("---\nform: rows\n---\n# Title"))
+	front, body = FrontMatter("---\nnot front matter\n---")
+	assert.Nil(t, front)
+	assert.Equal(t, "---\nnot front matter\n---", body)
+}
+
+func TestTableOfContents(t *testing.T) {
+	assert.Equal(t, "- [Title](#title)\n  - [A b & \\[c\\]](#a-b-c)\n", TableOfContents("---\nform: rows\n---\n# Title\n\ntext\n\n## A *b* & [c]"))
+	assert.Equal(t, "", TableOfContents("no headings"))
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	md := "- [ ] todo\n- [x] done\n\nTerm\n: Definition\n\n~~gone~~ http://a.com"
+	html := string(RenderMarkdown(md, false))
+	assert.Contains(t, html, `<li class="task"><input type="checkbox" disabled> todo`)
+	assert.Contains(t, html, `<input type="checkbox" disabled checked> done`)
+	assert.Contains(t, html, "<dt>Term</dt>")
+	assert.Contains(t, html, "<del>gone</del>")
+	strict := string(RenderMarkdown(md, true))
+	assert.NotContains(t, strict, "checkbox")
+	assert.NotContains(t, strict, "<del>")
+	assert.NotContains(t, strict, "<dt>")
+
+	audio := string(RenderMarkdown("[memo](/uploads/sha256-ab12?filename=memo.weba) [x](/uploads/sha256-ab12?filename=x.png)", false))
+	assert.Contains(t, audio, `<audio controls preload="none" src="/uploads/sha256-ab12?filename=memo.weba"><a href="/uploads/sha256-ab12?filename=memo.weba" rel="nofollow">memo</a></audio>`)
+	assert.Equal(t, 1, strings.Count(audio, "<audio"))
+}
+
+func TestVideoEmbed(t *testing.T) {
+	peerTube := map[string]bool{"tube.example.org": true}
+	assert.Equal(t, "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", VideoEmbed("https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1", peerTube))
+	assert.Equal(t, "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", VideoEmbed("https://youtu.be/dQw4w9WgXcQ", peerTube))
+	assert.Equal(t, "https://player.vimeo.com/video/76979871?dnt=1", VideoEmbed("https://vimeo.com/76979871", peerTube))
+	assert.Equal(t, "https://tube.example.org/videos/embed/9c9de5e8-0a1e-484a-b099-e80766180a6d", VideoEmbed("https://tube.example.org/videos/watch/9c9de5e8-0a1e-484a-b099-e80766180a6d", peerTube))
+	assert.Equal(t, "https://tube.example.org/videos/embed/kkGMgK9ZtnKfYAgnEtQxbv", VideoEmbed("https://tube.example.org/w/kkGMgK9ZtnKfYAgnEtQxbv", peerTube))
+	assert.Equal(t, "", VideoEmbed("https://www.youtube.com/channel/UC", nil))
+	assert.Equal(t, "", VideoEmbed("https://example.com/page", peerTube))
+	assert.Equal(t, "", VideoEmbed("javascript:alert(1)", peerTube))
+	assert.Equal(t, "", VideoEmbed("https://tube.example.net/w/kkGMgK9ZtnKfYAgnEtQxbv", peerTube))
+}
+
+func TestSection(t *testing.T) {
+	md := "# Title\nintro\n## Shared snippet\ntext\n```\n# not a heading\n```\n### Deeper\nmore\n## Next\nother"
+	section, found := Section(md, "shared snippet")
+	assert.True(t, found)
+	assert.Equal(t, "## Shared snippet\ntext\n```\n# not a heading\n```\n### Deeper\nmore", section)
+	section, _ = Section(md, "next")
+	assert.Equal(t, "## Next\nother", section)
+	_, found = Section(md, "missing")
+	assert.False(t, found)
+}
+
+func TestTables(t *testing.T) {
+	md := "# Title\n\n| Name | Score |\n|:--|--:|\n| a \\| b | 1 |\n| c | 22 |\n\ntext\n```\n| x | y |\n|---|---|\n```\nq | r\n--- | ---\ns | t"
+	tables := Tables(md)
+	assert.Equal(t, 2, len(tables))
+	assert.Equal(t, []string{"left", "right"}, tables[0].Align)
+	assert.Equal(t, [][]string{{"Name", "Score"}, {`a \| b`, "1"}, {"c", "22"}}, tables[0].Rows)
+	assert.Equal(t, 2, tables[0].Start)
+	assert.Equal(t, 6, tables[0].End)
+
+	replaced, err := ReplaceTable(md, 0, [][]string{{"Name", "Score", "Note"}, {"c", "22", "new\nline"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "# Title\n\n| Name | Score | Note     |\n| :--- | ----: | -------- |\n| c    | 22    | new line |\n\ntext", replaced[:strings.Index(replaced, "\ntext")+5])
+	_, err = ReplaceTable(md, 2, [][]string{{"x"}})
+	assert.NotNil(t, err)
+}
+
+func TestDueTasks(t *testing.T) {
+	md := "# Todo\n\n- [ ] send the report @due(2024-05-01)\n- [x] book @due(2024-04-02) the room\n- [ ] no date\n* [ ] bad @due(2024-13-01)\n```\n- [ ] code @due(2024-05-01)\n```\n1. [ ] call @due(2024-06-10)"
+	tasks := DueTasks(md)
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, Task{Line: 2, Text: "send the report", Due: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}, tasks[0])
+	assert.Equal(t, "book the room", tasks[1].Text)
+	assert.True(t, tasks[1].Done)
+	assert.Equal(t, 9, tasks[2].Line)
+}
+
+func TestSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, Similarity("meeting-notes", "Meeting Notes"))
+	assert.Equal(t, 0.0, Similarity("abc", ""))
+	assert.True(t, Similarity("meeting-notse", "meeting-notes") > 0.5)
+	assert.True(t, Similarity("projects/alpha", "projects/alpha/notes") > Similarity("projects/alpha", "recipes"))
+	assert.True(t, Similarity("cat", "dog") < 0.1)
+}
+
+func TestSummarize(t *testing.T) {
+	markdown := "# Garden\n\nThe garden gets tomatoes this year. Cats sleep a lot.\n\n```\ncode code code\n```\n\nThe tomatoes need water every day! Someone said hello. Tomatoes and water make the garden grow."
+	assert.Equal(t, "The garden gets tomatoes this year. Tomatoes and water make the garden grow.", Summarize(markdown, 2))
+	assert.Equal(t, "Short.", Summarize("# Title\n\nShort.", 3))
+	assert.Equal(t, 26, WordCount(markdown))
+}
+
+func TestKeywords(t *testing.T) {
+	markdown := "# Tomato garden\n\nThe tomatoes in the garden need water. Water them with rain water.\n\n```\ncode code code\n```\n\nThe garden is big. #garden"
+	assert.Equal(t, []string{"garden", "water"}, Keywords(markdown, 5))
+	assert.Equal(t, []string{"garden"}, Keywords(markdown, 1))
+	assert.Empty(t, Keywords("They would have been there.", 5))
+}
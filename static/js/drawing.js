@@ -0,0 +1,88 @@
+// drawing.js edits a drawing of strokes on a canvas, which is saved as
+// JSON by the form under it
+(function () {
+    var canvas = document.getElementById("drawing");
+    if (canvas == null) {
+        return;
+    }
+    var drawing = JSON.parse(canvas.dataset.drawing);
+    var context = canvas.getContext("2d");
+    var stroke = null;
+    canvas.width = drawing.width;
+    canvas.height = drawing.height;
+
+    function draw() {
+        context.clearRect(0, 0, canvas.width, canvas.height);
+        context.lineCap = "round";
+        context.lineJoin = "round";
+        drawing.strokes.forEach(function (s) {
+            if (s.points.length == 0) {
+                return;
+            }
+            context.strokeStyle = s.color;
+            context.lineWidth = s.width;
+            context.beginPath();
+            context.moveTo(s.points[0][0], s.points[0][1]);
+            s.points.forEach(function (p) {
+                context.lineTo(p[0], p[1]);
+            });
+            if (s.points.length == 1) {
+                context.lineTo(s.points[0][0] + 0.1, s.points[0][1]);
+            }
+            context.stroke();
+        });
+    }
+
+    // point is where the pointer is on the canvas, in its own pixels
+    function point(e) {
+        var rect = canvas.getBoundingClientRect();
+        return [
+            Math.round((e.clientX - rect.left) * canvas.width / rect.width * 10) / 10,
+            Math.round((e.clientY - rect.top) * canvas.height / rect.height * 10) / 10
+        ];
+    }
+
+    canvas.addEventListener("pointerdown", function (e) {
+        e.preventDefault();
+        canvas.setPointerCapture(e.pointerId);
+        stroke = {
+            "color": document.getElementById("drawingcolor").value,
+            "width": parseFloat(document.getElementById("drawingwidth").value),
+            "points": [point(e)]
+        };
+        drawing.strokes.push(stroke);
+        draw();
+    });
+    canvas.addEventListener("pointermove", function (e) {
+        if (stroke == null) {
+            return;
+        }
+        stroke.points.push(point(e));
+        draw();
+    });
+    ["pointerup", "pointercancel"].forEach(function (name) {
+        canvas.addEventListener(name, function () {
+            stroke = null;
+        });
+    });
+
+    document.getElementById("drawingundo").onclick = function (e) {
+        e.preventDefault();
+        drawing.strokes.pop();
+        draw();
+    };
+    document.getElementById("drawingclear").onclick = function (e) {
+        e.preventDefault();
+        if (confirm("Clear the drawing?")) {
+            drawing.strokes = [];
+            draw();
+        }
+    };
+    var form = document.getElementById("drawingform");
+    if (form != null) {
+        form.onsubmit = function () {
+            document.getElementById("drawingdata").value = JSON.stringify(drawing);
+        };
+    }
+    draw();
+})();
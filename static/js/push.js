@@ -0,0 +1,29 @@
+// service worker that shows the notifications of changes of pages that
+// the browser subscribed to
+
+self.addEventListener('push', function (event) {
+    var data = {};
+    try {
+        data = event.data.json();
+    } catch (e) {
+        data = { title: "A page changed", body: "", url: "/" };
+    }
+    event.waitUntil(self.registration.showNotification(data.title, {
+        body: data.body,
+        tag: data.url,
+        data: { url: data.url }
+    }));
+});
+
+self.addEventListener('notificationclick', function (event) {
+    event.notification.close();
+    var url = event.notification.data && event.notification.data.url ? event.notification.data.url : "/";
+    event.waitUntil(clients.matchAll({ type: 'window' }).then(function (windows) {
+        for (var i = 0; i < windows.length; i++) {
+            if (new URL(windows[i].url).pathname == url && 'focus' in windows[i]) {
+                return windows[i].focus();
+            }
+        }
+        return clients.openWindow(url);
+    }));
+});
@@ -6,6 +6,14 @@ const socketMessageListener = (event) => {
 };
 const socketOpenListener = (event) => {
     // console.log('Connected');
+    CY.watch();
+    // a new connection does not know the text yet, so whatever was not
+    // acknowledged before disconnecting is sent again as the full text
+    CY.base = null;
+    if (CY.pending.length > 0) {
+        CY.pending = [];
+        CY.contentEdited();
+    }
     document.getElementById("connectedicon").style.display = 'inline-block';
     setTimeout(function () {
         document.getElementById("connectedicon").style.display = 'none';
@@ -15,7 +23,18 @@ const socketCloseListener = (event) => {
     if (socket) {
         console.error('Disconnected.');
     }
-    var url = window.origin.replace("http", "ws") + '/ws';
+    if (event && event.code == 4000) {
+        // closed for being idle, reconnect when the page is edited
+        CY.idle = true;
+    } else if (event) {
+        // wait a moment before reconnecting, in case the server is restarting
+        setTimeout(socketConnect, 1000);
+    } else {
+        socketConnect();
+    }
+};
+const socketConnect = () => {
+    var url = window.origin.replace("http", "ws") + '/ws?domain=' + encodeURIComponent(window.rwtxt.domain);
     socket = new WebSocket(url);
     socket.addEventListener('open', socketOpenListener);
     socket.addEventListener('message', socketMessageListener);
@@ -38,13 +57,28 @@ function getParameterByName(name, url) {
 // slugify the current text
 function slugify(text) {
     var lines = text.split('\n');
-    for (var i = 0; i < lines.length; i++) {
+    var start = 0;
+    // skip the front matter, like utils.FrontMatter
+    if (lines.length > 2 && lines[0].trim() == "---") {
+        for (var j = 1; j < lines.length; j++) {
+            var line = lines[j].trim();
+            if (line == "---" && j > 1) {
+                start = j + 1;
+                break;
+            }
+            if (!/^[\w-]+:/.test(line)) {
+                break;
+            }
+        }
+    }
+    for (var i = start; i < lines.length; i++) {
         var slug = lines[i].toString().toLowerCase()
             .replace(/\s+/g, '-') // Replace spaces with -
-            .replace(/[^\w\-]+/g, '') // Remove all non-word chars
+            .replace(/[^\w\-\/]+/g, '') // Remove all non-word chars, keeping / for folders
             .replace(/\-\-+/g, '-') // Replace multiple - with single -
-            .replace(/^-+/, '') // Trim - from start of text
-            .replace(/-+$/, ''); // Trim - from end of text
+            .replace(/-*\/[-\/]*/g, '/') // Collapse - and / around folder separators
+            .replace(/^[-\/]+/, '') // Trim - and / from start of text
+            .replace(/[-\/]+$/, ''); // Trim - and / from end of text
         if (slug.length > 1) {
             return slug;
         }
@@ -84,21 +118,163 @@ CY.debounce = function (func, wait, immediate) {
     };
 };
 
+// changes are numbered and kept until the server acknowledges them,
+// so that changes made while disconnected are sent after reconnecting
+CY.base = null;
+CY.client = Math.random().toString(36).substring(2) + Date.now().toString(36);
+CY.seq = 0;
+CY.pending = [];
+
+CY.send = function (message) {
+    if (CY.idle) {
+        CY.idle = false;
+        socketConnect();
+    }
+    CY.seq++;
+    message.client = CY.client;
+    message.seq = CY.seq;
+    CY.pending.push({
+        message: message,
+        sent: 0
+    });
+    CY.flush();
+};
+
+CY.flush = function () {
+    if (!socket || socket.readyState != WebSocket.OPEN) {
+        return;
+    }
+    var now = Date.now();
+    CY.pending.forEach(function (change) {
+        if (change.sent == 0) {
+            socket.send(JSON.stringify(change.message));
+            change.sent = now;
+        }
+    });
+};
+
+CY.acknowledged = function (seq) {
+    CY.pending = CY.pending.filter(function (change) {
+        return change.message.seq > seq;
+    });
+};
+
+// reconnect when changes are not acknowledged, as the connection is stuck
+setInterval(function () {
+    if (CY.pending.length > 0 && CY.pending[0].sent > 0 && Date.now() - CY.pending[0].sent > 10000) {
+        socket.close();
+    }
+}, 5000);
+
+window.addEventListener('beforeunload', function (e) {
+    if (CY.pending.length > 0) {
+        e.preventDefault();
+        e.returnValue = '';
+    }
+});
+
+// diff returns the part of the text that changed since the base, without
+// splitting characters that take two code units
+CY.diff = function (base, text) {
+    var start = 0;
+    var max = Math.min(base.length, text.length);
+    while (start < max && base.charCodeAt(start) == text.charCodeAt(start)) {
+        start++;
+    }
+    if (start > 0 && CY.isHighSurrogate(base.charCodeAt(start - 1))) {
+        start--;
+    }
+    var end = 0;
+    while (end < max - start && base.charCodeAt(base.length - 1 - end) == text.charCodeAt(text.length - 1 - end)) {
+        end++;
+    }
+    if (end > 0 && CY.isHighSurrogate(base.charCodeAt(base.length - 1 - end))) {
+        end--;
+    }
+    return {
+        "start": start,
+        "delete": base.length - end - start,
+        "insert": text.substring(start, text.length - end),
+        "length": base.length
+    };
+};
+
+CY.isHighSurrogate = function (code) {
+    return code >= 0xD800 && code <= 0xDBFF;
+};
+
 CY.contentEdited = function () {
     // console.log('edited');
     var markdown = document.getElementById("editable").value.replaceAll("<br>", "\n");
-    var slug = slugify(markdown);
-    socket.send(JSON.stringify({
+    var message = {
         "id": window.rwtxt.file_id,
         "slug": slugify(markdown),
-        "data": markdown,
         "domain": window.rwtxt.domain,
-        "domain_key": window.rwtxt.domain_key
+        "name": CY.editorName()
+    };
+    // send only what changed, unless the server does not have the text yet
+    if (CY.base == null) {
+        message.data = markdown;
+    } else {
+        message.patch = CY.diff(CY.base, markdown);
+    }
+    CY.base = markdown;
+    CY.send(message);
+};
+
+// the name shown to others while editing
+CY.editorName = function () {
+    return localStorage.getItem("rwtxt_name") || "";
+};
+
+// ask to be told who is editing this page
+CY.watch = function () {
+    socket.send(JSON.stringify({
+        "message": "watch",
+        "id": window.rwtxt.file_id,
+        "domain": window.rwtxt.domain,
+        "name": CY.editorName()
     }));
 };
 
+// show who else is editing this page
+CY.showEditors = function (names) {
+    var d = document.getElementById("editors");
+    d.innerHTML = "";
+    if (names.length == 0) {
+        d.style.display = 'none';
+        if (CY.locked) {
+            // the page is free again, save what was written meanwhile
+            CY.locked = false;
+            CY.contentEdited();
+        }
+        return;
+    }
+    var text = names.join(", ") + (names.length == 1 ? " is" : " are") + " editing this page.";
+    if (CY.locked) {
+        text += " Your changes will be saved when they are done.";
+    }
+    d.appendChild(document.createTextNode(text + " "));
+    var namelink = document.createElement("a");
+    namelink.href = "#";
+    namelink.innerText = "Set your name";
+    namelink.addEventListener("click", function (e) {
+        e.preventDefault();
+        var name = prompt("Your name, shown to others editing this page", CY.editorName());
+        if (name != null) {
+            localStorage.setItem("rwtxt_name", name.trim());
+            CY.watch();
+        }
+    });
+    d.appendChild(namelink);
+    d.style.display = 'block';
+};
+
 CY.serverResponse = function (jsonString) {
     var data = JSON.parse(jsonString);
+    if (data.seq) {
+        CY.acknowledged(data.seq);
+    }
     if (data.message == "unique_slug") {
         var newwindowname = ""
         if (data.success) {
@@ -107,16 +283,58 @@ CY.serverResponse = function (jsonString) {
             newwindowname = data.id;
         }
         // console.log(newwindowname);
-        if (newwindowname != undefined && newwindowname.length > 0 && "/" + newwindowname != window.location
+        // slugs may contain folders, so always replace the full path
+        var newpathname = "/" + window.rwtxt.domain + "/" + newwindowname;
+        if (newwindowname != undefined && newwindowname.length > 0 && newpathname != window.location
             .pathname) {
-            history.replaceState({}, newwindowname, newwindowname);
+            history.replaceState({}, newwindowname, newpathname);
             document.title = newwindowname;
         }
         document.getElementById("saved").style.display = 'inline-block';
         setTimeout(function () {
             document.getElementById("saved").style.display = 'none';
         }, 1000);
+    } else if (data.message == "slug_taken") {
+        var d = document.getElementById("duplicate");
+        d.innerText = data.data + ": " + data.slug + ". Change the first line to save it under a new name.";
+        d.style.display = 'block';
+        document.getElementById("notsaved").style.display = 'inline-block';
+        setTimeout(function () {
+            document.getElementById("notsaved").style.display = 'none';
+        }, 1000);
+    } else if (data.message == "editors") {
+        CY.showEditors(data.editors || []);
+    } else if (data.message == "resync") {
+        CY.base = null;
+        CY.contentEdited();
+    } else if (data.message == "locked") {
+        CY.locked = true;
+        CY.base = null;
+        CY.showEditors([data.data]);
+        document.getElementById("notsaved").style.display = 'inline-block';
+        setTimeout(function () {
+            document.getElementById("notsaved").style.display = 'none';
+        }, 1000);
+    } else if (data.message == "duplicate") {
+        CY.showDuplicate(data);
+    } else if (data.message == "tags") {
+        CY.showTags(data.tags || []);
+    } else if (data.message == "merged") {
+        if (data.success) {
+            window.location = "/" + window.rwtxt.domain + "/" + data.target;
+        } else {
+            alert("Could not merge: " + data.data);
+        }
+    } else if (data.message == "table") {
+        CY.tableSaved(data);
+    } else if (data.message == "expansion") {
+        CY.expanded(data);
     } else if (data.message == "not saving") {
+        if (data.data) {
+            var d = document.getElementById("duplicate");
+            d.innerText = "Not saved: " + data.data + ".";
+            d.style.display = 'block';
+        }
         document.getElementById("notsaved").style.display = 'inline-block';
         setTimeout(function () {
             document.getElementById("notsaved").style.display = 'none';
@@ -124,13 +342,88 @@ CY.serverResponse = function (jsonString) {
     }
 }
 
+// warn that the page is nearly identical to another page
+CY.showDuplicate = function (data) {
+    var name = data.target_slug ? data.target_slug : data.target;
+    var d = document.getElementById("duplicate");
+    d.innerHTML = "";
+    d.appendChild(document.createTextNode("This looks " + Math.round(data.similarity * 100) + "% like "));
+    var openlink = document.createElement("a");
+    openlink.href = "/" + window.rwtxt.domain + "/" + data.target;
+    openlink.target = "_blank";
+    openlink.innerText = name;
+    d.appendChild(openlink);
+    d.appendChild(document.createTextNode(". "));
+    var mergelink = document.createElement("a");
+    mergelink.href = "#";
+    mergelink.innerText = "Merge into it";
+    mergelink.addEventListener("click", function (e) {
+        e.preventDefault();
+        if (!confirm("Append this page to " + name + " and remove this page?")) {
+            return;
+        }
+        socket.send(JSON.stringify({
+            "message": "merge",
+            "id": window.rwtxt.file_id,
+            "target": data.target,
+            "domain": window.rwtxt.domain
+        }));
+    });
+    d.appendChild(mergelink);
+    d.style.display = 'block';
+};
+
+// suggest tags for the page, each added to it with a click
+CY.showTags = function (tags) {
+    var d = document.getElementById("tags");
+    d.innerHTML = "";
+    if (tags.length == 0) {
+        d.style.display = 'none';
+        return;
+    }
+    d.appendChild(document.createTextNode("Suggested tags: "));
+    tags.forEach(function (tag) {
+        var taglink = document.createElement("a");
+        taglink.href = "#";
+        taglink.innerText = "#" + tag;
+        taglink.addEventListener("click", function (e) {
+            e.preventDefault();
+            CY.acceptTag(tag);
+            d.removeChild(taglink.nextSibling);
+            d.removeChild(taglink);
+            if (d.getElementsByTagName("a").length == 0) {
+                d.style.display = 'none';
+            }
+            // the link is gone, so keep on writing
+            document.getElementById("editable").focus();
+        });
+        d.appendChild(taglink);
+        d.appendChild(document.createTextNode(" "));
+    });
+    d.style.display = 'block';
+};
+
+// add the tag to the line of tags at the end of the page, or start one
+CY.acceptTag = function (tag) {
+    var editor = document.getElementById("editable");
+    var text = editor.value.replace(/\s+$/, "");
+    var lines = text.split("\n");
+    if (/^(#[^\s#]+\s*)+$/.test(lines[lines.length - 1])) {
+        text += " #" + tag;
+    } else {
+        text += "\n\n#" + tag;
+    }
+    editor.value = text;
+    autoExpand(editor);
+    CY.contentEdited();
+};
+
 CY.editClick = function (e) {
     e.preventDefault();
     CY.loadEditor();
 }
 
 CY.loadEditor = function () {
-    socketCloseListener();
     d = document.getElementById("rendered")
     d.innerHTML = "";
     editor = document.getElementById("editable")
@@ -144,9 +437,704 @@ CY.loadEditor = function () {
 
 document.getElementById("editable").addEventListener('input', CY.debounce(CY.contentEdited, 200));
 
+// typing [[ suggests pages to link to, and # suggests tags
+CY.suggestions = [];
+CY.suggested = 0;
+
+CY.suggest = function () {
+    var editor = document.getElementById("editable");
+    var pos = editor.selectionStart;
+    var before = editor.value.substring(0, pos);
+    var query, start;
+    var match = /\[\[([^\[\]\n]*)$/.exec(before);
+    if (match != null) {
+        query = "page=" + encodeURIComponent(match[1]);
+        start = pos - match[1].length - 2;
+    } else {
+        match = /(?:^|[\s(])#([\w][\w\-\/]*)$/.exec(before);
+        if (match == null) {
+            CY.showSuggestions([]);
+            return;
+        }
+        query = "tag=" + encodeURIComponent(match[1]);
+        start = pos - match[1].length - 1;
+    }
+    fetch('/api/v1/' + encodeURIComponent(window.rwtxt.domain) + '/complete?' + query, {
+        credentials: 'same-origin'
+    }).then(function (response) {
+        return response.json();
+    }).then(function (data) {
+        if (editor.selectionStart != pos) {
+            // the text changed meanwhile
+            return;
+        }
+        var suggestions = [];
+        (data.pages || []).forEach(function (page) {
+            if (page.id == window.rwtxt.file_id) {
+                return;
+            }
+            suggestions.push({
+                "name": page.title,
+                "text": "[" + page.title + "](/" + window.rwtxt.domain + "/" + (page.slug || page.id) + ")"
+            });
+        });
+        (data.tags || []).forEach(function (tag) {
+            suggestions.push({
+                "name": "#" + tag,
+                "text": "#" + tag + " "
+            });
+        });
+        CY.suggestStart = start;
+        CY.showSuggestions(suggestions);
+    });
+};
+
+CY.showSuggestions = function (suggestions) {
+    CY.suggestions = suggestions;
+    var d = document.getElementById("suggestions");
+    d.innerHTML = "";
+    if (suggestions.length == 0) {
+        d.style.display = 'none';
+        CY.selectSuggestion(0);
+        return;
+    }
+    suggestions.forEach(function (suggestion, i) {
+        var link = document.createElement("a");
+        link.id = "suggestion" + i;
+        link.setAttribute("role", "option");
+        link.innerText = suggestion.name;
+        link.addEventListener("mousedown", function (e) {
+            e.preventDefault();
+            CY.pickSuggestion(i);
+        });
+        d.appendChild(link);
+    });
+    d.style.display = 'block';
+    CY.selectSuggestion(0);
+};
+
+// mark the suggestion that enter picks, also for screen readers, which
+// follow it while the focus stays in the editor
+CY.selectSuggestion = function (i) {
+    var links = document.getElementById("suggestions").children;
+    for (var j = 0; j < links.length; j++) {
+        links[j].className = i == j ? "suggested" : "";
+        links[j].setAttribute("aria-selected", i == j ? "true" : "false");
+    }
+    CY.suggested = i;
+    var editor = document.getElementById("editable");
+    if (links.length > 0) {
+        editor.setAttribute("aria-activedescendant", links[i].id);
+    } else {
+        editor.removeAttribute("aria-activedescendant");
+    }
+};
+
+CY.pickSuggestion = function (i) {
+    var editor = document.getElementById("editable");
+    var pos = editor.selectionStart;
+    editor.value = editor.value.substring(0, CY.suggestStart) + editor.value.substring(pos);
+    var text = CY.suggestions[i].text;
+    CY.showSuggestions([]);
+    CY.insertText(CY.suggestStart, text);
+};
+
+CY.suggestKey = function (e) {
+    if (CY.suggestions.length == 0) {
+        return;
+    }
+    var links = document.getElementById("suggestions").children;
+    if (e.key == "ArrowDown" || e.key == "ArrowUp") {
+        e.preventDefault();
+        CY.selectSuggestion((CY.suggested + (e.key == "ArrowDown" ? 1 : links.length - 1)) % links.length);
+    } else if (e.key == "Enter" || e.key == "Tab") {
+        e.preventDefault();
+        CY.pickSuggestion(CY.suggested);
+    } else if (e.key == "Escape") {
+        CY.showSuggestions([]);
+    }
+};
+
+document.getElementById("editable").addEventListener('keydown', CY.suggestKey);
+document.getElementById("editable").addEventListener('input', CY.debounce(CY.suggest, 150));
+document.getElementById("editable").addEventListener('blur', function () {
+    CY.showSuggestions([]);
+});
+
+// slash commands typed on a line of their own are replaced when pressing
+// enter, by the editor or by the server when they need other pages
+CY.commands = /^\/(date|toc|template|upload|record|drawing)(\s.*)?$/;
+
+CY.insertText = function (pos, text) {
+    var editor = document.getElementById("editable");
+    editor.value = editor.value.substring(0, pos) + text + editor.value.substring(pos);
+    editor.selectionStart = editor.selectionEnd = pos + text.length;
+    autoExpand(editor);
+    CY.contentEdited();
+};
+
+CY.runCommand = function (e) {
+    if (e.key != "Enter" || e.shiftKey || e.defaultPrevented) {
+        return;
+    }
+    var editor = document.getElementById("editable");
+    var pos = editor.selectionStart;
+    if (pos != editor.selectionEnd) {
+        return;
+    }
+    var lineStart = editor.value.lastIndexOf("\n", pos - 1) + 1;
+    var lineEnd = editor.value.indexOf("\n", pos);
+    if (lineEnd == -1) {
+        lineEnd = editor.value.length;
+    }
+    var command = editor.value.substring(lineStart, lineEnd).trim();
+    var match = CY.commands.exec(command);
+    if (match == null || pos != lineEnd) {
+        return;
+    }
+    e.preventDefault();
+    editor.value = editor.value.substring(0, lineStart) + editor.value.substring(lineEnd);
+    editor.selectionStart = editor.selectionEnd = lineStart;
+    if (match[1] == "date") {
+        var now = new Date();
+        var date = now.getFullYear() + "-" + ("0" + (now.getMonth() + 1)).slice(-2) + "-" + ("0" + now.getDate()).slice(-2);
+        CY.insertText(lineStart, date);
+    } else if (match[1] == "upload") {
+        CY.contentEdited();
+        CY.chooseUpload();
+    } else if (match[1] == "record") {
+        CY.contentEdited();
+        CY.record();
+    } else if (match[1] == "drawing") {
+        // the page links to a new drawing, which replaces it when saved
+        CY.insertText(lineStart, "{{drawing}}");
+    } else {
+        // send the text without the command first, so that the server
+        // expands it for what is written now
+        CY.contentEdited();
+        CY.expandAt = lineStart;
+        socket.send(JSON.stringify({
+            "message": "expand",
+            "id": window.rwtxt.file_id,
+            "domain": window.rwtxt.domain,
+            "data": command
+        }));
+    }
+};
+
+CY.expanded = function (data) {
+    if (CY.expandAt == null) {
+        return;
+    }
+    var pos = Math.min(CY.expandAt, document.getElementById("editable").value.length);
+    CY.expandAt = null;
+    if (!data.success) {
+        var d = document.getElementById("duplicate");
+        d.innerText = data.data + ".";
+        d.style.display = 'block';
+        return;
+    }
+    CY.insertText(pos, data.data.replace(/\n+$/, ""));
+};
+
+// pick files to upload, as if they were dropped on the editor
+CY.chooseUpload = function () {
+    var input = document.createElement("input");
+    input.type = "file";
+    input.multiple = true;
+    input.addEventListener("change", function () {
+        var dropzone = document.getElementById("dropzoneForm").dropzone;
+        for (var i = 0; i < input.files.length; i++) {
+            dropzone.addFile(input.files[i]);
+        }
+    });
+    input.click();
+};
+
+// record records an audio memo, which is uploaded like a chosen file
+// when the recording is stopped
+CY.record = function () {
+    if (!navigator.mediaDevices || typeof MediaRecorder == "undefined") {
+        alert("This browser can not record audio.");
+        return;
+    }
+    navigator.mediaDevices.getUserMedia({ audio: true }).then(function (stream) {
+        var recorder = new MediaRecorder(stream);
+        var chunks = [];
+        var stop = document.createElement("a");
+        stop.id = "recording";
+        stop.href = "#";
+        stop.innerText = "■ Stop recording";
+        stop.onclick = function (e) {
+            e.preventDefault();
+            recorder.stop();
+        };
+        document.body.appendChild(stop);
+        recorder.ondataavailable = function (e) {
+            chunks.push(e.data);
+        };
+        recorder.onstop = function () {
+            stream.getTracks().forEach(function (track) {
+                track.stop();
+            });
+            stop.remove();
+            var type = recorder.mimeType.split(";")[0];
+            var extension = type == "audio/mp4" ? ".m4a" : (type == "audio/ogg" ? ".ogg" : ".weba");
+            var name = "memo-" + new Date().toISOString().slice(0, 16).replace(/[T:]/g, "-") + extension;
+            document.getElementById("dropzoneForm").dropzone.addFile(new File(chunks, name, { type: type }));
+        };
+        recorder.start();
+    }).catch(function (err) {
+        alert("Could not record: " + err.message);
+    });
+};
+
+document.getElementById("editable").addEventListener('keydown', CY.runCommand);
+
 editlink = document.getElementById("editlink")
 if (editlink != null) {
-    editlink.addEventListener("click", CY.loadEditor);
+    editlink.addEventListener("click", CY.editClick);
+}
+
+// tables of the page can be edited as a grid, which sends the rows to be
+// written back as a markdown table
+
+// parseTables returns the rows of the tables of the markdown, like
+// utils.Tables
+CY.parseTables = function (markdown) {
+    var lines = markdown.split("\n");
+    var delimiter = /^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$/;
+    var cells = function (line) {
+        line = line.trim().replace(/^\|/, "");
+        if (/[^\\]\|$/.test(line) || line == "|") {
+            line = line.substring(0, line.length - 1);
+        }
+        return line.split(/(?<!\\)\|/).map(function (cell) {
+            return cell.trim();
+        });
+    };
+    var tables = [];
+    var inCode = false;
+    for (var i = 0; i < lines.length; i++) {
+        if (lines[i].trim().startsWith("```")) {
+            inCode = !inCode;
+            continue;
+        }
+        if (inCode || i + 1 >= lines.length || lines[i].indexOf("|") == -1 || !delimiter.test(lines[i + 1]) || lines[i + 1].indexOf("-") == -1) {
+            continue;
+        }
+        var rows = [cells(lines[i])];
+        var j = i + 2;
+        for (; j < lines.length && lines[j].trim() != "" && lines[j].indexOf("|") != -1; j++) {
+            rows.push(cells(lines[j]));
+        }
+        tables.push(rows);
+        i = j - 1;
+    }
+    return tables;
+};
+
+CY.addTableLinks = function () {
+    var tables = document.querySelectorAll("#rendered table");
+    var rows = CY.parseTables(document.getElementById("editable").value);
+    if (tables.length == 0 || tables.length != rows.length) {
+        // included pages have tables that can not be edited here
+        return;
+    }
+    tables.forEach(function (table, i) {
+        var link = document.createElement("a");
+        link.innerText = "Edit table";
+        link.className = "grayed smaller tablelink noprint";
+        link.addEventListener("click", function (e) {
+            e.preventDefault();
+            var current = CY.parseTables(document.getElementById("editable").value)[i];
+            if (current) {
+                link.style.display = 'none';
+                CY.editTable(table, i, current, link);
+            }
+        });
+        table.parentNode.insertBefore(link, table.nextSibling);
+    });
+};
+
+CY.editTable = function (table, index, rows, link) {
+    var grid = document.createElement("div");
+    grid.className = "grid";
+    var gridTable = document.createElement("table");
+    var button = function (parent, text, title, onclick) {
+        var a = document.createElement("a");
+        a.innerText = text;
+        a.title = title;
+        a.className = "gridbutton";
+        a.addEventListener("click", function (e) {
+            e.preventDefault();
+            onclick(a);
+        });
+        parent.appendChild(a);
+    };
+    // the cells of a column are found by their place among the cells
+    // of their row, leaving out the last cell with the row's buttons
+    var column = function (cell) {
+        return Array.prototype.indexOf.call(cell.parentNode.querySelectorAll(".gridcolumn"), cell);
+    };
+    var text = function (tr, c) {
+        var cells = tr.querySelectorAll(".gridcell");
+        return c < cells.length ? cells[c].innerText.replace(/\n/g, " ").trim() : "";
+    };
+    var sort = function (c, ascending) {
+        var body = Array.prototype.slice.call(gridTable.rows, 1);
+        var numeric = body.every(function (tr) {
+            return text(tr, c) == "" || !isNaN(parseFloat(text(tr, c)));
+        });
+        body.sort(function (a, b) {
+            var x = text(a, c),
+                y = text(b, c);
+            var order = numeric ? (parseFloat(x) || 0) - (parseFloat(y) || 0) : x.localeCompare(y);
+            return ascending ? order : -order;
+        });
+        body.forEach(function (tr) {
+            gridTable.appendChild(tr);
+        });
+    };
+    var cell = function (tr, value, header) {
+        var td = document.createElement(header ? "th" : "td");
+        td.className = "gridcolumn";
+        var editable = document.createElement("div");
+        editable.className = "gridcell";
+        editable.contentEditable = "true";
+        editable.innerText = value || "";
+        td.appendChild(editable);
+        if (header) {
+            button(td, "▲", "Sort up", function () {
+                sort(column(td), true);
+            });
+            button(td, "▼", "Sort down", function () {
+                sort(column(td), false);
+            });
+            button(td, "×", "Remove this column", function () {
+                var c = column(td);
+                Array.prototype.forEach.call(gridTable.rows, function (row) {
+                    var cells = row.querySelectorAll(".gridcolumn");
+                    if (c < cells.length) {
+                        cells[c].remove();
+                    }
+                });
+            });
+        }
+        tr.insertBefore(td, tr.querySelector(".gridcontrols"));
+    };
+    var row = function (values, header) {
+        var tr = document.createElement("tr");
+        var controls = document.createElement(header ? "th" : "td");
+        controls.className = "gridcontrols";
+        if (!header) {
+            button(controls, "×", "Remove this row", function () {
+                tr.remove();
+            });
+        }
+        tr.appendChild(controls);
+        for (var c = 0; c < values.length; c++) {
+            cell(tr, values[c], header);
+        }
+        gridTable.appendChild(tr);
+    };
+
+    var columns = 0;
+    rows.forEach(function (values) {
+        columns = Math.max(columns, values.length);
+    });
+    rows.forEach(function (values, r) {
+        values = values.slice();
+        while (values.length < columns) {
+            values.push("");
+        }
+        row(values, r == 0);
+    });
+    grid.appendChild(gridTable);
+
+    var actions = document.createElement("div");
+    actions.className = "smaller";
+    button(actions, "Add row", "", function () {
+        row(new Array(gridTable.rows[0].querySelectorAll(".gridcolumn").length).fill(""), false);
+    });
+    button(actions, "Add column", "", function () {
+        Array.prototype.forEach.call(gridTable.rows, function (tr, r) {
+            cell(tr, "", r == 0);
+        });
+    });
+    button(actions, "Save", "", function () {
+        var edited = Array.prototype.map.call(gridTable.rows, function (tr) {
+            return Array.prototype.map.call(tr.querySelectorAll(".gridcell"), function (_, c) {
+                return text(tr, c);
+            });
+        });
+        CY.tableEdit = {
+            grid: grid,
+            rows: edited,
+            link: link
+        };
+        CY.send({
+            "id": window.rwtxt.file_id,
+            "slug": slugify(document.getElementById("editable").value),
+            "domain": window.rwtxt.domain,
+            "name": CY.editorName(),
+            "table": {
+                "index": index,
+                "rows": edited
+            }
+        });
+    });
+    button(actions, "Cancel", "", function () {
+        grid.parentNode.replaceChild(table, grid);
+        link.style.display = '';
+    });
+    grid.appendChild(actions);
+    table.parentNode.replaceChild(grid, table);
+};
+
+// tableSaved shows the saved table and keeps the text that has it
+CY.tableSaved = function (data) {
+    var editor = document.getElementById("editable");
+    editor.value = data.data;
+    CY.base = data.data;
+    autoExpand(editor);
+    if (CY.tableEdit == null) {
+        return;
+    }
+    var table = document.createElement("table");
+    CY.tableEdit.rows.forEach(function (row, r) {
+        var tr = document.createElement("tr");
+        row.forEach(function (text) {
+            var td = document.createElement(r == 0 ? "th" : "td");
+            td.innerText = text;
+            tr.appendChild(td);
+        });
+        table.appendChild(tr);
+    });
+    CY.tableEdit.grid.parentNode.replaceChild(table, CY.tableEdit.grid);
+    CY.tableEdit.link.style.display = '';
+    CY.tableEdit = null;
+};
+
+if (document.getElementById("editlink") != null) {
+    CY.addTableLinks();
+}
+
+CY.togglePin = function (e) {
+    e.preventDefault();
+    var pinlink = document.getElementById("pinlink");
+    var pinned = pinlink.dataset.pinned == "yes";
+    fetch('/api/pins?domain=' + encodeURIComponent(window.rwtxt.domain) + '&id=' + encodeURIComponent(window.rwtxt.file_id), {
+        method: pinned ? 'DELETE' : 'POST',
+        credentials: 'same-origin'
+    }).then(function (response) {
+        return response.json();
+    }).then(function (data) {
+        if (data.message == "pinned") {
+            pinlink.dataset.pinned = data.success ? "yes" : "no";
+            pinlink.innerText = data.success ? "★ Unpin" : "☆ Pin";
+        }
+    });
+}
+
+pinlink = document.getElementById("pinlink")
+if (pinlink != null) {
+    pinlink.addEventListener("click", CY.togglePin);
+}
+
+CY.toggleArchive = function (e) {
+    e.preventDefault();
+    var archivelink = document.getElementById("archivelink");
+    var archived = archivelink.dataset.archived == "yes";
+    fetch('/api/archive?domain=' + encodeURIComponent(window.rwtxt.domain) + '&id=' + encodeURIComponent(window.rwtxt.file_id), {
+        method: archived ? 'DELETE' : 'POST',
+        credentials: 'same-origin'
+    }).then(function (response) {
+        return response.json();
+    }).then(function (data) {
+        if (data.message == "archived") {
+            archivelink.dataset.archived = data.success ? "yes" : "no";
+            archivelink.innerText = data.success ? "Unarchive" : "Archive";
+        }
+    });
+}
+
+archivelink = document.getElementById("archivelink")
+if (archivelink != null) {
+    archivelink.addEventListener("click", CY.toggleArchive);
+}
+
+// hovering over a link to another page of the domain shows the start of
+// that page in a card
+CY.previews = {};
+CY.previewTimer = null;
+
+CY.previewPage = function (link) {
+    if (link.host != window.location.host || link.hash != "") {
+        return null;
+    }
+    var parts = link.pathname.split("/");
+    if (parts.length != 3 || parts[1] != window.rwtxt.domain || parts[2] == "" || parts[2] == window.rwtxt.file_id) {
+        return null;
+    }
+    return decodeURIComponent(parts[2]);
+};
+
+CY.showPreview = function (link, preview) {
+    var card = document.getElementById("preview");
+    if (card == null) {
+        card = document.createElement("div");
+        card.id = "preview";
+        card.className = "preview fonty";
+        card.setAttribute("role", "tooltip");
+        card.addEventListener("mouseenter", function () {
+            clearTimeout(CY.previewTimer);
+        });
+        card.addEventListener("mouseleave", CY.hidePreview);
+        document.body.appendChild(card);
+    }
+    card.innerHTML = "";
+    var title = document.createElement("a");
+    title.className = "preview-title";
+    title.href = link.href;
+    title.innerText = preview.title;
+    card.appendChild(title);
+    var body = document.createElement("div");
+    body.innerHTML = preview.html;
+    card.appendChild(body);
+    if (preview.truncated) {
+        var more = document.createElement("a");
+        more.href = link.href;
+        more.className = "smaller";
+        more.innerText = "Read more";
+        card.appendChild(more);
+    }
+    var rect = link.getBoundingClientRect();
+    card.style.top = (rect.bottom + window.scrollY + 4) + "px";
+    card.style.left = Math.max(0, Math.min(rect.left + window.scrollX, document.documentElement.clientWidth - 340)) + "px";
+    card.style.display = "block";
+    link.setAttribute("aria-describedby", "preview");
+};
+
+CY.hidePreview = function () {
+    clearTimeout(CY.previewTimer);
+    CY.previewTimer = setTimeout(function () {
+        var card = document.getElementById("preview");
+        if (card != null) {
+            card.style.display = "none";
+        }
+    }, 300);
+};
+
+CY.hoverLink = function (e) {
+    var link = e.target.closest("a");
+    if (link == null || link.closest("#preview") != null) {
+        return;
+    }
+    var page = CY.previewPage(link);
+    if (page == null) {
+        return;
+    }
+    clearTimeout(CY.previewTimer);
+    CY.previewTimer = setTimeout(function () {
+        if (CY.previews[page] !== undefined) {
+            CY.showPreview(link, CY.previews[page]);
+            return;
+        }
+        fetch('/api/v1/' + encodeURIComponent(window.rwtxt.domain) + '/preview?page=' + encodeURIComponent(page), {
+            credentials: 'same-origin'
+        }).then(function (response) {
+            if (!response.ok) {
+                throw new Error(response.statusText);
+            }
+            return response.json();
+        }).then(function (preview) {
+            CY.previews[page] = preview;
+            if (link.matches(":hover") || document.activeElement == link) {
+                CY.showPreview(link, preview);
+            }
+        }).catch(function () {});
+    }, 400);
+};
+
+CY.leaveLink = function (e) {
+    var link = e.target.closest("a");
+    if (link != null && CY.previewPage(link) != null) {
+        CY.hidePreview();
+    }
+};
+
+var renderedDiv = document.getElementById("rendered");
+if (renderedDiv != null) {
+    renderedDiv.addEventListener("mouseover", CY.hoverLink);
+    renderedDiv.addEventListener("mouseout", CY.leaveLink);
+    renderedDiv.addEventListener("focusin", CY.hoverLink);
+    renderedDiv.addEventListener("focusout", CY.leaveLink);
+}
+
+
+// notifylink subscribes the browser to notifications of changes of the
+// page, through the service worker in push.js
+CY.urlBase64ToUint8Array = function (base64) {
+    var padding = '='.repeat((4 - base64.length % 4) % 4);
+    var raw = window.atob((base64 + padding).replace(/-/g, '+').replace(/_/g, '/'));
+    var array = new Uint8Array(raw.length);
+    for (var i = 0; i < raw.length; ++i) {
+        array[i] = raw.charCodeAt(i);
+    }
+    return array;
+}
+
+CY.toggleNotify = function (e) {
+    e.preventDefault();
+    var notifylink = document.getElementById("notifylink");
+    var notifying = notifylink.dataset.notifying == "yes";
+    navigator.serviceWorker.register('/static/js/push.js').then(function (registration) {
+        return registration.pushManager.getSubscription().then(function (subscription) {
+            if (subscription != null) {
+                return subscription;
+            }
+            return registration.pushManager.subscribe({
+                userVisibleOnly: true,
+                applicationServerKey: CY.urlBase64ToUint8Array(window.rwtxt.push_key)
+            });
+        });
+    }).then(function (subscription) {
+        return fetch('/api/push?domain=' + encodeURIComponent(window.rwtxt.domain) + '&id=' + encodeURIComponent(window.rwtxt.file_id), {
+            method: notifying ? 'DELETE' : 'POST',
+            credentials: 'same-origin',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify(subscription)
+        });
+    }).then(function (response) {
+        return response.json();
+    }).then(function (data) {
+        if (data.message == "subscribed") {
+            CY.showNotifying(data.success);
+            if (data.success) {
+                localStorage.setItem("notify-" + window.rwtxt.file_id, "yes");
+            } else {
+                localStorage.removeItem("notify-" + window.rwtxt.file_id);
+            }
+        }
+    }).catch(function (err) {
+        alert("Could not turn on notifications: " + err);
+    });
+}
+
+CY.showNotifying = function (notifying) {
+    var notifylink = document.getElementById("notifylink");
+    notifylink.dataset.notifying = notifying ? "yes" : "no";
+    notifylink.innerText = notifying ? "🔕 Stop notifying me" : "🔔 Notify me of changes";
+}
+
+notifylink = document.getElementById("notifylink")
+if (notifylink != null && window.rwtxt.push_key != "" && 'serviceWorker' in navigator && 'PushManager' in window) {
+    CY.showNotifying(localStorage.getItem("notify-" + window.rwtxt.file_id) == "yes");
+    notifylink.style.display = "";
+    notifylink.addEventListener("click", CY.toggleNotify);
 }
 
 
@@ -199,6 +1187,11 @@ function onUploadFinished(file) {
     // // console.log("upload finished");
     // // console.log(file);
     this.removeFile(file);
+    if (file.status != Dropzone.SUCCESS) {
+        // like an upload that was rejected as malware
+        alert("Could not upload " + file.name + ": " + (file.xhr ? file.xhr.responseText.trim() : "no response"));
+        return;
+    }
     var cursorPos = document.getElementById("editable").selectionStart;
     var cursorEnd = document.getElementById("editable").selectionEnd;
     var v = document.getElementById("editable").value;
@@ -216,6 +1209,14 @@ function onUploadFinished(file) {
     var extraText = prefix+'['+file.xhr.getResponseHeader("Location").split('filename=')[1]+'](' +
         file.xhr.getResponseHeader("Location") +
         ')';
+    if (prefix == '!' && file.xhr.getResponseHeader("X-Original-Location")) {
+        // the photo was shrunk, and links to its full size
+        extraText = '[' + extraText + '](' + file.xhr.getResponseHeader("X-Original-Location") + ')';
+    }
+    if (file.name.toLowerCase().endsWith(".csv")) {
+        // shown as a table
+        extraText = '{{csv "' + file.xhr.getResponseHeader("Location") + '"}}';
+    }
 
     document.getElementById("editable").value = (
         textBefore +
@@ -250,7 +1251,9 @@ Dropzone.options.dropzoneForm = {
     }
 };
 
+// the socket is opened right away, so that viewers see who is editing
+socketCloseListener();
+
 if (window.rwtxt.editonly == "yes") {
-    socketCloseListener();
     showMessage();
 }
\ No newline at end of file
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// formatBytes shows a number of bytes in the largest unit that keeps it
+// above one
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// runStats is the "stats" command, which shows how much the database
+// keeps, by domain, for planning the capacity of the instance
+func runStats(args []string) (err error) {
+	set := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := set.Bool("json", false, "print JSON instead of tables")
+	top := set.Int("top", 10, "how many of the largest pages to list")
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "usage: rwtxt [-db rwtxt.db] stats [-json] [-top 10]")
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	// stat first, since opening a database that is not there makes it
+	info, err := os.Stat(dbName)
+	if err != nil {
+		return
+	}
+
+	fs, err = db.New(dbName)
+	if err != nil {
+		return
+	}
+	defer fs.Close()
+	stats, err := fs.Stats(*top)
+	if err != nil {
+		return
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	return printStats(os.Stdout, dbName, info.Size(), stats)
+}
+
+// printStats prints the stats as tables
+func printStats(out io.Writer, name string, size int64, stats db.Stats) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(out, "%s has %s, of which %d uploads have %s\n\n", name, formatBytes(size), stats.Uploads, formatBytes(stats.UploadBytes))
+
+	fmt.Fprintln(w, "DOMAIN\tPUBLIC\tPAGES\tARCHIVED\tTEXT\tREVISIONS\tUPLOADS\tUPLOAD SIZE\tKEYS\tVISITS\t")
+	for _, d := range stats.Domains {
+		public := "no"
+		if d.Public {
+			public = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%d\t%s\t%d\t%d\t\n", d.Name, public, d.Pages, d.Archived,
+			formatBytes(d.TextBytes), formatBytes(d.HistoryBytes), d.Uploads, formatBytes(d.UploadBytes), d.Keys, d.Visits)
+	}
+	w.Flush()
+
+	if len(stats.LargestPages) > 0 {
+		fmt.Fprintln(out, "\nlargest pages")
+		fmt.Fprintln(w, "PAGE\tTEXT\tREVISIONS\t")
+		for _, p := range stats.LargestPages {
+			page := p.Slug
+			if page == "" {
+				page = p.ID
+			}
+			fmt.Fprintf(w, "%s/%s\t%s\t%s\t\n", p.Domain, page, formatBytes(p.TextBytes), formatBytes(p.HistoryBytes))
+		}
+		w.Flush()
+	}
+
+	fmt.Fprintln(out, "\nleft over")
+	none := true
+	for _, o := range stats.Orphans {
+		if o.Rows == 0 {
+			continue
+		}
+		none = false
+		if o.Bytes > 0 {
+			fmt.Fprintf(w, "%s\t%d\t%s\t\n", o.What, o.Rows, formatBytes(o.Bytes))
+		} else {
+			fmt.Fprintf(w, "%s\t%d\t\t\n", o.What, o.Rows)
+		}
+	}
+	if none {
+		fmt.Fprintln(out, "nothing")
+	}
+	return w.Flush()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/llm"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+const (
+	// summaryWords is how many words a page has before it can be
+	// summarized
+	summaryWords = 300
+	// summarySentences is how many sentences a summary without a model
+	// has
+	summarySentences = 3
+	// summaryText is how much of a page the model reads
+	summaryText = 12000
+	// summaryExtract is the summarizer of summaries made of sentences of
+	// the page
+	summaryExtract = "extract"
+)
+
+// summaryPrompt tells the model how to summarize
+const summaryPrompt = `Summarize the note of the user in two or three sentences, in the language it is written in. Only say what the note says. Reply with just the summary.`
+
+// isLong is whether the page is long enough to be summarized
+func isLong(f db.File) bool {
+	return utils.WordCount(f.Data) >= summaryWords
+}
+
+// summarize summarizes the page with the model set with -llm, or with
+// the sentences of the page that say the most if there is none or it
+// fails
+func summarize(f db.File) (s db.Summary) {
+	s = db.Summary{FileID: f.ID, Hash: textHash(f.Data)}
+	if answerer != nil {
+		text, err := answerer.Chat([]llm.Message{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: truncateText(f.Data, summaryText)},
+		})
+		if err == nil && text != "" {
+			s.Text, s.Summarizer = text, answerer.Model
+			return
+		}
+		log.Warn(err)
+	}
+	s.Text, s.Summarizer = utils.Summarize(f.Data, summarySentences), summaryExtract
+	return
+}
+
+// handleSummarize summarizes a long page, to show the summary above it
+// and in search results
+func (tr *TemplateRender) handleSummarize(w http.ResponseWriter, r *http.Request) (err error) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/"+tr.Domain+"/"+tr.Page, 302)
+		return
+	}
+	if !tr.SignedIn && tr.Domain != "public" {
+		return tr.handleMain(w, r, "need to log in to summarize")
+	}
+	if isBlocked(r) {
+		http.Error(w, "blocked", http.StatusForbidden)
+		return
+	}
+	f, err := tr.getReadableFile(tr.Page)
+	if err != nil {
+		return tr.handleMain(w, r, err.Error())
+	}
+	if !isLong(f) {
+		return tr.handleMain(w, r, "the page is too short to summarize")
+	}
+	if err = fs.SetSummary(summarize(f)); err != nil {
+		return
+	}
+	http.Redirect(w, r, "/"+tr.Domain+"/"+f.ID, 302)
+	return
+}
+
+// summaries returns the summaries of the files that have one, by id
+func summaries(files []db.File) map[string]db.Summary {
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = f.ID
+	}
+	found, err := fs.GetSummaries(ids)
+	if err != nil {
+		log.Error(err)
+	}
+	return found
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// remindersSetting is the setting that keeps the last day that
+// reminders were sent
+const remindersSetting = "reminders-sent"
+
+// Deadlines are the tasks of a domain that are not done, by when they
+// are due
+type Deadlines struct {
+	Overdue  []db.DueTask
+	Today    []db.DueTask
+	Upcoming []db.DueTask
+}
+
+// readableTasks returns the tasks of the domain that are not done, in
+// the pages that the editor may read
+func readableTasks(domain, editor string) (tasks []db.DueTask, err error) {
+	all, err := fs.GetDueTasks(domain, time.Time{})
+	if err != nil || len(all) == 0 {
+		return
+	}
+	files, err := fs.GetAll(domain)
+	if err != nil {
+		return
+	}
+	canRead := make(map[string]bool)
+	for _, f := range readable(files, editor) {
+		canRead[f.ID] = true
+	}
+	for _, t := range all {
+		if canRead[t.FileID] {
+			tasks = append(tasks, t)
+		}
+	}
+	return
+}
+
+// deadlines returns the tasks of the domain that are not done, in the
+// pages that the editor may read, by when they are due
+func deadlines(domain, editor string) (d Deadlines, err error) {
+	tasks, err := readableTasks(domain, editor)
+	if err != nil {
+		return
+	}
+	today := time.Now().Format("2006-01-02")
+	for _, t := range tasks {
+		switch due := t.Due.Format("2006-01-02"); {
+		case due < today:
+			d.Overdue = append(d.Overdue, t)
+		case due == today:
+			d.Today = append(d.Today, t)
+		default:
+			d.Upcoming = append(d.Upcoming, t)
+		}
+	}
+	return
+}
+
+// handleTasks shows the tasks of the domain that are not done yet, by
+// when they are due
+func (tr *TemplateRender) handleTasks(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
+	if !tr.SignedIn && !ispublic {
+		return tr.handleMain(w, r, "need to log in to see tasks")
+	}
+
+	d, err := deadlines(tr.Domain, tr.EditorID)
+	if err != nil {
+		return
+	}
+	tr.Deadlines = &d
+	tr.Title = tr.Domain + "/tasks"
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return tasksTemplate.Execute(gz, tr)
+}
+
+// runReminders notifies the browsers subscribed to pages of the tasks
+// that are due that day, once a day, in the domains that want reminders
+func runReminders() {
+	for {
+		sendReminders(time.Now())
+		time.Sleep(time.Hour)
+	}
+}
+
+func sendReminders(now time.Time) {
+	if vapidKey == nil {
+		return
+	}
+	today := now.Format("2006-01-02")
+	if sent, err := fs.Setting(remindersSetting); err != nil || sent == today {
+		return
+	}
+	if err := fs.SetSetting(remindersSetting, today); err != nil {
+		log.Error(err)
+		return
+	}
+	tasks, err := fs.GetDueTasks("", now.AddDate(0, 0, 1))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	wants := make(map[string]bool)
+	for _, t := range tasks {
+		if t.Due.Format("2006-01-02") != today {
+			continue
+		}
+		if _, ok := wants[t.Domain]; !ok {
+			options, _ := fs.GetDomainOptions(t.Domain)
+			wants[t.Domain] = options.Reminders
+		}
+		if !wants[t.Domain] {
+			continue
+		}
+		subscriptions, err := fs.GetSubscriptions(t.FileID)
+		if err != nil || len(subscriptions) == 0 {
+			continue
+		}
+		payload, _ := json.Marshal(pushNotification{
+			Title: "Due today: " + t.Text,
+			Body:  "in " + t.Page().DisplayName(),
+			URL:   "/" + t.Domain + "/" + t.FileID,
+		})
+		for _, s := range subscriptions {
+			err = sendPush(s, payload)
+			if err == errPushGone {
+				err = fs.Unsubscribe("", s.Endpoint)
+			}
+			if err != nil {
+				log.Debugf("push to %s: %s", s.Endpoint, err)
+			}
+		}
+	}
+}
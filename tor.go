@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// torMode serves rwtxt as an onion service: nothing makes browsers load
+// or hint at other sites, pages are read without JavaScript and the
+// server itself does not reach out to the sites that pages link to
+var torMode bool
+
+// onionAddress is the .onion address of the site, which Tor Browser is
+// told to switch to when it opens the site at its other address
+var onionAddress string
+
+// torContentSecurity keeps pages to their own site, and scriptless
+// pages to no scripts at all
+const (
+	torContentSecurity           = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data: blob:; media-src 'self' blob:; frame-src 'self'; connect-src 'self'; form-action 'self'; base-uri 'self'"
+	torScriptlessContentSecurity = "default-src 'self'; script-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; media-src 'self'; frame-src 'none'; connect-src 'none'; form-action 'self'; base-uri 'self'"
+)
+
+// setOnionHeaders sets the headers of the tor profile on a response
+func setOnionHeaders(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if onionAddress != "" && !strings.EqualFold(host, onionAddress) {
+		w.Header().Set("Onion-Location", "http://"+onionAddress+r.URL.RequestURI())
+	}
+	if torMode {
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Security-Policy", torContentSecurity)
+	}
+}
+
+// scriptless returns whether the page is shown without any scripts,
+// which in the tor profile is the reader view and every page that the
+// visitor can only read
+func scriptless(tr *TemplateRender, r *http.Request) bool {
+	if !torMode {
+		return false
+	}
+	canEdit := (tr.SignedIn || tr.Domain == "public") && tr.CanWrite
+	return r.URL.Query().Get("view") == "reader" || !canEdit
+}
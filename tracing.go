@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer makes the spans of requests, websocket messages and database
+// calls. Until tracing is set up with -otlp they are not recorded.
+var tracer = otel.Tracer("github.com/schollz/rwtxt")
+
+// tracing is whether spans are sent to an OTLP collector
+var tracing bool
+
+// setupTracing sends spans to the OTLP/HTTP endpoint, like
+// http://localhost:4318, keeping the traces of the fraction sample of the
+// requests that are not part of a trace already. The OTEL_EXPORTER_OTLP_*
+// variables set the rest, like headers with keys.
+func setupTracing(endpoint string, sample float64) (shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+	if endpoint == "" {
+		return
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		err = errors.Wrap(err, "could not set up tracing")
+		return
+	}
+	version := Version
+	if version == "" {
+		version = "dev"
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sample))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "rwtxt"),
+			attribute.String("service.version", version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	tracer = provider.Tracer("github.com/schollz/rwtxt")
+	tracing = true
+	return provider.Shutdown, nil
+}
+
+// statusRecorder keeps the status of a response for its span. Websockets
+// take over the connection, so it can be hijacked.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can not be taken over")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// traceRequests makes a span of each request, continuing the trace of
+// the request if it has one
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := requestRoute(r.URL.Path)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", clientIP(r)),
+				attribute.String("user_agent.original", r.UserAgent()),
+			),
+		)
+		defer span.End()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// routes are the paths that are named as they are in spans
+var routes = map[string]bool{
+	"/": true, "/login": true, "/ws": true, "/metrics": true, "/logout": true,
+	"/replica": true, "/graphql": true, "/oembed": true, "/duplicates": true, "/search": true,
+	"/moderation": true, "/upload": true, "/robots.txt": true, "/favicon.ico": true, "/sitemap.xml": true,
+}
+
+// requestRoute names the kind of request for its span, with the domain,
+// page and upload left out so that there are not a span name for each
+func requestRoute(path string) string {
+	if routes[path] {
+		return path
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	switch {
+	case parts[0] == "static":
+		return "/static/{file}"
+	case parts[0] == "uploads":
+		return "/uploads/{id}"
+	case strings.HasPrefix(path, "/api/v1/"):
+		v1 := strings.Split(strings.TrimPrefix(path, "/api/v1/"), "/")
+		return "/api/v1/{domain}/" + v1[len(v1)-1]
+	case parts[0] == "api":
+		return path
+	case len(parts) == 1 || parts[1] == "":
+		return "/{domain}"
+	case reservedPages[parts[1]]:
+		return "/{domain}/" + parts[1]
+	}
+	if _, action := splitPageAction(parts[1]); action != "" {
+		return "/{domain}/{page}/" + action
+	}
+	return "/{domain}/{page}"
+}
+
+// traceError marks the span of a request as failed with the error
+func traceError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// traceDB starts a span of a call to the database, which is ended with
+// its error
+func traceDB(ctx context.Context, call string) (end func(error)) {
+	_, span := tracer.Start(ctx, "db "+call,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.operation", call),
+		),
+	)
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// websocketMessage names a message of an editor for its span
+func websocketMessage(p Payload) string {
+	switch p.Message {
+	case "watch", "expand", "merge":
+		return p.Message
+	}
+	if p.ID != "" {
+		return "edit"
+	}
+	return "message"
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+	"github.com/schollz/versionedtext"
+)
+
+// domainArchiveFormat marks the archives of whole domains, which are zip
+// files with a domain.json, the pages in pages/<id>.json and the uploads
+// in uploads/<id>
+const domainArchiveFormat = "rwtxt-domain"
+const domainArchiveVersion = 1
+
+// transferKeyLifetime is how long a transfer key can be used
+const transferKeyLifetime = time.Hour
+
+var transferClient = &http.Client{
+	Timeout:       10 * time.Minute,
+	Transport:     fetchClient.Transport,
+	CheckRedirect: fetchClient.CheckRedirect,
+}
+
+// uploadLink matches the links to uploads in pages
+var uploadLink = regexp.MustCompile(`/uploads/(sha256-[0-9a-f]{64})`)
+
+// DomainArchive describes the domain in an archive. Keys are not
+// archived, so everyone signs in again after a domain is moved.
+type DomainArchive struct {
+	Format   string           `json:"format"`
+	Version  int              `json:"version"`
+	Domain   string           `json:"domain"`
+	Public   bool             `json:"public"`
+	Options  db.DomainOptions `json:"options"`
+	Pinned   []string         `json:"pinned,omitempty"`
+	Uploads  []ArchiveUpload  `json:"uploads,omitempty"`
+	Exported time.Time        `json:"exported"`
+}
+
+// ArchiveUpload is an upload that pages of the domain link to
+type ArchiveUpload struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ArchivePage is a page with all its revisions
+type ArchivePage struct {
+	ID       string                      `json:"id"`
+	Slug     string                      `json:"slug"`
+	Created  time.Time                   `json:"created"`
+	Modified time.Time                   `json:"modified"`
+	Data     string                      `json:"data"`
+	History  versionedtext.VersionedText `json:"history"`
+	Views    int                         `json:"views"`
+	Archived bool                        `json:"archived,omitempty"`
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) (err error) {
+	w, err := zw.Create(name)
+	if err != nil {
+		return
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+// writeDomainArchive writes the pages of the domain that the editor may
+// read, their revisions and uploads, and the settings of the domain as a
+// zip file
+func writeDomainArchive(w io.Writer, domain, editor string) (err error) {
+	archive := DomainArchive{
+		Format:   domainArchiveFormat,
+		Version:  domainArchiveVersion,
+		Domain:   domain,
+		Exported: time.Now().UTC(),
+	}
+	_, archive.Public, err = fs.GetDomainFromName(domain)
+	if err != nil {
+		return
+	}
+	archive.Options, err = fs.GetDomainOptions(domain)
+	if err != nil {
+		return
+	}
+	// the keys and secrets of the domain stay with it, since everyone who
+	// may download the archive would learn them
+	archive.Options.TransferKey, archive.Options.TransferKeyExpires = "", time.Time{}
+	archive.Options.Webhooks, archive.Options.WebhookSecret = nil, ""
+	pinned, err := fs.GetPinned(domain)
+	if err != nil {
+		return
+	}
+	for _, f := range readable(pinned, editor) {
+		archive.Pinned = append(archive.Pinned, f.ID)
+	}
+	files, err := fs.GetAllWithPrefix(domain, "", true)
+	if err != nil {
+		return
+	}
+	files = readable(files, editor)
+
+	zw := zip.NewWriter(w)
+	uploads := make(map[string]bool)
+	for _, f := range files {
+		err = writeZipJSON(zw, "pages/"+f.ID+".json", ArchivePage{
+			ID:       f.ID,
+			Slug:     f.Slug,
+			Created:  f.Created,
+			Modified: f.Modified,
+			Data:     f.Data,
+			History:  f.History,
+			Views:    f.Views,
+			Archived: f.Archived,
+		})
+		if err != nil {
+			return
+		}
+		for _, match := range uploadLink.FindAllStringSubmatch(f.Data, -1) {
+			if uploads[match[1]] {
+				continue
+			}
+			uploads[match[1]] = true
+			name, data, errBlob := readBlob(match[1])
+			if errBlob != nil {
+				log.Debugf("could not archive upload %s: %v", match[1], errBlob)
+				continue
+			}
+			var uw io.Writer
+			uw, err = zw.Create("uploads/" + match[1])
+			if err != nil {
+				return
+			}
+			if _, err = uw.Write(data); err != nil {
+				return
+			}
+			archive.Uploads = append(archive.Uploads, ArchiveUpload{ID: match[1], Name: name})
+		}
+	}
+	if err = writeZipJSON(zw, "domain.json", archive); err != nil {
+		return
+	}
+	return zw.Close()
+}
+
+// isDomainArchive returns whether the zip file is an archive of a domain
+// rather than another export
+func isDomainArchive(r io.ReaderAt, size int64) bool {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return false
+	}
+	for _, zf := range zr.File {
+		if zf.Name == "domain.json" {
+			return true
+		}
+	}
+	return false
+}
+
+func readZipFile(zf *zip.File, limit int64) (b []byte, err error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	b, err = ioutil.ReadAll(io.LimitReader(rc, limit+1))
+	if err == nil && int64(len(b)) > limit {
+		err = errors.New(zf.Name + " is too large")
+	}
+	return
+}
+
+// importDomainArchive imports an archive into the domain. Pages that the
+// domain already has are kept if they changed after the archived ones.
+// A domain without pages also takes the settings of the archived domain.
+// Pages that the editor may not change are left as they are.
+func importDomainArchive(domain, editor string, r io.ReaderAt, size int64) (imported int, err error) {
+	defer forgetDomain(domain)
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return
+	}
+	var archive DomainArchive
+	zipFiles := make(map[string]*zip.File)
+	for _, zf := range zr.File {
+		zipFiles[zf.Name] = zf
+	}
+	if zipFiles["domain.json"] == nil {
+		return 0, errors.New("not an archive of a domain")
+	}
+	b, err := readZipFile(zipFiles["domain.json"], limits.MaxRequestBody)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &archive); err != nil {
+		return 0, errors.Wrap(err, "could not read domain.json")
+	}
+	if archive.Format != domainArchiveFormat || archive.Version > domainArchiveVersion {
+		return 0, errors.New("not an archive of a domain that this version can import")
+	}
+	existing, err := fs.GetAllWithPrefix(domain, "", true)
+	if err != nil {
+		return
+	}
+
+	// uploads are named by their content, so that they can be saved again
+	uploadIDs := make(map[string]string)
+	for _, upload := range archive.Uploads {
+		zf := zipFiles["uploads/"+upload.ID]
+		if zf == nil {
+			continue
+		}
+		var data []byte
+		if data, err = readZipFile(zf, limits.MaxRequestBody); err != nil {
+			return
+		}
+		var link string
+		if link, err = saveUpload(upload.Name, bytes.NewReader(data)); err != nil {
+			return
+		}
+		uploadIDs[upload.ID] = strings.TrimPrefix(strings.SplitN(link, "?", 2)[0], "/uploads/")
+	}
+
+	pageIDs := make(map[string]string)
+	for name, zf := range zipFiles {
+		if !strings.HasPrefix(name, "pages/") || path.Ext(name) != ".json" {
+			continue
+		}
+		if b, err = readZipFile(zf, limits.MaxImportSize); err != nil {
+			return
+		}
+		var page ArchivePage
+		if err = json.Unmarshal(b, &page); err != nil {
+			return imported, errors.Wrap(err, "could not read "+name)
+		}
+		data := page.Data
+		for old, id := range uploadIDs {
+			if old != id {
+				data = strings.Replace(data, "/uploads/"+old, "/uploads/"+id, -1)
+			}
+		}
+		if page.ID == "" {
+			page.ID = utils.UUID()
+		}
+		id, ok, errImport := fs.ImportFile(db.File{
+			ID:       page.ID,
+			Slug:     page.Slug,
+			Created:  page.Created,
+			Modified: page.Modified,
+			Data:     data,
+			Domain:   domain,
+			Editor:   editor,
+			History:  page.History,
+			Views:    page.Views,
+			Archived: page.Archived,
+		})
+		if errImport == db.ErrReadOnly || errImport == db.ErrNoAccess {
+			log.Debugf("not importing over %s/%s: %s", domain, page.ID, errImport)
+		} else if errImport != nil {
+			return imported, errImport
+		}
+		pageIDs[page.ID] = id
+		if ok {
+			imported++
+		}
+	}
+	for _, id := range archive.Pinned {
+		if pageIDs[id] != "" {
+			if err = fs.Pin(domain, pageIDs[id]); err != nil {
+				return
+			}
+		}
+	}
+
+	if len(existing) == 0 {
+		// the webhooks and scripts of an archive could send the pages
+		// anywhere, so they are turned on again by hand
+		options, _ := fs.GetDomainOptions(domain)
+		archive.Options.TransferKey, archive.Options.TransferKeyExpires = options.TransferKey, options.TransferKeyExpires
+		archive.Options.Webhooks, archive.Options.WebhookSecret = options.Webhooks, options.WebhookSecret
+		archive.Options.Scripts = options.Scripts
+		if err = fs.SetDomainOptions(domain, archive.Options); err != nil {
+			return
+		}
+		err = fs.UpdateDomain(domain, "", archive.Public)
+	}
+	return
+}
+
+// newTransferKey makes a key that lets another instance copy a domain
+func newTransferKey() (key string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		err = errors.Wrap(err, "could not make a transfer key")
+		return
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// transferKeyValid returns whether the request has the transfer key of
+// the domain
+func transferKeyValid(r *http.Request, domain string) bool {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if key == "" || key == r.Header.Get("Authorization") {
+		return false
+	}
+	options, err := fs.GetDomainOptions(domain)
+	return err == nil && options.ActiveTransferKey() != "" &&
+		subtle.ConstantTimeCompare([]byte(key), []byte(options.ActiveTransferKey())) == 1
+}
+
+// handleAPIArchive downloads the archive of a domain, for someone signed
+// in to it or for another instance with its transfer key. Pages that
+// only their owner may read are left out, unless the owner asks.
+func handleAPIArchive(w http.ResponseWriter, r *http.Request, domain string) (err error) {
+	if !transferKeyValid(r, domain) && !apiSignedIn(w, r, domain) {
+		return writeJSON(w, http.StatusForbidden, Payload{Message: "need to be logged in"})
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+domain+`.rwtxt.zip"`)
+	return writeDomainArchive(w, domain, requestEditorID(r))
+}
+
+// handleTransfer makes a transfer key for the domain, or copies a domain
+// from another instance with its transfer key
+func (tr *TemplateRender) handleTransfer(w http.ResponseWriter, r *http.Request) (err error) {
+	if !tr.SignedIn || tr.Domain == "public" {
+		return tr.handleMain(w, r, "need to log in to transfer")
+	}
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/"+tr.Domain, 302)
+		return
+	}
+	if r.FormValue("action") == "key" {
+		var options db.DomainOptions
+		options, err = fs.GetDomainOptions(tr.Domain)
+		if err != nil {
+			return
+		}
+		options.TransferKey, err = newTransferKey()
+		if err != nil {
+			return
+		}
+		options.TransferKeyExpires = time.Now().Add(transferKeyLifetime)
+		if err = fs.SetDomainOptions(tr.Domain, options); err != nil {
+			return
+		}
+		return tr.handleMain(w, r, "made a transfer key")
+	}
+
+	imported, err := pullDomain(tr.Domain, tr.EditorID, strings.TrimSpace(r.FormValue("source")), strings.TrimSpace(r.FormValue("key")))
+	if err != nil {
+		log.Warn(err)
+		return tr.handleMain(w, r, "could not transfer: "+err.Error())
+	}
+	log.Debugf("transferred %d pages into %s", imported, tr.Domain)
+	http.Redirect(w, r, treeLink(tr.Domain, ""), 302)
+	return nil
+}
+
+// parseDomainAddress returns the instance and the name of the domain of
+// an address like "https://notes.example.com/mydomain"
+func parseDomainAddress(source string) (u *url.URL, domain string, err error) {
+	u, err = url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || strings.Trim(u.Path, "/") == "" {
+		return nil, "", errors.New("give the address of the domain, like https://notes.example.com/mydomain")
+	}
+	return u, strings.ToLower(strings.Split(strings.Trim(u.Path, "/"), "/")[0]), nil
+}
+
+// pullDomain copies a domain of another instance, given by its address
+// like "https://notes.example.com/mydomain", into the domain
+func pullDomain(domain, editor, source, key string) (imported int, err error) {
+	u, remoteDomain, err := parseDomainAddress(source)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("GET", u.Scheme+"://"+u.Host+"/api/v1/"+url.PathEscape(remoteDomain)+"/archive", nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("User-Agent", "rwtxt/"+Version)
+	resp, err := transferClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("%s answered %s", u.Host, resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile("", "rwtxt-transfer")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, io.LimitReader(resp.Body, limits.MaxImportSize+1))
+	if err != nil {
+		return
+	}
+	if size > limits.MaxImportSize {
+		return 0, errors.New("the domain is larger than an import can be")
+	}
+	return importDomainArchive(domain, editor, tmp, size)
+}
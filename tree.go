@@ -0,0 +1,160 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// Breadcrumb is one folder on the way to a nested page
+type Breadcrumb struct {
+	Name string
+	Link string
+}
+
+// TreeNode is a folder and/or page in the slug hierarchy
+type TreeNode struct {
+	Name       string
+	Prefix     string
+	Link       string
+	FolderLink string
+	File       *db.File
+	Children   []*TreeNode
+	NumPages   int
+
+	children map[string]*TreeNode
+}
+
+// cleanSlugPath removes empty path elements so that
+// "/projects//alpha/" becomes "projects/alpha"
+func cleanSlugPath(s string) string {
+	parts := []string{}
+	for _, part := range strings.Split(strings.TrimSpace(s), "/") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// slugFolders returns the folders that a slug is nested in
+func slugFolders(slug string) (folders []string) {
+	parts := strings.Split(cleanSlugPath(slug), "/")
+	for i := 1; i < len(parts); i++ {
+		folders = append(folders, strings.Join(parts[:i], "/"))
+	}
+	return
+}
+
+func treeLink(domain, prefix string) string {
+	if prefix == "" {
+		return "/" + domain + "/tree"
+	}
+	return "/" + domain + "/tree?prefix=" + url.QueryEscape(prefix)
+}
+
+// breadcrumbs returns links to every folder that contains the slug
+func breadcrumbs(domain, slug string) (crumbs []Breadcrumb) {
+	for _, folder := range slugFolders(slug) {
+		crumbs = append(crumbs, Breadcrumb{
+			Name: folder[strings.LastIndex(folder, "/")+1:],
+			Link: treeLink(domain, folder),
+		})
+	}
+	return
+}
+
+// buildTree groups files into folders by the slash-separated parts of
+// their slugs, starting beneath prefix
+func buildTree(domain, prefix string, files []db.File) (root *TreeNode) {
+	prefix = cleanSlugPath(prefix)
+	root = &TreeNode{Name: prefix, Prefix: prefix, FolderLink: treeLink(domain, prefix)}
+	for i := range files {
+		name := files[i].Slug
+		if name == "" {
+			name = files[i].ID
+		}
+		name = cleanSlugPath(name)
+		if prefix != "" {
+			if !strings.HasPrefix(name, prefix+"/") {
+				continue
+			}
+			name = strings.TrimPrefix(name, prefix+"/")
+		}
+
+		node := root
+		parts := strings.Split(name, "/")
+		for j, part := range parts {
+			node.NumPages++
+			if node.children == nil {
+				node.children = make(map[string]*TreeNode)
+			}
+			child, ok := node.children[part]
+			if !ok {
+				childPrefix := strings.Join(parts[:j+1], "/")
+				if prefix != "" {
+					childPrefix = prefix + "/" + childPrefix
+				}
+				child = &TreeNode{
+					Name:       part,
+					Prefix:     childPrefix,
+					FolderLink: treeLink(domain, childPrefix),
+				}
+				node.children[part] = child
+				node.Children = append(node.Children, child)
+			}
+			node = child
+		}
+		node.File = &files[i]
+		node.Link = "/" + domain + "/" + node.Prefix
+	}
+	root.sort()
+	return
+}
+
+// sort orders folders before pages, and then by name
+func (n *TreeNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		iFolder, jFolder := len(n.Children[i].Children) > 0, len(n.Children[j].Children) > 0
+		if iFolder != jFolder {
+			return iFolder
+		}
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, child := range n.Children {
+		child.sort()
+	}
+}
+
+func (tr *TemplateRender) handleTree(w http.ResponseWriter, r *http.Request) (err error) {
+	_, ispublic, _ := fs.GetDomainFromName(tr.Domain)
+	if !tr.SignedIn && !ispublic {
+		return tr.handleMain(w, r, "need to log in to browse")
+	}
+
+	tr.Prefix = cleanSlugPath(r.URL.Query().Get("prefix"))
+	tr.IncludeArchived = r.URL.Query().Get("archived") == "1"
+	files, err := fs.GetAllWithPrefix(tr.Domain, tr.Prefix, tr.IncludeArchived)
+	if err != nil {
+		return
+	}
+	files = readable(files, tr.EditorID)
+	for i := range files {
+		files[i].Data = ""
+	}
+	tr.Tree = buildTree(tr.Domain, tr.Prefix, files)
+	tr.Breadcrumbs = breadcrumbs(tr.Domain, tr.Prefix)
+	tr.NumResults = tr.Tree.NumPages
+	tr.Title = tr.Domain + "/" + tr.Prefix
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	return treeTemplate.Execute(gz, tr)
+}
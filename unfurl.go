@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/db"
+	"golang.org/x/net/html"
+)
+
+// unfurlMaxAge is how long a link preview is used before it is fetched again
+const unfurlMaxAge = 7 * 24 * time.Hour
+
+// bareLink matches a paragraph that is only a link
+var bareLink = regexp.MustCompile(`<p><a href="([^"]+)"[^>]*>([^<]+)</a></p>`)
+
+var unfurlQueue = make(chan string, 100)
+
+// unfurling are the links that are queued to be fetched
+var unfurling = struct {
+	sync.Mutex
+	links map[string]bool
+}{links: make(map[string]bool)}
+
+// unfurlLinks replaces the links that are alone in a paragraph, and show
+// their own address, with a preview of the page they link to. Previews
+// are fetched in the background, so links stay plain until then. The
+// previews have the rel of the links.
+func unfurlLinks(rendered template.HTML, rel string) template.HTML {
+	return template.HTML(bareLink.ReplaceAllStringFunc(string(rendered), func(paragraph string) string {
+		match := bareLink.FindStringSubmatch(paragraph)
+		link := html.UnescapeString(match[1])
+		if html.UnescapeString(match[2]) != link || !isWebLink(link) {
+			return paragraph
+		}
+		u, found, err := fs.GetUnfurl(link)
+		if err != nil {
+			log.Debug(err)
+			return paragraph
+		}
+		if !found || time.Since(u.Fetched) > unfurlMaxAge {
+			queueUnfurl(link)
+		}
+		if !found || u.Title == "" {
+			return paragraph
+		}
+		return unfurlCard(u, rel)
+	}))
+}
+
+func isWebLink(link string) bool {
+	return strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://")
+}
+
+func unfurlCard(u db.Unfurl, rel string) string {
+	var b strings.Builder
+	b.WriteString(`<p><a class="unfurl" href="` + html.EscapeString(u.URL) + `" rel="` + rel + `">`)
+	if isWebLink(u.Icon) {
+		b.WriteString(`<img class="unfurl-icon" src="` + html.EscapeString(u.Icon) + `" alt="">`)
+	}
+	b.WriteString(`<strong>` + html.EscapeString(u.Title) + `</strong>`)
+	if u.Description != "" {
+		b.WriteString(`<br><span class="unfurl-description">` + html.EscapeString(u.Description) + `</span>`)
+	}
+	if parsed, err := url.Parse(u.URL); err == nil {
+		b.WriteString(`<br><small class="grayed">` + html.EscapeString(parsed.Host) + `</small>`)
+	}
+	b.WriteString(`</a></p>`)
+	return b.String()
+}
+
+func queueUnfurl(link string) {
+	unfurling.Lock()
+	defer unfurling.Unlock()
+	if unfurling.links[link] {
+		return
+	}
+	select {
+	case unfurlQueue <- link:
+		unfurling.links[link] = true
+	default:
+		// the queue is full, the link is tried again on the next view
+	}
+}
+
+// runUnfurler fetches the queued links one at a time. Links that can not
+// be fetched are cached without a title, so they are not tried again on
+// every view.
+func runUnfurler() {
+	for link := range unfurlQueue {
+		u := fetchUnfurl(link)
+		if err := fs.SaveUnfurl(u); err != nil {
+			log.Error(err)
+		}
+		unfurling.Lock()
+		delete(unfurling.links, link)
+		unfurling.Unlock()
+	}
+}
+
+func fetchUnfurl(link string) (u db.Unfurl) {
+	u = db.Unfurl{URL: link, Fetched: time.Now()}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	b, page, err := fetch(ctx, link)
+	if err != nil {
+		log.Debugf("could not unfurl %s: %s", link, err)
+		return
+	}
+	root, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+
+	icon := "/favicon.ico"
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				if u.Title == "" && n.FirstChild != nil {
+					u.Title = n.FirstChild.Data
+				}
+			case "meta":
+				property, content := htmlAttr(n, "property")+htmlAttr(n, "name"), htmlAttr(n, "content")
+				switch property {
+				case "og:title":
+					u.Title = content
+				case "og:description", "description":
+					if u.Description == "" || property == "og:description" {
+						u.Description = content
+					}
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(htmlAttr(n, "rel"))) {
+					if rel == "icon" && htmlAttr(n, "href") != "" {
+						icon = htmlAttr(n, "href")
+					}
+				}
+			case "body":
+				// everything needed is in the head
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	u.Title = truncate(strings.Join(strings.Fields(u.Title), " "), 120)
+	u.Description = truncate(strings.Join(strings.Fields(u.Description), " "), 200)
+	if ref, err := url.Parse(icon); err == nil {
+		u.Icon = page.ResolveReference(ref).String()
+	}
+	return
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// truncate shortens text to at most max runes
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/schollz/rwtxt/src/scan"
+)
+
+// uploadScanner checks uploads for malware before they are saved, if
+// -clamd or -scan-command is set
+var uploadScanner scan.Scanner
+
+// setUploadScanner scans uploads with clamd at its socket, or else with
+// the command
+func setUploadScanner(clamd, command string, timeout time.Duration) {
+	if clamd != "" {
+		uploadScanner = scan.Clamd{Address: clamd, Timeout: timeout}
+	} else if fields := strings.Fields(command); len(fields) > 0 {
+		uploadScanner = scan.Command{Command: fields, Timeout: timeout}
+	}
+}
+
+// scanUpload returns the name of the malware found in an upload, or "".
+// Uploads that can not be scanned are an error, so that they are not
+// saved unchecked while the scanner is down.
+func scanUpload(name string, data []byte) (threat string, err error) {
+	if uploadScanner == nil {
+		return
+	}
+	threat, err = uploadScanner.Scan(name, data)
+	if err != nil {
+		log.Errorf("could not scan %s: %s", name, err)
+	} else if threat != "" {
+		log.Infof("rejected %s: %s", name, threat)
+	}
+	return
+}
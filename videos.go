@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/schollz/rwtxt/src/db"
+	"github.com/schollz/rwtxt/src/utils"
+)
+
+// videoPlaceholder is shown in a player that is clicked to load the
+// video, so that the site of the video knows nothing of readers who do
+// not watch it
+const videoPlaceholder = `<style>html,body{height:100%%;margin:0}body{display:flex;align-items:center;justify-content:center;background:#111;font-family:sans-serif}a{color:#fff;text-decoration:none;text-align:center;font-size:1.2em}span{font-size:3em}</style><a href="%s"><span>▶</span><br>Play video from %s</a>`
+
+// peerTubeInstances are the hosts of the PeerTube instances whose videos
+// are shown as players, since anyone can run an instance
+var peerTubeInstances = make(map[string]bool)
+
+// setPeerTubeInstances reads the instances of -peertube, which are
+// separated by commas
+func setPeerTubeInstances(instances string) {
+	for _, instance := range strings.Split(instances, ",") {
+		instance = strings.ToLower(strings.TrimSpace(instance))
+		if u, err := url.Parse(instance); err == nil && u.Host != "" {
+			instance = u.Host
+		}
+		if instance = strings.TrimSuffix(instance, "/"); instance != "" {
+			peerTubeInstances[instance] = true
+		}
+	}
+}
+
+// embedVideos replaces links to videos that are on a line of their own
+// with players, as the domain chose. The players are sandboxed, so that
+// they can not open popups or navigate the page.
+func embedVideos(rendered, videos string) string {
+	if videos == db.VideosLink {
+		return rendered
+	}
+	return bareLink.ReplaceAllStringFunc(rendered, func(paragraph string) string {
+		match := bareLink.FindStringSubmatch(paragraph)
+		embed := utils.VideoEmbed(html.UnescapeString(match[1]), peerTubeInstances)
+		if embed == "" {
+			return paragraph
+		}
+		player := `<iframe src="` + html.EscapeString(embed) + `" sandbox="allow-scripts allow-same-origin" allow="autoplay; fullscreen; picture-in-picture" allowfullscreen`
+		if videos == db.VideosClickToLoad {
+			u, _ := url.Parse(embed)
+			query := u.Query()
+			query.Set("autoplay", "1")
+			u.RawQuery = query.Encode()
+			placeholder := fmt.Sprintf(videoPlaceholder, html.EscapeString(u.String()), html.EscapeString(u.Hostname()))
+			player += ` srcdoc="` + html.EscapeString(placeholder) + `"`
+		} else {
+			player += ` loading="lazy"`
+		}
+		return `<div class="video">` + player + `></iframe></div>`
+	})
+}
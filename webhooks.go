@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkg/errors"
+	"github.com/schollz/rwtxt/src/db"
+)
+
+// webhookDelay is how long a page has to stay unchanged before its
+// webhooks are told, since the editor saves while someone types
+var webhookDelay = 10 * time.Second
+
+// webhookTimers wait for the pages that were saved to stay unchanged
+var webhookTimers = struct {
+	sync.Mutex
+	pages map[string]*time.Timer
+}{pages: make(map[string]*time.Timer)}
+
+// WebhookEvent is what is sent to the webhooks of a domain when one of
+// its pages is saved
+type WebhookEvent struct {
+	Event    string    `json:"event"`
+	Domain   string    `json:"domain"`
+	ID       string    `json:"id"`
+	Slug     string    `json:"slug"`
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Modified time.Time `json:"modified"`
+	Data     string    `json:"data"`
+}
+
+// newWebhookSecret makes the key that signs what is sent to the webhooks
+// of a domain
+func newWebhookSecret() (secret string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		err = errors.Wrap(err, "could not make a webhook secret")
+		return
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// webhookSaved tells the webhooks of the domain of a saved page about
+// it, once it has not changed for webhookDelay
+func webhookSaved(f db.File) {
+	options, err := fs.GetDomainOptions(f.Domain)
+	if err != nil || len(options.Webhooks) == 0 {
+		return
+	}
+	webhookTimers.Lock()
+	defer webhookTimers.Unlock()
+	if timer := webhookTimers.pages[f.ID]; timer != nil {
+		timer.Stop()
+	}
+	webhookTimers.pages[f.ID] = time.AfterFunc(webhookDelay, func() {
+		webhookTimers.Lock()
+		delete(webhookTimers.pages, f.ID)
+		webhookTimers.Unlock()
+		sendWebhooks(f)
+	})
+}
+
+// sendWebhooks posts the page to the webhooks of its domain, signed
+// with the secret of the domain in the X-Rwtxt-Signature header
+func sendWebhooks(f db.File) {
+	options, err := fs.GetDomainOptions(f.Domain)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	slug := f.Slug
+	if slug == "" {
+		slug = f.ID
+	}
+	body, err := json.Marshal(WebhookEvent{
+		Event:    "save",
+		Domain:   f.Domain,
+		ID:       f.ID,
+		Slug:     f.Slug,
+		Name:     f.DisplayName(),
+		Path:     "/" + f.Domain + "/" + slug,
+		Modified: f.Modified,
+		Data:     f.Data,
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(options.WebhookSecret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	for _, webhook := range options.Webhooks {
+		if err := postWebhook(webhook, body, signature); err != nil {
+			log.Warnf("webhook of %s: %s", f.Domain, err)
+		}
+	}
+}
+
+func postWebhook(webhook string, body []byte, signature string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequest("POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rwtxt")
+	req.Header.Set("X-Rwtxt-Event", "save")
+	req.Header.Set("X-Rwtxt-Signature", signature)
+	// the webhooks may only be on public addresses, like fetched links
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		err = errors.Errorf("%s answered %s", webhook, resp.Status)
+	}
+	return
+}